@@ -0,0 +1,829 @@
+// apply is a package to apply patches to the working tree and the index like $ git apply
+//
+// A patch is a unified diff, and a patch generated by git has the extended header lines.
+//
+//  diff --git a/<old path> b/<new path>
+//  old mode <mode>                                  (mode change)
+//  new mode <mode>
+//  deleted file mode <mode>                         (deletion)
+//  new file mode <mode>                             (creation)
+//  similarity index <percent>                       (rename or copy)
+//  rename from <old path>                           (copy from <old path>)
+//  rename to <new path>                             (copy to <new path>)
+//  dissimilarity index <percent>                    (rewrite)
+//  index <old digest>..<new digest>[ <mode>]
+//  --- a/<old path>
+//  +++ b/<new path>
+//  @@ -<old start>,<old lines> +<new start>,<new lines> @@
+//   <context line>
+//  -<deleted line>
+//  +<added line>
+//
+// A binary patch has "GIT binary patch" line instead of hunks, and it's followed by the forward hunk
+// and the optional reverse hunk. each hunk begins with "literal <size>" or "delta <size>", and
+// the zlib compressed content or delta is encoded by base85.
+//
+// Patches are applied all or nothing. all patches are applied in memory first, and nothing is written
+// if any patch doesn't apply.
+//
+// If you want to know more about git apply, please refer to
+// https://git-scm.com/docs/git-apply
+package apply
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/shumon84/mogit/inner/attributes"
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/diff"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/merge"
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// Options is a type representing options of applying patches.
+type Options struct {
+	Index            bool             // apply to both the working tree and the index like --index
+	Cached           bool             // apply to the index only like --cached
+	ThreeWay         bool             // fall back to three-way merge like --3way, it implies Index unless Cached is set
+	Reverse          bool             // apply patches in reverse like -R
+	Strip            int              // number of leading components removed from names like -p<n>, 0 means 1 and negative means -p0
+	MinContext       int              // minimum number of context lines which must match like -C<n>, 0 means all and negative means -C0
+	UnidiffZero      bool             // don't trust lack of context lines like --unidiff-zero
+	IgnoreWhitespace bool             // ignore changes in whitespaces of context lines like --ignore-whitespace
+	Whitespace       WhitespaceAction // action for whitespace errors in added lines like --whitespace
+}
+
+// Apply parses patches in data and applies them like $ git apply
+// patches are applied to the working tree by default, and Index or Cached option changes the target.
+// if some patches are applied by three-way merge with conflicts, the results having conflict markers and
+// unmerged entries are written, and then ErrConflict is returned.
+// options may be nil, then apply.whitespace and apply.ignoreWhitespace config are used.
+// gitDir of parameters must be path to .git directory.
+func Apply(gitDir string, data []byte, options *Options) error {
+	patches, err := Parse(data, options)
+	if err != nil {
+		return err
+	}
+	return ApplyPatches(gitDir, patches, options)
+}
+
+// ApplyPatches applies parsed patches like Apply, Strip of options is not used.
+func ApplyPatches(gitDir string, patches []*Patch, options *Options) error {
+	a, err := newApplier(gitDir, options)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	files := make([]*file, len(patches))
+	for i, patch := range patches {
+		if a.options.Reverse {
+			patch = patch.reversed()
+		} else {
+			copied := *patch
+			patch = &copied
+		}
+		files[i] = &file{Patch: patch}
+	}
+	a.prepareTable(files)
+	for _, f := range files {
+		if err := a.checkPatch(f); err != nil {
+			return err
+		}
+	}
+	return a.writeResults(files)
+}
+
+// file is a type holding state of a patch while applying it.
+type file struct {
+	*Patch
+	oldEntry   *index.Entry // index entry of the old path
+	threeWay   bool         // the new path already exists, so the patch can be applied only by three-way merge
+	result     []byte       // content after the patch is applied
+	conflicted bool         // three-way merge conflicts
+	stages     [3][]byte    // contents of base, ours and theirs of the conflict, base is nil for a creation
+}
+
+// name returns the path for messages.
+func (f *file) name() string {
+	if f.OldName != "" {
+		return f.OldName
+	}
+	return f.NewName
+}
+
+// markers of paths which are deleted by patches
+var (
+	toBeDeleted = &file{} // the path will be deleted by a later patch
+	wasDeleted  = &file{} // the path was deleted by an earlier patch
+)
+
+// symlink changes of paths by patches
+const (
+	symlinkGoesAway = 1 << iota // a symbolic link is removed
+	symlinkInResult             // a symbolic link is created or remains
+)
+
+// applier is a type holding state while applying patches.
+type applier struct {
+	gitDir      string
+	workDir     string
+	options     Options
+	minContext  int
+	checkIndex  bool // preimages are read from the index, and files must match the index
+	updateIndex bool
+	config      *config.Config
+	converter   *attributes.Converter
+	merger      *merge.FileMerger       // merges results of three-way fallback
+	fileMode    bool                    // core.filemode, the executable bit of files is trusted
+	wsRule      uint                    // core.whitespace
+	entries     []*index.Entry          // all entries of the index
+	stage0      map[string]*index.Entry // entries at stage 0 by path
+	table       map[string]*file        // results of patches by path
+	symlinks    map[string]int          // symlink changes by path
+}
+
+func newApplier(gitDir string, options *Options) (*applier, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	a := &applier{
+		gitDir:   gitDir,
+		workDir:  filepath.Dir(gitDir),
+		config:   cfg,
+		entries:  []*index.Entry{},
+		stage0:   map[string]*index.Entry{},
+		table:    map[string]*file{},
+		symlinks: map[string]int{},
+	}
+	if options != nil {
+		a.options = *options
+	} else if err := a.loadOptions(); err != nil {
+		return nil, err
+	}
+	switch {
+	case a.options.MinContext == 0:
+		a.minContext = math.MaxInt32
+	case a.options.MinContext > 0:
+		a.minContext = a.options.MinContext
+	}
+	a.checkIndex = a.options.Index || a.options.Cached || a.options.ThreeWay
+	a.updateIndex = a.checkIndex
+	if a.fileMode, err = cfg.Bool("core.filemode", true); err != nil {
+		return nil, err
+	}
+	if a.wsRule, err = parseWhitespaceRule(cfg.GetString("core.whitespace", "")); err != nil {
+		return nil, err
+	}
+	if a.checkIndex {
+		if err := a.readIndex(); err != nil {
+			return nil, err
+		}
+	}
+	if a.converter, err = attributes.NewConverter(gitDir); err != nil {
+		return nil, err
+	}
+	if a.checkIndex {
+		// like git, the index is consulted for line endings only if it's read
+		a.converter.IndexBlob = index.BlobReader(gitDir, a.entries)
+	}
+	if a.options.ThreeWay {
+		if a.merger, err = merge.NewFileMerger(gitDir); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// loadOptions loads default options from config.
+func (a *applier) loadOptions() error {
+	var err error
+	if a.options.Whitespace, err = ParseWhitespaceAction(a.config.GetString("apply.whitespace", "warn")); err != nil {
+		return err
+	}
+	switch value := a.config.GetString("apply.ignorewhitespace", "no"); value {
+	case "change":
+		a.options.IgnoreWhitespace = true
+	case "no", "none", "false":
+	default:
+		return ErrInvalidWhitespace
+	}
+	return nil
+}
+
+func (a *applier) close() error {
+	return a.converter.Close()
+}
+
+// readIndex reads entries of the index, it is empty if there is no index file.
+func (a *applier) readIndex() error {
+	idx, err := index.ReadIndexFrom(a.gitDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < idx.Header().NumOfEntries; i++ {
+		entry, err := idx.Entries(i)
+		if err != nil {
+			return err
+		}
+		a.entries = append(a.entries, entry)
+		if entry.ConflictFlag == index.NoConflict {
+			a.stage0[entry.Name] = entry
+		}
+	}
+	return nil
+}
+
+func (a *applier) path(name string) string {
+	return filepath.Join(a.workDir, filepath.FromSlash(name))
+}
+
+// prepareTable records paths deleted by the patches, and changes of symbolic links.
+func (a *applier) prepareTable(files []*file) {
+	for _, f := range files {
+		if f.NewName == "" || f.IsRename {
+			a.table[f.OldName] = toBeDeleted
+		}
+		if f.OldName != "" && f.OldMode == object.ModeSymlink && (f.IsRename || f.IsDelete) {
+			a.symlinks[f.OldName] |= symlinkGoesAway
+		}
+		if f.NewName != "" && f.NewMode == object.ModeSymlink {
+			a.symlinks[f.NewName] |= symlinkInResult
+		}
+	}
+}
+
+// addToTable records the result of the patch, later patches to the path use it.
+func (a *applier) addToTable(f *file) {
+	if f.NewName != "" {
+		a.table[f.NewName] = f
+	}
+	if f.NewName == "" || f.IsRename {
+		a.table[f.OldName] = wasDeleted
+	}
+}
+
+// previous returns the file of an earlier patch whose result is the old path of the patch.
+func (a *applier) previous(f *file) (*file, error) {
+	// git patches don't depend on the order
+	if f.IsCopy || f.IsRename {
+		return nil, nil
+	}
+	previous, ok := a.table[f.OldName]
+	if !ok || previous == toBeDeleted {
+		return nil, nil
+	}
+	if previous == wasDeleted {
+		return nil, &PatchError{Path: f.OldName, Err: ErrRenamedOrDeleted}
+	}
+	return previous, nil
+}
+
+// checkPatch checks the patch can be applied, and applies it in memory.
+func (a *applier) checkPatch(f *file) error {
+	if err := a.checkPreimage(f); err != nil {
+		return err
+	}
+
+	// a file type change is a deletion followed by a creation, and paths may be swapped by renames,
+	// so the new path may exist if it's deleted by another patch.
+	okIfExists := false
+	if t, ok := a.table[f.NewName]; ok && (t == toBeDeleted || t == wasDeleted) {
+		okIfExists = true
+	}
+	if f.NewName != "" && (f.IsNew || f.IsRename || f.IsCopy) {
+		if err := a.checkToCreate(f.NewName, okIfExists); err != nil {
+			if _, ok := err.(*PatchError); !ok || !a.options.ThreeWay {
+				return err
+			}
+			f.threeWay = true
+		}
+		if f.NewMode == 0 {
+			if f.IsNew {
+				f.NewMode = object.ModeRegular
+			} else {
+				f.NewMode = f.OldMode
+			}
+		}
+	}
+	if f.NewName != "" && f.OldName != "" {
+		if f.NewMode == 0 {
+			f.NewMode = f.OldMode
+		}
+		if (f.OldMode^f.NewMode)&0170000 != 0 {
+			return &PatchError{Path: f.NewName, Err: ErrWrongType}
+		}
+	}
+	if err := checkUnsafePath(f); err != nil {
+		return err
+	}
+	if !f.IsDelete && a.isBeyondSymlink(f.NewName) {
+		return &PatchError{Path: f.NewName, Err: ErrBeyondSymlink}
+	}
+	return a.applyData(f)
+}
+
+// checkPreimage checks the old path exists and its mode matches the patch.
+func (a *applier) checkPreimage(f *file) error {
+	if f.OldName == "" {
+		return nil
+	}
+	previous, err := a.previous(f)
+	if err != nil {
+		return err
+	}
+	var info os.FileInfo
+	var mode object.FileMode
+	if previous != nil {
+		mode = previous.NewMode
+	} else if !a.options.Cached {
+		info, err = os.Lstat(a.path(f.OldName))
+		if err != nil && !os.IsNotExist(err) && !isNotDir(err) {
+			return err
+		}
+	}
+
+	if a.checkIndex && previous == nil {
+		entry, ok := a.stage0[f.OldName]
+		if !ok {
+			if f.maybeNew {
+				return a.makeNew(f)
+			}
+			return &PatchError{Path: f.OldName, Err: ErrNotExistInIndex}
+		}
+		f.oldEntry = entry
+		// a missing file is regarded as the same as the index
+		if info != nil {
+			match, err := a.matchIndex(entry, info)
+			if err != nil {
+				return err
+			}
+			if !match {
+				return &PatchError{Path: f.OldName, Err: ErrNotMatchIndex}
+			}
+		}
+		mode = diff.IndexMode(entry)
+	} else if previous == nil && info == nil {
+		if f.maybeNew {
+			return a.makeNew(f)
+		}
+		return &PatchError{Path: f.OldName, Err: ErrNotExistInWorktree}
+	}
+	if previous == nil && info != nil {
+		mode = a.worktreeMode(info, f.oldEntry, f.OldMode)
+	}
+
+	f.maybeNew = false
+	if f.OldMode == 0 {
+		f.OldMode = mode
+	}
+	if (mode^f.OldMode)&0170000 != 0 {
+		return &PatchError{Path: f.OldName, Err: ErrWrongType}
+	}
+	if f.NewMode == 0 && !f.IsDelete {
+		f.NewMode = mode
+	}
+	return nil
+}
+
+// makeNew makes a traditional patch a creation because the old path doesn't exist.
+func (a *applier) makeNew(f *file) error {
+	f.IsNew, f.IsDelete, f.maybeNew = true, false, false
+	f.OldName = ""
+	return nil
+}
+
+// worktreeMode returns mode of the file in the working tree.
+// if core.filemode is false, the executable bit is taken from the index entry or the patch.
+func (a *applier) worktreeMode(info os.FileInfo, entry *index.Entry, mode object.FileMode) object.FileMode {
+	if !a.fileMode {
+		if entry != nil {
+			return diff.IndexMode(entry)
+		}
+		return mode
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return object.ModeSymlink
+	case info.IsDir():
+		return object.ModeGitLink
+	case info.Mode()&0100 != 0:
+		return object.ModeExecutable
+	}
+	return object.ModeRegular
+}
+
+// matchIndex reports whether the file in the working tree matches the index entry.
+func (a *applier) matchIndex(entry *index.Entry, info os.FileInfo) (bool, error) {
+	entryMode := diff.IndexMode(entry)
+	mode := a.worktreeMode(info, entry, entryMode)
+	if mode != entryMode {
+		return false, nil
+	}
+	if mode == object.ModeGitLink {
+		return true, nil
+	}
+	data, err := a.readFile(entry.Name, info)
+	if err != nil {
+		return false, err
+	}
+	digest, err := object.NewBlobFromBytes(data).SHA1()
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(digest, entry.Digest), nil
+}
+
+func isNotDir(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	return ok && pathErr.Err == syscall.ENOTDIR
+}
+
+// checkToCreate checks the path can be created.
+func (a *applier) checkToCreate(name string, okIfExists bool) error {
+	if a.checkIndex && !okIfExists {
+		if _, ok := a.stage0[name]; ok {
+			return &PatchError{Path: name, Err: ErrExistsInIndex}
+		}
+	}
+	if a.options.Cached {
+		return nil
+	}
+	info, err := os.Lstat(a.path(name))
+	if err != nil {
+		if os.IsNotExist(err) || isNotDir(err) {
+			return nil
+		}
+		return err
+	}
+	// a leading directory may be a symbolic link which is going to be removed by a patch
+	if info.IsDir() || okIfExists || a.hasSymlinkLeadingPath(name) {
+		return nil
+	}
+	return &PatchError{Path: name, Err: ErrExistsInWorktree}
+}
+
+// checkUnsafePath checks paths of the patch don't escape from the working tree, nor point into .git directory.
+func checkUnsafePath(f *file) error {
+	oldName, newName := "", ""
+	if f.IsDelete || (!f.IsNew && !f.IsCopy) {
+		oldName = f.OldName
+	}
+	if !f.IsDelete {
+		newName = f.NewName
+	}
+	for _, name := range []string{oldName, newName} {
+		if name != "" && !verifyPath(name) {
+			return &PatchError{Path: name, Err: ErrUnsafePath}
+		}
+	}
+	return nil
+}
+
+// verifyPath reports whether the path is a valid path in the working tree.
+func verifyPath(name string) bool {
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || component == "." || component == ".." || strings.EqualFold(component, ".git") {
+			return false
+		}
+	}
+	return true
+}
+
+// hasSymlinkLeadingPath reports whether a leading directory of the path is a symbolic link in the working tree.
+func (a *applier) hasSymlinkLeadingPath(name string) bool {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if info, err := os.Lstat(a.path(dir)); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isBeyondSymlink reports whether a leading directory of the path is a symbolic link after the patches.
+func (a *applier) isBeyondSymlink(name string) bool {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		change := a.symlinks[dir]
+		if change&symlinkInResult != 0 {
+			return true
+		}
+		// a new one may be created at a higher level
+		if change&symlinkGoesAway != 0 {
+			continue
+		}
+		if a.checkIndex {
+			if entry, ok := a.stage0[dir]; ok && entry.ObjectType == index.SymbolicLink {
+				return true
+			}
+		} else if info, err := os.Lstat(a.path(dir)); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// applyData applies the patch to the preimage in memory.
+func (a *applier) applyData(f *file) error {
+	img, err := a.loadPreimage(f)
+	if err != nil {
+		return err
+	}
+	applied := false
+	if a.options.ThreeWay {
+		if applied, err = a.tryThreeWay(f, img); err != nil {
+			return err
+		}
+	}
+	if !applied {
+		if f.threeWay {
+			return &PatchError{Path: f.name(), Err: ErrNotApply}
+		}
+		if f.result, err = a.applyFragments(f, img); err != nil {
+			return err
+		}
+	}
+	a.addToTable(f)
+	if f.IsDelete && len(f.result) > 0 {
+		return &PatchError{Path: f.name(), Err: ErrRemovalLeavesContents}
+	}
+	return nil
+}
+
+// loadPreimage returns content of the old path.
+// it's the result of an earlier patch, the content in the index or the file in the working tree.
+func (a *applier) loadPreimage(f *file) ([]byte, error) {
+	if f.OldName == "" {
+		return []byte{}, nil
+	}
+	previous, err := a.previous(f)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		return previous.result, nil
+	}
+	if a.checkIndex {
+		return a.readEntry(f.oldEntry)
+	}
+	if f.OldMode == object.ModeGitLink {
+		return nil, &PatchError{Path: f.OldName, Err: ErrNotExistInIndex}
+	}
+	if a.hasSymlinkLeadingPath(f.OldName) {
+		return nil, &PatchError{Path: f.OldName, Err: ErrBeyondSymlink}
+	}
+	info, err := os.Lstat(a.path(f.OldName))
+	if err != nil {
+		return nil, err
+	}
+	return a.readFile(f.OldName, info)
+}
+
+// readEntry returns content of the index entry, it's empty if the entry is nil.
+func (a *applier) readEntry(entry *index.Entry) ([]byte, error) {
+	if entry == nil {
+		return []byte{}, nil
+	}
+	if entry.ObjectType == index.GitLink {
+		return []byte(fmt.Sprintf("Subproject commit %x\n", entry.Digest)), nil
+	}
+	return readBlob(a.gitDir, entry.Digest)
+}
+
+func readBlob(gitDir string, digest []byte) ([]byte, error) {
+	obj, err := object.ReadObjectFrom(gitDir, digest)
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := obj.(*object.Blob)
+	if !ok {
+		return nil, object.ErrUnexpectedType
+	}
+	return blob.Content()
+}
+
+// readFile returns content of the file in the working tree as a blob.
+// content of symbolic link is the target path.
+func (a *applier) readFile(name string, info os.FileInfo) ([]byte, error) {
+	filePath := a.path(name)
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(filepath.ToSlash(target)), nil
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return a.converter.ToGit(name, data)
+}
+
+// applyFragments applies fragments or the binary hunk of the patch to the content.
+func (a *applier) applyFragments(f *file, data []byte) ([]byte, error) {
+	if f.IsBinary {
+		return a.applyBinary(f, data)
+	}
+	name := f.NewName
+	if name == "" {
+		name = f.OldName
+	}
+	rule, err := a.whitespaceRule(name)
+	if err != nil {
+		return nil, err
+	}
+	if a.options.Whitespace == WhitespaceError {
+		for _, frag := range f.Fragments {
+			for _, line := range frag.Lines {
+				if line.Op == '+' && wsCheck(line.Text, rule) != 0 {
+					return nil, &PatchError{Path: name, Err: ErrWhitespace}
+				}
+			}
+		}
+	}
+	fa := &fragmentApplier{
+		minContext:       a.minContext,
+		unidiffZero:      a.options.UnidiffZero,
+		ignoreWhitespace: a.options.IgnoreWhitespace,
+		fixWhitespace:    a.options.Whitespace == WhitespaceFix,
+		wsRule:           rule,
+	}
+	img, ok := fa.apply(newImage(data), f.Fragments)
+	if !ok {
+		return nil, &PatchError{Path: f.name(), Err: ErrNotApply}
+	}
+	if fa.wsBlankAtEOF && a.options.Whitespace == WhitespaceError {
+		return nil, &PatchError{Path: name, Err: ErrWhitespace}
+	}
+	return img.bytes(), nil
+}
+
+// whitespaceRule returns whitespace rules of the path.
+// whitespace attribute enables all rules except tab-in-indent, and the value of the attribute is rules.
+// otherwise rules of core.whitespace config are used.
+func (a *applier) whitespaceRule(name string) (uint, error) {
+	values, err := a.converter.Attributes().Check(name, "whitespace")
+	if err != nil {
+		return 0, err
+	}
+	switch value := values[0]; value.State {
+	case attributes.Set:
+		rule := a.wsRule & wsTabWidthMask
+		for _, r := range wsRuleNames {
+			if !r.loosensError && !r.excludeDefault {
+				rule |= r.rule
+			}
+		}
+		return rule, nil
+	case attributes.Unset:
+		return a.wsRule & wsTabWidthMask, nil
+	case attributes.Valued:
+		return parseWhitespaceRule(value.Text)
+	}
+	return a.wsRule, nil
+}
+
+// applyBinary applies the binary hunk of the patch to the content.
+// the patch must have full digests in index line, and the content must match the old digest.
+// if the new blob exists in the repository, it's used without the hunk.
+func (a *applier) applyBinary(f *file, data []byte) ([]byte, error) {
+	oldDigest, err1 := hex.DecodeString(f.OldDigest)
+	newDigest, err2 := hex.DecodeString(f.NewDigest)
+	if err1 != nil || err2 != nil || len(oldDigest) != object.DigestSize || len(newDigest) != object.DigestSize {
+		return nil, &PatchError{Path: f.name(), Err: ErrNoFullIndex}
+	}
+	if f.OldName != "" {
+		digest, err := object.NewBlobFromBytes(data).SHA1()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(digest, oldDigest) {
+			return nil, &PatchError{Path: f.name(), Err: ErrNotApply}
+		}
+	} else if len(data) > 0 {
+		return nil, &PatchError{Path: f.name(), Err: ErrNotApply}
+	}
+	if bytes.Equal(newDigest, make([]byte, object.DigestSize)) {
+		return []byte{}, nil
+	}
+	if object.HasObject(a.gitDir, newDigest) {
+		return readBlob(a.gitDir, newDigest)
+	}
+
+	if f.Binary == nil {
+		return nil, &PatchError{Path: f.name(), Err: ErrNoBinaryData}
+	}
+	result := f.Binary.Data
+	if f.Binary.Method == BinaryDelta {
+		var err error
+		if result, err = applyDelta(data, f.Binary.Data); err != nil {
+			return nil, &PatchError{Path: f.name(), Err: ErrNotApply}
+		}
+	}
+	digest, err := object.NewBlobFromBytes(result).SHA1()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(digest, newDigest) {
+		return nil, &PatchError{Path: f.name(), Err: ErrBinaryMismatch}
+	}
+	return result, nil
+}
+
+// tryThreeWay applies the patch by three-way merge, it returns false if three-way merge can't be used.
+// the patch is applied to the blob in index line, and the result is merged with the current content.
+func (a *applier) tryThreeWay(f *file, current []byte) (bool, error) {
+	if f.IsDelete || f.OldMode == object.ModeGitLink || f.NewMode == object.ModeGitLink ||
+		(f.IsNew && !f.threeWay) || (f.IsRename && f.linesAdded == 0 && f.linesDeleted == 0) {
+		return false, nil
+	}
+	// preimage the patch was prepared for
+	var base []byte
+	if f.IsNew {
+		base = []byte{}
+	} else {
+		digest, ok := a.resolveDigest(f.OldDigest)
+		if !ok {
+			return false, nil
+		}
+		var err error
+		if base, err = readBlob(a.gitDir, digest); err != nil {
+			return false, nil
+		}
+	}
+	theirs, err := a.applyFragments(f, base)
+	if err != nil {
+		return false, nil
+	}
+	ours := current
+	if f.IsNew {
+		if ours, err = a.loadCurrent(f); err != nil {
+			return false, nil
+		}
+	}
+	options := a.merger.Options()
+	options.AncestorLabel, options.OurLabel, options.TheirLabel = "base", "ours", "theirs"
+	result, conflicts, err := a.merger.Merge(f.NewName, base, ours, theirs, options)
+	if err != nil {
+		return false, err
+	}
+	f.result = result
+	if conflicts > 0 {
+		f.conflicted = true
+		f.stages = [3][]byte{base, ours, theirs}
+		if f.IsNew {
+			f.stages[0] = nil
+		}
+	}
+	return true, nil
+}
+
+// resolveDigest resolves hex digits in index line to the digest of the blob.
+func (a *applier) resolveDigest(prefix string) ([]byte, bool) {
+	if len(prefix) == object.DigestSize*2 {
+		digest, err := hex.DecodeString(prefix)
+		return digest, err == nil && object.HasObject(a.gitDir, digest)
+	}
+	digests, err := object.FindObjects(a.gitDir, prefix)
+	if err != nil || len(digests) != 1 {
+		return nil, false
+	}
+	return digests[0], true
+}
+
+// loadCurrent returns the current content of the new path of a creation, which already exists.
+func (a *applier) loadCurrent(f *file) ([]byte, error) {
+	entry, ok := a.stage0[f.NewName]
+	if !ok {
+		return nil, &PatchError{Path: f.NewName, Err: ErrNotExistInIndex}
+	}
+	if !a.options.Cached {
+		info, err := os.Lstat(a.path(f.NewName))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if info != nil {
+			match, err := a.matchIndex(entry, info)
+			if err != nil {
+				return nil, err
+			}
+			if !match {
+				return nil, &PatchError{Path: f.NewName, Err: ErrNotMatchIndex}
+			}
+		}
+	}
+	return a.readEntry(entry)
+}
@@ -0,0 +1,208 @@
+package apply
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// base85Alphabet is the alphabet of base85 encoding used by git
+const base85Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+var base85Values = func() [256]int {
+	values := [256]int{}
+	for i := range values {
+		values[i] = -1
+	}
+	for i := 0; i < len(base85Alphabet); i++ {
+		values[base85Alphabet[i]] = i
+	}
+	return values
+}()
+
+// decodeBase85 decodes groups of 5 characters into size bytes, each group has 4 bytes in big endian.
+func decodeBase85(s string, size int) ([]byte, bool) {
+	data := make([]byte, 0, size)
+	for len(data) < size {
+		if len(s) < 5 {
+			return nil, false
+		}
+		var acc uint64
+		for i := 0; i < 5; i++ {
+			v := base85Values[s[i]]
+			if v < 0 {
+				return nil, false
+			}
+			acc = acc*85 + uint64(v)
+		}
+		if acc > 0xFFFFFFFF {
+			return nil, false
+		}
+		s = s[5:]
+		for shift := 24; shift >= 0 && len(data) < size; shift -= 8 {
+			data = append(data, byte(acc>>uint(shift)))
+		}
+	}
+	return data, true
+}
+
+// parseBinary parses hunks following "GIT binary patch" line.
+// the forward hunk is required, and the reverse hunk may follow it.
+func (p *parser) parseBinary(patch *Patch) error {
+	forward, err := p.parseBinaryHunk()
+	if err != nil {
+		return err
+	}
+	if forward == nil {
+		return p.errorf(ErrCorruptBinaryPatch)
+	}
+	reverse, err := p.parseBinaryHunk()
+	if err != nil {
+		return err
+	}
+	patch.IsBinary = true
+	patch.Binary, patch.Reverse = forward, reverse
+	return nil
+}
+
+// parseBinaryHunk parses a binary hunk, which begins with "literal <size>" or "delta <size>".
+// each following line has a length character and base85 encoded data, and an empty line ends the hunk.
+// the length character 'A'-'Z' means 1-26 bytes and 'a'-'z' means 27-52 bytes.
+func (p *parser) parseBinaryHunk() (*BinaryHunk, error) {
+	hunk := &BinaryHunk{}
+	line := p.line(p.pos)
+	var size string
+	switch {
+	case strings.HasPrefix(line, "delta "):
+		hunk.Method, size = BinaryDelta, line[6:]
+	case strings.HasPrefix(line, "literal "):
+		hunk.Method, size = BinaryLiteral, line[8:]
+	default:
+		return nil, nil
+	}
+	origLen, err := strconv.Atoi(strings.TrimSpace(size))
+	if err != nil {
+		return nil, p.errorf(ErrCorruptBinaryPatch)
+	}
+	p.pos++
+
+	compressed := []byte{}
+	for ; ; p.pos++ {
+		line := p.line(p.pos)
+		if line == "\n" {
+			p.pos++
+			break
+		}
+		// the shortest line is "A00000\n", and the length of the line must be multiple of 5 plus 2
+		if len(line) < 7 || (len(line)-2)%5 != 0 || !strings.HasSuffix(line, "\n") {
+			return nil, p.errorf(ErrCorruptBinaryPatch)
+		}
+		maxLength := (len(line) - 2) / 5 * 4
+		length := 0
+		switch c := line[0]; {
+		case 'A' <= c && c <= 'Z':
+			length = int(c-'A') + 1
+		case 'a' <= c && c <= 'z':
+			length = int(c-'a') + 27
+		default:
+			return nil, p.errorf(ErrCorruptBinaryPatch)
+		}
+		// the filler of the last group never exceeds 3 bytes
+		if maxLength < length || length <= maxLength-4 {
+			return nil, p.errorf(ErrCorruptBinaryPatch)
+		}
+		data, ok := decodeBase85(line[1:len(line)-1], length)
+		if !ok {
+			return nil, p.errorf(ErrCorruptBinaryPatch)
+		}
+		compressed = append(compressed, data...)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, p.errorf(ErrCorruptBinaryPatch)
+	}
+	defer zr.Close()
+	if hunk.Data, err = ioutil.ReadAll(zr); err != nil || len(hunk.Data) != origLen {
+		return nil, p.errorf(ErrCorruptBinaryPatch)
+	}
+	return hunk, nil
+}
+
+// applyDelta applies the delta in git's format to base.
+// the delta has sizes of base and the result, and then instructions to copy from base or insert data.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	baseSize, delta, ok := deltaSize(delta)
+	if !ok || baseSize != len(base) {
+		return nil, ErrInvalidDelta
+	}
+	resultSize, delta, ok := deltaSize(delta)
+	if !ok {
+		return nil, ErrInvalidDelta
+	}
+	result := make([]byte, 0, resultSize)
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+		switch {
+		case cmd&0x80 != 0:
+			// copy from base, bits tell which bytes of offset and size follow
+			offset, size := 0, 0
+			for i := uint(0); i < 4; i++ {
+				if cmd&(1<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, ErrInvalidDelta
+					}
+					offset |= int(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			for i := uint(0); i < 3; i++ {
+				if cmd&(0x10<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, ErrInvalidDelta
+					}
+					size |= int(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > len(base) || len(result)+size > resultSize {
+				return nil, ErrInvalidDelta
+			}
+			result = append(result, base[offset:offset+size]...)
+		case cmd != 0:
+			// insert cmd bytes following it
+			size := int(cmd)
+			if size > len(delta) || len(result)+size > resultSize {
+				return nil, ErrInvalidDelta
+			}
+			result = append(result, delta[:size]...)
+			delta = delta[size:]
+		default:
+			return nil, ErrInvalidDelta
+		}
+	}
+	if len(result) != resultSize {
+		return nil, ErrInvalidDelta
+	}
+	return result, nil
+}
+
+// deltaSize reads a size in the delta header, it's little endian base 128.
+func deltaSize(delta []byte) (int, []byte, bool) {
+	size := 0
+	for shift := uint(0); len(delta) > 0; shift += 7 {
+		c := delta[0]
+		delta = delta[1:]
+		size |= int(c&0x7F) << shift
+		if c&0x80 == 0 {
+			return size, delta, true
+		}
+	}
+	return 0, nil, false
+}
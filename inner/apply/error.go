@@ -0,0 +1,53 @@
+package apply
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNoPatch                = errors.New("no valid patches in input")
+	ErrCorruptPatch           = errors.New("corrupt patch")
+	ErrCorruptBinaryPatch     = errors.New("corrupt binary patch")
+	ErrFragmentWithoutHeader  = errors.New("patch fragment without header")
+	ErrNoFileName             = errors.New("git diff header lacks filename information")
+	ErrInconsistentHeader     = errors.New("git diff header has inconsistent lines")
+	ErrOnlyGarbage            = errors.New("patch with only garbage")
+	ErrNewFileDependsOnOld    = errors.New("new file depends on old contents")
+	ErrDeletedFileHasContents = errors.New("deleted file still has contents")
+	ErrInvalidDelta           = errors.New("invalid binary delta")
+	ErrInvalidWhitespace      = errors.New("invalid whitespace action")
+	ErrInvalidWhitespaceRule  = errors.New("cannot enforce both tab-in-indent and indent-with-non-tab")
+	ErrUnsafePath             = errors.New("invalid path")
+	ErrBeyondSymlink          = errors.New("affected file is beyond a symbolic link")
+	ErrNotExistInIndex        = errors.New("path does not exist in index")
+	ErrNotExistInWorktree     = errors.New("path does not exist in working directory")
+	ErrExistsInIndex          = errors.New("path already exists in index")
+	ErrExistsInWorktree       = errors.New("path already exists in working directory")
+	ErrNotMatchIndex          = errors.New("path does not match index")
+	ErrWrongType              = errors.New("file type does not match the patch")
+	ErrRenamedOrDeleted       = errors.New("path has been renamed or deleted by another patch")
+	ErrNotApply               = errors.New("patch does not apply")
+	ErrRemovalLeavesContents  = errors.New("removal patch leaves file contents")
+	ErrNoFullIndex            = errors.New("cannot apply binary patch without full index line")
+	ErrNoBinaryData           = errors.New("binary patch has no data to apply")
+	ErrBinaryMismatch         = errors.New("binary patch creates incorrect result")
+	ErrCorruptSubmodule       = errors.New("corrupt patch for submodule")
+	ErrWhitespace             = errors.New("patch adds whitespace errors")
+	ErrConflict               = errors.New("patch is applied with conflicts")
+)
+
+// PatchError is an error of a patch, it tells which path or which line of the patch causes the error.
+type PatchError struct {
+	Path string // path of the file, it's empty for errors while parsing
+	Line int    // line number in the patch, it's 0 for errors while applying
+	Err  error  // one of the errors above
+}
+
+// Error is implementation of error interface
+func (e *PatchError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+	}
+	return e.Path + ": " + e.Err.Error()
+}
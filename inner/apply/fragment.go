@@ -0,0 +1,373 @@
+package apply
+
+import (
+	"bytes"
+)
+
+// imageLine is a type representing a line of a content while applying fragments.
+type imageLine struct {
+	text    []byte
+	hash    uint32 // hash of the line ignoring whitespaces
+	common  bool   // a context line of a fragment
+	patched bool   // a line written by a fragment, it never matches later fragments
+}
+
+func newImageLine(text []byte, common bool) *imageLine {
+	return &imageLine{text: text, hash: hashLine(text), common: common}
+}
+
+// hashLine returns hash of the line ignoring whitespaces, lines differing only in whitespaces have the same hash.
+func hashLine(text []byte) uint32 {
+	h := uint32(0)
+	for _, c := range text {
+		if !isSpace(c) {
+			h = h*3 + uint32(c)
+		}
+	}
+	return h
+}
+
+// image is a type representing a content as lines.
+type image []*imageLine
+
+func newImage(data []byte) image {
+	img := image{}
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) > 0 {
+			img = append(img, newImageLine(line, false))
+		}
+	}
+	return img
+}
+
+// bytes returns the content of the image.
+func (img image) bytes() []byte {
+	data := []byte{}
+	for _, line := range img {
+		data = append(data, line.text...)
+	}
+	return data
+}
+
+// fragmentApplier is a type holding options to apply fragments.
+type fragmentApplier struct {
+	minContext       int  // minimum number of context lines, context lines are reduced to it while searching
+	unidiffZero      bool // don't trust lack of context lines
+	ignoreWhitespace bool // context lines match lines differing only in whitespaces
+	fixWhitespace    bool // whitespace errors in added lines are fixed
+	wsRule           uint // whitespace rules of the file
+	wsBlankAtEOF     bool // blank lines are added at end of the file, it's an error of blank-at-eof rule
+}
+
+// apply applies fragments to the image.
+func (a *fragmentApplier) apply(img image, fragments []*Fragment) (image, bool) {
+	for _, frag := range fragments {
+		var ok bool
+		if img, ok = a.applyFragment(img, frag); !ok {
+			return nil, false
+		}
+	}
+	return img, true
+}
+
+// applyFragment applies a fragment to the image.
+// the position of the fragment is searched around the line number in the hunk header,
+// and context lines are reduced until the fragment matches if minContext allows it.
+func (a *fragmentApplier) applyFragment(img image, frag *Fragment) (image, bool) {
+	preimage, postimage := image{}, image{}
+	newBlankLinesAtEnd := 0
+	for _, line := range frag.Lines {
+		isBlankContext, addedBlankLine := false, false
+		switch line.Op {
+		case ' ':
+			preimage = append(preimage, newImageLine(line.Text, true))
+			postimage = append(postimage, newImageLine(line.Text, true))
+			isBlankContext = a.wsRule&wsBlankAtEOF != 0 && wsBlankLine(line.Text)
+		case '-':
+			preimage = append(preimage, newImageLine(line.Text, false))
+		case '+':
+			text := line.Text
+			if a.fixWhitespace {
+				text = wsFix(text, a.wsRule)
+			}
+			postimage = append(postimage, newImageLine(text, false))
+			addedBlankLine = a.wsRule&wsBlankAtEOF != 0 && wsBlankLine(line.Text)
+		}
+		switch {
+		case addedBlankLine:
+			newBlankLinesAtEnd++
+		case isBlankContext:
+		default:
+			newBlankLinesAtEnd = 0
+		}
+	}
+
+	// a fragment changing lines at the beginning has "@@ -1,<n> ..." or "@@ -0,0 ..." header,
+	// but a fragment without context lines may insert lines after the first line.
+	matchBeginning := frag.OldPos == 0 || (frag.OldPos == 1 && !a.unidiffZero)
+	// a fragment without trailing context lines must match at the end
+	matchEnd := !a.unidiffZero && frag.Trailing == 0
+
+	pos := 0
+	if frag.NewPos > 0 {
+		pos = frag.NewPos - 1
+	}
+	leading, trailing := frag.Leading, frag.Trailing
+	applied := -1
+	for {
+		if applied = a.findPos(img, &preimage, &postimage, pos, matchBeginning, matchEnd); applied >= 0 {
+			break
+		}
+		// am I at my context limits?
+		if leading <= a.minContext && trailing <= a.minContext {
+			break
+		}
+		if matchBeginning || matchEnd {
+			matchBeginning, matchEnd = false, false
+			continue
+		}
+		// reduce both leading and trailing context lines if they are equal, otherwise reduce the larger one
+		if leading >= trailing {
+			preimage, postimage = preimage[1:], postimage[1:]
+			pos--
+			leading--
+		}
+		if trailing > leading {
+			preimage, postimage = preimage[:len(preimage)-1], postimage[:len(postimage)-1]
+			trailing--
+		}
+	}
+	if applied < 0 {
+		return nil, false
+	}
+
+	if newBlankLinesAtEnd > 0 && len(preimage)+applied >= len(img) && a.wsRule&wsBlankAtEOF != 0 {
+		a.wsBlankAtEOF = true
+		if a.fixWhitespace {
+			postimage = postimage[:len(postimage)-newBlankLinesAtEnd]
+		}
+	}
+	return updateImage(img, applied, preimage, postimage), true
+}
+
+// updateImage replaces lines of preimage at the position with postimage.
+func updateImage(img image, pos int, preimage, postimage image) image {
+	// the preimage may extend beyond the end when blank lines at the end are removed
+	limit := len(preimage)
+	if limit > len(img)-pos {
+		limit = len(img) - pos
+	}
+	result := make(image, 0, len(img)-limit+len(postimage))
+	result = append(result, img[:pos]...)
+	for _, line := range postimage {
+		patched := *line
+		patched.patched = true
+		result = append(result, &patched)
+	}
+	return append(result, img[pos+limit:]...)
+}
+
+// findPos finds the position where the preimage matches, it returns -1 if it's not found.
+// the position is searched from line alternately forward and backward.
+func (a *fragmentApplier) findPos(img image, preimage, postimage *image, line int, matchBeginning, matchEnd bool) int {
+	// there is no point starting from a wrong line that will never match
+	if matchBeginning {
+		line = 0
+	} else if matchEnd {
+		line = len(img) - len(*preimage)
+	}
+	if line > len(img) || line < 0 {
+		line = len(img)
+	}
+
+	backwards, forwards, current := line, line, line
+	for i := 0; ; i++ {
+		if a.matchFragment(img, preimage, postimage, current, matchBeginning, matchEnd) {
+			return current
+		}
+		for {
+			if backwards == 0 && forwards == len(img) {
+				return -1
+			}
+			if i&1 != 0 {
+				if backwards == 0 {
+					i++
+					continue
+				}
+				backwards--
+				current = backwards
+			} else {
+				if forwards == len(img) {
+					i++
+					continue
+				}
+				forwards++
+				current = forwards
+			}
+			break
+		}
+	}
+}
+
+// matchFragment reports whether the preimage matches lines of the image at the position.
+// if whitespaces are ignored or fixed, the preimage and the context lines of the postimage are updated
+// to have the same whitespaces as the image.
+func (a *fragmentApplier) matchFragment(img image, preimage, postimage *image, pos int, matchBeginning, matchEnd bool) bool {
+	pre := *preimage
+	limit := len(pre)
+	switch {
+	case len(pre)+pos <= len(img):
+		// the fragment falls within the image
+		if matchEnd && len(pre)+pos != len(img) {
+			return false
+		}
+	case a.fixWhitespace && a.wsRule&wsBlankAtEOF != 0:
+		// the fragment extends beyond the end of the image while blank lines at the end are removed,
+		// lines beyond the end must be blank
+		limit = len(img) - pos
+	default:
+		return false
+	}
+	if matchBeginning && pos != 0 {
+		return false
+	}
+
+	// quick hash check
+	for i := 0; i < limit; i++ {
+		if img[pos+i].patched || pre[i].hash != img[pos+i].hash {
+			return false
+		}
+	}
+
+	if limit == len(pre) {
+		exact := true
+		for i := 0; i < limit; i++ {
+			if !bytes.Equal(pre[i].text, img[pos+i].text) {
+				exact = false
+				break
+			}
+		}
+		if exact {
+			return true
+		}
+	} else {
+		// the preimage extends beyond the end, so there can't be an exact match.
+		// there must be one non-blank context line that matches a line before the end.
+		blank := true
+		for _, line := range pre[:limit] {
+			if !wsBlankLine(line.text) {
+				blank = false
+				break
+			}
+		}
+		if blank {
+			return false
+		}
+	}
+
+	if a.ignoreWhitespace {
+		return a.fuzzyMatch(img, preimage, postimage, pos, limit)
+	}
+	if !a.fixWhitespace {
+		return false
+	}
+
+	// the preimage may be based on a version before whitespace errors are fixed, or the image may have them.
+	// they match if both are the same after fixing whitespace errors.
+	fixed := make([][]byte, len(pre))
+	for i, line := range pre {
+		fixed[i] = wsFix(line.text, a.wsRule)
+		if i < limit {
+			if !bytes.Equal(fixed[i], wsFix(img[pos+i].text, a.wsRule)) {
+				return false
+			}
+		} else if !wsBlankLine(fixed[i]) {
+			// lines beyond the end of the image must be blank
+			return false
+		}
+	}
+	updatePrePostImages(preimage, postimage, fixed)
+	return true
+}
+
+// fuzzyMatch reports whether the preimage matches the image ignoring differences of whitespaces.
+func (a *fragmentApplier) fuzzyMatch(img image, preimage, postimage *image, pos, limit int) bool {
+	pre := *preimage
+	for i := 0; i < limit; i++ {
+		if !fuzzyMatchLines(img[pos+i].text, pre[i].text) {
+			return false
+		}
+	}
+	// lines beyond the end of the image must be blank
+	for _, line := range pre[limit:] {
+		if !wsBlankLine(line.text) {
+			return false
+		}
+	}
+	// the preimage and the context lines use the same whitespaces as the image
+	fixed := make([][]byte, len(pre))
+	for i, line := range pre {
+		if i < limit {
+			fixed[i] = img[pos+i].text
+		} else {
+			fixed[i] = line.text
+		}
+	}
+	updatePrePostImages(preimage, postimage, fixed)
+	return true
+}
+
+// fuzzyMatchLines reports whether lines are the same ignoring amount of whitespaces and line endings.
+func fuzzyMatchLines(s1, s2 []byte) bool {
+	s1 = bytes.TrimRight(s1, "\r\n")
+	s2 = bytes.TrimRight(s2, "\r\n")
+	for len(s1) > 0 && len(s2) > 0 {
+		if isSpace(s1[0]) {
+			// "a b" doesn't match "ab"
+			if !isSpace(s2[0]) {
+				return false
+			}
+			for len(s1) > 0 && isSpace(s1[0]) {
+				s1 = s1[1:]
+			}
+			for len(s2) > 0 && isSpace(s2[0]) {
+				s2 = s2[1:]
+			}
+			continue
+		}
+		if s1[0] != s2[0] {
+			return false
+		}
+		s1, s2 = s1[1:], s2[1:]
+	}
+	return len(s1) == 0 && len(s2) == 0
+}
+
+// updatePrePostImages replaces lines of the preimage with fixed lines,
+// and context lines of the postimage are also replaced with the corresponding lines.
+func updatePrePostImages(preimage, postimage *image, fixed [][]byte) {
+	pre := make(image, len(fixed))
+	for i, text := range fixed {
+		pre[i] = newImageLine(text, (*preimage)[i].common)
+	}
+	*preimage = pre
+
+	post := image{}
+	ctx := 0
+	for _, line := range *postimage {
+		if !line.common {
+			post = append(post, line)
+			continue
+		}
+		for ctx < len(pre) && !pre[ctx].common {
+			ctx++
+		}
+		// the preimage is expected to run out if blank lines at the end are removed
+		if ctx >= len(pre) {
+			continue
+		}
+		post = append(post, newImageLine(pre[ctx].text, true))
+		ctx++
+	}
+	*postimage = post
+}
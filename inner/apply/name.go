@@ -0,0 +1,209 @@
+package apply
+
+import (
+	"strings"
+)
+
+// isSpace reports whether c is a whitespace like isspace() of git, it doesn't include '\v' and '\f'.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isDevNull reports whether the name in ---/+++ line is /dev/null.
+func isDevNull(line string) bool {
+	return strings.HasPrefix(line, "/dev/null") && len(line) > 9 && isSpace(line[9])
+}
+
+// unquote unquotes the C-style quoted name at the beginning of s, it returns the rest after the closing quote.
+func unquote(s string) (string, string, bool) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", false
+	}
+	name := &strings.Builder{}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			return name.String(), s[i+1:], true
+		case '\n':
+			return "", "", false
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", "", false
+			}
+			switch c = s[i]; c {
+			case 'a':
+				name.WriteByte('\a')
+			case 'b':
+				name.WriteByte('\b')
+			case 'f':
+				name.WriteByte('\f')
+			case 'n':
+				name.WriteByte('\n')
+			case 'r':
+				name.WriteByte('\r')
+			case 't':
+				name.WriteByte('\t')
+			case 'v':
+				name.WriteByte('\v')
+			case '\\', '"':
+				name.WriteByte(c)
+			case '0', '1', '2', '3':
+				if i+2 >= len(s) || !isOctal(s[i+1]) || !isOctal(s[i+2]) {
+					return "", "", false
+				}
+				name.WriteByte((c-'0')<<6 | (s[i+1]-'0')<<3 | (s[i+2] - '0'))
+				i += 2
+			default:
+				return "", "", false
+			}
+		default:
+			name.WriteByte(c)
+		}
+	}
+	return "", "", false
+}
+
+func isOctal(c byte) bool {
+	return '0' <= c && c <= '7'
+}
+
+// skipTreePrefix removes strip leading components like "a/" from the name.
+// it returns false if the name doesn't have enough components.
+func skipTreePrefix(strip int, name string) (string, bool) {
+	if strip == 0 {
+		return name, !strings.HasPrefix(name, "/")
+	}
+	n := strip
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' {
+			if n--; n <= 0 {
+				return name[i+1:], i != 0
+			}
+		}
+	}
+	return "", false
+}
+
+// gitHeaderName returns the name in "diff --git a/<name> b/<name>" line.
+// the name is found only if both sides are the same, because renames tell names in the other lines.
+func gitHeaderName(strip int, line string) (string, bool) {
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "diff --git "), "\n")
+	if strings.HasPrefix(line, `"`) {
+		first, second, ok := unquote(line)
+		if !ok {
+			return "", false
+		}
+		if first, ok = skipTreePrefix(strip, first); !ok {
+			return "", false
+		}
+		second = strings.TrimLeft(second, " \t\r")
+		if second == "" {
+			return "", false
+		}
+		if strings.HasPrefix(second, `"`) {
+			if second, _, ok = unquote(second); !ok {
+				return "", false
+			}
+		}
+		if second, ok = skipTreePrefix(strip, second); !ok || second != first {
+			return "", false
+		}
+		return first, true
+	}
+
+	name, ok := skipTreePrefix(strip, line)
+	if !ok {
+		return "", false
+	}
+	// since the first name is unquoted, a quote must be the beginning of the second name
+	if i := strings.IndexByte(name, '"'); i >= 0 {
+		second, _, ok := unquote(name[i:])
+		if !ok {
+			return "", false
+		}
+		if second, ok = skipTreePrefix(strip, second); !ok {
+			return "", false
+		}
+		if len(second) < i && strings.HasPrefix(name, second) && isSpace(name[len(second)]) {
+			return second, true
+		}
+		return "", false
+	}
+
+	// accept a name only if it shows up twice in exactly the same form
+	for i := 0; i < len(name); i++ {
+		if name[i] != ' ' && name[i] != '\t' {
+			continue
+		}
+		second, ok := skipTreePrefix(strip, name[i+1:])
+		if !ok {
+			return "", false
+		}
+		if second == name[:i] {
+			return second, true
+		}
+	}
+	return "", false
+}
+
+// findName returns the name in ---/+++ line or the other extended header lines.
+// strip leading components are removed, and the name is terminated by a tab if tabTerminates is true.
+// a name which can't be found is empty.
+func findName(line string, strip int, tabTerminates bool) string {
+	line = strings.TrimSuffix(line, "\n")
+	if strings.HasPrefix(line, `"`) {
+		if name, _, ok := unquote(line); ok {
+			for ; strip > 0; strip-- {
+				i := strings.IndexByte(name, '/')
+				if i < 0 {
+					return ""
+				}
+				name = name[i+1:]
+			}
+			return squashSlash(name)
+		}
+	}
+	if tabTerminates {
+		if i := strings.IndexByte(line, '\t'); i >= 0 {
+			line = line[:i]
+		}
+	}
+	start := -1
+	if strip == 0 {
+		start = 0
+	}
+	for i := 0; i < len(line); i++ {
+		if line[i] == '/' {
+			if strip--; strip == 0 {
+				start = i + 1
+			}
+		}
+	}
+	if start < 0 || start == len(line) {
+		return ""
+	}
+	return squashSlash(line[start:])
+}
+
+// squashSlash squashes consecutive slashes in the name.
+func squashSlash(name string) string {
+	for strings.Contains(name, "//") {
+		name = strings.Replace(name, "//", "/", -1)
+	}
+	return name
+}
+
+// guessStrip guesses the number of leading components to be stripped from the name of a traditional patch.
+// it's 0 if the name doesn't have any directories, otherwise it's unknown and -1 is returned.
+func guessStrip(line string) int {
+	if isDevNull(line) {
+		return -1
+	}
+	name := findName(line, 0, true)
+	if name == "" || strings.Contains(name, "/") {
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,513 @@
+package apply
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/xdiff"
+)
+
+// Parse parses patches like $ git apply
+// patches are found in data, other lines like an e-mail header and a commit message are skipped.
+// only Strip of options is used to find the names of the files, options may be nil.
+func Parse(data []byte, options *Options) ([]*Patch, error) {
+	p := &parser{lines: splitLines(data), strip: 1}
+	if options != nil {
+		switch {
+		case options.Strip > 0:
+			p.strip, p.stripKnown = options.Strip, true
+		case options.Strip < 0:
+			p.strip, p.stripKnown = 0, true
+		}
+	}
+	patches := []*Patch{}
+	for p.pos < len(p.lines) {
+		patch, err := p.parseChunk()
+		if err != nil {
+			return nil, err
+		}
+		if patch == nil {
+			break
+		}
+		patches = append(patches, patch)
+	}
+	if len(patches) == 0 {
+		return nil, ErrNoPatch
+	}
+	return patches, nil
+}
+
+// splitLines splits data into lines having trailing '\n', except the last line without '\n'.
+func splitLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// parser is a type holding state while parsing patches.
+type parser struct {
+	lines      []string
+	pos        int  // index of the next line
+	strip      int  // number of leading components removed from names
+	stripKnown bool // strip is given or guessed, otherwise it may be guessed from a traditional patch
+}
+
+func (p *parser) errorf(err error) error {
+	return &PatchError{Line: p.pos + 1, Err: err}
+}
+
+// parseChunk parses the next patch, it returns nil if there are no more patches.
+func (p *parser) parseChunk() (*Patch, error) {
+	patch, err := p.findHeader()
+	if err != nil || patch == nil {
+		return nil, err
+	}
+	if err := p.parseFragments(patch); err != nil {
+		return nil, err
+	}
+	if len(patch.Fragments) > 0 {
+		return patch, nil
+	}
+
+	line := p.line(p.pos)
+	switch {
+	case line == "GIT binary patch\n":
+		p.pos++
+		if err := p.parseBinary(patch); err != nil {
+			return nil, err
+		}
+	case strings.HasSuffix(line, " differ\n") && (strings.HasPrefix(line, "Binary files ") || strings.HasPrefix(line, "Files ")):
+		p.pos++
+		patch.IsBinary = true
+	}
+	// an empty patch can't be applied if it's a text patch without metadata change,
+	// a binary patch appears empty here
+	if !patch.IsBinary && !hasMetadataChanges(patch) {
+		return nil, p.errorf(ErrOnlyGarbage)
+	}
+	return patch, nil
+}
+
+func (p *parser) line(i int) string {
+	if i < len(p.lines) {
+		return p.lines[i]
+	}
+	return ""
+}
+
+// hasMetadataChanges reports whether the patch changes other than the content.
+func hasMetadataChanges(patch *Patch) bool {
+	return patch.IsRename || patch.IsCopy || patch.IsNew || patch.IsDelete ||
+		(patch.OldMode != 0 && patch.NewMode != 0 && patch.OldMode != patch.NewMode)
+}
+
+// findHeader finds the header of the next patch, which is a git diff header or ---/+++ lines.
+// lines before the header are skipped.
+func (p *parser) findHeader() (*Patch, error) {
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		if len(line) < 6 {
+			continue
+		}
+		// a fragment without header means that the patch is corrupted
+		if strings.HasPrefix(line, "@@ -") {
+			if _, ok := parseFragmentHeader(line); ok {
+				return nil, p.errorf(ErrFragmentWithoutHeader)
+			}
+			continue
+		}
+		if p.pos+1 >= len(p.lines) {
+			break
+		}
+		if strings.HasPrefix(line, "diff --git ") {
+			start := p.pos
+			patch, err := p.parseGitHeader()
+			if err != nil {
+				return nil, err
+			}
+			if p.pos == start+1 {
+				// only "diff --git" line, it's not a patch
+				p.pos = start
+				continue
+			}
+			return patch, nil
+		}
+		// --- followed by +++ and a fragment
+		if !strings.HasPrefix(line, "--- ") || !strings.HasPrefix(p.lines[p.pos+1], "+++ ") ||
+			!strings.HasPrefix(p.line(p.pos+2), "@@ -") {
+			continue
+		}
+		patch, err := p.parseTraditionalHeader(line[4:], p.lines[p.pos+1][4:])
+		if err != nil {
+			return nil, err
+		}
+		p.pos += 2
+		return patch, nil
+	}
+	return nil, nil
+}
+
+// parseTraditionalHeader parses names in ---/+++ lines of a patch which isn't generated by git.
+// a creation or a deletion is detected by /dev/null.
+func (p *parser) parseTraditionalHeader(first, second string) (*Patch, error) {
+	if !p.stripKnown {
+		s1, s2 := guessStrip(first), guessStrip(second)
+		if s1 < 0 {
+			s1 = s2
+		}
+		if s1 >= 0 && s1 == s2 {
+			p.strip, p.stripKnown = s1, true
+		}
+	}
+	patch := &Patch{maybeNew: true, maybeDelete: true}
+	var name string
+	switch {
+	case isDevNull(first):
+		patch.IsNew, patch.maybeNew, patch.maybeDelete = true, false, false
+		name = findName(second, p.strip, true)
+		patch.NewName = name
+	case isDevNull(second):
+		patch.IsDelete, patch.maybeNew, patch.maybeDelete = true, false, false
+		name = findName(first, p.strip, true)
+		patch.OldName = name
+	default:
+		// the shorter name is preferred, the other may be a backup like "file.orig"
+		firstName := findName(first, p.strip, true)
+		name = findName(second, p.strip, true)
+		if firstName != "" && (name == "" || len(firstName) < len(name) && strings.HasPrefix(name, firstName)) {
+			name = firstName
+		}
+		patch.OldName, patch.NewName = name, name
+	}
+	if name == "" {
+		return nil, p.errorf(ErrNoFileName)
+	}
+	return patch, nil
+}
+
+// parseGitHeader parses a git diff header, which has the extended header lines after "diff --git" line.
+func (p *parser) parseGitHeader() (*Patch, error) {
+	// the name in "diff --git" line is used for a creation, a deletion and a patch without ---/+++ lines
+	defName, _ := gitHeaderName(p.strip, p.lines[p.pos])
+	p.pos++
+	patch := &Patch{}
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		if !strings.HasSuffix(line, "\n") {
+			break
+		}
+		done, err := p.parseGitHeaderLine(defName, patch, line)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+
+	if patch.OldName == "" && patch.NewName == "" {
+		if defName == "" {
+			return nil, p.errorf(ErrNoFileName)
+		}
+		patch.OldName, patch.NewName = defName, defName
+	}
+	if (patch.NewName == "" && !patch.IsDelete) || (patch.OldName == "" && !patch.IsNew) {
+		return nil, p.errorf(ErrNoFileName)
+	}
+	return patch, nil
+}
+
+// parseGitHeaderLine parses a line of git diff header, it returns true at the end of the header.
+func (p *parser) parseGitHeaderLine(defName string, patch *Patch, line string) (bool, error) {
+	var err error
+	switch {
+	case strings.HasPrefix(line, "@@ -"):
+		return true, nil
+	case strings.HasPrefix(line, "--- "):
+		err = p.verifyName(line[4:], patch.IsNew, &patch.OldName)
+	case strings.HasPrefix(line, "+++ "):
+		err = p.verifyName(line[4:], patch.IsDelete, &patch.NewName)
+	case strings.HasPrefix(line, "old mode "):
+		patch.OldMode, err = parseMode(line[9:])
+	case strings.HasPrefix(line, "new mode "):
+		patch.NewMode, err = parseMode(line[9:])
+	case strings.HasPrefix(line, "deleted file mode "):
+		patch.IsDelete = true
+		patch.OldName = defName
+		patch.OldMode, err = parseMode(line[18:])
+	case strings.HasPrefix(line, "new file mode "):
+		patch.IsNew = true
+		patch.NewName = defName
+		patch.NewMode, err = parseMode(line[14:])
+	case strings.HasPrefix(line, "copy from "):
+		patch.IsCopy = true
+		patch.OldName = findName(line[10:], p.renameStrip(), false)
+	case strings.HasPrefix(line, "copy to "):
+		patch.IsCopy = true
+		patch.NewName = findName(line[8:], p.renameStrip(), false)
+	case strings.HasPrefix(line, "rename old "):
+		patch.IsRename = true
+		patch.OldName = findName(line[11:], p.renameStrip(), false)
+	case strings.HasPrefix(line, "rename new "):
+		patch.IsRename = true
+		patch.NewName = findName(line[11:], p.renameStrip(), false)
+	case strings.HasPrefix(line, "rename from "):
+		patch.IsRename = true
+		patch.OldName = findName(line[12:], p.renameStrip(), false)
+	case strings.HasPrefix(line, "rename to "):
+		patch.IsRename = true
+		patch.NewName = findName(line[10:], p.renameStrip(), false)
+	case strings.HasPrefix(line, "similarity index "):
+		patch.Score = parseScore(line[17:])
+	case strings.HasPrefix(line, "dissimilarity index "):
+		patch.Score = parseScore(line[20:])
+	case strings.HasPrefix(line, "index "):
+		err = parseIndexLine(patch, line[6:])
+	default:
+		// an unrecognized line ends the header, e.g. "Binary files ... differ" or the next patch
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// a patch can be only one of creation, deletion, rename and copy
+	extensions := 0
+	for _, b := range []bool{patch.IsNew, patch.IsDelete, patch.IsRename, patch.IsCopy} {
+		if b {
+			extensions++
+		}
+	}
+	if extensions > 1 {
+		return false, p.errorf(ErrInconsistentHeader)
+	}
+	return false, nil
+}
+
+// renameStrip returns the number of leading components removed from names in rename and copy lines,
+// they don't have "a/" and "b/" prefixes.
+func (p *parser) renameStrip() int {
+	if p.strip > 0 {
+		return p.strip - 1
+	}
+	return 0
+}
+
+// verifyName verifies the name in ---/+++ line is consistent with the name found already.
+func (p *parser) verifyName(line string, isNull bool, name *string) error {
+	if *name == "" && !isNull {
+		*name = findName(line, p.strip, true)
+		return nil
+	}
+	if *name != "" {
+		if isNull || findName(line, p.strip, true) != *name {
+			return p.errorf(ErrInconsistentHeader)
+		}
+		return nil
+	}
+	if !isDevNull(line) {
+		return p.errorf(ErrInconsistentHeader)
+	}
+	return nil
+}
+
+// parseMode parses octal digits of a mode.
+func parseMode(s string) (object.FileMode, error) {
+	s = strings.TrimSpace(s)
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, ErrCorruptPatch
+	}
+	return object.FileMode(mode), nil
+}
+
+// parseScore parses a score in percent like "90%", an invalid score is 0.
+func parseScore(s string) int {
+	i := 0
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+	}
+	score, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0
+	}
+	return score
+}
+
+// parseIndexLine parses "<old digest>..<new digest>[ <mode>]".
+// the line is ignored if the digests are too long.
+func parseIndexLine(patch *Patch, line string) error {
+	line = strings.TrimSuffix(line, "\n")
+	dots := strings.Index(line, "..")
+	if dots < 0 || dots > object.DigestSize*2 {
+		return nil
+	}
+	old, rest := line[:dots], line[dots+2:]
+	mode := ""
+	if space := strings.IndexByte(rest, ' '); space >= 0 {
+		rest, mode = rest[:space], rest[space+1:]
+	}
+	if len(rest) > object.DigestSize*2 {
+		return nil
+	}
+	patch.OldDigest, patch.NewDigest = old, rest
+	if mode != "" {
+		m, err := parseMode(mode)
+		if err != nil {
+			return err
+		}
+		patch.OldMode = m
+	}
+	return nil
+}
+
+// parseFragmentHeader parses "@@ -<old start>[,<old lines>] +<new start>[,<new lines>] @@".
+func parseFragmentHeader(line string) (*Fragment, bool) {
+	if !strings.HasSuffix(line, "\n") {
+		return nil, false
+	}
+	frag := &Fragment{}
+	rest, ok := parseRange(line[4:], " +", &frag.OldPos, &frag.OldLines)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := parseRange(rest, " @@", &frag.NewPos, &frag.NewLines); !ok {
+		return nil, false
+	}
+	return frag, true
+}
+
+// parseRange parses "<start>[,<lines>]" followed by expect, lines is 1 if it's omitted.
+func parseRange(s, expect string, start, lines *int) (string, bool) {
+	var ok bool
+	if *start, s, ok = parseNum(s); !ok {
+		return "", false
+	}
+	*lines = 1
+	if strings.HasPrefix(s, ",") {
+		if *lines, s, ok = parseNum(s[1:]); !ok {
+			return "", false
+		}
+	}
+	if !strings.HasPrefix(s, expect) {
+		return "", false
+	}
+	return s[len(expect):], true
+}
+
+func parseNum(s string) (int, string, bool) {
+	i := 0
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, "", false
+	}
+	return n, s[i:], true
+}
+
+// parseFragments parses fragments following the header.
+func (p *parser) parseFragments(patch *Patch) error {
+	oldLines, newLines, context := 0, 0, 0
+	for strings.HasPrefix(p.line(p.pos), "@@ -") {
+		frag, err := p.parseFragment(patch)
+		if err != nil {
+			return err
+		}
+		patch.Fragments = append(patch.Fragments, frag)
+		oldLines += frag.OldLines
+		newLines += frag.NewLines
+		context += frag.Leading + frag.Trailing
+	}
+
+	// a patch removing lines can't be a creation, and a patch adding lines can't be a deletion.
+	// patches having many fragments can't be either.
+	if oldLines > 0 || len(patch.Fragments) > 1 {
+		patch.maybeNew = false
+	}
+	if newLines > 0 || len(patch.Fragments) > 1 {
+		patch.maybeDelete = false
+	}
+	if patch.IsNew && oldLines > 0 {
+		return &PatchError{Path: patch.NewName, Err: ErrNewFileDependsOnOld}
+	}
+	if patch.IsDelete && newLines > 0 {
+		return &PatchError{Path: patch.OldName, Err: ErrDeletedFileHasContents}
+	}
+	return nil
+}
+
+// parseFragment parses a fragment, which is a hunk header and lines.
+func (p *parser) parseFragment(patch *Patch) (*Fragment, error) {
+	frag, ok := parseFragmentHeader(p.lines[p.pos])
+	if !ok {
+		return nil, p.errorf(ErrCorruptPatch)
+	}
+	p.pos++
+	oldLines, newLines := frag.OldLines, frag.NewLines
+	added, deleted, leading, trailing := 0, 0, 0, 0
+	for ; p.pos < len(p.lines) && (oldLines > 0 || newLines > 0); p.pos++ {
+		line := p.lines[p.pos]
+		if !strings.HasSuffix(line, "\n") {
+			return nil, p.errorf(ErrCorruptPatch)
+		}
+		switch line[0] {
+		case '\n', ' ':
+			// an empty line is a context line made by newer GNU diff
+			oldLines--
+			newLines--
+			if added == 0 && deleted == 0 {
+				leading++
+			}
+			trailing++
+			text := line[1:]
+			if line[0] == '\n' {
+				text = line
+			}
+			frag.Lines = append(frag.Lines, &xdiff.Line{Op: ' ', Text: []byte(text)})
+		case '-':
+			deleted++
+			oldLines--
+			trailing = 0
+			frag.Lines = append(frag.Lines, &xdiff.Line{Op: '-', Text: []byte(line[1:])})
+		case '+':
+			added++
+			newLines--
+			trailing = 0
+			frag.Lines = append(frag.Lines, &xdiff.Line{Op: '+', Text: []byte(line[1:])})
+		case '\\':
+			// "\ No newline at end of file" may be localized, so only its beginning is checked
+			if len(line) < 12 || !strings.HasPrefix(line, `\ `) {
+				return nil, p.errorf(ErrCorruptPatch)
+			}
+			p.removeNewline(frag)
+		default:
+			return nil, p.errorf(ErrCorruptPatch)
+		}
+	}
+	if oldLines != 0 || newLines != 0 || (added == 0 && deleted == 0) {
+		return nil, p.errorf(ErrCorruptPatch)
+	}
+	frag.Leading, frag.Trailing = leading, trailing
+
+	// an incomplete last line is followed by "\ No newline at end of file"
+	if line := p.line(p.pos); len(line) > 12 && strings.HasPrefix(line, `\ `) {
+		p.removeNewline(frag)
+		p.pos++
+	}
+	patch.linesAdded += added
+	patch.linesDeleted += deleted
+	return frag, nil
+}
+
+// removeNewline removes '\n' of the last line of the fragment.
+func (p *parser) removeNewline(frag *Fragment) {
+	if n := len(frag.Lines); n > 0 {
+		frag.Lines[n-1].Text = bytes.TrimSuffix(frag.Lines[n-1].Text, []byte("\n"))
+	}
+}
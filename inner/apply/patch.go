@@ -0,0 +1,93 @@
+package apply
+
+import (
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/xdiff"
+)
+
+// Patch is a type representing changes of a file in a patch.
+type Patch struct {
+	OldName   string          // path before the change, it's empty for a creation
+	NewName   string          // path after the change, it's empty for a deletion
+	OldMode   object.FileMode // mode before the change, 0 if the patch doesn't tell it
+	NewMode   object.FileMode // mode after the change, 0 if the patch doesn't tell it
+	IsNew     bool            // the file is created
+	IsDelete  bool            // the file is deleted
+	IsRename  bool            // the file is renamed from OldName to NewName
+	IsCopy    bool            // the file is copied from OldName to NewName
+	Score     int             // similarity index of a rename or a copy, or dissimilarity index of a rewrite in percent
+	OldDigest string          // hex digits of the blob before the change in index line, it may be abbreviated
+	NewDigest string          // hex digits of the blob after the change in index line, it may be abbreviated
+	IsBinary  bool            // the file is binary, Binary is nil if the patch doesn't include the data
+	Fragments []*Fragment     // hunks of a text patch
+	Binary    *BinaryHunk     // hunk of a binary patch to produce the new content
+	Reverse   *BinaryHunk     // hunk of a binary patch to produce the old content, it may be nil
+
+	maybeNew     bool // a traditional patch may create the file, it's a creation if the file doesn't exist
+	maybeDelete  bool // a traditional patch may delete the file, it's used only for reverse
+	linesAdded   int
+	linesDeleted int
+}
+
+// Fragment is a type representing a hunk of a text patch.
+// line numbers are 1-based like hunk header.
+type Fragment struct {
+	OldPos   int
+	OldLines int
+	NewPos   int
+	NewLines int
+	Leading  int           // number of context lines before the first change
+	Trailing int           // number of context lines after the last change
+	Lines    []*xdiff.Line // lines of the hunk, a line followed by "\ No newline at end of file" doesn't have '\n'
+}
+
+// BinaryMethod is a type representing how a binary hunk produces the content.
+type BinaryMethod int
+
+// constants of BinaryMethod
+const (
+	BinaryLiteral BinaryMethod = iota // the hunk is the content itself
+	BinaryDelta                       // the hunk is a delta from the other content
+)
+
+// BinaryHunk is a type representing a hunk of a binary patch.
+type BinaryHunk struct {
+	Method BinaryMethod
+	Data   []byte // inflated data of the hunk
+}
+
+// reversed returns a copy of the patch which changes the file in reverse.
+func (p *Patch) reversed() *Patch {
+	r := *p
+	r.OldName, r.NewName = p.NewName, p.OldName
+	r.OldMode, r.NewMode = p.NewMode, p.OldMode
+	r.IsNew, r.IsDelete = p.IsDelete, p.IsNew
+	r.maybeNew, r.maybeDelete = p.maybeDelete, p.maybeNew
+	r.OldDigest, r.NewDigest = p.NewDigest, p.OldDigest
+	r.linesAdded, r.linesDeleted = p.linesDeleted, p.linesAdded
+	r.Binary, r.Reverse = p.Reverse, p.Binary
+	r.Fragments = make([]*Fragment, len(p.Fragments))
+	for i, frag := range p.Fragments {
+		lines := make([]*xdiff.Line, len(frag.Lines))
+		for j, line := range frag.Lines {
+			op := line.Op
+			switch op {
+			case '-':
+				op = '+'
+			case '+':
+				op = '-'
+			}
+			lines[j] = &xdiff.Line{Op: op, Text: line.Text}
+		}
+		r.Fragments[i] = &Fragment{
+			OldPos:   frag.NewPos,
+			OldLines: frag.NewLines,
+			NewPos:   frag.OldPos,
+			NewLines: frag.OldLines,
+			Leading:  frag.Leading,
+			Trailing: frag.Trailing,
+			Lines:    lines,
+		}
+	}
+	return &r
+}
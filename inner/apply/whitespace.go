@@ -0,0 +1,236 @@
+package apply
+
+import (
+	"strconv"
+	"strings"
+)
+
+// WhitespaceAction is a type representing what to do for whitespace errors in added lines.
+type WhitespaceAction int
+
+// constants of WhitespaceAction, same as --whitespace option of git apply
+const (
+	WhitespaceNoWarn WhitespaceAction = iota // whitespace errors are ignored, it's "nowarn" and "warn"
+	WhitespaceFix                            // whitespace errors are fixed, it's "fix" and "strip"
+	WhitespaceError                          // patches having whitespace errors are refused, it's "error" and "error-all"
+)
+
+// ParseWhitespaceAction parses the value of --whitespace option or apply.whitespace config.
+func ParseWhitespaceAction(s string) (WhitespaceAction, error) {
+	switch s {
+	case "nowarn", "warn":
+		return WhitespaceNoWarn, nil
+	case "fix", "strip":
+		return WhitespaceFix, nil
+	case "error", "error-all":
+		return WhitespaceError, nil
+	}
+	return 0, ErrInvalidWhitespace
+}
+
+// whitespace rules, same as core.whitespace config and whitespace attribute
+const (
+	wsBlankAtEOL       = 0100
+	wsSpaceBeforeTab   = 0200
+	wsIndentWithNonTab = 0400
+	wsCRAtEOL          = 01000
+	wsBlankAtEOF       = 02000
+	wsTabInIndent      = 04000
+	wsTrailingSpace    = wsBlankAtEOL | wsBlankAtEOF
+	wsTabWidthMask     = 077
+	wsDefaultRule      = wsTrailingSpace | wsSpaceBeforeTab | 8
+)
+
+// wsRuleNames are names of whitespace rules.
+// cr-at-eol loosens errors and tab-in-indent isn't enabled by default, so they are not set by "whitespace" attribute.
+var wsRuleNames = []struct {
+	name           string
+	rule           uint
+	loosensError   bool
+	excludeDefault bool
+}{
+	{"trailing-space", wsTrailingSpace, false, false},
+	{"space-before-tab", wsSpaceBeforeTab, false, false},
+	{"indent-with-non-tab", wsIndentWithNonTab, false, false},
+	{"cr-at-eol", wsCRAtEOL, true, false},
+	{"blank-at-eol", wsBlankAtEOL, false, false},
+	{"blank-at-eof", wsBlankAtEOF, false, false},
+	{"tab-in-indent", wsTabInIndent, false, true},
+}
+
+// parseWhitespaceRule parses comma separated rules like core.whitespace config.
+// a rule prefixed by '-' is disabled, and "tabwidth=<n>" sets width of a tab.
+func parseWhitespaceRule(s string) (uint, error) {
+	rule := uint(wsDefaultRule)
+	for _, token := range strings.Split(s, ",") {
+		token = strings.TrimLeft(token, " \t\n\r")
+		negated := strings.HasPrefix(token, "-")
+		if negated {
+			token = token[1:]
+		}
+		if token == "" {
+			break
+		}
+		for _, r := range wsRuleNames {
+			if !strings.HasPrefix(r.name, token) {
+				continue
+			}
+			if negated {
+				rule &^= r.rule
+			} else {
+				rule |= r.rule
+			}
+			break
+		}
+		if strings.HasPrefix(token, "tabwidth=") {
+			if width, err := strconv.Atoi(token[9:]); err == nil && 0 < width && width < 0100 {
+				rule = rule&^wsTabWidthMask | uint(width)
+			}
+		}
+	}
+	if rule&wsTabInIndent != 0 && rule&wsIndentWithNonTab != 0 {
+		return 0, ErrInvalidWhitespaceRule
+	}
+	return rule, nil
+}
+
+func wsTabWidth(rule uint) int {
+	return int(rule & wsTabWidthMask)
+}
+
+// wsCheck returns rules which the line violates, the line may have trailing '\n'.
+func wsCheck(line []byte, rule uint) uint {
+	result := uint(0)
+	n := len(line)
+	if n > 0 && line[n-1] == '\n' {
+		n--
+	}
+	if rule&wsCRAtEOL != 0 && n > 0 && line[n-1] == '\r' {
+		n--
+	}
+
+	trailing := n
+	if rule&wsBlankAtEOL != 0 {
+		for i := n - 1; i >= 0 && isSpace(line[i]); i-- {
+			trailing = i
+			result |= wsBlankAtEOL
+		}
+	}
+
+	// check indentation
+	written, i := 0, 0
+	for ; i < trailing; i++ {
+		if line[i] == ' ' {
+			continue
+		}
+		if line[i] != '\t' {
+			break
+		}
+		if rule&wsSpaceBeforeTab != 0 && written < i {
+			result |= wsSpaceBeforeTab
+		} else if rule&wsTabInIndent != 0 {
+			result |= wsTabInIndent
+		}
+		written = i + 1
+	}
+	if rule&wsIndentWithNonTab != 0 && i-written >= wsTabWidth(rule) {
+		result |= wsIndentWithNonTab
+	}
+	return result
+}
+
+// wsBlankLine reports whether the line has only whitespaces.
+func wsBlankLine(line []byte) bool {
+	for _, c := range line {
+		if !isSpace(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// wsFix returns the line whose whitespace errors are fixed.
+// trailing whitespaces are removed, and the indent is fixed with tabs or spaces.
+func wsFix(line []byte, rule uint) []byte {
+	src := line
+	addNL, addCR := false, false
+	if rule&wsBlankAtEOL != 0 {
+		if n := len(src); n > 0 && src[n-1] == '\n' {
+			addNL = true
+			src = src[:n-1]
+			if n := len(src); n > 0 && src[n-1] == '\r' {
+				addCR = rule&wsCRAtEOL != 0
+				src = src[:n-1]
+			}
+		}
+		for len(src) > 0 && isSpace(src[len(src)-1]) {
+			src = src[:len(src)-1]
+		}
+	}
+
+	// check leading whitespaces
+	lastTab, lastSpace := -1, -1
+	needFixLeadingSpace := false
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\t' {
+			lastTab = i
+			if rule&wsSpaceBeforeTab != 0 && lastSpace >= 0 {
+				needFixLeadingSpace = true
+			}
+		} else if src[i] == ' ' {
+			lastSpace = i
+			if rule&wsIndentWithNonTab != 0 && wsTabWidth(rule) <= i-lastTab {
+				needFixLeadingSpace = true
+			}
+		} else {
+			break
+		}
+	}
+
+	dst := []byte{}
+	switch {
+	case needFixLeadingSpace:
+		// spaces in the indent are replaced with tabs
+		last := lastTab + 1
+		if rule&wsIndentWithNonTab != 0 && lastTab < lastSpace {
+			last = lastSpace + 1
+		}
+		spaces := 0
+		for _, c := range src[:last] {
+			if c != ' ' {
+				spaces = 0
+				dst = append(dst, c)
+				continue
+			}
+			if spaces++; spaces == wsTabWidth(rule) {
+				dst = append(dst, '\t')
+				spaces = 0
+			}
+		}
+		for ; spaces > 0; spaces-- {
+			dst = append(dst, ' ')
+		}
+		src = src[last:]
+	case rule&wsTabInIndent != 0 && lastTab >= 0:
+		// tabs in the indent are expanded to spaces
+		for _, c := range src[:lastTab+1] {
+			if c != '\t' {
+				dst = append(dst, c)
+				continue
+			}
+			dst = append(dst, ' ')
+			for len(dst)%wsTabWidth(rule) != 0 {
+				dst = append(dst, ' ')
+			}
+		}
+		src = src[lastTab+1:]
+	}
+	dst = append(dst, src...)
+	if addCR {
+		dst = append(dst, '\r')
+	}
+	if addNL {
+		dst = append(dst, '\n')
+	}
+	return dst
+}
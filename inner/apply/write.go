@@ -0,0 +1,220 @@
+package apply
+
+import (
+	"encoding/hex"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// writeResults writes results of the patches to the working tree and the index.
+// old paths are removed first, and then new paths are created, because patches may swap paths.
+func (a *applier) writeResults(files []*file) error {
+	for _, f := range files {
+		if f.OldName == "" || f.IsCopy {
+			continue
+		}
+		if f.IsDelete || f.IsRename {
+			if err := a.removeFile(f.OldName, true); err != nil {
+				return err
+			}
+		}
+	}
+	conflicted := false
+	for _, f := range files {
+		if f.IsDelete {
+			continue
+		}
+		if err := a.createFile(f); err != nil {
+			return err
+		}
+		conflicted = conflicted || f.conflicted
+	}
+	if a.updateIndex {
+		if err := index.WriteIndex(a.gitDir, index.NewIndex(a.entries)); err != nil {
+			return err
+		}
+	}
+	if conflicted {
+		return ErrConflict
+	}
+	return nil
+}
+
+// removeFile removes the path from the index and the working tree.
+// if rmdir is true, leading directories which become empty are also removed.
+func (a *applier) removeFile(name string, rmdir bool) error {
+	if a.updateIndex {
+		a.removeEntries(name)
+	}
+	if a.options.Cached {
+		return nil
+	}
+	if err := os.Remove(a.path(name)); err != nil && !os.IsNotExist(err) {
+		info, statErr := os.Lstat(a.path(name))
+		// a submodule directory which has contents is left as it is
+		if statErr != nil || !info.IsDir() {
+			return err
+		}
+	}
+	if rmdir {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if os.Remove(a.path(dir)) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// removeEntries removes entries of the path at all stages.
+func (a *applier) removeEntries(name string) {
+	entries := a.entries[:0]
+	for _, entry := range a.entries {
+		if entry.Name != name {
+			entries = append(entries, entry)
+		}
+	}
+	a.entries = entries
+	delete(a.stage0, name)
+}
+
+// createFile writes the result of the patch to the new path in the working tree and the index.
+func (a *applier) createFile(f *file) error {
+	if !a.options.Cached {
+		if err := a.writeFile(f.NewName, f.NewMode, f.result); err != nil {
+			return err
+		}
+	}
+	if !a.updateIndex {
+		return nil
+	}
+	a.removeEntries(f.NewName)
+	if f.conflicted {
+		for i, content := range f.stages {
+			if content == nil {
+				continue
+			}
+			digest, err := object.WriteObject(a.gitDir, object.NewBlobFromBytes(content))
+			if err != nil {
+				return err
+			}
+			entry := newEntry(f.NewName, f.NewMode, digest)
+			entry.ConflictFlag = index.ConflictFlag(i + 1)
+			a.entries = append(a.entries, entry)
+		}
+		return nil
+	}
+	return a.addIndexEntry(f.NewName, f.NewMode, f.result)
+}
+
+// writeFile writes the content to the path in the working tree.
+// a symbolic link is created for a symbolic link, and an empty directory is created for a submodule.
+func (a *applier) writeFile(name string, mode object.FileMode, content []byte) error {
+	filePath := a.path(name)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0777); err != nil {
+		return err
+	}
+	switch mode {
+	case object.ModeGitLink:
+		// submodules are not checked out
+		if err := os.Mkdir(filePath, 0777); err != nil && !os.IsExist(err) {
+			return err
+		}
+		return nil
+	case object.ModeSymlink:
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Symlink(filepath.FromSlash(string(content)), filePath)
+	}
+	data, err := a.converter.ToWorktree(name, content)
+	if err != nil {
+		return err
+	}
+	// the file is recreated to set the permission
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	perm := os.FileMode(0666)
+	if mode == object.ModeExecutable {
+		perm = 0777
+	}
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// addIndexEntry adds an entry of the content at stage 0.
+// stat information is taken from the working tree unless Cached is set.
+func (a *applier) addIndexEntry(name string, mode object.FileMode, content []byte) error {
+	var digest []byte
+	if mode == object.ModeGitLink {
+		line := strings.TrimSuffix(string(content), "\n")
+		if !strings.HasPrefix(line, "Subproject commit ") {
+			return &PatchError{Path: name, Err: ErrCorruptSubmodule}
+		}
+		var err error
+		if digest, err = hex.DecodeString(strings.TrimPrefix(line, "Subproject commit ")); err != nil || len(digest) != object.DigestSize {
+			return &PatchError{Path: name, Err: ErrCorruptSubmodule}
+		}
+	} else {
+		var err error
+		if digest, err = object.WriteObject(a.gitDir, object.NewBlobFromBytes(content)); err != nil {
+			return err
+		}
+	}
+
+	entry := newEntry(name, mode, digest)
+	if !a.options.Cached && mode != object.ModeGitLink {
+		info, err := os.Lstat(a.path(name))
+		if err != nil {
+			return err
+		}
+		if entry, err = index.NewEntry(info, digest); err != nil {
+			return err
+		}
+		entry.Name = name
+		// the mode in the patch is trusted when core.filemode is false
+		if !a.fileMode {
+			entry.ObjectType, entry.Permission = entryMode(mode)
+		}
+	}
+	a.entries = append(a.entries, entry)
+	a.stage0[name] = entry
+	return nil
+}
+
+// newEntry creates an index entry without stat information.
+func newEntry(name string, mode object.FileMode, digest []byte) *index.Entry {
+	objectType, perm := entryMode(mode)
+	return &index.Entry{
+		ObjectType: objectType,
+		Permission: perm,
+		Digest:     digest,
+		Name:       name,
+	}
+}
+
+// entryMode returns object type and permission of the index entry for the mode.
+func entryMode(mode object.FileMode) (index.ObjectType, uint16) {
+	switch mode {
+	case object.ModeExecutable:
+		return index.RegularFile, 0755
+	case object.ModeSymlink:
+		return index.SymbolicLink, 0
+	case object.ModeGitLink:
+		return index.GitLink, 0
+	}
+	return index.RegularFile, 0644
+}
@@ -0,0 +1,282 @@
+// attributes is a package to resolve attributes of paths from .gitattributes files
+//
+// Attributes are read from the following files, a file listed earlier has higher precedence.
+// In each file, the last matching line decides the value of an attribute.
+//
+//  .git/info/attributes  - attributes of the repository which are not shared
+//  <dir>/.gitattributes  - patterns relative to the directory, deeper one has higher precedence
+//  core.attributesFile   - attributes of the user, $XDG_CONFIG_HOME/git/attributes by default
+//  /etc/gitattributes    - attributes of the system
+//
+// Each line is a pattern followed by attributes in the following forms.
+//
+//  <attr>         - the attribute is set
+//  -<attr>        - the attribute is unset
+//  !<attr>        - the attribute is unspecified
+//  <attr>=<value> - the attribute is set to the value
+//
+// A line starting with "[attr]<name>" defines a macro which is expanded when it is set.
+// The macro "binary" is built in as "-diff -merge -text".
+//
+// If you want to know more about attributes, please refer to
+// https://git-scm.com/docs/gitattributes
+package attributes
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// FileName is name of per-directory attributes files
+const FileName = ".gitattributes"
+
+// State is a type representing state of an attribute.
+type State int
+
+// constants of State
+const (
+	Unspecified State = iota // no line decides the attribute
+	Set                      // the attribute is set
+	Unset                    // the attribute is unset with '-'
+	Valued                   // the attribute is set to a value
+)
+
+// Value is a type representing value of an attribute.
+type Value struct {
+	State State  // state of the attribute
+	Text  string // value of the attribute, it's used only if State is Valued
+}
+
+// String is implementation of fmt.Stringer interface
+// it returns the same format as $ git check-attr
+func (v Value) String() string {
+	switch v.State {
+	case Set:
+		return "set"
+	case Unset:
+		return "unset"
+	case Valued:
+		return v.Text
+	default:
+		return "unspecified"
+	}
+}
+
+// Is reports whether the attribute is set to the value.
+func (v Value) Is(text string) bool {
+	return v.State == Valued && v.Text == text
+}
+
+// builtinMacros are macros defined by git itself
+const builtinMacros = "[attr]binary -diff -merge -text\n"
+
+// Matcher is a type to resolve attributes of paths in the working tree.
+type Matcher struct {
+	workDir    string
+	ignoreCase bool
+	info       []*line            // lines of .git/info/attributes
+	global     [][]*line          // lines of core.attributesFile and /etc/gitattributes
+	dirs       map[string][]*line // lines of .gitattributes by directory
+	macros     map[string][]*assignment
+}
+
+// NewMatcher creates a Matcher of the repository.
+// gitDir of parameters must be path to .git directory.
+func NewMatcher(gitDir string) (*Matcher, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	return newMatcher(gitDir, cfg)
+}
+
+func newMatcher(gitDir string, cfg *config.Config) (*Matcher, error) {
+	ignoreCase, err := cfg.Bool("core.ignorecase", false)
+	if err != nil {
+		return nil, err
+	}
+	m := &Matcher{
+		workDir:    filepath.Dir(gitDir),
+		ignoreCase: ignoreCase,
+		dirs:       map[string][]*line{},
+		macros:     map[string][]*assignment{},
+	}
+	if m.info, err = readLines(filepath.Join(gitDir, "info", "attributes"), "", true); err != nil {
+		return nil, err
+	}
+	attributesFile, ok := cfg.Path("core.attributesfile")
+	if !ok {
+		attributesFile = defaultAttributesFile()
+	}
+	files := []string{attributesFile}
+	if os.Getenv("GIT_ATTR_NOSYSTEM") == "" {
+		files = append(files, "/etc/gitattributes")
+	}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		lines, err := readLines(file, "", true)
+		if err != nil {
+			return nil, err
+		}
+		m.global = append(m.global, lines)
+	}
+	top, err := m.dirLines("")
+	if err != nil {
+		return nil, err
+	}
+
+	// a macro defined in a file with higher precedence or later in the same file wins
+	sources := [][]*line{parseLines([]byte(builtinMacros), "", true)}
+	for i := len(m.global) - 1; i >= 0; i-- {
+		sources = append(sources, m.global[i])
+	}
+	sources = append(sources, top, m.info)
+	for _, lines := range sources {
+		for _, l := range lines {
+			if l.macro != "" {
+				m.macros[l.macro] = l.assignments
+			}
+		}
+	}
+	return m, nil
+}
+
+// OpenMatcher creates a Matcher of current repository.
+func OpenMatcher() (*Matcher, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewMatcher(gitDir)
+}
+
+// defaultAttributesFile returns $XDG_CONFIG_HOME/git/attributes or ~/.config/git/attributes
+func defaultAttributesFile() string {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "attributes")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "git", "attributes")
+}
+
+// readLines reads lines of the attributes file, a missing file has no lines.
+func readLines(file, base string, allowMacro bool) ([]*line, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) || isNotDir(err) {
+		return []*line{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseLines(data, base, allowMacro), nil
+}
+
+// isNotDir returns whether the error is caused by a file in the way of a directory.
+func isNotDir(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	return ok && pathErr.Err == syscall.ENOTDIR
+}
+
+// dirLines returns lines of .gitattributes in the directory, they are read only once.
+// macros can be defined only in the top level directory.
+func (m *Matcher) dirLines(dir string) ([]*line, error) {
+	if lines, ok := m.dirs[dir]; ok {
+		return lines, nil
+	}
+	file := filepath.Join(m.workDir, filepath.FromSlash(dir), FileName)
+	if info, err := os.Lstat(file); err == nil && !info.Mode().IsRegular() {
+		// same as git, .gitattributes is not followed if it's a symbolic link
+		m.dirs[dir] = []*line{}
+		return m.dirs[dir], nil
+	}
+	lines, err := readLines(file, dir, dir == "")
+	if err != nil {
+		return nil, err
+	}
+	m.dirs[dir] = lines
+	return lines, nil
+}
+
+// All returns all attributes of the path except unspecified ones.
+// path is relative from top level directory and separated by '/'.
+func (m *Matcher) All(name string) (map[string]Value, error) {
+	if name == "" || path.IsAbs(name) || name != path.Clean(name) || strings.HasPrefix(name, "../") {
+		return nil, ErrInvalidPath
+	}
+
+	// files in order of precedence
+	stack := [][]*line{m.info}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		lines, err := m.dirLines(dir)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, lines)
+	}
+	top, err := m.dirLines("")
+	if err != nil {
+		return nil, err
+	}
+	stack = append(stack, top)
+	stack = append(stack, m.global...)
+
+	values := map[string]Value{}
+	for _, lines := range stack {
+		for i := len(lines) - 1; i >= 0; i-- {
+			l := lines[i]
+			if l.pattern != nil && l.pattern.Match(name, false, m.ignoreCase) {
+				m.fill(values, l.assignments)
+			}
+		}
+	}
+	for attr, value := range values {
+		if value.State == Unspecified {
+			delete(values, attr)
+		}
+	}
+	return values, nil
+}
+
+// fill decides attributes which are not decided yet, the last assignment wins.
+func (m *Matcher) fill(values map[string]Value, assignments []*assignment) {
+	for i := len(assignments) - 1; i >= 0; i-- {
+		a := assignments[i]
+		if _, ok := values[a.name]; ok {
+			continue
+		}
+		values[a.name] = a.value
+		if macro, ok := m.macros[a.name]; ok && a.value.State == Set {
+			m.fill(values, macro)
+		}
+	}
+}
+
+// Check returns values of the attributes of the path.
+// path is relative from top level directory and separated by '/'.
+func (m *Matcher) Check(name string, attrs ...string) ([]Value, error) {
+	all, err := m.All(name)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]Value, len(attrs))
+	for i, attr := range attrs {
+		values[i] = all[attr]
+	}
+	return values, nil
+}
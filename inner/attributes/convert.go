@@ -0,0 +1,316 @@
+package attributes
+
+import (
+	"bytes"
+	"runtime"
+
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/lfs"
+)
+
+// crlfAction is a type representing how line endings of a file are converted.
+type crlfAction int
+
+// constants of crlfAction, same as convert.c of git
+const (
+	crlfUndefined crlfAction = iota
+	crlfBinary               // no conversion
+	crlfText                 // text, line endings in the working tree follow core.eol
+	crlfTextInput            // text, LF in the working tree
+	crlfTextCRLF             // text, CRLF in the working tree
+	crlfAuto                 // detect text, line endings in the working tree follow core.eol
+	crlfAutoInput            // detect text, LF in the working tree
+	crlfAutoCRLF             // detect text, CRLF in the working tree
+)
+
+// Converter is a type to convert content of files between the working tree and the repository.
+// contents are converted by the filter driver of filter attribute, and
+// line endings are converted according to text and eol attributes, core.autocrlf and core.eol.
+// Git LFS is handled natively if the driver of filter=lfs has no commands.
+// Close must be called to stop filter processes after use.
+type Converter struct {
+	gitDir     string
+	attributes *Matcher
+	config     *config.Config
+	autoCRLF   string                    // "true", "false" or "input"
+	eolIsCRLF  bool                      // core.eol is crlf
+	processes  map[string]*filterProcess // running filter processes by command
+	lfs        *lfs.Store                // local LFS store, it's opened on demand
+
+	// IndexBlob returns content of the blob of the path at stage 0 in the index, or nil if the path isn't in the
+	// index. CRLF of files whose blobs in the index have CRLF is kept by auto conversion like git, so the files
+	// aren't regarded as changed only by line endings. nil regards all paths as not in the index.
+	IndexBlob func(name string) ([]byte, error)
+}
+
+// NewConverter creates a Converter of the repository.
+// gitDir of parameters must be path to .git directory.
+func NewConverter(gitDir string) (*Converter, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := newMatcher(gitDir, cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &Converter{
+		gitDir:     gitDir,
+		attributes: attributes,
+		config:     cfg,
+		autoCRLF:   "false",
+		processes:  map[string]*filterProcess{},
+	}
+	if value, ok := cfg.Get("core.autocrlf"); ok {
+		if value == "input" {
+			c.autoCRLF = value
+		} else if autoCRLF, err := config.ParseBool(value); err != nil {
+			return nil, err
+		} else if autoCRLF {
+			c.autoCRLF = "true"
+		}
+	}
+	switch cfg.GetString("core.eol", "native") {
+	case "crlf":
+		c.eolIsCRLF = true
+	case "native":
+		c.eolIsCRLF = runtime.GOOS == "windows"
+	}
+	return c, nil
+}
+
+// Attributes returns Matcher what this Converter uses.
+func (c *Converter) Attributes() *Matcher {
+	return c.attributes
+}
+
+// textEOLIsCRLF reports whether text files have CRLF in the working tree.
+func (c *Converter) textEOLIsCRLF() bool {
+	switch c.autoCRLF {
+	case "true":
+		return true
+	case "input":
+		return false
+	}
+	return c.eolIsCRLF
+}
+
+// action decides how line endings of the path are converted.
+func (c *Converter) action(name string) (crlfAction, error) {
+	values, err := c.attributes.Check(name, "text", "crlf", "eol")
+	if err != nil {
+		return crlfUndefined, err
+	}
+	action := textAction(values[0])
+	if action == crlfUndefined {
+		action = textAction(values[1])
+	}
+	if action != crlfBinary {
+		eol := values[2]
+		switch {
+		case action == crlfAuto && eol.Is("lf"):
+			action = crlfAutoInput
+		case action == crlfAuto && eol.Is("crlf"):
+			action = crlfAutoCRLF
+		case eol.Is("lf"):
+			action = crlfTextInput
+		case eol.Is("crlf"):
+			action = crlfTextCRLF
+		}
+	}
+	if action == crlfText {
+		if c.textEOLIsCRLF() {
+			return crlfTextCRLF, nil
+		}
+		return crlfTextInput, nil
+	}
+	if action == crlfUndefined {
+		switch c.autoCRLF {
+		case "true":
+			return crlfAutoCRLF, nil
+		case "input":
+			return crlfAutoInput, nil
+		default:
+			return crlfBinary, nil
+		}
+	}
+	return action, nil
+}
+
+// textAction returns crlfAction decided by text or legacy crlf attribute.
+func textAction(value Value) crlfAction {
+	switch {
+	case value.State == Set:
+		return crlfText
+	case value.State == Unset:
+		return crlfBinary
+	case value.Is("input"):
+		return crlfTextInput
+	case value.Is("auto"):
+		return crlfAuto
+	}
+	return crlfUndefined
+}
+
+// outputIsCRLF reports whether the action writes CRLF to the working tree.
+func (c *Converter) outputIsCRLF(action crlfAction) bool {
+	switch action {
+	case crlfTextCRLF, crlfAutoCRLF:
+		return true
+	case crlfText, crlfAuto:
+		return c.textEOLIsCRLF()
+	}
+	return false
+}
+
+func isAuto(action crlfAction) bool {
+	return action == crlfAuto || action == crlfAutoInput || action == crlfAutoCRLF
+}
+
+// ToGit converts content of the file in the working tree to content of a blob.
+// the clean filter is applied before line endings are converted.
+// path is relative from top level directory and separated by '/'.
+func (c *Converter) ToGit(name string, data []byte) ([]byte, error) {
+	data, _, err := c.applyFilter(name, data, "clean", false)
+	if err != nil {
+		return nil, err
+	}
+	return c.crlfToGit(name, data)
+}
+
+// ToWorktree converts content of a blob to content of the file in the working tree.
+// the smudge filter is applied after line endings are converted.
+// path is relative from top level directory and separated by '/'.
+func (c *Converter) ToWorktree(name string, data []byte) ([]byte, error) {
+	data, err := c.crlfToWorktree(name, data)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err = c.applyFilter(name, data, "smudge", false)
+	return data, err
+}
+
+// ToWorktreeDelayed is the same as ToWorktree, but the filter process can delay the content.
+// if the content is delayed, it returns true and the content is passed to FetchDelayed later.
+func (c *Converter) ToWorktreeDelayed(name string, data []byte) ([]byte, bool, error) {
+	data, err := c.crlfToWorktree(name, data)
+	if err != nil {
+		return nil, false, err
+	}
+	return c.applyFilter(name, data, "smudge", true)
+}
+
+// crlfToGit converts CRLF to LF if the path is text.
+// auto conversion keeps CRLF if the blob in the index has CRLF, so it isn't normalized silently.
+func (c *Converter) crlfToGit(name string, data []byte) ([]byte, error) {
+	action, err := c.action(name)
+	if err != nil {
+		return nil, err
+	}
+	if action == crlfBinary {
+		return data, nil
+	}
+	stats := gatherStats(data)
+	if isAuto(action) && stats.isBinary() {
+		return data, nil
+	}
+	if stats.crlf == 0 {
+		return data, nil
+	}
+	if isAuto(action) {
+		if crlf, err := c.hasCRLFInIndex(name); err != nil || crlf {
+			return data, err
+		}
+	}
+	return bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1), nil
+}
+
+// hasCRLFInIndex reports whether the blob of the path in the index is text having CRLF like git.
+func (c *Converter) hasCRLFInIndex(name string) (bool, error) {
+	if c.IndexBlob == nil {
+		return false, nil
+	}
+	data, err := c.IndexBlob(name)
+	if err != nil || bytes.IndexByte(data, '\r') < 0 {
+		return false, err
+	}
+	stats := gatherStats(data)
+	return !stats.isBinary() && stats.crlf > 0, nil
+}
+
+// crlfToWorktree converts LF to CRLF if the path is text and CRLF is expected in the working tree.
+func (c *Converter) crlfToWorktree(name string, data []byte) ([]byte, error) {
+	action, err := c.action(name)
+	if err != nil {
+		return nil, err
+	}
+	if !c.outputIsCRLF(action) {
+		return data, nil
+	}
+	stats := gatherStats(data)
+	if stats.lonelf == 0 {
+		return data, nil
+	}
+	if isAuto(action) {
+		// the file is not touched if it already has CR, or it's binary
+		if stats.lonecr > 0 || stats.crlf > 0 || stats.isBinary() {
+			return data, nil
+		}
+	}
+	converted := make([]byte, 0, len(data)+stats.lonelf)
+	for i, b := range data {
+		if b == '\n' && (i == 0 || data[i-1] != '\r') {
+			converted = append(converted, '\r')
+		}
+		converted = append(converted, b)
+	}
+	return converted, nil
+}
+
+// textStats is a type representing statistics of characters to detect text files.
+type textStats struct {
+	nul, lonecr, lonelf, crlf int
+	printable, nonprintable   int
+}
+
+func gatherStats(data []byte) *textStats {
+	stats := &textStats{}
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '\r':
+			if i+1 < len(data) && data[i+1] == '\n' {
+				stats.crlf++
+				i++
+			} else {
+				stats.lonecr++
+			}
+		case c == '\n':
+			stats.lonelf++
+		case c == 127:
+			stats.nonprintable++
+		case c < 32:
+			switch c {
+			case '\b', '\t', '\033', '\014':
+				stats.printable++
+			case 0:
+				stats.nul++
+				stats.nonprintable++
+			default:
+				stats.nonprintable++
+			}
+		default:
+			stats.printable++
+		}
+	}
+	// a file ending with EOF character(^Z) is not binary
+	if len(data) > 0 && data[len(data)-1] == '\032' {
+		stats.nonprintable--
+	}
+	return stats
+}
+
+// isBinary reports whether the file looks like a binary file same as git.
+func (s *textStats) isBinary() bool {
+	return s.lonecr > 0 || s.nul > 0 || (s.printable>>7) < s.nonprintable
+}
@@ -0,0 +1,11 @@
+package attributes
+
+import "errors"
+
+var (
+	ErrInvalidPath         = errors.New("path must be relative from top level directory")
+	ErrFilterFailed        = errors.New("filter failed to convert the content")
+	ErrFilterNotConfigured = errors.New("required filter has no command")
+	ErrFilterProtocol      = errors.New("filter process violates the protocol")
+	ErrDelayedNotAvailable = errors.New("filter process has delayed contents but none of them are available")
+)
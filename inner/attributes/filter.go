@@ -0,0 +1,341 @@
+package attributes
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/lfs"
+	"github.com/shumon84/mogit/inner/pktline"
+)
+
+// filterDriver is a type representing filter.<driver> configuration.
+type filterDriver struct {
+	name     string // name of the driver
+	clean    string // command to convert the file in the working tree to a blob
+	smudge   string // command to convert a blob to the file in the working tree
+	process  string // long running command which converts files with pkt-line protocol
+	required bool   // the conversion must succeed
+}
+
+// driver returns the filter driver of the path, or nil if there is no driver.
+func (c *Converter) driver(name string) (*filterDriver, error) {
+	values, err := c.attributes.Check(name, "filter")
+	if err != nil {
+		return nil, err
+	}
+	if values[0].State != Valued {
+		return nil, nil
+	}
+	prefix := "filter." + values[0].Text + "."
+	required, err := c.config.Bool(prefix+"required", false)
+	if err != nil {
+		return nil, err
+	}
+	return &filterDriver{
+		name:     values[0].Text,
+		clean:    c.config.GetString(prefix+"clean", ""),
+		smudge:   c.config.GetString(prefix+"smudge", ""),
+		process:  c.config.GetString(prefix+"process", ""),
+		required: required,
+	}, nil
+}
+
+// applyFilter converts data with the filter driver of the path.
+// command is "clean" or "smudge", content is delayed only if canDelay and the filter process supports it.
+// if the driver is not required, failures are ignored and data is returned as it is.
+func (c *Converter) applyFilter(name string, data []byte, command string, canDelay bool) ([]byte, bool, error) {
+	d, err := c.driver(name)
+	if err != nil || d == nil {
+		return data, false, err
+	}
+	if d.name == lfs.FilterName && d.clean == "" && d.smudge == "" && d.process == "" {
+		converted, err := c.applyLFS(data, command)
+		return converted, false, err
+	}
+	if d.process != "" {
+		converted, delayed, err := c.applyProcessFilter(d.process, name, data, command, canDelay)
+		if err != nil {
+			if d.required {
+				return nil, false, err
+			}
+			return data, false, nil
+		}
+		return converted, delayed, nil
+	}
+	shellCommand := d.clean
+	if command == "smudge" {
+		shellCommand = d.smudge
+	}
+	if shellCommand == "" {
+		if d.required {
+			return nil, false, ErrFilterNotConfigured
+		}
+		return data, false, nil
+	}
+	converted, err := c.runFilterCommand(shellCommand, name, data)
+	if err != nil {
+		if d.required {
+			return nil, false, err
+		}
+		return data, false, nil
+	}
+	return converted, false, nil
+}
+
+// applyLFS converts data between a pointer and the content in the local LFS store without git-lfs.
+func (c *Converter) applyLFS(data []byte, command string) ([]byte, error) {
+	if c.lfs == nil {
+		store, err := lfs.NewStore(c.gitDir)
+		if err != nil {
+			return nil, err
+		}
+		c.lfs = store
+	}
+	if command == "clean" {
+		return c.lfs.Clean(data)
+	}
+	return c.lfs.Smudge(data)
+}
+
+// runFilterCommand runs the command with data as stdin, and returns its stdout.
+// "%f" in the command is replaced with the quoted path.
+func (c *Converter) runFilterCommand(shellCommand, name string, data []byte) ([]byte, error) {
+	shellCommand = strings.NewReplacer("%%", "%", "%f", shellQuote(name)).Replace(shellCommand)
+	cmd := exec.Command("sh", "-c", shellCommand)
+	cmd.Dir = c.attributes.workDir
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, ErrFilterFailed
+	}
+	return out, nil
+}
+
+// shellQuote quotes s with single quotes for sh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func (c *Converter) applyProcessFilter(command, name string, data []byte, filterCommand string, canDelay bool) ([]byte, bool, error) {
+	p, ok := c.processes[command]
+	if !ok {
+		started, err := startFilterProcess(command, c.attributes.workDir)
+		if err != nil {
+			return nil, false, err
+		}
+		p = started
+		c.processes[command] = p
+	}
+	if !p.capabilities[filterCommand] {
+		return data, false, nil
+	}
+	return p.filter(filterCommand, name, data, canDelay)
+}
+
+// FetchDelayed waits for contents delayed by ToWorktreeDelayed, and calls fn with each of them.
+func (c *Converter) FetchDelayed(fn func(name string, data []byte) error) error {
+	for _, p := range c.processes {
+		for len(p.delayed) > 0 {
+			available, err := p.availableBlobs()
+			if err != nil {
+				return err
+			}
+			if len(available) == 0 {
+				return ErrDelayedNotAvailable
+			}
+			for _, name := range available {
+				if !p.delayed[name] {
+					return ErrFilterProtocol
+				}
+				delete(p.delayed, name)
+				data, _, err := p.filter("smudge", name, nil, false)
+				if err != nil {
+					return err
+				}
+				if err := fn(name, data); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Close stops filter processes which this Converter started.
+func (c *Converter) Close() error {
+	var firstErr error
+	for command, p := range c.processes {
+		if err := p.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.processes, command)
+	}
+	return firstErr
+}
+
+// filterProcess is a type representing a long running filter process.
+//
+//  git> git-filter-client, version=2, flush
+//  filter> git-filter-server, version=2, flush
+//  git> capability=clean, capability=smudge, capability=delay, flush
+//  filter> capability=<supported capabilities>..., flush
+//  git> command=<clean|smudge>, pathname=<path>, [can-delay=1], flush, <content>, flush
+//  filter> status=<success|delayed|error|abort>, flush, [<content>, flush, <status list>, flush]
+type filterProcess struct {
+	cmd          *exec.Cmd
+	stdin        io.WriteCloser
+	reader       *pktline.Reader
+	writer       *pktline.Writer
+	capabilities map[string]bool
+	delayed      map[string]bool // paths whose contents are delayed
+}
+
+func startFilterProcess(command, dir string) (*filterProcess, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &filterProcess{
+		cmd:          cmd,
+		stdin:        stdin,
+		reader:       pktline.NewReader(stdout),
+		writer:       pktline.NewWriter(stdin),
+		capabilities: map[string]bool{},
+		delayed:      map[string]bool{},
+	}
+	if err := p.handshake(); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *filterProcess) handshake() error {
+	if err := p.writer.WriteLines("git-filter-client", "version=2"); err != nil {
+		return err
+	}
+	lines, err := p.reader.ReadLines()
+	if err != nil {
+		return err
+	}
+	if len(lines) != 2 || lines[0] != "git-filter-server" || lines[1] != "version=2" {
+		return ErrFilterProtocol
+	}
+	if err := p.writer.WriteLines("capability=clean", "capability=smudge", "capability=delay"); err != nil {
+		return err
+	}
+	lines, err = p.reader.ReadLines()
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "capability=") {
+			return ErrFilterProtocol
+		}
+		p.capabilities[strings.TrimPrefix(line, "capability=")] = true
+	}
+	return nil
+}
+
+// filter sends a request to the process and reads the response.
+func (p *filterProcess) filter(command, name string, data []byte, canDelay bool) ([]byte, bool, error) {
+	request := []string{"command=" + command, "pathname=" + name}
+	if canDelay && p.capabilities["delay"] {
+		request = append(request, "can-delay=1")
+	}
+	if err := p.writer.WriteLines(request...); err != nil {
+		return nil, false, err
+	}
+	if err := p.writer.WriteData(data); err != nil {
+		return nil, false, err
+	}
+	status, err := p.readStatus("")
+	if err != nil {
+		return nil, false, err
+	}
+	switch status {
+	case "success":
+	case "delayed":
+		if !canDelay {
+			return nil, false, ErrFilterProtocol
+		}
+		p.delayed[name] = true
+		return nil, true, nil
+	case "abort":
+		p.capabilities[command] = false
+		return nil, false, ErrFilterFailed
+	default:
+		return nil, false, ErrFilterFailed
+	}
+	converted, err := p.reader.ReadData()
+	if err != nil {
+		return nil, false, err
+	}
+	// empty status list means that status is not changed
+	if status, err = p.readStatus(status); err != nil {
+		return nil, false, err
+	}
+	if status != "success" {
+		return nil, false, ErrFilterFailed
+	}
+	return converted, false, nil
+}
+
+// availableBlobs asks the process which delayed contents are available.
+func (p *filterProcess) availableBlobs() ([]string, error) {
+	if err := p.writer.WriteLines("command=list_available_blobs"); err != nil {
+		return nil, err
+	}
+	lines, err := p.reader.ReadLines()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "pathname=") {
+			names = append(names, strings.TrimPrefix(line, "pathname="))
+		}
+	}
+	status, err := p.readStatus("")
+	if err != nil {
+		return nil, err
+	}
+	if status != "success" {
+		return nil, ErrFilterFailed
+	}
+	return names, nil
+}
+
+// readStatus reads a status list, it returns current if the list has no status.
+func (p *filterProcess) readStatus(current string) (string, error) {
+	lines, err := p.reader.ReadLines()
+	if err != nil {
+		return "", err
+	}
+	status := current
+	for _, line := range lines {
+		if strings.HasPrefix(line, "status=") {
+			status = strings.TrimPrefix(line, "status=")
+		}
+	}
+	return status, nil
+}
+
+func (p *filterProcess) close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
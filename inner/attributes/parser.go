@@ -0,0 +1,116 @@
+package attributes
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/ignore"
+)
+
+// line is a type representing one line of attributes files.
+type line struct {
+	pattern     *ignore.Pattern // nil if the line defines a macro
+	macro       string          // name of the macro defined by the line
+	assignments []*assignment
+}
+
+// assignment is a type representing one attribute in a line.
+type assignment struct {
+	name  string
+	value Value
+}
+
+// parseLines parses content of attributes files.
+// blank lines, comment lines, negative patterns and invalid macro definitions are skipped.
+func parseLines(data []byte, base string, allowMacro bool) []*line {
+	lines := []*line{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		text := strings.TrimLeft(scanner.Text(), " \t\r")
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		pattern, rest := splitPattern(text)
+		l := &line{assignments: parseAssignments(rest)}
+		if strings.HasPrefix(pattern, "[attr]") {
+			l.macro = pattern[len("[attr]"):]
+			if !allowMacro || !isValidName(l.macro) {
+				continue
+			}
+		} else {
+			l.pattern = ignore.ParsePattern(pattern, base)
+			if l.pattern == nil || l.pattern.Negative {
+				continue
+			}
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// splitPattern splits a line to the pattern and the rest, the pattern may be quoted.
+func splitPattern(text string) (string, string) {
+	if text[0] == '"' {
+		for i := 1; i < len(text); i++ {
+			if text[i] == '\\' {
+				i++
+				continue
+			}
+			if text[i] == '"' {
+				if pattern, err := strconv.Unquote(text[:i+1]); err == nil {
+					return pattern, text[i+1:]
+				}
+				break
+			}
+		}
+	}
+	end := strings.IndexAny(text, " \t\r")
+	if end < 0 {
+		return text, ""
+	}
+	return text[:end], text[end:]
+}
+
+func parseAssignments(text string) []*assignment {
+	assignments := []*assignment{}
+	for _, field := range strings.Fields(text) {
+		a := &assignment{value: Value{State: Set}}
+		switch field[0] {
+		case '-':
+			a.value.State = Unset
+			field = field[1:]
+		case '!':
+			a.value.State = Unspecified
+			field = field[1:]
+		default:
+			if eq := strings.IndexByte(field, '='); eq >= 0 {
+				a.value = Value{State: Valued, Text: field[eq+1:]}
+				field = field[:eq]
+			}
+		}
+		if !isValidName(field) {
+			continue
+		}
+		a.name = field
+		assignments = append(assignments, a)
+	}
+	return assignments
+}
+
+// isValidName reports whether name consists of [-._0-9A-Za-z] and doesn't start with '-'.
+func isValidName(name string) bool {
+	if name == "" || name[0] == '-' {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '.', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,250 @@
+// checkout is a package to check out branches, commits and trees like $ git checkout and $ git switch
+//
+// Checking out moves the index and the working tree from the tree of HEAD to another tree with the minimal set of
+// file writes and deletes. paths which are the same in both trees are not touched, so local changes of them are
+// carried to the next tree. the other paths are updated only if they have no local changes, and untracked files
+// are never overwritten unless Force like the two-way merge of git.
+//
+//  HEAD   index    next    result
+//  ------------------------------------------------------------------
+//  A      any      A       the entry and the file are kept
+//  A      B        B       the staged change is kept
+//  A      A        B       the file is updated to B if it is clean, or ErrLocalChanges
+//  none   none     B       the file is created if no untracked file is lost, or ErrUntrackedFiles
+//  A      A        none    the file is removed if it is clean, or ErrLocalChanges
+//  A      C        B       ErrLocalChanges
+//
+// Files are converted by filters and line ending attributes when they are written, and entries of written files
+// have fresh stat information, so status after checkout doesn't need to hash them.
+//
+// Files out of Options.Sparse aren't written, and their entries have skip-worktree flag like sparse checkout of git.
+// Sparsity applies another Options.Sparse to the current index and working tree.
+//
+// Many files are written by a bounded pool of workers like parallel checkout of git, it's configured by
+// checkout.workers and checkout.thresholdForParallelism. paths which differ only in case are written by one worker,
+// and they are reported as collisions if they are the same file like on a case-insensitive filesystem.
+//
+// If you want to know more about checkout, please refer to
+// https://git-scm.com/docs/git-checkout
+// https://git-scm.com/docs/git-switch
+// https://git-scm.com/docs/git-read-tree#_two_tree_merge
+package checkout
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/commit"
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/refs"
+	"github.com/shumon84/mogit/inner/revparse"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// Options is a type representing options of checking out.
+type Options struct {
+	Force  bool                   // discard local changes and overwrite untracked files like --force
+	Detach bool                   // detach HEAD at the commit even if the name is a branch like --detach, only for Switch
+	Sparse func(name string) bool // whether the path is written to the working tree, nil means all paths
+
+	// Workers is the number of goroutines writing files, 0 means checkout.workers and negative means the number
+	// of CPUs. files are written in parallel only if they are at least checkout.thresholdForParallelism.
+	Workers int
+}
+
+// includes reports whether the file of the path is written to the working tree.
+func (o *Options) includes(name string) bool {
+	return o.Sparse == nil || o.Sparse(name)
+}
+
+// Result is a type representing what the checkout did.
+type Result struct {
+	Written    []string // paths of written files in name order
+	Removed    []string // paths of removed files in name order
+	Collisions []string // paths which are written to the same file, e.g. "A" and "a" on a case-insensitive filesystem
+	Messages   []string // messages like "Switched to branch 'main'"
+}
+
+// Checkout is a type to check out trees into the index and the working tree of the repository.
+type Checkout struct {
+	gitDir   string
+	workDir  string
+	config   *config.Config
+	resolver *revparse.Resolver
+
+	// Committer is written to the reflog entry of HEAD when switching, the environment and config decide it if nil.
+	Committer *object.Signature
+}
+
+// NewCheckout creates a Checkout of the repository.
+// gitDir of parameters must be path to .git directory.
+func NewCheckout(gitDir string) (*Checkout, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := revparse.NewResolver(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkout{
+		gitDir:   gitDir,
+		workDir:  filepath.Dir(gitDir),
+		config:   cfg,
+		resolver: resolver,
+	}, nil
+}
+
+// OpenCheckout creates a Checkout of current repository.
+func OpenCheckout() (*Checkout, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewCheckout(gitDir)
+}
+
+// head returns the digest of the commit which HEAD points to and the commit, they are nil if HEAD is unborn.
+func (c *Checkout) head() ([]byte, *object.Commit, error) {
+	ref, err := c.resolver.Refs().Resolve(refs.HEAD)
+	if err == refs.ErrRefNotFound {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	commit, err := c.readCommit(ref.Digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ref.Digest, commit, nil
+}
+
+func (c *Checkout) readCommit(digest []byte) (*object.Commit, error) {
+	obj, err := object.ReadObjectFrom(c.gitDir, digest)
+	if err != nil {
+		return nil, err
+	}
+	commit, ok := obj.(*object.Commit)
+	if !ok {
+		return nil, object.ErrUnexpectedType
+	}
+	return commit, nil
+}
+
+// Tree checks out the tree into the index and the working tree without moving HEAD
+// like $ git read-tree -m -u HEAD <tree-ish>. tree is SHA1 digest of a tree, a commit or a tag pointing to them.
+// options may be nil.
+func (c *Checkout) Tree(tree []byte, options *Options) (*Result, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	to, err := c.resolver.Peel(tree, object.TreeObject)
+	if err != nil {
+		return nil, ErrNotTree
+	}
+	_, headCommit, err := c.head()
+	if err != nil {
+		return nil, err
+	}
+	var from []byte
+	if headCommit != nil {
+		from = headCommit.Tree
+	}
+	return c.run(from, to, options)
+}
+
+// Switch checks out the branch or the commit, and moves HEAD to it like $ git checkout <branch> and
+// $ git checkout --detach <commit>. HEAD points to the branch if name is a branch, and it's detached at the
+// commit otherwise. options may be nil.
+func (c *Checkout) Switch(name string, options *Options) (*Result, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	branch := ""
+	if !options.Detach && refs.IsValidName(refs.HeadPrefix+name) {
+		if _, err := c.resolver.Refs().Read(refs.HeadPrefix + name); err == nil {
+			branch = refs.HeadPrefix + name
+		} else if err != refs.ErrRefNotFound {
+			return nil, err
+		}
+	}
+	var target []byte
+	if branch != "" {
+		ref, err := c.resolver.Refs().Resolve(branch)
+		if err != nil {
+			return nil, err
+		}
+		target = ref.Digest
+	} else {
+		var err error
+		if target, err = c.resolver.ResolveCommit(name); err != nil {
+			return nil, err
+		}
+	}
+	targetCommit, err := c.readCommit(target)
+	if err != nil {
+		return nil, err
+	}
+
+	headRef, err := c.resolver.Refs().Read(refs.HEAD)
+	if err != nil {
+		return nil, err
+	}
+	head, headCommit, err := c.head()
+	if err != nil {
+		return nil, err
+	}
+	var from []byte
+	if headCommit != nil {
+		from = headCommit.Tree
+	}
+	result, err := c.run(from, targetCommit.Tree, options)
+	if err != nil {
+		return nil, err
+	}
+
+	committer, err := commit.ResolveCommitter(c.config, c.Committer)
+	if err != nil {
+		return nil, err
+	}
+	previous := hex.EncodeToString(head)
+	if headRef.IsSymbolic() {
+		previous = strings.TrimPrefix(headRef.Target, refs.HeadPrefix)
+	}
+	log := &refs.LogMessage{Committer: committer, Message: "checkout: moving from " + previous + " to " + name}
+	if branch != "" {
+		if err := c.resolver.Refs().UpdateSymbolic(refs.HEAD, branch, log); err != nil {
+			return nil, err
+		}
+		if headRef.Target == branch {
+			result.Messages = append(result.Messages, "Already on '"+name+"'")
+		} else {
+			result.Messages = append(result.Messages, "Switched to branch '"+name+"'")
+		}
+		return result, nil
+	}
+	if err := c.resolver.Refs().Detach(refs.HEAD, target, nil, log); err != nil {
+		return nil, err
+	}
+	result.Messages = append(result.Messages, "HEAD is now at "+c.abbrev(target)+" "+targetCommit.Summary())
+	return result, nil
+}
+
+// abbrev returns the shortest unique prefix of the digest at least 7 characters.
+func (c *Checkout) abbrev(digest []byte) string {
+	name := hex.EncodeToString(digest)
+	for n := 7; n < len(name); n++ {
+		if candidates, err := object.FindObjects(c.gitDir, name[:n]); err == nil && len(candidates) <= 1 {
+			return name[:n]
+		}
+	}
+	return name
+}
@@ -0,0 +1,24 @@
+package checkout
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrLocalChanges   = errors.New("your local changes to the following files would be overwritten by checkout")
+	ErrUntrackedFiles = errors.New("the following untracked working tree files would be overwritten by checkout")
+	ErrUnmergedPaths  = errors.New("you need to resolve your current index first")
+	ErrNotTree        = errors.New("reference is not a tree")
+)
+
+// PathError is an error about paths in the working tree, it tells which paths cause the error.
+type PathError struct {
+	Paths []string // paths in the working tree
+	Err   error    // ErrLocalChanges, ErrUntrackedFiles, ErrUnmergedPaths or the error writing the file
+}
+
+// Error is implementation of error interface
+func (e *PathError) Error() string {
+	return e.Err.Error() + ": " + strings.Join(e.Paths, ", ")
+}
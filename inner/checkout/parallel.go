@@ -0,0 +1,196 @@
+package checkout
+
+import (
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/worktree"
+)
+
+// defaults of checkout.workers and checkout.thresholdForParallelism like git
+const (
+	defaultWorkers   = 1
+	defaultThreshold = 100
+)
+
+// collisionWarning is the message reporting paths which collided in the working tree.
+const collisionWarning = "warning: the following paths have collided (e.g. case-sensitive paths\n" +
+	"on a case-insensitive filesystem) and only one from the same\n" +
+	"colliding group is in the working tree:\n"
+
+// collisionMessage returns the warning message of the paths which collided.
+func collisionMessage(collisions []string) string {
+	message := collisionWarning
+	for _, name := range collisions {
+		message += "\n  '" + name + "'"
+	}
+	return message
+}
+
+// parallelism returns the number of workers and the least number of files checked out in parallel.
+// workers is taken from Options.Workers or checkout.workers, and less than 1 means the number of CPUs.
+// files are written sequentially if there is only one worker or the files are fewer than the threshold.
+func (c *Checkout) parallelism(options *Options) (int, int, error) {
+	workers := options.Workers
+	if workers == 0 {
+		var err error
+		if workers, err = c.config.Int("checkout.workers", defaultWorkers); err != nil {
+			return 0, 0, err
+		}
+	}
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	threshold, err := c.config.Int("checkout.thresholdForParallelism", defaultThreshold)
+	if err != nil {
+		return 0, 0, err
+	}
+	return workers, threshold, nil
+}
+
+// writer is a type to write many files by a bounded pool of workers like parallel checkout of git.
+// each worker has its own worktree because filter processes of a converter can't be shared.
+type writer struct {
+	w         *worktree.Worktree
+	workers   int
+	threshold int
+}
+
+// writeFiles writes the files and returns their stat information in the same order.
+// leading directories are created beforehand, so workers only write files.
+// if some files fail, PathError of the first one in the order is returned like checking out sequentially.
+// paths which are the same ignoring case are written by one goroutine in the order, and paths which turned out
+// to be the same file are returned as collisions.
+func (wr *writer) writeFiles(files []*object.TreeEntry) ([]os.FileInfo, []string, error) {
+	for _, file := range files {
+		if err := wr.w.MakeDirs(file.Name); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	groups := map[string][]int{}
+	for i, file := range files {
+		folded := strings.ToLower(file.Name)
+		groups[folded] = append(groups[folded], i)
+	}
+	independent := make([]int, 0, len(files))
+	colliding := [][]int{}
+	for i, file := range files {
+		group := groups[strings.ToLower(file.Name)]
+		switch {
+		case len(group) == 1:
+			independent = append(independent, i)
+		case group[0] == i:
+			colliding = append(colliding, group)
+		}
+	}
+
+	infos := make([]os.FileInfo, len(files))
+	errs := make([]error, len(files))
+	if wr.workers <= 1 || len(independent) < wr.threshold {
+		for _, i := range independent {
+			if infos[i], errs[i] = wr.w.Write(files[i]); errs[i] != nil {
+				break
+			}
+		}
+	} else if err := wr.parallel(files, independent, infos, errs); err != nil {
+		return nil, nil, err
+	}
+	for i, err := range errs {
+		if err != nil {
+			return nil, nil, &PathError{Paths: []string{files[i].Name}, Err: err}
+		}
+	}
+
+	collisions := []string{}
+	for _, group := range colliding {
+		for _, i := range group {
+			var err error
+			if infos[i], err = wr.w.Write(files[i]); err != nil {
+				return nil, nil, &PathError{Paths: []string{files[i].Name}, Err: err}
+			}
+		}
+		collisions = append(collisions, wr.collided(files, group)...)
+	}
+	sort.Strings(collisions)
+	return infos, collisions, nil
+}
+
+// parallel writes the files of the indexes by the workers, and stores results to infos and errs.
+// no more files are dispatched after a failure, but files before it are written, so the first error in the
+// order is the same as writing sequentially.
+func (wr *writer) parallel(files []*object.TreeEntry, indexes []int, infos []os.FileInfo, errs []error) error {
+	workers := wr.workers
+	if workers > len(indexes) {
+		workers = len(indexes)
+	}
+	pool := make([]*worktree.Worktree, workers)
+	for n := range pool {
+		w, err := wr.w.Clone()
+		if err != nil {
+			for _, w := range pool[:n] {
+				w.Close()
+			}
+			return err
+		}
+		pool[n] = w
+	}
+
+	var failed int32
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+	for _, w := range pool {
+		wg.Add(1)
+		go func(w *worktree.Worktree) {
+			defer wg.Done()
+			for i := range jobs {
+				if infos[i], errs[i] = w.Write(files[i]); errs[i] != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}(w)
+	}
+	for _, i := range indexes {
+		if atomic.LoadInt32(&failed) != 0 {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var closeErr error
+	for _, w := range pool {
+		if err := w.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return closeErr
+}
+
+// collided returns paths of the group if some of them are the same file in the working tree.
+func (wr *writer) collided(files []*object.TreeEntry, group []int) []string {
+	infos := make([]os.FileInfo, 0, len(group))
+	for _, i := range group {
+		info, err := os.Lstat(wr.w.Path(files[i].Name))
+		if err != nil {
+			continue
+		}
+		for _, other := range infos {
+			if os.SameFile(info, other) {
+				paths := make([]string, len(group))
+				for n, i := range group {
+					paths[n] = files[i].Name
+				}
+				return paths
+			}
+		}
+		infos = append(infos, info)
+	}
+	return nil
+}
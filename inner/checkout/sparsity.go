@@ -0,0 +1,162 @@
+package checkout
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/worktree"
+)
+
+// warnings of paths which are left despite sparse patterns like git.
+const (
+	notUpToDateWarning = "warning: The following paths are not up to date and were left despite sparse patterns:"
+	unmergedWarning    = "warning: The following paths are unmerged and were left despite sparse patterns:"
+	presentWarning     = "warning: The following paths were already present and thus not updated despite sparse patterns:"
+	reapplyAdvice      = "After fixing the above paths, you may want to run `git sparse-checkout reapply`."
+)
+
+// Sparsity applies options.Sparse to the index and the working tree without changing entries like
+// $ git sparse-checkout reapply. entries of paths out of the patterns get skip-worktree flag and their files are
+// removed, and entries of paths in the patterns lose the flag and their files are written.
+//
+// files which have local changes, unmerged paths and untracked files in the way are left with warnings in
+// Result.Messages, so no changes are lost. options may be nil, and only Sparse and Workers are used.
+func (c *Checkout) Sparsity(options *Options) (*Result, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	idx, indexTime, err := worktree.ReadIndex(c.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	current, err := worktree.ReadEntries(idx)
+	if err != nil {
+		return nil, err
+	}
+	w, err := worktree.NewWorktree(c.gitDir, c.workDir, c.config, current, indexTime)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	result := &Result{Written: []string{}, Removed: []string{}}
+	entries := make([]*index.Entry, 0, len(current))
+	files := []*object.TreeEntry{}
+	notUpToDate, unmerged, present := []string{}, []string{}, []string{}
+	for _, entry := range current {
+		included := options.includes(entry.Name)
+		switch {
+		case entry.ConflictFlag != index.NoConflict:
+			if !included && (len(unmerged) == 0 || unmerged[len(unmerged)-1] != entry.Name) {
+				unmerged = append(unmerged, entry.Name)
+			}
+		case included && entry.SkipWorktree:
+			info, err := os.Lstat(w.Path(entry.Name))
+			if os.IsNotExist(err) || worktree.IsNotDir(err) {
+				files = append(files, &object.TreeEntry{Mode: worktree.IndexMode(entry), Name: entry.Name, Digest: entry.Digest})
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			// a file having the same contents is taken as it is
+			if same, err := isSameFile(w, entry, info); err != nil {
+				return nil, err
+			} else if same {
+				next, err := worktree.NewEntry(&object.TreeEntry{Mode: worktree.IndexMode(entry), Name: entry.Name, Digest: entry.Digest}, info)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, next)
+				continue
+			}
+			present = append(present, entry.Name)
+		case !included && !entry.SkipWorktree && !entry.IntentToAdd:
+			clean, err := w.IsClean(entry)
+			if err != nil {
+				return nil, err
+			}
+			if !clean {
+				notUpToDate = append(notUpToDate, entry.Name)
+				break
+			}
+			skipped := *entry
+			skipped.SkipWorktree = true
+			entries = append(entries, &skipped)
+			result.Removed = append(result.Removed, entry.Name)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	for i := len(result.Removed) - 1; i >= 0; i-- {
+		if err := w.Remove(result.Removed[i]); err != nil {
+			return nil, err
+		}
+	}
+	workers, threshold, err := c.parallelism(options)
+	if err != nil {
+		return nil, err
+	}
+	wr := &writer{w: w, workers: workers, threshold: threshold}
+	infos, collisions, err := wr.writeFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	for i, file := range files {
+		entry, err := worktree.NewEntry(file, infos[i])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		result.Written = append(result.Written, file.Name)
+	}
+	if err := index.WriteIndex(c.gitDir, index.NewIndexWithResolveUndo(entries, idx.ResolveUndo())); err != nil {
+		return nil, err
+	}
+
+	result.Collisions = collisions
+	if len(collisions) > 0 {
+		result.Messages = append(result.Messages, collisionMessage(collisions))
+	}
+	for _, warning := range []struct {
+		message string
+		paths   []string
+	}{
+		{notUpToDateWarning, notUpToDate},
+		{unmergedWarning, unmerged},
+		{presentWarning, present},
+	} {
+		if len(warning.paths) == 0 {
+			continue
+		}
+		message := warning.message
+		for _, name := range warning.paths {
+			message += "\n\t" + name
+		}
+		result.Messages = append(result.Messages, message)
+	}
+	if len(notUpToDate)+len(unmerged)+len(present) > 0 {
+		result.Messages = append(result.Messages, reapplyAdvice)
+	}
+	return result, nil
+}
+
+// isSameFile reports whether the file in the working tree has the same type and contents as the entry.
+// the directory of a git link is always the same.
+func isSameFile(w *worktree.Worktree, entry *index.Entry, info os.FileInfo) (bool, error) {
+	if entry.ObjectType == index.GitLink {
+		return info.IsDir(), nil
+	}
+	isLink := info.Mode()&os.ModeSymlink != 0
+	if !isLink && !info.Mode().IsRegular() || isLink != (entry.ObjectType == index.SymbolicLink) {
+		return false, nil
+	}
+	digest, err := w.HashFile(entry.Name, info)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(digest, entry.Digest), nil
+}
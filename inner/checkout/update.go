@@ -0,0 +1,251 @@
+package checkout
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/shumon84/mogit/inner/ignore"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/worktree"
+)
+
+// update is a type representing changes of the working tree and the index from a tree to another tree.
+type update struct {
+	files   map[string]*object.TreeEntry // files of the next tree by path
+	kept    map[string]*index.Entry      // entries of the current index kept in the next index by path
+	written []string                     // paths whose files are written in name order
+	skipped []string                     // paths whose entries are updated without writing files in name order
+	removed []string                     // paths whose files are removed in name order
+}
+
+// sameEntry reports whether the index entry has the same mode and digest as the tree entry, nil equals only nil.
+func sameEntry(entry *index.Entry, file *object.TreeEntry) bool {
+	if entry == nil || file == nil {
+		return entry == nil && file == nil
+	}
+	return worktree.IndexMode(entry) == file.Mode && bytes.Equal(entry.Digest, file.Digest)
+}
+
+// sameFile reports whether the tree entries have the same mode and digest, nil equals only nil.
+func sameFile(a, b *object.TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Mode == b.Mode && bytes.Equal(a.Digest, b.Digest)
+}
+
+// twoWay computes changes from the tree "from" to the tree "to" like two-way merge of $ git read-tree -m -u.
+// paths which are the same in both trees keep their entries and files, so staged and unstaged changes are carried.
+// paths which differ are updated if their entries match "from" and their files are clean, and the others cause
+// ErrLocalChanges. untracked files in the way cause ErrUntrackedFiles.
+// with force, the index and the working tree are reset to "to", and local changes and untracked files are lost.
+func (c *Checkout) twoWay(current []*index.Entry, from, to map[string]*object.TreeEntry, w *worktree.Worktree, options *Options) (*update, error) {
+	tracked := map[string]*index.Entry{}
+	unmerged := map[string]bool{}
+	conflicts := []string{}
+	for _, entry := range current {
+		if entry.ConflictFlag == index.NoConflict {
+			tracked[entry.Name] = entry
+		} else if !unmerged[entry.Name] {
+			unmerged[entry.Name] = true
+			conflicts = append(conflicts, entry.Name)
+		}
+	}
+	if len(conflicts) > 0 && !options.Force {
+		return nil, &PathError{Paths: conflicts, Err: ErrUnmergedPaths}
+	}
+
+	names := make([]string, 0, len(to)+len(current))
+	seen := map[string]bool{}
+	for _, files := range []map[string]*object.TreeEntry{from, to} {
+		for name := range files {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	for _, entry := range current {
+		if !seen[entry.Name] {
+			seen[entry.Name] = true
+			names = append(names, entry.Name)
+		}
+	}
+	sort.Strings(names)
+
+	matcher, err := ignore.NewMatcher(c.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	u := &update{files: to, kept: map[string]*index.Entry{}}
+	local, untracked := []string{}, []string{}
+	for _, name := range names {
+		entry, old, file := tracked[name], from[name], to[name]
+		if options.Force {
+			switch {
+			case file == nil:
+				if entry != nil || unmerged[name] {
+					u.removed = append(u.removed, name)
+				}
+			case sameEntry(entry, file):
+				clean, err := w.IsClean(entry)
+				if err != nil {
+					return nil, err
+				}
+				if clean {
+					u.kept[name] = entry
+				} else {
+					u.written = append(u.written, name)
+				}
+			default:
+				u.written = append(u.written, name)
+			}
+			continue
+		}
+
+		switch {
+		case sameFile(old, file):
+			// the path isn't changed by the checkout, so the entry and the file are carried as they are
+			if entry != nil {
+				u.kept[name] = entry
+			}
+		case sameEntry(entry, file):
+			// the change is already staged
+			if entry != nil {
+				u.kept[name] = entry
+			}
+		case sameEntry(entry, old):
+			if entry != nil {
+				clean, err := w.IsClean(entry)
+				if err != nil {
+					return nil, err
+				}
+				if !clean {
+					local = append(local, name)
+					continue
+				}
+			} else if options.includes(name) {
+				ok, err := w.CanCreate(file, tracked, matcher.IsIgnored)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					untracked = append(untracked, name)
+					continue
+				}
+			}
+			if file == nil {
+				u.removed = append(u.removed, name)
+			} else {
+				u.written = append(u.written, name)
+			}
+		default:
+			local = append(local, name)
+		}
+	}
+	if len(local) > 0 {
+		return nil, &PathError{Paths: local, Err: ErrLocalChanges}
+	}
+	if len(untracked) > 0 {
+		return nil, &PathError{Paths: untracked, Err: ErrUntrackedFiles}
+	}
+
+	// files out of the sparse patterns aren't written
+	if options.Sparse != nil {
+		written := make([]string, 0, len(u.written))
+		for _, name := range u.written {
+			if options.includes(name) {
+				written = append(written, name)
+			} else {
+				u.skipped = append(u.skipped, name)
+			}
+		}
+		u.written = written
+	}
+	return u, nil
+}
+
+// apply removes and writes files of the update, and returns the next index and paths which collided.
+// entries of written files are made in one pass after all files are written, and they have fresh
+// stat information, so the next status doesn't need to hash them. entries of skipped files have skip-worktree flag.
+func (u *update) apply(wr *writer, resolveUndo []*index.ResolveUndo) (index.Index, []string, error) {
+	for i := len(u.removed) - 1; i >= 0; i-- {
+		if err := wr.w.Remove(u.removed[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	files := make([]*object.TreeEntry, len(u.written))
+	for i, name := range u.written {
+		files[i] = u.files[name]
+	}
+	infos, collisions, err := wr.writeFiles(files)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]*index.Entry, 0, len(u.kept)+len(u.written)+len(u.skipped))
+	for _, entry := range u.kept {
+		entries = append(entries, entry)
+	}
+	for i, file := range files {
+		entry, err := worktree.NewEntry(file, infos[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, entry)
+	}
+	for _, name := range u.skipped {
+		file := u.files[name]
+		entry := &index.Entry{Name: name, Digest: file.Digest, SkipWorktree: true}
+		worktree.SetMode(entry, file.Mode)
+		entries = append(entries, entry)
+	}
+	return index.NewIndexWithResolveUndo(entries, resolveUndo), collisions, nil
+}
+
+// run computes the update from the tree "from" to the tree "to", applies it and writes the next index.
+func (c *Checkout) run(from, to []byte, options *Options) (*Result, error) {
+	idx, indexTime, err := worktree.ReadIndex(c.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	current, err := worktree.ReadEntries(idx)
+	if err != nil {
+		return nil, err
+	}
+	fromFiles, err := worktree.TreeFiles(c.gitDir, from)
+	if err != nil {
+		return nil, err
+	}
+	toFiles, err := worktree.TreeFiles(c.gitDir, to)
+	if err != nil {
+		return nil, err
+	}
+	w, err := worktree.NewWorktree(c.gitDir, c.workDir, c.config, current, indexTime)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	u, err := c.twoWay(current, fromFiles, toFiles, w, options)
+	if err != nil {
+		return nil, err
+	}
+	workers, threshold, err := c.parallelism(options)
+	if err != nil {
+		return nil, err
+	}
+	next, collisions, err := u.apply(&writer{w: w, workers: workers, threshold: threshold}, idx.ResolveUndo())
+	if err != nil {
+		return nil, err
+	}
+	if err := index.WriteIndex(c.gitDir, next); err != nil {
+		return nil, err
+	}
+	result := &Result{Written: u.written, Removed: u.removed, Collisions: collisions}
+	if len(collisions) > 0 {
+		result.Messages = append(result.Messages, collisionMessage(collisions))
+	}
+	return result, nil
+}
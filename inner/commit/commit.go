@@ -0,0 +1,420 @@
+// commit is a package to create commits from the index like $ git commit
+//
+// The index is written as tree objects, and a commit object of the root tree is created on HEAD. parents of
+// the commit are HEAD and commits in MERGE_HEAD when a merge is concluded, and a commit without HEAD becomes
+// a root commit. HEAD, or the branch which HEAD points to, moves to the new commit with a reflog entry like
+// "commit: <subject>".
+//
+// The message is taken from Options.Message, MERGE_MSG or SQUASH_MSG, and the message of HEAD with Amend.
+// Signed-off-by and other trailers are appended, and the message is cleaned up by the cleanup mode.
+//
+//  mode        comment lines  trailing spaces and blank lines
+//  ----------------------------------------------------------
+//  strip       removed        removed
+//  whitespace  kept           removed
+//  verbatim    kept           kept
+//
+// The author and the committer are taken from GIT_AUTHOR_*, GIT_COMMITTER_* environment variables and config
+// like git. the author of HEAD is kept with Amend, and the author of CHERRY_PICK_HEAD is used when a conflicting
+// cherry-pick is concluded. MERGE_HEAD, MERGE_MSG and other files of the concluded operation are removed, and
+// resolutions are recorded by rerere if it's enabled.
+//
+// Cleaning up messages, appending trailers, resolving identities and writing trees are exported for other
+// packages creating commits, like cherry-pick, revert and rebase of sequencer.
+//
+// If you want to know more about commit, please refer to
+// https://git-scm.com/docs/git-commit
+// https://git-scm.com/docs/git-interpret-trailers
+package commit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/refs"
+	"github.com/shumon84/mogit/inner/rerere"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// constants of names of files in .git directory
+const (
+	CherryPickHead = "CHERRY_PICK_HEAD"
+	RevertHead     = "REVERT_HEAD"
+	CommitEditMsg  = "COMMIT_EDITMSG"
+	MergeMsg       = "MERGE_MSG"
+	MergeMode      = "MERGE_MODE"
+	SquashMsg      = "SQUASH_MSG"
+	AutoMerge      = "AUTO_MERGE"
+)
+
+// Options is a type representing options of creating a commit.
+type Options struct {
+	Message           string            // the message, MERGE_MSG, SQUASH_MSG or the message of HEAD with Amend if it's ""
+	Cleanup           CleanupMode       // how the message is cleaned up like --cleanup, "" means commit.cleanup config
+	Amend             bool              // replace HEAD with the new commit having the parents of HEAD like --amend
+	AllowEmpty        bool              // allow the commit having the same tree as the parent like --allow-empty
+	AllowEmptyMessage bool              // allow the empty message like --allow-empty-message
+	Signoff           bool              // append Signed-off-by trailer of the committer like --signoff
+	Trailers          []string          // trailers like "Reviewed-by: <name>" or "Reviewed-by=<name>" like --trailer
+	Author            *object.Signature // the author like --author and --date, nil means GIT_AUTHOR_* and config
+	ResetAuthor       bool              // take the author from GIT_AUTHOR_* and config even with Amend like --reset-author
+}
+
+// Result is a type representing the created commit.
+type Result struct {
+	Digest   []byte         // SHA1 digest of the commit
+	Commit   *object.Commit // the commit
+	Messages []string       // messages like "[main (root-commit) 1234567] subject"
+}
+
+// Commit is a type to create commits in the repository.
+type Commit struct {
+	gitDir string
+	config *config.Config
+	refs   refs.Store
+
+	// Committer is who records new commits and entries of reflogs, nil means ResolveCommitter finds it.
+	Committer *object.Signature
+}
+
+// NewCommit creates a Commit of the repository.
+// gitDir of parameters must be path to .git directory.
+func NewCommit(gitDir string) (*Commit, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	store, err := refs.NewStore(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Commit{gitDir: gitDir, config: cfg, refs: store}, nil
+}
+
+// OpenCommit creates a Commit of current repository.
+func OpenCommit() (*Commit, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewCommit(gitDir)
+}
+
+func (c *Commit) path(name string) string {
+	return filepath.Join(c.gitDir, name)
+}
+
+// Create creates a commit of the index and moves HEAD to it like $ git commit. options may be nil.
+// it fails with ErrNothingToCommit if the tree is the same as the parent unless AllowEmpty or a merge is concluded,
+// and with ErrEmptyMessage if the cleaned up message is empty unless AllowEmptyMessage.
+func (c *Commit) Create(options *Options) (*Result, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	head, headCommit, err := c.head()
+	if err != nil {
+		return nil, err
+	}
+	mergeHeads, err := c.mergeHeads()
+	if err != nil {
+		return nil, err
+	}
+	picked, err := c.readHead(CherryPickHead)
+	if err != nil {
+		return nil, err
+	}
+	if options.Amend {
+		switch {
+		case headCommit == nil:
+			return nil, ErrNothingToAmend
+		case len(mergeHeads) > 0:
+			return nil, ErrAmendInMerge
+		case picked != nil:
+			return nil, ErrAmendInCherryPick
+		}
+	}
+
+	idx, err := index.ReadIndexFrom(c.gitDir)
+	if os.IsNotExist(err) {
+		idx, err = index.NewIndex(nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tree, err := WriteTree(c.gitDir, idx)
+	if err != nil {
+		return nil, err
+	}
+	var parents [][]byte
+	if options.Amend {
+		parents = headCommit.Parents
+	} else if head != nil {
+		parents = [][]byte{head}
+	}
+	parents = append(parents, mergeHeads...)
+	if !options.AllowEmpty && len(mergeHeads) == 0 && !(options.Amend && len(parents) > 1) {
+		if err := c.checkChanged(tree, parents, options.Amend); err != nil {
+			return nil, err
+		}
+	}
+
+	committer, err := ResolveCommitter(c.config, c.Committer)
+	if err != nil {
+		return nil, err
+	}
+	author, err := c.author(headCommit, picked, options)
+	if err != nil {
+		return nil, err
+	}
+	message, err := c.message(headCommit, committer, options)
+	if err != nil {
+		return nil, err
+	}
+
+	commit := &object.Commit{Tree: tree, Parents: parents, Author: author, Committer: committer, Message: message}
+	digest, err := object.WriteObject(c.gitDir, commit)
+	if err != nil {
+		return nil, err
+	}
+	old := head
+	if old == nil {
+		old = refs.ZeroDigest
+	}
+	log := &refs.LogMessage{Committer: committer, Message: reflogMessage(head, mergeHeads, picked, options) + FirstLine(message)}
+	if err := c.refs.Update(refs.HEAD, digest, old, log); err != nil {
+		return nil, err
+	}
+	if err := c.removeFiles(refs.MergeHEAD, MergeMsg, MergeMode, SquashMsg, AutoMerge, CherryPickHead, RevertHead); err != nil {
+		return nil, err
+	}
+	rr, err := rerere.NewRerere(c.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rr.Run(); err != nil {
+		return nil, err
+	}
+
+	summary, err := c.summary(digest, head == nil, commit)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Digest: digest, Commit: commit, Messages: []string{summary}}, nil
+}
+
+// head returns the digest of the commit which HEAD points to and the commit, they are nil if HEAD is unborn.
+func (c *Commit) head() ([]byte, *object.Commit, error) {
+	ref, err := c.refs.Resolve(refs.HEAD)
+	if err == refs.ErrRefNotFound {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	commit, err := c.readCommit(ref.Digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ref.Digest, commit, nil
+}
+
+func (c *Commit) readCommit(digest []byte) (*object.Commit, error) {
+	obj, err := object.ReadObjectFrom(c.gitDir, digest)
+	if err != nil {
+		return nil, err
+	}
+	commit, ok := obj.(*object.Commit)
+	if !ok {
+		return nil, object.ErrUnexpectedType
+	}
+	return commit, nil
+}
+
+// readHead returns the digest written in the file like CHERRY_PICK_HEAD, it's nil if the file doesn't exist.
+func (c *Commit) readHead(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(c.path(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(data)))
+}
+
+// mergeHeads returns digests of commits in MERGE_HEAD, one commit is written in a line.
+func (c *Commit) mergeHeads() ([][]byte, error) {
+	data, err := ioutil.ReadFile(c.path(refs.MergeHEAD))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	heads := [][]byte{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		digest, err := hex.DecodeString(line)
+		if err != nil || len(digest) != refs.DigestSize {
+			return nil, ErrInvalidMergeHead
+		}
+		heads = append(heads, digest)
+	}
+	return heads, nil
+}
+
+// checkChanged returns an error if the tree is the same as the tree of the first parent, or the empty tree for
+// a root commit.
+func (c *Commit) checkChanged(tree []byte, parents [][]byte, amend bool) error {
+	base, err := object.NewTree(nil).SHA1()
+	if err != nil {
+		return err
+	}
+	if len(parents) > 0 {
+		parent, err := c.readCommit(parents[0])
+		if err != nil {
+			return err
+		}
+		base = parent.Tree
+	}
+	if !bytes.Equal(tree, base) {
+		return nil
+	}
+	if amend {
+		return ErrEmptyAmend
+	}
+	return ErrNothingToCommit
+}
+
+// author returns the author of the commit. Options.Author is used if it's given, and the author of HEAD is kept
+// with Amend unless ResetAuthor. the author of the cherry-picked commit is used if a cherry-pick is concluded.
+func (c *Commit) author(headCommit *object.Commit, picked []byte, options *Options) (*object.Signature, error) {
+	switch {
+	case options.Author != nil:
+		return options.Author, nil
+	case options.Amend && !options.ResetAuthor:
+		return headCommit.Author, nil
+	case picked != nil && !options.ResetAuthor:
+		commit, err := c.readCommit(picked)
+		if err != nil {
+			return nil, err
+		}
+		return commit.Author, nil
+	}
+	return Identity(c.config, "author")
+}
+
+// message returns the cleaned up message having trailers of the options, and writes it to COMMIT_EDITMSG.
+func (c *Commit) message(headCommit *object.Commit, committer *object.Signature, options *Options) (string, error) {
+	mode, err := c.cleanupMode(options.Cleanup)
+	if err != nil {
+		return "", err
+	}
+	message := options.Message
+	if message == "" {
+		if options.Amend {
+			message = headCommit.Message
+		} else if message, err = c.readMessage(); err != nil {
+			return "", err
+		}
+	}
+	if options.Signoff {
+		message = AppendTrailer(message, Signoff(committer))
+	}
+	for _, trailer := range options.Trailers {
+		trailer, err := parseTrailer(trailer)
+		if err != nil {
+			return "", err
+		}
+		message = AppendTrailer(message, trailer)
+	}
+	message = cleanup(message, mode, CommentChar(c.config))
+	if isEmptyMessage(message, mode) && !options.AllowEmptyMessage {
+		return "", ErrEmptyMessage
+	}
+	// the message is left in COMMIT_EDITMSG like git, it's never edited though
+	if err := ioutil.WriteFile(c.path(CommitEditMsg), []byte(message), 0666); err != nil {
+		return "", err
+	}
+	return message, nil
+}
+
+// readMessage returns the message prepared in MERGE_MSG or SQUASH_MSG, it's "" if they don't exist.
+func (c *Commit) readMessage() (string, error) {
+	for _, name := range []string{MergeMsg, SquashMsg} {
+		data, err := ioutil.ReadFile(c.path(name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return "", nil
+}
+
+// reflogMessage returns the prefix of the reflog message like "commit (amend): ".
+func reflogMessage(head []byte, mergeHeads [][]byte, picked []byte, options *Options) string {
+	switch {
+	case options.Amend:
+		return "commit (amend): "
+	case head == nil:
+		return "commit (initial): "
+	case len(mergeHeads) > 0:
+		return "commit (merge): "
+	case picked != nil:
+		return "commit (cherry-pick): "
+	}
+	return "commit: "
+}
+
+// summary returns the message like "[main (root-commit) 1234567] subject".
+func (c *Commit) summary(digest []byte, root bool, commit *object.Commit) (string, error) {
+	ref, err := c.refs.Read(refs.HEAD)
+	if err != nil {
+		return "", err
+	}
+	branch := "detached HEAD"
+	if ref.IsSymbolic() {
+		branch = strings.TrimPrefix(ref.Target, refs.HeadPrefix)
+	}
+	if root {
+		branch += " (root-commit)"
+	}
+	return "[" + branch + " " + c.abbrev(digest) + "] " + commit.Summary(), nil
+}
+
+// abbrev returns the shortest unique prefix of the digest at least 7 characters.
+func (c *Commit) abbrev(digest []byte) string {
+	name := hex.EncodeToString(digest)
+	for n := 7; n < len(name); n++ {
+		if candidates, err := object.FindObjects(c.gitDir, name[:n]); err == nil && len(candidates) <= 1 {
+			return name[:n]
+		}
+	}
+	return name
+}
+
+// removeFiles removes the files in .git directory, files which don't exist are ignored.
+func (c *Commit) removeFiles(names ...string) error {
+	for _, name := range names {
+		if err := os.Remove(c.path(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package commit
+
+import "errors"
+
+var (
+	ErrNothingToCommit   = errors.New("nothing to commit")
+	ErrEmptyAmend        = errors.New("you asked to amend the most recent commit, but doing so would make it empty")
+	ErrNothingToAmend    = errors.New("you have nothing to amend")
+	ErrAmendInMerge      = errors.New("you are in the middle of a merge -- cannot amend")
+	ErrAmendInCherryPick = errors.New("you are in the middle of a cherry-pick -- cannot amend")
+	ErrUnmergedPaths     = errors.New("committing is not possible because you have unmerged files")
+	ErrEmptyMessage      = errors.New("aborting commit due to empty commit message")
+	ErrInvalidCleanup    = errors.New("invalid cleanup mode")
+	ErrInvalidTrailer    = errors.New("invalid trailer")
+	ErrInvalidMergeHead  = errors.New("could not parse MERGE_HEAD")
+	ErrNoIdentity        = errors.New("unable to auto-detect name or email address")
+	ErrInvalidDate       = errors.New("invalid date format")
+)
@@ -0,0 +1,87 @@
+package commit
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// ResolveCommitter returns the committer, the identity is taken from GIT_COMMITTER_NAME, GIT_COMMITTER_EMAIL,
+// GIT_COMMITTER_DATE and config if it's nil.
+func ResolveCommitter(cfg *config.Config, committer *object.Signature) (*object.Signature, error) {
+	if committer != nil {
+		return committer, nil
+	}
+	return Identity(cfg, "committer")
+}
+
+// Identity returns the identity of the author or the committer like git var GIT_AUTHOR_IDENT and GIT_COMMITTER_IDENT.
+// role is "author" or "committer". the name is taken from GIT_<ROLE>_NAME, <role>.name config or user.name config,
+// the email is taken from GIT_<ROLE>_EMAIL, <role>.email config, user.email config or EMAIL, and the date is taken
+// from GIT_<ROLE>_DATE.
+func Identity(cfg *config.Config, role string) (*object.Signature, error) {
+	upper := strings.ToUpper(role)
+	name := os.Getenv("GIT_" + upper + "_NAME")
+	if name == "" {
+		name = cfg.GetString(role+".name", cfg.GetString("user.name", ""))
+	}
+	email := os.Getenv("GIT_" + upper + "_EMAIL")
+	if email == "" {
+		email = cfg.GetString(role+".email", cfg.GetString("user.email", os.Getenv("EMAIL")))
+	}
+	if name == "" || email == "" {
+		return nil, ErrNoIdentity
+	}
+	when := time.Now()
+	if date := os.Getenv("GIT_" + upper + "_DATE"); date != "" {
+		var err error
+		if when, err = ParseDate(date); err != nil {
+			return nil, err
+		}
+	}
+	return &object.Signature{Name: name, Email: email, When: when}, nil
+}
+
+// rawDate matches git's internal date format "<unix time> <timezone>", "@" may precede the unix time.
+var rawDate = regexp.MustCompile(`^@?([0-9]+)(?: ([+-][0-9]{4}))?$`)
+
+// dateLayouts are formats of dates accepted besides git's internal format.
+var dateLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// ParseDate parses a date of GIT_AUTHOR_DATE and GIT_COMMITTER_DATE.
+// git's internal format "<unix time> <timezone>", RFC3339, RFC1123 and ISO 8601 like formats are accepted.
+func ParseDate(date string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	if m := rawDate.FindStringSubmatch(date); m != nil {
+		sec, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return time.Time{}, ErrInvalidDate
+		}
+		if m[2] == "" {
+			return time.Unix(sec, 0), nil
+		}
+		signature, err := object.ParseSignature("x <x> " + m[1] + " " + m[2])
+		if err != nil {
+			return time.Time{}, ErrInvalidDate
+		}
+		return signature.When, nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, date, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrInvalidDate
+}
@@ -0,0 +1,178 @@
+package commit
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// CleanupMode is a type representing how the commit message is cleaned up like --cleanup of $ git commit
+type CleanupMode string
+
+// constants of cleanup modes, they are the same as values of commit.cleanup config.
+// messages are never edited, so CleanupDefault and CleanupScissors are the same as CleanupWhitespace like git.
+const (
+	CleanupDefault    CleanupMode = "default"    // commit.cleanup config, or CleanupWhitespace
+	CleanupStrip      CleanupMode = "strip"      // CleanupWhitespace, and comment lines are removed
+	CleanupWhitespace CleanupMode = "whitespace" // trailing spaces, leading and trailing blank lines are removed
+	CleanupVerbatim   CleanupMode = "verbatim"   // the message isn't changed at all
+	CleanupScissors   CleanupMode = "scissors"   // CleanupWhitespace, the message would be cut at scissors if edited
+)
+
+// signoffPrefix is the key of the trailer added by Signoff.
+const signoffPrefix = "Signed-off-by: "
+
+// cleanupMode returns the cleanup mode of the commit, CleanupDefault and "" are resolved by commit.cleanup config.
+func (c *Commit) cleanupMode(mode CleanupMode) (CleanupMode, error) {
+	if mode == "" || mode == CleanupDefault {
+		mode = CleanupMode(strings.ToLower(c.config.GetString("commit.cleanup", string(CleanupDefault))))
+	}
+	switch mode {
+	case CleanupDefault, CleanupScissors:
+		return CleanupWhitespace, nil
+	case CleanupStrip, CleanupWhitespace, CleanupVerbatim:
+		return mode, nil
+	default:
+		return "", ErrInvalidCleanup
+	}
+}
+
+// CommentChar returns the character starting comment lines by core.commentChar config, "auto" means '#'.
+func CommentChar(cfg *config.Config) byte {
+	char := cfg.GetString("core.commentChar", "#")
+	if char == "" || char == "auto" {
+		return '#'
+	}
+	return char[0]
+}
+
+// cleanup cleans up the message by the mode, comment lines start with the comment character.
+func cleanup(message string, mode CleanupMode, comment byte) string {
+	switch mode {
+	case CleanupVerbatim:
+		return message
+	case CleanupStrip:
+		return Stripspace(message, comment)
+	default:
+		return Stripspace(message, 0)
+	}
+}
+
+// Stripspace cleans up the message like $ git stripspace
+// trailing spaces of lines, leading and trailing blank lines are removed, and consecutive blank lines are squashed.
+// lines starting with the comment character are also removed unless it's 0, use CommentChar to get it.
+func Stripspace(message string, comment byte) string {
+	lines := []string{}
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		if comment != 0 && line != "" && line[0] == comment {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r\v\f")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// isEmptyMessage reports whether the message has only blank lines and Signed-off-by trailers like git.
+// any message is not empty in CleanupVerbatim except "".
+func isEmptyMessage(message string, mode CleanupMode) bool {
+	if mode == CleanupVerbatim {
+		return message == ""
+	}
+	for _, line := range strings.Split(message, "\n") {
+		if !strings.HasPrefix(line, signoffPrefix) && strings.TrimSpace(line) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// CompleteLine appends a newline to the message if it doesn't end with a newline.
+func CompleteLine(message string) string {
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		return message + "\n"
+	}
+	return message
+}
+
+// FirstLine returns the first line of the message.
+func FirstLine(message string) string {
+	if i := strings.IndexByte(message, '\n'); i >= 0 {
+		return message[:i]
+	}
+	return message
+}
+
+// trailerLine matches a line of trailers like "Signed-off-by: <name>".
+var trailerLine = regexp.MustCompile(`^[A-Za-z0-9-]+:\s`)
+
+// trailerSeparator matches a trailer given like --trailer "<key>: <value>" or "<key>=<value>".
+var trailerSeparator = regexp.MustCompile(`^([A-Za-z0-9-]+)\s*[:=]\s*(.*)$`)
+
+// TrailerLines returns lines of the last paragraph of the message if it consists of trailers.
+// the first paragraph is the title, so it isn't regarded as trailers.
+func TrailerLines(message string) []string {
+	paragraphs := strings.Split(strings.TrimRight(message, "\n"), "\n\n")
+	if len(paragraphs) < 2 {
+		return nil
+	}
+	lines := strings.Split(strings.Trim(paragraphs[len(paragraphs)-1], "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case trailerLine.MatchString(line), strings.HasPrefix(line, "(cherry picked from commit "):
+		case i > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")):
+		default:
+			return nil
+		}
+	}
+	return lines
+}
+
+// HasTrailers reports whether the message ends with trailers.
+func HasTrailers(message string) bool {
+	return TrailerLines(message) != nil
+}
+
+// Signoff returns the Signed-off-by trailer of the signature like "Signed-off-by: <name> <<email>>".
+func Signoff(signature *object.Signature) string {
+	return signoffPrefix + signature.Name + " <" + signature.Email + ">"
+}
+
+// parseTrailer normalizes the trailer given like "<key>=<value>" to "<key>: <value>".
+func parseTrailer(trailer string) (string, error) {
+	m := trailerSeparator.FindStringSubmatch(strings.TrimSpace(trailer))
+	if m == nil {
+		return "", ErrInvalidTrailer
+	}
+	return m[1] + ": " + m[2], nil
+}
+
+// AppendTrailer appends the trailer to trailers of the message, a blank line separates them if the message has
+// no trailers. nothing is appended if the last trailer is already the same like trailer.ifExists of
+// addIfDifferentNeighbor.
+func AppendTrailer(message, trailer string) string {
+	message = CompleteLine(message)
+	lines := TrailerLines(message)
+	if lines == nil {
+		if message != "" {
+			message += "\n"
+		}
+	} else if lines[len(lines)-1] == trailer {
+		return message
+	}
+	return message + trailer + "\n"
+}
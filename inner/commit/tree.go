@@ -0,0 +1,60 @@
+package commit
+
+import (
+	"strings"
+
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// WriteTree writes tree objects of the index like $ git write-tree, and returns the digest of the root tree.
+// it fails with ErrUnmergedPaths if the index has conflicting entries, and intent-to-add entries aren't written
+// like git.
+// gitDir of parameters must be path to .git directory.
+func WriteTree(gitDir string, idx index.Index) ([]byte, error) {
+	type dir struct {
+		entries []*object.TreeEntry
+		subdirs map[string]*dir
+	}
+	root := &dir{subdirs: map[string]*dir{}}
+	for i := uint32(0); i < idx.Header().NumOfEntries; i++ {
+		entry, err := idx.Entries(i)
+		if err != nil {
+			return nil, err
+		}
+		if entry.ConflictFlag != index.NoConflict {
+			return nil, ErrUnmergedPaths
+		}
+		if entry.IntentToAdd {
+			continue
+		}
+		d := root
+		components := strings.Split(entry.Name, "/")
+		for _, component := range components[:len(components)-1] {
+			sub, ok := d.subdirs[component]
+			if !ok {
+				sub = &dir{subdirs: map[string]*dir{}}
+				d.subdirs[component] = sub
+			}
+			d = sub
+		}
+		d.entries = append(d.entries, &object.TreeEntry{
+			Mode:   object.FileMode(uint32(entry.ObjectType)<<12 | uint32(entry.Permission)),
+			Name:   components[len(components)-1],
+			Digest: entry.Digest,
+		})
+	}
+	var write func(d *dir) ([]byte, error)
+	write = func(d *dir) ([]byte, error) {
+		entries := d.entries
+		for name, sub := range d.subdirs {
+			digest, err := write(sub)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, &object.TreeEntry{Mode: object.ModeTree, Name: name, Digest: digest})
+		}
+		return object.WriteObject(gitDir, object.NewTree(entries))
+	}
+	return write(root)
+}
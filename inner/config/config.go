@@ -0,0 +1,331 @@
+// config is a package to read git configuration files
+//
+// The following show git configuration file format overview.
+//
+//  # comment
+//  [core]
+//      bare = false
+//      autocrlf        ; boolean key without value means true
+//  [remote "origin"]
+//      url = "https://example.com/repo.git"
+//  [include]
+//      path = other.config
+//
+// Section names and variable names are case-insensitive, subsection names are case-sensitive.
+// A variable is specified by "<section>.<name>" or "<section>.<subsection>.<name>" key.
+// If a variable is set many times, the last value wins.
+//
+// If you want to know more about git configuration, please refer to
+// https://git-scm.com/docs/git-config
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth is the same limit as git's MAX_INCLUDE_DEPTH
+const maxIncludeDepth = 10
+
+// Entry is a type representing one variable of git configuration.
+type Entry struct {
+	Section    string // lower-cased section name
+	Subsection string // case-sensitive subsection name, or empty
+	Name       string // lower-cased variable name
+	Value      string // value of the variable
+	NoValue    bool   // whether the variable is written without "= value"
+}
+
+// Key returns "<section>.<subsection>.<name>" or "<section>.<name>"
+func (e *Entry) Key() string {
+	if e.Subsection == "" {
+		return e.Section + "." + e.Name
+	}
+	return e.Section + "." + e.Subsection + "." + e.Name
+}
+
+// String is implementation of fmt.Stringer interface
+func (e *Entry) String() string {
+	if e.NoValue {
+		return e.Key()
+	}
+	return e.Key() + "=" + e.Value
+}
+
+// Config is a type representing git configuration.
+type Config struct {
+	entries []*Entry
+}
+
+// New creates a new empty Config
+func New() *Config {
+	return &Config{}
+}
+
+// Load reads configuration of the repository in the same order as git does.
+// global configurations(~/.gitconfig, $XDG_CONFIG_HOME/git/config) are read first,
+// and repository configuration(.git/config) overrides them.
+// gitDir of parameters must be path to .git directory.
+func Load(gitDir string) (*Config, error) {
+	config := New()
+	paths := []string{}
+	if os.Getenv("GIT_CONFIG_NOSYSTEM") == "" {
+		paths = append(paths, "/etc/gitconfig")
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "git", "config"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config", "git", "config"))
+	}
+	if global := os.Getenv("GIT_CONFIG_GLOBAL"); global != "" {
+		paths = append(paths, global)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".gitconfig"))
+	}
+	paths = append(paths, filepath.Join(gitDir, "config"))
+
+	for _, path := range paths {
+		if err := config.readFile(path, 0); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// ReadFile reads a git configuration file
+func ReadFile(path string) (*Config, error) {
+	config := New()
+	if err := config.readFile(path, 0); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// readFile reads a git configuration file and appends its entries.
+// include.path is expanded relative to the directory of the file.
+func (c *Config) readFile(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return ErrIncludeTooDeep
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	entries, err := parse(data)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		c.entries = append(c.entries, entry)
+		if entry.Key() != "include.path" || entry.NoValue {
+			continue
+		}
+		includePath := expandPath(entry.Value)
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(path), includePath)
+		}
+		if err := c.readFile(includePath, depth+1); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse parses contents of a git configuration file.
+// include.path is not expanded because there is no base directory.
+func Parse(data []byte) (*Config, error) {
+	entries, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{entries: entries}, nil
+}
+
+// Entries returns all entries in order of appearance
+func (c *Config) Entries() []*Entry {
+	return c.entries
+}
+
+// Add appends a variable, it overrides the variables already set.
+func (c *Config) Add(key, value string) error {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	c.entries = append(c.entries, &Entry{
+		Section:    section,
+		Subsection: subsection,
+		Name:       name,
+		Value:      value,
+	})
+	return nil
+}
+
+// splitKey splits "<section>.<subsection>.<name>" key and normalizes cases.
+func splitKey(key string) (string, string, string, error) {
+	first := strings.IndexByte(key, '.')
+	last := strings.LastIndexByte(key, '.')
+	if first <= 0 || last == len(key)-1 {
+		return "", "", "", ErrInvalidKey
+	}
+	section := strings.ToLower(key[:first])
+	name := strings.ToLower(key[last+1:])
+	subsection := ""
+	if first != last {
+		subsection = key[first+1 : last]
+	}
+	return section, subsection, name, nil
+}
+
+// lookup returns entries matching key in order of appearance.
+func (c *Config) lookup(key string) []*Entry {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return nil
+	}
+	entries := []*Entry{}
+	for _, entry := range c.entries {
+		if entry.Section == section && entry.Subsection == subsection && entry.Name == name {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Get returns the last value of the variable, and whether it is set.
+func (c *Config) Get(key string) (string, bool) {
+	entries := c.lookup(key)
+	if len(entries) == 0 {
+		return "", false
+	}
+	return entries[len(entries)-1].Value, true
+}
+
+// GetString returns the last value of the variable, or defaultValue if it is not set.
+func (c *Config) GetString(key, defaultValue string) string {
+	if value, ok := c.Get(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// GetAll returns all values of the multi-valued variable.
+func (c *Config) GetAll(key string) []string {
+	values := []string{}
+	for _, entry := range c.lookup(key) {
+		values = append(values, entry.Value)
+	}
+	return values
+}
+
+// Bool returns the last value of the variable as boolean, or defaultValue if it is not set.
+// true, yes, on and non-zero numbers are true, and false, no, off, 0 and empty string are false.
+func (c *Config) Bool(key string, defaultValue bool) (bool, error) {
+	entries := c.lookup(key)
+	if len(entries) == 0 {
+		return defaultValue, nil
+	}
+	entry := entries[len(entries)-1]
+	if entry.NoValue {
+		return true, nil
+	}
+	return ParseBool(entry.Value)
+}
+
+// ParseBool parses a boolean value in the same way as git.
+func ParseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+	n, err := ParseInt(value)
+	if err != nil {
+		return false, ErrInvalidBool
+	}
+	return n != 0, nil
+}
+
+// Int returns the last value of the variable as integer, or defaultValue if it is not set.
+func (c *Config) Int(key string, defaultValue int) (int, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return defaultValue, nil
+	}
+	n, err := ParseInt(value)
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// ParseInt parses an integer value with optional unit suffix k, m or g.
+func ParseInt(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	factor := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			factor = 1024
+		case 'm', 'M':
+			factor = 1024 * 1024
+		case 'g', 'G':
+			factor = 1024 * 1024 * 1024
+		}
+		if factor != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidInt
+	}
+	return n * factor, nil
+}
+
+// Path returns the last value of the variable with "~/" expanded to home directory.
+func (c *Config) Path(key string) (string, bool) {
+	value, ok := c.Get(key)
+	if !ok {
+		return "", false
+	}
+	return expandPath(value), true
+}
+
+// expandPath expands "~/" prefix to home directory
+func expandPath(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// Subsections returns names of subsections of section in order of appearance.
+// (e.g. Subsections("remote") returns ["origin", "upstream"])
+func (c *Config) Subsections(section string) []string {
+	section = strings.ToLower(section)
+	seen := map[string]struct{}{}
+	subsections := []string{}
+	for _, entry := range c.entries {
+		if entry.Section != section || entry.Subsection == "" {
+			continue
+		}
+		if _, ok := seen[entry.Subsection]; ok {
+			continue
+		}
+		seen[entry.Subsection] = struct{}{}
+		subsections = append(subsections, entry.Subsection)
+	}
+	return subsections
+}
@@ -0,0 +1,11 @@
+package config
+
+import "errors"
+
+var (
+	ErrInvalidSyntax  = errors.New("invalid config syntax")
+	ErrInvalidKey     = errors.New("invalid config key")
+	ErrInvalidBool    = errors.New("invalid boolean config value")
+	ErrInvalidInt     = errors.New("invalid integer config value")
+	ErrIncludeTooDeep = errors.New("config include nesting is too deep")
+)
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"strings"
+)
+
+// parser is a parser of git configuration file
+type parser struct {
+	data       []byte
+	pos        int
+	section    string
+	subsection string
+}
+
+// parse parses contents of git configuration file to entries
+func parse(data []byte) ([]*Entry, error) {
+	p := &parser{data: data}
+	// skip UTF-8 BOM
+	if strings.HasPrefix(string(data), "\xEF\xBB\xBF") {
+		p.pos = 3
+	}
+	entries := []*Entry{}
+	for {
+		p.skipSpaces()
+		c, ok := p.peek()
+		if !ok {
+			return entries, nil
+		}
+		switch {
+		case c == '\n':
+			p.pos++
+		case c == '#' || c == ';':
+			p.skipLine()
+		case c == '[':
+			if err := p.parseSection(); err != nil {
+				return nil, err
+			}
+		case isAlpha(c):
+			if p.section == "" {
+				return nil, ErrInvalidSyntax
+			}
+			entry, err := p.parseVariable()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		default:
+			return nil, ErrInvalidSyntax
+		}
+	}
+}
+
+func (p *parser) peek() (byte, bool) {
+	if p.pos >= len(p.data) {
+		return 0, false
+	}
+	return p.data[p.pos], true
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t' || p.data[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+func (p *parser) skipLine() {
+	for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+// parseSection parses [section], [section "subsection"] and deprecated [section.subsection]
+func (p *parser) parseSection() error {
+	p.pos++ // '['
+	start := p.pos
+	for p.pos < len(p.data) && (isAlnum(p.data[p.pos]) || p.data[p.pos] == '-' || p.data[p.pos] == '.') {
+		p.pos++
+	}
+	name := string(p.data[start:p.pos])
+	if name == "" {
+		return ErrInvalidSyntax
+	}
+
+	c, ok := p.peek()
+	if !ok {
+		return ErrInvalidSyntax
+	}
+	if c == ']' {
+		p.pos++
+		if dot := strings.IndexByte(name, '.'); dot >= 0 {
+			// [section.subsection] is deprecated syntax and its subsection is case-insensitive
+			p.section = strings.ToLower(name[:dot])
+			p.subsection = strings.ToLower(name[dot+1:])
+		} else {
+			p.section = strings.ToLower(name)
+			p.subsection = ""
+		}
+		return nil
+	}
+
+	p.skipSpaces()
+	if c, ok := p.peek(); !ok || c != '"' || strings.ContainsRune(name, '.') {
+		return ErrInvalidSyntax
+	}
+	p.pos++
+	subsection := []byte{}
+	for {
+		c, ok := p.peek()
+		if !ok || c == '\n' {
+			return ErrInvalidSyntax
+		}
+		p.pos++
+		if c == '"' {
+			break
+		}
+		if c == '\\' {
+			c, ok = p.peek()
+			if !ok || c == '\n' {
+				return ErrInvalidSyntax
+			}
+			p.pos++
+		}
+		subsection = append(subsection, c)
+	}
+	if c, ok := p.peek(); !ok || c != ']' {
+		return ErrInvalidSyntax
+	}
+	p.pos++
+	p.section = strings.ToLower(name)
+	p.subsection = string(subsection)
+	return nil
+}
+
+// parseVariable parses "name = value" or "name"
+func (p *parser) parseVariable() (*Entry, error) {
+	start := p.pos
+	for p.pos < len(p.data) && (isAlnum(p.data[p.pos]) || p.data[p.pos] == '-') {
+		p.pos++
+	}
+	entry := &Entry{
+		Section:    p.section,
+		Subsection: p.subsection,
+		Name:       strings.ToLower(string(p.data[start:p.pos])),
+	}
+	p.skipSpaces()
+	c, ok := p.peek()
+	if !ok || c == '\n' || c == '#' || c == ';' {
+		entry.NoValue = true
+		p.skipLine()
+		return entry, nil
+	}
+	if c != '=' {
+		return nil, ErrInvalidSyntax
+	}
+	p.pos++
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	entry.Value = value
+	return entry, nil
+}
+
+// parseValue parses a value to end of line.
+// quotes are removed, escape sequences are interpreted,
+// white spaces out of quotes are trimmed and comments are removed.
+func (p *parser) parseValue() (string, error) {
+	p.skipSpaces()
+	value := []byte{}
+	quoted := false
+	// length of value without trailing white spaces out of quotes
+	trimmed := 0
+	for {
+		c, ok := p.peek()
+		if !ok {
+			break
+		}
+		p.pos++
+		if c == '\n' {
+			if quoted {
+				return "", ErrInvalidSyntax
+			}
+			break
+		}
+		if !quoted && (c == '#' || c == ';') {
+			p.skipLine()
+			break
+		}
+		switch c {
+		case '"':
+			quoted = !quoted
+			trimmed = len(value)
+			continue
+		case '\\':
+			escaped, ok := p.peek()
+			if !ok {
+				return "", ErrInvalidSyntax
+			}
+			p.pos++
+			switch escaped {
+			case '\n':
+				// line continuation
+				continue
+			case '\r':
+				if next, ok := p.peek(); ok && next == '\n' {
+					p.pos++
+					continue
+				}
+				return "", ErrInvalidSyntax
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case '\\', '"':
+				c = escaped
+			default:
+				return "", ErrInvalidSyntax
+			}
+			value = append(value, c)
+			trimmed = len(value)
+			continue
+		}
+		value = append(value, c)
+		if quoted || (c != ' ' && c != '\t' && c != '\r') {
+			trimmed = len(value)
+		}
+	}
+	if quoted {
+		return "", ErrInvalidSyntax
+	}
+	return string(value[:trimmed]), nil
+}
+
+func isAlpha(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isAlnum(c byte) bool {
+	return isAlpha(c) || ('0' <= c && c <= '9')
+}
@@ -0,0 +1,420 @@
+// conflict is a package to inspect and resolve conflicts left in the index by merging
+//
+// A conflicting path has entries at stage 1 (the common ancestor), 2 (ours) and 3 (theirs) instead of stage 0.
+// They are grouped into a conflict, and it's classified by the stages and paths around it.
+//
+//  content        - modified on both sides, the path has all stages
+//  add/add        - added on both sides differently, the path doesn't have stage 1
+//  modify/delete  - modified on one side and deleted on the other side, the path has stage 1 and one of the others
+//  rename/rename  - renamed to different paths on each side, stage 1 is at the original path and the others are at the destinations
+//  directory/file - a file of one side is in the way of a directory of the other side
+//
+// When a conflict is resolved, its stages are recorded in REUC extension of the index,
+// and the conflict can be recreated from them like $ git update-index --unresolve <path>.
+//
+// If you want to know more about conflicts, please refer to
+// https://git-scm.com/docs/git-merge#_how_conflicts_are_presented
+// https://github.com/git/git/blob/master/Documentation/technical/index-format.txt
+package conflict
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// Type is a type representing the kind of a conflict.
+type Type int
+
+// constants of Type
+const (
+	Content       Type = iota // modified on both sides
+	AddAdd                    // added on both sides
+	ModifyDelete              // modified on one side and deleted on the other side
+	RenameRename              // renamed to different paths on each side
+	DirectoryFile             // a file is in the way of a directory
+)
+
+// String is implementation of fmt.Stringer interface.
+func (t Type) String() string {
+	switch t {
+	case Content:
+		return "content"
+	case AddAdd:
+		return "add/add"
+	case ModifyDelete:
+		return "modify/delete"
+	case RenameRename:
+		return "rename/rename"
+	case DirectoryFile:
+		return "directory/file"
+	default:
+		return "unknown"
+	}
+}
+
+// Conflict is a type representing stages of a conflicting path.
+// stages of rename/rename are at different paths, Name is the original path and
+// names of Ours and Theirs are the destinations.
+type Conflict struct {
+	Name     string
+	Type     Type
+	Ancestor *index.Entry // stage 1, nil if the path doesn't exist in the common ancestor
+	Ours     *index.Entry // stage 2, nil if the path doesn't exist in ours
+	Theirs   *index.Entry // stage 3, nil if the path doesn't exist in theirs
+}
+
+// Entry returns the entry of the stage, it's nil if the stage doesn't exist.
+func (c *Conflict) Entry(stage index.ConflictFlag) *index.Entry {
+	switch stage {
+	case index.LowestCommonAncestorCommit:
+		return c.Ancestor
+	case index.CurrentCommit:
+		return c.Ours
+	case index.AnotherCommit:
+		return c.Theirs
+	default:
+		return nil
+	}
+}
+
+// Paths returns paths having the stages of the conflict, the path of a rename/rename conflict has 2 or 3 paths.
+func (c *Conflict) Paths() []string {
+	paths := []string{}
+	for _, entry := range []*index.Entry{c.Ancestor, c.Ours, c.Theirs} {
+		if entry != nil && !contains(paths, entry.Name) {
+			paths = append(paths, entry.Name)
+		}
+	}
+	return paths
+}
+
+// List returns conflicts in the index in order of their names.
+func List(idx index.Index) ([]*Conflict, error) {
+	entries, err := readEntries(idx)
+	if err != nil {
+		return nil, err
+	}
+	return list(entries), nil
+}
+
+// list groups stages of the entries into conflicts.
+func list(entries []*index.Entry) []*Conflict {
+	names := make([]string, len(entries))
+	stages := map[string]*[3]*index.Entry{}
+	conflicting := []string{}
+	for i, entry := range entries {
+		names[i] = entry.Name
+		if entry.ConflictFlag == index.NoConflict {
+			continue
+		}
+		s, ok := stages[entry.Name]
+		if !ok {
+			s = &[3]*index.Entry{}
+			stages[entry.Name] = s
+			conflicting = append(conflicting, entry.Name)
+		}
+		s[entry.ConflictFlag-1] = entry
+	}
+	sort.Strings(names)
+
+	// a directory/file conflict has entries under the path, or the file is moved to <path>~<label> like merge-ort
+	dirFile := map[string]bool{}
+	for _, name := range conflicting {
+		dirFile[name] = hasDirectory(names, name)
+		if i := strings.LastIndexByte(name, '~'); i > 0 && !dirFile[name] {
+			dirFile[name] = hasDirectory(names, name[:i])
+		}
+	}
+
+	// stage 1 of rename/rename is left alone at the original path, and paired with destinations having only ours or theirs
+	renamed := map[string]bool{}
+	destination := func(stage index.ConflictFlag, source *index.Entry) *index.Entry {
+		var found *index.Entry
+		for _, name := range conflicting {
+			if renamed[name] || dirFile[name] || !onlyStage(stages[name], stage) {
+				continue
+			}
+			entry := stages[name][stage-1]
+			if found == nil || bytes.Equal(entry.Digest, source.Digest) && !bytes.Equal(found.Digest, source.Digest) {
+				found = entry
+			}
+		}
+		if found != nil {
+			renamed[found.Name] = true
+		}
+		return found
+	}
+	conflicts := []*Conflict{}
+	for _, name := range conflicting {
+		if dirFile[name] || !onlyStage(stages[name], index.LowestCommonAncestorCommit) {
+			continue
+		}
+		renamed[name] = true
+		c := &Conflict{Name: name, Type: RenameRename, Ancestor: stages[name][0]}
+		c.Ours = destination(index.CurrentCommit, c.Ancestor)
+		c.Theirs = destination(index.AnotherCommit, c.Ancestor)
+		conflicts = append(conflicts, c)
+	}
+
+	for _, name := range conflicting {
+		if renamed[name] {
+			continue
+		}
+		s := stages[name]
+		c := &Conflict{Name: name, Ancestor: s[0], Ours: s[1], Theirs: s[2]}
+		switch {
+		case dirFile[name]:
+			c.Type = DirectoryFile
+		case c.Ancestor != nil && c.Ours != nil && c.Theirs != nil:
+			c.Type = Content
+		case c.Ancestor != nil:
+			c.Type = ModifyDelete
+		default:
+			c.Type = AddAdd
+		}
+		conflicts = append(conflicts, c)
+	}
+	sort.SliceStable(conflicts, func(i, j int) bool {
+		return conflicts[i].Name < conflicts[j].Name
+	})
+	return conflicts
+}
+
+// hasDirectory returns whether the sorted names have a path under the directory.
+func hasDirectory(names []string, dir string) bool {
+	prefix := dir + "/"
+	i := sort.SearchStrings(names, prefix)
+	return i < len(names) && strings.HasPrefix(names[i], prefix)
+}
+
+// onlyStage returns whether the path has only the stage.
+func onlyStage(stages *[3]*index.Entry, stage index.ConflictFlag) bool {
+	for i, entry := range stages {
+		if (entry != nil) != (index.ConflictFlag(i+1) == stage) {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolver is a type holding entries of the index and resolving conflicts in them.
+// resolutions are applied to the index only, files in the working tree aren't changed.
+type Resolver struct {
+	gitDir      string
+	entries     []*index.Entry
+	resolveUndo map[string]*index.ResolveUndo
+}
+
+// NewResolver creates a Resolver of the index of the repository.
+// gitDir of parameters must be path to .git directory.
+func NewResolver(gitDir string) (*Resolver, error) {
+	idx, err := index.ReadIndexFrom(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewResolverFromIndex(gitDir, idx)
+}
+
+// OpenResolver creates a Resolver of the index of current repository.
+func OpenResolver() (*Resolver, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewResolver(gitDir)
+}
+
+// NewResolverFromIndex creates a Resolver of the index, e.g. the index of the result of merging.
+// gitDir of parameters must be path to .git directory, contents of resolutions are written to it.
+func NewResolverFromIndex(gitDir string, idx index.Index) (*Resolver, error) {
+	entries, err := readEntries(idx)
+	if err != nil {
+		return nil, err
+	}
+	r := &Resolver{
+		gitDir:      gitDir,
+		entries:     entries,
+		resolveUndo: map[string]*index.ResolveUndo{},
+	}
+	for _, record := range idx.ResolveUndo() {
+		r.resolveUndo[record.Name] = record
+	}
+	return r, nil
+}
+
+// Conflicts returns conflicts left in the index in order of their names.
+func (r *Resolver) Conflicts() []*Conflict {
+	return list(r.entries)
+}
+
+// Conflict returns the conflict of the path, the path may be any of paths of the conflict.
+// it returns ErrNotConflicting if the path doesn't conflict.
+func (r *Resolver) Conflict(name string) (*Conflict, error) {
+	for _, c := range r.Conflicts() {
+		if contains(c.Paths(), name) {
+			return c, nil
+		}
+	}
+	return nil, ErrNotConflicting
+}
+
+// Choose resolves the conflict by taking the stage like $ git checkout --ours <path> && git add <path>.
+// stage must be one of index.LowestCommonAncestorCommit, index.CurrentCommit and index.AnotherCommit.
+// if the conflict doesn't have the stage, the path is deleted.
+// the entry is placed at its own path, so a rename/rename conflict is resolved to the path of the side.
+func (r *Resolver) Choose(c *Conflict, stage index.ConflictFlag) error {
+	if stage < index.LowestCommonAncestorCommit || index.AnotherCommit < stage {
+		return ErrInvalidStage
+	}
+	var entry *index.Entry
+	if chosen := c.Entry(stage); chosen != nil {
+		e := *chosen
+		e.ConflictFlag = index.NoConflict
+		entry = &e
+	}
+	return r.resolve(c, entry)
+}
+
+// ResolveContent resolves the conflict by the content like editing <path> && git add <path>.
+// name is the path of the resolved file, it's usually Name of the conflict and may be a destination of rename/rename.
+// the mode is taken from the stage at the path, ours is preferred.
+func (r *Resolver) ResolveContent(c *Conflict, name string, content []byte) error {
+	digest, err := object.WriteObject(r.gitDir, object.NewBlobFromBytes(content))
+	if err != nil {
+		return err
+	}
+	var mode *index.Entry
+	for _, entry := range []*index.Entry{c.Ours, c.Theirs, c.Ancestor} {
+		if entry == nil {
+			continue
+		}
+		if mode == nil || entry.Name == name && mode.Name != name {
+			mode = entry
+		}
+	}
+	entry := &index.Entry{
+		ObjectType: index.RegularFile,
+		Permission: 0644,
+		Size:       uint32(len(content)),
+		Digest:     digest,
+		Name:       name,
+	}
+	if mode != nil {
+		entry.ObjectType, entry.Permission = mode.ObjectType, mode.Permission
+	}
+	return r.resolve(c, entry)
+}
+
+// resolve replaces stages of the conflict with the entry, nil entry means the path is deleted.
+// stages of each path are recorded in REUC extension.
+func (r *Resolver) resolve(c *Conflict, entry *index.Entry) error {
+	paths := c.Paths()
+	entries := []*index.Entry{}
+	records := map[string]*index.ResolveUndo{}
+	for _, e := range r.entries {
+		if e.ConflictFlag == index.NoConflict || !contains(paths, e.Name) {
+			entries = append(entries, e)
+			continue
+		}
+		record, ok := records[e.Name]
+		if !ok {
+			record = &index.ResolveUndo{Name: e.Name}
+			records[e.Name] = record
+		}
+		record.Modes[e.ConflictFlag-1] = uint32(e.ObjectType)<<12 | uint32(e.Permission)
+		record.Digests[e.ConflictFlag-1] = e.Digest
+	}
+	if len(records) == 0 {
+		return ErrNotConflicting
+	}
+	if entry != nil {
+		removed := entries[:0]
+		for _, e := range entries {
+			if e.Name != entry.Name {
+				removed = append(removed, e)
+			}
+		}
+		entries = append(removed, entry)
+	}
+	r.entries = entries
+	for name, record := range records {
+		r.resolveUndo[name] = record
+	}
+	return nil
+}
+
+// Unresolve recreates the conflict of the path from REUC extension like $ git update-index --unresolve <path>.
+// it returns ErrNoResolveUndo if the path doesn't have a record of the resolved conflict.
+func (r *Resolver) Unresolve(name string) error {
+	record, ok := r.resolveUndo[name]
+	if !ok {
+		return ErrNoResolveUndo
+	}
+	entries := []*index.Entry{}
+	for _, e := range r.entries {
+		if e.Name != name {
+			entries = append(entries, e)
+		}
+	}
+	for i, mode := range record.Modes {
+		if mode == 0 {
+			continue
+		}
+		entries = append(entries, &index.Entry{
+			ObjectType:   index.ObjectType(mode >> 12 & 0xF),
+			Permission:   uint16(mode & 0x1FF),
+			Digest:       record.Digests[i],
+			ConflictFlag: index.ConflictFlag(i + 1),
+			Name:         name,
+		})
+	}
+	r.entries = entries
+	delete(r.resolveUndo, name)
+	return nil
+}
+
+// Index returns the index having the resolutions and REUC extension.
+func (r *Resolver) Index() index.Index {
+	records := make([]*index.ResolveUndo, 0, len(r.resolveUndo))
+	for _, record := range r.resolveUndo {
+		records = append(records, record)
+	}
+	return index.NewIndexWithResolveUndo(r.entries, records)
+}
+
+// Write writes the index having the resolutions to .git/index of the repository.
+func (r *Resolver) Write() error {
+	return index.WriteIndex(r.gitDir, r.Index())
+}
+
+// readEntries returns all entries of the index.
+func readEntries(idx index.Index) ([]*index.Entry, error) {
+	entries := make([]*index.Entry, idx.Header().NumOfEntries)
+	for i := range entries {
+		entry, err := idx.Entries(uint32(i))
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
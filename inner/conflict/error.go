@@ -0,0 +1,9 @@
+package conflict
+
+import "errors"
+
+var (
+	ErrNotConflicting = errors.New("path is not conflicting")
+	ErrInvalidStage   = errors.New("stage must be 1, 2 or 3")
+	ErrNoResolveUndo  = errors.New("path doesn't have a record of the resolved conflict")
+)
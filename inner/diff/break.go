@@ -0,0 +1,134 @@
+package diff
+
+import "github.com/shumon84/mogit/inner/object"
+
+// minimumBreakSize is the minimum size of contents to break a modification.
+const minimumBreakSize = 400
+
+// breakPairs breaks modifications rewritten heavily into deletions and creations,
+// like diffcore_break of git.
+// broken halves are candidates of renames, and they are merged again by mergeBroken if they survive.
+// the score of broken halves is 0 if the dissimilarity is less than MergeScore.
+func (c *diffcore) breakPairs(queue []*pair) ([]*pair, error) {
+	result := []*pair{}
+	for _, p := range queue {
+		if p.unmerged == nil && p.one != nil && p.two != nil && isBlob(p.one.Mode) && isBlob(p.two.Mode) {
+			score, ok, err := c.shouldBreak(p.one, p.two)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				if score < c.options.MergeScore {
+					score = 0
+				}
+				result = append(result,
+					&pair{one: p.one, score: score, broken: true},
+					&pair{two: p.two, score: score, broken: true})
+				continue
+			}
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// shouldBreak reports whether the modification should be broken, and returns its dissimilarity.
+// the dissimilarity is the ratio of bytes removed from src.
+// a modification is broken if it removes much of src, or it has large changes
+// counting both removed bytes and added bytes, but not if it just removes much without adding.
+func (c *diffcore) shouldBreak(src, dst *Entry) (int, bool, error) {
+	if isRegular(src.Mode) != isRegular(dst.Mode) {
+		return MaxScore, true, nil
+	}
+	if string(src.Digest) == string(dst.Digest) {
+		return 0, false, nil
+	}
+	srcData, err := c.reader.read(src)
+	if err != nil {
+		return 0, false, err
+	}
+	dstData, err := c.reader.read(dst)
+	if err != nil {
+		return 0, false, err
+	}
+	srcSize, dstSize := len(srcData), len(dstData)
+	maxSize := srcSize
+	if maxSize < dstSize {
+		maxSize = dstSize
+	}
+	if maxSize < minimumBreakSize || srcSize == 0 {
+		return 0, false, nil
+	}
+	srcHashes, err := c.hashChunks(src, srcData)
+	if err != nil {
+		return 0, false, err
+	}
+	dstHashes, err := c.hashChunks(dst, dstData)
+	if err != nil {
+		return 0, false, err
+	}
+	copied, added := countChanges(srcHashes, dstHashes)
+	if srcSize < copied {
+		copied = srcSize
+	}
+	if dstSize < added+copied {
+		if copied < dstSize {
+			added = dstSize - copied
+		} else {
+			added = 0
+		}
+	}
+	removed := srcSize - copied
+
+	score := removed * MaxScore / srcSize
+	if score > c.options.BreakScore {
+		return score, true, nil
+	}
+	if (removed+added)*MaxScore/maxSize < c.options.BreakScore {
+		return score, false, nil
+	}
+	if srcSize*c.options.BreakScore < removed*MaxScore && added*20 < removed && added*20 < copied {
+		return score, false, nil
+	}
+	return score, true, nil
+}
+
+// mergeBroken merges broken halves surviving rename detection into modifications.
+// the source of the merged pair stays, so it is counted as a user of the source.
+func mergeBroken(queue []*pair, used map[*Entry]int) []*pair {
+	result := []*pair{}
+	merged := map[*pair]bool{}
+	for i, p := range queue {
+		if merged[p] {
+			continue
+		}
+		if !p.broken {
+			result = append(result, p)
+			continue
+		}
+		var peer *pair
+		for _, q := range queue[i+1:] {
+			if q.broken && !merged[q] && q.path() == p.path() {
+				peer = q
+				break
+			}
+		}
+		if peer == nil {
+			result = append(result, p)
+			continue
+		}
+		merged[peer] = true
+		deletion, creation := p, peer
+		if p.one == nil {
+			deletion, creation = peer, p
+		}
+		result = append(result, &pair{one: deletion.one, two: creation.two, score: p.score})
+		used[deletion.one]++
+	}
+	return result
+}
+
+// isBlob reports whether the mode is a blob, which is a regular file or a symbolic link.
+func isBlob(mode object.FileMode) bool {
+	return mode&0170000 == object.ModeRegular&0170000 || mode == object.ModeSymlink
+}
@@ -0,0 +1,152 @@
+package diff
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/shumon84/mogit/inner/attributes"
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/xdiff"
+)
+
+// contentReader is a type to read contents of entries.
+// contents of entries in the working tree are read from the files,
+// and the others are read from the object database.
+type contentReader struct {
+	gitDir    string
+	workDir   string
+	config    *config.Config
+	converter *attributes.Converter
+	contents  map[*Entry][]byte                 // cache of contents
+	blobs     func(name string) ([]byte, error) // reader of blobs in the index, it's made on demand
+}
+
+func newContentReader(gitDir string) (*contentReader, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	converter, err := attributes.NewConverter(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	r := &contentReader{
+		gitDir:    gitDir,
+		workDir:   filepath.Dir(gitDir),
+		config:    cfg,
+		converter: converter,
+		contents:  map[*Entry][]byte{},
+	}
+	converter.IndexBlob = r.indexBlob
+	return r, nil
+}
+
+// indexBlob returns content of the blob of the path in the index for the converter.
+// the index is read when it is needed first.
+func (r *contentReader) indexBlob(name string) ([]byte, error) {
+	if r.blobs == nil {
+		entries := []*index.Entry{}
+		if _, err := os.Stat(filepath.Join(r.gitDir, "index")); err == nil {
+			idx, err := index.ReadIndexFrom(r.gitDir)
+			if err != nil {
+				return nil, err
+			}
+			for i := uint32(0); i < idx.Header().NumOfEntries; i++ {
+				entry, err := idx.Entries(i)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, entry)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		r.blobs = index.BlobReader(r.gitDir, entries)
+	}
+	return r.blobs(name)
+}
+
+func (r *contentReader) close() error {
+	return r.converter.Close()
+}
+
+// read returns content of the entry, it is empty if the entry is nil.
+// content of git link is the commit like "Subproject commit <digest>".
+func (r *contentReader) read(e *Entry) ([]byte, error) {
+	if e == nil {
+		return []byte{}, nil
+	}
+	if data, ok := r.contents[e]; ok {
+		return data, nil
+	}
+	data, err := r.readEntry(e)
+	if err != nil {
+		return nil, err
+	}
+	r.contents[e] = data
+	return data, nil
+}
+
+func (r *contentReader) readEntry(e *Entry) ([]byte, error) {
+	switch {
+	case e.Mode == object.ModeGitLink:
+		return []byte(fmt.Sprintf("Subproject commit %x\n", e.Digest)), nil
+	case e.Worktree:
+		filePath := filepath.Join(r.workDir, filepath.FromSlash(e.Path))
+		if e.Mode == object.ModeSymlink {
+			target, err := os.Readlink(filePath)
+			if err != nil {
+				return nil, err
+			}
+			return []byte(filepath.ToSlash(target)), nil
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return r.converter.ToGit(e.Path, data)
+	}
+	obj, err := object.ReadObjectFrom(r.gitDir, e.Digest)
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := obj.(*object.Blob)
+	if !ok {
+		return nil, object.ErrUnexpectedType
+	}
+	return blob.Content()
+}
+
+// driver returns diff attribute of the path.
+func (r *contentReader) driver(name string) (attributes.Value, error) {
+	values, err := r.converter.Attributes().Check(name, "diff")
+	if err != nil {
+		return attributes.Value{}, err
+	}
+	return values[0], nil
+}
+
+// isBinary reports whether content of the path is binary.
+// "-diff" attribute means binary, "diff" means text, and diff.<driver>.binary config is used for a driver.
+// otherwise content having NUL is binary.
+func (r *contentReader) isBinary(name string, data []byte) (bool, error) {
+	driver, err := r.driver(name)
+	if err != nil {
+		return false, err
+	}
+	switch driver.State {
+	case attributes.Set:
+		return false, nil
+	case attributes.Unset:
+		return true, nil
+	case attributes.Valued:
+		if value, ok := r.config.Get("diff." + driver.Text + ".binary"); ok {
+			return config.ParseBool(value)
+		}
+	}
+	return xdiff.IsBinary(data), nil
+}
@@ -0,0 +1,183 @@
+// diff is a package to compute changes between trees, the index and the working tree like $ git diff --raw
+//
+// Each side of a comparison is one of the following.
+//
+//  tree     - a tree object, or a commit object meaning its tree
+//  index    - entries at stage 0 of .git/index
+//  worktree - files in the working tree of tracked paths
+//
+// Changes are reported with the following types.
+//
+//  A = added              D = deleted            M = modified
+//  M = mode changed       T = file type changed  U = unmerged
+//  R = renamed            C = copied
+//
+// Renames and copies are detected like $ git diff -M -C
+// Paths having the same content are paired first, and then the rest are paired by similarity of their contents.
+// Similarity is the ratio of bytes of the new content copied from the old content,
+// which are counted by hash values of chunks of lines.
+//
+// If you want to know more about diff, please refer to
+// https://git-scm.com/docs/git-diff#_raw_output_format
+package diff
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/pathspec"
+)
+
+// ChangeType is a type representing kind of change.
+type ChangeType int
+
+// constants of ChangeType
+const (
+	Added       ChangeType = iota // the path exists only in the new side
+	Deleted                       // the path exists only in the old side
+	Modified                      // the content is changed
+	ModeChanged                   // only the executable bit is changed
+	TypeChanged                   // file type is changed, e.g. from regular file to symbolic link
+	Unmerged                      // the path is unmerged in the index
+	Renamed                       // the path is moved to another path
+	Copied                        // the path is copied to another path
+
+	unmodified ChangeType = -1 // the path isn't changed, it's used only as a source of copies
+)
+
+// String is implementation of fmt.Stringer interface.
+// it returns the status letter like $ git diff --name-status
+func (t ChangeType) String() string {
+	switch t {
+	case Added:
+		return "A"
+	case Deleted:
+		return "D"
+	case Modified, ModeChanged:
+		return "M"
+	case TypeChanged:
+		return "T"
+	case Unmerged:
+		return "U"
+	case Renamed:
+		return "R"
+	case Copied:
+		return "C"
+	default:
+		return "X"
+	}
+}
+
+// Entry is a type representing one side of a change.
+type Entry struct {
+	Path     string          // path relative from top level directory
+	Mode     object.FileMode // mode of the entry
+	Digest   []byte          // SHA1 digest of the blob, or the commit of git link
+	Worktree bool            // the content is in the working tree, it may not exist in the object database
+}
+
+// Change is a type representing a change of a path.
+type Change struct {
+	Type  ChangeType
+	From  *Entry // entry of the old side, nil if Type is Added
+	To    *Entry // entry of the new side, nil if Type is Deleted
+	Score int    // similarity of Renamed and Copied, or dissimilarity of rewritten Modified, in units of MaxScore
+}
+
+// unmerged returns the change of the unmerged path.
+// from and to are entries of the both sides, nil is replaced with an entry having only the path,
+// like $ git diff --raw
+func unmerged(name string, from, to *Entry) *Change {
+	if from == nil {
+		from = &Entry{Path: name}
+	}
+	if to == nil {
+		to = &Entry{Path: name}
+	}
+	return &Change{Type: Unmerged, From: from, To: to}
+}
+
+// Path returns the path of the change.
+func (c *Change) Path() string {
+	if c.To != nil {
+		return c.To.Path
+	}
+	return c.From.Path
+}
+
+// String is implementation of fmt.Stringer interface.
+// it returns the same format as $ git diff --raw --no-abbrev
+//
+//  :<old mode> <new mode> <old digest> <new digest> <type>[<score>]\t<path>
+//  :<old mode> <new mode> <old digest> <new digest> <type><score>\t<old path>\t<new path>
+func (c *Change) String() string {
+	status := c.Type.String()
+	if c.Score != 0 {
+		status += fmt.Sprintf("%03d", percent(c.Score))
+	}
+	name := c.Path()
+	if c.Type == Renamed || c.Type == Copied {
+		name = c.From.Path + "\t" + c.To.Path
+	}
+	return fmt.Sprintf(":%s %s %x %x %s\t%s",
+		entryMode(c.From), entryMode(c.To), entryDigest(c.From), entryDigest(c.To), status, name)
+}
+
+func entryMode(e *Entry) object.FileMode {
+	if e == nil {
+		return 0
+	}
+	return e.Mode
+}
+
+func entryDigest(e *Entry) []byte {
+	if e == nil || e.Digest == nil {
+		return make([]byte, object.DigestSize)
+	}
+	return e.Digest
+}
+
+// Options is a type representing options of diff.
+// scores are in units of MaxScore, 0 means the default score.
+type Options struct {
+	Pathspec         *pathspec.Pathspec // paths to compare, nil means all paths
+	DetectRenames    bool               // pair deleted paths and added paths as renames like -M
+	DetectCopies     bool               // also pair modified paths and added paths as copies like -C, it implies DetectRenames
+	FindCopiesHarder bool               // also pair unmodified paths as copies like --find-copies-harder, it implies DetectCopies
+	RenameScore      int                // minimum similarity of renames and copies
+	RenameLimit      int                // limit of the number of paths for inexact renames, 0 means diff.renameLimit and negative means no limit
+	BreakRewrites    bool               // break rewritten paths into deletion and addition like -B
+	BreakScore       int                // minimum dissimilarity to break a modification
+	MergeScore       int                // minimum dissimilarity to show a broken modification as rewrite
+
+	// RelevantSources limits sources of renames other than exact renames to these paths, nil means all paths.
+	// merging trees needs renames of only paths whose content is changed on the other side.
+	RelevantSources map[string]bool
+}
+
+// compare returns the change from one entry to another, or false if they are the same.
+func compare(from, to *Entry) (*Change, bool) {
+	switch {
+	case from == nil && to == nil:
+		return nil, false
+	case from == nil:
+		return &Change{Type: Added, To: to}, true
+	case to == nil:
+		return &Change{Type: Deleted, From: from}, true
+	case from.Mode&0170000 != to.Mode&0170000:
+		return &Change{Type: TypeChanged, From: from, To: to}, true
+	case string(from.Digest) != string(to.Digest):
+		return &Change{Type: Modified, From: from, To: to}, true
+	case from.Mode != to.Mode:
+		return &Change{Type: ModeChanged, From: from, To: to}, true
+	}
+	return nil, false
+}
+
+// sortChanges sorts changes by path in the same order as git.
+func sortChanges(changes []*Change) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path() < changes[j].Path()
+	})
+}
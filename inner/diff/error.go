@@ -0,0 +1,7 @@
+package diff
+
+import "errors"
+
+var (
+	ErrInvalidScore = errors.New("invalid score")
+)
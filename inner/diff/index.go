@@ -0,0 +1,101 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// TreeToIndex computes changes from a tree to the index like $ git diff --cached
+// tree is a digest of a tree object or a commit object, nil means the empty tree.
+// intent-to-add entries aren't staged yet, so they are regarded as not in the index like git.
+func TreeToIndex(gitDir string, tree []byte, options *Options) ([]*Change, error) {
+	d := newDiffer(gitDir, options)
+	idx, err := d.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	fromEntries := map[string]*Entry{}
+	fromNames := []string{}
+	err = d.listTree("", tree, func(entry *Entry) {
+		fromEntries[entry.Path] = entry
+		fromNames = append(fromNames, entry.Path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range idx.entries {
+		if entry.IntentToAdd {
+			continue
+		}
+		d.add(fromEntries[entry.Name], indexEntry(entry))
+		delete(fromEntries, entry.Name)
+	}
+	for _, entry := range idx.unmerged {
+		d.changes = append(d.changes, unmerged(entry.Name, fromEntries[entry.Name], nil))
+		delete(fromEntries, entry.Name)
+	}
+	for _, name := range fromNames {
+		if entry, ok := fromEntries[name]; ok {
+			d.add(entry, nil)
+		}
+	}
+	return d.result()
+}
+
+// indexFile is a type representing entries of the index matching the pathspec.
+type indexFile struct {
+	entries  []*index.Entry // entries at stage 0
+	unmerged []*index.Entry // the first entry of each unmerged path
+	ours     []*index.Entry // entries at stage 2 of unmerged paths
+	modTime  time.Time      // modification time of the index file
+}
+
+// readIndex reads entries of the index, it is empty if there is no index file.
+func (d *differ) readIndex() (*indexFile, error) {
+	idx := &indexFile{entries: []*index.Entry{}, unmerged: []*index.Entry{}, ours: []*index.Entry{}}
+	info, err := os.Stat(filepath.Join(d.gitDir, "index"))
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	idx.modTime = info.ModTime()
+	file, err := index.ReadIndexFrom(d.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < file.Header().NumOfEntries; i++ {
+		entry, err := file.Entries(i)
+		if err != nil {
+			return nil, err
+		}
+		if !d.options.Pathspec.Match(entry.Name) {
+			continue
+		}
+		if entry.ConflictFlag == index.NoConflict {
+			idx.entries = append(idx.entries, entry)
+			continue
+		}
+		if entry.ConflictFlag == index.CurrentCommit {
+			idx.ours = append(idx.ours, entry)
+		}
+		if n := len(idx.unmerged); n == 0 || idx.unmerged[n-1].Name != entry.Name {
+			idx.unmerged = append(idx.unmerged, entry)
+		}
+	}
+	return idx, nil
+}
+
+// IndexMode returns mode of the index entry as tree entry mode.
+func IndexMode(entry *index.Entry) object.FileMode {
+	return object.FileMode(uint32(entry.ObjectType)<<12 | uint32(entry.Permission))
+}
+
+func indexEntry(entry *index.Entry) *Entry {
+	return &Entry{Path: entry.Name, Mode: IndexMode(entry), Digest: entry.Digest}
+}
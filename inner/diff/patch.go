@@ -0,0 +1,357 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/attributes"
+	"github.com/shumon84/mogit/inner/xdiff"
+)
+
+// DefaultAbbrev is the default length of abbreviated digests in index line.
+const DefaultAbbrev = 7
+
+// PatchOptions is a type representing options of patch output.
+type PatchOptions struct {
+	xdiff.Options
+	Abbrev int // length of abbreviated digests in index line, 0 means DefaultAbbrev
+}
+
+// WritePatch writes changes as patches like $ git diff
+// contents of entries in the working tree are read from the files,
+// and the others are read from the object database.
+// options may be nil, then diff.algorithm, diff.context and diff.indentHeuristic config are used.
+func WritePatch(w io.Writer, gitDir string, changes []*Change, options *PatchOptions) error {
+	p, err := newPatcher(gitDir, options)
+	if err != nil {
+		return err
+	}
+	defer p.reader.close()
+	for _, change := range changes {
+		if err := p.writeChange(w, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patcher is a type holding state while writing patches.
+type patcher struct {
+	options   PatchOptions
+	reader    *contentReader
+	funcNames map[string]func(line []byte) ([]byte, bool) // function line matchers by diff driver
+}
+
+func newPatcher(gitDir string, options *PatchOptions) (*patcher, error) {
+	reader, err := newContentReader(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	p := &patcher{
+		reader:    reader,
+		funcNames: map[string]func(line []byte) ([]byte, bool){},
+	}
+	if options != nil {
+		p.options = *options
+	} else if err := p.loadOptions(); err != nil {
+		reader.close()
+		return nil, err
+	}
+	if p.options.Abbrev <= 0 {
+		p.options.Abbrev = DefaultAbbrev
+	}
+	return p, nil
+}
+
+// loadOptions loads default options from config.
+func (p *patcher) loadOptions() error {
+	cfg := p.reader.config
+	var err error
+	if p.options.Algorithm, err = xdiff.ParseAlgorithm(cfg.GetString("diff.algorithm", "myers")); err != nil {
+		return err
+	}
+	if p.options.Context, err = cfg.Int("diff.context", xdiff.DefaultContext); err != nil {
+		return err
+	}
+	indentHeuristic, err := cfg.Bool("diff.indentheuristic", true)
+	if err != nil {
+		return err
+	}
+	p.options.NoIndentHeuristic = !indentHeuristic
+	return nil
+}
+
+func (p *patcher) writeChange(w io.Writer, change *Change) error {
+	switch change.Type {
+	case Unmerged:
+		_, err := fmt.Fprintf(w, "* Unmerged path %s\n", change.Path())
+		return err
+	case TypeChanged:
+		// a file type change is shown as deletion and creation
+		if err := p.writeChange(w, &Change{Type: Deleted, From: change.From}); err != nil {
+			return err
+		}
+		return p.writeChange(w, &Change{Type: Added, To: change.To})
+	}
+
+	fromPath, toPath := change.Path(), change.Path()
+	if change.From != nil {
+		fromPath = change.From.Path
+	}
+	header := &bytes.Buffer{}
+	mustShowHeader := false
+	fmt.Fprintf(header, "diff --git %s %s\n", quotePath("a/"+fromPath), quotePath("b/"+toPath))
+	switch {
+	case change.From == nil:
+		fmt.Fprintf(header, "new file mode %s\n", change.To.Mode)
+		mustShowHeader = true
+	case change.To == nil:
+		fmt.Fprintf(header, "deleted file mode %s\n", change.From.Mode)
+		mustShowHeader = true
+	case change.From.Mode != change.To.Mode:
+		fmt.Fprintf(header, "old mode %s\nnew mode %s\n", change.From.Mode, change.To.Mode)
+		mustShowHeader = true
+	}
+	switch {
+	case change.Type == Renamed:
+		fmt.Fprintf(header, "similarity index %d%%\nrename from %s\nrename to %s\n",
+			percent(change.Score), quotePath(fromPath), quotePath(toPath))
+		mustShowHeader = true
+	case change.Type == Copied:
+		fmt.Fprintf(header, "similarity index %d%%\ncopy from %s\ncopy to %s\n",
+			percent(change.Score), quotePath(fromPath), quotePath(toPath))
+		mustShowHeader = true
+	case change.Type == Modified && change.Score != 0:
+		fmt.Fprintf(header, "dissimilarity index %d%%\n", percent(change.Score))
+		mustShowHeader = true
+	}
+	if change.From != nil && change.To != nil && string(change.From.Digest) == string(change.To.Digest) {
+		_, err := header.WriteTo(w)
+		return err
+	}
+	fmt.Fprintf(header, "index %s..%s", p.abbrev(entryDigest(change.From)), p.abbrev(entryDigest(change.To)))
+	if change.From != nil && change.To != nil && change.From.Mode == change.To.Mode {
+		fmt.Fprintf(header, " %s", change.From.Mode)
+	}
+	header.WriteString("\n")
+
+	old, err := p.reader.read(change.From)
+	if err != nil {
+		return err
+	}
+	new, err := p.reader.read(change.To)
+	if err != nil {
+		return err
+	}
+	fromName, toName := "/dev/null", "/dev/null"
+	if change.From != nil {
+		fromName = quotePath("a/" + fromPath)
+	}
+	if change.To != nil {
+		toName = quotePath("b/" + toPath)
+	}
+	binary, err := p.isBinary(change, old, new)
+	if err != nil {
+		return err
+	}
+	if binary {
+		fmt.Fprintf(header, "Binary files %s and %s differ\n", fromName, toName)
+		_, err := header.WriteTo(w)
+		return err
+	}
+
+	var hunks []*xdiff.Hunk
+	if change.Type == Modified && change.Score != 0 {
+		hunks = rewriteHunks(old, new)
+	} else {
+		options := p.options.Options
+		if options.FuncName, err = p.funcName(change.Path()); err != nil {
+			return err
+		}
+		hunks = xdiff.Hunks(old, new, &options)
+	}
+	if len(hunks) == 0 {
+		if !mustShowHeader {
+			return nil
+		}
+		_, err := header.WriteTo(w)
+		return err
+	}
+	// names having spaces are followed by tab to be parsed unambiguously
+	fmt.Fprintf(header, "--- %s%s\n+++ %s%s\n", fromName, tabIfSpace(fromName), toName, tabIfSpace(toName))
+	if _, err := header.WriteTo(w); err != nil {
+		return err
+	}
+	for _, hunk := range hunks {
+		if _, err := hunk.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteHunks returns the hunk of a rewritten content, which removes all lines and adds all lines.
+func rewriteHunks(old, new []byte) []*xdiff.Hunk {
+	hunk := &xdiff.Hunk{OldStart: 1, NewStart: 1, Lines: []*xdiff.Line{}}
+	for _, line := range splitLines(old) {
+		hunk.Lines = append(hunk.Lines, &xdiff.Line{Op: '-', Text: line})
+		hunk.OldLines++
+	}
+	for _, line := range splitLines(new) {
+		hunk.Lines = append(hunk.Lines, &xdiff.Line{Op: '+', Text: line})
+		hunk.NewLines++
+	}
+	return []*xdiff.Hunk{hunk}
+}
+
+// splitLines splits data into lines having trailing '\n', except the last line without '\n'.
+func splitLines(data []byte) [][]byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func (p *patcher) abbrev(digest []byte) string {
+	hex := fmt.Sprintf("%x", digest)
+	if p.options.Abbrev < len(hex) {
+		return hex[:p.options.Abbrev]
+	}
+	return hex
+}
+
+// isBinary reports whether either content of the change is binary.
+func (p *patcher) isBinary(change *Change, old, new []byte) (bool, error) {
+	if change.From != nil {
+		if binary, err := p.reader.isBinary(change.From.Path, old); err != nil || binary {
+			return binary, err
+		}
+	}
+	if change.To != nil {
+		return p.reader.isBinary(change.To.Path, new)
+	}
+	return false, nil
+}
+
+// funcName returns the function line matcher of diff.<driver>.xfuncname config for the path.
+// nil means the default matcher.
+func (p *patcher) funcName(name string) (func(line []byte) ([]byte, bool), error) {
+	driver, err := p.reader.driver(name)
+	if err != nil {
+		return nil, err
+	}
+	if driver.State != attributes.Valued {
+		return nil, nil
+	}
+	if funcName, ok := p.funcNames[driver.Text]; ok {
+		return funcName, nil
+	}
+	value, ok := p.reader.config.Get("diff." + driver.Text + ".xfuncname")
+	if !ok {
+		p.funcNames[driver.Text] = nil
+		return nil, nil
+	}
+	funcName, err := compileFuncName(value)
+	if err != nil {
+		return nil, err
+	}
+	p.funcNames[driver.Text] = funcName
+	return funcName, nil
+}
+
+// compileFuncName compiles xfuncname pattern.
+// the pattern has regular expressions separated by '\n', the first matching one is used,
+// and the line doesn't match if it begins with '!'.
+// the first submatch is shown in hunk header if it exists, otherwise the whole match is shown.
+func compileFuncName(pattern string) (func(line []byte) ([]byte, bool), error) {
+	type funcRegexp struct {
+		re     *regexp.Regexp
+		negate bool
+	}
+	regexps := []*funcRegexp{}
+	for _, expr := range strings.Split(pattern, "\n") {
+		negate := strings.HasPrefix(expr, "!")
+		if negate {
+			expr = expr[1:]
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		regexps = append(regexps, &funcRegexp{re: re, negate: negate})
+	}
+	return func(line []byte) ([]byte, bool) {
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		for _, r := range regexps {
+			match := r.re.FindSubmatchIndex(line)
+			if match == nil {
+				continue
+			}
+			if r.negate {
+				return nil, false
+			}
+			if len(match) >= 4 && match[2] >= 0 {
+				return line[match[2]:match[3]], true
+			}
+			return line[match[0]:match[1]], true
+		}
+		return nil, false
+	}, nil
+}
+
+func tabIfSpace(name string) string {
+	if strings.Contains(name, " ") {
+		return "\t"
+	}
+	return ""
+}
+
+// quotePath quotes the path like core.quotePath if it has special characters.
+func quotePath(name string) string {
+	needsQuote := false
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < 0x20 || c == '"' || c == '\\' || c >= 0x7f {
+			needsQuote = true
+			break
+		}
+	}
+	if !needsQuote {
+		return name
+	}
+	buf := &strings.Builder{}
+	buf.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; c {
+		case '\a':
+			buf.WriteString(`\a`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\v':
+			buf.WriteString(`\v`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(buf, "\\%03o", c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
@@ -0,0 +1,541 @@
+package diff
+
+import (
+	"path"
+	"sort"
+
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// DefaultRenameLimit is the default limit of the number of paths for inexact renames.
+const DefaultRenameLimit = 1000
+
+// candidatesPerDst is the number of candidates of sources kept for each destination.
+const candidatesPerDst = 4
+
+// pair is a type representing a pair of entries while detecting renames and rewrites,
+// same as diff_filepair of git.
+type pair struct {
+	one, two   *Entry  // entries of the both sides, nil means the path doesn't exist on the side
+	score      int     // similarity of renamed pair, or dissimilarity of broken pair
+	broken     bool    // the pair is a half of a broken modification
+	renamed    bool    // the pair is made by rename detection
+	unmodified bool    // the pair isn't changed
+	unmerged   *Change // the change of the unmerged path
+}
+
+// path returns the path of the pair, broken halves have the same path as their peers.
+func (p *pair) path() string {
+	if p.two != nil {
+		return p.two.Path
+	}
+	return p.one.Path
+}
+
+// renameDst is a type representing a destination of renames.
+type renameDst struct {
+	pair     *pair // the creation, it's replaced with the renamed pair once a source is found
+	isRename bool
+}
+
+// candidate is a type representing a pair of a source and a destination having similarity.
+type candidate struct {
+	dst, src  int // indexes of the destination and the source, dst is negative if unused
+	score     int
+	nameScore int // 1 if they have the same base name
+}
+
+// diffcore is a type holding state while detecting renames and rewrites, same as diffcore of git.
+type diffcore struct {
+	options Options
+	reader  *contentReader
+	used    map[*Entry]int            // the number of users of each source, the path stays if it is more than the renames
+	hashes  map[*Entry]map[uint32]int // cache of hashChunks
+}
+
+func newDiffcore(gitDir string, options Options) (*diffcore, error) {
+	reader, err := newContentReader(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	c := &diffcore{
+		options: options,
+		reader:  reader,
+		used:    map[*Entry]int{},
+		hashes:  map[*Entry]map[uint32]int{},
+	}
+	if c.options.RenameScore == 0 {
+		c.options.RenameScore = DefaultRenameScore
+	}
+	if c.options.BreakScore == 0 {
+		c.options.BreakScore = DefaultBreakScore
+	}
+	if c.options.MergeScore == 0 {
+		c.options.MergeScore = DefaultMergeScore
+	}
+	if c.options.RenameLimit == 0 {
+		if c.options.RenameLimit, err = reader.config.Int("diff.renamelimit", DefaultRenameLimit); err != nil {
+			reader.close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *diffcore) close() error {
+	return c.reader.close()
+}
+
+// run detects renames, copies and rewrites in changes sorted by path.
+// rewritten modifications are broken first, renames are detected,
+// and then broken halves which are not renamed are merged again.
+func (c *diffcore) run(changes []*Change) ([]*Change, error) {
+	queue := make([]*pair, len(changes))
+	for i, change := range changes {
+		queue[i] = &pair{one: change.From, two: change.To}
+		switch change.Type {
+		case Unmerged:
+			queue[i].unmerged = change
+		case unmodified:
+			queue[i].unmodified = true
+		}
+	}
+	var err error
+	if c.options.BreakRewrites {
+		if queue, err = c.breakPairs(queue); err != nil {
+			return nil, err
+		}
+	}
+	if c.options.DetectRenames {
+		if queue, err = c.detectRenames(queue); err != nil {
+			return nil, err
+		}
+	}
+	if c.options.BreakRewrites {
+		queue = mergeBroken(queue, c.used)
+	}
+
+	result := []*Change{}
+	for _, p := range queue {
+		if change, ok := c.resolve(p); ok {
+			result = append(result, change)
+		}
+	}
+	return result, nil
+}
+
+// resolve decides the type of change of the pair, or returns false if it isn't changed.
+// if a source of renames is used by other pairs, the pair is a copy.
+func (c *diffcore) resolve(p *pair) (*Change, bool) {
+	switch {
+	case p.unmerged != nil:
+		return p.unmerged, true
+	case p.one == nil:
+		return &Change{Type: Added, To: p.two}, true
+	case p.two == nil:
+		return &Change{Type: Deleted, From: p.one}, true
+	case p.one.Mode&0170000 != p.two.Mode&0170000:
+		return &Change{Type: TypeChanged, From: p.one, To: p.two, Score: p.score}, true
+	case p.renamed && p.one.Path != p.two.Path:
+		c.used[p.one]--
+		if c.used[p.one] > 0 {
+			return &Change{Type: Copied, From: p.one, To: p.two, Score: p.score}, true
+		}
+		return &Change{Type: Renamed, From: p.one, To: p.two, Score: p.score}, true
+	}
+	change, ok := compare(p.one, p.two)
+	if !ok {
+		return nil, false
+	}
+	change.Score = p.score
+	return change, true
+}
+
+// detectRenames pairs deletions and creations as renames, like diffcore_rename of git.
+// modifications are also sources of copies if DetectCopies is given.
+func (c *diffcore) detectRenames(queue []*pair) ([]*pair, error) {
+	minimum := c.options.RenameScore
+	copies := c.options.DetectCopies
+	hasBroken := false
+	srcs := []*pair{}
+	dsts := []*renameDst{}
+	dstOf := map[*pair]*renameDst{}
+	dstByPath := map[string]*renameDst{}
+	for _, p := range queue {
+		switch {
+		case p.unmerged != nil:
+		case p.one == nil:
+			dst := &renameDst{pair: p}
+			dsts = append(dsts, dst)
+			dstOf[p] = dst
+			dstByPath[p.two.Path] = dst
+		case p.two == nil:
+			// a broken deletion not to be rewritten means the source stays
+			if p.broken {
+				hasBroken = true
+				if p.score == 0 {
+					c.used[p.one]++
+				}
+			}
+			srcs = append(srcs, p)
+		case copies:
+			c.used[p.one]++
+			srcs = append(srcs, p)
+		}
+	}
+	if len(dsts) > 0 && len(srcs) > 0 {
+		if err := c.findRenames(srcs, dsts, minimum, copies, hasBroken); err != nil {
+			return nil, err
+		}
+	}
+
+	result := []*pair{}
+	for _, p := range queue {
+		switch {
+		case p.unmerged != nil:
+			result = append(result, p)
+		case p.one == nil:
+			result = append(result, dstOf[p].pair)
+		case p.two == nil:
+			// the deletion disappears if the path is renamed,
+			// or the broken creation of the path is connected to a source
+			if p.broken {
+				if dst, ok := dstByPath[p.one.Path]; ok && dst.isRename {
+					continue
+				}
+			} else if c.used[p.one] > 0 {
+				continue
+			}
+			result = append(result, p)
+		case !p.unmodified:
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+// findRenames finds sources of destinations.
+// sources having the same content are searched first,
+// then sources having the same base name, and then all sources by similarity.
+func (c *diffcore) findRenames(srcs []*pair, dsts []*renameDst, minimum int, copies, hasBroken bool) error {
+	renames := c.findExactRenames(srcs, dsts)
+	if minimum == MaxScore {
+		return nil
+	}
+	// sources used by renames are dropped unless they may be used again by copies or broken pairs
+	if !copies && !hasBroken {
+		srcs = c.unusedSources(srcs)
+		basenameScore := minimum + (MaxScore-minimum)/2
+		n, err := c.findBasenameRenames(srcs, dsts, basenameScore)
+		if err != nil {
+			return err
+		}
+		renames += n
+		srcs = c.unusedSources(srcs)
+	}
+	if c.options.RelevantSources != nil {
+		relevant := []*pair{}
+		for _, src := range srcs {
+			if c.relevant(src) {
+				relevant = append(relevant, src)
+			}
+		}
+		srcs = relevant
+	}
+
+	numDsts := len(dsts) - renames
+	if numDsts == 0 || len(srcs) == 0 {
+		return nil
+	}
+	skipUnmodified := false
+	switch c.tooManyCandidates(srcs, numDsts) {
+	case 1:
+		return nil
+	case 2:
+		skipUnmodified = true
+	}
+
+	matrix := make([]*candidate, 0, numDsts*candidatesPerDst)
+	for i, dst := range dsts {
+		if dst.isRename {
+			continue
+		}
+		m := make([]*candidate, candidatesPerDst)
+		for j := range m {
+			m[j] = &candidate{dst: -1}
+		}
+		for j, src := range srcs {
+			if skipUnmodified && src.unmodified {
+				continue
+			}
+			score, err := c.estimateSimilarity(src.one, dst.pair.two, minimum)
+			if err != nil {
+				return err
+			}
+			recordIfBetter(m, &candidate{dst: i, src: j, score: score, nameScore: basenameSame(src.one.Path, dst.pair.two.Path)})
+		}
+		matrix = append(matrix, m...)
+	}
+	sort.SliceStable(matrix, func(i, j int) bool {
+		return compareCandidates(matrix[i], matrix[j]) < 0
+	})
+	c.pickCandidates(srcs, dsts, matrix, minimum, false)
+	if copies {
+		c.pickCandidates(srcs, dsts, matrix, minimum, true)
+	}
+	return nil
+}
+
+// record connects the source to the destination.
+// the score of a broken pair connected to itself is the dissimilarity of the pair.
+func (c *diffcore) record(dst *renameDst, src *pair, score int) {
+	c.used[src.one]++
+	p := &pair{one: src.one, two: dst.pair.two, renamed: true, score: score}
+	if src.one.Path == dst.pair.two.Path {
+		p.score = src.score
+	}
+	dst.pair = p
+	dst.isRename = true
+}
+
+// unusedSources returns sources which are not used by renames.
+func (c *diffcore) unusedSources(srcs []*pair) []*pair {
+	unused := []*pair{}
+	for _, src := range srcs {
+		if c.used[src.one] == 0 {
+			unused = append(unused, src)
+		}
+	}
+	return unused
+}
+
+// relevant returns whether the source may be used by renames other than exact renames.
+func (c *diffcore) relevant(src *pair) bool {
+	return c.options.RelevantSources == nil || c.options.RelevantSources[src.one.Path]
+}
+
+// findExactRenames connects destinations with sources having the same content.
+// sources not used yet and sources having the same base name are preferred.
+// files other than regular files must have the same mode.
+func (c *diffcore) findExactRenames(srcs []*pair, dsts []*renameDst) int {
+	renames := 0
+	for _, dst := range dsts {
+		target := dst.pair.two
+		var best *pair
+		bestScore := -1
+		tries := 100
+		for _, src := range srcs {
+			source := src.one
+			if string(source.Digest) != string(target.Digest) {
+				continue
+			}
+			if (!isRegular(source.Mode) || !isRegular(target.Mode)) && source.Mode != target.Mode {
+				continue
+			}
+			if c.used[source] > 0 && !c.options.DetectCopies {
+				continue
+			}
+			score := basenameSame(source.Path, target.Path)
+			if c.used[source] == 0 {
+				score++
+			}
+			if score > bestScore {
+				best = src
+				bestScore = score
+				if score == 2 {
+					break
+				}
+			}
+			// too many identical alternatives, pick one
+			tries--
+			if tries == 0 {
+				break
+			}
+		}
+		if best != nil {
+			c.record(dst, best, MaxScore)
+			renames++
+		}
+	}
+	return renames
+}
+
+// findBasenameRenames connects destinations with sources having the same base name.
+// only base names which are unique in both sources and destinations are used,
+// and they must be more similar than minimum.
+func (c *diffcore) findBasenameRenames(srcs []*pair, dsts []*renameDst, minimum int) (int, error) {
+	srcIndexes := map[string]int{}
+	for i, src := range srcs {
+		base := path.Base(src.one.Path)
+		if _, ok := srcIndexes[base]; ok {
+			srcIndexes[base] = -1
+		} else {
+			srcIndexes[base] = i
+		}
+	}
+	dstIndexes := map[string]int{}
+	for i, dst := range dsts {
+		if dst.isRename {
+			continue
+		}
+		base := path.Base(dst.pair.two.Path)
+		if _, ok := dstIndexes[base]; ok {
+			dstIndexes[base] = -1
+		} else {
+			dstIndexes[base] = i
+		}
+	}
+
+	renames := 0
+	for base, i := range srcIndexes {
+		j, ok := dstIndexes[base]
+		if i < 0 || !ok || j < 0 || !c.relevant(srcs[i]) {
+			continue
+		}
+		score, err := c.estimateSimilarity(srcs[i].one, dsts[j].pair.two, minimum)
+		if err != nil {
+			return 0, err
+		}
+		if score < minimum {
+			continue
+		}
+		c.record(dsts[j], srcs[i], score)
+		renames++
+	}
+	return renames, nil
+}
+
+// tooManyCandidates reports whether the matrix of sources and destinations is larger than the limit.
+// it returns 0 if not, 2 if it's not too large without unmodified sources, or 1 otherwise.
+func (c *diffcore) tooManyCandidates(srcs []*pair, numDsts int) int {
+	limit := c.options.RenameLimit
+	if limit <= 0 || numDsts*len(srcs) <= limit*limit {
+		return 0
+	}
+	if !c.options.FindCopiesHarder {
+		return 1
+	}
+	numSrcs := 0
+	for _, src := range srcs {
+		if !src.unmodified {
+			numSrcs++
+		}
+	}
+	if numDsts*numSrcs <= limit*limit {
+		return 2
+	}
+	return 1
+}
+
+// pickCandidates connects destinations with the most similar candidates.
+// sources used once are not used again unless copies is true.
+func (c *diffcore) pickCandidates(srcs []*pair, dsts []*renameDst, matrix []*candidate, minimum int, copies bool) {
+	for _, m := range matrix {
+		if m.dst < 0 || m.score < minimum {
+			break
+		}
+		dst := dsts[m.dst]
+		if dst.isRename {
+			continue
+		}
+		if !copies && c.used[srcs[m.src].one] > 0 {
+			continue
+		}
+		c.record(dst, srcs[m.src], m.score)
+	}
+}
+
+// recordIfBetter replaces the worst candidate with o if o is better.
+func recordIfBetter(m []*candidate, o *candidate) {
+	worst := 0
+	for i := 1; i < len(m); i++ {
+		if compareCandidates(m[i], m[worst]) > 0 {
+			worst = i
+		}
+	}
+	if compareCandidates(m[worst], o) > 0 {
+		m[worst] = o
+	}
+}
+
+// compareCandidates returns negative if a is better than b, and positive if b is better.
+// unused candidates are the worst.
+func compareCandidates(a, b *candidate) int {
+	if a.dst < 0 {
+		if b.dst >= 0 {
+			return 1
+		}
+		return 0
+	}
+	if b.dst < 0 {
+		return -1
+	}
+	if a.score == b.score {
+		return b.nameScore - a.nameScore
+	}
+	return b.score - a.score
+}
+
+// basenameSame returns 1 if the paths have the same base name, or 0.
+func basenameSame(src, dst string) int {
+	if path.Base(src) == path.Base(dst) {
+		return 1
+	}
+	return 0
+}
+
+func isRegular(mode object.FileMode) bool {
+	return mode&0170000 == object.ModeRegular&0170000
+}
+
+// estimateSimilarity returns similarity of the regular files in units of MaxScore,
+// which is the ratio of bytes copied from src to the larger size.
+// it returns 0 without reading the contents if their sizes are too different to be more similar than minimum.
+func (c *diffcore) estimateSimilarity(src, dst *Entry, minimum int) (int, error) {
+	if !isRegular(src.Mode) || !isRegular(dst.Mode) {
+		return 0, nil
+	}
+	srcData, err := c.reader.read(src)
+	if err != nil {
+		return 0, err
+	}
+	dstData, err := c.reader.read(dst)
+	if err != nil {
+		return 0, err
+	}
+	maxSize, baseSize := len(srcData), len(dstData)
+	if maxSize < baseSize {
+		maxSize, baseSize = baseSize, maxSize
+	}
+	deltaSize := maxSize - baseSize
+	if maxSize*(MaxScore-minimum) < deltaSize*MaxScore {
+		return 0, nil
+	}
+	if len(dstData) == 0 {
+		return 0, nil
+	}
+	srcHashes, err := c.hashChunks(src, srcData)
+	if err != nil {
+		return 0, err
+	}
+	dstHashes, err := c.hashChunks(dst, dstData)
+	if err != nil {
+		return 0, err
+	}
+	copied, _ := countChanges(srcHashes, dstHashes)
+	return copied * MaxScore / maxSize, nil
+}
+
+// hashChunks returns hash values of chunks of the entry, they are cached.
+func (c *diffcore) hashChunks(e *Entry, data []byte) (map[uint32]int, error) {
+	if hashes, ok := c.hashes[e]; ok {
+		return hashes, nil
+	}
+	binary, err := c.reader.isBinary(e.Path, data)
+	if err != nil {
+		return nil, err
+	}
+	hashes := hashChunks(data, !binary)
+	c.hashes[e] = hashes
+	return hashes, nil
+}
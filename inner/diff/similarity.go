@@ -0,0 +1,98 @@
+package diff
+
+// constants of scores, same as diffcore.h of git
+const (
+	MaxScore           = 60000 // score of 100%
+	DefaultRenameScore = 30000 // minimum similarity of renames and copies, 50%
+	DefaultBreakScore  = 30000 // minimum dissimilarity to break a modification, 50%
+	DefaultMergeScore  = 36000 // minimum dissimilarity to show a broken modification as rewrite, 60%
+)
+
+// hashBase is the modulus of hash values of chunks, same as diffcore-delta.c of git
+const hashBase = 107927
+
+// ParseScore parses a score of options like -M and -B.
+// "50%" is 50% and digits without '%' are a fraction, e.g. "5" and "0.5" are also 50%.
+// it returns the score in units of MaxScore.
+func ParseScore(s string) (int, error) {
+	num, scale := 0, 1
+	dot := false
+	i := 0
+loop:
+	for ; i < len(s); i++ {
+		switch c := s[i]; {
+		case !dot && c == '.':
+			scale = 1
+			dot = true
+		case c == '%':
+			if dot {
+				scale *= 100
+			} else {
+				scale = 100
+			}
+			i++ // '%' is always at the end
+			break loop
+		case '0' <= c && c <= '9':
+			if scale < 100000 {
+				scale *= 10
+				num = num*10 + int(c-'0')
+			}
+		default:
+			break loop
+		}
+	}
+	if i < len(s) {
+		return 0, ErrInvalidScore
+	}
+	if num >= scale {
+		return MaxScore, nil
+	}
+	return MaxScore * num / scale, nil
+}
+
+// percent converts the score to percentage.
+func percent(score int) int {
+	return score * 100 / MaxScore
+}
+
+// hashChunks counts bytes of the content by hash values of its chunks.
+// the content is split into lines, and lines longer than 64 bytes are split into 64 bytes.
+// CR of CRLF is ignored if the content is text, and an incomplete line at the end is not counted.
+func hashChunks(data []byte, isText bool) map[uint32]int {
+	counts := map[uint32]int{}
+	var accum1, accum2 uint32
+	n := 0
+	for i := 0; i < len(data); i++ {
+		c := uint32(data[i])
+		if isText && c == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			continue
+		}
+		old := accum1
+		accum1 = (accum1 << 7) ^ (accum2 >> 25)
+		accum2 = (accum2 << 7) ^ (old >> 25)
+		accum1 += c
+		n++
+		if n < 64 && c != '\n' {
+			continue
+		}
+		counts[(accum1+accum2*0x61)%hashBase] += n
+		n = 0
+		accum1, accum2 = 0, 0
+	}
+	return counts
+}
+
+// countChanges returns the number of bytes of dst copied from src and the number of bytes added to dst.
+func countChanges(src, dst map[uint32]int) (int, int) {
+	copied, added := 0, 0
+	for hash, dstCount := range dst {
+		srcCount := src[hash]
+		if srcCount < dstCount {
+			copied += srcCount
+			added += dstCount - srcCount
+		} else {
+			copied += dstCount
+		}
+	}
+	return copied, added
+}
@@ -0,0 +1,172 @@
+package diff
+
+import (
+	"bytes"
+	"path"
+
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// TreeToTree computes changes from a tree to another tree.
+// from and to are digests of tree objects or commit objects, nil means the empty tree.
+// sub trees having the same digest are skipped without reading, unless FindCopiesHarder is given.
+func TreeToTree(gitDir string, from, to []byte, options *Options) ([]*Change, error) {
+	d := newDiffer(gitDir, options)
+	if err := d.diffTrees("", from, to); err != nil {
+		return nil, err
+	}
+	return d.result()
+}
+
+// differ is a type holding state while computing changes.
+type differ struct {
+	gitDir  string
+	options Options
+	changes []*Change
+}
+
+func newDiffer(gitDir string, options *Options) *differ {
+	d := &differ{gitDir: gitDir, changes: []*Change{}}
+	if options != nil {
+		d.options = *options
+	}
+	if d.options.FindCopiesHarder {
+		d.options.DetectCopies = true
+	}
+	if d.options.DetectCopies {
+		d.options.DetectRenames = true
+	}
+	return d
+}
+
+// add adds the change from one entry to another.
+// unmodified entries are also kept as sources of copies if FindCopiesHarder is given.
+func (d *differ) add(from, to *Entry) {
+	if change, ok := compare(from, to); ok {
+		d.changes = append(d.changes, change)
+	} else if from != nil && d.options.FindCopiesHarder {
+		d.changes = append(d.changes, &Change{Type: unmodified, From: from, To: to})
+	}
+}
+
+// result returns the changes sorted by path, renames, copies and rewrites are detected if needed.
+func (d *differ) result() ([]*Change, error) {
+	sortChanges(d.changes)
+	if !d.options.DetectRenames && !d.options.BreakRewrites {
+		return d.changes, nil
+	}
+	c, err := newDiffcore(d.gitDir, d.options)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+	return c.run(d.changes)
+}
+
+// readTree reads the tree object, or the tree of the commit object.
+// it returns the empty tree if digest is nil.
+func (d *differ) readTree(digest []byte) (*object.Tree, error) {
+	if digest == nil {
+		return &object.Tree{Entries: []*object.TreeEntry{}}, nil
+	}
+	obj, err := object.ReadObjectFrom(d.gitDir, digest)
+	if err != nil {
+		return nil, err
+	}
+	if commit, ok := obj.(*object.Commit); ok {
+		return d.readTree(commit.Tree)
+	}
+	tree, ok := obj.(*object.Tree)
+	if !ok {
+		return nil, object.ErrUnexpectedType
+	}
+	return tree, nil
+}
+
+func (d *differ) diffTrees(prefix string, from, to []byte) error {
+	if bytes.Equal(from, to) && !d.options.FindCopiesHarder {
+		return nil
+	}
+	fromTree, err := d.readTree(from)
+	if err != nil {
+		return err
+	}
+	toTree, err := d.readTree(to)
+	if err != nil {
+		return err
+	}
+	fromEntries := map[string]*object.TreeEntry{}
+	for _, entry := range fromTree.Entries {
+		fromEntries[entry.Name] = entry
+	}
+	for _, entry := range toTree.Entries {
+		if err := d.diffEntries(path.Join(prefix, entry.Name), fromEntries[entry.Name], entry); err != nil {
+			return err
+		}
+		delete(fromEntries, entry.Name)
+	}
+	for _, entry := range fromTree.Entries {
+		if _, ok := fromEntries[entry.Name]; !ok {
+			continue
+		}
+		if err := d.diffEntries(path.Join(prefix, entry.Name), entry, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffEntries compares entries of the same name, either of them may be nil.
+// a sub tree replaced with a file is reported as deletion of files in the tree and addition of the file.
+func (d *differ) diffEntries(name string, from, to *object.TreeEntry) error {
+	var fromTree, toTree []byte
+	var fromEntry, toEntry *Entry
+	if from != nil {
+		if from.Mode.IsTree() {
+			fromTree = from.Digest
+		} else {
+			fromEntry = &Entry{Path: name, Mode: from.Mode, Digest: from.Digest}
+		}
+	}
+	if to != nil {
+		if to.Mode.IsTree() {
+			toTree = to.Digest
+		} else {
+			toEntry = &Entry{Path: name, Mode: to.Mode, Digest: to.Digest}
+		}
+	}
+	if (fromTree != nil || toTree != nil) && d.options.Pathspec.MatchDir(name) {
+		if err := d.diffTrees(name, fromTree, toTree); err != nil {
+			return err
+		}
+	}
+	if (fromEntry != nil || toEntry != nil) && d.options.Pathspec.Match(name) {
+		d.add(fromEntry, toEntry)
+	}
+	return nil
+}
+
+// listTree calls fn for each file in the tree recursively.
+// sub trees which can't contain paths matching the pathspec are skipped.
+func (d *differ) listTree(prefix string, digest []byte, fn func(entry *Entry)) error {
+	tree, err := d.readTree(digest)
+	if err != nil {
+		return err
+	}
+	for _, entry := range tree.Entries {
+		name := path.Join(prefix, entry.Name)
+		if entry.Mode.IsTree() {
+			if !d.options.Pathspec.MatchDir(name) {
+				continue
+			}
+			if err := d.listTree(name, entry.Digest, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if d.options.Pathspec.Match(name) {
+			fn(&Entry{Path: name, Mode: entry.Mode, Digest: entry.Digest})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package diff
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/shumon84/mogit/inner/attributes"
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// IndexToWorktree computes changes from the index to the working tree like $ git diff
+// only tracked paths are compared, untracked files are not reported.
+// unmerged paths are reported with changes from stage 2 (ours) to the working tree.
+// entries having skip-worktree flag are not compared, their files are left out by sparse checkout.
+// files of intent-to-add entries are reported as added, and as deleted from the entries if they don't exist.
+func IndexToWorktree(gitDir string, options *Options) ([]*Change, error) {
+	d := newDiffer(gitDir, options)
+	idx, err := d.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	w, err := newWorktree(gitDir, idx)
+	if err != nil {
+		return nil, err
+	}
+	defer w.close()
+	for _, entry := range idx.entries {
+		if entry.SkipWorktree {
+			continue
+		}
+		to, err := w.entry(entry)
+		if err != nil {
+			return nil, err
+		}
+		from := indexEntry(entry)
+		if entry.IntentToAdd && to != nil {
+			from = nil
+		}
+		d.add(from, to)
+	}
+	for _, entry := range idx.unmerged {
+		to, err := w.entry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if to != nil {
+			to = &Entry{Path: to.Path, Mode: to.Mode}
+		}
+		d.changes = append(d.changes, unmerged(entry.Name, nil, to))
+	}
+	for _, entry := range idx.ours {
+		to, err := w.entry(entry)
+		if err != nil {
+			return nil, err
+		}
+		d.add(indexEntry(entry), to)
+	}
+	return d.result()
+}
+
+// TreeToWorktree computes changes from a tree to the working tree like $ git diff HEAD
+// tree is a digest of a tree object or a commit object, nil means the empty tree.
+// paths not in the index are regarded as deleted even if the files exist,
+// and unmerged paths are compared with the files directly.
+// entries having skip-worktree flag are regarded as the same as their files like git.
+func TreeToWorktree(gitDir string, tree []byte, options *Options) ([]*Change, error) {
+	d := newDiffer(gitDir, options)
+	idx, err := d.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	w, err := newWorktree(gitDir, idx)
+	if err != nil {
+		return nil, err
+	}
+	defer w.close()
+	fromEntries := map[string]*Entry{}
+	fromNames := []string{}
+	err = d.listTree("", tree, func(entry *Entry) {
+		fromEntries[entry.Path] = entry
+		fromNames = append(fromNames, entry.Path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range idx.entries {
+		to := indexEntry(entry)
+		if !entry.SkipWorktree {
+			if to, err = w.entry(entry); err != nil {
+				return nil, err
+			}
+		}
+		d.add(fromEntries[entry.Name], to)
+		delete(fromEntries, entry.Name)
+	}
+	for _, entry := range idx.unmerged {
+		to, err := w.entry(entry)
+		if err != nil {
+			return nil, err
+		}
+		d.add(fromEntries[entry.Name], to)
+		delete(fromEntries, entry.Name)
+	}
+	for _, name := range fromNames {
+		if entry, ok := fromEntries[name]; ok {
+			d.add(entry, nil)
+		}
+	}
+	return d.result()
+}
+
+// worktree is a type to make entries from files in the working tree.
+type worktree struct {
+	workDir   string
+	fileMode  bool                  // core.fileMode, whether executable bit is tracked
+	stat      *index.StatConfig     // how stat information of files is compared with entries
+	converter *attributes.Converter // converter of files to blobs
+	indexTime time.Time             // modification time of the index file
+}
+
+func newWorktree(gitDir string, idx *indexFile) (*worktree, error) {
+	w := &worktree{workDir: filepath.Dir(gitDir), indexTime: idx.modTime}
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	if w.fileMode, err = cfg.Bool("core.filemode", true); err != nil {
+		return nil, err
+	}
+	w.stat = &index.StatConfig{FileMode: w.fileMode, Minimal: cfg.GetString("core.checkstat", "default") == "minimal"}
+	if w.stat.TrustCTime, err = cfg.Bool("core.trustctime", true); err != nil {
+		return nil, err
+	}
+	if w.converter, err = attributes.NewConverter(gitDir); err != nil {
+		return nil, err
+	}
+	w.converter.IndexBlob = index.BlobReader(gitDir, idx.entries)
+	return w, nil
+}
+
+func (w *worktree) close() error {
+	return w.converter.Close()
+}
+
+// entry returns the entry of the file at the path of the index entry, or nil if it doesn't exist.
+// files whose stat information matches the index entry are not hashed.
+func (w *worktree) entry(entry *index.Entry) (*Entry, error) {
+	filePath := filepath.Join(w.workDir, filepath.FromSlash(entry.Name))
+	info, err := os.Lstat(filePath)
+	if os.IsNotExist(err) || isNotDir(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Entry{Path: entry.Name, Worktree: true}
+	switch {
+	case info.IsDir():
+		if entry.ObjectType != index.GitLink {
+			return nil, nil
+		}
+		e.Mode = object.ModeGitLink
+		e.Digest = entry.Digest
+		return e, nil
+	case info.Mode()&os.ModeSymlink != 0:
+		e.Mode = object.ModeSymlink
+	case info.Mode().IsRegular():
+		e.Mode = object.ModeRegular
+		if w.fileMode && info.Mode()&0100 != 0 {
+			e.Mode = object.ModeExecutable
+		}
+		if !w.fileMode && entry.ObjectType == index.RegularFile {
+			e.Mode = IndexMode(entry)
+		}
+	default:
+		return nil, nil
+	}
+
+	if e.Mode&0170000 == IndexMode(entry)&0170000 && w.isStatClean(entry, info) {
+		e.Digest = entry.Digest
+		return e, nil
+	}
+	if e.Digest, err = w.hashFile(entry.Name, filePath, info); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func isNotDir(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	return ok && pathErr.Err == syscall.ENOTDIR
+}
+
+// isStatClean reports whether the file is unchanged according to the stat information.
+// an entry modified at the same time as the index or later is racily clean, so it is not trusted.
+func (w *worktree) isStatClean(entry *index.Entry, info os.FileInfo) bool {
+	if entry.IsAssumeValid {
+		return true
+	}
+	if !entry.MTime.Before(w.indexTime) {
+		return false
+	}
+	return entry.MatchStat(info, w.stat)
+}
+
+// hashFile returns SHA1 digest of the file as a blob object.
+// the content is filtered and line endings are converted according to attributes of the path.
+func (w *worktree) hashFile(name, filePath string, info os.FileInfo) ([]byte, error) {
+	data, err := w.readFile(name, filePath, info)
+	if err != nil {
+		return nil, err
+	}
+	return object.NewBlobFromBytes(data).SHA1()
+}
+
+// readFile returns content of the file as a blob object.
+// content of symbolic link is the target path.
+func (w *worktree) readFile(name, filePath string, info os.FileInfo) ([]byte, error) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(filepath.ToSlash(target)), nil
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return w.converter.ToGit(name, data)
+}
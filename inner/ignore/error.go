@@ -0,0 +1,7 @@
+package ignore
+
+import "errors"
+
+var (
+	ErrInvalidPath = errors.New("path must be relative from top level directory")
+)
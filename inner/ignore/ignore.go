@@ -0,0 +1,192 @@
+// ignore is a package to decide whether paths are ignored by .gitignore and exclude files
+//
+// Patterns are read from the following files, a file listed earlier has higher precedence.
+// In each file, the last matching pattern decides the result.
+//
+//  <dir>/.gitignore      - patterns relative to the directory, deeper one has higher precedence
+//  .git/info/exclude     - patterns of the repository which are not shared
+//  core.excludesFile     - patterns of the user, $XDG_CONFIG_HOME/git/ignore by default
+//
+// A path can't be re-included by a negative pattern if its parent directory is ignored.
+//
+// If you want to know more about ignore patterns, please refer to
+// https://git-scm.com/docs/gitignore
+package ignore
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// FileName is name of per-directory ignore files
+const FileName = ".gitignore"
+
+// Matcher is a type to match paths in the working tree with ignore patterns.
+type Matcher struct {
+	workDir    string
+	ignoreCase bool
+	global     [][]*Pattern          // lists of info/exclude and core.excludesFile in order of precedence
+	dirs       map[string][]*Pattern // patterns of .gitignore by directory
+}
+
+// NewMatcher creates a Matcher of the repository.
+// gitDir of parameters must be path to .git directory.
+func NewMatcher(gitDir string) (*Matcher, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	ignoreCase, err := cfg.Bool("core.ignorecase", false)
+	if err != nil {
+		return nil, err
+	}
+	m := &Matcher{
+		workDir:    filepath.Dir(gitDir),
+		ignoreCase: ignoreCase,
+		dirs:       map[string][]*Pattern{},
+	}
+
+	excludesFile, ok := cfg.Path("core.excludesfile")
+	if !ok {
+		excludesFile = defaultExcludesFile()
+	}
+	for _, file := range []string{filepath.Join(gitDir, "info", "exclude"), excludesFile} {
+		if file == "" {
+			continue
+		}
+		patterns, err := m.readPatterns(file, "")
+		if err != nil {
+			return nil, err
+		}
+		m.global = append(m.global, patterns)
+	}
+	return m, nil
+}
+
+// OpenMatcher creates a Matcher of current repository.
+func OpenMatcher() (*Matcher, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewMatcher(gitDir)
+}
+
+// defaultExcludesFile returns $XDG_CONFIG_HOME/git/ignore or ~/.config/git/ignore
+func defaultExcludesFile() string {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "git", "ignore")
+}
+
+// readPatterns reads patterns from the file, a missing file has no patterns.
+func (m *Matcher) readPatterns(file, base string) ([]*Pattern, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) || isNotDir(err) {
+		return []*Pattern{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	source := file
+	if rel, err := filepath.Rel(m.workDir, file); err == nil && !strings.HasPrefix(rel, "..") {
+		source = filepath.ToSlash(rel)
+	}
+	return ParsePatterns(data, source, base), nil
+}
+
+func isNotDir(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	return ok && pathErr.Err == syscall.ENOTDIR
+}
+
+// dirPatterns returns patterns of .gitignore in the directory, they are read only once.
+func (m *Matcher) dirPatterns(dir string) ([]*Pattern, error) {
+	if patterns, ok := m.dirs[dir]; ok {
+		return patterns, nil
+	}
+	file := filepath.Join(m.workDir, filepath.FromSlash(dir), FileName)
+	if info, err := os.Lstat(file); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		// same as git, .gitignore is not followed if it's a symbolic link
+		m.dirs[dir] = []*Pattern{}
+		return m.dirs[dir], nil
+	}
+	patterns, err := m.readPatterns(file, dir)
+	if err != nil {
+		return nil, err
+	}
+	m.dirs[dir] = patterns
+	return patterns, nil
+}
+
+// Match returns the pattern deciding whether the path is ignored, or nil if no pattern matches.
+// if a parent directory of the path is ignored, the pattern ignoring the directory is returned.
+// path is relative from top level directory and separated by '/'.
+func (m *Matcher) Match(name string, isDir bool) (*Pattern, error) {
+	name = strings.TrimSuffix(name, "/")
+	if name == "" || path.IsAbs(name) || name != path.Clean(name) || strings.HasPrefix(name, "../") {
+		return nil, ErrInvalidPath
+	}
+	components := strings.Split(name, "/")
+	for i := 1; i < len(components); i++ {
+		p, err := m.match(strings.Join(components[:i], "/"), true)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil && !p.Negative {
+			return p, nil
+		}
+	}
+	return m.match(name, isDir)
+}
+
+// match returns the last pattern matching the path without checking parent directories.
+func (m *Matcher) match(name string, isDir bool) (*Pattern, error) {
+	// deeper .gitignore has higher precedence
+	dirs := []string{}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, "")
+	lists := make([][]*Pattern, 0, len(dirs)+len(m.global))
+	for _, dir := range dirs {
+		patterns, err := m.dirPatterns(dir)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, patterns)
+	}
+	lists = append(lists, m.global...)
+	for _, patterns := range lists {
+		for i := len(patterns) - 1; i >= 0; i-- {
+			if patterns[i].Match(name, isDir, m.ignoreCase) {
+				return patterns[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// IsIgnored reports whether the path is ignored.
+// path is relative from top level directory and separated by '/'.
+// a path which can't be matched because of I/O errors is treated as not ignored.
+func (m *Matcher) IsIgnored(name string, isDir bool) bool {
+	p, err := m.Match(name, isDir)
+	return err == nil && p != nil && !p.Negative
+}
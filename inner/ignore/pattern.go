@@ -0,0 +1,124 @@
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/wildmatch"
+)
+
+// Pattern is a type representing one line of .gitignore or exclude files.
+type Pattern struct {
+	Text     string // the line without trailing spaces
+	Source   string // path of the file where the pattern is written
+	Line     int    // line number in Source, starting from 1
+	Base     string // directory which the pattern is relative to, "" means top level directory
+	Negative bool   // the pattern starts with '!', matching paths are re-included
+	DirOnly  bool   // the pattern ends with '/', it matches only directories
+	NoDir    bool   // the pattern has no '/', it matches base name in any depth
+	pattern  string // wildcard pattern without the leading '!', leading '/' and trailing '/'
+}
+
+// String is implementation of fmt.Stringer interface
+// it returns the same format as $ git check-ignore -v
+func (p *Pattern) String() string {
+	return fmt.Sprintf("%s:%d:%s", p.Source, p.Line, p.Text)
+}
+
+// ParsePatterns parses content of .gitignore or exclude files.
+// blank lines and comment lines are skipped.
+func ParsePatterns(data []byte, source, base string) []*Pattern {
+	patterns := []*Pattern{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		if p := ParsePattern(scanner.Text(), base); p != nil {
+			p.Source = source
+			p.Line = lineNum
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// ParsePattern parses a line of .gitignore, it returns nil for blank lines and comment lines.
+func ParsePattern(line, base string) *Pattern {
+	line = trimTrailingSpaces(strings.TrimSuffix(line, "\r"))
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+	p := &Pattern{Text: line, Base: base}
+	pattern := line
+	if pattern[0] == '!' {
+		p.Negative = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		p.DirOnly = true
+		pattern = pattern[:len(pattern)-1]
+	}
+	if !strings.Contains(pattern, "/") {
+		p.NoDir = true
+	}
+	p.pattern = strings.TrimPrefix(pattern, "/")
+	if p.pattern == "" {
+		return nil
+	}
+	return p
+}
+
+// trimTrailingSpaces removes trailing spaces which are not escaped with backslash.
+func trimTrailingSpaces(line string) string {
+	lastSpace := -1
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			if lastSpace < 0 {
+				lastSpace = i
+			}
+			continue
+		case '\\':
+			i++
+			if i >= len(line) {
+				return line
+			}
+		}
+		lastSpace = -1
+	}
+	if lastSpace >= 0 {
+		return line[:lastSpace]
+	}
+	return line
+}
+
+// Match reports whether the pattern matches the path relative from top level directory.
+// a negative pattern also matches, check Negative to know whether the path is ignored.
+func (p *Pattern) Match(name string, isDir, ignoreCase bool) bool {
+	if p.DirOnly && !isDir {
+		return false
+	}
+	flags := wildmatch.Flags(0)
+	if ignoreCase {
+		flags |= wildmatch.CaseFold
+	}
+	if p.NoDir {
+		return wildmatch.Match(p.pattern, path.Base(name), flags)
+	}
+	if p.Base != "" {
+		prefix := p.Base + "/"
+		if len(name) <= len(prefix) || !equalPath(name[:len(prefix)], prefix, ignoreCase) {
+			return false
+		}
+		name = name[len(prefix):]
+	}
+	return wildmatch.Match(p.pattern, name, flags|wildmatch.PathName)
+}
+
+func equalPath(a, b string, ignoreCase bool) bool {
+	if ignoreCase {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
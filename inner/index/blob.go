@@ -0,0 +1,22 @@
+package index
+
+import "github.com/shumon84/mogit/inner/object"
+
+// BlobReader returns a function which reads content of the blob of the path at stage 0 in the entries, and
+// returns nil if the path has no entry of a regular file. it's for IndexBlob of attributes.Converter.
+// gitDir of parameters must be path to .git directory.
+func BlobReader(gitDir string, entries []*Entry) func(name string) ([]byte, error) {
+	files := map[string][]byte{}
+	for _, entry := range entries {
+		if entry.ConflictFlag == NoConflict && entry.ObjectType == RegularFile && !entry.IntentToAdd {
+			files[entry.Name] = entry.Digest
+		}
+	}
+	return func(name string) ([]byte, error) {
+		digest, ok := files[name]
+		if !ok {
+			return nil, nil
+		}
+		return object.ReadBlobContent(gitDir, digest)
+	}
+}
@@ -1,10 +1,10 @@
 package index
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io"
-	"log"
 	"time"
 
 	"github.com/shumon84/binutil"
@@ -23,6 +23,8 @@ type Entry struct {
 	Size          uint32       // size of the file what this entry specifies
 	Digest        []byte       // SHA1 digest of the file what this entry specifies
 	IsAssumeValid bool         // bool value of whether this entry specifying file is assume valid
+	SkipWorktree  bool         // the file isn't in the working tree because of sparse checkout
+	IntentToAdd   bool         // the file will be added later like $ git add --intent-to-add
 	ConflictFlag  ConflictFlag // flag to handle a conflicting file
 	Name          string       // name of the file what this entry specifies
 }
@@ -30,18 +32,20 @@ type Entry struct {
 // String is implementation of fmt.Stringer interface
 func (e *Entry) String() string {
 	return fmt.Sprintf(`%s
-  CTime       : %s
-  MTime       : %s
-  DeviceID    : %d
-  Inode       : %d
-  ObjectType  : %s
-  Permission  : %o
-  UserID      : %d
-  GroupID     : %d
-  FileSize    : %d
-  SHA1        : %s
-  AssumeValid : %v
-  Conflict    : %d`,
+  CTime        : %s
+  MTime        : %s
+  DeviceID     : %d
+  Inode        : %d
+  ObjectType   : %s
+  Permission   : %o
+  UserID       : %d
+  GroupID      : %d
+  FileSize     : %d
+  SHA1         : %s
+  AssumeValid  : %v
+  SkipWorktree : %v
+  IntentToAdd  : %v
+  Conflict     : %d`,
 		e.Name,
 		e.CTime,
 		e.MTime,
@@ -54,6 +58,8 @@ func (e *Entry) String() string {
 		e.Size,
 		hex.EncodeToString(e.Digest),
 		e.IsAssumeValid,
+		e.SkipWorktree,
+		e.IntentToAdd,
 		e.ConflictFlag)
 }
 
@@ -121,10 +127,16 @@ func readEntry(r binutil.Reader) (*Entry, error) {
 	if err != nil {
 		return nil, err
 	}
-	isAssumeValid, conflictFlag, fileNameLength, err := readFlags(r)
+	isAssumeValid, isExtended, conflictFlag, fileNameLength, err := readFlags(r)
 	if err != nil {
 		return nil, err
 	}
+	skipWorktree, intentToAdd := false, false
+	if isExtended {
+		if skipWorktree, intentToAdd, err = readExtendedFlags(r); err != nil {
+			return nil, err
+		}
+	}
 	name, err := readName(r, fileNameLength)
 	if err != nil {
 		return nil, err
@@ -142,6 +154,8 @@ func readEntry(r binutil.Reader) (*Entry, error) {
 		Size:          size,
 		Digest:        digest,
 		IsAssumeValid: isAssumeValid,
+		SkipWorktree:  skipWorktree,
+		IntentToAdd:   intentToAdd,
 		ConflictFlag:  conflictFlag,
 		Name:          name,
 	}, nil
@@ -156,7 +170,6 @@ func readTime(r binutil.Reader) (time.Time, error) {
 	if err != nil {
 		return time.Time{}, err
 	}
-	log.Println(sec, ":", nano)
 	return time.Unix(int64(sec), int64(nano)), nil
 }
 
@@ -211,24 +224,46 @@ func readDigest(r binutil.Reader) ([]byte, error) {
 	return r.Bytes(20)
 }
 
-func readFlags(r binutil.Reader) (bool, ConflictFlag, int, error) {
+func readFlags(r binutil.Reader) (bool, bool, ConflictFlag, int, error) {
 	flags, err := r.UInt16()
 	if err != nil {
-		return false, 0, 0, err
+		return false, false, 0, 0, err
 	}
 
-	// split flags(16 bit) to assume-valid flag(0 bit), conflict flag(3 ~ 4 bit) and file name length(5 ~ 16 bit).
-	// ------------------------------------------------------------
-	// |                          flags                           |
-	// |==========================================================|
-	// |   0    ~    B    |    C     D    | E |         F         |
-	// | file name length | conflict flag | 0 | assume valid flag |
-	// ------------------------------------------------------------
+	// split flags(16 bit) to assume-valid flag(0 bit), extended flag(1 bit), conflict flag(3 ~ 4 bit) and
+	// file name length(5 ~ 16 bit).
+	// -------------------------------------------------------------------
+	// |                              flags                              |
+	// |=================================================================|
+	// |   0    ~    B    |    C     D    |       E       |      F       |
+	// | file name length | conflict flag | extended flag | assume valid |
+	// -------------------------------------------------------------------
 	fileNameLength := int(flags & 0xFFF)
 	conflictFlag := ConflictFlag((flags >> 12) & 0x3)
+	isExtended := ((flags >> 14) & 0x1) == 1
 	isAssumeValid := ((flags >> 15) & 0x1) == 1
 
-	return isAssumeValid, conflictFlag, fileNameLength, nil
+	return isAssumeValid, isExtended, conflictFlag, fileNameLength, nil
+}
+
+// readExtendedFlags reads flags following the flags of version 3 or later, it returns skip-worktree flag and
+// intent-to-add flag.
+func readExtendedFlags(r binutil.Reader) (bool, bool, error) {
+	flags, err := r.UInt16()
+	if err != nil {
+		return false, false, err
+	}
+
+	// -------------------------------------------------------------------
+	// |                         extended flags                          |
+	// |=================================================================|
+	// |      0    ~    C     |       D       |       E       |    F     |
+	// | unused(must be zero) | intent-to-add | skip-worktree | reserved |
+	// -------------------------------------------------------------------
+	skipWorktree := ((flags >> 14) & 0x1) == 1
+	intentToAdd := ((flags >> 13) & 0x1) == 1
+
+	return skipWorktree, intentToAdd, nil
 }
 
 func readName(r binutil.Reader, fileNameLength int) (string, error) {
@@ -253,3 +288,70 @@ func seekToNextEntry(r binutil.Reader) error {
 	}
 	return nil
 }
+
+// hasExtendedFlags reports whether the entry needs extended flags of version 3 or later.
+func (e *Entry) hasExtendedFlags() bool {
+	return e.SkipWorktree || e.IntentToAdd
+}
+
+// writeEntry writes git index file entry.
+// the entry is padded with null bytes until the offset of next multiple of 8 from the entries section.
+// extended flags are written if the entry has them, so the index must be version 3 or later.
+func writeEntry(w io.Writer, e *Entry) error {
+	if len(e.Digest) != 20 {
+		return ErrInvalidDigest
+	}
+	// fixed size fields are 62 bytes or 64 bytes with extended flags,
+	// and at least one null byte terminates the name
+	fixed := 62
+	if e.hasExtendedFlags() {
+		fixed = 64
+	}
+	size := (fixed + len(e.Name) + 8) &^ 7
+	data := make([]byte, size)
+	putTime(data[0:], e.CTime)
+	putTime(data[8:], e.MTime)
+	binary.BigEndian.PutUint32(data[16:], uint32(e.Dev))
+	binary.BigEndian.PutUint32(data[20:], uint32(e.Ino))
+	binary.BigEndian.PutUint32(data[24:], uint32(e.ObjectType)<<12|uint32(e.Permission&0x1FF))
+	binary.BigEndian.PutUint32(data[28:], e.UserID)
+	binary.BigEndian.PutUint32(data[32:], e.GroupID)
+	binary.BigEndian.PutUint32(data[36:], e.Size)
+	copy(data[40:], e.Digest)
+
+	// the file name length is saturated at 0xFFF like git
+	flags := uint16(len(e.Name))
+	if len(e.Name) > 0xFFF {
+		flags = 0xFFF
+	}
+	flags |= uint16(e.ConflictFlag&0x3) << 12
+	if e.hasExtendedFlags() {
+		flags |= 1 << 14
+	}
+	if e.IsAssumeValid {
+		flags |= 1 << 15
+	}
+	binary.BigEndian.PutUint16(data[60:], flags)
+	if e.hasExtendedFlags() {
+		extended := uint16(0)
+		if e.IntentToAdd {
+			extended |= 1 << 13
+		}
+		if e.SkipWorktree {
+			extended |= 1 << 14
+		}
+		binary.BigEndian.PutUint16(data[62:], extended)
+	}
+	copy(data[fixed:], e.Name)
+	_, err := w.Write(data)
+	return err
+}
+
+// putTime puts seconds and nano seconds of the time, zero time is written as 0.
+func putTime(data []byte, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	binary.BigEndian.PutUint32(data, uint32(t.Unix()))
+	binary.BigEndian.PutUint32(data[4:], uint32(t.Nanosecond()))
+}
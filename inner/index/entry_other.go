@@ -5,7 +5,6 @@ package index
 import (
 	"os"
 	"syscall"
-	"time"
 )
 
 // NewEntry creates a new git index entry made by file info.
@@ -22,32 +21,29 @@ func NewEntry(fileInfo os.FileInfo, digest []byte) (*Entry, error) {
 	if err != nil {
 		return nil, err
 	}
-	perm := uint16(fileInfo.Mode().Perm())
-
-	// check valid combination of permission and object.
+	// normalize permission in the same way as git.
 	// the allowed combinations of permission and object are as follows:
 	// - RegularFile : 644
-	// - RegularFile : 755
+	// - RegularFile : 755 (the owner can execute the file)
 	// - Other type  : 000
+	perm := uint16(0)
 	if objectType == RegularFile {
-		if perm != 0644 && perm != 0755 {
-			return nil, ErrForbiddenPermission
-		}
-	} else {
-		if perm != 000 {
-			return nil, ErrForbiddenPermission
+		perm = 0644
+		if fileInfo.Mode()&0100 != 0 {
+			perm = 0755
 		}
 	}
 
+	ctime, mtime := statTimes(stat)
 	entry := &Entry{
-		CTime:         time.Unix(stat.Ctimespec.Sec, stat.Ctimespec.Nsec),
-		MTime:         time.Unix(stat.Mtimespec.Sec, stat.Mtimespec.Nsec),
-		Dev:           stat.Dev,
-		Ino:           stat.Ino,
+		CTime:         ctime,
+		MTime:         mtime,
+		Dev:           int32(stat.Dev),
+		Ino:           uint64(stat.Ino),
 		ObjectType:    objectType,
 		Permission:    perm,
-		UserID:        stat.Uid,
-		GroupID:       stat.Gid,
+		UserID:        uint32(stat.Uid),
+		GroupID:       uint32(stat.Gid),
 		Size:          uint32(fileInfo.Size()),
 		Digest:        digest,
 		IsAssumeValid: false,
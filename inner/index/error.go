@@ -9,4 +9,7 @@ var (
 	ErrForbiddenPermission   = errors.New("forbidden permission")
 	ErrNotSupportedVersion   = errors.New("this git index version is not supported")
 	ErrIndexOutOfEntryRanges = errors.New("index out of entry ranges")
+	ErrInvalidDigest         = errors.New("digest of entry must be 20 bytes")
+	ErrInvalidExtension      = errors.New("index extension is broken")
+	ErrNotSupportedExtension = errors.New("this index extension is not supported")
 )
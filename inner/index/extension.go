@@ -0,0 +1,88 @@
+package index
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+
+	"github.com/shumon84/binutil"
+)
+
+// extensions is a type holding extensions of the index.
+type extensions struct {
+	resolveUndo []*ResolveUndo
+	sparse      bool // sdir extension exists, the index may have sparse directory entries
+}
+
+// readExtensions reads extensions following entries until the trailing checksum.
+// r must be positioned at the first extension.
+// extensions which aren't supported are skipped if they are optional, whose signature starts with 'A' to 'Z'.
+//
+//  -------------------------------------------------------------------------------
+//  | Extension 0 |  4byte - signature(e.g. 'R','E','U','C')
+//  |             |================================================================
+//  |             |  4byte - size of the extension data
+//  |             |================================================================
+//  |             | $(size)byte - extension data
+//  -------------------------------------------------------------------------------
+//  | Extension 1 |
+//  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+func readExtensions(r binutil.Reader) (*extensions, error) {
+	position, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	end -= sha1.Size
+	if _, err := r.Seek(position, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	exts := &extensions{resolveUndo: []*ResolveUndo{}}
+	for position+8 <= end {
+		signature, err := r.Bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.UInt32()
+		if err != nil {
+			return nil, err
+		}
+		if end < position+8+int64(size) {
+			return nil, ErrInvalidExtension
+		}
+		data, err := r.Bytes(int(size))
+		if err != nil {
+			return nil, err
+		}
+		switch string(signature) {
+		case resolveUndoSignature:
+			if exts.resolveUndo, err = parseResolveUndo(data); err != nil {
+				return nil, err
+			}
+		case sparseDirectorySignature:
+			exts.sparse = true
+		default:
+			if signature[0] < 'A' || 'Z' < signature[0] {
+				return nil, ErrNotSupportedExtension
+			}
+		}
+		position += 8 + int64(size)
+	}
+	return exts, nil
+}
+
+// writeExtension writes the extension having the signature and the data.
+func writeExtension(w io.Writer, signature string, data []byte) error {
+	header := make([]byte, 8)
+	copy(header, signature)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
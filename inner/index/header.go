@@ -1,6 +1,7 @@
 package index
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
 
@@ -72,6 +73,7 @@ func readSignature(r binutil.Reader) (string, error) {
 func readVersion(r binutil.Reader) (uint32, error) {
 	supportedVersions := map[uint32]struct{}{
 		2: {},
+		3: {},
 	}
 	version, err := r.UInt32()
 	if err != nil {
@@ -86,3 +88,17 @@ func readVersion(r binutil.Reader) (uint32, error) {
 func readNumOfEntries(r binutil.Reader) (uint32, error) {
 	return r.UInt32()
 }
+
+// writeHeader writes git index file header.
+// only version 2 and 3 can be written.
+func writeHeader(w io.Writer, h *Header) error {
+	if h.Version != 2 && h.Version != 3 {
+		return ErrNotSupportedVersion
+	}
+	data := make([]byte, 12)
+	copy(data, HeaderSignature)
+	binary.BigEndian.PutUint32(data[4:], h.Version)
+	binary.BigEndian.PutUint32(data[8:], h.NumOfEntries)
+	_, err := w.Write(data)
+	return err
+}
@@ -1,6 +1,6 @@
 // index is a package to handle git index file
 //
-// Note! Now supported version is 2 only.
+// Note! Now supported versions are 2 and 3 only. version 3 is written only if some entries have extended flags.
 //
 // The following show git index file format overview.
 //
@@ -46,7 +46,7 @@
 //  |          |                |         see bellow a command more about this flag
 //  |          |                |         $ git update-index --assume-unchanged
 //  |          |                |==================================================
-//  |          |                |  1bit - must be zero
+//  |          |                |  1bit - extended flag, must be zero in version 2
 //  |          |                |==================================================
 //  |          |                |  2bit - conflict flag
 //  |          |                |         00 = no conflict
@@ -56,23 +56,44 @@
 //  |          |                |==================================================
 //  |          |                | 12bit - file name length
 //  |          |===================================================================
+//  |          |  2byte - extended flags, only if extended flag is set
+//  |          |                |  1bit - reserved
+//  |          |                |==================================================
+//  |          |                |  1bit - skip-worktree flag, used by sparse checkout
+//  |          |                |==================================================
+//  |          |                |  1bit - intent-to-add flag
+//  |          |                |         $ git add --intent-to-add
+//  |          |                |==================================================
+//  |          |                | 13bit - unused, must be zero
+//  |          |===================================================================
 //  |          | $(file name length)byte - relative path from top level directory
 //  |          |===================================================================
 //  |          | Fill in null bytes until file offset of next multiple of 8
 //  -------------------------------------------------------------------------------
 //  | Entry  1 |
 //  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+//  | Extensions (e.g. REUC extension, stages of resolved conflicts)
+//  -------------------------------------------------------------------------------
+//  | 20byte - SHA-1 checksum of all the above
+//  -------------------------------------------------------------------------------
 //  * All binary numbers are in network byte order.
 //
+// A sparse index has sdir extension, and directories out of sparse checkout patterns are collapsed into sparse
+// directory entries. ReadIndexFrom expands them to entries of files, so callers which don't know sparse index
+// see the same entries as a full index. ReadSparseIndexFrom keeps them, and Expand expands them lazily.
+//
 // If you want to know more about git index file format, please refer to
 // https://github.com/git/git/blob/master/Documentation/technical/index-format.txt
 package index
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/shumon84/mogit/inner/util"
 
@@ -84,14 +105,19 @@ type Index interface {
 	fmt.Stringer
 	Header() *Header                    // get git index file header.
 	Entries(idx uint32) (*Entry, error) // get idx-th git index entry.
+	ResolveUndo() []*ResolveUndo        // get stages of resolved conflicts recorded in REUC extension.
+	IsSparse() bool                     // get whether the index may have sparse directory entries.
 }
 
 type indexImpl struct {
-	header  *Header
-	entries []*Entry
+	header      *Header
+	entries     []*Entry
+	resolveUndo []*ResolveUndo
+	sparse      bool
 }
 
 // ReadIndex gets index tree from current repository
+// sparse directory entries are expanded like ReadIndexFrom.
 func ReadIndex() (Index, error) {
 	currentDir, err := os.Getwd()
 	if err != nil {
@@ -101,14 +127,33 @@ func ReadIndex() (Index, error) {
 	if err != nil {
 		return nil, err
 	}
-	indexFile, err := os.Open(filepath.Join(path, ".git", "index"))
+	return ReadIndexFrom(filepath.Join(path, ".git"))
+}
+
+// ReadIndexFrom reads index tree of the repository.
+// sparse directory entries of a sparse index are expanded to entries of files in their trees.
+// gitDir of parameters must be path to .git directory.
+func ReadIndexFrom(gitDir string) (Index, error) {
+	idx, err := ReadSparseIndexFrom(gitDir)
 	if err != nil {
 		return nil, err
 	}
+	return Expand(gitDir, idx, "")
+}
+
+// ReadSparseIndexFrom reads index tree of the repository without expanding sparse directory entries.
+// gitDir of parameters must be path to .git directory.
+func ReadSparseIndexFrom(gitDir string) (Index, error) {
+	indexFile, err := os.Open(filepath.Join(gitDir, "index"))
+	if err != nil {
+		return nil, err
+	}
+	defer indexFile.Close()
 	return ReadIndexFromReader(indexFile)
 }
 
 // ReadIndexFromReade reads index tree from byte stream of .git/index
+// sparse directory entries aren't expanded because objects can't be read.
 func ReadIndexFromReader(rs io.ReadSeeker) (Index, error) {
 	if rs == nil {
 		return nil, ErrNilReader
@@ -125,12 +170,114 @@ func ReadIndexFromReader(rs io.ReadSeeker) (Index, error) {
 		return nil, err
 	}
 
+	exts, err := readExtensions(r)
+	if err != nil {
+		return nil, err
+	}
+
 	return &indexImpl{
-		header:  header,
-		entries: entries,
+		header:      header,
+		entries:     entries,
+		resolveUndo: exts.resolveUndo,
+		sparse:      exts.sparse,
 	}, nil
 }
 
+// NewIndex creates a new index tree of version 2 having the entries.
+// entries are sorted by name and conflict flag like git.
+func NewIndex(entries []*Entry) Index {
+	return NewIndexWithResolveUndo(entries, nil)
+}
+
+// NewIndexWithResolveUndo creates a new index tree of version 2 having the entries and REUC extension.
+// entries are sorted by name and conflict flag, and records of REUC extension are sorted by name like git.
+func NewIndexWithResolveUndo(entries []*Entry, resolveUndo []*ResolveUndo) Index {
+	return newIndex(entries, resolveUndo, false)
+}
+
+// NewSparseIndex creates a new sparse index having the entries and REUC extension.
+// entries may have sparse directory entries, and they are sorted like NewIndexWithResolveUndo.
+func NewSparseIndex(entries []*Entry, resolveUndo []*ResolveUndo) Index {
+	return newIndex(entries, resolveUndo, true)
+}
+
+func newIndex(entries []*Entry, resolveUndo []*ResolveUndo, sparse bool) Index {
+	sorted := make([]*Entry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].ConflictFlag < sorted[j].ConflictFlag
+	})
+	return &indexImpl{
+		header: &Header{
+			Signature:    HeaderSignature,
+			Version:      2,
+			NumOfEntries: uint32(len(sorted)),
+		},
+		entries:     sorted,
+		resolveUndo: sortResolveUndo(resolveUndo),
+		sparse:      sparse,
+	}
+}
+
+// WriteIndex writes the index tree to .git/index of the repository.
+// the index file is locked while writing, and it returns util.ErrLocked if another process locks it.
+// gitDir of parameters must be path to .git directory.
+func WriteIndex(gitDir string, idx Index) error {
+	lock, err := util.NewLockFile(filepath.Join(gitDir, "index"))
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	if err := WriteIndexTo(lock, idx); err != nil {
+		return err
+	}
+	return lock.Commit()
+}
+
+// WriteIndexTo writes the index tree as byte stream of .git/index.
+// REUC extension is written if it has records, and sdir extension is written if the index is sparse.
+// the other extensions aren't written. the index is written as version 3 if some entries have extended flags
+// like git. the trailing SHA1 checksum of the contents is also written.
+func WriteIndexTo(w io.Writer, idx Index) error {
+	header := *idx.Header()
+	entries := make([]*Entry, header.NumOfEntries)
+	for i := range entries {
+		entry, err := idx.Entries(uint32(i))
+		if err != nil {
+			return err
+		}
+		if entry.hasExtendedFlags() && header.Version < 3 {
+			header.Version = 3
+		}
+		entries[i] = entry
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeHeader(buf, &header); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := writeEntry(buf, entry); err != nil {
+			return err
+		}
+	}
+	if err := writeResolveUndo(buf, idx.ResolveUndo()); err != nil {
+		return err
+	}
+	if idx.IsSparse() {
+		if err := writeExtension(buf, sparseDirectorySignature, nil); err != nil {
+			return err
+		}
+	}
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+	_, err := buf.WriteTo(w)
+	return err
+}
+
 // Header returns *Header in this index tree
 func (i *indexImpl) Header() *Header {
 	return i.header
@@ -144,6 +291,16 @@ func (i *indexImpl) Entries(idx uint32) (entry *Entry, err error) {
 	return i.entries[idx], nil
 }
 
+// ResolveUndo returns records of REUC extension in this index tree
+func (i *indexImpl) ResolveUndo() []*ResolveUndo {
+	return i.resolveUndo
+}
+
+// IsSparse returns whether this index tree is a sparse index
+func (i *indexImpl) IsSparse() bool {
+	return i.sparse
+}
+
 // String is implementation of fmt.Stringer interface
 func (i *indexImpl) String() string {
 	str := i.Header().String()
@@ -7,6 +7,7 @@ type ObjectType uint
 
 // constants of git index entry object type.
 const (
+	Directory    ObjectType = 0x4 // only for sparse directory entries of a sparse index
 	RegularFile  ObjectType = 0x8
 	SymbolicLink ObjectType = 0xA
 	GitLink      ObjectType = 0xE
@@ -15,6 +16,8 @@ const (
 // String is an implementation of fmt.Stringer interface.
 func (objectType ObjectType) String() string {
 	switch objectType {
+	case Directory:
+		return "directory"
 	case RegularFile:
 		return "regular"
 	case SymbolicLink:
@@ -0,0 +1,110 @@
+package index
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// resolveUndoSignature is the signature of REUC extension.
+const resolveUndoSignature = "REUC"
+
+// ResolveUndo is a type representing stages of a conflicting path before it was resolved.
+// they are recorded in REUC extension, and $ git checkout -m <path> recreates the conflict from them.
+type ResolveUndo struct {
+	Name    string    // path of the resolved file
+	Modes   [3]uint32 // modes of stage 1, 2 and 3, 0 means the path didn't exist at the stage
+	Digests [3][]byte // SHA1 digests of stage 1, 2 and 3, nil if the path didn't exist at the stage
+}
+
+// Stage returns the mode and the digest of the stage, it's one of LowestCommonAncestorCommit, CurrentCommit and AnotherCommit.
+func (u *ResolveUndo) Stage(stage ConflictFlag) (uint32, []byte) {
+	if stage < LowestCommonAncestorCommit || AnotherCommit < stage {
+		return 0, nil
+	}
+	return u.Modes[stage-1], u.Digests[stage-1]
+}
+
+// parseResolveUndo parses data of REUC extension.
+//
+//  -------------------------------------------------------------------------------
+//  | Record 0 | NUL-terminated path name
+//  |          |===================================================================
+//  |          | three NUL-terminated ASCII octal numbers - modes of stage 1, 2 and 3
+//  |          |===================================================================
+//  |          | 20byte SHA-1 digests of stages whose mode isn't 0
+//  -------------------------------------------------------------------------------
+//  | Record 1 |
+//  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+func parseResolveUndo(data []byte) ([]*ResolveUndo, error) {
+	records := []*ResolveUndo{}
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			return nil, ErrInvalidExtension
+		}
+		record := &ResolveUndo{Name: string(data[:end])}
+		data = data[end+1:]
+		for i := range record.Modes {
+			end := bytes.IndexByte(data, 0)
+			if end < 0 {
+				return nil, ErrInvalidExtension
+			}
+			mode, err := strconv.ParseUint(string(data[:end]), 8, 32)
+			if err != nil {
+				return nil, ErrInvalidExtension
+			}
+			record.Modes[i] = uint32(mode)
+			data = data[end+1:]
+		}
+		for i, mode := range record.Modes {
+			if mode == 0 {
+				continue
+			}
+			if len(data) < 20 {
+				return nil, ErrInvalidExtension
+			}
+			record.Digests[i] = data[:20]
+			data = data[20:]
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// writeResolveUndo writes REUC extension having the records, nothing is written if there are no records.
+func writeResolveUndo(w io.Writer, records []*ResolveUndo) error {
+	if len(records) == 0 {
+		return nil
+	}
+	data := &bytes.Buffer{}
+	for _, record := range records {
+		data.WriteString(record.Name)
+		data.WriteByte(0)
+		for _, mode := range record.Modes {
+			data.WriteString(strconv.FormatUint(uint64(mode), 8))
+			data.WriteByte(0)
+		}
+		for i, mode := range record.Modes {
+			if mode == 0 {
+				continue
+			}
+			if len(record.Digests[i]) != 20 {
+				return ErrInvalidDigest
+			}
+			data.Write(record.Digests[i])
+		}
+	}
+	return writeExtension(w, resolveUndoSignature, data.Bytes())
+}
+
+// sortResolveUndo sorts the records by name like git.
+func sortResolveUndo(records []*ResolveUndo) []*ResolveUndo {
+	sorted := make([]*ResolveUndo, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
@@ -0,0 +1,78 @@
+package index
+
+import (
+	"path"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// sparseDirectorySignature is the signature of sdir extension.
+// the extension has no data, it only tells the index may have sparse directory entries.
+const sparseDirectorySignature = "sdir"
+
+// IsSparseDirectory reports whether the entry is a sparse directory entry of a sparse index.
+// a sparse directory entry points to a tree, its name ends with '/' and it has skip-worktree flag.
+func (e *Entry) IsSparseDirectory() bool {
+	return e.ObjectType == Directory && strings.HasSuffix(e.Name, "/")
+}
+
+// Expand expands sparse directory entries of the index to entries of files in their trees.
+// only the sparse directory containing the path is expanded, and all of them are expanded if name is "".
+// the returned index is still sparse if some sparse directory entries are left.
+// the index is returned as it is if it isn't sparse.
+// gitDir of parameters must be path to .git directory.
+func Expand(gitDir string, idx Index, name string) (Index, error) {
+	if !idx.IsSparse() {
+		return idx, nil
+	}
+	entries := make([]*Entry, 0, idx.Header().NumOfEntries)
+	sparse := false
+	for i := uint32(0); i < idx.Header().NumOfEntries; i++ {
+		entry, err := idx.Entries(i)
+		if err != nil {
+			return nil, err
+		}
+		if !entry.IsSparseDirectory() || name != "" && !strings.HasPrefix(name+"/", entry.Name) {
+			sparse = sparse || entry.IsSparseDirectory()
+			entries = append(entries, entry)
+			continue
+		}
+		if entries, err = expandDirectory(gitDir, entries, strings.TrimSuffix(entry.Name, "/"), entry.Digest); err != nil {
+			return nil, err
+		}
+	}
+	if sparse {
+		return NewSparseIndex(entries, idx.ResolveUndo()), nil
+	}
+	return NewIndexWithResolveUndo(entries, idx.ResolveUndo()), nil
+}
+
+// expandDirectory appends entries of files in the tree of the directory, they have skip-worktree flag.
+func expandDirectory(gitDir string, entries []*Entry, dir string, tree []byte) ([]*Entry, error) {
+	obj, err := object.ReadObjectFrom(gitDir, tree)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := obj.(*object.Tree)
+	if !ok {
+		return nil, object.ErrUnexpectedType
+	}
+	for _, file := range t.Entries {
+		name := path.Join(dir, file.Name)
+		if file.Mode.IsTree() {
+			if entries, err = expandDirectory(gitDir, entries, name, file.Digest); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		entries = append(entries, &Entry{
+			ObjectType:   ObjectType(file.Mode >> 12),
+			Permission:   uint16(file.Mode & 0777),
+			Digest:       file.Digest,
+			SkipWorktree: true,
+			Name:         name,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,45 @@
+package index
+
+import "os"
+
+// StatConfig is a type representing how stat information of files is compared with entries.
+// the fields correspond to core.fileMode, core.trustCTime and core.checkStat config.
+type StatConfig struct {
+	FileMode   bool // executable bit of regular files is compared
+	TrustCTime bool // ctime is compared
+	Minimal    bool // core.checkStat=minimal, only seconds of times and the size are compared
+}
+
+// DefaultStatConfig is StatConfig of the default config.
+var DefaultStatConfig = &StatConfig{FileMode: true, TrustCTime: true}
+
+// MatchStat reports whether stat information of the file matches the entry like ie_match_stat of git.
+// the file is regarded as changed if its type or its executable bit is changed.
+// times, the size, the inode, the device, the owner and the group are compared like match_stat_data of git,
+// and the inode and the device are compared by their lower 32 bits which are stored in the index.
+// it doesn't detect racily clean entries, callers must check modification time of the index file.
+func (e *Entry) MatchStat(info os.FileInfo, config *StatConfig) bool {
+	if config == nil {
+		config = DefaultStatConfig
+	}
+	if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+	stat, err := NewEntry(info, nil)
+	if err != nil || stat.ObjectType != e.ObjectType {
+		return false
+	}
+	if config.FileMode && e.ObjectType == RegularFile && stat.Permission&0100 != e.Permission&0100 {
+		return false
+	}
+	if stat.Size != e.Size {
+		return false
+	}
+	if config.Minimal {
+		return stat.MTime.Unix() == e.MTime.Unix() && (!config.TrustCTime || stat.CTime.Unix() == e.CTime.Unix())
+	}
+	if !stat.MTime.Equal(e.MTime) || config.TrustCTime && !stat.CTime.Equal(e.CTime) {
+		return false
+	}
+	return uint32(stat.Ino) == uint32(e.Ino) && stat.Dev == e.Dev && stat.UserID == e.UserID && stat.GroupID == e.GroupID
+}
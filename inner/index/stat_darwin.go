@@ -0,0 +1,14 @@
+//+build darwin
+
+package index
+
+import (
+	"syscall"
+	"time"
+)
+
+// statTimes returns ctime and mtime of the file
+func statTimes(stat *syscall.Stat_t) (time.Time, time.Time) {
+	return time.Unix(stat.Ctimespec.Sec, stat.Ctimespec.Nsec),
+		time.Unix(stat.Mtimespec.Sec, stat.Mtimespec.Nsec)
+}
@@ -0,0 +1,14 @@
+//+build !darwin,!windows
+
+package index
+
+import (
+	"syscall"
+	"time"
+)
+
+// statTimes returns ctime and mtime of the file
+func statTimes(stat *syscall.Stat_t) (time.Time, time.Time) {
+	return time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec)),
+		time.Unix(int64(stat.Mtim.Sec), int64(stat.Mtim.Nsec))
+}
@@ -0,0 +1,10 @@
+package lfs
+
+import "errors"
+
+var (
+	ErrNotPointer     = errors.New("data is not a git lfs pointer")
+	ErrInvalidOID     = errors.New("invalid git lfs object ID")
+	ErrObjectNotFound = errors.New("git lfs object is not found in the local store")
+	ErrSizeMismatch   = errors.New("size of git lfs object doesn't match the pointer")
+)
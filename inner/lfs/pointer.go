@@ -0,0 +1,134 @@
+// lfs is a package to handle Git LFS pointers and the local LFS object store
+//
+// A pointer is a small blob stored in place of a large file, and the content is
+// stored in .git/lfs/objects/<oid[0:2]>/<oid[2:4]>/<oid>
+//
+//  version https://git-lfs.github.com/spec/v1
+//  oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+//  size 12345
+//
+// If you want to know more about Git LFS pointers, please refer to
+// https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md
+package lfs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Version is the version URL of pointers
+	Version = "https://git-lfs.github.com/spec/v1"
+	// FilterName is the name of filter attribute for Git LFS
+	FilterName = "lfs"
+	// MaxPointerSize is the max size of a pointer
+	MaxPointerSize = 1024
+)
+
+// legacyVersion is the version URL of pointers created by old clients
+const legacyVersion = "https://hawser.github.com/spec/v1"
+
+// Pointer is a type representing a Git LFS pointer.
+type Pointer struct {
+	OID        string            // SHA256 digest of the content in lower hex
+	Size       int64             // size of the content
+	Extensions map[string]string // extension lines like "ext-0-foo sha256:...", key doesn't include "ext-"
+}
+
+// NewPointer creates a pointer of the content.
+func NewPointer(oid string, size int64) *Pointer {
+	return &Pointer{OID: oid, Size: size, Extensions: map[string]string{}}
+}
+
+// IsPointer reports whether data is a pointer.
+func IsPointer(data []byte) bool {
+	_, err := ParsePointer(data)
+	return err == nil
+}
+
+// ParsePointer parses a pointer.
+func ParsePointer(data []byte) (*Pointer, error) {
+	if len(data) == 0 || len(data) >= MaxPointerSize || data[len(data)-1] != '\n' {
+		return nil, ErrNotPointer
+	}
+	lines := strings.Split(string(data[:len(data)-1]), "\n")
+	if len(lines) < 3 {
+		return nil, ErrNotPointer
+	}
+	version := strings.TrimPrefix(lines[0], "version ")
+	if version == lines[0] || (version != Version && version != legacyVersion) {
+		return nil, ErrNotPointer
+	}
+
+	p := NewPointer("", -1)
+	prevKey := ""
+	for _, line := range lines[1:] {
+		space := strings.IndexByte(line, ' ')
+		if space <= 0 {
+			return nil, ErrNotPointer
+		}
+		key, value := line[:space], line[space+1:]
+		// keys except version are sorted
+		if key <= prevKey {
+			return nil, ErrNotPointer
+		}
+		prevKey = key
+		switch {
+		case key == "oid":
+			oid := strings.TrimPrefix(value, "sha256:")
+			if oid == value || !isValidOID(oid) {
+				return nil, ErrInvalidOID
+			}
+			p.OID = oid
+		case key == "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return nil, ErrNotPointer
+			}
+			p.Size = size
+		case strings.HasPrefix(key, "ext-"):
+			p.Extensions[key[len("ext-"):]] = value
+		default:
+			return nil, ErrNotPointer
+		}
+	}
+	if p.OID == "" || p.Size < 0 {
+		return nil, ErrNotPointer
+	}
+	return p, nil
+}
+
+// isValidOID reports whether oid is 64 lower hex digits.
+func isValidOID(oid string) bool {
+	if len(oid) != hex.EncodedLen(32) || strings.ToLower(oid) != oid {
+		return false
+	}
+	_, err := hex.DecodeString(oid)
+	return err == nil
+}
+
+// Encode returns content of the pointer blob.
+func (p *Pointer) Encode() []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "version %s\n", Version)
+	extensions := make([]string, 0, len(p.Extensions))
+	for key := range p.Extensions {
+		extensions = append(extensions, key)
+	}
+	sort.Strings(extensions)
+	for _, key := range extensions {
+		fmt.Fprintf(buf, "ext-%s %s\n", key, p.Extensions[key])
+	}
+	fmt.Fprintf(buf, "oid sha256:%s\n", p.OID)
+	fmt.Fprintf(buf, "size %d\n", p.Size)
+	return buf.Bytes()
+}
+
+// String is implementation of fmt.Stringer interface
+func (p *Pointer) String() string {
+	return string(p.Encode())
+}
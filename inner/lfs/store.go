@@ -0,0 +1,170 @@
+package lfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// Store is a type representing the local LFS object store.
+type Store struct {
+	dir string // path to lfs directory, it includes objects and tmp directories
+}
+
+// NewStore creates a Store of the repository.
+// the store is .git/lfs unless lfs.storage is configured.
+// gitDir of parameters must be path to .git directory.
+func NewStore(gitDir string) (*Store, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(gitDir, "lfs")
+	if storage, ok := cfg.Path("lfs.storage"); ok && storage != "" {
+		if !filepath.IsAbs(storage) {
+			storage = filepath.Join(gitDir, storage)
+		}
+		dir = storage
+	}
+	return &Store{dir: dir}, nil
+}
+
+// OpenStore creates a Store of current repository.
+func OpenStore() (*Store, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(gitDir)
+}
+
+// Path returns path to the object in the store.
+func (s *Store) Path(oid string) (string, error) {
+	if !isValidOID(oid) {
+		return "", ErrInvalidOID
+	}
+	return filepath.Join(s.dir, "objects", oid[0:2], oid[2:4], oid), nil
+}
+
+// Has reports whether the content of the pointer is in the store.
+func (s *Store) Has(p *Pointer) bool {
+	path, err := s.Path(p.OID)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Size() == p.Size
+}
+
+// Open opens the content of the pointer in the store.
+func (s *Store) Open(p *Pointer) (*os.File, error) {
+	path, err := s.Path(p.OID)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() != p.Size {
+		file.Close()
+		return nil, ErrSizeMismatch
+	}
+	return file, nil
+}
+
+// Read reads the content of the pointer in the store.
+func (s *Store) Read(p *Pointer) ([]byte, error) {
+	file, err := s.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// Write stores the content read from r, and returns the pointer of it.
+func (s *Store) Write(r io.Reader) (*Pointer, error) {
+	tmpDir := filepath.Join(s.dir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(tmpDir, "object")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := NewPointer(hex.EncodeToString(h.Sum(nil)), size)
+	if s.Has(p) {
+		return p, nil
+	}
+	path, err := s.Path(p.OID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Clean stores the content and returns the pointer blob like $ git lfs clean
+// data which is already a pointer is returned as it is.
+func (s *Store) Clean(data []byte) ([]byte, error) {
+	if IsPointer(data) {
+		return data, nil
+	}
+	p, err := s.Write(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return p.Encode(), nil
+}
+
+// Smudge returns the content of the pointer blob like $ git lfs smudge
+// data which is not a pointer, or whose content is not in the store is returned as it is.
+func (s *Store) Smudge(data []byte) ([]byte, error) {
+	p, err := ParsePointer(data)
+	if err != nil {
+		return data, nil
+	}
+	content, err := s.Read(p)
+	if err == ErrObjectNotFound {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
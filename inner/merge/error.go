@@ -0,0 +1,14 @@
+package merge
+
+import "errors"
+
+var (
+	ErrUnknownStrategy       = errors.New("unknown merge strategy")
+	ErrUnknownStrategyOption = errors.New("unknown option for merge strategy")
+	ErrNotTwoHeads           = errors.New("not handling anything other than two heads merge")
+	ErrUnrelatedHistories    = errors.New("refusing to merge unrelated histories")
+	ErrNoMergeBase           = errors.New("unable to find common commit")
+	ErrOctopusHeads          = errors.New("octopus merge needs two or more heads")
+	ErrOctopusFailed         = errors.New("automated merge did not work, should not be doing an octopus")
+	ErrSubtreeNotFound       = errors.New("subtree not found")
+)
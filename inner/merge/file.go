@@ -0,0 +1,232 @@
+package merge
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/attributes"
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/xdiff"
+)
+
+// FileOptions is a type representing options of merging contents of a file.
+type FileOptions struct {
+	Style      xdiff.ConflictStyle
+	Favor      xdiff.Favor // like -Xours, -Xtheirs and merge=union attribute
+	MarkerSize int         // length of conflict markers, 0 means conflict-marker-size attribute or the default
+	Algorithm  xdiff.Algorithm
+	Flags      xdiff.Flags // like -Xignore-space-change
+
+	// ExtraMarkerSize is added to the length of conflict markers,
+	// it's used to merge contents which may already have conflict markers.
+	ExtraMarkerSize int
+	// Virtual is true while merging merge bases to make a virtual merge base.
+	// binary contents take the ancestor without conflicts, and merge.<driver>.recursive config is used as the driver.
+	Virtual bool
+
+	// labels shown after conflict markers, they may be empty
+	AncestorLabel string
+	OurLabel      string
+	TheirLabel    string
+}
+
+// MergeFile merges changes from base to ours and changes from base to theirs like $ git merge-file,
+// and returns the result and the number of conflicts.
+// if any content is binary, it can't be merged, and the result is ours with a conflict unless Favor is set.
+// options may be nil.
+func MergeFile(base, ours, theirs []byte, options *FileOptions) ([]byte, int) {
+	if options == nil {
+		options = &FileOptions{}
+	}
+	if xdiff.IsBinary(base) || xdiff.IsBinary(ours) || xdiff.IsBinary(theirs) {
+		return mergeBinary(base, ours, theirs, options)
+	}
+	return xdiff.Merge(base, ours, theirs, &xdiff.MergeOptions{
+		Algorithm:     options.Algorithm,
+		Flags:         options.Flags,
+		Style:         options.Style,
+		Favor:         options.Favor,
+		MarkerSize:    markerSize(options),
+		AncestorLabel: options.AncestorLabel,
+		OurLabel:      options.OurLabel,
+		TheirLabel:    options.TheirLabel,
+	})
+}
+
+// mergeBinary takes one side, it's a conflict unless Favor decides the side.
+// the ancestor is taken while merging merge bases.
+func mergeBinary(base, ours, theirs []byte, options *FileOptions) ([]byte, int) {
+	if options.Virtual {
+		return base, 0
+	}
+	switch options.Favor {
+	case xdiff.FavorOurs:
+		return ours, 0
+	case xdiff.FavorTheirs:
+		return theirs, 0
+	}
+	return ours, 1
+}
+
+// markerSize returns the length of conflict markers including ExtraMarkerSize.
+func markerSize(options *FileOptions) int {
+	size := options.MarkerSize
+	if size <= 0 {
+		size = xdiff.DefaultMarkerSize
+	}
+	return size + options.ExtraMarkerSize
+}
+
+// FileMerger is a type to merge contents of files with merge drivers of the repository.
+// the driver of a file is selected by merge attribute.
+//
+//  merge          - the built-in text driver
+//  -merge         - the built-in binary driver, it takes ours with a conflict
+//  merge=text     - the built-in text driver
+//  merge=binary   - the built-in binary driver
+//  merge=union    - the built-in text driver, both sides are taken for conflicts
+//  merge=<driver> - merge.<driver>.driver config is run as a command
+//  (unspecified)  - the driver of merge.default config, or the built-in text driver
+//
+// A command of a custom driver has the following placeholders, and it writes the result to %A.
+// the exit status of the command tells whether it conflicts.
+//
+//  %O - path to a temporary file having the content of the ancestor
+//  %A - path to a temporary file having the content of ours
+//  %B - path to a temporary file having the content of theirs
+//  %L - length of conflict markers
+//  %P - path of the file
+type FileMerger struct {
+	workDir    string
+	config     *config.Config
+	attributes *attributes.Matcher
+	style      xdiff.ConflictStyle // merge.conflictStyle
+}
+
+// NewFileMerger creates a FileMerger of the repository.
+// gitDir of parameters must be path to .git directory.
+func NewFileMerger(gitDir string) (*FileMerger, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := attributes.NewMatcher(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	style, err := xdiff.ParseConflictStyle(cfg.GetString("merge.conflictstyle", "merge"))
+	if err != nil {
+		return nil, err
+	}
+	return &FileMerger{
+		workDir:    filepath.Dir(gitDir),
+		config:     cfg,
+		attributes: matcher,
+		style:      style,
+	}, nil
+}
+
+// Options returns the default options, the conflict style is merge.conflictStyle config.
+func (m *FileMerger) Options() *FileOptions {
+	return &FileOptions{Style: m.style}
+}
+
+// Merge merges contents of the file by its merge driver, and returns the result and the number of conflicts.
+// name is the path relative from top level directory and separated by '/'.
+// options may be nil, then Options() is used.
+func (m *FileMerger) Merge(name string, base, ours, theirs []byte, options *FileOptions) ([]byte, int, error) {
+	if options == nil {
+		options = m.Options()
+	}
+	values, err := m.attributes.Check(name, "merge", "conflict-marker-size")
+	if err != nil {
+		return nil, 0, err
+	}
+	opts := *options
+	if opts.MarkerSize <= 0 && values[1].State == attributes.Valued {
+		if size, err := strconv.Atoi(values[1].Text); err == nil && size > 0 {
+			opts.MarkerSize = size
+		}
+	}
+
+	driver := ""
+	switch value := values[0]; value.State {
+	case attributes.Set:
+		driver = "text"
+	case attributes.Unset:
+		driver = "binary"
+	case attributes.Valued:
+		driver = value.Text
+	default:
+		driver = m.config.GetString("merge.default", "text")
+	}
+	if opts.Virtual {
+		driver = m.config.GetString("merge."+driver+".recursive", driver)
+	}
+	if command, ok := m.config.Get("merge." + driver + ".driver"); ok && command != "" {
+		return m.runDriver(command, name, base, ours, theirs, &opts)
+	}
+	switch driver {
+	case "binary":
+		result, conflicts := mergeBinary(base, ours, theirs, &opts)
+		return result, conflicts, nil
+	case "union":
+		opts.Favor = xdiff.FavorUnion
+	}
+	result, conflicts := MergeFile(base, ours, theirs, &opts)
+	return result, conflicts, nil
+}
+
+// runDriver runs the command of the custom merge driver, the result is what the command writes to %A.
+// a non-zero exit status of the command is a conflict.
+func (m *FileMerger) runDriver(command, name string, base, ours, theirs []byte, options *FileOptions) ([]byte, int, error) {
+	paths := make([]string, 3)
+	for i, content := range [][]byte{base, ours, theirs} {
+		tmp, err := ioutil.TempFile(m.workDir, ".merge_file_")
+		if err != nil {
+			return nil, 0, err
+		}
+		paths[i] = tmp.Name()
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(content); err != nil {
+			tmp.Close()
+			return nil, 0, err
+		}
+		if err := tmp.Close(); err != nil {
+			return nil, 0, err
+		}
+	}
+	command = strings.NewReplacer(
+		"%%", "%",
+		"%O", shellQuote(paths[0]),
+		"%A", shellQuote(paths[1]),
+		"%B", shellQuote(paths[2]),
+		"%L", strconv.Itoa(markerSize(options)),
+		"%P", shellQuote(name),
+	).Replace(command)
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = m.workDir
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	conflicts := 0
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, 0, err
+		}
+		conflicts = 1
+	}
+	result, err := ioutil.ReadFile(paths[1])
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, conflicts, nil
+}
+
+// shellQuote quotes s with single quotes for sh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
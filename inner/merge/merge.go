@@ -0,0 +1,372 @@
+// merge is a package to merge changes of two sides derived from a common ancestor
+//
+// Trees are merged by one of the following strategies like $ git merge -s <strategy>
+//
+//  ort       - the default, renames are detected and criss-cross merges have a virtual merge base
+//  recursive - same as ort
+//  resolve   - paths are merged by the trivial rules, and contents of files modified on both sides are merged
+//  octopus   - two or more heads are merged one by one like resolve
+//  ours      - the result is ours, changes of the other heads are ignored
+//  subtree   - same as ort, but theirs is shifted to match the directory structure of ours
+//
+// Conflicting paths are left in the index at stage 1 (the common ancestor), 2 (ours) and 3 (theirs),
+// and the merged tree has their contents with conflict markers.
+//
+// Contents of a file are merged line by line. changes which don't overlap are taken from both sides, and
+// overlapping changes conflict unless they are identical. a conflict is shown between conflict markers.
+//
+//  <<<<<<< ours
+//  lines of ours
+//  ||||||| base                  (diff3 and zdiff3 style)
+//  lines of the common ancestor
+//  =======
+//  lines of theirs
+//  >>>>>>> theirs
+//
+// If you want to know more about merging, please refer to
+// https://git-scm.com/docs/git-merge#_merge_strategies
+// https://git-scm.com/docs/git-merge-file
+package merge
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/diff"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/revwalk"
+	"github.com/shumon84/mogit/inner/xdiff"
+)
+
+// Strategy is a type representing how trees are merged.
+type Strategy int
+
+// constants of Strategy
+const (
+	StrategyOrt     Strategy = iota // detect renames and merge merge bases recursively, recursive is the same
+	StrategyResolve                 // merge paths by the trivial rules and contents by the content merge
+	StrategyOctopus                 // merge two or more heads one by one like resolve
+	StrategyOurs                    // take ours
+	StrategySubtree                 // shift theirs to match ours, and merge them like ort
+)
+
+// String is implementation of fmt.Stringer interface.
+func (s Strategy) String() string {
+	switch s {
+	case StrategyOrt:
+		return "ort"
+	case StrategyResolve:
+		return "resolve"
+	case StrategyOctopus:
+		return "octopus"
+	case StrategyOurs:
+		return "ours"
+	case StrategySubtree:
+		return "subtree"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseStrategy parses the name of a strategy, recursive is parsed as ort.
+func ParseStrategy(name string) (Strategy, error) {
+	switch name {
+	case "ort", "recursive":
+		return StrategyOrt, nil
+	case "resolve":
+		return StrategyResolve, nil
+	case "octopus":
+		return StrategyOctopus, nil
+	case "ours":
+		return StrategyOurs, nil
+	case "subtree":
+		return StrategySubtree, nil
+	default:
+		return 0, ErrUnknownStrategy
+	}
+}
+
+// Options is a type representing options of merging trees.
+type Options struct {
+	Strategy    Strategy
+	Favor       xdiff.Favor // take ours or theirs for conflicting hunks like -Xours and -Xtheirs, only for ort and subtree
+	Flags       xdiff.Flags // like -Xignore-space-change
+	NoRenames   bool        // don't detect renames like -Xno-renames
+	RenameScore int         // minimum similarity of renames like -Xfind-renames=<n>, in units of diff.MaxScore
+	RenameLimit int         // 0 means merge.renameLimit config and negative means no limit
+
+	// Subtree is the path of the directory where theirs is in ours like -Xsubtree=<path>.
+	// StrategySubtree guesses it if it's empty.
+	Subtree string
+
+	// AllowUnrelatedHistories merges commits which don't have a common ancestor with the empty tree as the base.
+	AllowUnrelatedHistories bool
+
+	// labels shown after conflict markers, they are also used in messages.
+	// AncestorLabel is used only by MergeTrees, Merge labels the merge base by its abbreviated digest.
+	AncestorLabel string
+	OurLabel      string   // "HEAD" if it's empty
+	TheirLabels   []string // labels of theirs in the same order, hex digits of the commit if it's empty
+}
+
+// Result is a type representing the result of merging trees.
+type Result struct {
+	Tree     []byte      // SHA1 digest of the merged tree, conflicting files have conflict markers
+	Index    index.Index // entries of the merged tree, conflicting paths are at stage 1, 2 and 3
+	Clean    bool        // whether no paths conflict
+	Messages []string    // messages like "CONFLICT (content): Merge conflict in <path>"
+}
+
+// ParseStrategyOption parses an option of strategies like -X<option>, and sets it to the options.
+//
+//  ours, theirs                  - Favor
+//  ignore-space-change, ignore-all-space, ignore-space-at-eol, ignore-cr-at-eol - Flags
+//  no-renames                    - NoRenames
+//  find-renames[=<n>]            - RenameScore, renames are detected
+//  rename-threshold=<n>          - RenameScore
+//  subtree[=<path>]              - Subtree
+//  patience, diff-algorithm=<a>  - accepted but ignored, contents are always merged with histogram diff like merge-ort
+func (o *Options) ParseStrategyOption(option string) error {
+	name, value, hasValue := option, "", false
+	if i := strings.IndexByte(option, '='); i >= 0 {
+		name, value, hasValue = option[:i], option[i+1:], true
+	}
+	switch {
+	case option == "ours":
+		o.Favor = xdiff.FavorOurs
+	case option == "theirs":
+		o.Favor = xdiff.FavorTheirs
+	case option == "ignore-space-change":
+		o.Flags |= xdiff.IgnoreSpaceChange
+	case option == "ignore-all-space":
+		o.Flags |= xdiff.IgnoreAllSpace
+	case option == "ignore-space-at-eol":
+		o.Flags |= xdiff.IgnoreSpaceAtEOL
+	case option == "ignore-cr-at-eol":
+		o.Flags |= xdiff.IgnoreCRAtEOL
+	case option == "no-renames":
+		o.NoRenames = true
+	case name == "find-renames", name == "rename-threshold" && hasValue:
+		o.NoRenames = false
+		if hasValue {
+			score, err := diff.ParseScore(value)
+			if err != nil {
+				return err
+			}
+			o.RenameScore = score
+		}
+	case name == "subtree":
+		o.Subtree = value
+	case option == "patience":
+	case name == "diff-algorithm" && hasValue:
+		if _, err := xdiff.ParseAlgorithm(value); err != nil {
+			return err
+		}
+	default:
+		return ErrUnknownStrategyOption
+	}
+	return nil
+}
+
+// merger is a type holding state common to strategies.
+type merger struct {
+	gitDir  string
+	options Options
+	config  *config.Config
+	files   *FileMerger
+}
+
+func newMerger(gitDir string, options *Options) (*merger, error) {
+	m := &merger{gitDir: gitDir}
+	if options != nil {
+		m.options = *options
+	}
+	var err error
+	if m.config, err = config.Load(gitDir); err != nil {
+		return nil, err
+	}
+	if m.files, err = NewFileMerger(gitDir); err != nil {
+		return nil, err
+	}
+	if m.options.RenameLimit == 0 {
+		limit, err := m.config.Int("diff.renamelimit", defaultRenameLimit)
+		if err != nil {
+			return nil, err
+		}
+		if m.options.RenameLimit, err = m.config.Int("merge.renamelimit", limit); err != nil {
+			return nil, err
+		}
+	}
+	if !m.options.NoRenames {
+		renames, err := m.config.Bool("diff.renames", true)
+		if err != nil {
+			return nil, err
+		}
+		if renames, err = m.config.Bool("merge.renames", renames); err != nil {
+			return nil, err
+		}
+		m.options.NoRenames = !renames
+	}
+	return m, nil
+}
+
+// Merge merges theirs into ours by the strategy like $ git merge -s <strategy> <theirs>...
+// ours and theirs are SHA1 digests of commits, and merge bases are found from their history.
+// StrategyOctopus needs two or more theirs, StrategyOurs accepts any number of theirs,
+// and the other strategies need just one.
+// gitDir of parameters must be path to .git directory, options may be nil.
+func Merge(gitDir string, ours []byte, theirs [][]byte, options *Options) (*Result, error) {
+	m, err := newMerger(gitDir, options)
+	if err != nil {
+		return nil, err
+	}
+	switch m.options.Strategy {
+	case StrategyOurs:
+		return m.mergeOurs(ours)
+	case StrategyOctopus:
+		return m.mergeOctopus(ours, theirs)
+	}
+	if len(theirs) != 1 {
+		return nil, ErrNotTwoHeads
+	}
+	bases, err := revwalk.MergeBases(gitDir, ours, theirs[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 && !m.options.AllowUnrelatedHistories {
+		return nil, ErrUnrelatedHistories
+	}
+	switch m.options.Strategy {
+	case StrategyOrt, StrategySubtree:
+		return m.mergeOrt(bases, ours, theirs[0])
+	case StrategyResolve:
+		if len(bases) == 0 {
+			return nil, ErrNoMergeBase
+		}
+		return m.mergeResolve(bases, ours, theirs[0])
+	default:
+		return nil, ErrUnknownStrategy
+	}
+}
+
+// MergeTrees merges changes from base to ours and changes from base to theirs by the strategy.
+// base, ours and theirs are SHA1 digests of trees or commits, nil means the empty tree.
+// merge bases are not merged recursively even if they are commits, and StrategyOctopus merges them like StrategyResolve.
+// gitDir of parameters must be path to .git directory, options may be nil.
+func MergeTrees(gitDir string, base, ours, theirs []byte, options *Options) (*Result, error) {
+	m, err := newMerger(gitDir, options)
+	if err != nil {
+		return nil, err
+	}
+	baseTree, err := m.treeOf(base)
+	if err != nil {
+		return nil, err
+	}
+	ourTree, err := m.treeOf(ours)
+	if err != nil {
+		return nil, err
+	}
+	theirTree, err := m.treeOf(theirs)
+	if err != nil {
+		return nil, err
+	}
+	switch m.options.Strategy {
+	case StrategyOurs:
+		return m.mergeOurs(ourTree)
+	case StrategyOrt, StrategySubtree:
+		ancestor := m.options.AncestorLabel
+		if ancestor == "" {
+			ancestor = "base"
+		}
+		o, err := m.mergeTreesOrt(baseTree, ourTree, theirTree, ancestor, m.ourLabel(), m.theirLabel(0, theirs), 0)
+		if err != nil {
+			return nil, err
+		}
+		return o.result()
+	case StrategyResolve, StrategyOctopus:
+		r, err := m.resolveTrees([][]byte{baseTree}, ourTree, theirTree, m.options.AncestorLabel, m.ourLabel(), m.theirLabel(0, theirs))
+		if err != nil {
+			return nil, err
+		}
+		return r.result()
+	default:
+		return nil, ErrUnknownStrategy
+	}
+}
+
+// mergeOurs takes the tree of ours, it may be a commit or a tree.
+func (m *merger) mergeOurs(ours []byte) (*Result, error) {
+	tree, err := m.treeOf(ours)
+	if err != nil {
+		return nil, err
+	}
+	t, err := listTree(m.gitDir, tree)
+	if err != nil {
+		return nil, err
+	}
+	return m.result(t, true, nil)
+}
+
+// result creates Result having the tree.
+func (m *merger) result(tree *mergedTree, clean bool, messages []string) (*Result, error) {
+	digest, err := tree.write(m.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := tree.index(m.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	if messages == nil {
+		messages = []string{}
+	}
+	return &Result{Tree: digest, Index: idx, Clean: clean, Messages: messages}, nil
+}
+
+func (m *merger) ourLabel() string {
+	if m.options.OurLabel != "" {
+		return m.options.OurLabel
+	}
+	return "HEAD"
+}
+
+// theirLabel returns the label of i-th theirs.
+func (m *merger) theirLabel(i int, digest []byte) string {
+	if i < len(m.options.TheirLabels) && m.options.TheirLabels[i] != "" {
+		return m.options.TheirLabels[i]
+	}
+	return hex.EncodeToString(digest)
+}
+
+// treeOf returns the digest of the tree, or the tree of the commit.
+// nil is returned as it is, it means the empty tree.
+func (m *merger) treeOf(digest []byte) ([]byte, error) {
+	if digest == nil {
+		return nil, nil
+	}
+	obj, err := object.ReadObjectFrom(m.gitDir, digest)
+	if err != nil {
+		return nil, err
+	}
+	switch obj := obj.(type) {
+	case *object.Commit:
+		return obj.Tree, nil
+	case *object.Tree:
+		return digest, nil
+	default:
+		return nil, object.ErrUnexpectedType
+	}
+}
+
+// abbrev returns the shortest unique abbreviation of the digest, it has 7 hex digits at least.
+func (m *merger) abbrev(digest []byte) string {
+	name := hex.EncodeToString(digest)
+	for n := 7; n < len(name); n++ {
+		if candidates, err := object.FindObjects(m.gitDir, name[:n]); err == nil && len(candidates) <= 1 {
+			return name[:n]
+		}
+	}
+	return name
+}
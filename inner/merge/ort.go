@@ -0,0 +1,825 @@
+package merge
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/diff"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/revwalk"
+	"github.com/shumon84/mogit/inner/xdiff"
+)
+
+// defaultRenameLimit is the limit of the number of paths for inexact renames while merging.
+const defaultRenameLimit = 7000
+
+// indices of stages in conflictInfo
+const (
+	mergeBase = iota
+	mergeSide1
+	mergeSide2
+)
+
+// conflictInfo is a type holding versions of a path in the three trees and how it's merged.
+// index 0 of arrays is the merge base, 1 is ours and 2 is theirs.
+type conflictInfo struct {
+	stages       [3]version
+	pathnames    [3]string // paths where versions come from, they differ for renames
+	filemask     int       // bits of trees having a file at the path
+	dirmask      int       // bits of trees having a directory at the path
+	matchmask    int       // bits of trees having the same version, 0 if none of them match
+	dfConflict   bool      // the path is a file in a tree and a directory in another tree
+	pathConflict bool      // the path is involved in a rename conflict
+	clean        bool      // the path is merged without conflicts, it's set before processing if it's trivial
+	result       version   // merged version of the path
+}
+
+// ort is a type holding state while merging trees by the ort strategy.
+type ort struct {
+	*merger
+	depth      int // depth of merging merge bases, 0 is the merge which the caller wants
+	ancestor   string
+	branch1    string
+	branch2    string
+	paths      map[string]*conflictInfo
+	subtrees   map[string][]byte // sub trees which are the same in all trees
+	nonEmpty   map[string]bool   // directories having merged paths
+	conflicted map[string]*conflictInfo
+	results    map[string]version
+	messages   map[string][]string // messages of each path
+	tree       *mergedTree
+}
+
+// virtualCommit is a type representing a commit, or a merge of merge bases which is not a real commit.
+type virtualCommit struct {
+	tree []byte
+	tips [][]byte // real commits which it's made from, it's only itself for real commits
+}
+
+func (m *merger) virtualCommit(digest []byte) (*virtualCommit, error) {
+	tree, err := m.treeOf(digest)
+	if err != nil {
+		return nil, err
+	}
+	return &virtualCommit{tree: tree, tips: [][]byte{digest}}, nil
+}
+
+// mergeOrt merges the commits by the ort strategy, bases are merge bases of them.
+func (m *merger) mergeOrt(bases [][]byte, ours, theirs []byte) (*Result, error) {
+	h1, err := m.virtualCommit(ours)
+	if err != nil {
+		return nil, err
+	}
+	h2, err := m.virtualCommit(theirs)
+	if err != nil {
+		return nil, err
+	}
+	o, err := m.mergeRecursive(bases, h1, h2, m.ourLabel(), m.theirLabel(0, theirs), 0)
+	if err != nil {
+		return nil, err
+	}
+	return o.result()
+}
+
+// mergeRecursive merges h1 and h2, h2 must be a real commit.
+// if there are multiple merge bases, they are merged into a virtual merge base from the oldest one.
+// bases are found if they are nil.
+func (m *merger) mergeRecursive(bases [][]byte, h1, h2 *virtualCommit, branch1, branch2 string, depth int) (*ort, error) {
+	if bases == nil {
+		var err error
+		if bases, err = revwalk.MergeBases(m.gitDir, h2.tips[0], h1.tips...); err != nil {
+			return nil, err
+		}
+	}
+	var merged *virtualCommit
+	ancestor := "merged common ancestors"
+	switch len(bases) {
+	case 0:
+		merged = &virtualCommit{}
+		ancestor = "empty tree"
+	case 1:
+		ancestor = m.abbrev(bases[0])
+	}
+	for i := len(bases) - 1; i >= 0; i-- {
+		next, err := m.virtualCommit(bases[i])
+		if err != nil {
+			return nil, err
+		}
+		if merged == nil {
+			merged = next
+			continue
+		}
+		o, err := m.mergeRecursive(nil, merged, next, "Temporary merge branch 1", "Temporary merge branch 2", depth+1)
+		if err != nil {
+			return nil, err
+		}
+		tree, err := o.tree.write(m.gitDir)
+		if err != nil {
+			return nil, err
+		}
+		tips := append(append([][]byte{}, merged.tips...), next.tips...)
+		merged = &virtualCommit{tree: tree, tips: tips}
+	}
+	return m.mergeTreesOrt(merged.tree, h1.tree, h2.tree, ancestor, branch1, branch2, depth)
+}
+
+// mergeTreesOrt merges the trees by the ort strategy without merging merge bases.
+func (m *merger) mergeTreesOrt(base, side1, side2 []byte, ancestor, branch1, branch2 string, depth int) (*ort, error) {
+	if m.options.Strategy == StrategySubtree || m.options.Subtree != "" {
+		var err error
+		if side2, err = m.shift(side1, side2); err != nil {
+			return nil, err
+		}
+		if base, err = m.shift(side1, base); err != nil {
+			return nil, err
+		}
+	}
+	o := &ort{
+		merger:     m,
+		depth:      depth,
+		ancestor:   ancestor,
+		branch1:    branch1,
+		branch2:    branch2,
+		paths:      map[string]*conflictInfo{},
+		subtrees:   map[string][]byte{},
+		nonEmpty:   map[string]bool{},
+		conflicted: map[string]*conflictInfo{},
+		results:    map[string]version{},
+		messages:   map[string][]string{},
+	}
+	trees := [3][]byte{base, side1, side2}
+	if err := o.collect(".", trees); err != nil {
+		return nil, err
+	}
+	if !m.options.NoRenames {
+		renames, err := o.detectRenames(trees)
+		if err != nil {
+			return nil, err
+		}
+		if err := o.processRenames(renames); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.process(); err != nil {
+		return nil, err
+	}
+
+	o.tree = newMergedTree()
+	o.tree.files = o.results
+	o.tree.subtrees = o.subtrees
+	for name, ci := range o.conflicted {
+		stages := [3]version{}
+		for i := range stages {
+			if ci.filemask&(1<<uint(i)) != 0 {
+				stages[i] = ci.stages[i]
+			}
+		}
+		o.tree.conflicts[name] = stages
+	}
+	return o, nil
+}
+
+// result returns the result of the merge, messages are sorted by path.
+func (o *ort) result() (*Result, error) {
+	names := make([]string, 0, len(o.messages))
+	for name := range o.messages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	messages := []string{}
+	for _, name := range names {
+		messages = append(messages, o.messages[name]...)
+	}
+	return o.merger.result(o.tree, len(o.conflicted) == 0, messages)
+}
+
+// message records a message about the path, messages of merging merge bases are dropped.
+func (o *ort) message(name, format string, args ...interface{}) {
+	if o.depth > 0 {
+		return
+	}
+	o.messages[name] = append(o.messages[name], fmt.Sprintf(format, args...))
+}
+
+// collect reads entries of the three trees, and records versions of each path.
+// paths which are trivially merged are resolved, and sub trees which are the same in all trees aren't read.
+func (o *ort) collect(prefix string, trees [3][]byte) error {
+	var entries [3]map[string]*object.TreeEntry
+	names := []string{}
+	seen := map[string]bool{}
+	for i, digest := range trees {
+		tree, err := readTree(o.gitDir, digest)
+		if err != nil {
+			return err
+		}
+		entries[i] = map[string]*object.TreeEntry{}
+		for _, entry := range tree.Entries {
+			entries[i][entry.Name] = entry
+			if !seen[entry.Name] {
+				seen[entry.Name] = true
+				names = append(names, entry.Name)
+			}
+		}
+	}
+
+	for _, name := range names {
+		fullPath := joinPath(prefix, name)
+		ci := &conflictInfo{pathnames: [3]string{fullPath, fullPath, fullPath}}
+		var versions [3]version
+		var subtrees [3][]byte
+		for i := range entries {
+			entry, ok := entries[i][name]
+			if !ok {
+				continue
+			}
+			versions[i] = version{mode: entry.Mode, digest: entry.Digest}
+			if entry.Mode.IsTree() {
+				ci.dirmask |= 1 << uint(i)
+				subtrees[i] = entry.Digest
+			} else {
+				ci.filemask |= 1 << uint(i)
+				ci.stages[i] = versions[i]
+			}
+		}
+		side1MatchesBase := !versions[mergeSide1].isNull() && versions[mergeSide1].same(versions[mergeBase])
+		side2MatchesBase := !versions[mergeSide2].isNull() && versions[mergeSide2].same(versions[mergeBase])
+		sidesMatch := !versions[mergeSide1].isNull() && versions[mergeSide1].same(versions[mergeSide2])
+
+		if side1MatchesBase && side2MatchesBase {
+			if ci.dirmask != 0 {
+				o.subtrees[fullPath] = versions[mergeBase].digest
+				o.markNonEmpty(fullPath)
+			} else {
+				ci.clean = true
+				ci.result = versions[mergeBase]
+				o.paths[fullPath] = ci
+			}
+			continue
+		}
+		if ci.dirmask != 0 {
+			if err := o.collect(fullPath, subtrees); err != nil {
+				return err
+			}
+		}
+		ci.dfConflict = ci.filemask != 0 && ci.dirmask != 0
+		switch {
+		case side1MatchesBase:
+			ci.matchmask = 3
+		case side2MatchesBase:
+			ci.matchmask = 5
+		case sidesMatch:
+			ci.matchmask = 6
+		}
+		// three files can be resolved now, renames don't change them
+		if ci.filemask == 7 && ci.matchmask != 0 {
+			ci.clean = true
+			switch ci.matchmask {
+			case 3:
+				ci.result = ci.stages[mergeSide2]
+			default:
+				ci.result = ci.stages[mergeSide1]
+			}
+		}
+		o.paths[fullPath] = ci
+	}
+	return nil
+}
+
+// rename is a type representing a rename on a side.
+type rename struct {
+	oldPath string
+	newPath string
+	side    int
+}
+
+// detectRenames detects renames from the merge base to each side.
+// they are sorted by the old path, and then by the side.
+func (o *ort) detectRenames(trees [3][]byte) ([]*rename, error) {
+	renames := []*rename{}
+	for side := mergeSide1; side <= mergeSide2; side++ {
+		if bytes.Equal(trees[mergeBase], trees[side]) {
+			continue
+		}
+		// a deleted path matters only if the other side changed it
+		relevant := map[string]bool{}
+		for name, ci := range o.paths {
+			if ci.filemask&1 != 0 && ci.filemask&(1<<uint(side)) == 0 && ci.matchmask&ci.filemask == 0 {
+				relevant[name] = true
+			}
+		}
+		changes, err := diff.TreeToTree(o.gitDir, trees[mergeBase], trees[side], &diff.Options{
+			DetectRenames:   true,
+			RenameScore:     o.options.RenameScore,
+			RenameLimit:     o.options.RenameLimit,
+			RelevantSources: relevant,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range changes {
+			if change.Type == diff.Renamed {
+				renames = append(renames, &rename{oldPath: change.From.Path, newPath: change.To.Path, side: side})
+			}
+		}
+	}
+	sort.SliceStable(renames, func(i, j int) bool {
+		if renames[i].oldPath != renames[j].oldPath {
+			return renames[i].oldPath < renames[j].oldPath
+		}
+		return renames[i].side < renames[j].side
+	})
+	return renames, nil
+}
+
+// processRenames moves versions of renamed paths to their new paths, and records rename conflicts.
+func (o *ort) processRenames(renames []*rename) error {
+	for i := 0; i < len(renames); i++ {
+		r := renames[i]
+		oldInfo, newInfo := o.paths[r.oldPath], o.paths[r.newPath]
+		// the other side didn't change the old path, or it has already been handled
+		if oldInfo == nil || newInfo == nil || oldInfo.clean {
+			continue
+		}
+
+		if i+1 < len(renames) && renames[i+1].oldPath == r.oldPath {
+			// both sides renamed the path
+			pathnames := [3]string{r.oldPath, r.newPath, renames[i+1].newPath}
+			base, side1, side2 := oldInfo, newInfo, o.paths[pathnames[2]]
+			i++
+			if pathnames[1] == pathnames[2] {
+				// rename/rename(1to1)
+				side1.stages[mergeBase] = base.stages[mergeBase]
+				side1.filemask |= 1 << mergeBase
+				base.result = version{}
+				base.clean = true
+				continue
+			}
+
+			// rename/rename(1to2)
+			merged, clean, err := o.mergeContents(r.oldPath, base.stages[mergeBase], side1.stages[mergeSide1], side2.stages[mergeSide2], pathnames, 1+2*o.depth)
+			if err != nil {
+				return err
+			}
+			binary := !clean && merged.same(side1.stages[mergeSide1])
+			side1.stages[mergeSide1] = merged
+			if binary {
+				// binary contents can't be merged, each path keeps its own content
+				merged = side2.stages[mergeSide2]
+			}
+			side2.stages[mergeSide2] = merged
+			side1.pathConflict = true
+			side2.pathConflict = true
+			// the old path is left at stage 1 like git
+			base.pathConflict = true
+			o.message(pathnames[0], "CONFLICT (rename/rename): %s renamed to %s in %s and to %s in %s.",
+				pathnames[0], pathnames[1], o.branch1, pathnames[2], o.branch2)
+			continue
+		}
+
+		target := r.side
+		other := 3 - target
+		sourceDeleted := oldInfo.filemask == 1
+		collision := newInfo.filemask&(1<<uint(other)) != 0
+		typeChanged := !sourceDeleted && isRegular(oldInfo.stages[other].mode) != isRegular(newInfo.stages[target].mode)
+		if typeChanged && collision {
+			// the other side also renamed the path but it wasn't detected because a new file is at the old path,
+			// it's merged like a normal rename
+			collision = false
+		}
+		renameBranch, deleteBranch := o.branch1, o.branch2
+		if target == mergeSide2 {
+			renameBranch, deleteBranch = o.branch2, o.branch1
+		}
+
+		switch {
+		case collision && !sourceDeleted:
+			// rename/add, content of the rename is merged first, and then it's merged with the added one
+			var pathnames [3]string
+			pathnames[mergeBase], pathnames[other], pathnames[target] = r.oldPath, r.oldPath, r.newPath
+			stages := [3]version{o.paths[pathnames[0]].stages[mergeBase], o.paths[pathnames[1]].stages[mergeSide1], o.paths[pathnames[2]].stages[mergeSide2]}
+			merged, clean, err := o.mergeContents(r.oldPath, stages[0], stages[1], stages[2], pathnames, 1+2*o.depth)
+			if err != nil {
+				return err
+			}
+			newInfo.stages[target] = merged
+			if !clean {
+				o.message(r.newPath, "CONFLICT (rename involved in collision): rename of %s -> %s has content conflicts AND collides with another path; this may result in nested conflict markers.",
+					r.oldPath, r.newPath)
+			}
+		case collision && sourceDeleted:
+			// rename/add/delete, it's left as an add/add conflict
+			newInfo.pathConflict = true
+			o.message(r.newPath, "CONFLICT (rename/delete): %s renamed to %s in %s, but deleted in %s.",
+				r.oldPath, r.newPath, renameBranch, deleteBranch)
+		default:
+			newInfo.stages[mergeBase] = oldInfo.stages[mergeBase]
+			newInfo.filemask |= 1 << mergeBase
+			newInfo.pathnames[mergeBase] = r.oldPath
+			switch {
+			case typeChanged:
+				// the old path has another type of file on the other side, it's merged with nothing
+				oldInfo.stages[mergeBase] = version{}
+				oldInfo.filemask &= 6
+			case sourceDeleted:
+				newInfo.pathConflict = true
+				o.message(r.newPath, "CONFLICT (rename/delete): %s renamed to %s in %s, but deleted in %s.",
+					r.oldPath, r.newPath, renameBranch, deleteBranch)
+			default:
+				newInfo.stages[other] = oldInfo.stages[other]
+				newInfo.filemask |= 1 << uint(other)
+				newInfo.pathnames[other] = r.oldPath
+			}
+		}
+		if !typeChanged {
+			oldInfo.result = version{}
+			oldInfo.clean = true
+		}
+	}
+	return nil
+}
+
+// process merges each path. paths under a directory are processed before the directory,
+// so it's known whether the directory is in the way of a file at the same path.
+func (o *ort) process() error {
+	names := make([]string, 0, len(o.paths))
+	for name := range o.paths {
+		names = append(names, name)
+	}
+	// directories are sorted right before their contents, and they are processed in reverse order
+	sort.Slice(names, func(i, j int) bool {
+		return names[i]+"/" > names[j]+"/"
+	})
+	for _, name := range names {
+		ci := o.paths[name]
+		if ci.clean {
+			o.record(name, ci.result)
+			continue
+		}
+		if err := o.processEntry(name, ci); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record records the merged version of the path.
+func (o *ort) record(name string, v version) {
+	if v.isNull() {
+		return
+	}
+	o.results[name] = v
+	o.markNonEmpty(name)
+}
+
+// markNonEmpty marks directories containing the path as not empty.
+func (o *ort) markNonEmpty(name string) {
+	for dir := path.Dir(name); dir != "." && !o.nonEmpty[dir]; dir = path.Dir(dir) {
+		o.nonEmpty[dir] = true
+	}
+}
+
+// processEntry merges versions of the path.
+func (o *ort) processEntry(name string, ci *conflictInfo) error {
+	dfFileIndex := 0
+	if ci.dirmask != 0 && ci.filemask == 0 {
+		// the directory is merged by its contents
+		return nil
+	}
+
+	if ci.dfConflict && !o.nonEmpty[name] {
+		// the directory has become empty, the file can be placed here
+		ci.dfConflict = false
+		ci.clean = false
+		ci.matchmask &^= ci.dirmask
+		ci.dirmask = 0
+		ci.zeroDirectories()
+	} else if ci.dfConflict {
+		if ci.filemask == 1 {
+			// the file is deleted on both sides
+			ci.filemask = 0
+			return nil
+		}
+		// the directory remains, the file is moved to another path
+		moved := *ci
+		moved.matchmask &^= moved.dirmask
+		moved.dirmask = 0
+		moved.zeroDirectories()
+		dfFileIndex = mergeSide1
+		if ci.dirmask&(1<<mergeSide1) != 0 {
+			dfFileIndex = mergeSide2
+		}
+		branch := o.branch(dfFileIndex)
+		oldName := name
+		name = o.uniquePath(name, branch)
+		o.paths[name] = &moved
+		o.message(name, "CONFLICT (file/directory): directory in the way of %s from %s; moving it to %s instead.",
+			oldName, branch, name)
+		ci.filemask = 0
+		ci = &moved
+	}
+
+	switch {
+	case ci.matchmask != 0:
+		ci.clean = !ci.dfConflict && !ci.pathConflict
+		if ci.matchmask == 6 {
+			ci.result = ci.stages[mergeSide1]
+		} else {
+			// take the side which doesn't match
+			side := mergeSide1
+			if 7&^ci.matchmask == 4 {
+				side = mergeSide2
+			}
+			ci.result = ci.stages[side]
+			if ci.result.isNull() {
+				ci.clean = true
+			}
+		}
+	case ci.filemask >= 6 && fileType(ci.stages[mergeSide1].mode) != fileType(ci.stages[mergeSide2].mode):
+		if o.depth > 0 {
+			ci.clean = false
+			ci.result = ci.stages[mergeBase]
+			break
+		}
+		name = o.separateTypes(name, ci)
+	case ci.filemask >= 6:
+		merged, clean, err := o.mergeContents(name, ci.stages[mergeBase], ci.stages[mergeSide1], ci.stages[mergeSide2], ci.pathnames, 2*o.depth)
+		if err != nil {
+			return err
+		}
+		ci.clean = clean && !ci.dfConflict && !ci.pathConflict
+		ci.result = merged
+		if clean && ci.dfConflict {
+			ci.filemask = 1 << uint(dfFileIndex)
+			ci.stages[dfFileIndex] = merged
+		}
+		if !clean {
+			reason := "content"
+			if ci.filemask == 6 {
+				reason = "add/add"
+			}
+			if merged.mode == object.ModeGitLink {
+				reason = "submodule"
+			}
+			o.message(name, "CONFLICT (%s): Merge conflict in %s", reason, name)
+		}
+	case ci.filemask == 3 || ci.filemask == 5:
+		// modify/delete, the modified version is left unless merging merge bases
+		side := mergeSide1
+		if ci.filemask == 5 {
+			side = mergeSide2
+		}
+		if o.depth > 0 {
+			ci.result = ci.stages[mergeBase]
+		} else {
+			ci.result = ci.stages[side]
+		}
+		ci.clean = false
+		modifyBranch, deleteBranch := o.branch(side), o.branch(3-side)
+		// a rename/delete conflict without modification has been reported already
+		if !ci.pathConflict || !bytes.Equal(ci.stages[mergeBase].digest, ci.stages[side].digest) {
+			o.message(name, "CONFLICT (modify/delete): %s deleted in %s and modified in %s.  Version %s of %s left in tree.",
+				name, deleteBranch, modifyBranch, modifyBranch, name)
+		}
+	case ci.filemask == 2 || ci.filemask == 4:
+		// added on one side
+		side := mergeSide1
+		if ci.filemask == 4 {
+			side = mergeSide2
+		}
+		ci.result = ci.stages[side]
+		ci.clean = !ci.dfConflict && !ci.pathConflict
+	case ci.filemask == 1:
+		// deleted on both sides
+		ci.result = version{}
+		ci.clean = !ci.pathConflict
+	}
+
+	if !ci.clean {
+		o.conflicted[name] = ci
+	}
+	o.record(name, ci.result)
+	return nil
+}
+
+// zeroDirectories clears stages which are directories.
+func (ci *conflictInfo) zeroDirectories() {
+	for i := range ci.stages {
+		if ci.filemask&(1<<uint(i)) == 0 {
+			ci.stages[i] = version{}
+		}
+	}
+}
+
+// separateTypes handles the path having different types of files on each side.
+// regular files are moved to another path so that both can be recorded, or both are moved if neither is regular.
+// it returns the path where ours is placed.
+func (o *ort) separateTypes(name string, ci *conflictInfo) string {
+	baseMode, aMode, bMode := ci.stages[mergeBase].mode, ci.stages[mergeSide1].mode, ci.stages[mergeSide2].mode
+	renameA, renameB := false, false
+	switch {
+	case isRegular(aMode):
+		renameA = true
+	case isRegular(bMode):
+		renameB = true
+	default:
+		renameA, renameB = true, true
+	}
+	if renameA && renameB {
+		o.message(name, "CONFLICT (distinct types): %s had different types on each side; renamed both of them so each can be recorded somewhere.", name)
+	} else {
+		o.message(name, "CONFLICT (distinct types): %s had different types on each side; renamed one of them so each can be recorded somewhere.", name)
+	}
+
+	ci.clean = false
+	theirs := *ci
+	theirs.result = ci.stages[mergeSide2]
+	theirs.stages[mergeSide1] = version{}
+	theirs.filemask = 5
+	if fileType(bMode) != fileType(baseMode) {
+		theirs.stages[mergeBase] = version{}
+		theirs.filemask = 4
+	}
+
+	ci.result = ci.stages[mergeSide1]
+	ci.stages[mergeSide2] = version{}
+	ci.filemask = 3
+	if fileType(aMode) != fileType(baseMode) {
+		ci.stages[mergeBase] = version{}
+		ci.filemask = 2
+	}
+
+	aName := name
+	if renameA {
+		aName = o.uniquePath(name, o.branch1)
+		o.paths[aName] = ci
+	}
+	bName := name
+	if renameB {
+		bName = o.uniquePath(name, o.branch2)
+	}
+	o.paths[bName] = &theirs
+	if renameA && renameB {
+		delete(o.paths, name)
+	}
+	o.conflicted[bName] = &theirs
+	o.record(bName, theirs.result)
+	return aName
+}
+
+// branch returns the label of the side.
+func (o *ort) branch(side int) string {
+	if side == mergeSide1 {
+		return o.branch1
+	}
+	return o.branch2
+}
+
+// uniquePath returns a path which isn't used, like <path>~<branch>.
+// '/' in the branch is replaced with '_'.
+func (o *ort) uniquePath(name, branch string) string {
+	base := name + "~" + strings.Replace(branch, "/", "_", -1)
+	unique := base
+	for i := 0; ; i++ {
+		_, used := o.paths[unique]
+		if _, ok := o.subtrees[unique]; !used && !ok {
+			return unique
+		}
+		unique = fmt.Sprintf("%s_%d", base, i)
+	}
+}
+
+// mergeContents merges the three versions of the same type, and returns the merged version and whether it's clean.
+// name is the path where the result is placed, and pathnames are paths where versions come from.
+// extraMarkerSize is added to the length of conflict markers.
+func (o *ort) mergeContents(name string, base, a, b version, pathnames [3]string, extraMarkerSize int) (version, bool, error) {
+	clean := true
+	result := version{}
+	if a.mode == b.mode || a.mode == base.mode {
+		result.mode = b.mode
+	} else {
+		// the executable bit is changed on both sides
+		result.mode = a.mode
+		clean = b.mode == base.mode
+	}
+
+	switch {
+	case bytes.Equal(a.digest, b.digest) || bytes.Equal(a.digest, base.digest):
+		result.digest = b.digest
+	case bytes.Equal(b.digest, base.digest):
+		result.digest = a.digest
+	case isRegular(a.mode):
+		twoWay := fileType(base.mode) != fileType(a.mode)
+		baseDigest := base.digest
+		if twoWay {
+			baseDigest = nil
+		}
+		digest, conflicts, err := o.mergeFile(name, baseDigest, a.digest, b.digest, pathnames, extraMarkerSize)
+		if err != nil {
+			return version{}, false, err
+		}
+		result.digest = digest
+		clean = clean && conflicts == 0
+		o.message(name, "Auto-merging %s", name)
+	case a.mode == object.ModeGitLink:
+		twoWay := fileType(base.mode) != fileType(a.mode)
+		baseDigest := base.digest
+		if twoWay {
+			baseDigest = nil
+		}
+		result.digest, clean = o.mergeSubmodule(pathnames[mergeBase], baseDigest, a.digest, b.digest)
+		if o.depth > 0 && twoWay && !clean {
+			result = base
+		}
+	case a.mode == object.ModeSymlink:
+		switch {
+		case o.depth > 0:
+			clean = false
+			result = base
+		case o.options.Favor == xdiff.FavorOurs:
+			result.digest = a.digest
+		case o.options.Favor == xdiff.FavorTheirs:
+			result.digest = b.digest
+		default:
+			clean = false
+			result.digest = a.digest
+		}
+	}
+	return result, clean, nil
+}
+
+// mergeFile merges contents of blobs by the merge driver of the path, and writes the result as a blob.
+// nil base means the empty content.
+func (o *ort) mergeFile(name string, base, a, b []byte, pathnames [3]string, extraMarkerSize int) ([]byte, int, error) {
+	var contents [3][]byte
+	for i, digest := range [][]byte{base, a, b} {
+		var err error
+		if contents[i], err = readBlob(o.gitDir, digest); err != nil {
+			return nil, 0, err
+		}
+	}
+	options := o.files.Options()
+	options.Algorithm = xdiff.Histogram
+	options.Flags = o.options.Flags
+	options.ExtraMarkerSize = extraMarkerSize
+	if o.depth > 0 {
+		options.Virtual = true
+	} else {
+		options.Favor = o.options.Favor
+	}
+	if pathnames[0] == pathnames[1] && pathnames[1] == pathnames[2] {
+		options.AncestorLabel, options.OurLabel, options.TheirLabel = o.ancestor, o.branch1, o.branch2
+	} else {
+		options.AncestorLabel = o.ancestor + ":" + pathnames[0]
+		options.OurLabel = o.branch1 + ":" + pathnames[1]
+		options.TheirLabel = o.branch2 + ":" + pathnames[2]
+	}
+	content, conflicts, err := o.files.Merge(name, contents[0], contents[1], contents[2], options)
+	if err != nil {
+		return nil, 0, err
+	}
+	digest, err := writeBlob(o.gitDir, content)
+	if err != nil {
+		return nil, 0, err
+	}
+	return digest, conflicts, nil
+}
+
+// mergeSubmodule merges commits of the submodule, nil base means there is no common ancestor.
+// it's merged only if one side fast-forwards to the other side in the repository of the submodule,
+// which is .git/modules/<path> of the repository.
+// ours is taken if it conflicts, or the merge base is taken while merging merge bases.
+func (o *ort) mergeSubmodule(name string, base, a, b []byte) ([]byte, bool) {
+	fallback := a
+	if o.depth > 0 {
+		fallback = base
+	}
+	if base == nil || a == nil || b == nil {
+		return fallback, false
+	}
+	gitDir := path.Join(o.gitDir, "modules", name)
+	if !object.HasObject(gitDir, base) || !object.HasObject(gitDir, a) || !object.HasObject(gitDir, b) {
+		o.message(name, "Failed to merge submodule %s (commits not present)", name)
+		return fallback, false
+	}
+	isAncestor := func(ancestor, descendant []byte) bool {
+		bases, err := revwalk.MergeBases(gitDir, ancestor, descendant)
+		return err == nil && len(bases) == 1 && bytes.Equal(bases[0], ancestor)
+	}
+	if !isAncestor(base, a) || !isAncestor(base, b) {
+		o.message(name, "Failed to merge submodule %s (commits don't follow merge-base)", name)
+		return fallback, false
+	}
+	if isAncestor(a, b) {
+		o.message(name, "Note: Fast-forwarding submodule %s to %x", name, b)
+		return b, true
+	}
+	if isAncestor(b, a) {
+		o.message(name, "Note: Fast-forwarding submodule %s to %x", name, a)
+		return a, true
+	}
+	o.message(name, "Failed to merge submodule %s", name)
+	return fallback, false
+}
@@ -0,0 +1,402 @@
+package merge
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/revwalk"
+)
+
+// resolver is a type holding state while merging trees by the resolve strategy.
+// paths are merged by the trivial rules of $ git read-tree -m --aggressive, and the rest are merged
+// like $ git merge-one-file
+type resolver struct {
+	*merger
+	ancestor string
+	ours     string
+	theirs   string
+	dirs     map[string]bool // directories in any of the trees
+	simple   bool            // whether all paths are merged by the trivial rules
+	tree     *mergedTree
+	messages []string
+}
+
+// mergeResolve merges the commits by the resolve strategy, bases are merge bases of them.
+func (m *merger) mergeResolve(bases [][]byte, ours, theirs []byte) (*Result, error) {
+	baseTrees := make([][]byte, len(bases))
+	for i, base := range bases {
+		var err error
+		if baseTrees[i], err = m.treeOf(base); err != nil {
+			return nil, err
+		}
+	}
+	ourTree, err := m.treeOf(ours)
+	if err != nil {
+		return nil, err
+	}
+	theirTree, err := m.treeOf(theirs)
+	if err != nil {
+		return nil, err
+	}
+	r, err := m.resolveTrees(baseTrees, ourTree, theirTree, m.abbrev(bases[0]), m.ourLabel(), m.theirLabel(0, theirs))
+	if err != nil {
+		return nil, err
+	}
+	messages := []string{"Trying simple merge."}
+	if !r.simple {
+		messages = append(messages, "Simple merge failed, trying Automatic merge.")
+	}
+	return m.result(r.tree, r.clean(), append(messages, r.messages...))
+}
+
+// mergeOctopus merges theirs into ours one by one by the octopus strategy.
+// a head is fast-forwarded if possible, otherwise it's merged like resolve.
+// only the last head may conflict, ErrOctopusFailed is returned if another head conflicts.
+func (m *merger) mergeOctopus(ours []byte, theirs [][]byte) (*Result, error) {
+	if len(theirs) < 2 {
+		return nil, ErrOctopusHeads
+	}
+	tree, err := m.treeOf(ours)
+	if err != nil {
+		return nil, err
+	}
+	commits := [][]byte{ours}
+	nonFastForward := false
+	var r *resolver
+	messages := []string{}
+	for i, head := range theirs {
+		if r != nil && !r.clean() {
+			return nil, ErrOctopusFailed
+		}
+		label := m.theirLabel(i, head)
+		bases, err := revwalk.MergeBases(m.gitDir, head, commits...)
+		if err != nil {
+			return nil, err
+		}
+		if len(bases) == 0 {
+			return nil, ErrNoMergeBase
+		}
+		upToDate := false
+		for _, base := range bases {
+			upToDate = upToDate || bytes.Equal(base, head)
+		}
+		if upToDate {
+			messages = append(messages, fmt.Sprintf("Already up to date with %s", label))
+			continue
+		}
+		headTree, err := m.treeOf(head)
+		if err != nil {
+			return nil, err
+		}
+		if !nonFastForward && len(commits) == 1 && len(bases) == 1 && bytes.Equal(bases[0], commits[0]) {
+			messages = append(messages, fmt.Sprintf("Fast-forwarding to: %s", label))
+			commits[0], tree, r = head, headTree, nil
+			continue
+		}
+		nonFastForward = true
+
+		messages = append(messages, fmt.Sprintf("Trying simple merge with %s", label))
+		baseTrees := make([][]byte, len(bases))
+		for i, base := range bases {
+			if baseTrees[i], err = m.treeOf(base); err != nil {
+				return nil, err
+			}
+		}
+		if r, err = m.resolveTrees(baseTrees, tree, headTree, m.abbrev(bases[0]), m.ourLabel(), label); err != nil {
+			return nil, err
+		}
+		if !r.simple {
+			messages = append(messages, "Simple merge did not work, trying automatic merge.")
+		}
+		messages = append(messages, r.messages...)
+		if tree, err = r.tree.write(m.gitDir); err != nil {
+			return nil, err
+		}
+		commits = append(commits, head)
+	}
+	if r == nil {
+		t, err := listTree(m.gitDir, tree)
+		if err != nil {
+			return nil, err
+		}
+		return m.result(t, true, messages)
+	}
+	return m.result(r.tree, r.clean(), messages)
+}
+
+// resolveTrees merges the trees by the resolve strategy, bases may have multiple trees.
+// the tree of the result is what the working tree would be after $ git merge -s resolve
+func (m *merger) resolveTrees(bases [][]byte, ours, theirs []byte, ancestor, ourLabel, theirLabel string) (*resolver, error) {
+	r := &resolver{
+		merger:   m,
+		ancestor: ancestor,
+		ours:     ourLabel,
+		theirs:   theirLabel,
+		dirs:     map[string]bool{},
+		tree:     newMergedTree(),
+		messages: []string{},
+	}
+	trees := append([][]byte{ours, theirs}, bases...)
+	files := make([]map[string]version, len(trees))
+	dirs := make([]map[string]bool, len(trees))
+	all := map[string]version{}
+	for i, tree := range trees {
+		var err error
+		if files[i], err = readFiles(m.gitDir, ".", tree); err != nil {
+			return nil, err
+		}
+		dirs[i] = map[string]bool{}
+		for name, v := range files[i] {
+			all[name] = v
+			for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+				dirs[i][dir] = true
+				r.dirs[dir] = true
+			}
+		}
+	}
+	// a path which is a directory in a tree is a directory/file conflict entry like $ git read-tree
+	versionOf := func(i int, name string) version {
+		if dirs[i][name] {
+			return version{mode: object.ModeTree}
+		}
+		return files[i][name]
+	}
+
+	// the working tree is ours at first
+	for name, v := range files[0] {
+		r.tree.files[name] = v
+	}
+	names := sortedPaths(all)
+	for _, name := range names {
+		baseVersions := make([]version, len(bases))
+		for i := range bases {
+			baseVersions[i] = versionOf(i+2, name)
+		}
+		result, stages, merged := threeWay(baseVersions, versionOf(0, name), versionOf(1, name))
+		if !merged {
+			r.tree.conflicts[name] = stages
+			continue
+		}
+		if result.isNull() {
+			delete(r.tree.files, name)
+		} else {
+			r.checkout(name, result)
+		}
+	}
+	r.simple = len(r.tree.conflicts) == 0
+	// conflicts are resolved in order of paths like $ git merge-index
+	for _, name := range names {
+		stages, ok := r.tree.conflicts[name]
+		if !ok {
+			continue
+		}
+		if err := r.mergeOneFile(name, stages); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *resolver) clean() bool {
+	return len(r.tree.conflicts) == 0
+}
+
+func (r *resolver) result() (*Result, error) {
+	return r.merger.result(r.tree, r.clean(), r.messages)
+}
+
+// threeWay merges versions of the path by the trivial rules like $ git read-tree -m --aggressive
+// a version whose mode is a tree means the path is a directory in the tree.
+// if it isn't merged, it returns stages which are the first existing base, ours and theirs.
+// a path is merged if
+//  - ours and theirs are the same
+//  - ours matches a base and theirs doesn't, then theirs is taken
+//  - theirs matches a base and ours doesn't, then ours is taken
+//  - both sides are deleted, or one side is deleted and the other side matches a base
+func threeWay(bases []version, ours, theirs version) (version, [3]version, bool) {
+	dfOurs, dfTheirs := ours.mode.IsTree(), theirs.mode.IsTree()
+	if dfOurs {
+		ours = version{}
+	}
+	if dfTheirs {
+		theirs = version{}
+	}
+	ourMatch, theirMatch := false, false
+	anyBaseMissing, noBaseExists := false, true
+	for _, base := range bases {
+		if base.isNull() || base.mode.IsTree() {
+			anyBaseMissing = true
+		} else {
+			noBaseExists = false
+		}
+	}
+	if !ours.same(theirs) {
+		for _, base := range bases {
+			ourMatch = ourMatch || base.same(ours)
+			theirMatch = theirMatch || base.same(theirs)
+		}
+	}
+
+	switch {
+	case !theirs.isNull() && !dfOurs && ourMatch && !theirMatch:
+		return theirs, [3]version{}, true
+	case !ours.isNull() && !dfTheirs && ours.same(theirs):
+		return ours, [3]version{}, true
+	case !ours.isNull() && !dfTheirs && theirMatch && !ourMatch:
+		return ours, [3]version{}, true
+	case ours.isNull() && theirs.isNull() && anyBaseMissing:
+		return version{}, [3]version{}, true
+	}
+	// aggressive rules
+	if (ours.isNull() && theirs.isNull()) || (ours.isNull() && theirMatch) || (theirs.isNull() && ourMatch) {
+		return version{}, [3]version{}, true
+	}
+	if noBaseExists && !ours.isNull() && ours.same(theirs) {
+		return ours, [3]version{}, true
+	}
+
+	stages := [3]version{{}, ours, theirs}
+	if !ourMatch || !theirMatch {
+		for _, base := range bases {
+			if !base.isNull() && !base.mode.IsTree() {
+				stages[0] = base
+				break
+			}
+		}
+	}
+	return version{}, stages, false
+}
+
+// mergeOneFile merges the path which isn't trivially merged like $ git merge-one-file
+// it fails for changes which can't be merged automatically, then the path is left at stage 1, 2 and 3.
+func (r *resolver) mergeOneFile(name string, stages [3]version) error {
+	base, ours, theirs := stages[0], stages[1], stages[2]
+	sameDigest := func(a, b version) bool {
+		return bytes.Equal(a.digest, b.digest)
+	}
+	switch {
+	case !base.isNull() && (ours.isNull() && (theirs.isNull() || sameDigest(theirs, base)) ||
+		sameDigest(ours, base) && theirs.isNull()):
+		// deleted on both sides, or deleted on one side and unchanged on the other side
+		if (ours.isNull() && base.mode != theirs.mode) || (theirs.isNull() && base.mode != ours.mode) {
+			r.message("ERROR: File %s deleted on one branch but had its", name)
+			r.message("ERROR: permissions changed on the other.")
+			return nil
+		}
+		if !ours.isNull() {
+			r.message("Removing %s", name)
+			delete(r.tree.files, name)
+		}
+	case base.isNull() && !ours.isNull() && theirs.isNull():
+		r.tree.files[name] = ours
+	case base.isNull() && ours.isNull() && !theirs.isNull():
+		r.message("Adding %s", name)
+		r.checkout(name, theirs)
+	case base.isNull() && !ours.isNull() && sameDigest(ours, theirs):
+		if ours.mode != theirs.mode {
+			r.message("ERROR: File %s added identically in both branches,", name)
+			r.message("ERROR: but permissions conflict %s->%s.", ours.mode, theirs.mode)
+			return nil
+		}
+		r.message("Adding %s", name)
+		r.checkout(name, ours)
+	case !ours.isNull() && !theirs.isNull():
+		return r.mergeContents(name, stages)
+	default:
+		r.message("ERROR: %s: Not handling case %s -> %s -> %s", name, hexDigest(base), hexDigest(ours), hexDigest(theirs))
+		return nil
+	}
+	delete(r.tree.conflicts, name)
+	return nil
+}
+
+// mergeContents merges contents of the file modified on both sides.
+// it's a conflict if the file is added on both sides, or modes of them are different.
+func (r *resolver) mergeContents(name string, stages [3]version) error {
+	base, ours, theirs := stages[0], stages[1], stages[2]
+	for _, mode := range []object.FileMode{ours.mode, theirs.mode} {
+		switch mode {
+		case object.ModeSymlink:
+			r.message("ERROR: %s: Not merging symbolic link changes.", name)
+			return nil
+		case object.ModeGitLink:
+			r.message("ERROR: %s: Not merging conflicting submodule changes.", name)
+			return nil
+		}
+	}
+	if base.isNull() {
+		r.message("Added %s in both, but differently.", name)
+	} else {
+		r.message("Auto-merging %s", name)
+	}
+	var contents [3][]byte
+	for i, v := range stages {
+		var err error
+		if contents[i], err = readBlob(r.gitDir, v.digest); err != nil {
+			return err
+		}
+	}
+	options := r.files.Options()
+	options.Flags = r.options.Flags
+	options.AncestorLabel, options.OurLabel, options.TheirLabel = r.ancestor, r.ours, r.theirs
+	content, conflicts := MergeFile(contents[0], contents[1], contents[2], options)
+	digest, err := writeBlob(r.gitDir, content)
+	if err != nil {
+		return err
+	}
+	// the merged content is placed with the mode of ours even if it conflicts
+	r.checkout(name, version{mode: ours.mode, digest: digest})
+
+	reasons := []string{}
+	if conflicts > 0 || base.isNull() {
+		reasons = append(reasons, "content conflict")
+	}
+	if ours.mode != theirs.mode {
+		reasons = append(reasons, fmt.Sprintf("permissions conflict: %s->%s,%s", modeString(base), ours.mode, theirs.mode))
+	}
+	if len(reasons) > 0 {
+		r.message("ERROR: %s in %s", strings.Join(reasons, ", "), name)
+		return nil
+	}
+	delete(r.tree.conflicts, name)
+	return nil
+}
+
+// checkout places the version at the path of the working tree like $ git checkout-index -f
+// files in the way of the path are removed, the directory at the path is removed too.
+func (r *resolver) checkout(name string, v version) {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		delete(r.tree.files, dir)
+	}
+	if r.dirs[name] {
+		for other := range r.tree.files {
+			if strings.HasPrefix(other, name+"/") {
+				delete(r.tree.files, other)
+			}
+		}
+	}
+	r.tree.files[name] = v
+}
+
+func (r *resolver) message(format string, args ...interface{}) {
+	r.messages = append(r.messages, fmt.Sprintf(format, args...))
+}
+
+// modeString returns the mode like $ git ls-files -s, or "" if the path doesn't exist.
+func modeString(v version) string {
+	if v.isNull() {
+		return ""
+	}
+	return v.mode.String()
+}
+
+func hexDigest(v version) string {
+	if v.isNull() {
+		return ""
+	}
+	return hex.EncodeToString(v.digest)
+}
@@ -0,0 +1,275 @@
+package merge
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// shiftDepth is the depth of sub trees searched when guessing how trees are shifted.
+const shiftDepth = 2
+
+// shift shifts the tree two to match the directory structure of the tree one.
+// if Subtree option is given, two is shifted by it, otherwise the shift is guessed from similarity of sub trees.
+func (m *merger) shift(one, two []byte) ([]byte, error) {
+	if m.options.Subtree != "" {
+		return m.shiftTreeBy(one, two, strings.Trim(m.options.Subtree, "/"))
+	}
+	return m.shiftTree(one, two)
+}
+
+// shiftTree guesses how two is shifted from one.
+// if a sub tree of one resembles two, two is placed at the sub tree of one.
+// if a sub tree of two resembles one, the sub tree is taken instead of two.
+func (m *merger) shiftTree(one, two []byte) ([]byte, error) {
+	score, err := m.scoreTrees(one, two)
+	if err != nil {
+		return nil, err
+	}
+	addScore, addPrefix, err := m.matchTrees(one, two, score, "", "", shiftDepth)
+	if err != nil {
+		return nil, err
+	}
+	delScore, delPrefix, err := m.matchTrees(two, one, score, "", "", shiftDepth)
+	if err != nil {
+		return nil, err
+	}
+	if addScore < delScore {
+		if delPrefix == "" {
+			return two, nil
+		}
+		entry, err := m.treeEntry(two, delPrefix)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, ErrSubtreeNotFound
+		}
+		return entry.Digest, nil
+	}
+	if addPrefix == "" {
+		return two, nil
+	}
+	return m.spliceTree(one, addPrefix, two)
+}
+
+// shiftTreeBy shifts two by the prefix, whether two is shifted down or up is decided by their similarity.
+func (m *merger) shiftTreeBy(one, two []byte, prefix string) ([]byte, error) {
+	candidate := 0
+	sub1, err := m.treeEntry(one, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if sub1 != nil && sub1.Mode.IsTree() {
+		candidate |= 1
+	}
+	sub2, err := m.treeEntry(two, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if sub2 != nil && sub2.Mode.IsTree() {
+		candidate |= 2
+	}
+
+	if candidate == 3 {
+		// both are plausible
+		best, err := m.scoreTrees(one, two)
+		if err != nil {
+			return nil, err
+		}
+		candidate = 0
+		score, err := m.scoreTrees(sub1.Digest, two)
+		if err != nil {
+			return nil, err
+		}
+		if score > best {
+			candidate, best = 1, score
+		}
+		if score, err = m.scoreTrees(sub2.Digest, one); err != nil {
+			return nil, err
+		}
+		if score > best {
+			candidate = 2
+		}
+	}
+
+	switch candidate {
+	case 1:
+		// two is shifted down under the prefix
+		return m.spliceTree(one, prefix, two)
+	case 2:
+		// two is shifted up by removing the prefix
+		return sub2.Digest, nil
+	}
+	return two, nil
+}
+
+// scoreTrees scores similarity of entries of two trees, they aren't compared recursively.
+func (m *merger) scoreTrees(one, two []byte) (int, error) {
+	tree1, err := readTree(m.gitDir, one)
+	if err != nil {
+		return 0, err
+	}
+	tree2, err := readTree(m.gitDir, two)
+	if err != nil {
+		return 0, err
+	}
+	score := 0
+	entries1, entries2 := tree1.Entries, tree2.Entries
+	for len(entries1) > 0 || len(entries2) > 0 {
+		cmp := 0
+		switch {
+		case len(entries1) == 0:
+			cmp = 1
+		case len(entries2) == 0:
+			cmp = -1
+		default:
+			cmp = object.CompareTreeEntries(entries1[0], entries2[0])
+		}
+		switch {
+		case cmp < 0:
+			score += scoreMissing(entries1[0].Mode)
+			entries1 = entries1[1:]
+		case cmp > 0:
+			score += scoreMissing(entries2[0].Mode)
+			entries2 = entries2[1:]
+		default:
+			if bytes.Equal(entries1[0].Digest, entries2[0].Digest) {
+				score += scoreMatches(entries1[0].Mode, entries2[0].Mode)
+			} else {
+				score += scoreDiffers(entries1[0].Mode, entries2[0].Mode)
+			}
+			entries1, entries2 = entries1[1:], entries2[1:]
+		}
+	}
+	return score, nil
+}
+
+func scoreMissing(mode object.FileMode) int {
+	switch {
+	case mode.IsTree():
+		return -1000
+	case mode == object.ModeSymlink:
+		return -500
+	default:
+		return -50
+	}
+}
+
+func scoreDiffers(mode1, mode2 object.FileMode) int {
+	switch {
+	case mode1.IsTree() != mode2.IsTree():
+		return -100
+	case (mode1 == object.ModeSymlink) != (mode2 == object.ModeSymlink):
+		return -50
+	default:
+		return -5
+	}
+}
+
+func scoreMatches(mode1, mode2 object.FileMode) int {
+	switch {
+	case mode1.IsTree() != mode2.IsTree():
+		return -100
+	case (mode1 == object.ModeSymlink) != (mode2 == object.ModeSymlink):
+		return -50
+	case mode1.IsTree():
+		return 1000
+	case mode1 == object.ModeSymlink:
+		return 500
+	default:
+		return 250
+	}
+}
+
+// matchTrees searches the sub tree of one which resembles two best, sub trees are searched down to depth.
+// it returns the best score and the path of the sub tree, or bestScore and bestMatch if no sub trees are better.
+func (m *merger) matchTrees(one, two []byte, bestScore int, bestMatch, prefix string, depth int) (int, string, error) {
+	tree, err := readTree(m.gitDir, one)
+	if err != nil {
+		return 0, "", err
+	}
+	for _, entry := range tree.Entries {
+		if !entry.Mode.IsTree() {
+			continue
+		}
+		score, err := m.scoreTrees(entry.Digest, two)
+		if err != nil {
+			return 0, "", err
+		}
+		if bestScore < score {
+			bestScore, bestMatch = score, prefix+entry.Name
+		}
+		if depth > 0 {
+			if bestScore, bestMatch, err = m.matchTrees(entry.Digest, two, bestScore, bestMatch, prefix+entry.Name+"/", depth-1); err != nil {
+				return 0, "", err
+			}
+		}
+	}
+	return bestScore, bestMatch, nil
+}
+
+// spliceTree replaces the sub tree of the tree at the prefix with another tree, and returns the new tree.
+func (m *merger) spliceTree(tree []byte, prefix string, subtree []byte) ([]byte, error) {
+	t, err := readTree(m.gitDir, tree)
+	if err != nil {
+		return nil, err
+	}
+	name, rest := prefix, ""
+	if i := strings.IndexByte(prefix, '/'); i >= 0 {
+		name, rest = prefix[:i], prefix[i+1:]
+	}
+	entries := make([]*object.TreeEntry, len(t.Entries))
+	found := false
+	for i, entry := range t.Entries {
+		entries[i] = entry
+		if entry.Name != name {
+			continue
+		}
+		if !entry.Mode.IsTree() {
+			return nil, ErrSubtreeNotFound
+		}
+		digest := subtree
+		if rest != "" {
+			if digest, err = m.spliceTree(entry.Digest, rest, subtree); err != nil {
+				return nil, err
+			}
+		}
+		if digest == nil {
+			// the empty tree
+			if digest, err = object.WriteObject(m.gitDir, object.NewTree(nil)); err != nil {
+				return nil, err
+			}
+		}
+		entries[i] = &object.TreeEntry{Mode: entry.Mode, Name: entry.Name, Digest: digest}
+		found = true
+	}
+	if !found {
+		return nil, ErrSubtreeNotFound
+	}
+	return object.WriteObject(m.gitDir, object.NewTree(entries))
+}
+
+// treeEntry returns the entry at the path in the tree, or nil if it doesn't exist.
+func (m *merger) treeEntry(tree []byte, name string) (*object.TreeEntry, error) {
+	components := strings.Split(name, "/")
+	for i, component := range components {
+		t, err := readTree(m.gitDir, tree)
+		if err != nil {
+			return nil, err
+		}
+		entry, ok := t.Entry(component)
+		if !ok {
+			return nil, nil
+		}
+		if i == len(components)-1 {
+			return entry, nil
+		}
+		if !entry.Mode.IsTree() {
+			return nil, nil
+		}
+		tree = entry.Digest
+	}
+	return nil, nil
+}
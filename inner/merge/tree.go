@@ -0,0 +1,239 @@
+package merge
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// version is a type representing a version of a path, zero mode means the path doesn't exist.
+type version struct {
+	mode   object.FileMode
+	digest []byte
+}
+
+func (v version) isNull() bool {
+	return v.mode == 0
+}
+
+// same returns whether both mode and content are the same.
+func (v version) same(other version) bool {
+	return v.mode == other.mode && bytes.Equal(v.digest, other.digest)
+}
+
+// fileType returns the type bits of the mode, it distinguishes files, symbolic links, git links and trees.
+func fileType(mode object.FileMode) object.FileMode {
+	return mode & 0170000
+}
+
+func isRegular(mode object.FileMode) bool {
+	return fileType(mode) == 0100000
+}
+
+// mergedTree is a type representing a merged tree and conflicting paths in it.
+type mergedTree struct {
+	files     map[string]version    // versions of files in the tree
+	subtrees  map[string][]byte     // sub trees taken as they are
+	conflicts map[string][3]version // versions of conflicting paths at stage 1, 2 and 3, null means no entry
+}
+
+func newMergedTree() *mergedTree {
+	return &mergedTree{
+		files:     map[string]version{},
+		subtrees:  map[string][]byte{},
+		conflicts: map[string][3]version{},
+	}
+}
+
+// treeNode is a directory of the tree being written.
+type treeNode struct {
+	entries  []*object.TreeEntry
+	children map[string]*treeNode
+}
+
+// write writes tree objects of the merged tree, and returns the digest of the root tree.
+// empty directories are omitted.
+func (t *mergedTree) write(gitDir string) ([]byte, error) {
+	root := &treeNode{children: map[string]*treeNode{}}
+	dir := func(name string) *treeNode {
+		node := root
+		if name == "." {
+			return node
+		}
+		for _, component := range strings.Split(name, "/") {
+			child, ok := node.children[component]
+			if !ok {
+				child = &treeNode{children: map[string]*treeNode{}}
+				node.children[component] = child
+			}
+			node = child
+		}
+		return node
+	}
+	for name, v := range t.files {
+		node := dir(path.Dir(name))
+		node.entries = append(node.entries, &object.TreeEntry{Mode: v.mode, Name: path.Base(name), Digest: v.digest})
+	}
+	for name, digest := range t.subtrees {
+		node := dir(path.Dir(name))
+		node.entries = append(node.entries, &object.TreeEntry{Mode: object.ModeTree, Name: path.Base(name), Digest: digest})
+	}
+	return writeTreeNode(gitDir, root)
+}
+
+func writeTreeNode(gitDir string, node *treeNode) ([]byte, error) {
+	entries := node.entries
+	for name, child := range node.children {
+		if len(child.entries) == 0 && len(child.children) == 0 {
+			continue
+		}
+		digest, err := writeTreeNode(gitDir, child)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &object.TreeEntry{Mode: object.ModeTree, Name: name, Digest: digest})
+	}
+	return object.WriteObject(gitDir, object.NewTree(entries))
+}
+
+// index returns the index of the merged tree.
+// files of conflicting paths are replaced with their stages.
+func (t *mergedTree) index(gitDir string) (index.Index, error) {
+	entries := []*index.Entry{}
+	for name, v := range t.files {
+		if _, ok := t.conflicts[name]; !ok {
+			entries = append(entries, newEntry(name, v, index.NoConflict))
+		}
+	}
+	for name, digest := range t.subtrees {
+		files, err := readFiles(gitDir, name, digest)
+		if err != nil {
+			return nil, err
+		}
+		for name, v := range files {
+			entries = append(entries, newEntry(name, v, index.NoConflict))
+		}
+	}
+	for name, stages := range t.conflicts {
+		for i, v := range stages {
+			if !v.isNull() {
+				entries = append(entries, newEntry(name, v, index.ConflictFlag(i+1)))
+			}
+		}
+	}
+	return index.NewIndex(entries), nil
+}
+
+// newEntry creates an index entry without stat information.
+func newEntry(name string, v version, stage index.ConflictFlag) *index.Entry {
+	entry := &index.Entry{Digest: v.digest, ConflictFlag: stage, Name: name}
+	switch v.mode {
+	case object.ModeExecutable:
+		entry.ObjectType, entry.Permission = index.RegularFile, 0755
+	case object.ModeSymlink:
+		entry.ObjectType = index.SymbolicLink
+	case object.ModeGitLink:
+		entry.ObjectType = index.GitLink
+	default:
+		entry.ObjectType, entry.Permission = index.RegularFile, 0644
+	}
+	return entry
+}
+
+// listTree returns the merged tree having all files of the tree without conflicts.
+func listTree(gitDir string, tree []byte) (*mergedTree, error) {
+	files, err := readFiles(gitDir, ".", tree)
+	if err != nil {
+		return nil, err
+	}
+	t := newMergedTree()
+	t.files = files
+	return t, nil
+}
+
+// readTree reads the tree object, nil means the empty tree.
+func readTree(gitDir string, digest []byte) (*object.Tree, error) {
+	if digest == nil {
+		return &object.Tree{Entries: []*object.TreeEntry{}}, nil
+	}
+	obj, err := object.ReadObjectFrom(gitDir, digest)
+	if err != nil {
+		return nil, err
+	}
+	tree, ok := obj.(*object.Tree)
+	if !ok {
+		return nil, object.ErrUnexpectedType
+	}
+	return tree, nil
+}
+
+// readFiles returns versions of all files in the tree recursively.
+// prefix is the path of the tree, "." means the root.
+func readFiles(gitDir, prefix string, digest []byte) (map[string]version, error) {
+	files := map[string]version{}
+	var walk func(prefix string, digest []byte) error
+	walk = func(prefix string, digest []byte) error {
+		tree, err := readTree(gitDir, digest)
+		if err != nil {
+			return err
+		}
+		for _, entry := range tree.Entries {
+			name := joinPath(prefix, entry.Name)
+			if entry.Mode.IsTree() {
+				if err := walk(name, entry.Digest); err != nil {
+					return err
+				}
+				continue
+			}
+			files[name] = version{mode: entry.Mode, digest: entry.Digest}
+		}
+		return nil
+	}
+	if err := walk(prefix, digest); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// joinPath joins the directory and the name, "." means the root directory.
+func joinPath(dir, name string) string {
+	if dir == "." || dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// readBlob reads content of the blob, nil means the empty content.
+// Git LFS pointers are merged as they are.
+func readBlob(gitDir string, digest []byte) ([]byte, error) {
+	if digest == nil {
+		return []byte{}, nil
+	}
+	obj, err := object.ReadObjectFrom(gitDir, digest)
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := obj.(*object.Blob)
+	if !ok {
+		return nil, object.ErrUnexpectedType
+	}
+	return blob.Content()
+}
+
+func writeBlob(gitDir string, content []byte) ([]byte, error) {
+	return object.WriteObject(gitDir, object.NewBlobFromBytes(content))
+}
+
+// sortedPaths returns paths of the map in sorted order.
+func sortedPaths(paths map[string]version) []string {
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
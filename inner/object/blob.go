@@ -2,13 +2,16 @@ package object
 
 import (
 	"bytes"
-	"compress/zlib"
 	"crypto/sha1"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/shumon84/binutil"
+	"github.com/shumon84/mogit/inner/attributes"
+	"github.com/shumon84/mogit/inner/lfs"
 	"github.com/shumon84/mogit/inner/util"
 )
 
@@ -20,7 +23,43 @@ type Blob struct {
 	decode []byte
 }
 
+// NewBlobFromPath creates a new blob from the file.
+// if the file is in a working tree of a repository, the content is filtered and line endings are converted
+// according to attributes of the repository like $ git hash-object
 func NewBlobFromPath(path string) (*Blob, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(filepath.Dir(absPath))
+	if err == util.ErrNotGitRepository {
+		return newBlobFromFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	name, err := filepath.Rel(filepath.Dir(gitDir), absPath)
+	if err != nil {
+		return nil, err
+	}
+	converter, err := attributes.NewConverter(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	defer converter.Close()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = converter.ToGit(filepath.ToSlash(name), data)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlobFromBytes(data), nil
+}
+
+// newBlobFromFile creates a new blob whose content is the file as it is
+func newBlobFromFile(path string) (*Blob, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -32,6 +71,14 @@ func NewBlobFromPath(path string) (*Blob, error) {
 	return NewBlob(file, stat.Size())
 }
 
+// NewBlobFromBytes creates a new blob whose content is data
+func NewBlobFromBytes(data []byte) *Blob {
+	return &Blob{
+		rsc:  util.NopCloser(bytes.NewReader(data)),
+		size: int64(len(data)),
+	}
+}
+
 func NewBlob(rsc util.ReadSeekCloser, size int64) (*Blob, error) {
 	if rsc == nil {
 		return nil, ErrNilReadCloser
@@ -94,6 +141,15 @@ func (b *Blob) Decode() ([]byte, error) {
 	return data, nil
 }
 
+// Content returns content of this blob without object header
+func (b *Blob) Content() ([]byte, error) {
+	data, err := b.Decode()
+	if err != nil {
+		return nil, err
+	}
+	return data[bytes.IndexByte(data, 0)+1:], nil
+}
+
 func (b *Blob) Encode() ([]byte, error) {
 	if b.encode != nil {
 		data := make([]byte, len(b.encode))
@@ -104,21 +160,41 @@ func (b *Blob) Encode() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	buf := &bytes.Buffer{}
-	zr := zlib.NewWriter(buf)
-	defer zr.Close()
-
-	if _, err := zr.Write(rawData); err != nil {
+	data, err := encode(rawData)
+	if err != nil {
 		return nil, err
 	}
-	//if err := zr.Flush(); err != nil {
-	//	return nil, err
-	//}
-
-	b.encode = buf.Bytes()
-	return buf.Bytes(), nil
+	b.encode = make([]byte, len(data))
+	copy(b.encode, data)
+	return data, nil
 }
 
 func (b *Blob) Close() error {
 	return b.rsc.Close()
 }
+
+// ReadBlobContent reads content of the blob in the repository.
+// if the blob is a Git LFS pointer and its content is in the local LFS store,
+// the content is returned instead of the pointer.
+func ReadBlobContent(gitDir string, digest []byte) ([]byte, error) {
+	obj, err := ReadObjectFrom(gitDir, digest)
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := obj.(*Blob)
+	if !ok {
+		return nil, ErrUnexpectedType
+	}
+	content, err := blob.Content()
+	if err != nil {
+		return nil, err
+	}
+	if !lfs.IsPointer(content) {
+		return content, nil
+	}
+	store, err := lfs.NewStore(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	return store.Smudge(content)
+}
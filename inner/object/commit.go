@@ -1 +1,178 @@
 package object
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+)
+
+// ExtraHeader is a type representing a commit or tag header which this package doesn't interpret
+// (e.g. encoding, gpgsig, mergetag).
+type ExtraHeader struct {
+	Key   string
+	Value string // multi-line value is joined with "\n"
+}
+
+// Commit is a type representing git commit object.
+//
+//  tree <40 hex digits>
+//  parent <40 hex digits>     (zero or more)
+//  author <signature>
+//  committer <signature>
+//  <extra headers>
+//
+//  <message>
+type Commit struct {
+	Tree         []byte         // SHA1 digest of the root tree
+	Parents      [][]byte       // SHA1 digests of the parent commits
+	Author       *Signature     // who wrote the change
+	Committer    *Signature     // who made the commit
+	ExtraHeaders []*ExtraHeader // headers which are not interpreted
+	Message      string         // commit message
+}
+
+// ParseCommit parses content of commit object
+func ParseCommit(content []byte) (*Commit, error) {
+	headers, message, err := parseHeaders(content)
+	if err != nil {
+		return nil, err
+	}
+	commit := &Commit{Message: message}
+	for _, header := range headers {
+		switch header.Key {
+		case "tree":
+			if commit.Tree != nil {
+				return nil, ErrInvalidObject
+			}
+			if commit.Tree, err = parseHexDigest(header.Value); err != nil {
+				return nil, err
+			}
+		case "parent":
+			parent, err := parseHexDigest(header.Value)
+			if err != nil {
+				return nil, err
+			}
+			commit.Parents = append(commit.Parents, parent)
+		case "author":
+			if commit.Author, err = ParseSignature(header.Value); err != nil {
+				return nil, err
+			}
+		case "committer":
+			if commit.Committer, err = ParseSignature(header.Value); err != nil {
+				return nil, err
+			}
+		default:
+			commit.ExtraHeaders = append(commit.ExtraHeaders, header)
+		}
+	}
+	if commit.Tree == nil || commit.Author == nil || commit.Committer == nil {
+		return nil, ErrInvalidObject
+	}
+	return commit, nil
+}
+
+// Content returns content of this commit object without object header
+func (c *Commit) Content() []byte {
+	buf := &bytes.Buffer{}
+	writeHeader(buf, "tree", hex.EncodeToString(c.Tree))
+	for _, parent := range c.Parents {
+		writeHeader(buf, "parent", hex.EncodeToString(parent))
+	}
+	writeHeader(buf, "author", c.Author.String())
+	writeHeader(buf, "committer", c.Committer.String())
+	for _, header := range c.ExtraHeaders {
+		writeHeader(buf, header.Key, header.Value)
+	}
+	buf.WriteByte('\n')
+	buf.WriteString(c.Message)
+	return buf.Bytes()
+}
+
+// Summary returns the first line of commit message
+func (c *Commit) Summary() string {
+	return firstLine(c.Message)
+}
+
+func (c *Commit) SHA1() ([]byte, error) {
+	return digestOf(decode(CommitObject, c.Content())), nil
+}
+
+func (c *Commit) Type() ObjectType {
+	return CommitObject
+}
+
+func (c *Commit) Decode() ([]byte, error) {
+	return decode(CommitObject, c.Content()), nil
+}
+
+func (c *Commit) Encode() ([]byte, error) {
+	return encode(decode(CommitObject, c.Content()))
+}
+
+func (c *Commit) Close() error {
+	return nil
+}
+
+// parseHeaders parses "key value" lines until an empty line, and returns headers and the rest.
+// a line beginning with a space is continuation of the previous header value.
+func parseHeaders(content []byte) ([]*ExtraHeader, string, error) {
+	headers := []*ExtraHeader{}
+	rest := string(content)
+	for {
+		if rest == "" {
+			return headers, "", nil
+		}
+		end := strings.IndexByte(rest, '\n')
+		if end < 0 {
+			end = len(rest)
+		}
+		line := rest[:end]
+		if end < len(rest) {
+			rest = rest[end+1:]
+		} else {
+			rest = ""
+		}
+		if line == "" {
+			return headers, rest, nil
+		}
+		if line[0] == ' ' {
+			if len(headers) == 0 {
+				return nil, "", ErrInvalidObject
+			}
+			last := headers[len(headers)-1]
+			last.Value += "\n" + line[1:]
+			continue
+		}
+		space := strings.IndexByte(line, ' ')
+		if space <= 0 {
+			return nil, "", ErrInvalidObject
+		}
+		headers = append(headers, &ExtraHeader{
+			Key:   line[:space],
+			Value: line[space+1:],
+		})
+	}
+}
+
+// writeHeader writes "key value\n", lines of multi-line value are continued by a space.
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	buf.WriteByte(' ')
+	buf.WriteString(strings.Replace(value, "\n", "\n ", -1))
+	buf.WriteByte('\n')
+}
+
+func parseHexDigest(s string) ([]byte, error) {
+	digest, err := hex.DecodeString(s)
+	if err != nil || len(digest) != DigestSize {
+		return nil, ErrInvalidHashLength
+	}
+	return digest, nil
+}
+
+func firstLine(s string) string {
+	if end := strings.IndexByte(s, '\n'); end >= 0 {
+		return s[:end]
+	}
+	return s
+}
@@ -6,4 +6,9 @@ var (
 	ErrNilReadCloser     = errors.New("io.ReadCloser is nil")
 	ErrNegativeSize      = errors.New("size is negative number")
 	ErrInvalidHashLength = errors.New("invalid hash length")
+	ErrInvalidSignature  = errors.New("invalid signature format")
+	ErrInvalidObjectType = errors.New("invalid object type")
+	ErrInvalidObject     = errors.New("invalid object format")
+	ErrObjectNotFound    = errors.New("object is not found")
+	ErrUnexpectedType    = errors.New("object type is not expected one")
 )
@@ -1,12 +1,24 @@
+// object is a package to handle git objects
+//
+// A git object is stored in .git/objects/<first 2 hex digits>/<remaining 38 hex digits>
+// and it is zlib compressed the following byte sequence.
+//
+//  <type("blob", "tree", "commit" or "tag")> <content size in decimal>\0<content>
+//
+// SHA1 digest of an object is calculated from the byte sequence before compression.
 package object
 
 import (
+	"bytes"
 	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-
-	"github.com/shumon84/binutil"
+	"strconv"
 
 	"github.com/shumon84/mogit/inner/util"
 )
@@ -27,7 +39,7 @@ func (o ObjectType) String() string {
 	case TreeObject:
 		return "tree"
 	case CommitObject:
-		return "object"
+		return "commit"
 	case TagObject:
 		return "tag"
 	default:
@@ -35,6 +47,16 @@ func (o ObjectType) String() string {
 	}
 }
 
+// ParseObjectType parses name of object type
+func ParseObjectType(s string) (ObjectType, error) {
+	for _, objectType := range []ObjectType{BlobObject, TreeObject, CommitObject, TagObject} {
+		if objectType.String() == s {
+			return objectType, nil
+		}
+	}
+	return 0, ErrInvalidObjectType
+}
+
 type Object interface {
 	io.Closer
 	SHA1() ([]byte, error)
@@ -43,47 +65,223 @@ type Object interface {
 	Encode() ([]byte, error)
 }
 
+// DigestSize is byte length of SHA1 digest
+const DigestSize = 20
+
+// GetObjectPath returns path to the loose object file in current repository.
+// digest of parameters must be 40 hex digits.
 func GetObjectPath(digest string) (string, error) {
-	if len(digest) != 20 {
+	if len(digest) != DigestSize*2 {
 		return "", ErrInvalidHashLength
 	}
 	wd, err := os.Getwd()
 	if err != nil {
 		return "", err
 	}
-	path, err := util.FindGitRoot(wd)
+	gitDir, err := util.FindGitDir(wd)
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(path, digest[:2], digest[2:]), nil
+	return objectPath(gitDir, digest), nil
 }
 
+func objectPath(gitDir, digest string) string {
+	return filepath.Join(gitDir, "objects", digest[:2], digest[2:])
+}
+
+// ReadObject reads the loose object from current repository.
+// digest of parameters must be 40 hex digits.
 func ReadObject(digest string) (Object, error) {
-	path, err := GetObjectPath(digest)
+	digestByte, err := hex.DecodeString(digest)
+	if err != nil || len(digestByte) != DigestSize {
+		return nil, ErrInvalidHashLength
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(wd)
+	if err != nil {
+		return nil, err
+	}
+	return ReadObjectFrom(gitDir, digestByte)
+}
+
+// ReadObjectFrom reads the loose object from the repository.
+// gitDir of parameters must be path to .git directory.
+func ReadObjectFrom(gitDir string, digest []byte) (Object, error) {
+	if len(digest) != DigestSize {
+		return nil, ErrInvalidHashLength
+	}
+	file, err := os.Open(objectPath(gitDir, hex.EncodeToString(digest)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	zr, err := util.NewZlibReadSeeker(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	defer zr.Close()
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	return ParseObject(data)
+}
+
+// ParseObject parses decoded object, it is "<type> <size>\0<content>".
+func ParseObject(data []byte) (Object, error) {
+	objectType, content, err := splitHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	switch objectType {
+	case BlobObject:
+		return NewBlobFromBytes(content), nil
+	case TreeObject:
+		return ParseTree(content)
+	case CommitObject:
+		return ParseCommit(content)
+	case TagObject:
+		return ParseTag(content)
+	}
+	return nil, ErrInvalidObjectType
+}
+
+// splitHeader splits decoded object to object type and content
+func splitHeader(data []byte) (ObjectType, []byte, error) {
+	nul := bytes.IndexByte(data, 0)
+	if nul < 0 {
+		return 0, nil, ErrInvalidObject
+	}
+	space := bytes.IndexByte(data[:nul], ' ')
+	if space < 0 {
+		return 0, nil, ErrInvalidObject
+	}
+	objectType, err := ParseObjectType(string(data[:space]))
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := strconv.Atoi(string(data[space+1 : nul]))
+	if err != nil || size != len(data)-nul-1 {
+		return 0, nil, ErrInvalidObject
+	}
+	return objectType, data[nul+1:], nil
+}
+
+// HasObject returns whether the loose object exists in the repository.
+func HasObject(gitDir string, digest []byte) bool {
+	if len(digest) != DigestSize {
+		return false
+	}
+	_, err := os.Stat(objectPath(gitDir, hex.EncodeToString(digest)))
+	return err == nil
+}
+
+// WriteObject writes the object to the repository as a loose object and returns its digest.
+// nothing is written if the object already exists.
+// gitDir of parameters must be path to .git directory.
+func WriteObject(gitDir string, obj Object) ([]byte, error) {
+	digest, err := obj.SHA1()
 	if err != nil {
 		return nil, err
 	}
-	file, err := os.Open(path)
+	if HasObject(gitDir, digest) {
+		return digest, nil
+	}
+	data, err := obj.Encode()
 	if err != nil {
 		return nil, err
 	}
-	zlibr, err := zlib.NewReader(file)
+	path := objectPath(gitDir, hex.EncodeToString(digest))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// the object is written to a temporary file and renamed, so readers never see a partial object
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "tmp_obj_")
 	if err != nil {
 		return nil, err
 	}
-	// TODO: read git object
-	r := binutil.NewReader(zlibr)
-	objectTypeString, err := r.String()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return digest, nil
+}
+
+// FindObjects returns digests of loose objects whose hex digits have prefix.
+// it is used to expand abbreviated object names.
+func FindObjects(gitDir, prefix string) ([][]byte, error) {
+	if len(prefix) < 2 || len(prefix) > DigestSize*2 {
+		return nil, ErrInvalidHashLength
+	}
+	if _, err := hex.DecodeString(prefix[:len(prefix)&^1]); err != nil {
+		return nil, ErrInvalidHashLength
+	}
+	dir := filepath.Join(gitDir, "objects", prefix[:2])
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return [][]byte{}, nil
+		}
+		return nil, err
+	}
+	digests := [][]byte{}
+	for _, file := range files {
+		name := prefix[:2] + file.Name()
+		if len(name) != DigestSize*2 || name[:len(prefix)] != prefix {
+			continue
+		}
+		digest, err := hex.DecodeString(name)
+		if err != nil {
+			continue
+		}
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// decode returns "<type> <size>\0<content>"
+func decode(objectType ObjectType, content []byte) []byte {
+	header := fmt.Sprintf("%s %d", objectType, len(content))
+	data := make([]byte, 0, len(header)+1+len(content))
+	data = append(data, header...)
+	data = append(data, 0)
+	return append(data, content...)
+}
+
+// digestOf returns SHA1 digest of decoded object
+func digestOf(data []byte) []byte {
+	digest := sha1.Sum(data)
+	return digest[:]
+}
+
+// encode compresses decoded object with zlib
+func encode(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	if _, err := zw.Write(data); err != nil {
 		return nil, err
 	}
-	var object Object
-	switch objectTypeString {
-	case BlobObject.String():
-		object, err = NewBlobFromPath(path)
-	case TreeObject.String():
-	case CommitObject.String():
-	case TagObject.String():
+	if err := zw.Close(); err != nil {
+		return nil, err
 	}
-	return nil, nil
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,69 @@
+package object
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature is a type representing identity and timestamp of author or committer.
+// it is written as "Name <email> 1136214245 +0900" in git objects and reflogs.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// String is implementation of fmt.Stringer interface
+func (s *Signature) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When.Unix(), s.When.Format("-0700"))
+}
+
+// ParseSignature parses "Name <email> 1136214245 +0900" format signature.
+func ParseSignature(s string) (*Signature, error) {
+	open := strings.IndexByte(s, '<')
+	closing := strings.LastIndexByte(s, '>')
+	if open < 0 || closing < open {
+		return nil, ErrInvalidSignature
+	}
+	signature := &Signature{
+		Name:  strings.TrimSpace(s[:open]),
+		Email: s[open+1 : closing],
+	}
+
+	fields := strings.Fields(s[closing+1:])
+	if len(fields) != 2 {
+		return nil, ErrInvalidSignature
+	}
+	sec, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	offset, err := parseTimezone(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	signature.When = time.Unix(sec, 0).In(time.FixedZone("", offset))
+	return signature, nil
+}
+
+// parseTimezone parses "+0900" format timezone to offset seconds.
+func parseTimezone(tz string) (int, error) {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return 0, ErrInvalidSignature
+	}
+	hour, err := strconv.Atoi(tz[1:3])
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+	minute, err := strconv.Atoi(tz[3:5])
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+	offset := hour*60*60 + minute*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return offset, nil
+}
@@ -0,0 +1,95 @@
+package object
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+// Tag is a type representing git annotated tag object.
+//
+//  object <40 hex digits>
+//  type <object type>
+//  tag <tag name>
+//  tagger <signature>
+//
+//  <message>
+type Tag struct {
+	Object       []byte         // SHA1 digest of the tagged object
+	ObjectType   ObjectType     // type of the tagged object
+	Name         string         // name of the tag
+	Tagger       *Signature     // who made the tag, it may be nil in very old tags
+	ExtraHeaders []*ExtraHeader // headers which are not interpreted
+	Message      string         // tag message, it may include a signature
+}
+
+// ParseTag parses content of tag object
+func ParseTag(content []byte) (*Tag, error) {
+	headers, message, err := parseHeaders(content)
+	if err != nil {
+		return nil, err
+	}
+	tag := &Tag{Message: message}
+	hasType := false
+	for _, header := range headers {
+		switch header.Key {
+		case "object":
+			if tag.Object, err = parseHexDigest(header.Value); err != nil {
+				return nil, err
+			}
+		case "type":
+			if tag.ObjectType, err = ParseObjectType(header.Value); err != nil {
+				return nil, err
+			}
+			hasType = true
+		case "tag":
+			tag.Name = header.Value
+		case "tagger":
+			if tag.Tagger, err = ParseSignature(header.Value); err != nil {
+				return nil, err
+			}
+		default:
+			tag.ExtraHeaders = append(tag.ExtraHeaders, header)
+		}
+	}
+	if tag.Object == nil || !hasType {
+		return nil, ErrInvalidObject
+	}
+	return tag, nil
+}
+
+// Content returns content of this tag object without object header
+func (t *Tag) Content() []byte {
+	buf := &bytes.Buffer{}
+	writeHeader(buf, "object", hex.EncodeToString(t.Object))
+	writeHeader(buf, "type", t.ObjectType.String())
+	writeHeader(buf, "tag", t.Name)
+	if t.Tagger != nil {
+		writeHeader(buf, "tagger", t.Tagger.String())
+	}
+	for _, header := range t.ExtraHeaders {
+		writeHeader(buf, header.Key, header.Value)
+	}
+	buf.WriteByte('\n')
+	buf.WriteString(t.Message)
+	return buf.Bytes()
+}
+
+func (t *Tag) SHA1() ([]byte, error) {
+	return digestOf(decode(TagObject, t.Content())), nil
+}
+
+func (t *Tag) Type() ObjectType {
+	return TagObject
+}
+
+func (t *Tag) Decode() ([]byte, error) {
+	return decode(TagObject, t.Content()), nil
+}
+
+func (t *Tag) Encode() ([]byte, error) {
+	return encode(decode(TagObject, t.Content()))
+}
+
+func (t *Tag) Close() error {
+	return nil
+}
@@ -1 +1,158 @@
 package object
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// FileMode is a type representing mode of tree entry.
+type FileMode uint32
+
+// constants of tree entry mode
+const (
+	ModeTree       FileMode = 0040000
+	ModeRegular    FileMode = 0100644
+	ModeExecutable FileMode = 0100755
+	ModeSymlink    FileMode = 0120000
+	ModeGitLink    FileMode = 0160000
+)
+
+// String is implementation of fmt.Stringer interface.
+// it returns 6 octal digits like $ git ls-tree
+func (m FileMode) String() string {
+	return fmt.Sprintf("%06o", uint32(m))
+}
+
+// IsTree returns whether the entry is a sub tree
+func (m FileMode) IsTree() bool {
+	return m&0170000 == ModeTree
+}
+
+// ObjectType returns type of the object what the entry points to
+func (m FileMode) ObjectType() ObjectType {
+	switch {
+	case m.IsTree():
+		return TreeObject
+	case m == ModeGitLink:
+		return CommitObject
+	default:
+		return BlobObject
+	}
+}
+
+// TreeEntry is a type representing one entry of tree object.
+type TreeEntry struct {
+	Mode   FileMode // mode of the entry
+	Name   string   // name of the entry, it doesn't include '/'
+	Digest []byte   // SHA1 digest of the blob, tree or commit(git link)
+}
+
+// String is implementation of fmt.Stringer interface
+// it returns the same format as $ git ls-tree
+func (e *TreeEntry) String() string {
+	return fmt.Sprintf("%s %s %x\t%s", e.Mode, e.Mode.ObjectType(), e.Digest, e.Name)
+}
+
+// Tree is a type representing git tree object.
+//
+//  <mode in octal> <name>\0<20 bytes SHA1 digest>   (repeated)
+type Tree struct {
+	Entries []*TreeEntry // entries sorted in git order
+}
+
+// NewTree creates a tree object from entries, they are sorted in git order.
+func NewTree(entries []*TreeEntry) *Tree {
+	sorted := make([]*TreeEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return CompareTreeEntries(sorted[i], sorted[j]) < 0
+	})
+	return &Tree{Entries: sorted}
+}
+
+// CompareTreeEntries compares entries in git order.
+// name of sub tree is compared as if it has trailing '/'.
+func CompareTreeEntries(a, b *TreeEntry) int {
+	return bytes.Compare(treeSortKey(a), treeSortKey(b))
+}
+
+func treeSortKey(e *TreeEntry) []byte {
+	if e.Mode.IsTree() {
+		return []byte(e.Name + "/")
+	}
+	return []byte(e.Name)
+}
+
+// ParseTree parses content of tree object
+func ParseTree(content []byte) (*Tree, error) {
+	tree := &Tree{Entries: []*TreeEntry{}}
+	for len(content) > 0 {
+		space := bytes.IndexByte(content, ' ')
+		if space <= 0 {
+			return nil, ErrInvalidObject
+		}
+		mode, err := strconv.ParseUint(string(content[:space]), 8, 32)
+		if err != nil {
+			return nil, ErrInvalidObject
+		}
+		content = content[space+1:]
+		nul := bytes.IndexByte(content, 0)
+		if nul <= 0 || len(content) < nul+1+DigestSize {
+			return nil, ErrInvalidObject
+		}
+		digest := make([]byte, DigestSize)
+		copy(digest, content[nul+1:nul+1+DigestSize])
+		tree.Entries = append(tree.Entries, &TreeEntry{
+			Mode:   FileMode(mode),
+			Name:   string(content[:nul]),
+			Digest: digest,
+		})
+		content = content[nul+1+DigestSize:]
+	}
+	return tree, nil
+}
+
+// Entry returns the entry named name
+func (t *Tree) Entry(name string) (*TreeEntry, bool) {
+	for _, entry := range t.Entries {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// Content returns content of this tree object without object header
+func (t *Tree) Content() []byte {
+	buf := &bytes.Buffer{}
+	for _, entry := range t.Entries {
+		buf.WriteString(strconv.FormatUint(uint64(entry.Mode), 8))
+		buf.WriteByte(' ')
+		buf.WriteString(entry.Name)
+		buf.WriteByte(0)
+		buf.Write(entry.Digest)
+	}
+	return buf.Bytes()
+}
+
+func (t *Tree) SHA1() ([]byte, error) {
+	return digestOf(decode(TreeObject, t.Content())), nil
+}
+
+func (t *Tree) Type() ObjectType {
+	return TreeObject
+}
+
+func (t *Tree) Decode() ([]byte, error) {
+	return decode(TreeObject, t.Content()), nil
+}
+
+func (t *Tree) Encode() ([]byte, error) {
+	return encode(decode(TreeObject, t.Content()))
+}
+
+func (t *Tree) Close() error {
+	return nil
+}
@@ -0,0 +1,147 @@
+// pathspec is a package to match paths with pathspecs like the arguments after "--" of git commands
+//
+// The following forms are supported.
+//
+//  <path>          - the path itself and paths under it, "." and "" match all paths
+//  <glob>          - paths matching the wildcard pattern, '*' matches '/' too
+//  :!<spec>        - exclude paths matching spec, ":^<spec>" and ":(exclude)<spec>" are the same
+//  :/<spec>        - spec relative from top level directory, it is the default for this package
+//
+// Paths are always relative from top level directory and separated by '/'.
+//
+// If you want to know more about pathspec, please refer to
+// https://git-scm.com/docs/gitglossary#Documentation/gitglossary.txt-aiddefpathspecapathspec
+package pathspec
+
+import (
+	"path"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/wildmatch"
+)
+
+// item is a type representing one pathspec.
+type item struct {
+	pattern string // cleaned pattern, "" matches everything
+	literal string // leading part of pattern without wildcards
+	glob    bool   // pattern has wildcards
+}
+
+// Pathspec is a type representing a list of pathspecs.
+// nil or empty Pathspec matches all paths.
+type Pathspec struct {
+	includes   []*item
+	excludes   []*item
+	ignoreCase bool
+}
+
+// New creates a Pathspec from patterns.
+func New(patterns ...string) *Pathspec {
+	p := &Pathspec{}
+	for _, pattern := range patterns {
+		exclude := false
+		switch {
+		case strings.HasPrefix(pattern, ":(exclude)"):
+			exclude = true
+			pattern = pattern[len(":(exclude)"):]
+		case strings.HasPrefix(pattern, ":!"), strings.HasPrefix(pattern, ":^"):
+			exclude = true
+			pattern = pattern[2:]
+		}
+		pattern = strings.TrimPrefix(pattern, ":/")
+		if exclude {
+			p.excludes = append(p.excludes, newItem(pattern))
+		} else {
+			p.includes = append(p.includes, newItem(pattern))
+		}
+	}
+	return p
+}
+
+// IgnoreCase makes the Pathspec match case-insensitively like core.ignorecase.
+func (p *Pathspec) IgnoreCase(ignoreCase bool) *Pathspec {
+	p.ignoreCase = ignoreCase
+	return p
+}
+
+func newItem(pattern string) *item {
+	cleaned := path.Clean("/" + pattern)[1:]
+	if strings.HasSuffix(pattern, "/") && cleaned != "" {
+		cleaned += "/"
+	}
+	it := &item{pattern: cleaned, literal: cleaned}
+	if i := strings.IndexAny(cleaned, "*?[\\"); i >= 0 {
+		it.glob = true
+		it.literal = cleaned[:i]
+	}
+	return it
+}
+
+// Match reports whether the path matches the Pathspec.
+func (p *Pathspec) Match(name string) bool {
+	if p == nil {
+		return true
+	}
+	for _, it := range p.excludes {
+		if p.matchItem(it, name) {
+			return false
+		}
+	}
+	if len(p.includes) == 0 {
+		return true
+	}
+	for _, it := range p.includes {
+		if p.matchItem(it, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchDir reports whether paths under the directory may match the Pathspec.
+// it's used to skip directories which can't contain matching paths.
+func (p *Pathspec) MatchDir(dir string) bool {
+	if p == nil || len(p.includes) == 0 {
+		return true
+	}
+	dir += "/"
+	for _, it := range p.includes {
+		literal := it.literal
+		if !it.glob && !strings.HasSuffix(literal, "/") && literal != "" {
+			literal += "/"
+		}
+		if p.hasPrefix(dir, literal) || p.hasPrefix(literal, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pathspec) matchItem(it *item, name string) bool {
+	if it.pattern == "" {
+		return true
+	}
+	if !it.glob {
+		pattern := strings.TrimSuffix(it.pattern, "/")
+		if p.equal(name, pattern) {
+			return !strings.HasSuffix(it.pattern, "/")
+		}
+		return p.hasPrefix(name, pattern+"/")
+	}
+	flags := wildmatch.Flags(0)
+	if p.ignoreCase {
+		flags |= wildmatch.CaseFold
+	}
+	return wildmatch.Match(it.pattern, name, flags)
+}
+
+func (p *Pathspec) equal(a, b string) bool {
+	if p.ignoreCase {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+func (p *Pathspec) hasPrefix(s, prefix string) bool {
+	return len(s) >= len(prefix) && p.equal(s[:len(prefix)], prefix)
+}
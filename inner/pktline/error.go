@@ -0,0 +1,8 @@
+package pktline
+
+import "errors"
+
+var (
+	ErrInvalidLength = errors.New("invalid pkt-line length")
+	ErrTooLong       = errors.New("pkt-line data is too long")
+)
@@ -0,0 +1,151 @@
+// pktline is a package to read and write pkt-line format used in git protocols
+//
+// Each packet starts with 4 hex digits of its length including the length itself.
+// The special packet "0000" is a flush packet which ends a section.
+//
+//  0009done\n   - packet whose data is "done\n"
+//  0000         - flush packet
+//
+// If you want to know more about pkt-line format, please refer to
+// https://git-scm.com/docs/protocol-common#_pkt_line_format
+package pktline
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxPacketSize is the max length of a packet including 4 bytes length
+	MaxPacketSize = 65520
+	// MaxDataSize is the max length of data in a packet
+	MaxDataSize = MaxPacketSize - 4
+)
+
+// Reader is a type to read packets.
+type Reader struct {
+	r io.Reader
+}
+
+// NewReader creates a Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// ReadPacket reads a packet, it returns nil data for a flush packet.
+// data of other packets are not nil even if it's empty.
+func (r *Reader) ReadPacket() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return nil, ErrInvalidLength
+	}
+	if length == 0 {
+		return nil, nil
+	}
+	if length < 4 || length > MaxPacketSize {
+		return nil, ErrInvalidLength
+	}
+	data := make([]byte, length-4)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// ReadLines reads text packets until a flush packet, trailing LF of each line is removed.
+func (r *Reader) ReadLines() ([]string, error) {
+	lines := []string{}
+	for {
+		data, err := r.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			return lines, nil
+		}
+		lines = append(lines, strings.TrimSuffix(string(data), "\n"))
+	}
+}
+
+// ReadData reads packets until a flush packet, and returns concatenated data of them.
+func (r *Reader) ReadData() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	for {
+		data, err := r.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			return buf.Bytes(), nil
+		}
+		buf.Write(data)
+	}
+}
+
+// Writer is a type to write packets.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter creates a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WritePacket writes data as a packet.
+func (w *Writer) WritePacket(data []byte) error {
+	if len(data) > MaxDataSize {
+		return ErrTooLong
+	}
+	if _, err := fmt.Fprintf(w.w, "%04x", len(data)+4); err != nil {
+		return err
+	}
+	_, err := w.w.Write(data)
+	return err
+}
+
+// WriteLine writes text line as a packet, LF is appended.
+func (w *Writer) WriteLine(line string) error {
+	return w.WritePacket([]byte(line + "\n"))
+}
+
+// WriteLines writes text lines as packets followed by a flush packet.
+func (w *Writer) WriteLines(lines ...string) error {
+	for _, line := range lines {
+		if err := w.WriteLine(line); err != nil {
+			return err
+		}
+	}
+	return w.WriteFlush()
+}
+
+// WriteData writes data split into packets followed by a flush packet.
+func (w *Writer) WriteData(data []byte) error {
+	for len(data) > 0 {
+		n := len(data)
+		if n > MaxDataSize {
+			n = MaxDataSize
+		}
+		if err := w.WritePacket(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return w.WriteFlush()
+}
+
+// WriteFlush writes a flush packet.
+func (w *Writer) WriteFlush() error {
+	_, err := io.WriteString(w.w, "0000")
+	return err
+}
@@ -0,0 +1,90 @@
+package reflog
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// absoluteDateLayouts are layouts of absolute date accepted by ParseDate
+var absoluteDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon Jan 2 15:04:05 2006 -0700",
+}
+
+// relativeDateUnits are units of relative date like "3.days.ago"
+var relativeDateUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+}
+
+// ParseDate parses date string what is used in ref@{date} notation.
+// the following formats are supported.
+//  - now, yesterday
+//  - relative date like "3.days.ago", "2 weeks ago", "1.month.ago"
+//  - absolute date like "2006-01-02", "2006-01-02 15:04:05", RFC3339
+//  - unix time like "@1136214245"
+func ParseDate(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "now":
+		return now, nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	}
+	if strings.HasPrefix(s, "@") {
+		sec, err := strconv.ParseInt(s[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, ErrInvalidDate
+		}
+		return time.Unix(sec, 0), nil
+	}
+	if t, ok := parseRelativeDate(s, now); ok {
+		return t, nil
+	}
+	for _, layout := range absoluteDateLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrInvalidDate
+}
+
+// parseRelativeDate parses "<number>.<unit>[.ago]" format date.
+func parseRelativeDate(s string, now time.Time) (time.Time, bool) {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == '.' || r == ' ' || r == '_'
+	})
+	if len(fields) == 3 && fields[2] == "ago" {
+		fields = fields[:2]
+	}
+	if len(fields) != 2 {
+		return time.Time{}, false
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil || n < 0 {
+		return time.Time{}, false
+	}
+	unit := strings.TrimSuffix(fields[1], "s")
+	switch unit {
+	case "month":
+		return now.AddDate(0, -n, 0), true
+	case "year":
+		return now.AddDate(-n, 0, 0), true
+	}
+	duration, ok := relativeDateUnits[unit]
+	if !ok {
+		return time.Time{}, false
+	}
+	return now.Add(-time.Duration(n) * duration), true
+}
@@ -0,0 +1,12 @@
+package reflog
+
+import "errors"
+
+var (
+	ErrInvalidEntry      = errors.New("invalid reflog entry")
+	ErrReflogNotFound    = errors.New("reflog is not found")
+	ErrEntryOutOfRange   = errors.New("reflog doesn't have so many entries")
+	ErrInvalidDate       = errors.New("invalid date format")
+	ErrInvalidSelector   = errors.New("invalid reflog selector")
+	ErrInvalidDigestSize = errors.New("invalid SHA1 digest size")
+)
@@ -0,0 +1,296 @@
+// reflog is a package to handle git reference logs
+//
+// Each reference has its own log file at .git/logs/<reference name>
+// (e.g. .git/logs/HEAD, .git/logs/refs/heads/master).
+// One line of the log file is one update of the reference, oldest first.
+//
+//  <old SHA1 hex> <new SHA1 hex> <name> <<email>> <unix time> <timezone>\t<message>\n
+//
+// Zero digest(40 zeros) as old digest means that the reference was created,
+// and as new digest means that the reference was deleted.
+package reflog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// digestSize is byte length of SHA1 digest
+const digestSize = 20
+
+// Entry is a type representing one update of a reference.
+type Entry struct {
+	OldDigest []byte            // SHA1 digest before the update
+	NewDigest []byte            // SHA1 digest after the update
+	Committer *object.Signature // identity who updated the reference and when it was updated
+	Message   string            // reason of the update (e.g. "commit: fix typo")
+}
+
+// String is implementation of fmt.Stringer interface.
+// it returns one line of reflog file without trailing new line.
+func (e *Entry) String() string {
+	line := fmt.Sprintf("%s %s %s",
+		digestHex(e.OldDigest),
+		digestHex(e.NewDigest),
+		e.Committer)
+	if message := CleanMessage(e.Message); message != "" {
+		line += "\t" + message
+	}
+	return line
+}
+
+// digestHex returns hex string of digest, or 40 zeros if digest is nil
+func digestHex(digest []byte) string {
+	if digest == nil {
+		digest = make([]byte, digestSize)
+	}
+	return hex.EncodeToString(digest)
+}
+
+// CleanMessage converts message into one line in the same way as git.
+// white spaces including new lines are squashed into one space.
+func CleanMessage(message string) string {
+	return strings.Join(strings.Fields(message), " ")
+}
+
+// ParseEntry parses one line of reflog file.
+func ParseEntry(line string) (*Entry, error) {
+	line = strings.TrimRight(line, "\n")
+	if len(line) < digestSize*4+2 || line[digestSize*2] != ' ' || line[digestSize*4+1] != ' ' {
+		return nil, ErrInvalidEntry
+	}
+	oldDigest, err := hex.DecodeString(line[:digestSize*2])
+	if err != nil {
+		return nil, ErrInvalidEntry
+	}
+	newDigest, err := hex.DecodeString(line[digestSize*2+1 : digestSize*4+1])
+	if err != nil {
+		return nil, ErrInvalidEntry
+	}
+
+	rest := line[digestSize*4+2:]
+	message := ""
+	if tab := strings.IndexByte(rest, '\t'); tab >= 0 {
+		message = rest[tab+1:]
+		rest = rest[:tab]
+	}
+	committer, err := object.ParseSignature(rest)
+	if err != nil {
+		return nil, ErrInvalidEntry
+	}
+	return &Entry{
+		OldDigest: oldDigest,
+		NewDigest: newDigest,
+		Committer: committer,
+		Message:   message,
+	}, nil
+}
+
+// Reflog is a type representing whole log of a reference.
+type Reflog struct {
+	Name    string   // name of the reference (e.g. HEAD, refs/heads/master)
+	Entries []*Entry // updates of the reference, oldest first
+}
+
+// Path returns path to log file of the reference.
+func Path(gitDir, name string) string {
+	return filepath.Join(gitDir, "logs", filepath.FromSlash(name))
+}
+
+// Exists returns whether the reference has a log file.
+func Exists(gitDir, name string) bool {
+	stat, err := os.Stat(Path(gitDir, name))
+	return err == nil && !stat.IsDir()
+}
+
+// Read reads log of the reference.
+func Read(gitDir, name string) (*Reflog, error) {
+	data, err := ioutil.ReadFile(Path(gitDir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrReflogNotFound
+		}
+		return nil, err
+	}
+	reflog := &Reflog{Name: name}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		entry, err := ParseEntry(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		reflog.Entries = append(reflog.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return reflog, nil
+}
+
+// Append appends an entry to log of the reference.
+// the log file is created if it doesn't exist.
+func Append(gitDir, name string, entry *Entry) error {
+	if err := checkEntry(entry); err != nil {
+		return err
+	}
+	path := Path(gitDir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(entry.String() + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Write replaces log file of the reference with entries of reflog.
+func Write(gitDir string, reflog *Reflog) error {
+	path := Path(gitDir, reflog.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	lock, err := util.NewLockFile(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+
+	w := bufio.NewWriter(lock)
+	for _, entry := range reflog.Entries {
+		if err := checkEntry(entry); err != nil {
+			return err
+		}
+		if _, err := w.WriteString(entry.String() + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return lock.Commit()
+}
+
+// Delete deletes log file of the reference.
+func Delete(gitDir, name string) error {
+	if err := os.Remove(Path(gitDir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func checkEntry(entry *Entry) error {
+	if entry.OldDigest != nil && len(entry.OldDigest) != digestSize {
+		return ErrInvalidDigestSize
+	}
+	if entry.NewDigest != nil && len(entry.NewDigest) != digestSize {
+		return ErrInvalidDigestSize
+	}
+	if entry.Committer == nil {
+		return ErrInvalidEntry
+	}
+	return nil
+}
+
+// Nth returns the n-th prior entry, it is used for ref@{n} notation.
+// Nth(0) is the latest entry.
+func (r *Reflog) Nth(n int) (*Entry, error) {
+	if n < 0 || len(r.Entries) <= n {
+		return nil, ErrEntryOutOfRange
+	}
+	return r.Entries[len(r.Entries)-1-n], nil
+}
+
+// DigestAt returns SHA1 digest what the reference pointed to at t, it is used for ref@{date} notation.
+// if t is older than the oldest entry, the old digest of the oldest entry is returned like git,
+// or the new digest if the reference was created by the oldest entry.
+func (r *Reflog) DigestAt(t time.Time) ([]byte, error) {
+	if len(r.Entries) == 0 {
+		return nil, ErrEntryOutOfRange
+	}
+	for i := len(r.Entries) - 1; i >= 0; i-- {
+		if !r.Entries[i].Committer.When.After(t) {
+			return r.Entries[i].NewDigest, nil
+		}
+	}
+	oldest := r.Entries[0]
+	if oldest.OldDigest == nil || isZeroDigest(oldest.OldDigest) {
+		return oldest.NewDigest, nil
+	}
+	return oldest.OldDigest, nil
+}
+
+func isZeroDigest(digest []byte) bool {
+	for _, b := range digest {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup returns SHA1 digest what selector specifies.
+// selector is contents of braces of ref@{...} notation, so it is a number or a date.
+func (r *Reflog) Lookup(selector string, now time.Time) ([]byte, error) {
+	if n, err := strconv.Atoi(selector); err == nil {
+		entry, err := r.Nth(n)
+		if err != nil {
+			return nil, err
+		}
+		return entry.NewDigest, nil
+	}
+	t, err := ParseDate(selector, now)
+	if err != nil {
+		return nil, ErrInvalidSelector
+	}
+	return r.DigestAt(t)
+}
+
+// Expire removes entries older than before and returns the number of removed entries.
+func (r *Reflog) Expire(before time.Time) int {
+	kept := make([]*Entry, 0, len(r.Entries))
+	for _, entry := range r.Entries {
+		if entry.Committer.When.Before(before) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	removed := len(r.Entries) - len(kept)
+	r.Entries = kept
+	return removed
+}
+
+// Expire removes entries older than before from log file of the reference.
+func Expire(gitDir, name string, before time.Time) (int, error) {
+	reflog, err := Read(gitDir, name)
+	if err != nil {
+		return 0, err
+	}
+	removed := reflog.Expire(before)
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := Write(gitDir, reflog); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
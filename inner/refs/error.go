@@ -0,0 +1,14 @@
+package refs
+
+import "errors"
+
+var (
+	ErrRefNotFound            = errors.New("reference is not found")
+	ErrInvalidRefName         = errors.New("invalid reference name")
+	ErrInvalidRefFormat       = errors.New("invalid reference format")
+	ErrInvalidDigest          = errors.New("invalid SHA1 digest")
+	ErrSymbolicRefLoop        = errors.New("symbolic reference loop is detected")
+	ErrRefMismatch            = errors.New("reference does not match the expected value")
+	ErrInvalidPackedRefs      = errors.New("invalid packed-refs format")
+	ErrNotSupportedRefStorage = errors.New("this reference storage is not supported")
+)
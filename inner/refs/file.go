@@ -0,0 +1,394 @@
+package refs
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/reflog"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// symbolicRefPrefix is prefix of contents of loose symbolic reference file
+const symbolicRefPrefix = "ref:"
+
+// fileStore is a Store of loose refs and packed-refs under .git directory
+type fileStore struct {
+	gitDir string
+}
+
+// NewFileStore creates a Store of loose refs and packed-refs.
+// gitDir of parameters must be path to .git directory.
+func NewFileStore(gitDir string) Store {
+	return &fileStore{
+		gitDir: gitDir,
+	}
+}
+
+func (s *fileStore) loosePath(name string) string {
+	return filepath.Join(s.gitDir, filepath.FromSlash(name))
+}
+
+func (s *fileStore) packedRefsPath() string {
+	return filepath.Join(s.gitDir, "packed-refs")
+}
+
+// Read reads the reference without following symbolic references.
+func (s *fileStore) Read(name string) (*Ref, error) {
+	if !IsValidName(name) {
+		return nil, ErrInvalidRefName
+	}
+	ref, err := s.readLoose(name)
+	if err == nil {
+		return ref, nil
+	}
+	if err != ErrRefNotFound {
+		return nil, err
+	}
+	packed, err := readPackedRefs(s.packedRefsPath())
+	if err != nil {
+		return nil, err
+	}
+	if ref, ok := packed.refs[name]; ok {
+		return ref, nil
+	}
+	return nil, ErrRefNotFound
+}
+
+// readLoose reads loose reference file.
+func (s *fileStore) readLoose(name string) (*Ref, error) {
+	data, err := ioutil.ReadFile(s.loosePath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrRefNotFound
+		}
+		if stat, statErr := os.Stat(s.loosePath(name)); statErr == nil && stat.IsDir() {
+			return nil, ErrRefNotFound
+		}
+		return nil, err
+	}
+	return parseLoose(name, data)
+}
+
+// parseLoose parses contents of loose reference file.
+func parseLoose(name string, data []byte) (*Ref, error) {
+	content := strings.TrimRight(string(data), "\r\n\t ")
+	if strings.HasPrefix(content, symbolicRefPrefix) {
+		target := strings.TrimSpace(content[len(symbolicRefPrefix):])
+		if !IsValidName(target) {
+			return nil, ErrInvalidRefFormat
+		}
+		return &Ref{
+			Name:   name,
+			Target: target,
+		}, nil
+	}
+	// FETCH_HEAD and MERGE_HEAD may have some lines, so only the first digest is used.
+	if len(content) < DigestSize*2 {
+		return nil, ErrInvalidRefFormat
+	}
+	if len(content) > DigestSize*2 && !strings.ContainsRune(" \t\n", rune(content[DigestSize*2])) {
+		return nil, ErrInvalidRefFormat
+	}
+	digest, err := ParseDigest(content[:DigestSize*2])
+	if err != nil {
+		return nil, ErrInvalidRefFormat
+	}
+	return &Ref{
+		Name:   name,
+		Digest: digest,
+	}, nil
+}
+
+// Resolve reads the reference following symbolic references.
+// returned *Ref is the last reference what is not symbolic.
+func (s *fileStore) Resolve(name string) (*Ref, error) {
+	return resolve(s, name)
+}
+
+// resolve follows symbolic references from name in store.
+func resolve(store Store, name string) (*Ref, error) {
+	visited := map[string]struct{}{}
+	for depth := 0; depth <= maxSymbolicRefDepth; depth++ {
+		if _, ok := visited[name]; ok {
+			return nil, ErrSymbolicRefLoop
+		}
+		visited[name] = struct{}{}
+		ref, err := store.Read(name)
+		if err != nil {
+			return nil, err
+		}
+		if !ref.IsSymbolic() {
+			return ref, nil
+		}
+		name = ref.Target
+	}
+	return nil, ErrSymbolicRefLoop
+}
+
+// resolveName follows symbolic references from name and returns
+// the name of the last reference even if it doesn't exist yet (e.g. unborn branch).
+func resolveName(store Store, name string) (string, error) {
+	visited := map[string]struct{}{}
+	for depth := 0; depth <= maxSymbolicRefDepth; depth++ {
+		if _, ok := visited[name]; ok {
+			return "", ErrSymbolicRefLoop
+		}
+		visited[name] = struct{}{}
+		ref, err := store.Read(name)
+		if err == ErrRefNotFound {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if !ref.IsSymbolic() {
+			return name, nil
+		}
+		name = ref.Target
+	}
+	return "", ErrSymbolicRefLoop
+}
+
+// Update makes the reference point to newDigest if current digest is equal to oldDigest.
+// if oldDigest is nil, current digest is not checked.
+// if oldDigest is ZeroDigest, the reference must not exist.
+// if name is a symbolic reference, the reference what it points to is updated.
+// if log is not nil, the update is recorded to reflogs.
+func (s *fileStore) Update(name string, newDigest, oldDigest []byte, log *LogMessage) error {
+	if len(newDigest) != DigestSize || IsZeroDigest(newDigest) {
+		return ErrInvalidDigest
+	}
+	if !IsValidName(name) {
+		return ErrInvalidRefName
+	}
+	resolvedName, err := resolveName(s, name)
+	if err != nil {
+		return err
+	}
+	current, err := s.writeLoose(resolvedName, oldDigest, []byte(hex.EncodeToString(newDigest)+"\n"))
+	if err != nil {
+		return err
+	}
+	if log == nil {
+		return nil
+	}
+	return s.writeLogs(name, resolvedName, current, newDigest, log)
+}
+
+// UpdateSymbolic makes the reference a symbolic reference pointing to target.
+// if log is not nil and target exists, the switch is recorded to reflog of name.
+func (s *fileStore) UpdateSymbolic(name, target string, log *LogMessage) error {
+	if !IsValidName(name) || !IsValidName(target) {
+		return ErrInvalidRefName
+	}
+	var oldDigest []byte
+	if log != nil {
+		if ref, err := s.Resolve(name); err == nil {
+			oldDigest = ref.Digest
+		}
+	}
+	if _, err := s.writeLoose(name, nil, []byte(symbolicRefPrefix+" "+target+"\n")); err != nil {
+		return err
+	}
+	if log == nil {
+		return nil
+	}
+	ref, err := s.Resolve(target)
+	if err == ErrRefNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return appendLog(s.gitDir, name, oldDigest, ref.Digest, log)
+}
+
+// Detach makes the reference itself point to newDigest like $ git update-ref --no-deref
+// symbolic references are not followed, so Detach("HEAD", ...) detaches HEAD from the branch.
+// oldDigest is compared with the digest which the reference resolves to, in the same way as Update.
+func (s *fileStore) Detach(name string, newDigest, oldDigest []byte, log *LogMessage) error {
+	if len(newDigest) != DigestSize || IsZeroDigest(newDigest) {
+		return ErrInvalidDigest
+	}
+	if !IsValidName(name) {
+		return ErrInvalidRefName
+	}
+	var current []byte
+	if ref, err := s.Resolve(name); err == nil {
+		current = ref.Digest
+	} else if err != ErrRefNotFound {
+		return err
+	}
+	if err := checkDigest(current, oldDigest); err != nil {
+		return err
+	}
+	if _, err := s.writeLoose(name, nil, []byte(hex.EncodeToString(newDigest)+"\n")); err != nil {
+		return err
+	}
+	if log == nil {
+		return nil
+	}
+	return appendLog(s.gitDir, name, current, newDigest, log)
+}
+
+// writeLoose writes contents to the loose reference file under the lock.
+// it returns digest before the update, or nil if the reference didn't exist.
+func (s *fileStore) writeLoose(name string, oldDigest []byte, contents []byte) ([]byte, error) {
+	path := s.loosePath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+	lock, err := util.NewLockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Rollback()
+
+	current, err := s.currentDigest(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDigest(current, oldDigest); err != nil {
+		return nil, err
+	}
+	if _, err := lock.Write(contents); err != nil {
+		return nil, err
+	}
+	return current, lock.Commit()
+}
+
+// currentDigest returns digest of the reference, or nil if it doesn't exist.
+func (s *fileStore) currentDigest(name string) ([]byte, error) {
+	ref, err := s.Read(name)
+	if err == ErrRefNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ref.Digest, nil
+}
+
+// Delete deletes the reference if current digest is equal to oldDigest.
+// Delete doesn't follow symbolic references, so Delete("HEAD", nil) deletes HEAD itself.
+// the reflog of the reference is deleted too.
+func (s *fileStore) Delete(name string, oldDigest []byte) error {
+	if !IsValidName(name) {
+		return ErrInvalidRefName
+	}
+	path := s.loosePath(name)
+	lock, err := util.NewLockFile(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+
+	current, err := s.Read(name)
+	if err != nil {
+		return err
+	}
+	if err := checkDigest(current.Digest, oldDigest); err != nil {
+		return err
+	}
+
+	if err := s.deletePacked(name); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lock.Rollback()
+	s.removeEmptyDirs(filepath.Dir(path))
+	return reflog.Delete(s.gitDir, name)
+}
+
+// deletePacked removes the reference from packed-refs if it is packed.
+func (s *fileStore) deletePacked(name string) error {
+	lock, err := util.NewLockFile(s.packedRefsPath())
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+
+	packed, err := readPackedRefs(s.packedRefsPath())
+	if err != nil {
+		return err
+	}
+	if _, ok := packed.refs[name]; !ok {
+		return nil
+	}
+	delete(packed.refs, name)
+	if err := packed.writeTo(lock); err != nil {
+		return err
+	}
+	return lock.Commit()
+}
+
+// removeEmptyDirs removes empty directories from dir to .git/refs.
+func (s *fileStore) removeEmptyDirs(dir string) {
+	refsDir := filepath.Join(s.gitDir, "refs")
+	for strings.HasPrefix(dir, refsDir+string(filepath.Separator)) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// List lists references whose name has prefix in name order.
+// the references are under refs/, so pseudo references like HEAD are not listed.
+func (s *fileStore) List(prefix string) ([]*Ref, error) {
+	packed, err := readPackedRefs(s.packedRefsPath())
+	if err != nil {
+		return nil, err
+	}
+	all := packed.refs
+
+	refsDir := filepath.Join(s.gitDir, "refs")
+	err = filepath.Walk(refsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.gitDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !IsValidName(name) {
+			return nil
+		}
+		ref, err := s.readLoose(name)
+		if err == ErrRefNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		all[name] = ref
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]*Ref, 0, len(all))
+	for name, ref := range all {
+		if strings.HasPrefix(name, prefix) {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+	return refs, nil
+}
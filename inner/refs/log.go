@@ -0,0 +1,88 @@
+package refs
+
+import (
+	"strings"
+
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/reflog"
+)
+
+// LogMessage is a type representing who updates a reference and why.
+// it is recorded to reflog with digests before and after the update.
+type LogMessage struct {
+	Committer *object.Signature
+	Message   string
+}
+
+// logAllRefPrefixes are prefixes of references whose reflogs are created automatically
+// in the same way as core.logAllRefUpdates=true
+var logAllRefPrefixes = []string{
+	HeadPrefix,
+	RemotePrefix,
+	"refs/notes/",
+}
+
+// shouldLog returns whether updates of the reference should be recorded to reflog.
+func shouldLog(gitDir, name string) bool {
+	if reflog.Exists(gitDir, name) {
+		return true
+	}
+	return isLogAllRef(name)
+}
+
+// isLogAllRef returns whether reflog of the reference is created automatically.
+func isLogAllRef(name string) bool {
+	if name == HEAD {
+		return true
+	}
+	for _, prefix := range logAllRefPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendLog appends an entry to reflog of the reference if it should be logged.
+func appendLog(gitDir, name string, oldDigest, newDigest []byte, log *LogMessage) error {
+	if !shouldLog(gitDir, name) {
+		return nil
+	}
+	return reflog.Append(gitDir, name, &reflog.Entry{
+		OldDigest: oldDigest,
+		NewDigest: newDigest,
+		Committer: log.Committer,
+		Message:   log.Message,
+	})
+}
+
+// logNames returns names of references whose reflogs record an update of resolvedName.
+// like git, the update is also recorded to reflogs of name if it is a symbolic reference,
+// and of HEAD if HEAD points to resolvedName.
+func logNames(store Store, name, resolvedName string) []string {
+	names := []string{resolvedName}
+	if name != resolvedName {
+		names = append(names, name)
+	}
+	if name != HEAD {
+		if head, err := store.Read(HEAD); err == nil && head.Target == resolvedName {
+			names = append(names, HEAD)
+		}
+	}
+	return names
+}
+
+// writeLogs records an update of resolvedName to reflogs.
+func (s *fileStore) writeLogs(name, resolvedName string, oldDigest, newDigest []byte, log *LogMessage) error {
+	for _, logName := range logNames(s, name, resolvedName) {
+		if err := appendLog(s.gitDir, logName, oldDigest, newDigest, log); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadLog reads reflog of the reference.
+func (s *fileStore) ReadLog(name string) (*reflog.Reflog, error) {
+	return reflog.Read(s.gitDir, name)
+}
@@ -0,0 +1,121 @@
+package refs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// packedRefsHeader is the header line what git writes to packed-refs.
+const packedRefsHeader = "# pack-refs with: peeled fully-peeled sorted "
+
+// packedRefs is a type representing contents of .git/packed-refs
+type packedRefs struct {
+	header string          // first line beginning with '#', it describes traits of this file
+	refs   map[string]*Ref // references keyed by name
+}
+
+// readPackedRefs reads packed-refs file.
+// if the file doesn't exist, it returns empty packedRefs.
+func readPackedRefs(path string) (*packedRefs, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &packedRefs{refs: map[string]*Ref{}}, nil
+		}
+		return nil, err
+	}
+	return parsePackedRefs(data)
+}
+
+// parsePackedRefs parses contents of packed-refs file.
+func parsePackedRefs(data []byte) (*packedRefs, error) {
+	packed := &packedRefs{refs: map[string]*Ref{}}
+	var last *Ref
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#"):
+			if packed.header == "" {
+				packed.header = line
+			}
+			last = nil
+		case strings.HasPrefix(line, "^"):
+			// "^<40 hex digits>" is a peeled object of the previous annotated tag
+			if last == nil {
+				return nil, ErrInvalidPackedRefs
+			}
+			peeled, err := ParseDigest(line[1:])
+			if err != nil {
+				return nil, ErrInvalidPackedRefs
+			}
+			last.Peeled = peeled
+			last = nil
+		default:
+			// "<40 hex digits> <name>"
+			if len(line) < DigestSize*2+2 || line[DigestSize*2] != ' ' {
+				return nil, ErrInvalidPackedRefs
+			}
+			digest, err := ParseDigest(line[:DigestSize*2])
+			if err != nil {
+				return nil, ErrInvalidPackedRefs
+			}
+			name := line[DigestSize*2+1:]
+			if !IsValidName(name) {
+				return nil, ErrInvalidPackedRefs
+			}
+			last = &Ref{
+				Name:   name,
+				Digest: digest,
+			}
+			packed.refs[name] = last
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return packed, nil
+}
+
+// sortedRefs returns references in name order.
+func (p *packedRefs) sortedRefs() []*Ref {
+	refs := make([]*Ref, 0, len(p.refs))
+	for _, ref := range p.refs {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+	return refs
+}
+
+// writeTo writes packed-refs file contents to w.
+func (p *packedRefs) writeTo(w io.Writer) error {
+	header := p.header
+	if header == "" {
+		header = packedRefsHeader
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(header + "\n"); err != nil {
+		return err
+	}
+	for _, ref := range p.sortedRefs() {
+		if _, err := bw.WriteString(hex.EncodeToString(ref.Digest) + " " + ref.Name + "\n"); err != nil {
+			return err
+		}
+		if ref.Peeled != nil {
+			if _, err := bw.WriteString("^" + hex.EncodeToString(ref.Peeled) + "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,182 @@
+// refs is a package to handle git references
+//
+// A reference is a name pointing to an object by its SHA1 digest, or a
+// symbolic reference pointing to another reference (e.g. HEAD).
+// References are stored in the following two places.
+//
+//  - loose refs  : one file per reference under .git (e.g. .git/refs/heads/master)
+//                  which contains "<40 hex digits>\n" or "ref: <name>\n"
+//  - packed-refs : .git/packed-refs, which contains many references at once
+//
+//  # pack-refs with: peeled fully-peeled sorted
+//  <40 hex digits> refs/heads/master
+//  <40 hex digits> refs/tags/v1.0
+//  ^<40 hex digits>   <- peeled object of the annotated tag above
+//
+// Loose refs take precedence over packed-refs.
+//
+// If you want to know more about git references, please refer to
+// https://git-scm.com/book/en/v2/Git-Internals-Git-References
+package refs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/reflog"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// constants of well-known reference names and prefixes
+const (
+	HEAD       = "HEAD"
+	OrigHEAD   = "ORIG_HEAD"
+	MergeHEAD  = "MERGE_HEAD"
+	FetchHEAD  = "FETCH_HEAD"
+	RefsPrefix = "refs/"
+	HeadPrefix = "refs/heads/"
+	TagPrefix  = "refs/tags/"
+	// RemotePrefix is prefix of remote-tracking branches
+	RemotePrefix = "refs/remotes/"
+)
+
+// maxSymbolicRefDepth is the same limit as git's SYMREF_MAXDEPTH
+const maxSymbolicRefDepth = 5
+
+// DigestSize is byte length of SHA1 digest
+const DigestSize = 20
+
+// ZeroDigest is a digest meaning that the reference doesn't exist.
+// Passing ZeroDigest as old digest of Update requires the reference to be created newly.
+var ZeroDigest = make([]byte, DigestSize)
+
+// Ref is a type representing a git reference.
+type Ref struct {
+	Name   string // full name of this reference (e.g. refs/heads/master)
+	Target string // name of the reference what this symbolic reference points to
+	Digest []byte // SHA1 digest of the object what this reference points to
+	Peeled []byte // SHA1 digest of the object what the annotated tag points to, if it is known
+}
+
+// IsSymbolic returns whether this reference is a symbolic reference.
+func (r *Ref) IsSymbolic() bool {
+	return r.Target != ""
+}
+
+// String is implementation of fmt.Stringer interface
+func (r *Ref) String() string {
+	if r.IsSymbolic() {
+		return fmt.Sprintf("%s -> %s", r.Name, r.Target)
+	}
+	return fmt.Sprintf("%s %s", hex.EncodeToString(r.Digest), r.Name)
+}
+
+// Store is interface of handle git references
+type Store interface {
+	Read(name string) (*Ref, error)                                         // read the reference without following symbolic references.
+	Resolve(name string) (*Ref, error)                                      // read the reference following symbolic references.
+	Update(name string, newDigest, oldDigest []byte, log *LogMessage) error // compare oldDigest and swap to newDigest.
+	UpdateSymbolic(name, target string, log *LogMessage) error              // make the reference symbolic reference to target.
+	Detach(name string, newDigest, oldDigest []byte, log *LogMessage) error // compare oldDigest and make the reference itself point to newDigest.
+	Delete(name string, oldDigest []byte) error                             // compare oldDigest and delete the reference and its reflog.
+	List(prefix string) ([]*Ref, error)                                     // list references whose name has prefix in name order.
+	ReadLog(name string) (*reflog.Reflog, error)                            // read reflog of the reference.
+}
+
+// OpenStore opens reference store of current repository
+func OpenStore() (Store, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(gitDir)
+}
+
+// NewStore opens reference store of the repository.
+// the backend is selected by extensions.refStorage in .git/config,
+// "files"(loose refs and packed-refs) or "reftable".
+func NewStore(gitDir string) (Store, error) {
+	cfg, err := config.ReadFile(filepath.Join(gitDir, "config"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		cfg = config.New()
+	}
+	switch strings.ToLower(cfg.GetString("extensions.refStorage", "files")) {
+	case "files":
+		return NewFileStore(gitDir), nil
+	case "reftable":
+		return NewReftableStore(gitDir)
+	default:
+		return nil, ErrNotSupportedRefStorage
+	}
+}
+
+// IsValidName returns whether name is allowed as a reference name.
+// the rules are the same as $ git check-ref-format
+func IsValidName(name string) bool {
+	if name == "" || name == "@" {
+		return false
+	}
+	if strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") ||
+		strings.Contains(name, "..") || strings.Contains(name, "@{") ||
+		strings.Contains(name, "//") {
+		return false
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7F || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseDigest parses 40 hex digits to SHA1 digest
+func ParseDigest(s string) ([]byte, error) {
+	if len(s) != DigestSize*2 {
+		return nil, ErrInvalidDigest
+	}
+	digest, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidDigest
+	}
+	return digest, nil
+}
+
+// IsZeroDigest returns whether digest is ZeroDigest
+func IsZeroDigest(digest []byte) bool {
+	return bytes.Equal(digest, ZeroDigest)
+}
+
+// checkDigest compares current digest of a reference with expected digest.
+// current is nil if the reference doesn't exist.
+func checkDigest(current, expected []byte) error {
+	if expected == nil {
+		return nil
+	}
+	if IsZeroDigest(expected) {
+		if current != nil {
+			return ErrRefMismatch
+		}
+		return nil
+	}
+	if !bytes.Equal(current, expected) {
+		return ErrRefMismatch
+	}
+	return nil
+}
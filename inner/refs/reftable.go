@@ -0,0 +1,322 @@
+package refs
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/reflog"
+	"github.com/shumon84/mogit/inner/reftable"
+)
+
+// reftableStore is a Store of reftable stack in .git/reftable
+type reftableStore struct {
+	gitDir string
+	stack  *reftable.Stack
+	// files is a store of FETCH_HEAD and MERGE_HEAD, they are always stored as files
+	files *fileStore
+}
+
+// NewReftableStore creates a Store of reftable stack.
+// gitDir of parameters must be path to .git directory.
+func NewReftableStore(gitDir string) (Store, error) {
+	stack, err := reftable.OpenStack(filepath.Join(gitDir, "reftable"))
+	if err != nil {
+		return nil, err
+	}
+	return &reftableStore{
+		gitDir: gitDir,
+		stack:  stack,
+		files:  &fileStore{gitDir: gitDir},
+	}, nil
+}
+
+// isFilePseudoRef returns whether the reference is stored as a file even in reftable repository.
+func isFilePseudoRef(name string) bool {
+	return name == FetchHEAD || name == MergeHEAD
+}
+
+// Read reads the reference without following symbolic references.
+func (s *reftableStore) Read(name string) (*Ref, error) {
+	if isFilePseudoRef(name) {
+		return s.files.Read(name)
+	}
+	if !IsValidName(name) {
+		return nil, ErrInvalidRefName
+	}
+	if err := s.stack.Reload(); err != nil {
+		return nil, err
+	}
+	return s.read(name)
+}
+
+// read reads the reference from already loaded stack.
+func (s *reftableStore) read(name string) (*Ref, error) {
+	record, err := s.stack.Ref(name)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrRefNotFound
+	}
+	return recordToRef(record), nil
+}
+
+func recordToRef(record *reftable.RefRecord) *Ref {
+	return &Ref{
+		Name:   record.Name,
+		Target: record.Target,
+		Digest: record.Digest,
+		Peeled: record.Peeled,
+	}
+}
+
+// Resolve reads the reference following symbolic references.
+func (s *reftableStore) Resolve(name string) (*Ref, error) {
+	return resolve(s, name)
+}
+
+// Update makes the reference point to newDigest if current digest is equal to oldDigest.
+// the semantics are the same as the Update of file store.
+func (s *reftableStore) Update(name string, newDigest, oldDigest []byte, log *LogMessage) error {
+	if isFilePseudoRef(name) {
+		return s.files.Update(name, newDigest, oldDigest, log)
+	}
+	if len(newDigest) != DigestSize || IsZeroDigest(newDigest) {
+		return ErrInvalidDigest
+	}
+	if !IsValidName(name) {
+		return ErrInvalidRefName
+	}
+	addition, err := s.stack.NewAddition()
+	if err != nil {
+		return err
+	}
+	defer addition.Rollback()
+
+	resolvedName, err := resolveName(lockedReftableStore{s}, name)
+	if err != nil {
+		return err
+	}
+	current, err := s.currentDigest(resolvedName)
+	if err != nil {
+		return err
+	}
+	if err := checkDigest(current, oldDigest); err != nil {
+		return err
+	}
+	addition.AddRef(&reftable.RefRecord{
+		Name:   resolvedName,
+		Digest: newDigest,
+	})
+	if log != nil {
+		for _, logName := range logNames(lockedReftableStore{s}, name, resolvedName) {
+			if err := s.addLog(addition, logName, current, newDigest, log); err != nil {
+				return err
+			}
+		}
+	}
+	return addition.Commit()
+}
+
+// currentDigest returns digest of the reference from already loaded stack, or nil if it doesn't exist.
+func (s *reftableStore) currentDigest(name string) ([]byte, error) {
+	ref, err := s.read(name)
+	if err == ErrRefNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ref.Digest, nil
+}
+
+// addLog adds a log record to addition if the reference should be logged.
+func (s *reftableStore) addLog(addition *reftable.Addition, name string, oldDigest, newDigest []byte, log *LogMessage) error {
+	if !isLogAllRef(name) {
+		logs, err := s.stack.Logs(name)
+		if err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+	}
+	addition.AddLog(&reftable.LogRecord{
+		Name:      name,
+		OldDigest: oldDigest,
+		NewDigest: newDigest,
+		Committer: log.Committer,
+		Message:   reflog.CleanMessage(log.Message),
+	})
+	return nil
+}
+
+// UpdateSymbolic makes the reference a symbolic reference pointing to target.
+func (s *reftableStore) UpdateSymbolic(name, target string, log *LogMessage) error {
+	if !IsValidName(name) || !IsValidName(target) {
+		return ErrInvalidRefName
+	}
+	addition, err := s.stack.NewAddition()
+	if err != nil {
+		return err
+	}
+	defer addition.Rollback()
+
+	var oldDigest []byte
+	if ref, err := resolve(lockedReftableStore{s}, name); err == nil {
+		oldDigest = ref.Digest
+	}
+	addition.AddRef(&reftable.RefRecord{
+		Name:   name,
+		Target: target,
+	})
+	if log != nil {
+		if ref, err := resolve(lockedReftableStore{s}, target); err == nil {
+			if err := s.addLog(addition, name, oldDigest, ref.Digest, log); err != nil {
+				return err
+			}
+		}
+	}
+	return addition.Commit()
+}
+
+// Detach makes the reference itself point to newDigest without following symbolic references.
+// the semantics are the same as the Detach of file store.
+func (s *reftableStore) Detach(name string, newDigest, oldDigest []byte, log *LogMessage) error {
+	if isFilePseudoRef(name) {
+		return s.files.Detach(name, newDigest, oldDigest, log)
+	}
+	if len(newDigest) != DigestSize || IsZeroDigest(newDigest) {
+		return ErrInvalidDigest
+	}
+	if !IsValidName(name) {
+		return ErrInvalidRefName
+	}
+	addition, err := s.stack.NewAddition()
+	if err != nil {
+		return err
+	}
+	defer addition.Rollback()
+
+	var current []byte
+	if ref, err := resolve(lockedReftableStore{s}, name); err == nil {
+		current = ref.Digest
+	} else if err != ErrRefNotFound {
+		return err
+	}
+	if err := checkDigest(current, oldDigest); err != nil {
+		return err
+	}
+	addition.AddRef(&reftable.RefRecord{
+		Name:   name,
+		Digest: newDigest,
+	})
+	if log != nil {
+		if err := s.addLog(addition, name, current, newDigest, log); err != nil {
+			return err
+		}
+	}
+	return addition.Commit()
+}
+
+// Delete deletes the reference and its reflog if current digest is equal to oldDigest.
+func (s *reftableStore) Delete(name string, oldDigest []byte) error {
+	if isFilePseudoRef(name) {
+		return s.files.Delete(name, oldDigest)
+	}
+	if !IsValidName(name) {
+		return ErrInvalidRefName
+	}
+	addition, err := s.stack.NewAddition()
+	if err != nil {
+		return err
+	}
+	defer addition.Rollback()
+
+	current, err := s.read(name)
+	if err != nil {
+		return err
+	}
+	if err := checkDigest(current.Digest, oldDigest); err != nil {
+		return err
+	}
+	addition.AddRef(&reftable.RefRecord{
+		Name:    name,
+		Deleted: true,
+	})
+	logs, err := s.stack.Logs(name)
+	if err != nil {
+		return err
+	}
+	for _, log := range logs {
+		addition.AddLog(&reftable.LogRecord{
+			Name:        name,
+			UpdateIndex: log.UpdateIndex,
+			Deleted:     true,
+		})
+	}
+	return addition.Commit()
+}
+
+// List lists references under refs/ whose name has prefix in name order.
+func (s *reftableStore) List(prefix string) ([]*Ref, error) {
+	if err := s.stack.Reload(); err != nil {
+		return nil, err
+	}
+	records, err := s.stack.Refs()
+	if err != nil {
+		return nil, err
+	}
+	refs := []*Ref{}
+	for _, record := range records {
+		if strings.HasPrefix(record.Name, RefsPrefix) && strings.HasPrefix(record.Name, prefix) {
+			refs = append(refs, recordToRef(record))
+		}
+	}
+	return refs, nil
+}
+
+// ReadLog reads reflog of the reference.
+func (s *reftableStore) ReadLog(name string) (*reflog.Reflog, error) {
+	if err := s.stack.Reload(); err != nil {
+		return nil, err
+	}
+	logs, err := s.stack.Logs(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(logs) == 0 {
+		return nil, reflog.ErrReflogNotFound
+	}
+	entries := make([]*reflog.Entry, len(logs))
+	for i, log := range logs {
+		// log records are sorted from newest to oldest
+		entries[len(logs)-1-i] = &reflog.Entry{
+			OldDigest: log.OldDigest,
+			NewDigest: log.NewDigest,
+			Committer: log.Committer,
+			Message:   log.Message,
+		}
+	}
+	return &reflog.Reflog{
+		Name:    name,
+		Entries: entries,
+	}, nil
+}
+
+// lockedReftableStore is a view of reftableStore which reads the stack without reloading.
+// it is used while the stack is locked by an addition.
+type lockedReftableStore struct {
+	*reftableStore
+}
+
+// Read reads the reference from already loaded stack.
+func (s lockedReftableStore) Read(name string) (*Ref, error) {
+	if isFilePseudoRef(name) {
+		return s.files.Read(name)
+	}
+	if !IsValidName(name) {
+		return nil, ErrInvalidRefName
+	}
+	return s.read(name)
+}
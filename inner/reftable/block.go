@@ -0,0 +1,152 @@
+package reftable
+
+import (
+	"encoding/binary"
+)
+
+// blockWriter is a writer of one block.
+// keys are prefix compressed against the previous key except at restart points.
+type blockWriter struct {
+	buf         []byte   // block data from the start of block (including file header in the first block)
+	headerSize  int      // size of file header in this block
+	blockSize   int      // limit of block size
+	restartStep int      // number of records between restart points
+	restarts    []uint32 // offsets of restart points from the start of block
+	lastKey     string   // key of the previous record
+	entries     int      // number of records
+}
+
+// newBlockWriter creates a blockWriter.
+// fileHeader must be file header if the block is the first block of the file, otherwise nil.
+func newBlockWriter(blockType byte, fileHeader []byte, blockSize, restartStep int) *blockWriter {
+	buf := make([]byte, 0, blockSize)
+	buf = append(buf, fileHeader...)
+	buf = append(buf, blockType, 0, 0, 0)
+	return &blockWriter{
+		buf:         buf,
+		headerSize:  len(fileHeader),
+		blockSize:   blockSize,
+		restartStep: restartStep,
+	}
+}
+
+// add appends a record to the block.
+// it returns false if the record doesn't fit in the block, except the block is empty.
+func (w *blockWriter) add(key string, valueType byte, value []byte) bool {
+	restart := w.entries%w.restartStep == 0
+	prefix := 0
+	if !restart {
+		prefix = commonPrefixLength(w.lastKey, key)
+	}
+	record := make([]byte, 0, 16+len(key)-prefix+len(value))
+	record = putVarint(record, uint64(prefix))
+	record = putVarint(record, uint64(len(key)-prefix)<<3|uint64(valueType))
+	record = append(record, key[prefix:]...)
+	record = append(record, value...)
+
+	restarts := len(w.restarts)
+	if restart {
+		restarts++
+	}
+	if w.entries > 0 && len(w.buf)+len(record)+3*restarts+2 > w.blockSize {
+		return false
+	}
+	if restart {
+		w.restarts = append(w.restarts, uint32(len(w.buf)))
+	}
+	w.buf = append(w.buf, record...)
+	w.lastKey = key
+	w.entries++
+	return true
+}
+
+// finish appends restart points and returns the block data without padding.
+func (w *blockWriter) finish() []byte {
+	for _, restart := range w.restarts {
+		var offset [3]byte
+		putUint24(offset[:], restart)
+		w.buf = append(w.buf, offset[:]...)
+	}
+	var count [2]byte
+	binary.BigEndian.PutUint16(count[:], uint16(len(w.restarts)))
+	w.buf = append(w.buf, count[:]...)
+	putUint24(w.buf[w.headerSize+1:], uint32(len(w.buf)))
+	return w.buf
+}
+
+func commonPrefixLength(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// blockRecord is a record decoded from a block before its value is interpreted.
+type blockRecord struct {
+	key       string
+	valueType byte
+	value     []byte // bytes from the value to the end of records
+}
+
+// blockReader is a reader of records in one uncompressed block.
+type blockReader struct {
+	block   []byte // block data from the start of block to block length
+	pos     int    // offset of the next record
+	end     int    // offset of the end of records
+	lastKey string
+}
+
+// newBlockReader creates a blockReader.
+// block must be uncompressed block data whose length is the block length.
+func newBlockReader(block []byte, headerSize int) (*blockReader, error) {
+	if len(block) < headerSize+blockHeaderSize+2 {
+		return nil, ErrInvalidTable
+	}
+	restartCount := int(binary.BigEndian.Uint16(block[len(block)-2:]))
+	end := len(block) - 2 - 3*restartCount
+	if end < headerSize+blockHeaderSize {
+		return nil, ErrInvalidTable
+	}
+	return &blockReader{
+		block: block,
+		pos:   headerSize + blockHeaderSize,
+		end:   end,
+	}, nil
+}
+
+// next decodes key of the next record.
+// the caller must call skip with the length of the value after interpreting it.
+// it returns nil when no more records.
+func (r *blockReader) next() (*blockRecord, error) {
+	if r.pos >= r.end {
+		return nil, nil
+	}
+	prefix, n, err := getVarint(r.block[r.pos:r.end])
+	if err != nil {
+		return nil, err
+	}
+	r.pos += n
+	suffixAndType, n, err := getVarint(r.block[r.pos:r.end])
+	if err != nil {
+		return nil, err
+	}
+	r.pos += n
+	suffixLength := int(suffixAndType >> 3)
+	if int(prefix) > len(r.lastKey) || r.pos+suffixLength > r.end {
+		return nil, ErrInvalidTable
+	}
+	key := r.lastKey[:prefix] + string(r.block[r.pos:r.pos+suffixLength])
+	r.pos += suffixLength
+	r.lastKey = key
+	return &blockRecord{
+		key:       key,
+		valueType: byte(suffixAndType & 0x7),
+		value:     r.block[r.pos:r.end],
+	}, nil
+}
+
+// skip skips value of the current record.
+func (r *blockReader) skip(n int) {
+	r.pos += n
+}
@@ -0,0 +1,14 @@
+package reftable
+
+import "errors"
+
+var (
+	ErrInvalidMagic       = errors.New("invalid reftable magic")
+	ErrNotSupportedFormat = errors.New("this reftable version or hash is not supported")
+	ErrInvalidTable       = errors.New("invalid reftable format")
+	ErrInvalidChecksum    = errors.New("reftable footer checksum mismatch")
+	ErrRecordTooLarge     = errors.New("record is larger than block size")
+	ErrUnsortedRecords    = errors.New("records are not sorted or duplicated")
+	ErrInvalidDigestSize  = errors.New("invalid SHA1 digest size")
+	ErrStackOutdated      = errors.New("reftable stack was modified by another process")
+)
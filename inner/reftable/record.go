@@ -0,0 +1,199 @@
+package reftable
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// encodeRefValue encodes value of ref record and returns its value type.
+func encodeRefValue(ref *RefRecord, minUpdateIndex uint64) (byte, []byte) {
+	value := putVarint(nil, ref.UpdateIndex-minUpdateIndex)
+	switch {
+	case ref.Deleted:
+		return refValueDeletion, value
+	case ref.Target != "":
+		value = putVarint(value, uint64(len(ref.Target)))
+		return refValueSymbolic, append(value, ref.Target...)
+	case ref.Peeled != nil:
+		value = append(value, ref.Digest...)
+		return refValuePeeled, append(value, ref.Peeled...)
+	default:
+		return refValueDigest, append(value, ref.Digest...)
+	}
+}
+
+// decodeRefValue decodes value of ref record and returns the read length.
+func decodeRefValue(record *blockRecord, minUpdateIndex uint64) (*RefRecord, int, error) {
+	buf := record.value
+	delta, pos, err := getVarint(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	ref := &RefRecord{
+		Name:        record.key,
+		UpdateIndex: minUpdateIndex + delta,
+	}
+	switch record.valueType {
+	case refValueDeletion:
+		ref.Deleted = true
+	case refValueDigest, refValuePeeled:
+		if pos+DigestSize > len(buf) {
+			return nil, 0, ErrInvalidTable
+		}
+		ref.Digest = copyBytes(buf[pos : pos+DigestSize])
+		pos += DigestSize
+		if record.valueType == refValuePeeled {
+			if pos+DigestSize > len(buf) {
+				return nil, 0, ErrInvalidTable
+			}
+			ref.Peeled = copyBytes(buf[pos : pos+DigestSize])
+			pos += DigestSize
+		}
+	case refValueSymbolic:
+		target, n, err := getString(buf[pos:])
+		if err != nil {
+			return nil, 0, err
+		}
+		ref.Target = target
+		pos += n
+	default:
+		return nil, 0, ErrInvalidTable
+	}
+	return ref, pos, nil
+}
+
+// encodeLogValue encodes value of log record and returns its value type.
+func encodeLogValue(log *LogRecord) (byte, []byte) {
+	if log.Deleted {
+		return logValueDeletion, nil
+	}
+	value := make([]byte, 0, 2*DigestSize+64+len(log.Message))
+	value = append(value, digestOrZero(log.OldDigest)...)
+	value = append(value, digestOrZero(log.NewDigest)...)
+	value = putString(value, log.Committer.Name)
+	value = putString(value, log.Committer.Email)
+	value = putVarint(value, uint64(log.Committer.When.Unix()))
+	_, offset := log.Committer.When.Zone()
+	var tz [2]byte
+	binary.BigEndian.PutUint16(tz[:], uint16(encodeZone(offset)))
+	value = append(value, tz[:]...)
+	message := log.Message
+	if message != "" && message[len(message)-1] != '\n' {
+		message += "\n"
+	}
+	return logValueUpdate, putString(value, message)
+}
+
+// encodeZone encodes the offset of the time zone in seconds to signed decimal HHMM like git, +0530 is 530.
+func encodeZone(offset int) int16 {
+	sign := 1
+	if offset < 0 {
+		sign, offset = -1, -offset
+	}
+	minutes := offset / 60
+	return int16(sign * (minutes/60*100 + minutes%60))
+}
+
+// decodeZone decodes signed decimal HHMM of the time zone to the offset in seconds.
+func decodeZone(zone int16) int {
+	sign, hhmm := 1, int(zone)
+	if hhmm < 0 {
+		sign, hhmm = -1, -hhmm
+	}
+	return sign * (hhmm/100*60 + hhmm%100) * 60
+}
+
+// decodeLogValue decodes value of log record and returns the read length.
+func decodeLogValue(record *blockRecord) (*LogRecord, int, error) {
+	name, updateIndex, err := splitLogKey(record.key)
+	if err != nil {
+		return nil, 0, err
+	}
+	log := &LogRecord{
+		Name:        name,
+		UpdateIndex: updateIndex,
+	}
+	if record.valueType == logValueDeletion {
+		log.Deleted = true
+		return log, 0, nil
+	}
+	if record.valueType != logValueUpdate {
+		return nil, 0, ErrInvalidTable
+	}
+
+	buf := record.value
+	if len(buf) < 2*DigestSize {
+		return nil, 0, ErrInvalidTable
+	}
+	log.OldDigest = copyBytes(buf[:DigestSize])
+	log.NewDigest = copyBytes(buf[DigestSize : 2*DigestSize])
+	pos := 2 * DigestSize
+
+	committerName, n, err := getString(buf[pos:])
+	if err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	email, n, err := getString(buf[pos:])
+	if err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	sec, n, err := getVarint(buf[pos:])
+	if err != nil {
+		return nil, 0, err
+	}
+	pos += n
+	if pos+2 > len(buf) {
+		return nil, 0, ErrInvalidTable
+	}
+	offset := decodeZone(int16(binary.BigEndian.Uint16(buf[pos:])))
+	pos += 2
+	message, n, err := getString(buf[pos:])
+	if err != nil {
+		return nil, 0, err
+	}
+	pos += n
+
+	log.Committer = &object.Signature{
+		Name:  committerName,
+		Email: email,
+		When:  time.Unix(int64(sec), 0).In(time.FixedZone("", offset)),
+	}
+	if len(message) > 0 && message[len(message)-1] == '\n' {
+		message = message[:len(message)-1]
+	}
+	log.Message = message
+	return log, pos, nil
+}
+
+func putString(buf []byte, s string) []byte {
+	buf = putVarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func getString(buf []byte) (string, int, error) {
+	length, n, err := getVarint(buf)
+	if err != nil {
+		return "", 0, err
+	}
+	if uint64(len(buf)-n) < length {
+		return "", 0, ErrInvalidTable
+	}
+	return string(buf[n : n+int(length)]), n + int(length), nil
+}
+
+func digestOrZero(digest []byte) []byte {
+	if digest == nil {
+		return make([]byte, DigestSize)
+	}
+	return digest
+}
+
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,152 @@
+// reftable is a package to read and write reftable files and stacks
+//
+// A reftable is a binary file which stores references and reflogs sorted by name.
+// A repository using reftable has $GIT_DIR/reftable/tables.list, which lists
+// table files from oldest to newest. Newer tables override older ones.
+//
+// The following show reftable file format overview (version 1).
+//
+//  -------------------------------------------------------------------------------
+//  |  Header  |  4byte - magic('R','E','F','T')
+//  |  24byte  |  1byte - version number(1)
+//  |          |  3byte - block size
+//  |          |  8byte - min update index
+//  |          |  8byte - max update index
+//  -------------------------------------------------------------------------------
+//  | ref block| 1byte - block type('r')
+//  |          | 3byte - block length (including file header in the first block)
+//  |          | ref records (prefix compressed keys)
+//  |          | 3byte * n - restart offsets
+//  |          | 2byte - number of restart offsets
+//  |          | padding to block size
+//  -------------------------------------------------------------------------------
+//  | log block| 1byte - block type('g')
+//  |          | 3byte - block length before compression
+//  |          | zlib compressed log records, restart offsets and number of them
+//  -------------------------------------------------------------------------------
+//  |  Footer  | the same 24byte as header
+//  |  68byte  | 8byte - ref index position
+//  |          | 8byte - obj position << 5 | obj id length
+//  |          | 8byte - obj index position
+//  |          | 8byte - log position
+//  |          | 8byte - log index position
+//  |          | 4byte - CRC-32 of footer
+//  -------------------------------------------------------------------------------
+//  * All binary numbers are in network byte order.
+//
+// Index blocks and obj blocks are optional, this package reads tables which have them
+// but doesn't write them.
+//
+// If you want to know more about reftable format, please refer to
+// https://git-scm.com/docs/reftable
+package reftable
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// constants of reftable format
+const (
+	Magic              = "REFT"
+	Version            = 1
+	DefaultBlockSize   = 4096
+	DigestSize         = 20
+	headerSize         = 24
+	headerSizeV2       = 28
+	footerSize         = 68
+	footerSizeV2       = 72
+	blockHeaderSize    = 4
+	defaultRestartStep = 16
+)
+
+// constants of block type
+const (
+	blockTypeRef   = 'r'
+	blockTypeObj   = 'o'
+	blockTypeIndex = 'i'
+	blockTypeLog   = 'g'
+)
+
+// constants of value type of ref record
+const (
+	refValueDeletion = 0x0
+	refValueDigest   = 0x1
+	refValuePeeled   = 0x2
+	refValueSymbolic = 0x3
+)
+
+// constants of value type of log record
+const (
+	logValueDeletion = 0x0
+	logValueUpdate   = 0x1
+)
+
+// RefRecord is a type representing one reference in a reftable.
+type RefRecord struct {
+	Name        string // full name of the reference
+	UpdateIndex uint64 // update index what this record was written at
+	Deleted     bool   // whether this record is a tombstone of the reference
+	Target      string // name of the reference what this symbolic reference points to
+	Digest      []byte // SHA1 digest of the object what this reference points to
+	Peeled      []byte // SHA1 digest of the object what the annotated tag points to
+}
+
+// String is implementation of fmt.Stringer interface
+func (r *RefRecord) String() string {
+	switch {
+	case r.Deleted:
+		return fmt.Sprintf("%s (deleted at %d)", r.Name, r.UpdateIndex)
+	case r.Target != "":
+		return fmt.Sprintf("%s -> %s (%d)", r.Name, r.Target, r.UpdateIndex)
+	default:
+		return fmt.Sprintf("%s %s (%d)", hex.EncodeToString(r.Digest), r.Name, r.UpdateIndex)
+	}
+}
+
+// LogRecord is a type representing one reflog entry in a reftable.
+type LogRecord struct {
+	Name        string            // full name of the reference
+	UpdateIndex uint64            // update index what this update was done at
+	Deleted     bool              // whether this record is a tombstone of the reflog entry
+	OldDigest   []byte            // SHA1 digest before the update
+	NewDigest   []byte            // SHA1 digest after the update
+	Committer   *object.Signature // identity who updated the reference and when it was updated
+	Message     string            // reason of the update
+}
+
+// String is implementation of fmt.Stringer interface
+func (l *LogRecord) String() string {
+	if l.Deleted {
+		return fmt.Sprintf("%s@%d (deleted)", l.Name, l.UpdateIndex)
+	}
+	return fmt.Sprintf("%s@%d %s %s %s\t%s", l.Name, l.UpdateIndex,
+		hex.EncodeToString(l.OldDigest), hex.EncodeToString(l.NewDigest), l.Committer, l.Message)
+}
+
+// logKey returns key of log record, it is name + '\0' + reversed update index.
+// so log records of the same reference are sorted from newest to oldest.
+func logKey(name string, updateIndex uint64) string {
+	key := make([]byte, 0, len(name)+9)
+	key = append(key, name...)
+	key = append(key, 0)
+	reversed := ^updateIndex
+	for shift := 56; shift >= 0; shift -= 8 {
+		key = append(key, byte(reversed>>uint(shift)))
+	}
+	return string(key)
+}
+
+// splitLogKey splits key of log record to name and update index.
+func splitLogKey(key string) (string, uint64, error) {
+	if len(key) < 9 || key[len(key)-9] != 0 {
+		return "", 0, ErrInvalidTable
+	}
+	reversed := uint64(0)
+	for _, b := range []byte(key[len(key)-8:]) {
+		reversed = reversed<<8 | uint64(b)
+	}
+	return key[:len(key)-9], ^reversed, nil
+}
@@ -0,0 +1,422 @@
+package reftable
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// TablesListName is name of the file which lists tables of a stack from oldest to newest
+const TablesListName = "tables.list"
+
+// maxReloadRetries is limit of retries to load a stack modified concurrently
+const maxReloadRetries = 8
+
+var (
+	randMutex  sync.Mutex
+	randSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Stack is a type representing a stack of reftables in $GIT_DIR/reftable.
+type Stack struct {
+	dir    string
+	names  []string
+	tables []*Table
+}
+
+// OpenStack opens a stack of reftables.
+// dir of parameters must be path to $GIT_DIR/reftable.
+func OpenStack(dir string) (*Stack, error) {
+	s := &Stack{dir: dir}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Stack) listPath() string {
+	return filepath.Join(s.dir, TablesListName)
+}
+
+// readTablesList reads names of tables, or empty slice if tables.list doesn't exist.
+func readTablesList(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	names := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, scanner.Err()
+}
+
+// Reload reads tables.list and tables again.
+// tables which were already read are reused.
+func (s *Stack) Reload() error {
+	loaded := map[string]*Table{}
+	for i, name := range s.names {
+		loaded[name] = s.tables[i]
+	}
+
+	var lastErr error
+	for retry := 0; retry < maxReloadRetries; retry++ {
+		names, err := readTablesList(s.listPath())
+		if err != nil {
+			return err
+		}
+		tables := make([]*Table, 0, len(names))
+		for _, name := range names {
+			if table, ok := loaded[name]; ok {
+				tables = append(tables, table)
+				continue
+			}
+			table, err := ReadTableFile(filepath.Join(s.dir, name))
+			if err != nil {
+				lastErr = err
+				break
+			}
+			tables = append(tables, table)
+		}
+		if len(tables) != len(names) {
+			// the table may be removed by compaction of another process, so read tables.list again
+			if os.IsNotExist(lastErr) {
+				continue
+			}
+			return lastErr
+		}
+		s.names = names
+		s.tables = tables
+		return nil
+	}
+	return lastErr
+}
+
+// NextUpdateIndex returns update index for the next addition.
+func (s *Stack) NextUpdateIndex() uint64 {
+	if len(s.tables) == 0 {
+		return 1
+	}
+	return s.tables[len(s.tables)-1].MaxUpdateIndex() + 1
+}
+
+// Ref returns the newest record of the reference.
+// it returns nil if the reference doesn't exist or is deleted.
+func (s *Stack) Ref(name string) (*RefRecord, error) {
+	for i := len(s.tables) - 1; i >= 0; i-- {
+		refs, err := s.tables[i].Refs()
+		if err != nil {
+			return nil, err
+		}
+		j := sort.Search(len(refs), func(j int) bool {
+			return refs[j].Name >= name
+		})
+		if j < len(refs) && refs[j].Name == name {
+			if refs[j].Deleted {
+				return nil, nil
+			}
+			return refs[j], nil
+		}
+	}
+	return nil, nil
+}
+
+// Refs returns all references of the stack sorted by name, deleted references are excluded.
+func (s *Stack) Refs() ([]*RefRecord, error) {
+	return mergeRefs(s.tables, false)
+}
+
+// Logs returns reflog entries of the reference from newest to oldest.
+func (s *Stack) Logs(name string) ([]*LogRecord, error) {
+	logs, err := mergeLogs(s.tables, false)
+	if err != nil {
+		return nil, err
+	}
+	filtered := []*LogRecord{}
+	for _, log := range logs {
+		if log.Name == name {
+			filtered = append(filtered, log)
+		}
+	}
+	return filtered, nil
+}
+
+// mergeRefs merges ref records of tables, newer tables override older ones.
+func mergeRefs(tables []*Table, keepDeletions bool) ([]*RefRecord, error) {
+	merged := map[string]*RefRecord{}
+	for _, table := range tables {
+		refs, err := table.Refs()
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			merged[ref.Name] = ref
+		}
+	}
+	refs := make([]*RefRecord, 0, len(merged))
+	for _, ref := range merged {
+		if ref.Deleted && !keepDeletions {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+	return refs, nil
+}
+
+// mergeLogs merges log records of tables, newer tables override older ones.
+func mergeLogs(tables []*Table, keepDeletions bool) ([]*LogRecord, error) {
+	merged := map[string]*LogRecord{}
+	for _, table := range tables {
+		logs, err := table.Logs()
+		if err != nil {
+			return nil, err
+		}
+		for _, log := range logs {
+			merged[logKey(log.Name, log.UpdateIndex)] = log
+		}
+	}
+	keys := make([]string, 0, len(merged))
+	for key, log := range merged {
+		if log.Deleted && !keepDeletions {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	logs := make([]*LogRecord, len(keys))
+	for i, key := range keys {
+		logs[i] = merged[key]
+	}
+	return logs, nil
+}
+
+// newTableName returns a file name of table in the same format as git.
+func newTableName(minUpdateIndex, maxUpdateIndex uint64) string {
+	randMutex.Lock()
+	suffix := randSource.Uint32()
+	randMutex.Unlock()
+	return fmt.Sprintf("0x%012x-0x%012x-%08x.ref", minUpdateIndex, maxUpdateIndex, suffix)
+}
+
+// writeTableFile writes a new table file into the stack directory and returns its name.
+func (s *Stack) writeTableFile(options *Options, refs []*RefRecord, logs []*LogRecord) (string, error) {
+	tmp, err := ioutil.TempFile(s.dir, "tmp_")
+	if err != nil {
+		return "", err
+	}
+	if err := WriteTable(tmp, options, refs, logs); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	name := newTableName(options.MinUpdateIndex, options.MaxUpdateIndex)
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return name, nil
+}
+
+// writeTablesList writes names to the lock file of tables.list and commits it.
+func writeTablesList(lock *util.LockFile, names []string) error {
+	for _, name := range names {
+		if _, err := lock.Write([]byte(name + "\n")); err != nil {
+			return err
+		}
+	}
+	return lock.Commit()
+}
+
+// Addition is a type representing a new table which is being added to a stack.
+// tables.list is locked while an Addition is alive, so other processes can't modify the stack.
+type Addition struct {
+	stack       *Stack
+	lock        *util.LockFile
+	updateIndex uint64
+	refs        []*RefRecord
+	logs        []*LogRecord
+}
+
+// NewAddition locks the stack and reloads it, so the caller can check current values
+// and add records atomically.
+func (s *Stack) NewAddition() (*Addition, error) {
+	if err := os.MkdirAll(s.dir, 0777); err != nil {
+		return nil, err
+	}
+	lock, err := util.NewLockFile(s.listPath())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Reload(); err != nil {
+		lock.Rollback()
+		return nil, err
+	}
+	return &Addition{
+		stack:       s,
+		lock:        lock,
+		updateIndex: s.NextUpdateIndex(),
+	}, nil
+}
+
+// UpdateIndex returns update index of this addition.
+func (a *Addition) UpdateIndex() uint64 {
+	return a.updateIndex
+}
+
+// AddRef adds a ref record, its update index is set to update index of this addition.
+func (a *Addition) AddRef(ref *RefRecord) {
+	ref.UpdateIndex = a.updateIndex
+	a.refs = append(a.refs, ref)
+}
+
+// AddLog adds a log record.
+// if its update index is zero, it is set to update index of this addition.
+// tombstones of existing log records must have the same update index as the records.
+func (a *Addition) AddLog(log *LogRecord) {
+	if log.UpdateIndex == 0 {
+		log.UpdateIndex = a.updateIndex
+	}
+	a.logs = append(a.logs, log)
+}
+
+// Commit writes a new table and appends it to tables.list, then compacts the stack if needed.
+func (a *Addition) Commit() error {
+	if a.lock == nil {
+		return util.ErrLockClosed
+	}
+	if len(a.refs) == 0 && len(a.logs) == 0 {
+		return a.Rollback()
+	}
+	name, err := a.stack.writeTableFile(&Options{
+		MinUpdateIndex: a.updateIndex,
+		MaxUpdateIndex: a.updateIndex,
+	}, a.refs, a.logs)
+	if err != nil {
+		a.Rollback()
+		return err
+	}
+	names := append(append([]string{}, a.stack.names...), name)
+	lock := a.lock
+	a.lock = nil
+	if err := writeTablesList(lock, names); err != nil {
+		lock.Rollback()
+		os.Remove(filepath.Join(a.stack.dir, name))
+		return err
+	}
+	if err := a.stack.Reload(); err != nil {
+		return err
+	}
+	// compaction is best effort, the addition has been already committed.
+	a.stack.AutoCompact()
+	return nil
+}
+
+// Rollback releases the lock without adding records.
+func (a *Addition) Rollback() error {
+	if a.lock == nil {
+		return nil
+	}
+	lock := a.lock
+	a.lock = nil
+	return lock.Rollback()
+}
+
+// Compact merges all tables of the stack into one table.
+func (s *Stack) Compact() error {
+	lock, err := util.NewLockFile(s.listPath())
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	if err := s.Reload(); err != nil {
+		return err
+	}
+	if len(s.tables) <= 1 {
+		return nil
+	}
+	return s.compactRange(lock, 0, len(s.tables)-1)
+}
+
+// AutoCompact merges newest tables to keep sizes of tables in a geometric sequence,
+// so the number of tables stays logarithmic to the number of additions.
+func (s *Stack) AutoCompact() error {
+	lock, err := util.NewLockFile(s.listPath())
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	if err := s.Reload(); err != nil {
+		return err
+	}
+	if len(s.tables) <= 1 {
+		return nil
+	}
+	last := len(s.tables) - 1
+	first := last
+	sum := s.tables[last].Size()
+	for first > 0 && s.tables[first-1].Size() < 2*sum {
+		first--
+		sum += s.tables[first].Size()
+	}
+	if first == last {
+		return nil
+	}
+	return s.compactRange(lock, first, last)
+}
+
+// compactRange merges tables from first to last into one table.
+// tombstones are dropped only if the merged tables include the oldest table.
+func (s *Stack) compactRange(lock *util.LockFile, first, last int) error {
+	tables := s.tables[first : last+1]
+	keepDeletions := first > 0
+	refs, err := mergeRefs(tables, keepDeletions)
+	if err != nil {
+		return err
+	}
+	logs, err := mergeLogs(tables, keepDeletions)
+	if err != nil {
+		return err
+	}
+	name, err := s.writeTableFile(&Options{
+		MinUpdateIndex: tables[0].MinUpdateIndex(),
+		MaxUpdateIndex: tables[len(tables)-1].MaxUpdateIndex(),
+	}, refs, logs)
+	if err != nil {
+		return err
+	}
+
+	names := append([]string{}, s.names[:first]...)
+	names = append(names, name)
+	names = append(names, s.names[last+1:]...)
+	if err := writeTablesList(lock, names); err != nil {
+		os.Remove(filepath.Join(s.dir, name))
+		return err
+	}
+	for _, old := range s.names[first : last+1] {
+		os.Remove(filepath.Join(s.dir, old))
+	}
+	return s.Reload()
+}
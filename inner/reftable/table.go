@@ -0,0 +1,242 @@
+package reftable
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+)
+
+// hashIDSHA1 is hash id of SHA1 in version 2 header, it is "sha1" in ASCII
+const hashIDSHA1 = 0x73686131
+
+// Table is a type representing one reftable file.
+type Table struct {
+	data           []byte
+	headerSize     int
+	blockSize      int
+	minUpdateIndex uint64
+	maxUpdateIndex uint64
+	refEnd         int // offset of the end of ref blocks
+	logStart       int // offset of the first log block, or -1 if there are no log blocks
+	logEnd         int // offset of the end of log blocks
+
+	refs []*RefRecord // cache of decoded ref records
+	logs []*LogRecord // cache of decoded log records
+}
+
+// ReadTableFile reads a reftable file.
+func ReadTableFile(path string) (*Table, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ReadTable(data)
+}
+
+// ReadTable reads a reftable from its whole contents.
+func ReadTable(data []byte) (*Table, error) {
+	if len(data) < headerSize || string(data[:4]) != Magic {
+		return nil, ErrInvalidMagic
+	}
+	t := &Table{data: data}
+	version := data[4]
+	switch version {
+	case 1:
+		t.headerSize = headerSize
+	case 2:
+		t.headerSize = headerSizeV2
+		if len(data) < headerSizeV2 || binary.BigEndian.Uint32(data[24:]) != hashIDSHA1 {
+			return nil, ErrNotSupportedFormat
+		}
+	default:
+		return nil, ErrNotSupportedFormat
+	}
+	t.blockSize = int(getUint24(data[5:]))
+	t.minUpdateIndex = binary.BigEndian.Uint64(data[8:])
+	t.maxUpdateIndex = binary.BigEndian.Uint64(data[16:])
+
+	footerLength := t.headerSize + 5*8 + 4
+	if len(data) < t.headerSize+footerLength {
+		return nil, ErrInvalidTable
+	}
+	footerStart := len(data) - footerLength
+	footer := data[footerStart:]
+	if !bytes.Equal(footer[:t.headerSize], data[:t.headerSize]) {
+		return nil, ErrInvalidTable
+	}
+	if binary.BigEndian.Uint32(footer[footerLength-4:]) != crc32.ChecksumIEEE(footer[:footerLength-4]) {
+		return nil, ErrInvalidChecksum
+	}
+	positions := footer[t.headerSize:]
+	refIndexPosition := int(binary.BigEndian.Uint64(positions[0:]))
+	objPosition := int(binary.BigEndian.Uint64(positions[8:]) >> 5)
+	objIndexPosition := int(binary.BigEndian.Uint64(positions[16:]))
+	logPosition := int(binary.BigEndian.Uint64(positions[24:]))
+	logIndexPosition := int(binary.BigEndian.Uint64(positions[32:]))
+
+	// sections are ordered as ref blocks, ref index, obj blocks, obj index, log blocks and log index.
+	t.refEnd = footerStart
+	for _, position := range []int{refIndexPosition, objPosition, objIndexPosition, logPosition} {
+		if position > 0 && position < t.refEnd {
+			t.refEnd = position
+		}
+	}
+	t.logStart = -1
+	if logPosition > 0 || (footerStart > t.headerSize && data[t.headerSize] == blockTypeLog) {
+		t.logStart = logPosition
+	}
+	if footerStart <= t.headerSize || data[t.headerSize] != blockTypeRef {
+		t.refEnd = 0
+	}
+	t.logEnd = footerStart
+	if logIndexPosition > 0 {
+		t.logEnd = logIndexPosition
+	}
+	if t.refEnd > footerStart || t.logEnd > footerStart || t.logStart > t.logEnd {
+		return nil, ErrInvalidTable
+	}
+	return t, nil
+}
+
+// MinUpdateIndex returns the smallest update index of this table
+func (t *Table) MinUpdateIndex() uint64 {
+	return t.minUpdateIndex
+}
+
+// MaxUpdateIndex returns the largest update index of this table
+func (t *Table) MaxUpdateIndex() uint64 {
+	return t.maxUpdateIndex
+}
+
+// Size returns byte size of this table
+func (t *Table) Size() int {
+	return len(t.data)
+}
+
+// blockHeaderOffset returns size of file header included in the block at offset
+func (t *Table) blockHeaderOffset(offset int) int {
+	if offset == 0 {
+		return t.headerSize
+	}
+	return 0
+}
+
+// Refs returns all ref records in this table including deletions, sorted by name.
+func (t *Table) Refs() ([]*RefRecord, error) {
+	if t.refs != nil {
+		return t.refs, nil
+	}
+	refs := []*RefRecord{}
+	offset := 0
+	for offset < t.refEnd {
+		headerOffset := t.blockHeaderOffset(offset)
+		if offset+headerOffset+blockHeaderSize > t.refEnd {
+			return nil, ErrInvalidTable
+		}
+		if t.data[offset+headerOffset] != blockTypeRef {
+			break
+		}
+		blockLength := int(getUint24(t.data[offset+headerOffset+1:]))
+		if offset+blockLength > t.refEnd {
+			return nil, ErrInvalidTable
+		}
+		br, err := newBlockReader(t.data[offset:offset+blockLength], headerOffset)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			record, err := br.next()
+			if err != nil {
+				return nil, err
+			}
+			if record == nil {
+				break
+			}
+			ref, n, err := decodeRefValue(record, t.minUpdateIndex)
+			if err != nil {
+				return nil, err
+			}
+			br.skip(n)
+			refs = append(refs, ref)
+		}
+
+		// blocks are padded to block size unless the table is written unaligned
+		next := offset + t.blockSize
+		if t.blockSize == 0 || next > t.refEnd ||
+			(offset+blockLength < t.refEnd && t.data[offset+blockLength] != 0) {
+			next = offset + blockLength
+		}
+		offset = next
+	}
+	t.refs = refs
+	return refs, nil
+}
+
+// Logs returns all log records in this table including deletions,
+// sorted by name and from newest to oldest.
+func (t *Table) Logs() ([]*LogRecord, error) {
+	if t.logs != nil {
+		return t.logs, nil
+	}
+	logs := []*LogRecord{}
+	if t.logStart < 0 {
+		t.logs = logs
+		return logs, nil
+	}
+	offset := t.logStart
+	for offset < t.logEnd {
+		headerOffset := t.blockHeaderOffset(offset)
+		blockStart := offset + headerOffset + blockHeaderSize
+		if blockStart > t.logEnd {
+			return nil, ErrInvalidTable
+		}
+		if t.data[offset+headerOffset] != blockTypeLog {
+			break
+		}
+		blockLength := int(getUint24(t.data[offset+headerOffset+1:]))
+		if blockLength < headerOffset+blockHeaderSize {
+			return nil, ErrInvalidTable
+		}
+
+		compressed := bytes.NewReader(t.data[blockStart:t.logEnd])
+		zr, err := zlib.NewReader(compressed)
+		if err != nil {
+			return nil, err
+		}
+		inflated, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, err
+		}
+		if len(inflated) != blockLength-headerOffset-blockHeaderSize {
+			return nil, ErrInvalidTable
+		}
+		block := make([]byte, 0, blockLength)
+		block = append(block, t.data[offset:blockStart]...)
+		block = append(block, inflated...)
+
+		br, err := newBlockReader(block, headerOffset)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			record, err := br.next()
+			if err != nil {
+				return nil, err
+			}
+			if record == nil {
+				break
+			}
+			log, n, err := decodeLogValue(record)
+			if err != nil {
+				return nil, err
+			}
+			br.skip(n)
+			logs = append(logs, log)
+		}
+		offset = t.logEnd - compressed.Len()
+	}
+	t.logs = logs
+	return logs, nil
+}
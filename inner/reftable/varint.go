@@ -0,0 +1,44 @@
+package reftable
+
+// putVarint appends the variable length integer used in reftable.
+// it is the same encoding as offset of OFS_DELTA in pack files:
+// each byte has 7 bits of value and MSB means continuation,
+// and 1 is subtracted from the upper part to make the encoding unique.
+func putVarint(buf []byte, value uint64) []byte {
+	var tmp [10]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(value & 0x7F)
+	for value >>= 7; value != 0; value >>= 7 {
+		value--
+		i--
+		tmp[i] = 0x80 | byte(value&0x7F)
+	}
+	return append(buf, tmp[i:]...)
+}
+
+// getVarint reads the variable length integer and returns the value and read length.
+func getVarint(buf []byte) (uint64, int, error) {
+	if len(buf) == 0 {
+		return 0, 0, ErrInvalidTable
+	}
+	value := uint64(buf[0] & 0x7F)
+	n := 1
+	for buf[n-1]&0x80 != 0 {
+		if n >= len(buf) || n >= 10 {
+			return 0, 0, ErrInvalidTable
+		}
+		value = ((value + 1) << 7) | uint64(buf[n]&0x7F)
+		n++
+	}
+	return value, n, nil
+}
+
+func putUint24(buf []byte, value uint32) {
+	buf[0] = byte(value >> 16)
+	buf[1] = byte(value >> 8)
+	buf[2] = byte(value)
+}
+
+func getUint24(buf []byte) uint32 {
+	return uint32(buf[0])<<16 | uint32(buf[1])<<8 | uint32(buf[2])
+}
@@ -0,0 +1,185 @@
+package reftable
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// Options is a type representing options to write a reftable.
+type Options struct {
+	BlockSize       int    // size of ref blocks, DefaultBlockSize if zero
+	RestartInterval int    // number of records between restart points, 16 if zero
+	MinUpdateIndex  uint64 // the smallest update index of ref records in the table
+	MaxUpdateIndex  uint64 // the largest update index of ref records in the table
+}
+
+// WriteTable writes a reftable which contains refs and logs.
+// records are sorted by this function, but duplicated keys are not allowed.
+func WriteTable(w io.Writer, options *Options, refs []*RefRecord, logs []*LogRecord) error {
+	blockSize := options.BlockSize
+	if blockSize == 0 {
+		blockSize = DefaultBlockSize
+	}
+	if blockSize < headerSize+footerSize || blockSize >= 1<<24 {
+		return ErrInvalidTable
+	}
+	restartStep := options.RestartInterval
+	if restartStep <= 0 {
+		restartStep = defaultRestartStep
+	}
+
+	refs, err := sortRefs(refs, options)
+	if err != nil {
+		return err
+	}
+	logs, err = sortLogs(logs)
+	if err != nil {
+		return err
+	}
+
+	header := encodeHeader(uint32(blockSize), options.MinUpdateIndex, options.MaxUpdateIndex)
+	buf := &bytes.Buffer{}
+	// pendingHeader is the file header what will be written as a part of the first block
+	pendingHeader := header
+
+	if len(refs) > 0 {
+		flush := func(bw *blockWriter) error {
+			block := bw.finish()
+			if len(block) > blockSize {
+				return ErrRecordTooLarge
+			}
+			buf.Write(block)
+			buf.Write(make([]byte, blockSize-len(block)))
+			return nil
+		}
+		bw := newBlockWriter(blockTypeRef, pendingHeader, blockSize, restartStep)
+		pendingHeader = nil
+		for _, ref := range refs {
+			valueType, value := encodeRefValue(ref, options.MinUpdateIndex)
+			if bw.add(ref.Name, valueType, value) {
+				continue
+			}
+			if err := flush(bw); err != nil {
+				return err
+			}
+			bw = newBlockWriter(blockTypeRef, nil, blockSize, restartStep)
+			bw.add(ref.Name, valueType, value)
+		}
+		if err := flush(bw); err != nil {
+			return err
+		}
+	}
+
+	logPosition := uint64(0)
+	if len(logs) > 0 {
+		logPosition = uint64(buf.Len())
+		flush := func(bw *blockWriter) error {
+			block := bw.finish()
+			blockStart := bw.headerSize + blockHeaderSize
+			buf.Write(block[:blockStart])
+			zw := zlib.NewWriter(buf)
+			if _, err := zw.Write(block[blockStart:]); err != nil {
+				return err
+			}
+			return zw.Close()
+		}
+		bw := newBlockWriter(blockTypeLog, pendingHeader, blockSize, restartStep)
+		pendingHeader = nil
+		for _, log := range logs {
+			if log.Committer == nil && !log.Deleted {
+				return ErrInvalidTable
+			}
+			valueType, value := encodeLogValue(log)
+			key := logKey(log.Name, log.UpdateIndex)
+			if bw.add(key, valueType, value) {
+				continue
+			}
+			if err := flush(bw); err != nil {
+				return err
+			}
+			bw = newBlockWriter(blockTypeLog, nil, blockSize, restartStep)
+			bw.add(key, valueType, value)
+		}
+		if err := flush(bw); err != nil {
+			return err
+		}
+	}
+
+	// a table without any records consists of only header and footer
+	buf.Write(pendingHeader)
+
+	footer := make([]byte, 0, footerSize)
+	footer = append(footer, header...)
+	var positions [5 * 8]byte
+	// ref index, obj and obj index are not written
+	binary.BigEndian.PutUint64(positions[24:], logPosition)
+	footer = append(footer, positions[:]...)
+	var checksum [4]byte
+	binary.BigEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(footer))
+	footer = append(footer, checksum[:]...)
+	buf.Write(footer)
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// encodeHeader encodes file header of version 1
+func encodeHeader(blockSize uint32, minUpdateIndex, maxUpdateIndex uint64) []byte {
+	header := make([]byte, headerSize)
+	copy(header, Magic)
+	header[4] = Version
+	putUint24(header[5:], blockSize)
+	binary.BigEndian.PutUint64(header[8:], minUpdateIndex)
+	binary.BigEndian.PutUint64(header[16:], maxUpdateIndex)
+	return header
+}
+
+// sortRefs returns ref records sorted by name and validates them.
+func sortRefs(refs []*RefRecord, options *Options) ([]*RefRecord, error) {
+	sorted := make([]*RefRecord, len(refs))
+	copy(sorted, refs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	for i, ref := range sorted {
+		if i > 0 && sorted[i-1].Name == ref.Name {
+			return nil, ErrUnsortedRecords
+		}
+		if ref.UpdateIndex < options.MinUpdateIndex || options.MaxUpdateIndex < ref.UpdateIndex {
+			return nil, ErrInvalidTable
+		}
+		if ref.Deleted || ref.Target != "" {
+			continue
+		}
+		if len(ref.Digest) != DigestSize || (ref.Peeled != nil && len(ref.Peeled) != DigestSize) {
+			return nil, ErrInvalidDigestSize
+		}
+	}
+	return sorted, nil
+}
+
+// sortLogs returns log records sorted by key, it is name and descending update index.
+func sortLogs(logs []*LogRecord) ([]*LogRecord, error) {
+	sorted := make([]*LogRecord, len(logs))
+	copy(sorted, logs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return logKey(sorted[i].Name, sorted[i].UpdateIndex) < logKey(sorted[j].Name, sorted[j].UpdateIndex)
+	})
+	for i, log := range sorted {
+		if i > 0 && sorted[i-1].Name == log.Name && sorted[i-1].UpdateIndex == log.UpdateIndex {
+			return nil, ErrUnsortedRecords
+		}
+		if log.Deleted {
+			continue
+		}
+		if (log.OldDigest != nil && len(log.OldDigest) != DigestSize) ||
+			(log.NewDigest != nil && len(log.NewDigest) != DigestSize) {
+			return nil, ErrInvalidDigestSize
+		}
+	}
+	return sorted, nil
+}
@@ -0,0 +1,197 @@
+package rerere
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// flags of a variant in rr-cache
+const (
+	hasPreimage = 1 << iota
+	hasPostimage
+)
+
+// both images are needed to replay a resolution
+const hasResolution = hasPreimage | hasPostimage
+
+// conflictID is a type representing a conflict ID and its variant.
+// different conflicts may have the same ID, they are recorded as different variants.
+type conflictID struct {
+	hex     string
+	variant int // -1 means it's not known yet
+}
+
+// String returns the ID written in MERGE_RR, the variant is omitted if it's 0.
+func (id *conflictID) String() string {
+	if id.variant <= 0 {
+		return id.hex
+	}
+	return id.hex + "." + strconv.Itoa(id.variant)
+}
+
+// path returns the path of the file of the variant in rr-cache, the name is "preimage", "postimage" or "".
+func (r *Rerere) path(id *conflictID, name string) string {
+	dir := filepath.Join(r.gitDir, "rr-cache", id.hex)
+	if name == "" {
+		return dir
+	}
+	if id.variant > 0 {
+		name += "." + strconv.Itoa(id.variant)
+	}
+	return filepath.Join(dir, name)
+}
+
+// variants returns flags of variants of the conflict ID, they are scanned from rr-cache only once.
+func (r *Rerere) variants(hex string) ([]int, error) {
+	if status, ok := r.status[hex]; ok {
+		return status, nil
+	}
+	status := []int{}
+	infos, err := ioutil.ReadDir(filepath.Join(r.gitDir, "rr-cache", hex))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, info := range infos {
+		flag, variant := 0, 0
+		name := info.Name()
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			v, err := strconv.Atoi(name[i+1:])
+			if err != nil || v < 0 {
+				continue
+			}
+			name, variant = name[:i], v
+		}
+		switch name {
+		case "preimage":
+			flag = hasPreimage
+		case "postimage":
+			flag = hasPostimage
+		default:
+			continue
+		}
+		for len(status) <= variant {
+			status = append(status, 0)
+		}
+		status[variant] |= flag
+	}
+	r.status[hex] = status
+	return status, nil
+}
+
+// setVariant sets flags of the variant.
+func (r *Rerere) setVariant(id *conflictID, flags int) error {
+	status, err := r.variants(id.hex)
+	if err != nil {
+		return err
+	}
+	for len(status) <= id.variant {
+		status = append(status, 0)
+	}
+	status[id.variant] = flags
+	r.status[id.hex] = status
+	return nil
+}
+
+// variantFlags returns flags of the variant of the conflict ID.
+func (r *Rerere) variantFlags(id *conflictID) (int, error) {
+	status, err := r.variants(id.hex)
+	if err != nil || id.variant < 0 || len(status) <= id.variant {
+		return 0, err
+	}
+	return status[id.variant], nil
+}
+
+// assignVariant assigns the first unused variant if the variant isn't known yet.
+func (r *Rerere) assignVariant(id *conflictID) error {
+	if id.variant >= 0 {
+		return nil
+	}
+	status, err := r.variants(id.hex)
+	if err != nil {
+		return err
+	}
+	id.variant = 0
+	for id.variant < len(status) && status[id.variant] != 0 {
+		id.variant++
+	}
+	return nil
+}
+
+// removeVariant removes images of the variant.
+func (r *Rerere) removeVariant(id *conflictID) error {
+	if id.variant < 0 {
+		return nil
+	}
+	for _, name := range []string{"preimage", "postimage"} {
+		if err := os.Remove(r.path(id, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return r.setVariant(id, 0)
+}
+
+// readMergeRR reads conflict IDs of paths in MERGE_RR.
+//
+//  <conflict ID>[.<variant>] TAB <path> NUL
+func (r *Rerere) readMergeRR() (map[string]*conflictID, error) {
+	data, err := ioutil.ReadFile(filepath.Join(r.gitDir, "MERGE_RR"))
+	if os.IsNotExist(err) {
+		return map[string]*conflictID{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rr := map[string]*conflictID{}
+	for _, record := range bytes.Split(data, []byte{0}) {
+		if len(record) == 0 {
+			continue
+		}
+		tab := bytes.IndexByte(record, '\t')
+		if tab < 0 {
+			return nil, ErrInvalidMergeRR
+		}
+		id := &conflictID{hex: string(record[:tab])}
+		if i := strings.IndexByte(id.hex, '.'); i >= 0 {
+			if id.variant, err = strconv.Atoi(id.hex[i+1:]); err != nil {
+				return nil, ErrInvalidMergeRR
+			}
+			id.hex = id.hex[:i]
+		}
+		if len(id.hex) != 40 {
+			return nil, ErrInvalidMergeRR
+		}
+		rr[string(record[tab+1:])] = id
+	}
+	return rr, nil
+}
+
+// writeMergeRR writes conflict IDs of paths to MERGE_RR through the lock.
+func writeMergeRR(lock *util.LockFile, rr map[string]*conflictID) error {
+	buf := &bytes.Buffer{}
+	for _, name := range sortedPaths(rr) {
+		buf.WriteString(rr[name].String())
+		buf.WriteByte('\t')
+		buf.WriteString(name)
+		buf.WriteByte(0)
+	}
+	if _, err := lock.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return lock.Commit()
+}
+
+func sortedPaths(rr map[string]*conflictID) []string {
+	names := make([]string, 0, len(rr))
+	for name := range rr {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,10 @@
+package rerere
+
+import "errors"
+
+var (
+	ErrNoConflict           = errors.New("content has no conflict hunks")
+	ErrBrokenConflict       = errors.New("conflict markers are broken")
+	ErrInvalidMergeRR       = errors.New("MERGE_RR is broken")
+	ErrNoRecordedResolution = errors.New("no remembered resolution for the path")
+)
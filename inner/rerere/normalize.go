@@ -0,0 +1,164 @@
+package rerere
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"hash"
+)
+
+// Normalize normalizes conflict hunks of the content, and returns the normalized content and the conflict ID.
+// the ID is hex digits of SHA1 digest of both sides of all the conflict hunks.
+// it returns ErrNoConflict if the content has no conflict hunks, and ErrBrokenConflict if conflict markers are broken.
+// markerSize is the length of conflict markers, 0 means the default.
+func Normalize(content []byte, markerSize int) ([]byte, string, error) {
+	if markerSize <= 0 {
+		markerSize = defaultMarkerSize
+	}
+	normalized, digest, conflicts := normalize(content, markerSize)
+	switch {
+	case conflicts < 0:
+		return nil, "", ErrBrokenConflict
+	case conflicts == 0:
+		return nil, "", ErrNoConflict
+	}
+	return normalized, hex.EncodeToString(digest), nil
+}
+
+// normalize normalizes conflict hunks, and returns the normalized content, SHA1 digest of conflict hunks and
+// whether it has conflict hunks, 1 means it has, 0 means it doesn't and -1 means conflict markers are broken.
+// in a normalized conflict hunk, the base of diff3 style is removed, labels are removed from markers,
+// and both sides are sorted so that the hunk is the same whichever side is ours.
+func normalize(content []byte, markerSize int) ([]byte, []byte, int) {
+	lines := &lineReader{content: content}
+	out := &bytes.Buffer{}
+	h := sha1.New()
+	conflicts := 0
+	for {
+		line, ok := lines.next()
+		if !ok {
+			break
+		}
+		if !isMarker(line, '<', markerSize) {
+			out.Write(line)
+			continue
+		}
+		hunk := &bytes.Buffer{}
+		if conflicts = normalizeHunk(hunk, lines, markerSize, h); conflicts < 0 {
+			break
+		}
+		out.Write(hunk.Bytes())
+	}
+	return out.Bytes(), h.Sum(nil), conflicts
+}
+
+// the part of a conflict hunk being read
+const (
+	sideOne = iota
+	sideOriginal
+	sideTwo
+)
+
+// normalizeHunk normalizes a conflict hunk after "<<<<<<<" marker, nested conflict hunks are normalized recursively.
+// both sides are added to h if it's not nil.
+func normalizeHunk(out *bytes.Buffer, lines *lineReader, markerSize int, h hash.Hash) int {
+	part := sideOne
+	one, two := &bytes.Buffer{}, &bytes.Buffer{}
+	for {
+		line, ok := lines.next()
+		if !ok {
+			return -1
+		}
+		switch {
+		case isMarker(line, '<', markerSize):
+			nested := &bytes.Buffer{}
+			if normalizeHunk(nested, lines, markerSize, nil) < 0 {
+				return -1
+			}
+			if part == sideOne {
+				one.Write(nested.Bytes())
+			} else {
+				two.Write(nested.Bytes())
+			}
+		case isMarker(line, '|', markerSize):
+			if part != sideOne {
+				return -1
+			}
+			part = sideOriginal
+		case isMarker(line, '=', markerSize):
+			if part != sideOne && part != sideOriginal {
+				return -1
+			}
+			part = sideTwo
+		case isMarker(line, '>', markerSize):
+			if part != sideTwo {
+				return -1
+			}
+			a, b := one.Bytes(), two.Bytes()
+			if bytes.Compare(a, b) > 0 {
+				a, b = b, a
+			}
+			writeMarker(out, '<', markerSize)
+			out.Write(a)
+			writeMarker(out, '=', markerSize)
+			out.Write(b)
+			writeMarker(out, '>', markerSize)
+			if h != nil {
+				h.Write(a)
+				h.Write([]byte{0})
+				h.Write(b)
+				h.Write([]byte{0})
+			}
+			return 1
+		case part == sideOne:
+			one.Write(line)
+		case part == sideTwo:
+			two.Write(line)
+		}
+	}
+}
+
+// isMarker returns whether the line is a conflict marker of the character.
+// "<<<<<<<" and ">>>>>>>" must be followed by a space and a label, the others may be followed by any white space.
+func isMarker(line []byte, c byte, markerSize int) bool {
+	if len(line) <= markerSize {
+		return false
+	}
+	for _, b := range line[:markerSize] {
+		if b != c {
+			return false
+		}
+	}
+	next := line[markerSize]
+	if (c == '<' || c == '>') && next != ' ' {
+		return false
+	}
+	switch next {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+func writeMarker(out *bytes.Buffer, c byte, markerSize int) {
+	out.Write(bytes.Repeat([]byte{c}, markerSize))
+	out.WriteByte('\n')
+}
+
+// lineReader is a type reading lines of the content, each line includes the trailing newline.
+type lineReader struct {
+	content []byte
+}
+
+func (r *lineReader) next() ([]byte, bool) {
+	if len(r.content) == 0 {
+		return nil, false
+	}
+	end := bytes.IndexByte(r.content, '\n') + 1
+	if end == 0 {
+		end = len(r.content)
+	}
+	line := r.content[:end]
+	r.content = r.content[end:]
+	return line, true
+}
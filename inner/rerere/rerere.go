@@ -0,0 +1,546 @@
+// rerere is a package to reuse recorded resolutions of conflicts like $ git rerere
+//
+// When a merge leaves a file with conflict hunks, the hunks are normalized and the file is recorded as a preimage.
+// After the conflict is resolved, the resolved file is recorded as a postimage.
+// When the same conflict appears again, the change from the preimage to the postimage is merged into the file.
+// Records are stored in the same format as git, so they are shared with git.
+//
+//  .git/MERGE_RR                          - conflict IDs of the paths conflicting now
+//  .git/rr-cache/<conflict ID>/preimage   - the normalized conflicting file
+//  .git/rr-cache/<conflict ID>/postimage  - the resolved file
+//
+// A conflict ID is SHA1 digest of both sides of the normalized conflict hunks. different conflicts having the same
+// ID are recorded as variants, whose files have the suffix of the variant number like "preimage.1".
+//
+// Rerere is enabled by rerere.enabled config, or by existence of .git/rr-cache if it's not set.
+//
+// If you want to know more about rerere, please refer to
+// https://git-scm.com/docs/git-rerere
+package rerere
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/shumon84/mogit/inner/attributes"
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/conflict"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/merge"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/pathspec"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// defaultMarkerSize is the length of conflict markers without conflict-marker-size attribute.
+const defaultMarkerSize = 7
+
+// Rerere is a type to record and replay resolutions of conflicts in the working tree.
+type Rerere struct {
+	gitDir     string
+	workDir    string
+	config     *config.Config
+	attributes *attributes.Matcher
+	files      *merge.FileMerger
+	status     map[string][]int // flags of variants of conflict IDs
+}
+
+// Result is a type representing what Rerere did.
+type Result struct {
+	Resolved []string // paths resolved by recorded resolutions
+	Recorded []string // paths whose resolutions are recorded
+	Messages []string // messages like "Resolved '<path>' using previous resolution."
+}
+
+// NewRerere creates a Rerere of the repository.
+// gitDir of parameters must be path to .git directory.
+func NewRerere(gitDir string) (*Rerere, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := attributes.NewMatcher(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := merge.NewFileMerger(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Rerere{
+		gitDir:     gitDir,
+		workDir:    filepath.Dir(gitDir),
+		config:     cfg,
+		attributes: matcher,
+		files:      files,
+		status:     map[string][]int{},
+	}, nil
+}
+
+// OpenRerere creates a Rerere of current repository.
+func OpenRerere() (*Rerere, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewRerere(gitDir)
+}
+
+// Enabled returns whether rerere is enabled by rerere.enabled config or existence of .git/rr-cache.
+// .git/rr-cache is created if rerere.enabled config is true.
+func (r *Rerere) Enabled() (bool, error) {
+	dir := filepath.Join(r.gitDir, "rr-cache")
+	info, err := os.Stat(dir)
+	exists := err == nil && info.IsDir()
+	if _, ok := r.config.Get("rerere.enabled"); !ok {
+		return exists, nil
+	}
+	enabled, err := r.config.Bool("rerere.enabled", false)
+	if err != nil || !enabled {
+		return false, err
+	}
+	if !exists {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Run records preimages of new conflicts and postimages of resolved conflicts,
+// and replays recorded resolutions to conflicting files like $ git rerere.
+// it's run after merging writes conflicting files to the working tree, and before committing the resolution.
+// files resolved by recorded resolutions are added to the index if rerere.autoUpdate config is true.
+// nothing is done if rerere isn't enabled.
+func (r *Rerere) Run() (*Result, error) {
+	result := &Result{Resolved: []string{}, Recorded: []string{}, Messages: []string{}}
+	if enabled, err := r.Enabled(); err != nil || !enabled {
+		return result, err
+	}
+	autoUpdate, err := r.config.Bool("rerere.autoupdate", false)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := util.NewLockFile(filepath.Join(r.gitDir, "MERGE_RR"))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Rollback()
+	rr, err := r.readMergeRR()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := index.ReadIndexFrom(r.gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// conflict IDs of conflicting files are computed again, they may be changed by hand
+	for _, name := range conflictingPaths(idx) {
+		content, err := r.readFile(name)
+		conflicts := -1
+		var digest []byte
+		if err == nil {
+			_, digest, conflicts = normalize(content, r.markerSize(name))
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if id, ok := rr[name]; ok && conflicts != 0 {
+			if err := r.removeVariant(id); err != nil {
+				return nil, err
+			}
+			delete(rr, name)
+		}
+		if conflicts < 1 {
+			continue
+		}
+		id := &conflictID{hex: hex.EncodeToString(digest), variant: -1}
+		rr[name] = id
+		if err := os.MkdirAll(r.path(id, ""), 0777); err != nil {
+			return nil, err
+		}
+	}
+
+	update := []string{}
+	for _, name := range sortedPaths(rr) {
+		done, resolved, err := r.runPath(name, rr[name], result)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			delete(rr, name)
+		}
+		if resolved && autoUpdate {
+			update = append(update, name)
+		}
+	}
+	if len(update) > 0 {
+		if err := r.updateIndex(update); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeMergeRR(lock, rr); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// runPath records the postimage if the path is resolved, replays a recorded resolution if any applies,
+// or records the preimage. it returns whether the path is done, and whether it's resolved by a resolution.
+func (r *Rerere) runPath(name string, id *conflictID, result *Result) (bool, bool, error) {
+	content, err := r.readFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return false, false, err
+	}
+	readable := err == nil
+
+	// the path has been resolved by hand
+	if id.variant >= 0 && readable {
+		if _, _, conflicts := normalize(content, r.markerSize(name)); conflicts == 0 {
+			if err := ioutil.WriteFile(r.path(id, "postimage"), content, 0666); err != nil {
+				return false, false, err
+			}
+			flags, err := r.variantFlags(id)
+			if err != nil {
+				return false, false, err
+			}
+			if err := r.setVariant(id, flags|hasPostimage); err != nil {
+				return false, false, err
+			}
+			result.Recorded = append(result.Recorded, name)
+			result.Messages = append(result.Messages, "Recorded resolution for '"+name+"'.")
+			return true, false, nil
+		}
+	}
+
+	// replay a recorded resolution applying cleanly
+	status, err := r.variants(id.hex)
+	if err != nil {
+		return false, false, err
+	}
+	for variant := range status {
+		if status[variant]&hasResolution != hasResolution || !readable {
+			continue
+		}
+		v := &conflictID{hex: id.hex, variant: variant}
+		merged, ok, err := r.replay(name, v, content)
+		if err != nil {
+			return false, false, err
+		}
+		if !ok {
+			continue
+		}
+		if err := r.writeFile(name, merged); err != nil {
+			return false, false, err
+		}
+		// a different variant applying cleanly makes our own variant needless
+		if id.variant >= 0 && id.variant != variant {
+			if err := r.removeVariant(id); err != nil {
+				return false, false, err
+			}
+		}
+		result.Resolved = append(result.Resolved, name)
+		result.Messages = append(result.Messages, "Resolved '"+name+"' using previous resolution.")
+		return true, true, nil
+	}
+
+	// no resolution applies, the conflict is recorded as a new variant
+	if err := r.assignVariant(id); err != nil {
+		return false, false, err
+	}
+	if readable {
+		preimage, _, _ := normalize(content, r.markerSize(name))
+		if err := ioutil.WriteFile(r.path(id, "preimage"), preimage, 0666); err != nil {
+			return false, false, err
+		}
+	}
+	if err := os.Remove(r.path(id, "postimage")); err != nil && !os.IsNotExist(err) {
+		return false, false, err
+	}
+	if err := r.setVariant(id, hasPreimage); err != nil {
+		return false, false, err
+	}
+	result.Messages = append(result.Messages, "Recorded preimage for '"+name+"'")
+	return false, false, nil
+}
+
+// replay merges the change from the preimage to the postimage of the variant into the conflicting content.
+// it returns whether the resolution applies cleanly.
+func (r *Rerere) replay(name string, id *conflictID, content []byte) ([]byte, bool, error) {
+	current, _, conflicts := normalize(content, r.markerSize(name))
+	if conflicts < 0 {
+		return nil, false, nil
+	}
+	merged, ok, err := r.merge(name, id, current)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	// the postimage is touched to tell it's used recently
+	now := time.Now()
+	if err := os.Chtimes(r.path(id, "postimage"), now, now); err != nil {
+		return nil, false, err
+	}
+	return merged, true, nil
+}
+
+// merge merges the change from the preimage to the postimage of the variant into the normalized content.
+func (r *Rerere) merge(name string, id *conflictID, current []byte) ([]byte, bool, error) {
+	preimage, err := ioutil.ReadFile(r.path(id, "preimage"))
+	if err != nil {
+		return nil, false, nil
+	}
+	postimage, err := ioutil.ReadFile(r.path(id, "postimage"))
+	if err != nil {
+		return nil, false, nil
+	}
+	merged, conflicts, err := r.files.Merge(name, preimage, current, postimage, nil)
+	if err != nil || conflicts > 0 {
+		return nil, false, err
+	}
+	return merged, true, nil
+}
+
+// updateIndex adds the resolved files to the index, stages of them are recorded in REUC extension.
+func (r *Rerere) updateIndex(names []string) error {
+	resolver, err := conflict.NewResolver(r.gitDir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		c, err := resolver.Conflict(name)
+		if err == conflict.ErrNotConflicting {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		content, err := r.readFile(name)
+		if err != nil {
+			return err
+		}
+		if err := resolver.ResolveContent(c, name, content); err != nil {
+			return err
+		}
+	}
+	return resolver.Write()
+}
+
+// Status returns paths whose conflicts are recorded and not resolved yet like $ git rerere status.
+func (r *Rerere) Status() ([]string, error) {
+	rr, err := r.readMergeRR()
+	if err != nil {
+		return nil, err
+	}
+	return sortedPaths(rr), nil
+}
+
+// Clear forgets conflicts which are not resolved yet like $ git rerere clear.
+// it's used when a merge is aborted, preimages without postimages are removed.
+func (r *Rerere) Clear() error {
+	lock, err := util.NewLockFile(filepath.Join(r.gitDir, "MERGE_RR"))
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	rr, err := r.readMergeRR()
+	if err != nil {
+		return err
+	}
+	for _, id := range rr {
+		flags, err := r.variantFlags(id)
+		if err != nil {
+			return err
+		}
+		if flags&hasResolution == hasResolution {
+			continue
+		}
+		if err := r.removeVariant(id); err != nil {
+			return err
+		}
+		// the directory remains if it has other variants
+		os.Remove(r.path(id, ""))
+	}
+	if err := os.Remove(filepath.Join(r.gitDir, "MERGE_RR")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Forget forgets recorded resolutions of conflicts of the paths like $ git rerere forget <pathspec>.
+// conflicts are recreated from the index, resolved paths are recreated from REUC extension.
+// the preimage is recorded again, so the next Run records a new resolution.
+func (r *Rerere) Forget(spec *pathspec.Pathspec) (*Result, error) {
+	result := &Result{Resolved: []string{}, Recorded: []string{}, Messages: []string{}}
+	lock, err := util.NewLockFile(filepath.Join(r.gitDir, "MERGE_RR"))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Rollback()
+	rr, err := r.readMergeRR()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := index.ReadIndexFrom(r.gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// the paths may have been resolved incorrectly, conflicts are recreated in memory
+	resolver, err := conflict.NewResolverFromIndex(r.gitDir, idx)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range idx.ResolveUndo() {
+		if spec.Match(record.Name) {
+			if err := resolver.Unresolve(record.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	idx = resolver.Index()
+
+	for _, name := range conflictingPaths(idx) {
+		if !spec.Match(name) {
+			continue
+		}
+		id, err := r.forgetPath(idx, name)
+		if err == ErrBrokenConflict || err == ErrNoRecordedResolution {
+			result.Messages = append(result.Messages, "error: "+err.Error()+" '"+name+"'")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rr[name] = id
+		result.Messages = append(result.Messages, "Updated preimage for '"+name+"'", "Forgot resolution for '"+name+"'")
+	}
+	if err := writeMergeRR(lock, rr); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// forgetPath removes the postimage of the variant resolving the conflict of the path, and records the preimage again.
+func (r *Rerere) forgetPath(idx index.Index, name string) (*conflictID, error) {
+	content, err := r.recreate(idx, name)
+	if err != nil {
+		return nil, err
+	}
+	preimage, digest, conflicts := normalize(content, r.markerSize(name))
+	if conflicts < 1 {
+		return nil, ErrBrokenConflict
+	}
+	id := &conflictID{hex: hex.EncodeToString(digest)}
+	status, err := r.variants(id.hex)
+	if err != nil {
+		return nil, err
+	}
+	for id.variant = 0; id.variant < len(status); id.variant++ {
+		if status[id.variant]&hasResolution != hasResolution {
+			continue
+		}
+		_, ok, err := r.merge(name, id, preimage)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+	}
+	if len(status) <= id.variant {
+		return nil, ErrNoRecordedResolution
+	}
+	if err := os.Remove(r.path(id, "postimage")); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoRecordedResolution
+		}
+		return nil, err
+	}
+	if err := ioutil.WriteFile(r.path(id, "preimage"), preimage, 0666); err != nil {
+		return nil, err
+	}
+	if err := r.setVariant(id, hasPreimage); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// recreate merges stages of the path in the index to recreate the conflicting content.
+func (r *Rerere) recreate(idx index.Index, name string) ([]byte, error) {
+	contents := make([][]byte, 3)
+	for i := uint32(0); i < idx.Header().NumOfEntries; i++ {
+		entry, err := idx.Entries(i)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Name != name || entry.ConflictFlag == index.NoConflict {
+			continue
+		}
+		obj, err := object.ReadObjectFrom(r.gitDir, entry.Digest)
+		if err != nil {
+			return nil, err
+		}
+		blob, ok := obj.(*object.Blob)
+		if !ok {
+			return nil, object.ErrUnexpectedType
+		}
+		if contents[entry.ConflictFlag-1], err = blob.Content(); err != nil {
+			return nil, err
+		}
+	}
+	options := r.files.Options()
+	options.OurLabel, options.TheirLabel = "ours", "theirs"
+	merged, _, err := r.files.Merge(name, contents[0], contents[1], contents[2], options)
+	return merged, err
+}
+
+// conflictingPaths returns paths having regular files at both stage 2 and 3 in the index.
+func conflictingPaths(idx index.Index) []string {
+	stages := map[string]int{}
+	names := []string{}
+	for i := uint32(0); i < idx.Header().NumOfEntries; i++ {
+		entry, err := idx.Entries(i)
+		if err != nil || entry.ConflictFlag < index.CurrentCommit || entry.ObjectType != index.RegularFile {
+			continue
+		}
+		if _, ok := stages[entry.Name]; !ok {
+			names = append(names, entry.Name)
+		}
+		stages[entry.Name] |= 1 << entry.ConflictFlag
+	}
+	paths := []string{}
+	for _, name := range names {
+		if stages[name] == 1<<index.CurrentCommit|1<<index.AnotherCommit {
+			paths = append(paths, name)
+		}
+	}
+	return paths
+}
+
+// markerSize returns the length of conflict markers of the path by conflict-marker-size attribute.
+func (r *Rerere) markerSize(name string) int {
+	values, err := r.attributes.Check(name, "conflict-marker-size")
+	if err != nil || values[0].State != attributes.Valued {
+		return defaultMarkerSize
+	}
+	if size, err := strconv.Atoi(values[0].Text); err == nil && size > 0 {
+		return size
+	}
+	return defaultMarkerSize
+}
+
+func (r *Rerere) readFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(r.workDir, filepath.FromSlash(name)))
+}
+
+func (r *Rerere) writeFile(name string, content []byte) error {
+	return ioutil.WriteFile(filepath.Join(r.workDir, filepath.FromSlash(name)), content, 0666)
+}
@@ -0,0 +1,227 @@
+package revparse
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/refs"
+)
+
+// checkoutMessagePrefix is prefix of HEAD reflog message written by checkout and switch
+const checkoutMessagePrefix = "checkout: moving from "
+
+// resolveAtBrace resolves "<refname>@{<selector>}".
+func (r *Resolver) resolveAtBrace(name, selector string) ([]byte, error) {
+	switch lower := strings.ToLower(selector); {
+	case strings.HasPrefix(selector, "-"):
+		n, err := strconv.Atoi(selector[1:])
+		if err != nil || n <= 0 || name != "" {
+			return nil, ErrInvalidSyntax
+		}
+		previous, err := r.previousBranch(n)
+		if err != nil {
+			return nil, err
+		}
+		return r.resolveBase(previous)
+	case lower == "upstream" || lower == "u":
+		upstream, err := r.Upstream(name)
+		if err != nil {
+			return nil, err
+		}
+		return r.resolveFullName(upstream)
+	case lower == "push":
+		push, err := r.PushDestination(name)
+		if err != nil {
+			return nil, err
+		}
+		return r.resolveFullName(push)
+	}
+
+	fullName, err := r.reflogName(name)
+	if err != nil {
+		return nil, err
+	}
+	log, err := r.refs.ReadLog(fullName)
+	if err != nil {
+		return nil, err
+	}
+	return log.Lookup(selector, r.now())
+}
+
+// resolveFullName resolves a full reference name to digest.
+func (r *Resolver) resolveFullName(name string) ([]byte, error) {
+	ref, err := r.refs.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return ref.Digest, nil
+}
+
+// reflogName returns full name of the reference whose reflog is used for "<name>@{...}".
+// "@{...}" without name means the current branch, or HEAD if it is detached.
+func (r *Resolver) reflogName(name string) (string, error) {
+	if name == "" {
+		head, err := r.refs.Read(refs.HEAD)
+		if err != nil {
+			return "", err
+		}
+		if head.IsSymbolic() {
+			return head.Target, nil
+		}
+		return refs.HEAD, nil
+	}
+	if name == "@" {
+		return refs.HEAD, nil
+	}
+	return r.ExpandRefName(name)
+}
+
+// currentBranch returns full name of the branch what HEAD points to.
+func (r *Resolver) currentBranch() (string, error) {
+	head, err := r.refs.Read(refs.HEAD)
+	if err != nil {
+		return "", err
+	}
+	if !head.IsSymbolic() || !strings.HasPrefix(head.Target, refs.HeadPrefix) {
+		return "", ErrNotOnBranch
+	}
+	return head.Target, nil
+}
+
+// branchName returns short name of the local branch for "<branch>@{upstream}".
+func (r *Resolver) branchName(name string) (string, error) {
+	if name == "" || name == refs.HEAD || name == "@" {
+		current, err := r.currentBranch()
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimPrefix(current, refs.HeadPrefix), nil
+	}
+	name = strings.TrimPrefix(name, refs.HeadPrefix)
+	if _, err := r.refs.Read(refs.HeadPrefix + name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// loadConfig reads configuration of the repository once.
+func (r *Resolver) loadConfig() (*config.Config, error) {
+	if r.config != nil {
+		return r.config, nil
+	}
+	cfg, err := config.Load(r.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	r.config = cfg
+	return cfg, nil
+}
+
+// Upstream returns full name of the remote-tracking branch what the branch builds on.
+// it is specified by branch.<name>.remote and branch.<name>.merge.
+// empty name means the current branch.
+func (r *Resolver) Upstream(name string) (string, error) {
+	branch, err := r.branchName(name)
+	if err != nil {
+		return "", err
+	}
+	cfg, err := r.loadConfig()
+	if err != nil {
+		return "", err
+	}
+	remote, ok := cfg.Get("branch." + branch + ".remote")
+	if !ok {
+		return "", ErrNoUpstream
+	}
+	merge, ok := cfg.Get("branch." + branch + ".merge")
+	if !ok {
+		return "", ErrNoUpstream
+	}
+	if remote == "." {
+		return merge, nil
+	}
+	return trackingRef(cfg, remote, merge)
+}
+
+// PushDestination returns full name of the remote-tracking branch what the branch is pushed to.
+// the remote is branch.<name>.pushRemote, remote.pushDefault or branch.<name>.remote in this order.
+// empty name means the current branch.
+func (r *Resolver) PushDestination(name string) (string, error) {
+	branch, err := r.branchName(name)
+	if err != nil {
+		return "", err
+	}
+	cfg, err := r.loadConfig()
+	if err != nil {
+		return "", err
+	}
+	remote, ok := cfg.Get("branch." + branch + ".pushRemote")
+	if !ok {
+		remote, ok = cfg.Get("remote.pushDefault")
+	}
+	if !ok {
+		remote, ok = cfg.Get("branch." + branch + ".remote")
+	}
+	if !ok {
+		return "", ErrNoUpstream
+	}
+	if remote == "." {
+		return refs.HeadPrefix + branch, nil
+	}
+	return trackingRef(cfg, remote, refs.HeadPrefix+branch)
+}
+
+// trackingRef maps a reference of the remote to the remote-tracking reference
+// by remote.<remote>.fetch refspecs.
+func trackingRef(cfg *config.Config, remote, name string) (string, error) {
+	specs := cfg.GetAll("remote." + remote + ".fetch")
+	if len(specs) == 0 {
+		specs = []string{"+refs/heads/*:refs/remotes/" + remote + "/*"}
+	}
+	for _, spec := range specs {
+		spec = strings.TrimPrefix(spec, "+")
+		colon := strings.IndexByte(spec, ':')
+		if colon < 0 {
+			continue
+		}
+		src, dst := spec[:colon], spec[colon+1:]
+		star := strings.IndexByte(src, '*')
+		if star < 0 {
+			if src == name {
+				return dst, nil
+			}
+			continue
+		}
+		prefix, suffix := src[:star], src[star+1:]
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) && len(name) >= len(prefix)+len(suffix) {
+			matched := name[len(prefix) : len(name)-len(suffix)]
+			return strings.Replace(dst, "*", matched, 1), nil
+		}
+	}
+	return "", ErrNoUpstream
+}
+
+// previousBranch returns n-th branch checked out before the current one
+// by reading "checkout: moving from <from> to <to>" messages in HEAD reflog.
+func (r *Resolver) previousBranch(n int) (string, error) {
+	log, err := r.refs.ReadLog(refs.HEAD)
+	if err != nil {
+		return "", err
+	}
+	for i := len(log.Entries) - 1; i >= 0; i-- {
+		message := log.Entries[i].Message
+		if !strings.HasPrefix(message, checkoutMessagePrefix) {
+			continue
+		}
+		to := strings.LastIndex(message, " to ")
+		if to < len(checkoutMessagePrefix) {
+			continue
+		}
+		n--
+		if n == 0 {
+			return message[len(checkoutMessagePrefix):to], nil
+		}
+	}
+	return "", ErrNoPreviousBranch
+}
@@ -0,0 +1,36 @@
+package revparse
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrUnknownRevision  = errors.New("unknown revision or path not in the working tree")
+	ErrInvalidSyntax    = errors.New("invalid revision syntax")
+	ErrNoSuchParent     = errors.New("commit doesn't have such parent")
+	ErrUnexpectedType   = errors.New("object can't be peeled to the type")
+	ErrPathNotFound     = errors.New("path doesn't exist in the tree")
+	ErrPathNotInIndex   = errors.New("path is not in the index")
+	ErrPathNotAtStage   = errors.New("path is in the index, but not at the stage")
+	ErrNoMatchedCommit  = errors.New("no commit message matches the pattern")
+	ErrNotOnBranch      = errors.New("HEAD does not point to a branch")
+	ErrNoUpstream       = errors.New("no upstream configured for the branch")
+	ErrNoPreviousBranch = errors.New("no such previously checked out branch")
+)
+
+// AmbiguousError is an error meaning that an abbreviated object name matches many objects.
+type AmbiguousError struct {
+	Prefix     string   // abbreviated object name
+	Candidates [][]byte // SHA1 digests of the objects matching Prefix
+}
+
+// Error is implementation of error interface
+func (e *AmbiguousError) Error() string {
+	candidates := make([]string, len(e.Candidates))
+	for i, candidate := range e.Candidates {
+		candidates[i] = hex.EncodeToString(candidate)
+	}
+	return "short object ID " + e.Prefix + " is ambiguous, the candidates are: " + strings.Join(candidates, ", ")
+}
@@ -0,0 +1,510 @@
+// revparse is a package to resolve revision expressions to object IDs
+//
+// The following expressions are supported, they can be combined
+// (e.g. "origin/main@{1}~2^2^{tree}").
+//
+//  <sha1>, <abbreviated sha1>  - object name, or abbreviated one at least 4 hex digits
+//  <describe output>           - e.g. v1.0-3-gabcdef1
+//  <refname>, @                - e.g. HEAD, main, tags/v1.0, refs/remotes/origin/main
+//  <refname>@{<n>}, @{<n>}     - n-th prior value of the reference in its reflog
+//  <refname>@{<date>}          - value of the reference at the date, e.g. main@{yesterday}
+//  @{-<n>}                     - n-th branch checked out before the current one
+//  <branch>@{upstream}, @{u}   - branch what the branch builds on top of
+//  <branch>@{push}             - remote-tracking branch what the branch is pushed to
+//  <rev>^<n>, <rev>^           - n-th parent of the commit, ^0 means the commit itself
+//  <rev>~<n>, <rev>~           - n-th generation ancestor following only first parents
+//  <rev>^{<type>}, <rev>^{}    - object peeled to the type, or tags peeled recursively
+//  <rev>^{/<regex>}            - the youngest commit reachable from rev whose message matches
+//  :/<regex>                   - the youngest commit reachable from any reference whose message matches
+//  <rev>:<path>                - blob or tree at the path in the tree-ish
+//  :<path>, :<n>:<path>        - blob of the path at the stage n (0 if omitted) in the index
+//
+// If you want to know more about revision expressions, please refer to
+// https://git-scm.com/docs/gitrevisions
+package revparse
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/refs"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// minAbbrevLength is the shortest length of abbreviated object name
+const minAbbrevLength = 4
+
+// dwimRules are rules to expand a short reference name in the same order as git
+var dwimRules = []string{
+	"%s",
+	"refs/%s",
+	"refs/tags/%s",
+	"refs/heads/%s",
+	"refs/remotes/%s",
+	"refs/remotes/%s/HEAD",
+}
+
+// Resolver is a type to resolve revision expressions in a repository.
+type Resolver struct {
+	gitDir string
+	refs   refs.Store
+	config *config.Config
+	now    func() time.Time
+}
+
+// NewResolver creates a Resolver of the repository.
+// gitDir of parameters must be path to .git directory.
+func NewResolver(gitDir string) (*Resolver, error) {
+	store, err := refs.NewStore(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{
+		gitDir: gitDir,
+		refs:   store,
+		now:    time.Now,
+	}, nil
+}
+
+// OpenResolver creates a Resolver of current repository.
+func OpenResolver() (*Resolver, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewResolver(gitDir)
+}
+
+// Refs returns reference store what this Resolver uses.
+func (r *Resolver) Refs() refs.Store {
+	return r.refs
+}
+
+// Resolve resolves revision expression to SHA1 digest of an object.
+func (r *Resolver) Resolve(rev string) ([]byte, error) {
+	if rev == "" {
+		return nil, ErrInvalidSyntax
+	}
+	if strings.HasPrefix(rev, ":/") {
+		return r.searchFromAllRefs(rev[2:])
+	}
+	if strings.HasPrefix(rev, ":") {
+		return r.resolveIndexPath(rev[1:])
+	}
+	if colon := findPathColon(rev); colon >= 0 {
+		treeish, err := r.resolveExpression(rev[:colon])
+		if err != nil {
+			return nil, err
+		}
+		return r.resolveTreePath(treeish, rev[colon+1:])
+	}
+	return r.resolveExpression(rev)
+}
+
+// ResolveCommit resolves revision expression and peels it to a commit.
+func (r *Resolver) ResolveCommit(rev string) ([]byte, error) {
+	digest, err := r.Resolve(rev)
+	if err != nil {
+		return nil, err
+	}
+	return r.Peel(digest, object.CommitObject)
+}
+
+// findPathColon returns index of ':' separating revision and path, or -1.
+// colons in braces like @{2006-01-02 15:04:05} are not separators.
+func findPathColon(rev string) int {
+	depth := 0
+	for i := 0; i < len(rev); i++ {
+		switch rev[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case ':':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// resolveExpression resolves "<base><operators>" expression.
+func (r *Resolver) resolveExpression(expr string) ([]byte, error) {
+	end := len(expr)
+	depth := 0
+	for i := 0; i < len(expr); i++ {
+		if expr[i] == '{' {
+			depth++
+		} else if expr[i] == '}' && depth > 0 {
+			depth--
+		} else if depth == 0 && (expr[i] == '^' || expr[i] == '~') {
+			end = i
+			break
+		}
+	}
+	digest, err := r.resolveBase(expr[:end])
+	if err != nil {
+		return nil, err
+	}
+	return r.applyOperators(digest, expr[end:])
+}
+
+// applyOperators applies "~<n>", "^<n>" and "^{...}" operators from left to right.
+func (r *Resolver) applyOperators(digest []byte, operators string) ([]byte, error) {
+	var err error
+	for operators != "" {
+		switch {
+		case strings.HasPrefix(operators, "^{"):
+			closing := matchingBrace(operators, 1)
+			if closing < 0 {
+				return nil, ErrInvalidSyntax
+			}
+			digest, err = r.peelOnion(digest, operators[2:closing])
+			operators = operators[closing+1:]
+		case operators[0] == '^':
+			var n int
+			n, operators = parseCount(operators[1:])
+			digest, err = r.parent(digest, n)
+		case operators[0] == '~':
+			var n int
+			n, operators = parseCount(operators[1:])
+			digest, err = r.ancestor(digest, n)
+		default:
+			return nil, ErrInvalidSyntax
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return digest, nil
+}
+
+// matchingBrace returns index of '}' matching '{' at open, or -1.
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseCount parses leading decimal digits, it returns 1 if there are no digits.
+func parseCount(s string) (int, string) {
+	end := 0
+	for end < len(s) && '0' <= s[end] && s[end] <= '9' {
+		end++
+	}
+	if end == 0 {
+		return 1, s
+	}
+	n, err := strconv.Atoi(s[:end])
+	if err != nil {
+		return 1, s
+	}
+	return n, s[end:]
+}
+
+// resolveBase resolves an expression without operators.
+func (r *Resolver) resolveBase(base string) ([]byte, error) {
+	if base == "" {
+		return nil, ErrInvalidSyntax
+	}
+	if at := strings.Index(base, "@{"); at >= 0 {
+		if !strings.HasSuffix(base, "}") {
+			return nil, ErrInvalidSyntax
+		}
+		return r.resolveAtBrace(base[:at], base[at+2:len(base)-1])
+	}
+	if base == "@" {
+		base = refs.HEAD
+	}
+	if len(base) == object.DigestSize*2 && isHex(base) {
+		return hex.DecodeString(base)
+	}
+	if name, err := r.ExpandRefName(base); err == nil {
+		ref, err := r.refs.Resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		return ref.Digest, nil
+	} else if err != refs.ErrRefNotFound {
+		return nil, err
+	}
+	if len(base) >= minAbbrevLength && isHex(base) {
+		return r.expandAbbrev(base)
+	}
+	// output of git describe, e.g. v1.0-3-gabcdef1
+	if g := strings.LastIndex(base, "-g"); g >= 0 {
+		abbrev := base[g+2:]
+		if len(abbrev) >= minAbbrevLength && isHex(abbrev) {
+			return r.expandAbbrev(abbrev)
+		}
+	}
+	return nil, ErrUnknownRevision
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9') && !('a' <= c && c <= 'f') && !('A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// isPseudoRefName returns whether name looks like HEAD, ORIG_HEAD or FETCH_HEAD.
+func isPseudoRefName(name string) bool {
+	for _, c := range name {
+		if !('A' <= c && c <= 'Z') && c != '_' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// ExpandRefName expands a short reference name like "master" to the full name of an existing reference like "refs/heads/master".
+// it returns refs.ErrRefNotFound if no reference matches the name.
+func (r *Resolver) ExpandRefName(name string) (string, error) {
+	if !refs.IsValidName(name) {
+		return "", refs.ErrRefNotFound
+	}
+	for i, rule := range dwimRules {
+		if i == 0 && !isPseudoRefName(name) && !strings.HasPrefix(name, refs.RefsPrefix) {
+			continue
+		}
+		fullName := strings.Replace(rule, "%s", name, 1)
+		if _, err := r.refs.Read(fullName); err == nil {
+			return fullName, nil
+		} else if err != refs.ErrRefNotFound && err != refs.ErrInvalidRefName {
+			return "", err
+		}
+	}
+	return "", refs.ErrRefNotFound
+}
+
+// expandAbbrev expands abbreviated object name.
+func (r *Resolver) expandAbbrev(abbrev string) ([]byte, error) {
+	candidates, err := object.FindObjects(r.gitDir, strings.ToLower(abbrev))
+	if err != nil {
+		return nil, err
+	}
+	switch len(candidates) {
+	case 0:
+		return nil, ErrUnknownRevision
+	case 1:
+		return candidates[0], nil
+	default:
+		return nil, &AmbiguousError{
+			Prefix:     abbrev,
+			Candidates: candidates,
+		}
+	}
+}
+
+// readObject reads the object in the repository.
+func (r *Resolver) readObject(digest []byte) (object.Object, error) {
+	return object.ReadObjectFrom(r.gitDir, digest)
+}
+
+// readCommit reads the commit, the digest must be peeled already.
+func (r *Resolver) readCommit(digest []byte) (*object.Commit, error) {
+	obj, err := r.readObject(digest)
+	if err != nil {
+		return nil, err
+	}
+	commit, ok := obj.(*object.Commit)
+	if !ok {
+		return nil, ErrUnexpectedType
+	}
+	return commit, nil
+}
+
+// Peel follows tags and commits until an object of objectType.
+// a tag is peeled to the tagged object, and a commit is peeled to its tree.
+func (r *Resolver) Peel(digest []byte, objectType object.ObjectType) ([]byte, error) {
+	for {
+		obj, err := r.readObject(digest)
+		if err != nil {
+			return nil, err
+		}
+		if obj.Type() == objectType {
+			return digest, nil
+		}
+		switch o := obj.(type) {
+		case *object.Tag:
+			digest = o.Object
+		case *object.Commit:
+			if objectType != object.TreeObject {
+				return nil, ErrUnexpectedType
+			}
+			digest = o.Tree
+		default:
+			return nil, ErrUnexpectedType
+		}
+	}
+}
+
+// peelTags follows tags until an object which is not a tag.
+func (r *Resolver) peelTags(digest []byte) ([]byte, error) {
+	for {
+		obj, err := r.readObject(digest)
+		if err != nil {
+			return nil, err
+		}
+		tag, ok := obj.(*object.Tag)
+		if !ok {
+			return digest, nil
+		}
+		digest = tag.Object
+	}
+}
+
+// peelOnion applies "^{...}" operator.
+func (r *Resolver) peelOnion(digest []byte, inner string) ([]byte, error) {
+	switch {
+	case inner == "":
+		return r.peelTags(digest)
+	case inner == "object":
+		if _, err := r.readObject(digest); err != nil {
+			return nil, err
+		}
+		return digest, nil
+	case strings.HasPrefix(inner, "/"):
+		commit, err := r.Peel(digest, object.CommitObject)
+		if err != nil {
+			return nil, err
+		}
+		return r.searchMessage([][]byte{commit}, inner[1:])
+	}
+	objectType, err := object.ParseObjectType(inner)
+	if err != nil {
+		return nil, ErrInvalidSyntax
+	}
+	return r.Peel(digest, objectType)
+}
+
+// parent returns n-th parent of the commit, or the commit itself if n is 0.
+func (r *Resolver) parent(digest []byte, n int) ([]byte, error) {
+	digest, err := r.Peel(digest, object.CommitObject)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return digest, nil
+	}
+	commit, err := r.readCommit(digest)
+	if err != nil {
+		return nil, err
+	}
+	if len(commit.Parents) < n {
+		return nil, ErrNoSuchParent
+	}
+	return commit.Parents[n-1], nil
+}
+
+// ancestor returns n-th generation ancestor following only first parents.
+func (r *Resolver) ancestor(digest []byte, n int) ([]byte, error) {
+	digest, err := r.Peel(digest, object.CommitObject)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		if digest, err = r.parent(digest, 1); err != nil {
+			return nil, err
+		}
+	}
+	return digest, nil
+}
+
+// resolveTreePath resolves the path in the tree-ish.
+func (r *Resolver) resolveTreePath(treeish []byte, path string) ([]byte, error) {
+	digest, err := r.Peel(treeish, object.TreeObject)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(path, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		obj, err := r.readObject(digest)
+		if err != nil {
+			return nil, err
+		}
+		tree, ok := obj.(*object.Tree)
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		entry, ok := tree.Entry(name)
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		digest = entry.Digest
+	}
+	return digest, nil
+}
+
+// resolveIndexPath resolves "<path>" or "<stage>:<path>" in the index.
+func (r *Resolver) resolveIndexPath(spec string) ([]byte, error) {
+	stage := index.NoConflict
+	if len(spec) >= 2 && spec[1] == ':' && '0' <= spec[0] && spec[0] <= '3' {
+		stage = index.ConflictFlag(spec[0] - '0')
+		spec = spec[2:]
+	}
+	file, err := os.Open(filepath.Join(r.gitDir, "index"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrPathNotInIndex
+		}
+		return nil, err
+	}
+	defer file.Close()
+	idx, err := index.ReadIndexFromReader(file)
+	if err != nil {
+		return nil, err
+	}
+	// only the sparse directory containing the path is expanded
+	if idx, err = index.Expand(r.gitDir, idx, spec); err != nil {
+		return nil, err
+	}
+
+	found := false
+	for i := uint32(0); i < idx.Header().NumOfEntries; i++ {
+		entry, err := idx.Entries(i)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Name != spec {
+			continue
+		}
+		if entry.ConflictFlag == stage {
+			return entry.Digest, nil
+		}
+		found = true
+	}
+	if found {
+		return nil, ErrPathNotAtStage
+	}
+	return nil, ErrPathNotInIndex
+}
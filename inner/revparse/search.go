@@ -0,0 +1,126 @@
+package revparse
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/refs"
+)
+
+// commitQueue is a priority queue of commits, the most recently committed one first.
+type commitQueue struct {
+	digests [][]byte
+	commits []*object.Commit
+}
+
+func (q *commitQueue) Len() int {
+	return len(q.commits)
+}
+
+func (q *commitQueue) Less(i, j int) bool {
+	return q.commits[i].Committer.When.After(q.commits[j].Committer.When)
+}
+
+func (q *commitQueue) Swap(i, j int) {
+	q.digests[i], q.digests[j] = q.digests[j], q.digests[i]
+	q.commits[i], q.commits[j] = q.commits[j], q.commits[i]
+}
+
+func (q *commitQueue) Push(x interface{}) {
+	item := x.(queuedCommit)
+	q.digests = append(q.digests, item.digest)
+	q.commits = append(q.commits, item.commit)
+}
+
+func (q *commitQueue) Pop() interface{} {
+	last := len(q.commits) - 1
+	item := queuedCommit{digest: q.digests[last], commit: q.commits[last]}
+	q.digests = q.digests[:last]
+	q.commits = q.commits[:last]
+	return item
+}
+
+type queuedCommit struct {
+	digest []byte
+	commit *object.Commit
+}
+
+// searchFromAllRefs searches the youngest commit whose message matches pattern
+// from HEAD and all references.
+func (r *Resolver) searchFromAllRefs(pattern string) ([]byte, error) {
+	starts := [][]byte{}
+	if head, err := r.refs.Resolve(refs.HEAD); err == nil {
+		starts = append(starts, head.Digest)
+	}
+	all, err := r.refs.List(refs.RefsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range all {
+		if ref.IsSymbolic() {
+			continue
+		}
+		commit, err := r.Peel(ref.Digest, object.CommitObject)
+		if err != nil {
+			continue
+		}
+		starts = append(starts, commit)
+	}
+	return r.searchMessage(starts, pattern)
+}
+
+// searchMessage searches the youngest commit reachable from starts whose message matches pattern.
+// pattern beginning with "!-" matches commits whose message doesn't match the rest,
+// and "!!" is escape of leading '!'.
+func (r *Resolver) searchMessage(starts [][]byte, pattern string) ([]byte, error) {
+	negative := false
+	switch {
+	case strings.HasPrefix(pattern, "!-"):
+		negative = true
+		pattern = pattern[2:]
+	case strings.HasPrefix(pattern, "!!"):
+		pattern = pattern[1:]
+	case strings.HasPrefix(pattern, "!"):
+		return nil, ErrInvalidSyntax
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, ErrInvalidSyntax
+	}
+
+	queue := &commitQueue{}
+	visited := map[string]struct{}{}
+	push := func(digest []byte) error {
+		key := hex.EncodeToString(digest)
+		if _, ok := visited[key]; ok {
+			return nil
+		}
+		visited[key] = struct{}{}
+		commit, err := r.readCommit(digest)
+		if err != nil {
+			return err
+		}
+		heap.Push(queue, queuedCommit{digest: digest, commit: commit})
+		return nil
+	}
+	for _, start := range starts {
+		if err := push(start); err != nil {
+			return nil, err
+		}
+	}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(queuedCommit)
+		if re.MatchString(item.commit.Message) != negative {
+			return item.digest, nil
+		}
+		for _, parent := range item.commit.Parents {
+			if err := push(parent); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, ErrNoMatchedCommit
+}
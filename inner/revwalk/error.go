@@ -0,0 +1,11 @@
+package revwalk
+
+import "errors"
+
+var (
+	ErrNotCommit     = errors.New("object is not a commit")
+	ErrInvalidRange  = errors.New("invalid revision range")
+	ErrAlreadyWalked = errors.New("walker has already started walking")
+	ErrNoMergeBase   = errors.New("no common ancestor is found")
+	ErrNoForkPoint   = errors.New("no fork point is found in the reflog")
+)
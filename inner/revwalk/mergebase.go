@@ -0,0 +1,361 @@
+package revwalk
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/reflog"
+	"github.com/shumon84/mogit/inner/refs"
+)
+
+// flags used while painting history to find merge bases
+const (
+	paintLeft = 1 << iota
+	paintRight
+	paintStale
+	paintResult
+)
+
+// MergeBase returns the best common ancestor of one and others like $ git merge-base <one> <others>...
+// it returns ErrNoMergeBase if they don't have a common ancestor.
+// gitDir of parameters must be path to .git directory.
+func MergeBase(gitDir string, one []byte, others ...[]byte) ([]byte, error) {
+	bases, err := MergeBases(gitDir, one, others...)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 {
+		return nil, ErrNoMergeBase
+	}
+	return bases[0], nil
+}
+
+// MergeBases returns best common ancestors of one and others like $ git merge-base --all <one> <others>...
+// a commit is a candidate if it's reachable from one and any of others, and candidates reachable from
+// other candidates are removed. merge bases are sorted by commit date, the newest first.
+// gitDir of parameters must be path to .git directory.
+func MergeBases(gitDir string, one []byte, others ...[]byte) ([][]byte, error) {
+	w, err := NewWalker(gitDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return w.allMergeBases(one, others...)
+}
+
+// OctopusMergeBases returns best common ancestors of all the commits like $ git merge-base --octopus --all <commits>...
+// it's used to find the merge base of an octopus merge, the first one is the best.
+// gitDir of parameters must be path to .git directory.
+func OctopusMergeBases(gitDir string, commits ...[]byte) ([][]byte, error) {
+	if len(commits) == 0 {
+		return [][]byte{}, nil
+	}
+	w, err := NewWalker(gitDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	first, err := w.commitNode(commits[0])
+	if err != nil {
+		return nil, err
+	}
+	bases := [][]byte{first.digest}
+	for _, commit := range commits[1:] {
+		next := [][]byte{}
+		for _, base := range bases {
+			found, err := w.allMergeBases(commit, base)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, found...)
+		}
+		bases = next
+	}
+	return w.independent(bases)
+}
+
+// Independent returns commits which can't be reached from any other of them like $ git merge-base --independent <commits>...
+// duplicated commits are reduced to one, and the order of the commits is kept.
+// gitDir of parameters must be path to .git directory.
+func Independent(gitDir string, commits ...[]byte) ([][]byte, error) {
+	w, err := NewWalker(gitDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return w.independent(commits)
+}
+
+// IsAncestor returns whether ancestor is reachable from descendant like $ git merge-base --is-ancestor <ancestor> <descendant>
+// a commit is an ancestor of itself.
+// gitDir of parameters must be path to .git directory.
+func IsAncestor(gitDir string, ancestor, descendant []byte) (bool, error) {
+	w, err := NewWalker(gitDir, nil)
+	if err != nil {
+		return false, err
+	}
+	ancestorNode, err := w.commitNode(ancestor)
+	if err != nil {
+		return false, err
+	}
+	descendantNode, err := w.commitNode(descendant)
+	if err != nil {
+		return false, err
+	}
+	_, flags, err := w.paintDownToCommon(ancestorNode, []*node{descendantNode})
+	if err != nil {
+		return false, err
+	}
+	return flags[ancestorNode]&paintRight != 0, nil
+}
+
+// ForkPoint returns the commit where commit forked from the reference like $ git merge-base --fork-point <ref> <commit>
+// the fork point is found from commits recorded in the reflog of the reference, so it's found even if the
+// reference was rewound or rebased. it returns ErrNoForkPoint if no commit in the reflog is the fork point.
+// ref may be a short name like "origin/master".
+// gitDir of parameters must be path to .git directory.
+func ForkPoint(gitDir, ref string, commit []byte) ([]byte, error) {
+	w, err := NewWalker(gitDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	name, err := w.resolver.ExpandRefName(ref)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := w.reflogCommits(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		// the reference doesn't have reflog
+		tip, err := w.resolver.Refs().Resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := w.commitNode(tip.Digest); err == nil {
+			candidates = append(candidates, n.digest)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoForkPoint
+	}
+
+	// the fork point is the only merge base, and it must be one of the reflog entries
+	bases, err := w.mergeBases(commit, candidates...)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) != 1 {
+		return nil, ErrNoForkPoint
+	}
+	for _, candidate := range candidates {
+		if bytes.Equal(candidate, bases[0]) {
+			return bases[0], nil
+		}
+	}
+	return nil, ErrNoForkPoint
+}
+
+// reflogCommits returns commits recorded in the reflog of the reference in the order they are recorded.
+// entries which aren't commits or don't exist are skipped.
+func (w *Walker) reflogCommits(name string) ([][]byte, error) {
+	log, err := w.resolver.Refs().ReadLog(name)
+	if err == reflog.ErrReflogNotFound {
+		return [][]byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	commits := [][]byte{}
+	seen := map[*node]bool{}
+	add := func(digest []byte) {
+		if refs.IsZeroDigest(digest) {
+			return
+		}
+		n, err := w.node(digest)
+		if err != nil || seen[n] {
+			return
+		}
+		seen[n] = true
+		commits = append(commits, n.digest)
+	}
+	for i, entry := range log.Entries {
+		if i == 0 {
+			add(entry.OldDigest)
+		}
+		add(entry.NewDigest)
+	}
+	return commits, nil
+}
+
+// commitNode returns the node of the commit, tags are peeled to the commit.
+func (w *Walker) commitNode(digest []byte) (*node, error) {
+	peeled, err := w.resolver.Peel(digest, object.CommitObject)
+	if err != nil {
+		return nil, err
+	}
+	return w.node(peeled)
+}
+
+// allMergeBases returns merge bases of one and others without redundant ones.
+func (w *Walker) allMergeBases(one []byte, others ...[]byte) ([][]byte, error) {
+	bases, err := w.mergeBases(one, others...)
+	if err != nil || len(bases) <= 1 {
+		return bases, err
+	}
+	nodes := make([]*node, len(bases))
+	for i, base := range bases {
+		if nodes[i], err = w.node(base); err != nil {
+			return nil, err
+		}
+	}
+	if nodes, err = w.removeRedundant(nodes); err != nil {
+		return nil, err
+	}
+	return digestsOf(nodes), nil
+}
+
+// independent removes duplicated commits and commits reachable from other commits, the order is kept.
+func (w *Walker) independent(commits [][]byte) ([][]byte, error) {
+	nodes := []*node{}
+	seen := map[*node]bool{}
+	for _, commit := range commits {
+		n, err := w.commitNode(commit)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[n] {
+			seen[n] = true
+			nodes = append(nodes, n)
+		}
+	}
+	nodes, err := w.removeRedundant(nodes)
+	if err != nil {
+		return nil, err
+	}
+	return digestsOf(nodes), nil
+}
+
+// mergeBases returns common ancestors of one and others sorted by commit date, the newest first.
+// redundant ones may be included, it's enough to exclude the common history.
+func (w *Walker) mergeBases(one []byte, others ...[]byte) ([][]byte, error) {
+	oneNode, err := w.commitNode(one)
+	if err != nil {
+		return nil, err
+	}
+	otherNodes := make([]*node, len(others))
+	for i, other := range others {
+		if otherNodes[i], err = w.commitNode(other); err != nil {
+			return nil, err
+		}
+		if otherNodes[i] == oneNode {
+			return [][]byte{oneNode.digest}, nil
+		}
+	}
+
+	results, flags, err := w.paintDownToCommon(oneNode, otherNodes)
+	if err != nil {
+		return nil, err
+	}
+	bases := []*node{}
+	for _, n := range results {
+		if flags[n]&paintStale == 0 {
+			bases = append(bases, n)
+		}
+	}
+	sort.SliceStable(bases, func(i, j int) bool {
+		return newerCommitDate(bases[i], bases[j])
+	})
+	return digestsOf(bases), nil
+}
+
+// paintDownToCommon walks history from one and others in commit date order, and paints commits with
+// where they are reachable from. it returns commits reachable from both sides in the order they are found,
+// commits reachable from them are painted stale.
+func (w *Walker) paintDownToCommon(one *node, others []*node) ([]*node, map[*node]uint, error) {
+	flags := map[*node]uint{one: paintLeft}
+	queue := newDateQueue()
+	queue.push(one)
+	for _, other := range others {
+		if flags[other]&paintRight == 0 {
+			flags[other] |= paintRight
+			queue.push(other)
+		}
+	}
+	results := []*node{}
+	for queue.Len() > 0 && !allStale(queue, flags) {
+		n := queue.pop()
+		f := flags[n] & (paintLeft | paintRight | paintStale)
+		if f == paintLeft|paintRight {
+			if flags[n]&paintResult == 0 {
+				flags[n] |= paintResult
+				results = append(results, n)
+			}
+			f |= paintStale
+		}
+		for _, digest := range n.commit.Parents {
+			parent, err := w.node(digest)
+			if err != nil {
+				return nil, nil, err
+			}
+			if flags[parent]&f == f {
+				continue
+			}
+			flags[parent] |= f
+			queue.push(parent)
+		}
+	}
+	return results, flags, nil
+}
+
+// removeRedundant removes commits reachable from other commits.
+func (w *Walker) removeRedundant(nodes []*node) ([]*node, error) {
+	redundant := make([]bool, len(nodes))
+	for i, n := range nodes {
+		if redundant[i] {
+			continue
+		}
+		others, indices := []*node{}, []int{}
+		for j, other := range nodes {
+			if i != j && !redundant[j] {
+				others = append(others, other)
+				indices = append(indices, j)
+			}
+		}
+		_, flags, err := w.paintDownToCommon(n, others)
+		if err != nil {
+			return nil, err
+		}
+		if flags[n]&paintRight != 0 {
+			redundant[i] = true
+		}
+		for k, other := range others {
+			if flags[other]&paintLeft != 0 {
+				redundant[indices[k]] = true
+			}
+		}
+	}
+	result := []*node{}
+	for i, n := range nodes {
+		if !redundant[i] {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
+
+func allStale(queue *nodeQueue, flags map[*node]uint) bool {
+	for _, n := range queue.nodes {
+		if flags[n]&paintStale == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func digestsOf(nodes []*node) [][]byte {
+	digests := make([][]byte, len(nodes))
+	for i, n := range nodes {
+		digests[i] = n.digest
+	}
+	return digests
+}
@@ -0,0 +1,116 @@
+package revwalk
+
+import (
+	"bytes"
+	"path"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// normalizePaths cleans paths, "" and "." mean the whole tree.
+func normalizePaths(paths []string) []string {
+	normalized := make([]string, 0, len(paths))
+	for _, p := range paths {
+		p = path.Clean(strings.TrimPrefix(p, "/"))
+		if p == "." {
+			p = ""
+		}
+		normalized = append(normalized, p)
+	}
+	return normalized
+}
+
+// simplify marks the node TREESAME if it doesn't modify Paths compared with all its parents.
+// unless FullHistory, only a TREESAME parent is followed if there is.
+func (w *Walker) simplify(n *node) error {
+	digests, err := w.digestsOfPaths(n)
+	if err != nil {
+		return err
+	}
+	if len(n.followed) == 0 {
+		if isAllNil(digests) {
+			n.flags |= treesame
+		}
+		return nil
+	}
+	same := 0
+	for _, parent := range n.followed {
+		parentDigests, err := w.digestsOfPaths(parent)
+		if err != nil {
+			return err
+		}
+		if !equalDigests(digests, parentDigests) {
+			continue
+		}
+		if !w.options.FullHistory {
+			n.followed = []*node{parent}
+			n.flags |= treesame
+			return nil
+		}
+		same++
+	}
+	if same == len(n.followed) {
+		n.flags |= treesame
+	}
+	return nil
+}
+
+// digestsOfPaths returns digests of Paths in the tree of the commit, nil for missing paths.
+func (w *Walker) digestsOfPaths(n *node) ([][]byte, error) {
+	if n.pathDigests != nil {
+		return n.pathDigests, nil
+	}
+	digests := make([][]byte, len(w.options.Paths))
+	for i, p := range w.options.Paths {
+		digest, err := w.lookupPath(n.commit.Tree, p)
+		if err != nil {
+			return nil, err
+		}
+		digests[i] = digest
+	}
+	n.pathDigests = digests
+	return digests, nil
+}
+
+// lookupPath returns the digest of the entry at the path in the tree, or nil if it doesn't exist.
+func (w *Walker) lookupPath(treeDigest []byte, p string) ([]byte, error) {
+	if p == "" {
+		return treeDigest, nil
+	}
+	digest := treeDigest
+	for _, name := range strings.Split(p, "/") {
+		obj, err := object.ReadObjectFrom(w.gitDir, digest)
+		if err != nil {
+			return nil, err
+		}
+		tree, ok := obj.(*object.Tree)
+		if !ok {
+			return nil, nil
+		}
+		entry, ok := tree.Entry(name)
+		if !ok {
+			return nil, nil
+		}
+		digest = entry.Digest
+	}
+	return digest, nil
+}
+
+func isAllNil(digests [][]byte) bool {
+	for _, digest := range digests {
+		if digest != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func equalDigests(a, b [][]byte) bool {
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package revwalk
+
+import "container/heap"
+
+// nodeQueue is a priority queue of nodes, the most recently committed one first.
+type nodeQueue struct {
+	nodes []*node
+	less  func(a, b *node) bool
+}
+
+func newDateQueue() *nodeQueue {
+	return &nodeQueue{less: newerCommitDate}
+}
+
+func newerCommitDate(a, b *node) bool {
+	return a.commit.Committer.When.After(b.commit.Committer.When)
+}
+
+func newerAuthorDate(a, b *node) bool {
+	return a.commit.Author.When.After(b.commit.Author.When)
+}
+
+func (q *nodeQueue) Len() int {
+	return len(q.nodes)
+}
+
+func (q *nodeQueue) Less(i, j int) bool {
+	return q.less(q.nodes[i], q.nodes[j])
+}
+
+func (q *nodeQueue) Swap(i, j int) {
+	q.nodes[i], q.nodes[j] = q.nodes[j], q.nodes[i]
+}
+
+func (q *nodeQueue) Push(x interface{}) {
+	q.nodes = append(q.nodes, x.(*node))
+}
+
+func (q *nodeQueue) Pop() interface{} {
+	last := len(q.nodes) - 1
+	n := q.nodes[last]
+	q.nodes = q.nodes[:last]
+	return n
+}
+
+func (q *nodeQueue) push(n *node) {
+	heap.Push(q, n)
+}
+
+func (q *nodeQueue) pop() *node {
+	return heap.Pop(q).(*node)
+}
@@ -0,0 +1,509 @@
+// revwalk is a package to walk commit history like $ git rev-list
+//
+// Walker starts from commits given by Push, and lists commits reachable from them
+// except ones reachable from hidden commits. Push accepts these forms.
+//
+//  <rev>          - include commits reachable from rev
+//  ^<rev>         - exclude commits reachable from rev
+//  <rev1>..<rev2> - same as ^<rev1> <rev2>, HEAD is used for omitted one
+//  <rev1>...<rev2> - commits reachable from either one but not both
+//  <rev>^@        - all parents of rev, but not rev itself
+//  <rev>^!        - rev, but none of its parents
+//  <rev>^-<n>     - same as <rev>^<n>..<rev>, n is 1 if omitted
+//
+// If you want to know more about the walk, please refer to
+// https://git-scm.com/docs/git-rev-list
+package revwalk
+
+import (
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/revparse"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// Order is a type representing the order of commits which Walker outputs.
+type Order int
+
+const (
+	// ChronologicalOrder outputs commits in reverse chronological order of commit date,
+	// it's the default order of $ git log
+	ChronologicalOrder Order = iota
+	// DateOrder outputs no parents before all of its children, and otherwise in commit date order
+	DateOrder
+	// AuthorDateOrder outputs no parents before all of its children, and otherwise in author date order
+	AuthorDateOrder
+	// TopoOrder outputs no parents before all of its children, and avoids intermixing multiple lines of history
+	TopoOrder
+)
+
+// Options is a type representing options of the walk.
+type Options struct {
+	Order       Order     // order of commits
+	Reverse     bool      // output commits in reverse order, it is applied after MaxCount and Skip
+	FirstParent bool      // follow only the first parent of merge commits
+	Paths       []string  // output only commits modifying the paths, history is simplified
+	FullHistory bool      // don't prune side branches which are TREESAME for Paths
+	MaxCount    int       // output at most MaxCount commits, 0 means no limit
+	Skip        int       // skip Skip commits before starting to output
+	Since       time.Time // output only commits committed after Since, zero means no limit
+	Until       time.Time // output only commits committed before Until, zero means no limit
+}
+
+// Commit is a type representing a commit which Walker outputs.
+type Commit struct {
+	Digest []byte // SHA1 digest of the commit
+	*object.Commit
+}
+
+// flags of node
+const (
+	seen          = 1 << iota // the node was pushed to the queue
+	processed                 // parents of the node were pushed to the queue
+	uninteresting             // the node is reachable from hidden commits
+	treesame                  // the node doesn't modify the paths
+)
+
+// node is a commit on the walk.
+type node struct {
+	digest      []byte
+	commit      *object.Commit
+	flags       uint
+	parents     []*node  // all parents, loaded when the node is processed
+	followed    []*node  // parents which the walk follows
+	pathDigests [][]byte // digests of Paths in the tree of the commit
+	indegree    int
+}
+
+// slop is the number of extra commits walked after everything in the queue becomes uninteresting,
+// to avoid being misled by clock skew.
+const slop = 5
+
+// Walker is a type to walk commit history.
+type Walker struct {
+	gitDir   string
+	resolver *revparse.Resolver
+	options  Options
+	nodes    map[string]*node
+	tips     []*node
+	queue    *nodeQueue
+	started  bool
+	limited  bool
+	list     []*node
+	skipped  int
+	count    int
+}
+
+// NewWalker creates a Walker of the repository.
+// gitDir of parameters must be path to .git directory, options may be nil.
+func NewWalker(gitDir string, options *Options) (*Walker, error) {
+	resolver, err := revparse.NewResolver(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	w := &Walker{
+		gitDir:   gitDir,
+		resolver: resolver,
+		nodes:    map[string]*node{},
+		queue:    newDateQueue(),
+	}
+	if options != nil {
+		w.options = *options
+	}
+	w.options.Paths = normalizePaths(w.options.Paths)
+	return w, nil
+}
+
+// OpenWalker creates a Walker of current repository.
+func OpenWalker(options *Options) (*Walker, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewWalker(gitDir, options)
+}
+
+// Push adds a starting point of the walk, see package document for accepted forms.
+func (w *Walker) Push(spec string) error {
+	switch {
+	case strings.HasPrefix(spec, "^"):
+		return w.pushRev(spec[1:], true)
+	case strings.Contains(spec, "..."):
+		i := strings.Index(spec, "...")
+		return w.pushSymmetricRange(orHEAD(spec[:i]), orHEAD(spec[i+3:]))
+	case strings.Contains(spec, ".."):
+		i := strings.Index(spec, "..")
+		if err := w.pushRev(orHEAD(spec[:i]), true); err != nil {
+			return err
+		}
+		return w.pushRev(orHEAD(spec[i+2:]), false)
+	case strings.HasSuffix(spec, "^@"):
+		return w.pushParents(spec[:len(spec)-2], false)
+	case strings.HasSuffix(spec, "^!"):
+		if err := w.pushRev(spec[:len(spec)-2], false); err != nil {
+			return err
+		}
+		return w.pushParents(spec[:len(spec)-2], true)
+	}
+	if i := strings.LastIndex(spec, "^-"); i > 0 {
+		n := 1
+		if rest := spec[i+2:]; rest != "" {
+			parsed, err := strconv.Atoi(rest)
+			if err != nil || parsed <= 0 {
+				return ErrInvalidRange
+			}
+			n = parsed
+		}
+		rev := spec[:i]
+		if err := w.pushRev(rev+"^"+strconv.Itoa(n), true); err != nil {
+			return err
+		}
+		return w.pushRev(rev, false)
+	}
+	return w.pushRev(spec, false)
+}
+
+func orHEAD(rev string) string {
+	if rev == "" {
+		return "HEAD"
+	}
+	return rev
+}
+
+func (w *Walker) pushRev(rev string, hide bool) error {
+	digest, err := w.resolver.ResolveCommit(rev)
+	if err != nil {
+		return err
+	}
+	return w.push(digest, hide)
+}
+
+func (w *Walker) pushParents(rev string, hide bool) error {
+	digest, err := w.resolver.ResolveCommit(rev)
+	if err != nil {
+		return err
+	}
+	n, err := w.node(digest)
+	if err != nil {
+		return err
+	}
+	for _, parent := range n.commit.Parents {
+		if err := w.push(parent, hide); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Walker) pushSymmetricRange(left, right string) error {
+	leftDigest, err := w.resolver.ResolveCommit(left)
+	if err != nil {
+		return err
+	}
+	rightDigest, err := w.resolver.ResolveCommit(right)
+	if err != nil {
+		return err
+	}
+	bases, err := w.mergeBases(leftDigest, rightDigest)
+	if err != nil {
+		return err
+	}
+	if err := w.push(leftDigest, false); err != nil {
+		return err
+	}
+	if err := w.push(rightDigest, false); err != nil {
+		return err
+	}
+	for _, base := range bases {
+		if err := w.push(base, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PushDigest adds the commit as a starting point of the walk.
+// a tag is peeled to the commit.
+func (w *Walker) PushDigest(digest []byte) error {
+	return w.pushObject(digest, false)
+}
+
+// Hide excludes commits reachable from the commit.
+// a tag is peeled to the commit.
+func (w *Walker) Hide(digest []byte) error {
+	return w.pushObject(digest, true)
+}
+
+func (w *Walker) pushObject(digest []byte, hide bool) error {
+	peeled, err := w.resolver.Peel(digest, object.CommitObject)
+	if err != nil {
+		return ErrNotCommit
+	}
+	return w.push(peeled, hide)
+}
+
+func (w *Walker) push(digest []byte, hide bool) error {
+	if w.started {
+		return ErrAlreadyWalked
+	}
+	n, err := w.node(digest)
+	if err != nil {
+		return err
+	}
+	if hide {
+		n.flags |= uninteresting
+		w.limited = true
+	}
+	if n.flags&seen == 0 {
+		n.flags |= seen
+		w.tips = append(w.tips, n)
+		w.queue.push(n)
+	}
+	return nil
+}
+
+// node returns the node of the commit, the commit is read only once.
+func (w *Walker) node(digest []byte) (*node, error) {
+	key := hex.EncodeToString(digest)
+	if n, ok := w.nodes[key]; ok {
+		return n, nil
+	}
+	obj, err := object.ReadObjectFrom(w.gitDir, digest)
+	if err != nil {
+		return nil, err
+	}
+	commit, ok := obj.(*object.Commit)
+	if !ok {
+		return nil, ErrNotCommit
+	}
+	n := &node{digest: digest, commit: commit}
+	w.nodes[key] = n
+	return n, nil
+}
+
+// Next returns the next commit of the walk, it returns io.EOF after the last commit.
+func (w *Walker) Next() (*Commit, error) {
+	if !w.started {
+		if err := w.start(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		if w.options.MaxCount > 0 && w.count >= w.options.MaxCount {
+			return nil, io.EOF
+		}
+		n, err := w.nextNode()
+		if err != nil {
+			return nil, err
+		}
+		if w.skipped < w.options.Skip {
+			w.skipped++
+			continue
+		}
+		w.count++
+		return &Commit{Digest: n.digest, Commit: n.commit}, nil
+	}
+}
+
+// All returns all the rest commits of the walk.
+func (w *Walker) All() ([]*Commit, error) {
+	commits := []*Commit{}
+	for {
+		commit, err := w.Next()
+		if err == io.EOF {
+			return commits, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, commit)
+	}
+}
+
+func (w *Walker) start() error {
+	w.started = true
+	if w.options.Order != ChronologicalOrder || w.options.Reverse || len(w.options.Paths) > 0 {
+		w.limited = true
+	}
+	if !w.limited {
+		return nil
+	}
+	if err := w.limit(); err != nil {
+		return err
+	}
+	if w.options.Order != ChronologicalOrder {
+		w.list = w.sortTopologically(w.list)
+	}
+	shown := w.list[:0]
+	for _, n := range w.list {
+		if w.isShown(n) {
+			shown = append(shown, n)
+		}
+	}
+	w.list = shown
+	if w.options.Reverse {
+		w.applyCount()
+		for i, j := 0, len(w.list)-1; i < j; i, j = i+1, j-1 {
+			w.list[i], w.list[j] = w.list[j], w.list[i]
+		}
+	}
+	return nil
+}
+
+// applyCount applies Skip and MaxCount to the limited list before it is reversed.
+func (w *Walker) applyCount() {
+	skip := w.options.Skip
+	if skip > len(w.list) {
+		skip = len(w.list)
+	}
+	w.list = w.list[skip:]
+	if max := w.options.MaxCount; max > 0 && max < len(w.list) {
+		w.list = w.list[:max]
+	}
+	w.options.Skip = 0
+	w.options.MaxCount = 0
+}
+
+func (w *Walker) nextNode() (*node, error) {
+	if w.limited {
+		if len(w.list) == 0 {
+			return nil, io.EOF
+		}
+		n := w.list[0]
+		w.list = w.list[1:]
+		return n, nil
+	}
+	for w.queue.Len() > 0 {
+		n := w.queue.pop()
+		if err := w.process(n); err != nil {
+			return nil, err
+		}
+		if w.isShown(n) {
+			return n, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// limit walks the whole history to be output, and sets it to list in commit date order.
+func (w *Walker) limit() error {
+	extra := slop
+	for w.queue.Len() > 0 {
+		n := w.queue.pop()
+		if err := w.process(n); err != nil {
+			return err
+		}
+		if n.flags&uninteresting == 0 {
+			w.list = append(w.list, n)
+		}
+		if w.everybodyUninteresting() {
+			extra--
+			if extra <= 0 {
+				break
+			}
+		} else {
+			extra = slop
+		}
+	}
+	interesting := w.list[:0]
+	for _, n := range w.list {
+		if n.flags&uninteresting == 0 {
+			interesting = append(interesting, n)
+		}
+	}
+	w.list = interesting
+	return nil
+}
+
+func (w *Walker) everybodyUninteresting() bool {
+	for _, n := range w.queue.nodes {
+		if n.flags&uninteresting == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// process loads parents of the node, and pushes ones to follow to the queue.
+func (w *Walker) process(n *node) error {
+	if n.flags&processed != 0 {
+		return nil
+	}
+	n.flags |= processed
+	n.parents = make([]*node, len(n.commit.Parents))
+	for i, digest := range n.commit.Parents {
+		parent, err := w.node(digest)
+		if err != nil {
+			return err
+		}
+		n.parents[i] = parent
+	}
+	if n.flags&uninteresting != 0 {
+		w.markParentsUninteresting(n)
+		return w.pushAll(n.parents)
+	}
+	n.followed = n.parents
+	if w.options.FirstParent && len(n.followed) > 1 {
+		n.followed = n.followed[:1]
+	}
+	if !w.options.Since.IsZero() && n.commit.Committer.When.Before(w.options.Since) {
+		n.followed = nil
+		return nil
+	}
+	if len(w.options.Paths) > 0 {
+		if err := w.simplify(n); err != nil {
+			return err
+		}
+	}
+	return w.pushAll(n.followed)
+}
+
+func (w *Walker) pushAll(nodes []*node) error {
+	for _, n := range nodes {
+		if n.flags&seen == 0 {
+			n.flags |= seen
+			w.queue.push(n)
+		}
+	}
+	return nil
+}
+
+// markParentsUninteresting propagates uninteresting flag to ancestors already processed.
+func (w *Walker) markParentsUninteresting(n *node) {
+	stack := append([]*node{}, n.parents...)
+	for len(stack) > 0 {
+		last := len(stack) - 1
+		parent := stack[last]
+		stack = stack[:last]
+		if parent.flags&uninteresting != 0 {
+			continue
+		}
+		parent.flags |= uninteresting
+		stack = append(stack, parent.parents...)
+	}
+}
+
+// isShown reports whether the processed node is output.
+func (w *Walker) isShown(n *node) bool {
+	if n.flags&(uninteresting|treesame) != 0 {
+		return false
+	}
+	when := n.commit.Committer.When
+	if !w.options.Since.IsZero() && when.Before(w.options.Since) {
+		return false
+	}
+	if !w.options.Until.IsZero() && when.After(w.options.Until) {
+		return false
+	}
+	return true
+}
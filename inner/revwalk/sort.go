@@ -0,0 +1,71 @@
+package revwalk
+
+// sortTopologically sorts nodes so that no parents come before all of its children.
+// ties are broken by commit date, author date or depth first order according to Order.
+func (w *Walker) sortTopologically(list []*node) []*node {
+	inList := make(map[*node]bool, len(list))
+	for _, n := range list {
+		inList[n] = true
+		n.indegree = 0
+	}
+	for _, n := range list {
+		for _, parent := range n.followed {
+			if inList[parent] {
+				parent.indegree++
+			}
+		}
+	}
+
+	lifo := w.options.Order == TopoOrder
+	stack := []*node{}
+	queue := newDateQueue()
+	if w.options.Order == AuthorDateOrder {
+		queue.less = newerAuthorDate
+	}
+	push := func(n *node) {
+		if lifo {
+			stack = append(stack, n)
+		} else {
+			queue.push(n)
+		}
+	}
+
+	tips := []*node{}
+	for _, n := range list {
+		if n.indegree == 0 {
+			tips = append(tips, n)
+		}
+	}
+	if lifo {
+		// the first tip should be popped first
+		for i := len(tips) - 1; i >= 0; i-- {
+			push(tips[i])
+		}
+	} else {
+		for _, n := range tips {
+			push(n)
+		}
+	}
+
+	sorted := make([]*node, 0, len(list))
+	for len(stack) > 0 || queue.Len() > 0 {
+		var n *node
+		if lifo {
+			n = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		} else {
+			n = queue.pop()
+		}
+		sorted = append(sorted, n)
+		for _, parent := range n.followed {
+			if !inList[parent] {
+				continue
+			}
+			parent.indegree--
+			if parent.indegree == 0 {
+				push(parent)
+			}
+		}
+	}
+	return sorted
+}
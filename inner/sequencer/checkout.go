@@ -0,0 +1,187 @@
+package sequencer
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/shumon84/mogit/inner/ignore"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/worktree"
+)
+
+// hasConflicts reports whether the index has entries at stage 1, 2 or 3.
+func hasConflicts(idx index.Index) (bool, error) {
+	entries, err := worktree.ReadEntries(idx)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.ConflictFlag != index.NoConflict {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// treeIndex returns an index having entries of the files in the tree at stage 0.
+func treeIndex(gitDir string, tree []byte) (index.Index, error) {
+	files, err := worktree.TreeFiles(gitDir, tree)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*index.Entry, 0, len(files))
+	for name, file := range files {
+		entry := &index.Entry{Digest: file.Digest, Name: name}
+		worktree.SetMode(entry, file.Mode)
+		entries = append(entries, entry)
+	}
+	return index.NewIndex(entries), nil
+}
+
+// checkout updates the working tree from the current index to the tree, and writes the next index.
+// the next index has entries of the tree at stage 0, and may have conflicting paths whose files in the tree have
+// conflict markers. files which are changed must not have local changes, and untracked files must not be
+// overwritten unless force. conflicting paths of the current index are overwritten always.
+// stat information of entries is kept for unchanged files, and taken from written files.
+// files of entries having skip-worktree flag are left out of the working tree and keep the flag, unless the
+// next index has conflicts at the path which must be resolved in the working tree.
+func (s *Sequencer) checkout(current index.Index, indexTime time.Time, next index.Index, tree []byte, force bool) error {
+	currentEntries, err := worktree.ReadEntries(current)
+	if err != nil {
+		return err
+	}
+	tracked := map[string]*index.Entry{}
+	unmerged := map[string]bool{}
+	for _, entry := range currentEntries {
+		if entry.ConflictFlag == index.NoConflict {
+			tracked[entry.Name] = entry
+		} else {
+			unmerged[entry.Name] = true
+		}
+	}
+	files, err := worktree.TreeFiles(s.gitDir, tree)
+	if err != nil {
+		return err
+	}
+	sorted := make([]string, 0, len(files)+len(currentEntries))
+	for name := range files {
+		sorted = append(sorted, name)
+	}
+	for _, entry := range currentEntries {
+		if _, ok := files[entry.Name]; !ok && (len(sorted) == 0 || sorted[len(sorted)-1] != entry.Name) {
+			sorted = append(sorted, entry.Name)
+		}
+	}
+	sort.Strings(sorted)
+	nextEntries, err := worktree.ReadEntries(next)
+	if err != nil {
+		return err
+	}
+	conflicting := map[string]bool{}
+	for _, entry := range nextEntries {
+		if entry.ConflictFlag != index.NoConflict {
+			conflicting[entry.Name] = true
+		}
+	}
+
+	w, err := worktree.NewWorktree(s.gitDir, s.workDir, s.config, currentEntries, indexTime)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	matcher, err := ignore.NewMatcher(s.gitDir)
+	if err != nil {
+		return err
+	}
+
+	removed, written := []string{}, []string{}
+	local, untracked := []string{}, []string{}
+	skipped := map[string]bool{}
+	for _, name := range sorted {
+		entry, file := tracked[name], files[name]
+		if entry != nil && file != nil && worktree.IndexMode(entry) == file.Mode && bytes.Equal(entry.Digest, file.Digest) {
+			continue
+		}
+		if entry != nil && entry.SkipWorktree && !conflicting[name] {
+			skipped[name] = true
+			continue
+		}
+		if file == nil {
+			removed = append(removed, name)
+		} else {
+			written = append(written, name)
+		}
+		if force {
+			continue
+		}
+		if entry != nil {
+			clean, err := w.IsClean(entry)
+			if err != nil {
+				return err
+			}
+			if !clean {
+				local = append(local, name)
+			}
+		} else if file != nil && !unmerged[name] {
+			ok, err := w.CanCreate(file, tracked, matcher.IsIgnored)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				untracked = append(untracked, name)
+			}
+		}
+	}
+	if len(local) > 0 {
+		return &PathError{Paths: local, Err: ErrLocalChanges}
+	}
+	if len(untracked) > 0 {
+		return &PathError{Paths: untracked, Err: ErrUntrackedFiles}
+	}
+
+	for i := len(removed) - 1; i >= 0; i-- {
+		if err := w.Remove(removed[i]); err != nil {
+			return err
+		}
+	}
+	stats := map[string]os.FileInfo{}
+	for _, name := range written {
+		info, err := w.Write(files[name])
+		if err != nil {
+			return err
+		}
+		stats[name] = info
+	}
+
+	entries := make([]*index.Entry, 0, len(nextEntries))
+	for _, entry := range nextEntries {
+		file := files[entry.Name]
+		if entry.ConflictFlag != index.NoConflict || file == nil || !bytes.Equal(file.Digest, entry.Digest) {
+			entries = append(entries, entry)
+			continue
+		}
+		if skipped[entry.Name] {
+			entry.SkipWorktree = true
+			entries = append(entries, entry)
+			continue
+		}
+		if info, ok := stats[entry.Name]; ok {
+			fresh, err := index.NewEntry(info, entry.Digest)
+			if err != nil {
+				return err
+			}
+			fresh.Name = entry.Name
+			worktree.SetMode(fresh, worktree.IndexMode(entry))
+			entries = append(entries, fresh)
+			continue
+		}
+		if old := tracked[entry.Name]; old != nil && bytes.Equal(old.Digest, entry.Digest) {
+			entries = append(entries, old)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return index.WriteIndex(s.gitDir, index.NewIndexWithResolveUndo(entries, next.ResolveUndo()))
+}
@@ -0,0 +1,51 @@
+package sequencer
+
+import (
+	"github.com/shumon84/mogit/inner/commit"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/refs"
+)
+
+// commit writes a commit object whose parent is HEAD, and moves HEAD to it with the reflog message.
+// nil head means an unborn branch, and the commit becomes a root commit.
+func (s *Sequencer) commit(tree, head []byte, author, committer *object.Signature, message, reflog string) ([]byte, error) {
+	var parents [][]byte
+	if head != nil {
+		parents = [][]byte{head}
+	}
+	return s.commitParents(tree, head, parents, author, committer, message, reflog)
+}
+
+// commitParents writes a commit object with the parents, and moves HEAD from head to it with the reflog message.
+func (s *Sequencer) commitParents(tree, head []byte, parents [][]byte, author, committer *object.Signature, message, reflog string) ([]byte, error) {
+	commit := &object.Commit{Tree: tree, Parents: parents, Author: author, Committer: committer, Message: message}
+	digest, err := object.WriteObject(s.gitDir, commit)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.updateHead(digest, head, reflog); err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+// updateHead moves HEAD, or the branch which HEAD points to, from head to the digest.
+func (s *Sequencer) updateHead(digest, head []byte, reflog string) error {
+	committer, err := commit.ResolveCommitter(s.config, s.Committer)
+	if err != nil {
+		return err
+	}
+	if head == nil {
+		head = refs.ZeroDigest
+	}
+	return s.refs.Update(refs.HEAD, digest, head, &refs.LogMessage{Committer: committer, Message: reflog})
+}
+
+// author returns the identity of the author from the environment and config, or Committer if it isn't found.
+func (s *Sequencer) author() (*object.Signature, error) {
+	author, err := commit.Identity(s.config, "author")
+	if err == commit.ErrNoIdentity && s.Committer != nil {
+		return s.Committer, nil
+	}
+	return author, err
+}
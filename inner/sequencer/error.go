@@ -0,0 +1,42 @@
+package sequencer
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInProgress       = errors.New("a cherry-pick or revert is already in progress")
+	ErrNoOperation      = errors.New("no cherry-pick or revert in progress")
+	ErrNoCommits        = errors.New("no commits are given")
+	ErrDirtyIndex       = errors.New("your local changes would be overwritten, commit or stash them to proceed")
+	ErrUnmergedPaths    = errors.New("unmerged paths are left in the index")
+	ErrLocalChanges     = errors.New("your local changes to the following files would be overwritten")
+	ErrUntrackedFiles   = errors.New("the following untracked working tree files would be overwritten")
+	ErrMainlineRequired = errors.New("commit is a merge but no mainline is given")
+	ErrInvalidMainline  = errors.New("commit does not have the parent of the mainline")
+	ErrEmptyCommit      = errors.New("nothing to commit, the commit is empty")
+	ErrEmptyMessage     = errors.New("aborting commit due to empty commit message")
+	ErrInvalidTodo      = errors.New("unusable instruction sheet")
+	ErrInvalidOpts      = errors.New("malformed options sheet")
+
+	ErrRebaseInProgress    = errors.New("a rebase is already in progress")
+	ErrNoRebase            = errors.New("no rebase in progress")
+	ErrUnbornBranch        = errors.New("cannot rebase an unborn branch")
+	ErrUnstagedChanges     = errors.New("you have unstaged changes, commit or stash them")
+	ErrUncommittedChanges  = errors.New("your index contains uncommitted changes, commit or stash them")
+	ErrNothingToDo         = errors.New("nothing to do")
+	ErrInvalidLabel        = errors.New("invalid label name")
+	ErrInvalidAuthorScript = errors.New("malformed author script")
+)
+
+// PathError is an error about paths in the working tree, it tells which paths cause the error.
+type PathError struct {
+	Paths []string // paths in the working tree
+	Err   error    // ErrLocalChanges or ErrUntrackedFiles
+}
+
+// Error is implementation of error interface
+func (e *PathError) Error() string {
+	return e.Err.Error() + ": " + strings.Join(e.Paths, ", ")
+}
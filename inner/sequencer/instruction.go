@@ -0,0 +1,286 @@
+package sequencer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+)
+
+// Instruction is a type representing a line of the todo list of rebase like
+//
+//  pick <commit> <subject>
+//  fixup [-C | -c] <commit> <subject>
+//  exec <command>
+//  label <label>
+//  reset <label> [# <oneline>]
+//  merge [-C <commit> | -c <commit>] <label>... [# <oneline>]
+//  update-ref <ref>
+type Instruction struct {
+	Action Action
+	Commit []byte // SHA1 digest of the commit, nil for commands without commits and merge without -C
+
+	// Arg is the rest of the line. it's the subject after the commit, the command of exec,
+	// the label of label and reset, labels of merge and the reference of update-ref.
+	// text after "#" of reset and merge is a comment, and Arg is the whole line of a comment.
+	Arg string
+
+	ReplaceMessage bool // use the message of the commit of fixup instead of the previous one like fixup -C
+	EditMessage    bool // edit the message of fixup and merge like fixup -c and merge -c
+}
+
+// String returns the line of the instruction, the commit is written in full hex digits like git-rebase-todo.
+func (ins *Instruction) String() string {
+	if ins.Action == Comment {
+		return ins.Arg
+	}
+	line := ins.Action.String()
+	switch {
+	case ins.Action == Fixup && ins.EditMessage:
+		line += " -c"
+	case ins.Action == Fixup && ins.ReplaceMessage:
+		line += " -C"
+	case ins.Action == Merge && ins.Commit != nil && ins.EditMessage:
+		line += " -c"
+	case ins.Action == Merge && ins.Commit != nil:
+		line += " -C"
+	}
+	if ins.Commit != nil {
+		line += " " + hex.EncodeToString(ins.Commit)
+	}
+	if ins.Arg != "" {
+		line += " " + ins.Arg
+	}
+	return line
+}
+
+// replaces reports whether the fixup uses the message of its commit, fixup -c also replaces the message.
+func (ins *Instruction) replaces() bool {
+	return ins.Action == Fixup && (ins.ReplaceMessage || ins.EditMessage)
+}
+
+// name returns the first word of Arg, it's the label of label and reset.
+func (ins *Instruction) name() string {
+	if fields := strings.Fields(ins.Arg); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// labels returns labels of merge, they are words of Arg before "#".
+func (ins *Instruction) labels() []string {
+	labels := []string{}
+	for _, field := range strings.Fields(ins.Arg) {
+		if strings.HasPrefix(field, "#") {
+			break
+		}
+		labels = append(labels, field)
+	}
+	return labels
+}
+
+// oneline returns the comment after "#" of Arg, it's the subject of reset and merge.
+func (ins *Instruction) oneline() string {
+	if i := strings.Index(ins.Arg, "#"); i >= 0 {
+		return strings.TrimSpace(ins.Arg[i+1:])
+	}
+	return ""
+}
+
+// isCommand reports whether the action is not a comment, comments aren't counted as commands.
+func isCommand(action Action) bool {
+	return action != Comment
+}
+
+// isFixup reports whether the action melds the commit into the previous commit.
+func isFixup(action Action) bool {
+	return action == Fixup || action == Squash
+}
+
+// needsCommit reports whether the action takes a commit as the argument.
+func needsCommit(action Action) bool {
+	switch action {
+	case Pick, Revert, Edit, Reword, Fixup, Squash, Drop:
+		return true
+	default:
+		return false
+	}
+}
+
+// actionLetters are abbreviations of commands of the todo list
+var actionLetters = map[string]Action{
+	"p": Pick, "e": Edit, "r": Reword, "f": Fixup, "s": Squash, "x": Exec, "b": Break,
+	"l": Label, "t": Reset, "m": Merge, "u": UpdateRef, "d": Drop,
+}
+
+// parseAction returns the action of the command word, the word may be abbreviated to a letter.
+func parseAction(word string) (Action, bool) {
+	if action, ok := actionLetters[word]; ok {
+		return action, true
+	}
+	for i, name := range actionNames {
+		if name == word && Action(i) != Comment {
+			return Action(i), true
+		}
+	}
+	return 0, false
+}
+
+// parseInstructions parses lines of the todo list like parse_insn_line of git.
+// blank lines and lines starting with '#' are comments, and commits are resolved by resolve.
+func parseInstructions(data []byte, resolve func(rev string) ([]byte, error)) ([]*Instruction, error) {
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return []*Instruction{}, nil
+	}
+	todo := []*Instruction{}
+	for _, line := range strings.Split(text, "\n") {
+		ins, err := parseInstruction(strings.TrimSuffix(line, "\r"), resolve)
+		if err != nil {
+			return nil, err
+		}
+		todo = append(todo, ins)
+	}
+	return todo, nil
+}
+
+func parseInstruction(line string, resolve func(rev string) ([]byte, error)) (*Instruction, error) {
+	rest := strings.TrimLeft(line, " \t")
+	if rest == "" || strings.HasPrefix(rest, "#") {
+		return &Instruction{Action: Comment, Arg: rest}, nil
+	}
+	end := strings.IndexAny(rest, " \t")
+	if end < 0 {
+		end = len(rest)
+	}
+	action, ok := parseAction(rest[:end])
+	if !ok {
+		return nil, ErrInvalidTodo
+	}
+	ins := &Instruction{Action: action}
+	rest = rest[end:]
+	padded := rest != "" && strings.IndexAny(rest[:1], " \t") == 0
+	rest = strings.TrimLeft(rest, " \t")
+
+	switch action {
+	case Noop, Break:
+		if rest != "" {
+			return nil, ErrInvalidTodo
+		}
+		return ins, nil
+	case Exec, Label, Reset, UpdateRef:
+		if !padded || rest == "" {
+			return nil, ErrInvalidTodo
+		}
+		ins.Arg = rest
+		return ins, nil
+	case Fixup:
+		if strings.HasPrefix(rest, "-C") {
+			ins.ReplaceMessage, rest = true, strings.TrimLeft(rest[2:], " \t")
+		} else if strings.HasPrefix(rest, "-c") {
+			ins.EditMessage, rest = true, strings.TrimLeft(rest[2:], " \t")
+		}
+	case Merge:
+		if strings.HasPrefix(rest, "-C") {
+			rest = strings.TrimLeft(rest[2:], " \t")
+		} else if strings.HasPrefix(rest, "-c") {
+			ins.EditMessage, rest = true, strings.TrimLeft(rest[2:], " \t")
+		} else {
+			if rest == "" {
+				return nil, ErrInvalidTodo
+			}
+			ins.EditMessage, ins.Arg = true, rest
+			return ins, nil
+		}
+	}
+	if !padded || rest == "" {
+		return nil, ErrInvalidTodo
+	}
+	end = strings.IndexAny(rest, " \t")
+	if end < 0 {
+		end = len(rest)
+	}
+	digest, err := resolve(rest[:end])
+	if err != nil {
+		return nil, ErrInvalidTodo
+	}
+	ins.Commit = digest
+	ins.Arg = strings.TrimLeft(rest[end:], " \t")
+	return ins, nil
+}
+
+// formatInstructions returns lines of the todo list.
+func formatInstructions(todo []*Instruction) []byte {
+	buf := &bytes.Buffer{}
+	for _, ins := range todo {
+		buf.WriteString(ins.String())
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// countCommands returns the number of instructions other than comments.
+func countCommands(todo []*Instruction) int {
+	n := 0
+	for _, ins := range todo {
+		if isCommand(ins.Action) {
+			n++
+		}
+	}
+	return n
+}
+
+// nextCommand returns the action of the first instruction other than comments, or Noop if there is nothing.
+func nextCommand(todo []*Instruction) Action {
+	for _, ins := range todo {
+		if isCommand(ins.Action) {
+			return ins.Action
+		}
+	}
+	return Noop
+}
+
+// isFinalFixup reports whether the rest of the todo list doesn't continue the chain of fixup and squash.
+// dropped commits and comments are skipped like is_final_fixup of git.
+func isFinalFixup(rest []*Instruction) bool {
+	for _, ins := range rest {
+		switch {
+		case isFixup(ins.Action):
+			return false
+		case ins.Action != Comment && ins.Action != Drop:
+			return true
+		}
+	}
+	return true
+}
+
+// todoHelp is the help following the line "# Rebase <range> onto <onto> (<n> commands)" in git-rebase-todo.backup.
+const todoHelp = `#
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup [-C | -c] <commit> = like "squash" but keep only the previous
+#                    commit's log message, unless -C is used, in which case
+#                    keep only this commit's message; -c is same as -C but
+#                    opens the editor
+# x, exec <command> = run command (the rest of the line) using shell
+# b, break = stop here (continue rebase later with 'git rebase --continue')
+# d, drop <commit> = remove commit
+# l, label <label> = label current HEAD with a name
+# t, reset <label> = reset HEAD to a label
+# m, merge [-C <commit> | -c <commit>] <label> [# <oneline>]
+#         create a merge commit using the original merge commit's
+#         message (or the oneline, if no original merge commit was
+#         specified); use -c <commit> to reword the commit message
+# u, update-ref <ref> = track a placeholder for the <ref> to be updated
+#                       to this position in the new commits. The <ref> is
+#                       updated at the end of the rebase
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+#
+# If you remove a line here THAT COMMIT WILL BE LOST.
+#
+# However, if you remove everything, the rebase will be aborted.
+#
+`
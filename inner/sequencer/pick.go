@@ -0,0 +1,379 @@
+package sequencer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/shumon84/mogit/inner/commit"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/merge"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/refs"
+	"github.com/shumon84/mogit/inner/rerere"
+	"github.com/shumon84/mogit/inner/worktree"
+)
+
+// head returns the digest of the commit which HEAD points to and the commit, they are nil if HEAD is unborn.
+func (s *Sequencer) head() ([]byte, *object.Commit, error) {
+	ref, err := s.refs.Resolve(refs.HEAD)
+	if err == refs.ErrRefNotFound {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	commit, err := s.readCommit(ref.Digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ref.Digest, commit, nil
+}
+
+func (s *Sequencer) readCommit(digest []byte) (*object.Commit, error) {
+	obj, err := object.ReadObjectFrom(s.gitDir, digest)
+	if err != nil {
+		return nil, err
+	}
+	commit, ok := obj.(*object.Commit)
+	if !ok {
+		return nil, object.ErrUnexpectedType
+	}
+	return commit, nil
+}
+
+// treeOf returns the tree of the commit, nil commit means the empty tree.
+func (s *Sequencer) treeOf(commit *object.Commit) ([]byte, error) {
+	if commit == nil {
+		return object.NewTree(nil).SHA1()
+	}
+	return commit.Tree, nil
+}
+
+// matchesHead reports whether the index has the same entries as the tree of HEAD, nil commit means an unborn branch.
+func (s *Sequencer) matchesHead(idx index.Index, commit *object.Commit) (bool, error) {
+	var tree []byte
+	if commit != nil {
+		tree = commit.Tree
+	}
+	files, err := worktree.TreeFiles(s.gitDir, tree)
+	if err != nil {
+		return false, err
+	}
+	entries, err := worktree.ReadEntries(idx)
+	if err != nil {
+		return false, err
+	}
+	if len(entries) != len(files) {
+		return false, nil
+	}
+	for _, entry := range entries {
+		file, ok := files[entry.Name]
+		if !ok || entry.ConflictFlag != index.NoConflict || worktree.IndexMode(entry) != file.Mode || !bytes.Equal(entry.Digest, file.Digest) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// parentOf returns the parent of the commit whose changes are picked, nil means the commit is a root commit.
+func parentOf(commit *object.Commit, mainline int) ([]byte, error) {
+	switch {
+	case len(commit.Parents) > 1 && mainline == 0:
+		return nil, ErrMainlineRequired
+	case mainline > len(commit.Parents) || mainline < 0:
+		return nil, ErrInvalidMainline
+	case mainline > 0:
+		return commit.Parents[mainline-1], nil
+	case len(commit.Parents) == 1:
+		return commit.Parents[0], nil
+	default:
+		return nil, nil
+	}
+}
+
+// pick does the action of the item like do_pick_commit of git.
+// it returns true if the sequence must stop because of conflicts or an empty commit.
+func (s *Sequencer) pick(it *item, options *Options, result *Result) (bool, error) {
+	head, headCommit, err := s.head()
+	if err != nil {
+		return false, err
+	}
+	headTree, err := s.treeOf(headCommit)
+	if err != nil {
+		return false, err
+	}
+	idx, indexTime, err := worktree.ReadIndex(s.gitDir)
+	if err != nil {
+		return false, err
+	}
+	// changes are piled up in the index without committing
+	ours := headTree
+	if options.NoCommit {
+		if ours, err = commit.WriteTree(s.gitDir, idx); err != nil {
+			return false, err
+		}
+	} else if clean, err := s.matchesHead(idx, headCommit); err != nil || !clean {
+		if err == nil {
+			err = ErrDirtyIndex
+		}
+		return false, err
+	}
+
+	picked, err := s.readCommit(it.digest)
+	if err != nil {
+		return false, err
+	}
+	parent, err := parentOf(picked, options.Mainline)
+	if err != nil {
+		return false, err
+	}
+	if options.AllowFastForward && it.action == Pick && !options.NoCommit && bytes.Equal(parent, head) {
+		return false, s.fastForward(it.digest, picked, head, idx, indexTime, result)
+	}
+
+	committer, err := commit.ResolveCommitter(s.config, s.Committer)
+	if err != nil {
+		return false, err
+	}
+	abbrev := s.abbrev(it.digest)
+	label := abbrev + " (" + picked.Summary() + ")"
+	parentLabel := "parent of " + label
+	var base, next []byte
+	var baseLabel, nextLabel, message string
+	if it.action == Revert {
+		base, next, baseLabel, nextLabel = it.digest, parent, label, parentLabel
+		message = revertMessage(picked, it.digest, parent)
+	} else {
+		base, next, baseLabel, nextLabel = parent, it.digest, parentLabel, label
+		message = commit.CompleteLine(picked.Message)
+		if options.RecordOrigin {
+			if !commit.HasTrailers(message) {
+				message += "\n"
+			}
+			message += "(cherry picked from commit " + hex.EncodeToString(it.digest) + ")\n"
+		}
+	}
+	if options.Signoff {
+		message = commit.AppendTrailer(message, commit.Signoff(committer))
+	}
+
+	mergeOptions, err := newMergeOptions(options.Strategy, options.StrategyOptions)
+	if err != nil {
+		return false, err
+	}
+	mergeOptions.AncestorLabel = baseLabel
+	mergeOptions.OurLabel = "HEAD"
+	mergeOptions.TheirLabels = []string{nextLabel}
+	merged, err := merge.MergeTrees(s.gitDir, base, ours, next, mergeOptions)
+	if err != nil {
+		return false, err
+	}
+	if err := s.checkout(idx, indexTime, merged.Index, merged.Tree, false); err != nil {
+		return false, err
+	}
+	result.Messages = append(result.Messages, merged.Messages...)
+
+	if !merged.Clean {
+		names, err := conflictingPaths(merged.Index)
+		if err != nil {
+			return false, err
+		}
+		message += "\n# Conflicts:\n"
+		for _, name := range names {
+			message += "#\t" + name + "\n"
+		}
+	}
+	if err := s.writeFile(commit.MergeMsg, []byte(message)); err != nil {
+		return false, err
+	}
+	if !merged.Clean {
+		if !options.NoCommit {
+			if err := s.writePickHead(it); err != nil {
+				return false, err
+			}
+		}
+		verb := "could not apply"
+		if it.action == Revert {
+			verb = "could not revert"
+		}
+		result.Messages = append(result.Messages, fmt.Sprintf("error: %s %s... %s", verb, abbrev, picked.Summary()))
+		r, err := rerere.NewRerere(s.gitDir)
+		if err != nil {
+			return false, err
+		}
+		replayed, err := r.Run()
+		if err != nil {
+			return false, err
+		}
+		result.Messages = append(result.Messages, replayed.Messages...)
+		result.Conflicts = true
+		return true, nil
+	}
+	if options.NoCommit {
+		return false, nil
+	}
+
+	if bytes.Equal(merged.Tree, headTree) {
+		var parentCommit *object.Commit
+		if parent != nil {
+			if parentCommit, err = s.readCommit(parent); err != nil {
+				return false, err
+			}
+		}
+		parentTree, err := s.treeOf(parentCommit)
+		if err != nil {
+			return false, err
+		}
+		originallyEmpty := bytes.Equal(picked.Tree, parentTree)
+		if !options.KeepRedundantCommits && !(options.AllowEmpty && originallyEmpty) {
+			if err := s.writePickHead(it); err != nil {
+				return false, err
+			}
+			result.Messages = append(result.Messages, "The previous cherry-pick is now empty, possibly due to conflict resolution.")
+			result.Empty = true
+			return true, nil
+		}
+	}
+
+	author := picked.Author
+	if it.action == Revert {
+		if author, err = s.author(); err != nil {
+			return false, err
+		}
+	}
+	digest, err := s.commit(merged.Tree, head, author, committer, message, it.action.name()+": "+commit.FirstLine(message))
+	if err != nil {
+		return false, err
+	}
+	result.Commits = append(result.Commits, digest)
+	return false, s.removePickState()
+}
+
+// fastForward moves HEAD to the commit, and updates the index and the working tree.
+func (s *Sequencer) fastForward(digest []byte, commit *object.Commit, head []byte, idx index.Index, indexTime time.Time, result *Result) error {
+	next, err := treeIndex(s.gitDir, commit.Tree)
+	if err != nil {
+		return err
+	}
+	if err := s.checkout(idx, indexTime, next, commit.Tree, false); err != nil {
+		return err
+	}
+	if err := s.updateHead(digest, head, "cherry-pick: fast-forward"); err != nil {
+		return err
+	}
+	result.Commits = append(result.Commits, digest)
+	return nil
+}
+
+// writePickHead writes CHERRY_PICK_HEAD or REVERT_HEAD having the digest of the commit of the item.
+func (s *Sequencer) writePickHead(it *item) error {
+	name := commit.CherryPickHead
+	if it.action == Revert {
+		name = commit.RevertHead
+	}
+	return s.writeFile(name, []byte(hex.EncodeToString(it.digest)+"\n"))
+}
+
+// conflictingPaths returns paths having entries at stage 1, 2 or 3 in name order.
+func conflictingPaths(idx index.Index) ([]string, error) {
+	entries, err := worktree.ReadEntries(idx)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.ConflictFlag != index.NoConflict && (len(names) == 0 || names[len(names)-1] != entry.Name) {
+			names = append(names, entry.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// revertMessage returns the message of the commit reverting the commit like
+//
+//  Revert "<subject>"
+//
+//  This reverts commit <digest>.
+func revertMessage(commit *object.Commit, digest, parent []byte) string {
+	message := "Revert \"" + commit.Summary() + "\"\n\nThis reverts commit " + hex.EncodeToString(digest)
+	if len(commit.Parents) > 1 {
+		message += ", reversing\nchanges made to " + hex.EncodeToString(parent)
+	}
+	return message + ".\n"
+}
+
+// commitResolution commits the index as the resolution of the commit in CHERRY_PICK_HEAD or REVERT_HEAD.
+// the author of a cherry-picked commit is kept, and comments in MERGE_MSG are stripped.
+func (s *Sequencer) commitResolution(name string, options *Options, result *Result) error {
+	idx, _, err := worktree.ReadIndex(s.gitDir)
+	if err != nil {
+		return err
+	}
+	if conflicts, err := hasConflicts(idx); err != nil || conflicts {
+		if err == nil {
+			err = ErrUnmergedPaths
+		}
+		return err
+	}
+	tree, err := commit.WriteTree(s.gitDir, idx)
+	if err != nil {
+		return err
+	}
+	head, headCommit, err := s.head()
+	if err != nil {
+		return err
+	}
+	headTree, err := s.treeOf(headCommit)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(tree, headTree) {
+		return ErrEmptyCommit
+	}
+	digest, err := s.readDigestFile(name)
+	if err != nil {
+		return err
+	}
+	original, err := s.readCommit(digest)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(s.path(commit.MergeMsg))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	message := commit.Stripspace(string(data), commit.CommentChar(s.config))
+	if message == "" {
+		return ErrEmptyMessage
+	}
+	committer, err := commit.ResolveCommitter(s.config, s.Committer)
+	if err != nil {
+		return err
+	}
+	author, reflog := original.Author, "commit (cherry-pick): "
+	if name == commit.RevertHead {
+		if author, err = s.author(); err != nil {
+			return err
+		}
+		reflog = "commit: "
+	}
+	created, err := s.commit(tree, head, author, committer, message, reflog+commit.FirstLine(message))
+	if err != nil {
+		return err
+	}
+	result.Commits = append(result.Commits, created)
+	r, err := rerere.NewRerere(s.gitDir)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Run(); err != nil {
+		return err
+	}
+	return s.removePickState()
+}
@@ -0,0 +1,502 @@
+package sequencer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/commit"
+	"github.com/shumon84/mogit/inner/merge"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/refs"
+	"github.com/shumon84/mogit/inner/revparse"
+	"github.com/shumon84/mogit/inner/revwalk"
+	"github.com/shumon84/mogit/inner/util"
+	"github.com/shumon84/mogit/inner/worktree"
+)
+
+// RebaseOptions is a type representing options of rebasing.
+type RebaseOptions struct {
+	Upstream string // revision of the upstream, "@{upstream}" if it's empty
+	Onto     string // revision of the new base like --onto <newbase>, Upstream if it's empty
+	Branch   string // the branch rebased, it's checked out first. the current branch if it's empty
+
+	ForceRebase  bool     // replay commits even if they can be fast-forwarded like --force-rebase
+	Autosquash   bool     // move fixup!, squash! and amend! commits like --autosquash
+	UpdateRefs   bool     // update branches pointing to rebased commits like --update-refs
+	RebaseMerges bool     // recreate merge commits with label, reset and merge like --rebase-merges
+	Exec         []string // commands run after each commit like --exec <cmd>
+
+	Strategy        merge.Strategy // merge strategy like --strategy
+	StrategyOptions []string       // options of the strategy like -X<option>
+
+	// Todo edits the todo list instead of the editor of $ git rebase --interactive.
+	// it receives instructions with comments, and the rebase is aborted if no commands are returned.
+	Todo func(todo []*Instruction) ([]*Instruction, error)
+}
+
+// Rebase is a type to rebase commits in the repository.
+type Rebase struct {
+	s        *Sequencer
+	resolver *revparse.Resolver
+
+	// Committer is handed to the underlying Sequencer, so rewritten commits and reflog entries of the rebase are
+	// signed by it. nil leaves the choice to the environment and config.
+	Committer *object.Signature
+
+	// Editor edits messages of reword, squash and commits resolving conflicts, and returns the edited message.
+	// messages are committed without editing if it's nil.
+	Editor func(message string) (string, error)
+
+	// Output is where outputs of commands of exec are written, they are discarded if it's nil.
+	Output io.Writer
+}
+
+// NewRebase creates a Rebase of the repository.
+// gitDir of parameters must be path to .git directory.
+func NewRebase(gitDir string) (*Rebase, error) {
+	s, err := NewSequencer(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := revparse.NewResolver(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Rebase{s: s, resolver: resolver}, nil
+}
+
+// OpenRebase creates a Rebase of current repository.
+func OpenRebase() (*Rebase, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewRebase(gitDir)
+}
+
+// InProgress reports whether a rebase is in progress.
+func (r *Rebase) InProgress() bool {
+	return r.inRebase()
+}
+
+// Start rebases commits of the branch which aren't in the upstream onto the new base like $ git rebase
+// options may be nil. the rebase stops at conflicts, edit, break and failed exec, and it's resumed by Continue.
+func (r *Rebase) Start(options *RebaseOptions) (*Result, error) {
+	if options == nil {
+		options = &RebaseOptions{}
+	}
+	r.s.Committer = r.Committer
+	if r.inRebase() {
+		return nil, ErrRebaseInProgress
+	}
+	if r.s.InProgress() {
+		return nil, ErrInProgress
+	}
+	upstreamName := options.Upstream
+	if upstreamName == "" {
+		upstreamName = "@{upstream}"
+	}
+	upstream, err := r.resolver.ResolveCommit(upstreamName)
+	if err != nil {
+		return nil, err
+	}
+	ontoName, onto := upstreamName, upstream
+	if options.Onto != "" {
+		ontoName = options.Onto
+		if onto, err = r.resolver.ResolveCommit(options.Onto); err != nil {
+			return nil, err
+		}
+	}
+	current, err := r.s.refs.Read(refs.HEAD)
+	if err != nil && err != refs.ErrRefNotFound {
+		return nil, err
+	}
+	currentName := detachedHead
+	if current != nil && current.IsSymbolic() {
+		currentName = current.Target
+	}
+	headName, head, err := r.branch(options.Branch, currentName)
+	if err != nil {
+		return nil, err
+	}
+	result := newResult()
+
+	upToDate, err := r.isUpToDate(upstream, onto, head, options)
+	if err != nil {
+		return nil, err
+	}
+	if upToDate {
+		if options.Branch != "" && headName != currentName {
+			if err := r.switchBranch(headName, head, options.Branch); err != nil {
+				return nil, err
+			}
+		} else if err := r.checkClean(); err != nil {
+			return nil, err
+		}
+		if headName == detachedHead {
+			result.Messages = append(result.Messages, "HEAD is up to date.")
+		} else {
+			result.Messages = append(result.Messages, "Current branch "+strings.TrimPrefix(headName, refs.HeadPrefix)+" is up to date.")
+		}
+		return result, nil
+	}
+	if err := r.checkClean(); err != nil {
+		return nil, err
+	}
+
+	todo, err := r.makeTodo(upstream, head, options, result)
+	if err != nil {
+		return nil, err
+	}
+	updates := []*updateRef{}
+	if options.UpdateRefs {
+		if todo, updates, err = r.addUpdateRefs(todo, headName); err != nil {
+			return nil, err
+		}
+	}
+	if options.Autosquash {
+		if todo, err = r.rearrangeSquash(todo); err != nil {
+			return nil, err
+		}
+	}
+	todo = addExecCommands(todo, options.Exec)
+	if countCommands(todo) == 0 {
+		todo = append(todo, &Instruction{Action: Noop})
+	}
+	backup := r.backupTodo(todo, upstream, head, onto)
+	if options.Todo != nil {
+		if todo, err = options.Todo(todo); err != nil {
+			return nil, err
+		}
+	}
+	todo = stripComments(todo)
+	if countCommands(todo) == 0 {
+		return nil, ErrNothingToDo
+	}
+
+	st := &rebaseState{
+		headName:         headName,
+		onto:             onto,
+		origHead:         head,
+		strategy:         options.Strategy,
+		strategyOptions:  options.StrategyOptions,
+		todo:             todo,
+		total:            countCommands(todo),
+		action:           "rebase",
+		allowFastForward: !options.ForceRebase,
+	}
+	switch {
+	case options.Todo != nil:
+		st.empty = emptyAsk
+	case len(options.Exec) > 0:
+		st.empty = emptyKeep
+	default:
+		st.empty = emptyDrop
+	}
+	if err := r.saveState(st); err != nil {
+		return nil, err
+	}
+	if err := r.writeState("git-rebase-todo.backup", backup); err != nil {
+		return nil, err
+	}
+	if len(updates) > 0 {
+		if err := r.writeUpdateRefs(updates); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.s.refs.Update(refs.OrigHEAD, head, nil, nil); err != nil {
+		return nil, err
+	}
+
+	base := onto
+	if !options.ForceRebase {
+		if base, err = r.skipUnnecessaryPicks(st, onto); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.saveTodo(st.todo, nil); err != nil {
+		return nil, err
+	}
+	if err := r.writeCount("end", st.total); err != nil {
+		return nil, err
+	}
+	if err := r.checkoutOnto(base, "rebase (start): checkout "+ontoName); err != nil {
+		return nil, err
+	}
+	return r.run(st, result)
+}
+
+// branch returns the name and the digest of the branch rebased, the name is detachedHead if it isn't a branch.
+func (r *Rebase) branch(name, currentName string) (string, []byte, error) {
+	if name == "" {
+		head, _, err := r.s.head()
+		if err != nil {
+			return "", nil, err
+		}
+		if head == nil {
+			return "", nil, ErrUnbornBranch
+		}
+		return currentName, head, nil
+	}
+	if ref, err := r.s.refs.Resolve(refs.HeadPrefix + name); err == nil {
+		return ref.Name, ref.Digest, nil
+	} else if err != refs.ErrRefNotFound {
+		return "", nil, err
+	}
+	digest, err := r.resolver.ResolveCommit(name)
+	if err != nil {
+		return "", nil, err
+	}
+	return detachedHead, digest, nil
+}
+
+// isUpToDate reports whether the branch needn't be rebased like can_fast_forward of git.
+// it's true if onto is the only merge base of onto and head, and also of upstream and head.
+func (r *Rebase) isUpToDate(upstream, onto, head []byte, options *RebaseOptions) (bool, error) {
+	if options.ForceRebase || options.Todo != nil || len(options.Exec) > 0 {
+		return false, nil
+	}
+	for _, commit := range [][]byte{onto, upstream} {
+		bases, err := revwalk.MergeBases(r.s.gitDir, commit, head)
+		if err != nil {
+			return false, err
+		}
+		if len(bases) != 1 || !bytes.Equal(bases[0], onto) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// switchBranch checks out the branch like $ git switch, HEAD is attached to the branch if it's a branch.
+func (r *Rebase) switchBranch(headName string, head []byte, name string) error {
+	if err := r.checkClean(); err != nil {
+		return err
+	}
+	if err := r.checkoutCommit(head, false); err != nil {
+		return err
+	}
+	committer, err := commit.ResolveCommitter(r.s.config, r.s.Committer)
+	if err != nil {
+		return err
+	}
+	log := &refs.LogMessage{Committer: committer, Message: "rebase: checkout " + name}
+	if headName == detachedHead {
+		return r.s.refs.Detach(refs.HEAD, head, nil, log)
+	}
+	return r.s.refs.UpdateSymbolic(refs.HEAD, headName, log)
+}
+
+// checkClean returns an error if the index or the working tree has changes from HEAD.
+func (r *Rebase) checkClean() error {
+	_, headCommit, err := r.s.head()
+	if err != nil {
+		return err
+	}
+	idx, indexTime, err := worktree.ReadIndex(r.s.gitDir)
+	if err != nil {
+		return err
+	}
+	if unstaged, err := r.hasUnstagedChanges(idx, indexTime); err != nil || unstaged {
+		if err == nil {
+			err = ErrUnstagedChanges
+		}
+		return err
+	}
+	if clean, err := r.s.matchesHead(idx, headCommit); err != nil || !clean {
+		if err == nil {
+			err = ErrUncommittedChanges
+		}
+		return err
+	}
+	return nil
+}
+
+// backupTodo returns contents of git-rebase-todo.backup, it's the todo list with the help.
+func (r *Rebase) backupTodo(todo []*Instruction, upstream, head, onto []byte) []byte {
+	buf := bytes.NewBuffer(formatInstructions(todo))
+	n := countCommands(todo)
+	plural := "s"
+	if n == 1 {
+		plural = ""
+	}
+	fmt.Fprintf(buf, "\n# Rebase %s..%s onto %s (%d command%s)\n", r.s.abbrev(upstream), r.s.abbrev(head), r.s.abbrev(onto), n, plural)
+	buf.WriteString(todoHelp)
+	return buf.Bytes()
+}
+
+// stripComments removes comments from the todo list, git doesn't keep them once the rebase starts.
+func stripComments(todo []*Instruction) []*Instruction {
+	stripped := make([]*Instruction, 0, len(todo))
+	for _, ins := range todo {
+		if isCommand(ins.Action) {
+			stripped = append(stripped, ins)
+		}
+	}
+	return stripped
+}
+
+// skipUnnecessaryPicks moves picks which are already on the base to the done list like skip_unnecessary_picks of git.
+// it returns the new base.
+func (r *Rebase) skipUnnecessaryPicks(st *rebaseState, base []byte) ([]byte, error) {
+	i := 0
+	for ; i < len(st.todo); i++ {
+		ins := st.todo[i]
+		if ins.Action == Noop || ins.Action == Drop {
+			continue
+		}
+		if ins.Action != Pick {
+			break
+		}
+		commit, err := r.s.readCommit(ins.Commit)
+		if err != nil {
+			return nil, err
+		}
+		if len(commit.Parents) == 0 || !bytes.Equal(commit.Parents[0], base) {
+			break
+		}
+		base = ins.Commit
+	}
+	if i == 0 {
+		return base, nil
+	}
+	if err := r.appendState("done", formatInstructions(st.todo[:i])); err != nil {
+		return nil, err
+	}
+	st.todo = st.todo[i:]
+	st.done += i
+	if isFixup(nextCommand(st.todo)) {
+		if err := r.recordRewritten(base, nextCommand(st.todo)); err != nil {
+			return nil, err
+		}
+	}
+	return base, nil
+}
+
+// checkoutOnto detaches HEAD at the commit, and checks it out.
+func (r *Rebase) checkoutOnto(digest []byte, reflog string) error {
+	if err := r.checkoutCommit(digest, false); err != nil {
+		return err
+	}
+	committer, err := commit.ResolveCommitter(r.s.config, r.s.Committer)
+	if err != nil {
+		return err
+	}
+	return r.s.refs.Detach(refs.HEAD, digest, nil, &refs.LogMessage{Committer: committer, Message: reflog})
+}
+
+// Todo returns instructions not done yet of the rebase in progress.
+func (r *Rebase) Todo() ([]*Instruction, error) {
+	if !r.inRebase() {
+		return nil, ErrNoRebase
+	}
+	return r.readTodo()
+}
+
+// SetTodo replaces instructions not done yet of the rebase in progress like $ git rebase --edit-todo
+func (r *Rebase) SetTodo(todo []*Instruction) error {
+	if !r.inRebase() {
+		return ErrNoRebase
+	}
+	st, err := r.loadState()
+	if err != nil {
+		return err
+	}
+	todo = stripComments(todo)
+	if err := r.saveTodo(todo, nil); err != nil {
+		return err
+	}
+	return r.writeCount("end", st.done+countCommands(todo))
+}
+
+// Continue commits changes of the stopped command, and resumes the rebase like $ git rebase --continue
+// the working tree must not have unstaged changes. staged changes are committed with the message of the stopped
+// commit, and they are amended to HEAD if the rebase stopped at edit or fixup.
+func (r *Rebase) Continue() (*Result, error) {
+	r.s.Committer = r.Committer
+	if !r.inRebase() {
+		return nil, ErrNoRebase
+	}
+	st, err := r.loadState()
+	if err != nil {
+		return nil, err
+	}
+	result := newResult()
+	if err := r.commitStaged(st, result); err != nil {
+		return nil, err
+	}
+	return r.run(st, result)
+}
+
+// Skip drops changes of the stopped command, and resumes the rebase like $ git rebase --skip
+func (r *Rebase) Skip() (*Result, error) {
+	r.s.Committer = r.Committer
+	if !r.inRebase() {
+		return nil, ErrNoRebase
+	}
+	st, err := r.loadState()
+	if err != nil {
+		return nil, err
+	}
+	head, _, err := r.s.head()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkoutCommit(head, true); err != nil {
+		return nil, err
+	}
+	if err := r.removeFiles(commit.MergeMsg, refs.MergeHEAD, commit.CherryPickHead, RebaseHead, commit.AutoMerge, "MERGE_RR", rebasePath("stopped-sha")); err != nil {
+		return nil, err
+	}
+	result := newResult()
+	if err := r.commitStaged(st, result); err != nil {
+		return nil, err
+	}
+	return r.run(st, result)
+}
+
+// Abort resets HEAD, the index and the working tree to the branch where the rebase started,
+// and removes the state like $ git rebase --abort
+func (r *Rebase) Abort() (*Result, error) {
+	r.s.Committer = r.Committer
+	if !r.inRebase() {
+		return nil, ErrNoRebase
+	}
+	st, err := r.loadState()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkoutCommit(st.origHead, true); err != nil {
+		return nil, err
+	}
+	committer, err := commit.ResolveCommitter(r.s.config, r.s.Committer)
+	if err != nil {
+		return nil, err
+	}
+	if st.headName == detachedHead {
+		err = r.s.refs.Detach(refs.HEAD, st.origHead, nil, &refs.LogMessage{
+			Committer: committer,
+			Message:   "rebase (abort): returning to " + hex.EncodeToString(st.origHead),
+		})
+	} else {
+		err = r.s.refs.UpdateSymbolic(refs.HEAD, st.headName, &refs.LogMessage{
+			Committer: committer,
+			Message:   "rebase (abort): returning to " + st.headName,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.removeFiles(commit.MergeMsg, refs.MergeHEAD, commit.CherryPickHead, RebaseHead, commit.SquashMsg, commit.AutoMerge, "MERGE_RR"); err != nil {
+		return nil, err
+	}
+	return newResult(), r.removeRebaseState()
+}
@@ -0,0 +1,979 @@
+package sequencer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/shumon84/mogit/inner/commit"
+	"github.com/shumon84/mogit/inner/diff"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/merge"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/refs"
+	"github.com/shumon84/mogit/inner/rerere"
+	"github.com/shumon84/mogit/inner/worktree"
+	"github.com/shumon84/mogit/inner/xdiff"
+)
+
+// rewrittenPrefix is the prefix of references of labels.
+const rewrittenPrefix = "refs/rewritten/"
+
+// newRoot is the argument of reset which starts a new root commit.
+const newRoot = "[new root]"
+
+// run does instructions of the todo list in order like pick_commits of git, and finishes the rebase when all
+// instructions are done. an instruction failing with an error is put back to the todo list.
+func (r *Rebase) run(st *rebaseState, result *Result) (*Result, error) {
+	for len(st.todo) > 0 {
+		ins := st.todo[0]
+		st.todo = st.todo[1:]
+		if err := r.saveTodo(st.todo, ins); err != nil {
+			return nil, err
+		}
+		if isCommand(ins.Action) {
+			st.done++
+			if err := r.writeCount("msgnum", st.done); err != nil {
+				return nil, err
+			}
+		}
+		if err := r.removeFiles(rebasePath("message"), rebasePath("author-script"), rebasePath("stopped-sha"),
+			rebasePath("amend"), refs.MergeHEAD, commit.AutoMerge, RebaseHead); err != nil {
+			return nil, err
+		}
+		stopped, err := r.do(ins, st, result)
+		if err != nil {
+			if ins.Action != Exec {
+				// reschedule the instruction
+				st.todo = append([]*Instruction{ins}, st.todo...)
+				if err := r.saveTodo(st.todo, nil); err != nil {
+					return nil, err
+				}
+			}
+			return nil, err
+		}
+		if stopped {
+			return result, nil
+		}
+	}
+	return result, r.finish(st, result)
+}
+
+// do does the instruction, it returns true if the rebase must stop.
+func (r *Rebase) do(ins *Instruction, st *rebaseState, result *Result) (bool, error) {
+	switch ins.Action {
+	case Pick, Revert, Edit, Reword, Fixup, Squash:
+		return r.doPick(ins, st, result)
+	case Exec:
+		return r.doExec(ins, result)
+	case Break:
+		return true, r.stopAtHead(result)
+	case Label:
+		return false, r.doLabel(ins)
+	case Reset:
+		return false, r.doReset(ins)
+	case Merge:
+		return r.doMerge(ins, st, result)
+	case UpdateRef:
+		return false, r.doUpdateRef(ins)
+	default:
+		return false, nil
+	}
+}
+
+// newMergeOptions returns options of the tree merge engine with the strategy and its options.
+func newMergeOptions(strategy merge.Strategy, strategyOptions []string) (*merge.Options, error) {
+	options := &merge.Options{Strategy: strategy}
+	for _, option := range strategyOptions {
+		if err := options.ParseStrategyOption(option); err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
+
+// doPick applies changes of the commit of the instruction to HEAD like do_pick_commit of git.
+// fixup and squash amend HEAD, and the message of the last one of the chain is made from messages of the chain.
+func (r *Rebase) doPick(ins *Instruction, st *rebaseState, result *Result) (bool, error) {
+	s := r.s
+	st.action = "rebase (" + ins.Action.String() + ")"
+	head, headCommit, err := s.head()
+	if err != nil {
+		return false, err
+	}
+	original, err := s.readCommit(ins.Commit)
+	if err != nil {
+		return false, err
+	}
+	parent, err := parentOf(original, 0)
+	if err != nil {
+		return false, err
+	}
+	if err := r.writeAuthorScript(original.Author); err != nil {
+		return false, err
+	}
+	if st.allowFastForward && ins.Action != Revert && !isFixup(ins.Action) && parent != nil && bytes.Equal(parent, head) {
+		if err := r.fastForward(ins.Commit, head); err != nil {
+			return false, err
+		}
+		result.Commits = append(result.Commits, ins.Commit)
+		if ins.Action == Reword {
+			if err := r.reword(st, result); err != nil {
+				return false, err
+			}
+		}
+		return r.picked(ins, original, st, result)
+	}
+
+	headTree, err := s.treeOf(headCommit)
+	if err != nil {
+		return false, err
+	}
+	idx, indexTime, err := worktree.ReadIndex(s.gitDir)
+	if err != nil {
+		return false, err
+	}
+	final := isFinalFixup(st.todo)
+	if isFixup(ins.Action) {
+		if err := r.updateSquashMessages(ins, original, headCommit, st); err != nil {
+			return false, err
+		}
+		// the message of the chain without fixup -C is edited in .git/SQUASH_MSG
+		if final && !r.existsState("message-fixup") {
+			data, err := r.readState("message-squash")
+			if err != nil {
+				return false, err
+			}
+			if err := s.writeFile(commit.SquashMsg, data); err != nil {
+				return false, err
+			}
+			if err := r.removeFiles(commit.MergeMsg); err != nil {
+				return false, err
+			}
+		}
+	}
+	abbrev := s.abbrev(ins.Commit)
+	label := abbrev + " (" + original.Summary() + ")"
+	parentLabel := "parent of " + label
+	base, next, baseLabel, nextLabel := parent, ins.Commit, parentLabel, label
+	message := commit.CompleteLine(original.Message)
+	if ins.Action == Revert {
+		base, next, baseLabel, nextLabel = ins.Commit, parent, label, parentLabel
+		message = revertMessage(original, ins.Commit, parent)
+	}
+	mergeOptions, err := newMergeOptions(st.strategy, st.strategyOptions)
+	if err != nil {
+		return false, err
+	}
+	mergeOptions.AncestorLabel = baseLabel
+	mergeOptions.OurLabel = "HEAD"
+	mergeOptions.TheirLabels = []string{nextLabel}
+	merged, err := merge.MergeTrees(s.gitDir, base, headTree, next, mergeOptions)
+	if err != nil {
+		return false, err
+	}
+	if err := s.checkout(idx, indexTime, merged.Index, merged.Tree, false); err != nil {
+		return false, err
+	}
+	result.Messages = append(result.Messages, merged.Messages...)
+	if ins.Action == Pick || ins.Action == Reword || ins.Action == Edit {
+		if err := s.writeFile(RebaseHead, []byte(hex.EncodeToString(ins.Commit)+"\n")); err != nil {
+			return false, err
+		}
+	}
+
+	if !merged.Clean {
+		if isFixup(ins.Action) {
+			if err := r.failSquash(head); err != nil {
+				return false, err
+			}
+		} else {
+			names, err := conflictingPaths(merged.Index)
+			if err != nil {
+				return false, err
+			}
+			conflicts := message + "\n# Conflicts:\n"
+			for _, name := range names {
+				conflicts += "#\t" + name + "\n"
+			}
+			if err := s.writeFile(commit.MergeMsg, []byte(conflicts)); err != nil {
+				return false, err
+			}
+		}
+		result.Messages = append(result.Messages, fmt.Sprintf("error: could not apply %s... %s", abbrev, original.Summary()))
+		rr, err := rerere.NewRerere(s.gitDir)
+		if err != nil {
+			return false, err
+		}
+		replayed, err := rr.Run()
+		if err != nil {
+			return false, err
+		}
+		result.Messages = append(result.Messages, replayed.Messages...)
+		result.Conflicts = true
+		result.Stopped = ins.Commit
+		return true, r.stopWithPatch(ins.Commit, original, nil)
+	}
+
+	if !isFixup(ins.Action) && bytes.Equal(merged.Tree, headTree) {
+		empty, err := r.isEmptyCommit(original)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case empty || st.empty == emptyKeep:
+		case st.empty == emptyDrop:
+			result.Messages = append(result.Messages, fmt.Sprintf("dropping %s %s -- patch contents already upstream",
+				hex.EncodeToString(ins.Commit), original.Summary()))
+			return r.picked(ins, original, st, result)
+		default:
+			result.Messages = append(result.Messages, "The previous cherry-pick is now empty, possibly due to conflict resolution.")
+			result.Empty = true
+			result.Stopped = ins.Commit
+			return true, r.stopWithPatch(ins.Commit, original, nil)
+		}
+	}
+
+	committer, err := commit.ResolveCommitter(s.config, s.Committer)
+	if err != nil {
+		return false, err
+	}
+	var digest []byte
+	if isFixup(ins.Action) {
+		if digest, err = r.commitFixup(ins, merged.Tree, head, headCommit, final, st); err != nil {
+			return false, err
+		}
+		result.Commits = append(result.Commits, digest)
+		return r.picked(ins, original, st, result)
+	}
+	author := original.Author
+	if ins.Action == Revert {
+		if author, err = s.author(); err != nil {
+			return false, err
+		}
+	}
+	parents, err := r.parentsOf(head)
+	if err != nil {
+		return false, err
+	}
+	if digest, err = s.commitParents(merged.Tree, head, parents, author, committer, message, st.action+": "+commit.FirstLine(message)); err != nil {
+		return false, err
+	}
+	result.Commits = append(result.Commits, digest)
+	if ins.Action == Reword {
+		if err := r.reword(st, result); err != nil {
+			return false, err
+		}
+	}
+	return r.picked(ins, original, st, result)
+}
+
+// picked stops the rebase if the instruction is edit, otherwise it records the commit as rewritten.
+func (r *Rebase) picked(ins *Instruction, commit *object.Commit, st *rebaseState, result *Result) (bool, error) {
+	if ins.Action != Edit {
+		return false, r.recordRewritten(ins.Commit, nextCommand(st.todo))
+	}
+	head, _, err := r.s.head()
+	if err != nil {
+		return false, err
+	}
+	result.Messages = append(result.Messages,
+		fmt.Sprintf("Stopped at %s...  %s", r.s.abbrev(ins.Commit), commit.Summary()),
+		"You can amend the commit now, with\n\n  git commit --amend \n\nOnce you are satisfied with your changes, run\n\n  git rebase --continue")
+	result.Stopped = ins.Commit
+	return true, r.stopWithPatch(ins.Commit, commit, head)
+}
+
+// parentsOf returns parents of a commit created on HEAD, a commit on the empty commit of "reset [new root]"
+// becomes a root commit.
+func (r *Rebase) parentsOf(head []byte) ([][]byte, error) {
+	root, err := r.readDigestState("squash-onto")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if root != nil && bytes.Equal(root, head) {
+		return nil, nil
+	}
+	return [][]byte{head}, nil
+}
+
+// fastForward moves HEAD to the commit, and checks it out.
+func (r *Rebase) fastForward(digest, head []byte) error {
+	if err := r.checkoutCommit(digest, false); err != nil {
+		return err
+	}
+	return r.s.updateHead(digest, head, "rebase: fast-forward")
+}
+
+// checkoutCommit updates the index and the working tree to the tree of the commit.
+// local changes and untracked files are overwritten if force.
+func (r *Rebase) checkoutCommit(digest []byte, force bool) error {
+	commit, err := r.s.readCommit(digest)
+	if err != nil {
+		return err
+	}
+	idx, indexTime, err := worktree.ReadIndex(r.s.gitDir)
+	if err != nil {
+		return err
+	}
+	next, err := treeIndex(r.s.gitDir, commit.Tree)
+	if err != nil {
+		return err
+	}
+	return r.s.checkout(idx, indexTime, next, commit.Tree, force)
+}
+
+// edit edits the message by Editor, the message isn't changed if there is no Editor.
+func (r *Rebase) edit(message string) (string, error) {
+	if r.Editor == nil {
+		return message, nil
+	}
+	return r.Editor(message)
+}
+
+// amendHead replaces HEAD with a commit having the tree and the message, the parents and the author are kept.
+func (r *Rebase) amendHead(tree []byte, message, reflog string) ([]byte, error) {
+	head, headCommit, err := r.s.head()
+	if err != nil {
+		return nil, err
+	}
+	committer, err := commit.ResolveCommitter(r.s.config, r.s.Committer)
+	if err != nil {
+		return nil, err
+	}
+	return r.s.commitParents(tree, head, headCommit.Parents, headCommit.Author, committer, message, reflog)
+}
+
+// reword edits the message of HEAD, and amends it.
+func (r *Rebase) reword(st *rebaseState, result *Result) error {
+	if r.Editor == nil {
+		return nil
+	}
+	_, headCommit, err := r.s.head()
+	if err != nil {
+		return err
+	}
+	edited, err := r.Editor(headCommit.Message)
+	if err != nil {
+		return err
+	}
+	message := commit.Stripspace(edited, commit.CommentChar(r.s.config))
+	if message == "" {
+		return ErrEmptyMessage
+	}
+	digest, err := r.amendHead(headCommit.Tree, message, st.action+": "+commit.FirstLine(message))
+	if err != nil {
+		return err
+	}
+	result.Commits[len(result.Commits)-1] = digest
+	return nil
+}
+
+// stopWithPatch writes the state of the stopped commit like error_with_patch of git.
+// the message of the commit is kept if .git/rebase-merge/message exists, and amend is written if it isn't nil.
+func (r *Rebase) stopWithPatch(digest []byte, commit *object.Commit, amend []byte) error {
+	if err := r.writeState("stopped-sha", []byte(hex.EncodeToString(digest)+"\n")); err != nil {
+		return err
+	}
+	if err := r.s.writeFile(RebaseHead, []byte(hex.EncodeToString(digest)+"\n")); err != nil {
+		return err
+	}
+	var parent []byte
+	if len(commit.Parents) > 0 {
+		parent = commit.Parents[0]
+	}
+	changes, err := diff.TreeToTree(r.s.gitDir, parent, commit.Tree, nil)
+	if err != nil {
+		return err
+	}
+	patch := &bytes.Buffer{}
+	if err := diff.WritePatch(patch, r.s.gitDir, changes, &diff.PatchOptions{Options: xdiff.Options{Context: xdiff.DefaultContext}}); err != nil {
+		return err
+	}
+	if err := r.writeState("patch", patch.Bytes()); err != nil {
+		return err
+	}
+	if !r.existsState("message") {
+		if err := r.writeState("message", []byte(commit.Message+"\n")); err != nil {
+			return err
+		}
+	}
+	if amend == nil {
+		return nil
+	}
+	return r.writeState("amend", []byte(hex.EncodeToString(amend)+"\n"))
+}
+
+// stopAtHead stops the rebase at HEAD for break.
+func (r *Rebase) stopAtHead(result *Result) error {
+	head, headCommit, err := r.s.head()
+	if err != nil {
+		return err
+	}
+	result.Messages = append(result.Messages, fmt.Sprintf("Stopped at %s...  %s", r.s.abbrev(head), headCommit.Summary()))
+	result.Stopped = head
+	return nil
+}
+
+// doExec runs the command by the shell in the top of the working tree.
+// the rebase stops if the command fails or leaves changes.
+func (r *Rebase) doExec(ins *Instruction, result *Result) (bool, error) {
+	result.Messages = append(result.Messages, "Executing: "+ins.Arg)
+	cmd := exec.Command("sh", "-c", ins.Arg)
+	cmd.Dir = r.s.workDir
+	cmd.Stdout, cmd.Stderr = ioutil.Discard, ioutil.Discard
+	if r.Output != nil {
+		cmd.Stdout, cmd.Stderr = r.Output, r.Output
+	}
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return false, err
+		}
+		result.Messages = append(result.Messages, "warning: execution failed: "+ins.Arg,
+			"You can fix the problem, and then run\n\n  git rebase --continue")
+		return true, r.stopAtExec(result)
+	}
+	if err := r.checkClean(); err == ErrUnstagedChanges || err == ErrUncommittedChanges {
+		result.Messages = append(result.Messages, "warning: execution succeeded: "+ins.Arg,
+			"but left changes to the index and/or the working tree.\nCommit or stash your changes, and then run\n\n  git rebase --continue")
+		return true, r.stopAtExec(result)
+	} else if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// stopAtExec records HEAD as the commit where the rebase stopped.
+func (r *Rebase) stopAtExec(result *Result) error {
+	head, _, err := r.s.head()
+	if err != nil {
+		return err
+	}
+	result.Stopped = head
+	return nil
+}
+
+// labelRef returns the name of the reference of the label.
+func labelRef(label string) (string, error) {
+	name := rewrittenPrefix + label
+	if !refs.IsValidName(name) {
+		return "", ErrInvalidLabel
+	}
+	return name, nil
+}
+
+// doLabel makes refs/rewritten/<label> point to HEAD, it's deleted when the rebase finishes.
+func (r *Rebase) doLabel(ins *Instruction) error {
+	name, err := labelRef(ins.name())
+	if err != nil {
+		return err
+	}
+	head, _, err := r.s.head()
+	if err != nil {
+		return err
+	}
+	if err := r.s.refs.Update(name, head, nil, nil); err != nil {
+		return err
+	}
+	return r.appendState("refs-to-be-deleted", []byte(name+"\n"))
+}
+
+// resolveLabel returns the commit of the label, it's a revision if there is no such label.
+func (r *Rebase) resolveLabel(label string) ([]byte, error) {
+	if name, err := labelRef(label); err == nil {
+		if ref, err := r.s.refs.Resolve(name); err == nil {
+			return ref.Digest, nil
+		} else if err != refs.ErrRefNotFound {
+			return nil, err
+		}
+	}
+	return r.resolver.ResolveCommit(label)
+}
+
+// doReset moves HEAD to the label, and checks it out. untracked files are not overwritten.
+func (r *Rebase) doReset(ins *Instruction) error {
+	name := ins.name()
+	var target []byte
+	var err error
+	if strings.HasPrefix(ins.Arg, newRoot) {
+		name = newRoot
+		target, err = r.squashOnto()
+	} else {
+		target, err = r.resolveLabel(name)
+	}
+	if err != nil {
+		return err
+	}
+	head, _, err := r.s.head()
+	if err != nil {
+		return err
+	}
+	if err := r.checkoutCommit(target, false); err != nil {
+		return err
+	}
+	if bytes.Equal(head, target) {
+		return nil
+	}
+	return r.s.updateHead(target, head, "rebase (reset): '"+name+"'")
+}
+
+// squashOnto returns the empty root commit which "reset [new root]" resets to, it's created at the first time.
+func (r *Rebase) squashOnto() ([]byte, error) {
+	digest, err := r.readDigestState("squash-onto")
+	if err == nil || !os.IsNotExist(err) {
+		return digest, err
+	}
+	tree, err := r.s.treeOf(nil)
+	if err != nil {
+		return nil, err
+	}
+	author, err := r.s.author()
+	if err != nil {
+		return nil, err
+	}
+	committer, err := commit.ResolveCommitter(r.s.config, r.s.Committer)
+	if err != nil {
+		return nil, err
+	}
+	digest, err = object.WriteObject(r.s.gitDir, &object.Commit{Tree: tree, Author: author, Committer: committer})
+	if err != nil {
+		return nil, err
+	}
+	return digest, r.writeState("squash-onto", []byte(hex.EncodeToString(digest)+"\n"))
+}
+
+// doMerge creates a merge commit of HEAD and the labels like do_merge of git.
+// the message is taken from the commit of -C or -c, the oneline, or made from labels in this order.
+// it fast-forwards to the original merge commit if its parents are not rewritten.
+func (r *Rebase) doMerge(ins *Instruction, st *rebaseState, result *Result) (bool, error) {
+	s := r.s
+	head, _, err := s.head()
+	if err != nil {
+		return false, err
+	}
+	labels := ins.labels()
+	parents := make([][]byte, 0, len(labels))
+	for _, label := range labels {
+		digest, err := r.resolveLabel(label)
+		if err != nil {
+			return false, err
+		}
+		parents = append(parents, digest)
+	}
+	var original *object.Commit
+	if ins.Commit != nil {
+		if original, err = s.readCommit(ins.Commit); err != nil {
+			return false, err
+		}
+	}
+	var message string
+	switch {
+	case original != nil:
+		message = commit.CompleteLine(original.Message)
+	case ins.oneline() != "":
+		message = ins.oneline() + "\n"
+	case len(labels) > 1:
+		message = "Merge branches '" + strings.Join(labels, " ") + "'\n"
+	default:
+		message = "Merge branch '" + strings.Join(labels, " ") + "'\n"
+	}
+
+	if st.allowFastForward && original != nil && len(original.Parents) == len(parents)+1 && bytes.Equal(original.Parents[0], head) {
+		same := true
+		for i, parent := range parents {
+			same = same && bytes.Equal(original.Parents[i+1], parent)
+		}
+		if same {
+			if err := r.fastForward(ins.Commit, head); err != nil {
+				return false, err
+			}
+			result.Commits = append(result.Commits, ins.Commit)
+			return false, r.recordRewritten(ins.Commit, nextCommand(st.todo))
+		}
+	}
+
+	mergeHead := &bytes.Buffer{}
+	for _, parent := range parents {
+		mergeHead.WriteString(hex.EncodeToString(parent) + "\n")
+	}
+	if err := s.writeFile(refs.MergeHEAD, mergeHead.Bytes()); err != nil {
+		return false, err
+	}
+	if err := s.writeFile(commit.MergeMsg, []byte(message)); err != nil {
+		return false, err
+	}
+	mergeOptions, err := newMergeOptions(st.strategy, st.strategyOptions)
+	if err != nil {
+		return false, err
+	}
+	if len(parents) > 1 {
+		mergeOptions.Strategy = merge.StrategyOctopus
+	}
+	mergeOptions.AllowUnrelatedHistories = true
+	mergeOptions.OurLabel = "HEAD"
+	for _, label := range labels {
+		mergeOptions.TheirLabels = append(mergeOptions.TheirLabels, rewrittenPrefix+label)
+	}
+	merged, err := merge.Merge(s.gitDir, head, parents, mergeOptions)
+	if err != nil {
+		return false, err
+	}
+	idx, indexTime, err := worktree.ReadIndex(s.gitDir)
+	if err != nil {
+		return false, err
+	}
+	if err := s.checkout(idx, indexTime, merged.Index, merged.Tree, false); err != nil {
+		return false, err
+	}
+	result.Messages = append(result.Messages, merged.Messages...)
+	author := (*object.Signature)(nil)
+	if original != nil {
+		author = original.Author
+		if err := r.writeAuthorScript(author); err != nil {
+			return false, err
+		}
+	} else if author, err = s.author(); err != nil {
+		return false, err
+	}
+
+	if !merged.Clean {
+		rr, err := rerere.NewRerere(s.gitDir)
+		if err != nil {
+			return false, err
+		}
+		replayed, err := rr.Run()
+		if err != nil {
+			return false, err
+		}
+		result.Messages = append(result.Messages, replayed.Messages...)
+		result.Conflicts = true
+		if original == nil {
+			result.Messages = append(result.Messages, "error: could not merge "+strings.Join(labels, " "))
+			result.Stopped = head
+			return true, r.writeState("message", []byte(message))
+		}
+		result.Messages = append(result.Messages, fmt.Sprintf("error: could not apply %s... %s", s.abbrev(ins.Commit), original.Summary()))
+		result.Stopped = ins.Commit
+		return true, r.stopWithPatch(ins.Commit, original, nil)
+	}
+
+	if ins.EditMessage {
+		edited, err := r.edit(message)
+		if err != nil {
+			return false, err
+		}
+		if message = commit.Stripspace(edited, commit.CommentChar(s.config)); message == "" {
+			return false, ErrEmptyMessage
+		}
+	}
+	committer, err := commit.ResolveCommitter(s.config, s.Committer)
+	if err != nil {
+		return false, err
+	}
+	digest, err := s.commitParents(merged.Tree, head, append([][]byte{head}, parents...), author, committer, message, st.action+": "+commit.FirstLine(message))
+	if err != nil {
+		return false, err
+	}
+	result.Commits = append(result.Commits, digest)
+	if err := r.removeFiles(refs.MergeHEAD, commit.MergeMsg); err != nil {
+		return false, err
+	}
+	if ins.Commit == nil {
+		return false, nil
+	}
+	return false, r.recordRewritten(ins.Commit, nextCommand(st.todo))
+}
+
+// doUpdateRef records HEAD as the digest which the reference is updated to when the rebase finishes.
+func (r *Rebase) doUpdateRef(ins *Instruction) error {
+	updates, err := r.readUpdateRefs()
+	if err != nil {
+		return err
+	}
+	head, _, err := r.s.head()
+	if err != nil {
+		return err
+	}
+	var update *updateRef
+	for _, u := range updates {
+		if u.name == ins.Arg {
+			update = u
+		}
+	}
+	if update == nil {
+		before := refs.ZeroDigest
+		if ref, err := r.s.refs.Resolve(ins.Arg); err == nil {
+			before = ref.Digest
+		} else if err != refs.ErrRefNotFound {
+			return err
+		}
+		update = &updateRef{name: ins.Arg, before: before}
+		updates = append(updates, update)
+	}
+	update.after = head
+	return r.writeUpdateRefs(updates)
+}
+
+// finish updates the branch to HEAD and attaches HEAD to it, updates references of update-ref,
+// and removes the state of the rebase.
+func (r *Rebase) finish(st *rebaseState, result *Result) error {
+	s := r.s
+	if err := r.flushRewritten(); err != nil {
+		return err
+	}
+	head, _, err := s.head()
+	if err != nil {
+		return err
+	}
+	committer, err := commit.ResolveCommitter(s.config, s.Committer)
+	if err != nil {
+		return err
+	}
+	if st.headName != detachedHead {
+		var current []byte
+		if ref, err := s.refs.Resolve(st.headName); err == nil {
+			current = ref.Digest
+		} else if err != refs.ErrRefNotFound {
+			return err
+		}
+		if !bytes.Equal(current, head) {
+			if current == nil {
+				current = refs.ZeroDigest
+			}
+			if err := s.refs.Update(st.headName, head, current, &refs.LogMessage{
+				Committer: committer,
+				Message:   "rebase (finish): " + st.headName + " onto " + hex.EncodeToString(st.onto),
+			}); err != nil {
+				return err
+			}
+		}
+		if err := s.refs.UpdateSymbolic(refs.HEAD, st.headName, &refs.LogMessage{
+			Committer: committer,
+			Message:   "rebase (finish): returning to " + st.headName,
+		}); err != nil {
+			return err
+		}
+	}
+	updates, err := r.readUpdateRefs()
+	if err != nil {
+		return err
+	}
+	for _, update := range updates {
+		if refs.IsZeroDigest(update.after) {
+			continue
+		}
+		err := s.refs.Update(update.name, update.after, update.before, &refs.LogMessage{Committer: committer, Message: "rewritten during rebase"})
+		if err == refs.ErrRefMismatch {
+			result.Messages = append(result.Messages, "error: could not update "+update.name)
+		} else if err != nil {
+			return err
+		}
+	}
+	result.Messages = append(result.Messages, "Successfully rebased and updated "+st.headName+".")
+	if err := r.removeFiles(RebaseHead, commit.SquashMsg, commit.AutoMerge); err != nil {
+		return err
+	}
+	return r.removeRebaseState()
+}
+
+// hasUnstagedChanges reports whether files in the working tree differ from the index.
+func (r *Rebase) hasUnstagedChanges(idx index.Index, indexTime time.Time) (bool, error) {
+	entries, err := worktree.ReadEntries(idx)
+	if err != nil {
+		return false, err
+	}
+	w, err := worktree.NewWorktree(r.s.gitDir, r.s.workDir, r.s.config, entries, indexTime)
+	if err != nil {
+		return false, err
+	}
+	defer w.Close()
+	for _, entry := range entries {
+		if entry.ConflictFlag != index.NoConflict {
+			continue
+		}
+		upToDate, err := w.IsUpToDate(entry)
+		if err != nil {
+			return false, err
+		}
+		if !upToDate {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// commitStaged commits staged changes of the stopped command like commit_staged_changes of git.
+// changes are amended to HEAD if the rebase stopped at edit or in a chain of fixups, and the stopped commit is
+// recorded as rewritten.
+func (r *Rebase) commitStaged(st *rebaseState, result *Result) error {
+	s := r.s
+	idx, indexTime, err := worktree.ReadIndex(s.gitDir)
+	if err != nil {
+		return err
+	}
+	if conflicts, err := hasConflicts(idx); err != nil || conflicts {
+		if err == nil {
+			err = ErrUnmergedPaths
+		}
+		return err
+	}
+	if unstaged, err := r.hasUnstagedChanges(idx, indexTime); err != nil || unstaged {
+		if err == nil {
+			err = ErrUnstagedChanges
+		}
+		return err
+	}
+	head, headCommit, err := s.head()
+	if err != nil {
+		return err
+	}
+	clean, err := s.matchesHead(idx, headCommit)
+	if err != nil {
+		return err
+	}
+	data, err := r.readState("message")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !clean && os.IsNotExist(err) {
+		return ErrUncommittedChanges
+	}
+	amend, err := r.readDigestState("amend")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if amend != nil && !clean && !bytes.Equal(amend, head) {
+		return ErrUncommittedChanges
+	}
+
+	next := nextCommand(st.todo)
+	if clean && len(st.fixups) > 0 {
+		// the last fixup of the chain is skipped
+		st.fixups = st.fixups[:len(st.fixups)-1]
+		if err := r.writeState("current-fixups", []byte(strings.Join(st.fixups, "\n"))); err != nil {
+			return err
+		}
+		switch {
+		case len(st.fixups) > 0 && !isFixup(next):
+			message := headCommit.Message
+			if seenSquash(st.fixups) {
+				if message, err = r.edit(message); err != nil {
+					return err
+				}
+			}
+			if message = commit.Stripspace(message, commit.CommentChar(s.config)); message == "" {
+				return ErrEmptyMessage
+			}
+			digest, err := r.amendHead(headCommit.Tree, message, st.action+": "+commit.FirstLine(message))
+			if err != nil {
+				return err
+			}
+			result.Commits = append(result.Commits, digest)
+			if err := r.clearFixups(st); err != nil {
+				return err
+			}
+		case isFixup(next):
+			if err := r.writeState("message-squash", []byte(headCommit.Message)); err != nil {
+				return err
+			}
+		default:
+			if err := r.clearFixups(st); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !clean {
+		tree, err := commit.WriteTree(s.gitDir, idx)
+		if err != nil {
+			return err
+		}
+		chain := len(st.fixups) > 0
+		final := chain && !isFixup(next)
+		message := string(data)
+		if !chain || final {
+			if !chain || seenSquash(st.fixups) {
+				if message, err = r.edit(message); err != nil {
+					return err
+				}
+			}
+			if message = commit.Stripspace(message, commit.CommentChar(s.config)); message == "" {
+				return ErrEmptyMessage
+			}
+		}
+		reflog := st.action + ": " + commit.FirstLine(message)
+		var digest []byte
+		if amend != nil || chain {
+			digest, err = r.amendHead(tree, message, reflog)
+		} else {
+			digest, err = r.commitMerge(tree, head, message, reflog)
+		}
+		if err != nil {
+			return err
+		}
+		result.Commits = append(result.Commits, digest)
+		if final {
+			if err := r.clearFixups(st); err != nil {
+				return err
+			}
+		}
+		rr, err := rerere.NewRerere(s.gitDir)
+		if err != nil {
+			return err
+		}
+		if _, err := rr.Run(); err != nil {
+			return err
+		}
+	}
+
+	stopped, err := r.readDigestState("stopped-sha")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if stopped != nil {
+		if err := r.recordRewritten(stopped, next); err != nil {
+			return err
+		}
+	}
+	return r.removeFiles(rebasePath("message"), rebasePath("author-script"), rebasePath("stopped-sha"),
+		rebasePath("amend"), refs.MergeHEAD, commit.MergeMsg, commit.CherryPickHead, RebaseHead, commit.AutoMerge)
+}
+
+// commitMerge commits the tree on HEAD and commits in MERGE_HEAD, the author is taken from the author script.
+func (r *Rebase) commitMerge(tree, head []byte, message, reflog string) ([]byte, error) {
+	s := r.s
+	parents, err := r.parentsOf(head)
+	if err != nil {
+		return nil, err
+	}
+	mergeHead, err := ioutil.ReadFile(s.path(refs.MergeHEAD))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range strings.Fields(string(mergeHead)) {
+		digest, err := refs.ParseDigest(line)
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, digest)
+	}
+	author, err := r.readAuthorScript()
+	if err != nil {
+		return nil, err
+	}
+	if author == nil {
+		if author, err = s.author(); err != nil {
+			return nil, err
+		}
+	}
+	committer, err := commit.ResolveCommitter(s.config, s.Committer)
+	if err != nil {
+		return nil, err
+	}
+	return s.commitParents(tree, head, parents, author, committer, message, reflog)
+}
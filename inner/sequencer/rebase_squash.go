@@ -0,0 +1,296 @@
+package sequencer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/commit"
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// headers of messages of commits in the squash message
+const (
+	combinedHeader  = "This is a combination of %d commits."
+	firstHeader     = "This is the 1st commit message:"
+	skipFirstHeader = "The 1st commit message will be skipped:"
+	nthHeader       = "This is the commit message #%d:"
+	skipNthHeader   = "The commit message #%d will be skipped:"
+)
+
+// seenSquash reports whether the chain of fixups has squash.
+func seenSquash(fixups []string) bool {
+	for _, fixup := range fixups {
+		if strings.HasPrefix(fixup, "squash ") {
+			return true
+		}
+	}
+	return false
+}
+
+// commentLines comments out lines of the text like strbuf_add_commented_lines of git.
+// blank lines become "#" and the others are prefixed by "# ".
+func commentLines(text string) string {
+	if text == "" {
+		return ""
+	}
+	buf := &strings.Builder{}
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if line == "" {
+			buf.WriteString("#\n")
+		} else {
+			buf.WriteString("# " + line + "\n")
+		}
+	}
+	return buf.String()
+}
+
+// isBlankLine reports whether the line has only white spaces.
+func isBlankLine(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// skipBlankLines removes leading blank lines of the text.
+func skipBlankLines(text string) string {
+	for text != "" {
+		end := strings.IndexByte(text, '\n')
+		if end < 0 {
+			end = len(text) - 1
+		}
+		if !isBlankLine(text[:end+1]) {
+			break
+		}
+		text = text[end+1:]
+	}
+	return text
+}
+
+// subjectLength returns the length of the subject paragraph of the message, including its newlines.
+func subjectLength(message string) int {
+	n := 0
+	for n < len(message) {
+		end := strings.IndexByte(message[n:], '\n')
+		line := message[n:]
+		if end >= 0 {
+			line = message[n : n+end+1]
+		}
+		if isBlankLine(line) {
+			break
+		}
+		n += len(line)
+	}
+	return n
+}
+
+// updateSquashMessages adds the message of the commit of fixup or squash to .git/rebase-merge/message-squash
+// like update_squash_messages of git. the message of HEAD is the first message of the chain.
+// .git/rebase-merge/message-fixup has the message used as it is when the chain has no squash.
+func (r *Rebase) updateSquashMessages(ins *Instruction, commit, headCommit *object.Commit, st *rebaseState) error {
+	count := len(st.fixups)
+	replace := ins.replaces()
+	var buf string
+	if count > 0 {
+		data, err := r.readState("message-squash")
+		if err != nil {
+			return err
+		}
+		buf = string(data)
+		eol := 0
+		if strings.HasPrefix(buf, "#") {
+			if eol = strings.IndexByte(buf, '\n'); eol < 0 {
+				eol = len(buf)
+			}
+		}
+		buf = "# " + fmt.Sprintf(combinedHeader, count+2) + buf[eol:]
+		if replace && !seenSquash(st.fixups) {
+			buf = updateSquashMessageForFixup(buf)
+		}
+	} else {
+		if headCommit == nil {
+			return ErrUnbornBranch
+		}
+		body := headCommit.Message
+		if ins.Action == Fixup && !replace {
+			if err := r.writeState("message-fixup", []byte(body)); err != nil {
+				return err
+			}
+		}
+		header := firstHeader
+		if replace {
+			header = skipFirstHeader
+		}
+		buf = "# " + fmt.Sprintf(combinedHeader, 2) + "\n# " + header + "\n\n"
+		if replace {
+			buf += commentLines(body)
+		} else {
+			buf += body
+		}
+	}
+
+	body := commit.Message
+	if ins.Action == Squash || replace {
+		var err error
+		if buf, err = r.appendSquashMessage(buf, body, ins, st.fixups); err != nil {
+			return err
+		}
+	} else {
+		buf += "\n# " + fmt.Sprintf(skipNthHeader, count+2) + "\n\n" + commentLines(body)
+	}
+	if err := r.writeState("message-squash", []byte(buf)); err != nil {
+		return err
+	}
+	st.fixups = append(st.fixups, ins.Action.String()+" "+hex.EncodeToString(ins.Commit))
+	return r.writeState("current-fixups", []byte(strings.Join(st.fixups, "\n")))
+}
+
+// appendSquashMessage appends the message of squash or fixup -C to the squash message like append_squash_message of git.
+func (r *Rebase) appendSquashMessage(buf, body string, ins *Instruction, fixups []string) (string, error) {
+	squashed := seenSquash(fixups)
+	commented := 0
+	// amend is non-interactive and not normally used with fixup! or squash! commits,
+	// so only comment out those subjects when squashing commit messages.
+	if strings.HasPrefix(body, "amend!") ||
+		(ins.Action == Squash || squashed) && (strings.HasPrefix(body, "squash!") || strings.HasPrefix(body, "fixup!")) {
+		commented = subjectLength(body)
+	}
+	buf += "\n# " + fmt.Sprintf(nthHeader, len(fixups)+2) + "\n\n"
+	buf += commentLines(body[:commented])
+	offset := len(buf)
+	buf += body[commented:]
+
+	// fixup -C after squash behaves like squash
+	if ins.replaces() && !squashed && (r.existsState("message-fixup") || !r.existsState("message-squash")) {
+		return buf, r.writeState("message-fixup", []byte(skipBlankLines(buf[offset:])))
+	}
+	return buf, r.removeFiles(rebasePath("message-fixup"))
+}
+
+// updateSquashMessageForFixup comments out all messages of the squash message for fixup -C, the message of
+// the fixup is appended later, like update_squash_message_for_fixup of git.
+func updateSquashMessageForFixup(msg string) string {
+	first := func(n int) string {
+		if n == 1 {
+			return "# " + firstHeader + "\n"
+		}
+		return "# " + fmt.Sprintf(nthHeader, n) + "\n"
+	}
+	skip := func(n int) string {
+		if n == 1 {
+			return "# " + skipFirstHeader + "\n"
+		}
+		return "# " + fmt.Sprintf(skipNthHeader, n) + "\n"
+	}
+	copyLines := func(s string) string { return s }
+	buf := &strings.Builder{}
+	start, s, i := 0, 0, 1
+	for s >= 0 {
+		switch {
+		case strings.HasPrefix(msg[s:], first(i)):
+			// copy the last message, preserving the blank line preceding the current line
+			off := 0
+			if s > start+1 && msg[s-2] == '\n' {
+				off = 1
+			}
+			buf.WriteString(copyLines(msg[start : s-off]))
+			if off == 1 {
+				buf.WriteByte('\n')
+			}
+			// the next message needs to be commented out but the header is already commented out,
+			// so just copy it and the blank line that follows it.
+			next := s + len(first(i))
+			buf.WriteString(skip(i))
+			if next < len(msg) && msg[next] == '\n' {
+				buf.WriteByte('\n')
+				next++
+			}
+			start, s = next, next
+			copyLines = commentLines
+			i++
+		case strings.HasPrefix(msg[s:], skip(i)):
+			off := 0
+			if s > start+1 && msg[s-2] == '\n' {
+				off = 1
+			}
+			buf.WriteString(copyLines(msg[start : s-off]))
+			start = s - off
+			s += len(skip(i))
+			copyLines = func(s string) string { return s }
+			i++
+		default:
+			if end := strings.IndexByte(msg[s:], '\n'); end >= 0 {
+				s += end + 1
+			} else {
+				s = -1
+			}
+		}
+	}
+	buf.WriteString(copyLines(msg[start:]))
+	return buf.String()
+}
+
+// commitFixup amends HEAD with the tree for fixup and squash. the message is the squash message with comments
+// until the end of the chain, and the last one commits the message of the chain.
+func (r *Rebase) commitFixup(ins *Instruction, tree, head []byte, headCommit *object.Commit, final bool, st *rebaseState) ([]byte, error) {
+	var message string
+	edit := false
+	if !final {
+		data, err := r.readState("message-squash")
+		if err != nil {
+			return nil, err
+		}
+		message = string(data)
+	} else if data, err := r.readState("message-fixup"); err == nil {
+		message, edit = string(data), ins.EditMessage
+	} else if os.IsNotExist(err) {
+		if data, err = r.readState("message-squash"); err != nil {
+			return nil, err
+		}
+		message, edit = string(data), true
+	} else {
+		return nil, err
+	}
+	if edit {
+		edited, err := r.edit(message)
+		if err != nil {
+			return nil, err
+		}
+		if message = commit.Stripspace(edited, commit.CommentChar(r.s.config)); message == "" {
+			return nil, ErrEmptyMessage
+		}
+	}
+	committer, err := commit.ResolveCommitter(r.s.config, r.s.Committer)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := r.s.commitParents(tree, head, headCommit.Parents, headCommit.Author, committer, message, st.action+": "+commit.FirstLine(message))
+	if err != nil {
+		return nil, err
+	}
+	if final {
+		return digest, r.clearFixups(st)
+	}
+	return digest, nil
+}
+
+// failSquash keeps the squash message as the message of the stopped commit when fixup or squash conflicts
+// like error_failed_squash of git, and the resolution is amended to HEAD by Continue.
+func (r *Rebase) failSquash(head []byte) error {
+	data, err := r.readState("message-squash")
+	if err != nil {
+		return err
+	}
+	if err := r.writeState("message", data); err != nil {
+		return err
+	}
+	if err := r.s.writeFile(commit.MergeMsg, data); err != nil {
+		return err
+	}
+	return r.writeState("amend", []byte(hex.EncodeToString(head)+"\n"))
+}
+
+// clearFixups removes files of the chain of fixups.
+func (r *Rebase) clearFixups(st *rebaseState) error {
+	st.fixups = nil
+	return r.removeFiles(rebasePath("message-squash"), rebasePath("message-fixup"), rebasePath("current-fixups"), commit.SquashMsg)
+}
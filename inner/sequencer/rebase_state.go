@@ -0,0 +1,426 @@
+package sequencer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/commit"
+	"github.com/shumon84/mogit/inner/merge"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/refs"
+)
+
+// rebaseDir is the directory having the state of the rebase.
+const rebaseDir = "rebase-merge"
+
+// RebaseHead is the name of the file in .git directory having the commit which the rebase stopped at.
+const RebaseHead = "REBASE_HEAD"
+
+// detachedHead is the content of .git/rebase-merge/head-name when HEAD is detached.
+const detachedHead = "detached HEAD"
+
+// emptyMode is a type representing how commits which become empty are handled like --empty.
+type emptyMode int
+
+const (
+	emptyDrop emptyMode = iota // drop them
+	emptyKeep                  // commit them
+	emptyAsk                   // stop like cherry-pick
+)
+
+// rebaseState is a type representing options and progress of the rebase saved in .git/rebase-merge.
+type rebaseState struct {
+	headName        string // the branch being rebased, or detachedHead
+	onto            []byte
+	origHead        []byte
+	strategy        merge.Strategy
+	strategyOptions []string
+	empty           emptyMode
+
+	todo   []*Instruction // instructions not done yet
+	done   int            // the number of commands done
+	total  int            // the number of commands
+	fixups []string       // fixups and squashes of the current chain like "fixup <digest>"
+	action string         // the reflog action of the last commit command, merge commits are logged with it
+
+	allowFastForward bool // fast-forward picks whose parents are HEAD, it's false only when ForceRebase starts the rebase
+}
+
+// rebasePath returns the path of the file in .git/rebase-merge relative to .git directory.
+func rebasePath(name string) string {
+	return filepath.Join(rebaseDir, name)
+}
+
+// inRebase reports whether .git/rebase-merge exists.
+func (r *Rebase) inRebase() bool {
+	info, err := os.Stat(r.s.path(rebaseDir))
+	return err == nil && info.IsDir()
+}
+
+// writeState writes the file in .git/rebase-merge.
+func (r *Rebase) writeState(name string, data []byte) error {
+	return r.s.writeFile(rebasePath(name), data)
+}
+
+// readState reads the file in .git/rebase-merge.
+func (r *Rebase) readState(name string) ([]byte, error) {
+	return ioutil.ReadFile(r.s.path(rebasePath(name)))
+}
+
+// existsState reports whether the file in .git/rebase-merge exists.
+func (r *Rebase) existsState(name string) bool {
+	_, err := os.Stat(r.s.path(rebasePath(name)))
+	return err == nil
+}
+
+// removeFiles removes the files in .git directory, files which don't exist are ignored.
+func (r *Rebase) removeFiles(names ...string) error {
+	for _, name := range names {
+		if err := os.Remove(r.s.path(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendState appends the data to the file in .git/rebase-merge.
+func (r *Rebase) appendState(name string, data []byte) error {
+	file, err := os.OpenFile(r.s.path(rebasePath(name)), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// saveState creates .git/rebase-merge with the options of the rebase.
+func (r *Rebase) saveState(st *rebaseState) error {
+	if err := os.Mkdir(r.s.path(rebaseDir), 0777); err != nil {
+		if os.IsExist(err) {
+			return ErrRebaseInProgress
+		}
+		return err
+	}
+	files := map[string]string{
+		"head-name":                 st.headName + "\n",
+		"onto":                      hex.EncodeToString(st.onto) + "\n",
+		"orig-head":                 hex.EncodeToString(st.origHead) + "\n",
+		"interactive":               "",
+		"no-reschedule-failed-exec": "",
+	}
+	switch st.empty {
+	case emptyDrop:
+		files["drop_redundant_commits"] = ""
+	case emptyKeep:
+		files["keep_redundant_commits"] = ""
+	}
+	if st.strategy != merge.StrategyOrt {
+		files["strategy"] = st.strategy.String() + "\n"
+	}
+	if len(st.strategyOptions) > 0 {
+		files["strategy_opts"] = " --" + strings.Join(st.strategyOptions, " --") + "\n"
+	}
+	for name, data := range files {
+		if err := r.writeState(name, []byte(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDigestState reads the file in .git/rebase-merge having hex digits of a digest.
+func (r *Rebase) readDigestState(name string) ([]byte, error) {
+	return r.s.readDigestFile(rebasePath(name))
+}
+
+// readLine reads the first line of the file in .git/rebase-merge, it's empty if the file doesn't exist.
+func (r *Rebase) readLine(name string) (string, error) {
+	data, err := r.readState(name)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(commit.FirstLine(string(data))), nil
+}
+
+// loadState reads the options and the progress of the rebase from .git/rebase-merge.
+func (r *Rebase) loadState() (*rebaseState, error) {
+	st := &rebaseState{action: "rebase (continue)", allowFastForward: true}
+	var err error
+	if st.headName, err = r.readLine("head-name"); err != nil {
+		return nil, err
+	}
+	if st.onto, err = r.readDigestState("onto"); err != nil {
+		return nil, err
+	}
+	if st.origHead, err = r.readDigestState("orig-head"); err != nil {
+		return nil, err
+	}
+	if strategy, err := r.readLine("strategy"); err != nil {
+		return nil, err
+	} else if strategy != "" {
+		if st.strategy, err = merge.ParseStrategy(strategy); err != nil {
+			return nil, err
+		}
+	}
+	options, err := r.readLine("strategy_opts")
+	if err != nil {
+		return nil, err
+	}
+	for _, option := range strings.Split(options, " --") {
+		if option = strings.TrimSpace(option); option != "" {
+			st.strategyOptions = append(st.strategyOptions, strings.TrimPrefix(option, "--"))
+		}
+	}
+	switch {
+	case r.existsState("drop_redundant_commits"):
+		st.empty = emptyDrop
+	case r.existsState("keep_redundant_commits"):
+		st.empty = emptyKeep
+	default:
+		st.empty = emptyAsk
+	}
+	if st.todo, err = r.readTodo(); err != nil {
+		return nil, err
+	}
+	for name, n := range map[string]*int{"msgnum": &st.done, "end": &st.total} {
+		line, err := r.readLine(name)
+		if err != nil {
+			return nil, err
+		}
+		if line != "" {
+			if *n, err = strconv.Atoi(line); err != nil {
+				return nil, ErrInvalidTodo
+			}
+		}
+	}
+	fixups, err := r.readState("current-fixups")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(fixups), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			st.fixups = append(st.fixups, line)
+		}
+	}
+	return st, nil
+}
+
+// resolveTodoCommit resolves a commit of the todo list.
+func (r *Rebase) resolveTodoCommit(rev string) ([]byte, error) {
+	return r.resolver.ResolveCommit(rev)
+}
+
+// readTodo reads .git/rebase-merge/git-rebase-todo.
+func (r *Rebase) readTodo() ([]*Instruction, error) {
+	data, err := r.readState("git-rebase-todo")
+	if err != nil {
+		return nil, err
+	}
+	return parseInstructions(data, r.resolveTodoCommit)
+}
+
+// saveTodo writes the rest of the todo list, and appends the instruction being done to the done list.
+func (r *Rebase) saveTodo(todo []*Instruction, done *Instruction) error {
+	if err := r.writeState("git-rebase-todo", formatInstructions(todo)); err != nil {
+		return err
+	}
+	if done == nil {
+		return nil
+	}
+	return r.appendState("done", formatInstructions([]*Instruction{done}))
+}
+
+// writeCount writes the number to the file in .git/rebase-merge like msgnum and end.
+func (r *Rebase) writeCount(name string, n int) error {
+	return r.writeState(name, []byte(strconv.Itoa(n)+"\n"))
+}
+
+// writeAuthorScript writes the author of the commit being picked in the format of shell variables.
+//
+//  GIT_AUTHOR_NAME='<name>'
+//  GIT_AUTHOR_EMAIL='<email>'
+//  GIT_AUTHOR_DATE='@<unix time> <timezone>'
+func (r *Rebase) writeAuthorScript(author *object.Signature) error {
+	script := fmt.Sprintf("GIT_AUTHOR_NAME=%s\nGIT_AUTHOR_EMAIL=%s\nGIT_AUTHOR_DATE=%s\n",
+		shellQuote(author.Name), shellQuote(author.Email),
+		shellQuote(fmt.Sprintf("@%d %s", author.When.Unix(), author.When.Format("-0700"))))
+	return r.writeState("author-script", []byte(script))
+}
+
+// readAuthorScript reads the author written by writeAuthorScript, it returns nil if there is no author script.
+func (r *Rebase) readAuthorScript() (*object.Signature, error) {
+	data, err := r.readState("author-script")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			continue
+		}
+		value, ok := shellUnquote(line[i+1:])
+		if !ok {
+			return nil, ErrInvalidAuthorScript
+		}
+		values[line[:i]] = value
+	}
+	name, nameOK := values["GIT_AUTHOR_NAME"]
+	email, emailOK := values["GIT_AUTHOR_EMAIL"]
+	date, dateOK := values["GIT_AUTHOR_DATE"]
+	if !nameOK || !emailOK || !dateOK {
+		return nil, ErrInvalidAuthorScript
+	}
+	when, err := commit.ParseDate(date)
+	if err != nil {
+		return nil, err
+	}
+	return &object.Signature{Name: name, Email: email, When: when}, nil
+}
+
+// shellQuote quotes the value by single quotes like sq_quote_buf of git, ' and ! are escaped.
+func shellQuote(value string) string {
+	buf := &strings.Builder{}
+	buf.WriteByte('\'')
+	for _, c := range []byte(value) {
+		if c == '\'' || c == '!' {
+			buf.WriteString("'\\" + string(c) + "'")
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	buf.WriteByte('\'')
+	return buf.String()
+}
+
+// shellUnquote removes quotes added by shellQuote.
+func shellUnquote(quoted string) (string, bool) {
+	buf := &strings.Builder{}
+	for quoted != "" {
+		if quoted[0] == '\\' && len(quoted) > 1 {
+			buf.WriteByte(quoted[1])
+			quoted = quoted[2:]
+			continue
+		}
+		if quoted[0] != '\'' {
+			return "", false
+		}
+		end := strings.IndexByte(quoted[1:], '\'')
+		if end < 0 {
+			return "", false
+		}
+		buf.WriteString(quoted[1 : 1+end])
+		quoted = quoted[end+2:]
+	}
+	return buf.String(), true
+}
+
+// recordRewritten records that the commit is rewritten to HEAD in .git/rebase-merge/rewritten-list.
+// commits of a chain of fixups are kept pending until the last fixup of the chain is done.
+func (r *Rebase) recordRewritten(digest []byte, next Action) error {
+	if err := r.appendState("rewritten-pending", []byte(hex.EncodeToString(digest)+"\n")); err != nil {
+		return err
+	}
+	if isFixup(next) {
+		return nil
+	}
+	return r.flushRewritten()
+}
+
+// flushRewritten records pending commits as rewritten to HEAD.
+func (r *Rebase) flushRewritten() error {
+	pending, err := r.readState("rewritten-pending")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	head, _, err := r.s.head()
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	for _, line := range strings.Split(string(pending), "\n") {
+		if line != "" {
+			fmt.Fprintf(buf, "%s %s\n", line, hex.EncodeToString(head))
+		}
+	}
+	if err := r.appendState("rewritten-list", buf.Bytes()); err != nil {
+		return err
+	}
+	return r.removeFiles(rebasePath("rewritten-pending"))
+}
+
+// writeUpdateRefs writes .git/rebase-merge/update-refs, which has three lines for each reference.
+//
+//  <name>
+//  <digest when the rebase started>
+//  <digest which it's updated to>
+func (r *Rebase) writeUpdateRefs(updates []*updateRef) error {
+	buf := &bytes.Buffer{}
+	for _, update := range updates {
+		fmt.Fprintf(buf, "%s\n%s\n%s\n", update.name, hex.EncodeToString(update.before), hex.EncodeToString(update.after))
+	}
+	return r.writeState("update-refs", buf.Bytes())
+}
+
+// readUpdateRefs reads .git/rebase-merge/update-refs, it's empty if the file doesn't exist.
+func (r *Rebase) readUpdateRefs() ([]*updateRef, error) {
+	data, err := r.readState("update-refs")
+	if os.IsNotExist(err) {
+		return []*updateRef{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines)%3 != 0 {
+		return nil, ErrInvalidTodo
+	}
+	updates := []*updateRef{}
+	for i := 0; i+2 < len(lines); i += 3 {
+		before, err := refs.ParseDigest(lines[i+1])
+		if err != nil {
+			return nil, ErrInvalidTodo
+		}
+		after, err := refs.ParseDigest(lines[i+2])
+		if err != nil {
+			return nil, ErrInvalidTodo
+		}
+		updates = append(updates, &updateRef{name: lines[i], before: before, after: after})
+	}
+	return updates, nil
+}
+
+// removeRebaseState removes .git/rebase-merge and references of labels created by the rebase.
+func (r *Rebase) removeRebaseState() error {
+	labels, err := r.readState("refs-to-be-deleted")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, name := range strings.Split(string(labels), "\n") {
+		if name == "" {
+			continue
+		}
+		if err := r.s.refs.Delete(name, nil); err != nil && err != refs.ErrRefNotFound {
+			return err
+		}
+	}
+	return os.RemoveAll(r.s.path(rebaseDir))
+}
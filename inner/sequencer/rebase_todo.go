@@ -0,0 +1,520 @@
+package sequencer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/shumon84/mogit/inner/diff"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/refs"
+	"github.com/shumon84/mogit/inner/revwalk"
+	"github.com/shumon84/mogit/inner/xdiff"
+)
+
+// makeTodo lists instructions to rebase commits reachable from head but not from upstream like sequencer_make_script.
+// commits whose changes are already in upstream are left out, and merge commits are left out unless RebaseMerges.
+func (r *Rebase) makeTodo(upstream, head []byte, options *RebaseOptions, result *Result) ([]*Instruction, error) {
+	w, err := revwalk.NewWalker(r.s.gitDir, &revwalk.Options{Order: revwalk.TopoOrder, Reverse: true})
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Hide(upstream); err != nil {
+		return nil, err
+	}
+	if err := w.PushDigest(head); err != nil {
+		return nil, err
+	}
+	commits, err := w.All()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := r.appliedCommits(upstream, head, commits)
+	if err != nil {
+		return nil, err
+	}
+	empty := map[string]bool{}
+	for _, commit := range commits {
+		if empty[string(commit.Digest)], err = r.isEmptyCommit(commit.Commit); err != nil {
+			return nil, err
+		}
+		if applied[string(commit.Digest)] && !empty[string(commit.Digest)] {
+			result.Messages = append(result.Messages, "warning: skipped previously applied commit "+r.s.abbrev(commit.Digest))
+		}
+	}
+	skip := func(commit *revwalk.Commit) bool {
+		return applied[string(commit.Digest)] && !empty[string(commit.Digest)]
+	}
+	pick := func(commit *revwalk.Commit) *Instruction {
+		ins := &Instruction{Action: Pick, Commit: commit.Digest, Arg: commit.Summary()}
+		if empty[string(commit.Digest)] {
+			ins.Arg += " # empty"
+		}
+		return ins
+	}
+	if options.RebaseMerges {
+		bases, err := revwalk.MergeBases(r.s.gitDir, upstream, head)
+		if err != nil {
+			return nil, err
+		}
+		return r.makeMergesTodo(commits, bases, skip, pick)
+	}
+	todo := []*Instruction{}
+	for _, commit := range commits {
+		if len(commit.Parents) > 1 || skip(commit) {
+			continue
+		}
+		todo = append(todo, pick(commit))
+	}
+	return todo, nil
+}
+
+// isEmptyCommit reports whether the commit has the same tree as its first parent.
+func (r *Rebase) isEmptyCommit(commit *object.Commit) (bool, error) {
+	var parent *object.Commit
+	if len(commit.Parents) > 0 {
+		var err error
+		if parent, err = r.s.readCommit(commit.Parents[0]); err != nil {
+			return false, err
+		}
+	}
+	tree, err := r.s.treeOf(parent)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(tree, commit.Tree), nil
+}
+
+// appliedCommits returns commits whose patches are the same as commits reachable from upstream but not from head,
+// like $ git log --cherry-mark upstream...head
+func (r *Rebase) appliedCommits(upstream, head []byte, commits []*revwalk.Commit) (map[string]bool, error) {
+	applied := map[string]bool{}
+	if len(commits) == 0 {
+		return applied, nil
+	}
+	w, err := revwalk.NewWalker(r.s.gitDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Hide(head); err != nil {
+		return nil, err
+	}
+	if err := w.PushDigest(upstream); err != nil {
+		return nil, err
+	}
+	others, err := w.All()
+	if err != nil {
+		return nil, err
+	}
+	patches := map[string]bool{}
+	for _, commit := range others {
+		if len(commit.Parents) > 1 {
+			continue
+		}
+		id, err := r.patchID(commit.Commit)
+		if err != nil {
+			return nil, err
+		}
+		patches[string(id)] = true
+	}
+	if len(patches) == 0 {
+		return applied, nil
+	}
+	for _, commit := range commits {
+		if len(commit.Parents) > 1 {
+			continue
+		}
+		id, err := r.patchID(commit.Commit)
+		if err != nil {
+			return nil, err
+		}
+		if patches[string(id)] {
+			applied[string(commit.Digest)] = true
+		}
+	}
+	return applied, nil
+}
+
+// patchID returns a digest of changes of the commit like $ git patch-id
+// line numbers, digests of blobs and white spaces don't affect it.
+func (r *Rebase) patchID(commit *object.Commit) ([]byte, error) {
+	var parent []byte
+	if len(commit.Parents) > 0 {
+		parent = commit.Parents[0]
+	}
+	changes, err := diff.TreeToTree(r.s.gitDir, parent, commit.Tree, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err := diff.WritePatch(buf, r.s.gitDir, changes, &diff.PatchOptions{Options: xdiff.Options{Context: xdiff.DefaultContext}}); err != nil {
+		return nil, err
+	}
+	hash := sha1.New()
+	scanner := bufio.NewScanner(buf)
+	scanner.Buffer(nil, 1<<30)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "index ") || strings.HasPrefix(line, "@@") {
+			continue
+		}
+		hash.Write([]byte(strings.Map(func(c rune) rune {
+			if unicode.IsSpace(c) {
+				return -1
+			}
+			return c
+		}, line)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// labelState is a type to name commits of the todo list of RebaseMerges.
+type labelState struct {
+	labels       map[string]bool   // labels in use, they are compared case-insensitively
+	commitLabels map[string]string // labels of commits
+	abbrev       func(digest []byte) string
+}
+
+// label returns the label of the commit like label_oid of git, a new label is created from name if it has no label.
+// empty name means an uninteresting commit, and it's labeled by the abbreviated digest.
+func (st *labelState) label(digest []byte, name string) string {
+	if label, ok := st.commitLabels[string(digest)]; ok {
+		return label
+	}
+	var label string
+	if name == "" {
+		label = st.abbrev(digest)
+		full := hex.EncodeToString(digest)
+		for n := len(label) + 1; st.labels[strings.ToLower(label)] && n <= len(full); n++ {
+			label = full[:n]
+		}
+	} else {
+		buf := []byte{}
+		for _, c := range []byte(name) {
+			if c&0x80 != 0 || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+				buf = append(buf, c)
+			} else if len(buf) > 0 && buf[len(buf)-1] != '-' {
+				// avoid leading and consecutive dashes
+				buf = append(buf, '-')
+			}
+		}
+		if len(buf) == 0 {
+			buf = append(buf, "rev-"+st.abbrev(digest)...)
+		}
+		label = string(buf)
+		if _, err := hex.DecodeString(label); err == nil && len(label) == 2*object.DigestSize || label == "#" || st.labels[strings.ToLower(label)] {
+			for i := 2; ; i++ {
+				if numbered := label + "-" + strconv.Itoa(i); !st.labels[strings.ToLower(numbered)] {
+					label = numbered
+					break
+				}
+			}
+		}
+	}
+	st.labels[strings.ToLower(label)] = true
+	st.commitLabels[string(digest)] = label
+	return label
+}
+
+// mergeLabel returns the name of the label of the branch merged by the merge commit from its subject.
+func mergeLabel(subject string) string {
+	if rest := strings.TrimPrefix(subject, "Merge "); rest != subject {
+		if i := strings.IndexByte(rest, '\''); i >= 0 {
+			if j := strings.IndexByte(rest[i+1:], '\''); j >= 0 {
+				return rest[i+1 : i+1+j]
+			}
+		}
+	}
+	if rest := strings.TrimPrefix(subject, "Merge pull request "); rest != subject {
+		if i := strings.Index(rest, " from "); i >= 0 {
+			return rest[i+len(" from "):]
+		}
+	}
+	return subject
+}
+
+// makeMergesTodo lists instructions which recreate the topology of commits with label, reset and merge
+// like make_script_with_merges of git. the first merge base is labeled "onto", it's replaced with the new base.
+func (r *Rebase) makeMergesTodo(commits []*revwalk.Commit, bases [][]byte, skip func(*revwalk.Commit) bool, pick func(*revwalk.Commit) *Instruction) ([]*Instruction, error) {
+	st := &labelState{labels: map[string]bool{}, commitLabels: map[string]string{}, abbrev: r.s.abbrev}
+	if len(bases) > 0 {
+		st.labels["onto"] = true
+		st.commitLabels[string(bases[0])] = "onto"
+	}
+	interesting := map[string]*revwalk.Commit{}
+	instructions := map[string]*Instruction{}
+	tips := [][]byte{}
+	for _, commit := range commits {
+		interesting[string(commit.Digest)] = commit
+		if skip(commit) {
+			continue
+		}
+		if len(commit.Parents) <= 1 {
+			instructions[string(commit.Digest)] = pick(commit)
+			continue
+		}
+		subject := commit.Summary()
+		name := mergeLabel(subject)
+		labels := []string{}
+		for _, parent := range commit.Parents[1:] {
+			if interesting[string(parent)] == nil {
+				labels = append(labels, st.label(parent, ""))
+				continue
+			}
+			tips = append(tips, parent)
+			labels = append(labels, st.label(parent, name))
+		}
+		instructions[string(commit.Digest)] = &Instruction{
+			Action: Merge,
+			Commit: commit.Digest,
+			Arg:    strings.Join(labels, " ") + " # " + subject,
+		}
+	}
+
+	// label branch points, and add HEAD as the implicit tip
+	seen := map[string]bool{}
+	for i, commit := range commits {
+		for _, parent := range commit.Parents {
+			if interesting[string(parent)] == nil {
+				continue
+			}
+			if seen[string(parent)] {
+				st.label(parent, "branch-point")
+			}
+			seen[string(parent)] = true
+		}
+		if i == len(commits)-1 {
+			tips = append(tips, commit.Digest)
+		}
+	}
+
+	// walk back from each tip gathering commits not shown yet, and output them in reverse
+	todo := []*Instruction{{Action: Label, Arg: "onto"}}
+	shown := map[string]bool{}
+	for _, tip := range tips {
+		if shown[string(tip)] {
+			continue
+		}
+		todo = append(todo, &Instruction{Action: Comment})
+		if label, ok := st.commitLabels[string(tip)]; ok {
+			todo = append(todo, &Instruction{Action: Comment, Arg: "# Branch " + label})
+		}
+		list := []*revwalk.Commit{}
+		var stop []byte
+		for digest := tip; ; {
+			commit := interesting[string(digest)]
+			if commit == nil || shown[string(digest)] {
+				stop = digest
+				break
+			}
+			list = append([]*revwalk.Commit{commit}, list...)
+			if len(commit.Parents) == 0 {
+				break
+			}
+			digest = commit.Parents[0]
+		}
+		if stop == nil {
+			todo = append(todo, &Instruction{Action: Reset, Arg: "[new root]"})
+		} else if to := st.label(stop, ""); to == "onto" {
+			todo = append(todo, &Instruction{Action: Reset, Arg: "onto"})
+		} else {
+			commit, err := r.s.readCommit(stop)
+			if err != nil {
+				return nil, err
+			}
+			todo = append(todo, &Instruction{Action: Reset, Arg: to + " # " + commit.Summary()})
+		}
+		for _, commit := range list {
+			if ins, ok := instructions[string(commit.Digest)]; ok {
+				todo = append(todo, ins)
+			}
+			if label, ok := st.commitLabels[string(commit.Digest)]; ok {
+				todo = append(todo, &Instruction{Action: Label, Arg: label})
+			}
+			shown[string(commit.Digest)] = true
+		}
+	}
+	return todo, nil
+}
+
+// updateRef is a reference updated at the end of the rebase like a record of .git/rebase-merge/update-refs.
+type updateRef struct {
+	name   string
+	before []byte // digest of the reference when the rebase started
+	after  []byte // digest which the reference is updated to, ZeroDigest until update-ref is done
+}
+
+// addUpdateRefs inserts update-ref after instructions of commits which other local branches point to
+// like $ git rebase --update-refs. the branch being rebased isn't updated by update-ref.
+func (r *Rebase) addUpdateRefs(todo []*Instruction, headName string) ([]*Instruction, []*updateRef, error) {
+	branches, err := r.s.refs.List(refs.HeadPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	decorations := map[string][]*refs.Ref{}
+	for _, branch := range branches {
+		if branch.IsSymbolic() || branch.Name == headName {
+			continue
+		}
+		// git lists decorations of a commit in the reverse order of names
+		decorations[string(branch.Digest)] = append([]*refs.Ref{branch}, decorations[string(branch.Digest)]...)
+	}
+	added := []*Instruction{}
+	updates := []*updateRef{}
+	for _, ins := range todo {
+		added = append(added, ins)
+		if ins.Commit == nil {
+			continue
+		}
+		for _, branch := range decorations[string(ins.Commit)] {
+			// the reference is written with a newline in git, so a blank line follows
+			added = append(added, &Instruction{Action: UpdateRef, Arg: branch.Name}, &Instruction{Action: Comment})
+			updates = append(updates, &updateRef{name: branch.Name, before: branch.Digest, after: refs.ZeroDigest})
+		}
+	}
+	sort.SliceStable(updates, func(i, j int) bool {
+		return updates[i].name < updates[j].name
+	})
+	return added, updates, nil
+}
+
+// fixupPrefixes are prefixes of subjects of commits which autosquash moves.
+var fixupPrefixes = []string{"fixup!", "amend!", "squash!"}
+
+// trimFixup removes a prefix of fixupPrefixes, it returns false if the subject has no prefix.
+func trimFixup(subject string) (string, bool) {
+	for _, prefix := range fixupPrefixes {
+		if strings.HasPrefix(subject, prefix) {
+			return subject[len(prefix):], true
+		}
+	}
+	return subject, false
+}
+
+// rearrangeSquash moves commits whose subjects start with "fixup! ", "squash! " or "amend! " after the commit
+// which they refer to like $ git rebase --autosquash. the rest of the subject is the subject of the commit,
+// a prefix of the subject, or a name of the commit.
+func (r *Rebase) rearrangeSquash(todo []*Instruction) ([]*Instruction, error) {
+	next := make([]int, len(todo))
+	tail := make([]int, len(todo))
+	subjects := make([]string, len(todo))
+	hasSubject := make([]bool, len(todo))
+	bySubject := map[string]int{}
+	byCommit := map[string]int{}
+	rearranged := false
+	for i, ins := range todo {
+		next[i], tail[i] = -1, -1
+		if ins.Commit == nil || ins.Action == Drop {
+			continue
+		}
+		commit, err := r.s.readCommit(ins.Commit)
+		if err != nil {
+			return nil, err
+		}
+		subject := commit.Summary()
+		subjects[i], hasSubject[i] = subject, true
+		target := -1
+		if rest, ok := trimFixup(subject); ok {
+			for ok {
+				rest, ok = trimFixup(strings.TrimLeftFunc(rest, unicode.IsSpace))
+			}
+			if j, found := bySubject[rest]; found {
+				target = j
+			} else if j, found := r.commitIndex(rest, byCommit); found {
+				target = j
+			} else {
+				for j := 0; j < i; j++ {
+					if hasSubject[j] && strings.HasPrefix(subjects[j], rest) {
+						target = j
+						break
+					}
+				}
+			}
+		}
+		if target >= 0 {
+			rearranged = true
+			switch {
+			case strings.HasPrefix(subject, "fixup!"):
+				ins.Action = Fixup
+			case strings.HasPrefix(subject, "amend!"):
+				ins.Action, ins.ReplaceMessage = Fixup, true
+			default:
+				ins.Action = Squash
+			}
+			if tail[target] < 0 {
+				next[i], next[target] = next[target], i
+			} else {
+				next[i], next[tail[target]] = next[tail[target]], i
+			}
+			tail[target] = i
+		} else if _, found := bySubject[subject]; !found {
+			bySubject[subject] = i
+		}
+		byCommit[string(ins.Commit)] = i
+	}
+	if !rearranged {
+		return todo, nil
+	}
+	rearrangedTodo := make([]*Instruction, 0, len(todo))
+	for i, ins := range todo {
+		if isFixup(ins.Action) {
+			continue
+		}
+		for cur := i; cur >= 0; cur = next[cur] {
+			rearrangedTodo = append(rearrangedTodo, todo[cur])
+		}
+	}
+	return rearrangedTodo, nil
+}
+
+// commitIndex returns the index of the instruction of the commit named name, the name must not have spaces.
+func (r *Rebase) commitIndex(name string, byCommit map[string]int) (int, bool) {
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return 0, false
+	}
+	digest, err := r.resolver.ResolveCommit(name)
+	if err != nil {
+		return 0, false
+	}
+	i, ok := byCommit[string(digest)]
+	return i, ok
+}
+
+// addExecCommands inserts exec of the commands after each commit like $ git rebase --exec
+// they follow the last fixup or squash of the commit.
+func addExecCommands(todo []*Instruction, commands []string) []*Instruction {
+	if len(commands) == 0 {
+		return todo
+	}
+	execs := func() []*Instruction {
+		list := make([]*Instruction, len(commands))
+		for i, command := range commands {
+			list[i] = &Instruction{Action: Exec, Arg: command}
+		}
+		return list
+	}
+	added := make([]*Instruction, 0, len(todo)+len(commands))
+	insert := false
+	for _, ins := range todo {
+		if insert && !isFixup(ins.Action) {
+			added = append(added, execs()...)
+			insert = false
+		}
+		added = append(added, ins)
+		if ins.Action == Pick || ins.Action == Merge {
+			insert = true
+		}
+	}
+	if insert {
+		added = append(added, execs()...)
+	}
+	return added
+}
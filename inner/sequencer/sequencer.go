@@ -0,0 +1,422 @@
+// sequencer is a package to cherry-pick, revert and rebase commits like $ git cherry-pick, $ git revert and $ git rebase
+//
+// Changes of commits are applied to HEAD one by one with the tree merge engine. cherry-picking a commit merges
+// changes from its parent to it, and reverting a commit merges changes from it to its parent.
+// A new commit is created for each commit unless NoCommit.
+//
+// When a commit conflicts, conflicting files are left in the working tree and the index, and the sequence stops.
+// it is resumed by Continue after the conflicts are resolved, or by Skip which drops the commit.
+// Abort rewinds HEAD to the commit where the sequence started.
+//
+// Progress is saved in the same files as git, so a sequence stopped by mogit can be resumed by git and vice versa.
+//
+//  .git/sequencer/head          - HEAD when the sequence started
+//  .git/sequencer/todo          - commits not picked yet like "pick <abbreviated digest> <subject>"
+//  .git/sequencer/done          - commits already picked in the same format
+//  .git/sequencer/opts          - options of the sequence in git config format
+//  .git/sequencer/abort-safety  - HEAD after the last commit created by the sequence
+//  .git/CHERRY_PICK_HEAD        - the commit being cherry-picked when the sequence stops
+//  .git/REVERT_HEAD             - the commit being reverted when the sequence stops
+//  .git/MERGE_MSG               - the message of the commit being created
+//
+// A sequence of one commit doesn't create .git/sequencer like git.
+//
+// Rebase replays commits on a new base by the todo list of Instruction, which is the same as
+// the todo list of $ git rebase -i, and picks, squashes, merges and labels commits with the same engine.
+// Its progress is saved in .git/rebase-merge like the merge backend of git.
+//
+//  .git/rebase-merge/head-name        - the branch being rebased, or "detached HEAD"
+//  .git/rebase-merge/onto             - the new base
+//  .git/rebase-merge/orig-head        - HEAD when the rebase started
+//  .git/rebase-merge/git-rebase-todo  - instructions not done yet
+//  .git/rebase-merge/done             - instructions already done
+//  .git/rebase-merge/msgnum, end      - the number of commands done and all commands
+//  .git/rebase-merge/rewritten-list   - pairs of digests of original and rewritten commits
+//  .git/rebase-merge/message-squash   - the message of the chain of fixup and squash
+//  .git/rebase-merge/update-refs      - branches updated at the end of the rebase
+//  .git/REBASE_HEAD                   - the commit being applied when the rebase stops
+//
+// If you want to know more about cherry-pick, revert and rebase, please refer to
+// https://git-scm.com/docs/git-cherry-pick
+// https://git-scm.com/docs/git-revert
+// https://git-scm.com/docs/git-rebase
+package sequencer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"github.com/shumon84/mogit/inner/commit"
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/merge"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/refs"
+	"github.com/shumon84/mogit/inner/util"
+	"github.com/shumon84/mogit/inner/worktree"
+)
+
+// Action is a type representing what is done to a commit.
+type Action int
+
+// constants of Action
+// they are also commands of the todo list of rebase, see Instruction.
+const (
+	Pick      Action = iota // apply changes of the commit
+	Revert                  // apply reverse changes of the commit
+	Edit                    // apply changes of the commit, and stop for amending
+	Reword                  // apply changes of the commit, and edit the message
+	Fixup                   // meld changes of the commit into the previous commit, and discard the message
+	Squash                  // meld changes and the message of the commit into the previous commit
+	Exec                    // run the command by the shell
+	Break                   // stop the rebase here
+	Label                   // label HEAD with the name
+	Reset                   // reset HEAD to the label
+	Merge                   // create a merge commit of HEAD and the labels
+	UpdateRef               // update the reference to HEAD at this point when the rebase finishes
+	Noop                    // do nothing
+	Drop                    // drop the commit
+	Comment                 // a comment or a blank line
+)
+
+// actionNames are commands of the todo list indexed by Action
+var actionNames = []string{"pick", "revert", "edit", "reword", "fixup", "squash", "exec", "break",
+	"label", "reset", "merge", "update-ref", "noop", "drop", "#"}
+
+// String is implementation of fmt.Stringer interface, it returns the command of the todo list.
+func (a Action) String() string {
+	if a < 0 || int(a) >= len(actionNames) {
+		return "unknown"
+	}
+	return actionNames[a]
+}
+
+// name returns the name of the command used in reflog messages.
+func (a Action) name() string {
+	if a == Revert {
+		return "revert"
+	}
+	return "cherry-pick"
+}
+
+// Options is a type representing options of cherry-picking and reverting.
+type Options struct {
+	Mainline             int  // parent number of merge commits like -m <parent-number>, it's 1-origin
+	NoCommit             bool // apply changes to the index and the working tree without committing like --no-commit
+	RecordOrigin         bool // append "(cherry picked from commit <digest>)" to messages like -x
+	Signoff              bool // append "Signed-off-by" trailer of the committer like --signoff
+	AllowEmpty           bool // commit commits which are empty originally like --allow-empty
+	KeepRedundantCommits bool // commit commits which become empty like --keep-redundant-commits
+	AllowFastForward     bool // fast-forward if the parent of the commit is HEAD like --ff
+
+	Strategy        merge.Strategy // merge strategy like --strategy
+	StrategyOptions []string       // options of the strategy like -X<option>
+}
+
+// Result is a type representing what the sequence did.
+type Result struct {
+	Commits   [][]byte // SHA1 digests of commits created or fast-forwarded to in order
+	Stopped   []byte   // SHA1 digest of the commit where the sequence stopped, nil if the sequence is finished
+	Conflicts bool     // whether the sequence stopped because of conflicts
+	Empty     bool     // whether the sequence stopped because the commit became empty
+	Messages  []string // messages like "CONFLICT (content): Merge conflict in <path>"
+}
+
+// Sequencer is a type to cherry-pick and revert commits in the repository.
+type Sequencer struct {
+	gitDir  string
+	workDir string
+	config  *config.Config
+	refs    refs.Store
+
+	// Committer signs commits made by cherry-pick and revert and their reflog entries.
+	// it's also the fallback author when no author identity is configured, nil resolves it like git.
+	Committer *object.Signature
+}
+
+// NewSequencer creates a Sequencer of the repository.
+// gitDir of parameters must be path to .git directory.
+func NewSequencer(gitDir string) (*Sequencer, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	store, err := refs.NewStore(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Sequencer{
+		gitDir:  gitDir,
+		workDir: filepath.Dir(gitDir),
+		config:  cfg,
+		refs:    store,
+	}, nil
+}
+
+// OpenSequencer creates a Sequencer of current repository.
+func OpenSequencer() (*Sequencer, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewSequencer(gitDir)
+}
+
+// InProgress reports whether a cherry-pick or a revert is in progress.
+func (s *Sequencer) InProgress() bool {
+	return s.inSequence() || s.pickHead() != ""
+}
+
+// CherryPick applies changes of the commits to HEAD in order like $ git cherry-pick <commit>...
+// commits are SHA1 digests of commits, and options may be nil.
+func (s *Sequencer) CherryPick(commits [][]byte, options *Options) (*Result, error) {
+	return s.start(Pick, commits, options)
+}
+
+// Revert applies reverse changes of the commits to HEAD in order like $ git revert <commit>...
+// commits are SHA1 digests of commits, and options may be nil.
+func (s *Sequencer) Revert(commits [][]byte, options *Options) (*Result, error) {
+	return s.start(Revert, commits, options)
+}
+
+// start starts a new sequence doing the action to the commits.
+func (s *Sequencer) start(action Action, commits [][]byte, options *Options) (*Result, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	if s.InProgress() {
+		return nil, ErrInProgress
+	}
+	if len(commits) == 0 {
+		return nil, ErrNoCommits
+	}
+	items := make([]*item, 0, len(commits))
+	for _, digest := range commits {
+		commit, err := s.readCommit(digest)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, s.newItem(action, digest, commit))
+	}
+	if len(items) > 1 {
+		head, _, err := s.head()
+		if err != nil {
+			return nil, err
+		}
+		if err := s.saveSequence(head, items, options); err != nil {
+			return nil, err
+		}
+	}
+	return s.run(items, options, newResult())
+}
+
+func newResult() *Result {
+	return &Result{Commits: [][]byte{}, Messages: []string{}}
+}
+
+// run does the items in order, and removes the state when all items are done.
+// the first item is left in the todo list if the sequence stops.
+func (s *Sequencer) run(items []*item, options *Options, result *Result) (*Result, error) {
+	for len(items) > 0 {
+		it := items[0]
+		stopped, err := s.pick(it, options, result)
+		if err != nil {
+			return nil, err
+		}
+		if stopped {
+			result.Stopped = it.digest
+			return result, nil
+		}
+		items = items[1:]
+		if s.inSequence() {
+			if err := s.advance(items, it); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, s.removeState()
+}
+
+// advance saves the rest of the todo list, and records HEAD after the done item.
+func (s *Sequencer) advance(items []*item, done *item) error {
+	if err := s.saveTodo(items, done); err != nil {
+		return err
+	}
+	head, _, err := s.head()
+	if err != nil {
+		return err
+	}
+	return s.saveAbortSafety(head)
+}
+
+// Continue commits the resolution of the stopped commit, and resumes the sequence like $ git cherry-pick --continue
+// the index must not have conflicts, and the message is taken from .git/MERGE_MSG with comments stripped.
+func (s *Sequencer) Continue() (*Result, error) {
+	name := s.pickHead()
+	if !s.inSequence() && name == "" {
+		return nil, ErrNoOperation
+	}
+	options, err := s.readOpts()
+	if err != nil {
+		return nil, err
+	}
+	result := newResult()
+	if name != "" {
+		if err := s.commitResolution(name, options, result); err != nil {
+			return nil, err
+		}
+	}
+	return s.resume(result)
+}
+
+// resume drops the first item of the todo list, and does the rest of items.
+func (s *Sequencer) resume(result *Result) (*Result, error) {
+	if !s.inSequence() {
+		return result, nil
+	}
+	items, options, err := s.readSequence()
+	if err != nil {
+		return nil, err
+	}
+	if !options.NoCommit {
+		_, commit, err := s.head()
+		if err != nil {
+			return nil, err
+		}
+		idx, _, err := worktree.ReadIndex(s.gitDir)
+		if err != nil {
+			return nil, err
+		}
+		if clean, err := s.matchesHead(idx, commit); err != nil || !clean {
+			if err == nil {
+				err = ErrDirtyIndex
+			}
+			return nil, err
+		}
+	}
+	if len(items) > 0 {
+		if err := s.advance(items[1:], items[0]); err != nil {
+			return nil, err
+		}
+		items = items[1:]
+	}
+	return s.run(items, options, result)
+}
+
+// Skip drops the stopped commit and resumes the sequence like $ git cherry-pick --skip
+// changes of the stopped commit are removed from the index and the working tree.
+func (s *Sequencer) Skip() (*Result, error) {
+	name := s.pickHead()
+	if !s.inSequence() && name == "" {
+		return nil, ErrNoOperation
+	}
+	if name != "" {
+		head, _, err := s.head()
+		if err != nil {
+			return nil, err
+		}
+		if err := s.resetMerge(head, head); err != nil {
+			return nil, err
+		}
+		if err := s.removePickState(); err != nil {
+			return nil, err
+		}
+	}
+	return s.resume(newResult())
+}
+
+// Abort rewinds HEAD, the index and the working tree to the commit where the sequence started,
+// and removes the state like $ git cherry-pick --abort
+// HEAD isn't rewound if it's moved by other than the sequence, and a warning is returned in messages.
+func (s *Sequencer) Abort() (*Result, error) {
+	result := newResult()
+	if !s.inSequence() {
+		if s.pickHead() == "" {
+			return nil, ErrNoOperation
+		}
+		head, _, err := s.head()
+		if err != nil {
+			return nil, err
+		}
+		if err := s.resetMerge(head, head); err != nil {
+			return nil, err
+		}
+		return result, s.removePickState()
+	}
+
+	head, _, err := s.head()
+	if err != nil {
+		return nil, err
+	}
+	start, err := s.readDigestFile(filepath.Join(sequencerDir, "head"))
+	if err != nil {
+		return nil, err
+	}
+	safety, err := s.readDigestFile(filepath.Join(sequencerDir, "abort-safety"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if safety == nil || bytes.Equal(safety, head) {
+		if err := s.resetMerge(head, start); err != nil {
+			return nil, err
+		}
+	} else {
+		result.Messages = append(result.Messages, "warning: You seem to have moved HEAD. Not rewinding, check your HEAD!")
+	}
+	if err := s.removePickState(); err != nil {
+		return nil, err
+	}
+	return result, s.removeState()
+}
+
+// removePickState removes CHERRY_PICK_HEAD, REVERT_HEAD, MERGE_MSG and MERGE_RR.
+func (s *Sequencer) removePickState() error {
+	for _, name := range []string{commit.CherryPickHead, commit.RevertHead, commit.MergeMsg, "MERGE_RR"} {
+		if err := os.Remove(s.path(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetMerge updates the index and the working tree from HEAD to the commit, and moves HEAD to it
+// like $ git reset --merge <commit>. HEAD is updated and logged even if it's already the commit.
+// conflicting paths are overwritten, and the other paths must not have local changes.
+// nil target means the empty tree of an unborn branch, HEAD isn't moved then.
+func (s *Sequencer) resetMerge(head, target []byte) error {
+	var tree []byte
+	if target != nil {
+		commit, err := s.readCommit(target)
+		if err != nil {
+			return err
+		}
+		tree = commit.Tree
+	}
+	idx, indexTime, err := worktree.ReadIndex(s.gitDir)
+	if err != nil {
+		return err
+	}
+	next, err := treeIndex(s.gitDir, tree)
+	if err != nil {
+		return err
+	}
+	if err := s.checkout(idx, indexTime, next, tree, false); err != nil {
+		return err
+	}
+	if target == nil {
+		return nil
+	}
+	committer, err := commit.ResolveCommitter(s.config, s.Committer)
+	if err != nil {
+		return err
+	}
+	return s.refs.Update(refs.HEAD, target, head, &refs.LogMessage{
+		Committer: committer,
+		Message:   "reset: moving to " + hex.EncodeToString(target),
+	})
+}
@@ -0,0 +1,279 @@
+package sequencer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/commit"
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/merge"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// item is an instruction of the todo list.
+type item struct {
+	action Action
+	digest []byte
+	line   string // the line as it is, it's written back to the todo list
+}
+
+// newItem creates an item with the line "<action> <abbreviated digest> <subject>".
+func (s *Sequencer) newItem(action Action, digest []byte, commit *object.Commit) *item {
+	return &item{
+		action: action,
+		digest: digest,
+		line:   fmt.Sprintf("%s %s %s", action, s.abbrev(digest), commit.Summary()),
+	}
+}
+
+// parseTodo parses lines of the todo list, blank lines and comments are skipped.
+func (s *Sequencer) parseTodo(data []byte) ([]*item, error) {
+	items := []*item{}
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		fields := strings.Fields(trimmed)
+		if len(fields) < 2 {
+			return nil, ErrInvalidTodo
+		}
+		var action Action
+		switch fields[0] {
+		case "pick", "p":
+			action = Pick
+		case "revert":
+			action = Revert
+		default:
+			return nil, ErrInvalidTodo
+		}
+		candidates, err := object.FindObjects(s.gitDir, strings.ToLower(fields[1]))
+		if err != nil || len(candidates) != 1 {
+			return nil, ErrInvalidTodo
+		}
+		items = append(items, &item{action: action, digest: candidates[0], line: trimmed})
+	}
+	return items, nil
+}
+
+// formatTodo returns lines of the items.
+func formatTodo(items []*item) []byte {
+	buf := &bytes.Buffer{}
+	for _, it := range items {
+		buf.WriteString(it.line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// abbrev returns the shortest unique abbreviation of the digest, it has 7 hex digits at least.
+func (s *Sequencer) abbrev(digest []byte) string {
+	name := hex.EncodeToString(digest)
+	for n := 7; n < len(name); n++ {
+		if candidates, err := object.FindObjects(s.gitDir, name[:n]); err == nil && len(candidates) <= 1 {
+			return name[:n]
+		}
+	}
+	return name
+}
+
+func (s *Sequencer) path(name string) string {
+	return filepath.Join(s.gitDir, name)
+}
+
+// sequencerDir is the directory having the state of cherry-picking or reverting many commits.
+const sequencerDir = "sequencer"
+
+// inSequence reports whether .git/sequencer exists.
+func (s *Sequencer) inSequence() bool {
+	info, err := os.Stat(s.path(sequencerDir))
+	return err == nil && info.IsDir()
+}
+
+// pickHead returns the name of CHERRY_PICK_HEAD or REVERT_HEAD which exists, or empty string.
+func (s *Sequencer) pickHead() string {
+	for _, name := range []string{commit.CherryPickHead, commit.RevertHead} {
+		if _, err := os.Stat(s.path(name)); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// writeFile writes the data to the file in .git directory through a lock file like git.
+func (s *Sequencer) writeFile(name string, data []byte) error {
+	lock, err := util.NewLockFile(s.path(name))
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	if _, err := lock.Write(data); err != nil {
+		return err
+	}
+	return lock.Commit()
+}
+
+// readDigestFile reads the file having hex digits of a digest like .git/sequencer/head.
+func (s *Sequencer) readDigestFile(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	digest, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(digest) != 20 {
+		return nil, ErrInvalidTodo
+	}
+	return digest, nil
+}
+
+// saveSequence creates .git/sequencer with the todo list and the options.
+func (s *Sequencer) saveSequence(head []byte, items []*item, opts *Options) error {
+	if err := os.Mkdir(s.path(sequencerDir), 0777); err != nil {
+		if os.IsExist(err) {
+			return ErrInProgress
+		}
+		return err
+	}
+	headHex := ""
+	if head != nil {
+		headHex = hex.EncodeToString(head)
+	}
+	if err := s.writeFile(filepath.Join(sequencerDir, "head"), []byte(headHex+"\n")); err != nil {
+		return err
+	}
+	if err := s.writeFile(filepath.Join(sequencerDir, "todo"), formatTodo(items)); err != nil {
+		return err
+	}
+	if err := s.writeFile(filepath.Join(sequencerDir, "opts"), formatOpts(opts)); err != nil {
+		return err
+	}
+	return s.saveAbortSafety(head)
+}
+
+// saveTodo writes the rest of the todo list, and appends the done item to the done list.
+func (s *Sequencer) saveTodo(items []*item, done *item) error {
+	if err := s.writeFile(filepath.Join(sequencerDir, "todo"), formatTodo(items)); err != nil {
+		return err
+	}
+	if done == nil {
+		return nil
+	}
+	doneList, err := ioutil.ReadFile(s.path(filepath.Join(sequencerDir, "done")))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.writeFile(filepath.Join(sequencerDir, "done"), append(doneList, formatTodo([]*item{done})...))
+}
+
+// saveAbortSafety records HEAD, Abort doesn't rewind HEAD if it's moved from the recorded commit.
+func (s *Sequencer) saveAbortSafety(head []byte) error {
+	headHex := ""
+	if head != nil {
+		headHex = hex.EncodeToString(head)
+	}
+	return s.writeFile(filepath.Join(sequencerDir, "abort-safety"), []byte(headHex+"\n"))
+}
+
+// readSequence reads the todo list and the options of .git/sequencer.
+func (s *Sequencer) readSequence() ([]*item, *Options, error) {
+	data, err := ioutil.ReadFile(s.path(filepath.Join(sequencerDir, "todo")))
+	if err != nil {
+		return nil, nil, err
+	}
+	items, err := s.parseTodo(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts, err := s.readOpts()
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, opts, nil
+}
+
+// formatOpts returns contents of .git/sequencer/opts, it's the same format as git config.
+// it's empty if all options are default.
+func formatOpts(opts *Options) []byte {
+	buf := &bytes.Buffer{}
+	add := func(key, value string) {
+		if buf.Len() == 0 {
+			buf.WriteString("[options]\n")
+		}
+		fmt.Fprintf(buf, "\t%s = %s\n", key, value)
+	}
+	if opts.NoCommit {
+		add("no-commit", "true")
+	}
+	if opts.Signoff {
+		add("signoff", "true")
+	}
+	if opts.AllowEmpty {
+		add("allow-empty", "true")
+	}
+	if opts.KeepRedundantCommits {
+		add("keep-redundant-commits", "true")
+	}
+	if opts.RecordOrigin {
+		add("record-origin", "true")
+	}
+	if opts.AllowFastForward {
+		add("allow-ff", "true")
+	}
+	if opts.Mainline > 0 {
+		add("mainline", strconv.Itoa(opts.Mainline))
+	}
+	if opts.Strategy != merge.StrategyOrt {
+		add("strategy", opts.Strategy.String())
+	}
+	for _, option := range opts.StrategyOptions {
+		add("strategy-option", option)
+	}
+	return buf.Bytes()
+}
+
+// readOpts reads .git/sequencer/opts, default options are returned if it doesn't exist.
+func (s *Sequencer) readOpts() (*Options, error) {
+	opts := &Options{}
+	cfg, err := config.ReadFile(s.path(filepath.Join(sequencerDir, "opts")))
+	if os.IsNotExist(err) {
+		return opts, nil
+	}
+	if err != nil {
+		return nil, ErrInvalidOpts
+	}
+	flags := map[string]*bool{
+		"options.no-commit":              &opts.NoCommit,
+		"options.signoff":                &opts.Signoff,
+		"options.allow-empty":            &opts.AllowEmpty,
+		"options.keep-redundant-commits": &opts.KeepRedundantCommits,
+		"options.record-origin":          &opts.RecordOrigin,
+		"options.allow-ff":               &opts.AllowFastForward,
+	}
+	for key, flag := range flags {
+		if *flag, err = cfg.Bool(key, false); err != nil {
+			return nil, ErrInvalidOpts
+		}
+	}
+	if opts.Mainline, err = cfg.Int("options.mainline", 0); err != nil {
+		return nil, ErrInvalidOpts
+	}
+	if strategy, ok := cfg.Get("options.strategy"); ok {
+		if opts.Strategy, err = merge.ParseStrategy(strategy); err != nil {
+			return nil, err
+		}
+	}
+	opts.StrategyOptions = cfg.GetAll("options.strategy-option")
+	return opts, nil
+}
+
+// removeState removes .git/sequencer.
+func (s *Sequencer) removeState() error {
+	return os.RemoveAll(s.path(sequencerDir))
+}
@@ -0,0 +1,9 @@
+package sparse
+
+import "errors"
+
+var (
+	ErrNotSparse      = errors.New("must be in a sparse-checkout to reapply sparsity patterns")
+	ErrNoPatterns     = errors.New("no sparse-checkout to add to")
+	ErrInvalidPattern = errors.New("pattern of cone mode must be a directory")
+)
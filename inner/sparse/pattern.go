@@ -0,0 +1,243 @@
+package sparse
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/ignore"
+)
+
+// rootPatterns are the first patterns of cone mode, they include files at the top level directory only.
+const rootPatterns = "/*\n!/*/\n"
+
+// Patterns is a type representing patterns of .git/info/sparse-checkout.
+//
+// In cone mode, patterns are directories. files at the top level directory are always included, files in
+// recursive directories are included in any depth, and files directly in their parent directories are included.
+// the other files are excluded. the patterns are written in the following form like git.
+//
+//  /*          - files at the top level directory
+//  !/*/        - but not directories at the top level directory
+//  /a/         - files in a, it's a parent directory of a/b
+//  !/a/*/      - but not directories in a
+//  /a/b/       - files in a/b in any depth, it's a recursive directory
+//
+// In non-cone mode, patterns are the same as .gitignore, and a path is included if the last pattern matching it
+// or its leading directories is not negative.
+type Patterns struct {
+	Cone     bool     // the patterns are in cone mode
+	Warnings []string // warnings of patterns which aren't in cone mode, cone mode is disabled if it isn't empty
+
+	patterns  []*ignore.Pattern // patterns of non-cone mode
+	full      bool              // "!/*/" isn't given, all files are included in cone mode
+	recursive map[string]bool   // recursive directories of cone mode
+	parents   map[string]bool   // parent directories of cone mode
+}
+
+// ParsePatterns parses contents of .git/info/sparse-checkout.
+// the patterns are parsed in cone mode if cone is true and all of them are in the form of cone mode,
+// otherwise they are parsed in non-cone mode with warnings like git.
+func ParsePatterns(data []byte, cone bool) *Patterns {
+	p := &Patterns{patterns: ignore.ParsePatterns(data, "info/sparse-checkout", "")}
+	if cone {
+		p.Cone = p.parseCone()
+	}
+	return p
+}
+
+// NewConePatterns creates patterns of cone mode which include the directories recursively.
+// directories in other directories are dropped, and their leading directories become parent directories.
+func NewConePatterns(dirs []string) *Patterns {
+	p := &Patterns{Cone: true, recursive: map[string]bool{}, parents: map[string]bool{}}
+	for _, dir := range dirs {
+		dir = strings.Trim(path.Clean("/"+dir), "/")
+		if dir != "" {
+			p.recursive[dir] = true
+		}
+	}
+	for dir := range p.recursive {
+		if p.inRecursive(path.Dir(dir)) {
+			delete(p.recursive, dir)
+		}
+	}
+	for dir := range p.recursive {
+		for parent := path.Dir(dir); parent != "."; parent = path.Dir(parent) {
+			p.parents[parent] = true
+		}
+	}
+	p.patterns = ignore.ParsePatterns(p.Bytes(), "info/sparse-checkout", "")
+	return p
+}
+
+// NewPatterns creates patterns of non-cone mode from lines of .gitignore format.
+func NewPatterns(lines []string) *Patterns {
+	data := &bytes.Buffer{}
+	for _, line := range lines {
+		data.WriteString(line + "\n")
+	}
+	return ParsePatterns(data.Bytes(), false)
+}
+
+// parseCone parses the patterns in cone mode, and reports whether all of them are in cone mode.
+func (p *Patterns) parseCone() bool {
+	p.full = true
+	p.recursive = map[string]bool{}
+	p.parents = map[string]bool{}
+	for _, pattern := range p.patterns {
+		text := pattern.Text
+		switch {
+		case text == "/*":
+			continue
+		case text == "!/*/":
+			p.full = false
+			continue
+		case strings.HasPrefix(text, "!/") && strings.HasSuffix(text, "/*/"):
+			dir, ok := unescape(text[2 : len(text)-3])
+			if ok && p.recursive[dir] {
+				delete(p.recursive, dir)
+				p.parents[dir] = true
+				continue
+			}
+		case strings.HasPrefix(text, "/") && strings.HasSuffix(text, "/") && len(text) > 2:
+			if dir, ok := unescape(text[1 : len(text)-1]); ok {
+				p.recursive[dir] = true
+				continue
+			}
+		}
+		p.Warnings = append(p.Warnings,
+			fmt.Sprintf("warning: unrecognized pattern: '%s'", text),
+			"warning: disabling cone pattern matching")
+		p.recursive, p.parents = nil, nil
+		return false
+	}
+	return true
+}
+
+// unescape removes backslashes escaping wildcards, it returns false if the directory has wildcards.
+func unescape(dir string) (string, bool) {
+	buf := &strings.Builder{}
+	for i := 0; i < len(dir); i++ {
+		switch dir[i] {
+		case '\\':
+			i++
+			if i == len(dir) {
+				return "", false
+			}
+		case '*', '?', '[':
+			return "", false
+		}
+		buf.WriteByte(dir[i])
+	}
+	return buf.String(), true
+}
+
+// escape escapes wildcards and backslashes of the directory with backslashes like git.
+func escape(dir string) string {
+	buf := &strings.Builder{}
+	for i := 0; i < len(dir); i++ {
+		if strings.IndexByte(`*?[\`, dir[i]) >= 0 {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(dir[i])
+	}
+	return buf.String()
+}
+
+// Includes reports whether the file of the path is included in the working tree.
+func (p *Patterns) Includes(name string) bool {
+	if !p.Cone {
+		return p.matches(name)
+	}
+	dir := path.Dir(name)
+	return p.full || dir == "." || p.parents[dir] || p.inRecursive(dir)
+}
+
+// matches reports whether the last pattern matching the path or its leading directories is not negative.
+// leading directories are matched from the nearest one only if no pattern matches the path.
+func (p *Patterns) matches(name string) bool {
+	isDir := false
+	for current := name; current != "."; current, isDir = path.Dir(current), true {
+		for i := len(p.patterns) - 1; i >= 0; i-- {
+			if p.patterns[i].Match(current, isDir, false) {
+				return !p.patterns[i].Negative
+			}
+		}
+	}
+	return false
+}
+
+// inRecursive reports whether the directory is a recursive directory or in one of them.
+func (p *Patterns) inRecursive(dir string) bool {
+	for ; dir != "."; dir = path.Dir(dir) {
+		if p.recursive[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// mayInclude reports whether some files in the directory may be included in cone mode.
+// directories which don't include any files are collapsed into sparse directory entries of a sparse index.
+func (p *Patterns) mayInclude(dir string) bool {
+	if p.full || p.parents[dir] || p.inRecursive(dir) {
+		return true
+	}
+	for _, dirs := range []map[string]bool{p.recursive, p.parents} {
+		for other := range dirs {
+			if strings.HasPrefix(other, dir+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Dirs returns the recursive directories in name order like $ git sparse-checkout list, it's nil in non-cone mode.
+func (p *Patterns) Dirs() []string {
+	if !p.Cone {
+		return nil
+	}
+	dirs := make([]string, 0, len(p.recursive))
+	for dir := range p.recursive {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// Bytes returns contents of .git/info/sparse-checkout having the patterns.
+// patterns of cone mode are written in the form of git, parent directories come first in name order and
+// recursive directories follow them.
+func (p *Patterns) Bytes() []byte {
+	buf := &bytes.Buffer{}
+	if !p.Cone {
+		for _, pattern := range p.patterns {
+			buf.WriteString(pattern.Text + "\n")
+		}
+		return buf.Bytes()
+	}
+	if p.full {
+		buf.WriteString("/*\n")
+		return buf.Bytes()
+	}
+	buf.WriteString(rootPatterns)
+	parents := make([]string, 0, len(p.parents))
+	for dir := range p.parents {
+		if !p.inRecursive(dir) {
+			parents = append(parents, dir)
+		}
+	}
+	sort.Strings(parents)
+	for _, dir := range parents {
+		fmt.Fprintf(buf, "/%s/\n!/%s/*/\n", escape(dir), escape(dir))
+	}
+	for _, dir := range p.Dirs() {
+		if !p.inRecursive(path.Dir(dir)) {
+			fmt.Fprintf(buf, "/%s/\n", escape(dir))
+		}
+	}
+	return buf.Bytes()
+}
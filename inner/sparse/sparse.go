@@ -0,0 +1,320 @@
+// sparse is a package to check out only a part of files in the working tree like $ git sparse-checkout
+//
+// Paths included by patterns in .git/info/sparse-checkout are written to the working tree, and the other paths
+// are kept only in the index with skip-worktree flag. Patterns are directories in cone mode, or .gitignore format
+// in non-cone mode. see Patterns for details.
+//
+// With a sparse index, directories out of the cone are collapsed into sparse directory entries which point to their
+// trees, so the index doesn't grow with files out of the cone. index.ReadIndexFrom expands them for callers which
+// don't know sparse index, and index.Expand expands only a directory when a path in it is needed. an index written
+// by such callers is a full index, and Reapply collapses it again.
+//
+// Sparse checkout is enabled by core.sparseCheckout config, cone mode by core.sparseCheckoutCone config and
+// sparse index by index.sparse config. they are taken from config by NewSparseCheckout, and callers can change
+// them because config isn't written by this package.
+//
+// If you want to know more about sparse checkout, please refer to
+// https://git-scm.com/docs/git-sparse-checkout
+// https://github.com/git/git/blob/master/Documentation/technical/sparse-index.txt
+package sparse
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/checkout"
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// SparseCheckout is a type to change sparse checkout patterns of the repository and apply them.
+type SparseCheckout struct {
+	gitDir   string
+	checkout *checkout.Checkout
+
+	Enabled     bool // core.sparseCheckout, whether sparse checkout is enabled
+	Cone        bool // core.sparseCheckoutCone, whether patterns are in cone mode
+	SparseIndex bool // index.sparse, whether the index is collapsed into a sparse index in cone mode
+	Workers     int  // the number of goroutines writing files, see checkout.Options
+}
+
+// NewSparseCheckout creates a SparseCheckout of the repository.
+// gitDir of parameters must be path to .git directory.
+func NewSparseCheckout(gitDir string) (*SparseCheckout, error) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	c, err := checkout.NewCheckout(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	s := &SparseCheckout{gitDir: gitDir, checkout: c}
+	if s.Enabled, err = cfg.Bool("core.sparseCheckout", false); err != nil {
+		return nil, err
+	}
+	if s.Cone, err = cfg.Bool("core.sparseCheckoutCone", false); err != nil {
+		return nil, err
+	}
+	if s.SparseIndex, err = cfg.Bool("index.sparse", false); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenSparseCheckout creates a SparseCheckout of current repository.
+func OpenSparseCheckout() (*SparseCheckout, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewSparseCheckout(gitDir)
+}
+
+// Patterns reads patterns of .git/info/sparse-checkout, all files are included if it doesn't exist.
+func (s *SparseCheckout) Patterns() (*Patterns, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.gitDir, "info", "sparse-checkout"))
+	if os.IsNotExist(err) {
+		return ParsePatterns([]byte("/*\n"), s.Cone), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParsePatterns(data, s.Cone), nil
+}
+
+// Includes returns a function reporting whether the path is included in the working tree.
+// it can be given to checkout.Options.Sparse, and nil is returned if sparse checkout isn't enabled.
+func (s *SparseCheckout) Includes() (func(name string) bool, error) {
+	if !s.Enabled {
+		return nil, nil
+	}
+	patterns, err := s.Patterns()
+	if err != nil {
+		return nil, err
+	}
+	return patterns.Includes, nil
+}
+
+// Set writes the patterns to .git/info/sparse-checkout and applies them like $ git sparse-checkout set.
+// patterns are directories in cone mode, and lines of .gitignore format in non-cone mode.
+// it doesn't need Enabled, but core.sparseCheckout config should be set by callers like git.
+func (s *SparseCheckout) Set(patterns []string) (*checkout.Result, error) {
+	p, err := s.newPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return s.write(p)
+}
+
+// Add adds the patterns to .git/info/sparse-checkout and applies them like $ git sparse-checkout add.
+func (s *SparseCheckout) Add(patterns []string) (*checkout.Result, error) {
+	if !s.Enabled {
+		return nil, ErrNoPatterns
+	}
+	current, err := s.Patterns()
+	if err != nil {
+		return nil, err
+	}
+	if current.Cone {
+		patterns = append(current.Dirs(), patterns...)
+	} else {
+		lines := make([]string, 0, len(current.patterns)+len(patterns))
+		for _, pattern := range current.patterns {
+			lines = append(lines, pattern.Text)
+		}
+		patterns = append(lines, patterns...)
+	}
+	p, err := s.newPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return s.write(p)
+}
+
+// newPatterns creates patterns of cone mode or non-cone mode by Cone.
+// patterns like "!a" or "a/*" aren't allowed in cone mode.
+func (s *SparseCheckout) newPatterns(patterns []string) (*Patterns, error) {
+	if !s.Cone {
+		return NewPatterns(patterns), nil
+	}
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") || strings.ContainsAny(pattern, "*?[") {
+			return nil, ErrInvalidPattern
+		}
+	}
+	return NewConePatterns(patterns), nil
+}
+
+// write writes the patterns to .git/info/sparse-checkout and applies them.
+func (s *SparseCheckout) write(p *Patterns) (*checkout.Result, error) {
+	if err := os.MkdirAll(filepath.Join(s.gitDir, "info"), 0777); err != nil {
+		return nil, err
+	}
+	lock, err := util.NewLockFile(filepath.Join(s.gitDir, "info", "sparse-checkout"))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Rollback()
+	if _, err := lock.Write(p.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := lock.Commit(); err != nil {
+		return nil, err
+	}
+	return s.apply(p)
+}
+
+// Reapply applies patterns of .git/info/sparse-checkout again like $ git sparse-checkout reapply.
+// files which were left because of local changes or conflicts are removed if they are clean now.
+func (s *SparseCheckout) Reapply() (*checkout.Result, error) {
+	if !s.Enabled {
+		return nil, ErrNotSparse
+	}
+	p, err := s.Patterns()
+	if err != nil {
+		return nil, err
+	}
+	return s.apply(p)
+}
+
+// Disable writes all files to the working tree, and clears skip-worktree flag of entries like
+// $ git sparse-checkout disable. the index becomes a full index.
+// .git/info/sparse-checkout is kept, and core.sparseCheckout config should be unset by callers like git.
+func (s *SparseCheckout) Disable() (*checkout.Result, error) {
+	return s.checkout.Sparsity(&checkout.Options{Workers: s.Workers})
+}
+
+// apply applies the patterns to the index and the working tree, and collapses the index into a sparse index
+// if SparseIndex is true and the patterns are in cone mode.
+func (s *SparseCheckout) apply(p *Patterns) (*checkout.Result, error) {
+	result, err := s.checkout.Sparsity(&checkout.Options{Sparse: p.Includes, Workers: s.Workers})
+	if err != nil {
+		return nil, err
+	}
+	result.Messages = append(append([]string{}, p.Warnings...), result.Messages...)
+	if s.SparseIndex && p.Cone {
+		if err := s.collapseIndex(p); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// collapseIndex writes the index whose directories out of the cone are collapsed into sparse directory entries.
+func (s *SparseCheckout) collapseIndex(p *Patterns) error {
+	idx, err := index.ReadIndexFrom(s.gitDir)
+	if err != nil {
+		return err
+	}
+	entries := make([]*index.Entry, idx.Header().NumOfEntries)
+	for i := range entries {
+		if entries[i], err = idx.Entries(uint32(i)); err != nil {
+			return err
+		}
+	}
+	if entries, err = s.collapse(entries, "", p); err != nil {
+		return err
+	}
+	return index.WriteIndex(s.gitDir, index.NewSparseIndex(entries, idx.ResolveUndo()))
+}
+
+// collapse returns the entries whose directories under the prefix are collapsed if they are out of the cone.
+// entries must be sorted by name. a directory is collapsed only if all entries in it have skip-worktree flag and
+// they aren't unmerged or git links like git, otherwise its sub directories are tried.
+func (s *SparseCheckout) collapse(entries []*index.Entry, prefix string, p *Patterns) ([]*index.Entry, error) {
+	collapsed := make([]*index.Entry, 0, len(entries))
+	for i := 0; i < len(entries); {
+		rest := strings.TrimPrefix(entries[i].Name, prefix)
+		slash := strings.IndexByte(rest, '/')
+		if slash < 0 {
+			collapsed = append(collapsed, entries[i])
+			i++
+			continue
+		}
+		dir := prefix + rest[:slash]
+		j := i + 1
+		for j < len(entries) && strings.HasPrefix(entries[j].Name, dir+"/") {
+			j++
+		}
+		group := entries[i:j]
+		i = j
+		if !p.mayInclude(dir) && canCollapse(group) {
+			tree, err := s.writeTree(group, dir+"/")
+			if err != nil {
+				return nil, err
+			}
+			collapsed = append(collapsed, &index.Entry{
+				ObjectType:   index.Directory,
+				Digest:       tree,
+				SkipWorktree: true,
+				Name:         dir + "/",
+			})
+			continue
+		}
+		sub, err := s.collapse(group, dir+"/", p)
+		if err != nil {
+			return nil, err
+		}
+		collapsed = append(collapsed, sub...)
+	}
+	return collapsed, nil
+}
+
+// canCollapse reports whether all the entries can be in a sparse directory entry.
+func canCollapse(entries []*index.Entry) bool {
+	for _, entry := range entries {
+		if !entry.SkipWorktree || entry.ConflictFlag != index.NoConflict || entry.ObjectType == index.GitLink {
+			return false
+		}
+	}
+	return true
+}
+
+// writeTree writes tree objects of the entries in the directory, and returns the digest of the tree.
+// names of the entries must start with prefix.
+func (s *SparseCheckout) writeTree(entries []*index.Entry, prefix string) ([]byte, error) {
+	type dir struct {
+		entries []*object.TreeEntry
+		subdirs map[string]*dir
+	}
+	root := &dir{subdirs: map[string]*dir{}}
+	for _, entry := range entries {
+		d := root
+		components := strings.Split(strings.TrimPrefix(entry.Name, prefix), "/")
+		for _, component := range components[:len(components)-1] {
+			sub, ok := d.subdirs[component]
+			if !ok {
+				sub = &dir{subdirs: map[string]*dir{}}
+				d.subdirs[component] = sub
+			}
+			d = sub
+		}
+		d.entries = append(d.entries, &object.TreeEntry{
+			Mode:   object.FileMode(uint32(entry.ObjectType)<<12 | uint32(entry.Permission)),
+			Name:   components[len(components)-1],
+			Digest: entry.Digest,
+		})
+	}
+	var write func(d *dir) ([]byte, error)
+	write = func(d *dir) ([]byte, error) {
+		entries := d.entries
+		for name, sub := range d.subdirs {
+			digest, err := write(sub)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, &object.TreeEntry{Mode: object.ModeTree, Name: name, Digest: digest})
+		}
+		return object.WriteObject(s.gitDir, object.NewTree(entries))
+	}
+	return write(root)
+}
@@ -0,0 +1,273 @@
+package status
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/shumon84/mogit/inner/diff"
+	"github.com/shumon84/mogit/inner/ignore"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/refs"
+)
+
+// computer is a type holding state while computing status.
+type computer struct {
+	gitDir    string
+	workDir   string
+	options   Options
+	head      []byte                  // digest of HEAD commit, nil on an unborn branch
+	entries   map[string]*index.Entry // entries at stage 0 by path
+	stages    map[string]uint         // bit set of stages of unmerged paths
+	trackDirs map[string]bool         // directories including tracked paths
+}
+
+func newComputer(gitDir string, options *Options) (*computer, error) {
+	c := &computer{
+		gitDir:    gitDir,
+		workDir:   filepath.Dir(gitDir),
+		entries:   map[string]*index.Entry{},
+		stages:    map[string]uint{},
+		trackDirs: map[string]bool{"": true},
+	}
+	if options != nil {
+		c.options = *options
+	}
+	if c.options.Ignorer == nil {
+		matcher, err := ignore.NewMatcher(gitDir)
+		if err != nil {
+			return nil, err
+		}
+		c.options.Ignorer = matcher
+	}
+	if err := c.readHead(); err != nil {
+		return nil, err
+	}
+	if err := c.readIndex(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readHead resolves HEAD, it is nil on an unborn branch.
+func (c *computer) readHead() error {
+	store, err := refs.NewStore(c.gitDir)
+	if err != nil {
+		return err
+	}
+	head, err := store.Resolve(refs.HEAD)
+	if err == refs.ErrRefNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.head = head.Digest
+	return nil
+}
+
+// readIndex reads entries of the index, it is empty if there is no index file.
+func (c *computer) readIndex() error {
+	idx, err := index.ReadIndexFrom(c.gitDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < idx.Header().NumOfEntries; i++ {
+		entry, err := idx.Entries(i)
+		if err != nil {
+			return err
+		}
+		if entry.ConflictFlag == index.NoConflict {
+			c.entries[entry.Name] = entry
+		} else {
+			c.stages[entry.Name] |= 1 << entry.ConflictFlag
+		}
+		for dir := path.Dir(entry.Name); dir != "."; dir = path.Dir(dir) {
+			c.trackDirs[dir] = true
+		}
+	}
+	return nil
+}
+
+func (c *computer) compute() (*Status, error) {
+	staged, err := diff.TreeToIndex(c.gitDir, c.head, &diff.Options{DetectRenames: !c.options.NoRenames})
+	if err != nil {
+		return nil, err
+	}
+	unstaged, err := diff.IndexToWorktree(c.gitDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	changed := map[string]*FileStatus{}
+	file := func(name string) *FileStatus {
+		if _, ok := changed[name]; !ok {
+			changed[name] = &FileStatus{Path: name, Staging: Unmodified, Worktree: Unmodified}
+		}
+		return changed[name]
+	}
+	for _, change := range staged {
+		if change.Type != diff.Unmerged {
+			file(change.Path()).Staging = changeCode(change.Type)
+		}
+		if change.Type == diff.Renamed {
+			file(change.Path()).OrigPath = change.From.Path
+		}
+	}
+	for _, change := range unstaged {
+		if change.Type != diff.Unmerged {
+			file(change.Path()).Worktree = changeCode(change.Type)
+		}
+	}
+	for name, stages := range c.stages {
+		changed[name] = unmergedStatus(name, stages)
+	}
+
+	files := []*FileStatus{}
+	for _, file := range changed {
+		files = append(files, file)
+	}
+	sortByPath(files)
+	if c.options.Untracked != UntrackedNo {
+		untracked, ignored, err := c.walkDir("")
+		if err != nil {
+			return nil, err
+		}
+		sortByPath(untracked)
+		files = append(files, untracked...)
+		if c.options.Ignored {
+			sortByPath(ignored)
+			files = append(files, ignored...)
+		}
+	}
+	return &Status{Files: files}, nil
+}
+
+// changeCode returns status code of the type of change.
+func changeCode(t diff.ChangeType) Code {
+	switch t {
+	case diff.Added:
+		return Added
+	case diff.Deleted:
+		return Deleted
+	case diff.TypeChanged:
+		return TypeChanged
+	case diff.Renamed:
+		return Renamed
+	default:
+		return Modified
+	}
+}
+
+// unmergedStatus returns status of the unmerged path from the set of its stages.
+func unmergedStatus(name string, stages uint) *FileStatus {
+	const (
+		base   = 1 << index.LowestCommonAncestorCommit
+		ours   = 1 << index.CurrentCommit
+		theirs = 1 << index.AnotherCommit
+	)
+	codes := map[uint]string{
+		base:                 "DD",
+		ours:                 "AU",
+		base | ours:          "UD",
+		theirs:               "UA",
+		base | theirs:        "DU",
+		ours | theirs:        "AA",
+		base | ours | theirs: "UU",
+	}
+	code := codes[stages]
+	return &FileStatus{Path: name, Staging: Code(code[0]), Worktree: Code(code[1])}
+}
+
+// walkDir lists untracked and ignored paths in the directory.
+func (c *computer) walkDir(dir string) ([]*FileStatus, []*FileStatus, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(c.workDir, filepath.FromSlash(dir)))
+	if err != nil {
+		return nil, nil, err
+	}
+	untracked := []*FileStatus{}
+	ignored := []*FileStatus{}
+	for _, info := range infos {
+		name := path.Join(dir, info.Name())
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				continue
+			}
+			if _, ok := c.entries[name]; ok {
+				continue
+			}
+			if c.trackDirs[name] {
+				u, i, err := c.walkDir(name)
+				if err != nil {
+					return nil, nil, err
+				}
+				untracked = append(untracked, u...)
+				ignored = append(ignored, i...)
+				continue
+			}
+			if c.isIgnored(name, true) {
+				ignored = append(ignored, &FileStatus{Path: name + "/", Staging: Ignored, Worktree: Ignored})
+				continue
+			}
+			u, i, err := c.walkUntrackedDir(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			untracked = append(untracked, u...)
+			ignored = append(ignored, i...)
+			continue
+		}
+		if c.isTracked(name) {
+			continue
+		}
+		if c.isIgnored(name, false) {
+			ignored = append(ignored, &FileStatus{Path: name, Staging: Ignored, Worktree: Ignored})
+			continue
+		}
+		untracked = append(untracked, &FileStatus{Path: name, Staging: Untracked, Worktree: Untracked})
+	}
+	return untracked, ignored, nil
+}
+
+// walkUntrackedDir lists paths in the directory which has no tracked paths.
+// in UntrackedNormal mode, the directory itself is reported instead of the files in it.
+func (c *computer) walkUntrackedDir(dir string) ([]*FileStatus, []*FileStatus, error) {
+	if isRepository(filepath.Join(c.workDir, filepath.FromSlash(dir))) {
+		return []*FileStatus{{Path: dir + "/", Staging: Untracked, Worktree: Untracked}}, nil, nil
+	}
+	untracked, ignored, err := c.walkDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.options.Untracked == UntrackedAll {
+		return untracked, ignored, nil
+	}
+	if len(untracked) > 0 {
+		return []*FileStatus{{Path: dir + "/", Staging: Untracked, Worktree: Untracked}}, ignored, nil
+	}
+	if len(ignored) > 0 {
+		return nil, []*FileStatus{{Path: dir + "/", Staging: Ignored, Worktree: Ignored}}, nil
+	}
+	return nil, nil, nil
+}
+
+// isRepository reports whether the directory is a nested repository.
+func isRepository(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+func (c *computer) isTracked(name string) bool {
+	if _, ok := c.entries[name]; ok {
+		return true
+	}
+	_, ok := c.stages[name]
+	return ok
+}
+
+func (c *computer) isIgnored(name string, isDir bool) bool {
+	return c.options.Ignorer.IsIgnored(name, isDir)
+}
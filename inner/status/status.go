@@ -0,0 +1,157 @@
+// status is a package to compute the working tree status like $ git status
+//
+// Each path is reported with two status codes like $ git status --porcelain.
+// Staging is the difference between the tree of HEAD and the index,
+// and Worktree is the difference between the index and the working tree.
+//
+//  ' ' = unmodified       'M' = modified         'T' = file type changed
+//  'A' = added            'D' = deleted          'R' = renamed
+//  'U' = unmerged         '?' = untracked        '!' = ignored
+//
+// Unmerged paths are reported with the following combinations.
+//
+//  DD = both deleted      AU = added by us       UD = deleted by them
+//  UA = added by them     DU = deleted by us     AA = both added
+//  UU = both modified
+//
+// If you want to know more about status, please refer to
+// https://git-scm.com/docs/git-status
+package status
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/shumon84/mogit/inner/util"
+)
+
+// Code is a type representing status of a path.
+type Code byte
+
+// constants of status code
+const (
+	Unmodified  Code = ' '
+	Modified    Code = 'M'
+	TypeChanged Code = 'T'
+	Added       Code = 'A'
+	Deleted     Code = 'D'
+	Renamed     Code = 'R'
+	Unmerged    Code = 'U'
+	Untracked   Code = '?'
+	Ignored     Code = '!'
+)
+
+// UntrackedMode is a type representing how untracked files are reported.
+type UntrackedMode int
+
+// constants of UntrackedMode
+const (
+	UntrackedNormal UntrackedMode = iota // report untracked files and directories
+	UntrackedAll                         // report individual files in untracked directories
+	UntrackedNo                          // don't report untracked files
+)
+
+// Ignorer is interface to decide whether an untracked path is ignored.
+// *ignore.Matcher implements it.
+type Ignorer interface {
+	// IsIgnored reports whether the path is ignored.
+	// path is relative from top level directory and separated by '/'.
+	IsIgnored(path string, isDir bool) bool
+}
+
+// Options is a type representing options of status computation.
+type Options struct {
+	Untracked UntrackedMode // how untracked files are reported
+	Ignored   bool          // report ignored files
+	Ignorer   Ignorer       // nil means .gitignore and exclude files of the repository
+	NoRenames bool          // don't detect renamed paths in the index
+}
+
+// FileStatus is a type representing status of a path.
+type FileStatus struct {
+	Path     string // path relative from top level directory, untracked directory has trailing '/'
+	OrigPath string // path before the rename, empty unless Staging is Renamed
+	Staging  Code   // status of the index compared with HEAD
+	Worktree Code   // status of the working tree compared with the index
+}
+
+// IsConflicted reports whether the path is unmerged.
+func (f *FileStatus) IsConflicted() bool {
+	return f.Staging == Unmerged || f.Worktree == Unmerged ||
+		(f.Staging == Added && f.Worktree == Added) ||
+		(f.Staging == Deleted && f.Worktree == Deleted)
+}
+
+// String is implementation of fmt.Stringer interface
+// it returns the same format as $ git status --porcelain
+func (f *FileStatus) String() string {
+	if f.OrigPath != "" {
+		return string([]byte{byte(f.Staging), byte(f.Worktree)}) + " " + f.OrigPath + " -> " + f.Path
+	}
+	return string([]byte{byte(f.Staging), byte(f.Worktree)}) + " " + f.Path
+}
+
+// Status is a type representing status of the working tree.
+type Status struct {
+	Files []*FileStatus // changed paths first, then untracked and ignored ones, each sorted by path
+}
+
+// NewStatus computes status of the repository.
+// gitDir of parameters must be path to .git directory, options may be nil.
+func NewStatus(gitDir string, options *Options) (*Status, error) {
+	c, err := newComputer(gitDir, options)
+	if err != nil {
+		return nil, err
+	}
+	return c.compute()
+}
+
+// OpenStatus computes status of current repository.
+func OpenStatus(options *Options) (*Status, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	gitDir, err := util.FindGitDir(currentDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewStatus(gitDir, options)
+}
+
+// File returns status of the path.
+func (s *Status) File(path string) (*FileStatus, bool) {
+	for _, file := range s.Files {
+		if file.Path == path {
+			return file, true
+		}
+	}
+	return nil, false
+}
+
+// IsClean reports whether there are no changes except untracked and ignored files.
+func (s *Status) IsClean() bool {
+	for _, file := range s.Files {
+		if file.Staging != Untracked && file.Staging != Ignored {
+			return false
+		}
+	}
+	return true
+}
+
+// String is implementation of fmt.Stringer interface
+// it returns the same format as $ git status --porcelain
+func (s *Status) String() string {
+	lines := make([]string, len(s.Files))
+	for i, file := range s.Files {
+		lines[i] = file.String() + "\n"
+	}
+	return strings.Join(lines, "")
+}
+
+func sortByPath(files []*FileStatus) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+}
@@ -0,0 +1,9 @@
+package util
+
+import "errors"
+
+var (
+	ErrNotGitRepository = errors.New("not a git repository")
+	ErrLocked           = errors.New("file is locked by another process")
+	ErrLockClosed       = errors.New("lock file is already closed")
+)
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"os"
+)
+
+// LockFile is a file to update another file atomically in the same way as git.
+// new contents are written to "<path>.lock" and it is renamed to path by Commit.
+// while "<path>.lock" exists, other processes can't lock the same path.
+type LockFile struct {
+	path string
+	file *os.File
+}
+
+// NewLockFile creates "<path>.lock" exclusively.
+// if it already exists, NewLockFile returns ErrLocked.
+func NewLockFile(path string) (*LockFile, error) {
+	file, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return &LockFile{
+		path: path,
+		file: file,
+	}, nil
+}
+
+// Path returns path of the file what this lock file protects.
+func (l *LockFile) Path() string {
+	return l.path
+}
+
+// Write is implementation of io.Writer interface
+func (l *LockFile) Write(p []byte) (int, error) {
+	if l.file == nil {
+		return 0, ErrLockClosed
+	}
+	return l.file.Write(p)
+}
+
+// Commit replaces the protected file with contents written to this lock file.
+func (l *LockFile) Commit() error {
+	if l.file == nil {
+		return ErrLockClosed
+	}
+	file := l.file
+	l.file = nil
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), l.path)
+}
+
+// Rollback discards contents written to this lock file and releases the lock.
+// it is safe to call Rollback after Commit.
+func (l *LockFile) Rollback() error {
+	if l.file == nil {
+		return nil
+	}
+	file := l.file
+	l.file = nil
+	file.Close()
+	return os.Remove(file.Name())
+}
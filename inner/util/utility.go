@@ -9,6 +9,10 @@ import (
 
 // FindGitRoot returns path to top level directory of current git repository
 func FindGitRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return "", err
@@ -18,7 +22,20 @@ func FindGitRoot(dir string) (string, error) {
 			return dir, nil
 		}
 	}
-	return FindGitRoot(filepath.Join(dir, ".."))
+	parent := filepath.Join(dir, "..")
+	if parent == dir {
+		return "", ErrNotGitRepository
+	}
+	return FindGitRoot(parent)
+}
+
+// FindGitDir returns path to .git directory of current git repository
+func FindGitDir(dir string) (string, error) {
+	root, err := FindGitRoot(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, ".git"), nil
 }
 
 type ReadSeekCloser interface {
@@ -39,3 +56,16 @@ type ReadWriteSeekCloser interface {
 	io.Seeker
 	io.Closer
 }
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// NopCloser returns a ReadSeekCloser with a no-op Close method wrapping rs.
+func NopCloser(rs io.ReadSeeker) ReadSeekCloser {
+	return nopCloser{rs}
+}
@@ -1,10 +1,36 @@
 package util
 
-import "compress/zlib"
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+)
 
+// ZlibReadSeeker is a ReadSeekCloser of zlib compressed byte stream.
+// it inflates whole the stream when it is created, so it is able to seek inflated data.
 type ZlibReadSeeker struct {
+	*bytes.Reader
+	closer ReadSeekCloser
 }
 
+// NewZlibReadSeeker creates a new ZlibReadSeeker from zlib compressed byte stream.
 func NewZlibReadSeeker(closer ReadSeekCloser) (ZlibReadSeeker, error) {
-	zlib.NewReader()
+	zr, err := zlib.NewReader(closer)
+	if err != nil {
+		return ZlibReadSeeker{}, err
+	}
+	defer zr.Close()
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return ZlibReadSeeker{}, err
+	}
+	return ZlibReadSeeker{
+		Reader: bytes.NewReader(data),
+		closer: closer,
+	}, nil
+}
+
+// Close closes underlying compressed byte stream.
+func (z ZlibReadSeeker) Close() error {
+	return z.closer.Close()
 }
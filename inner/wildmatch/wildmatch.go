@@ -0,0 +1,310 @@
+// wildmatch is a package to match paths with git's wildcard patterns
+//
+// The following special characters are supported.
+//
+//  *        - matches any string, but not '/' if PathName flag is set
+//  **       - matches any string including '/' between slashes, or at the start or end of the pattern
+//  ?        - matches any one character, but not '/' if PathName flag is set
+//  [...]    - matches one character in the set, ranges like a-z and classes like [:alpha:] are allowed
+//  [!...]   - matches one character not in the set, [^...] is the same
+//  \        - escapes the following character
+//
+// This is a port of wildmatch.c of git, so patterns behave exactly the same as in .gitignore.
+// https://github.com/git/git/blob/master/wildmatch.c
+package wildmatch
+
+// Flags is a type representing options of matching.
+type Flags uint
+
+// constants of Flags
+const (
+	PathName Flags = 1 << iota // wildcards don't match '/', and "**" has special meaning
+	CaseFold                   // match case-insensitively
+)
+
+// results of dowild
+const (
+	match = iota
+	noMatch
+	abortAll
+	abortToStarStar
+)
+
+// Match reports whether text matches pattern.
+func Match(pattern, text string, flags Flags) bool {
+	return dowild([]byte(pattern), []byte(text), flags) == match
+}
+
+// at returns s[i], or 0 if i is out of range like a NUL terminated string.
+func at(s []byte, i int) byte {
+	if i < 0 || i >= len(s) {
+		return 0
+	}
+	return s[i]
+}
+
+func isUpper(c byte) bool {
+	return 'A' <= c && c <= 'Z'
+}
+
+func isLower(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+func toLower(c byte) byte {
+	if isUpper(c) {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func toUpper(c byte) byte {
+	if isLower(c) {
+		return c - ('a' - 'A')
+	}
+	return c
+}
+
+func isGlobSpecial(c byte) bool {
+	return c == '*' || c == '?' || c == '[' || c == '\\'
+}
+
+func indexSlash(text []byte, from int) int {
+	for i := from; i < len(text); i++ {
+		if text[i] == '/' {
+			return i
+		}
+	}
+	return -1
+}
+
+func dowild(p, text []byte, flags Flags) int {
+	pi, ti := 0, 0
+	for ; at(p, pi) != 0; ti, pi = ti+1, pi+1 {
+		pCh := at(p, pi)
+		tCh := at(text, ti)
+		if tCh == 0 && pCh != '*' {
+			return abortAll
+		}
+		if flags&CaseFold != 0 {
+			tCh = toLower(tCh)
+			pCh = toLower(pCh)
+		}
+		switch pCh {
+		case '\\':
+			// literal match with the following character
+			pi++
+			pCh = at(p, pi)
+			if tCh != pCh {
+				return noMatch
+			}
+		default:
+			if tCh != pCh {
+				return noMatch
+			}
+		case '?':
+			if flags&PathName != 0 && tCh == '/' {
+				return noMatch
+			}
+		case '*':
+			var matchSlash bool
+			pi++
+			if at(p, pi) == '*' {
+				prev := pi - 2
+				for pi++; at(p, pi) == '*'; pi++ {
+				}
+				if (prev < 0 || p[prev] == '/') &&
+					(at(p, pi) == 0 || at(p, pi) == '/' || (at(p, pi) == '\\' && at(p, pi+1) == '/')) {
+					// assume "**/" matches nothing, and try to match the rest of the pattern,
+					// so that "foo/**/bar" matches both "foo/bar" and "foo/a/bar".
+					if at(p, pi) == '/' && dowild(p[pi+1:], text[ti:], flags) == match {
+						return match
+					}
+					matchSlash = true
+				} else {
+					matchSlash = false
+				}
+			} else {
+				// without PathName, '*' is the same as '**'
+				matchSlash = flags&PathName == 0
+			}
+			if at(p, pi) == 0 {
+				// trailing "**" matches everything, trailing "*" matches only if there are no more slashes
+				if !matchSlash && indexSlash(text, ti) >= 0 {
+					return abortToStarStar
+				}
+				return match
+			} else if !matchSlash && at(p, pi) == '/' {
+				// one asterisk followed by a slash matches the next directory
+				slash := indexSlash(text, ti)
+				if slash < 0 {
+					return abortAll
+				}
+				// the slash is consumed by the loop
+				ti = slash
+				continue
+			}
+			for {
+				if tCh == 0 {
+					break
+				}
+				// advance faster when the asterisk is followed by a literal
+				if !isGlobSpecial(at(p, pi)) {
+					pCh = at(p, pi)
+					if flags&CaseFold != 0 {
+						pCh = toLower(pCh)
+					}
+					for {
+						tCh = at(text, ti)
+						if tCh == 0 || (!matchSlash && tCh == '/') {
+							break
+						}
+						if flags&CaseFold != 0 {
+							tCh = toLower(tCh)
+						}
+						if tCh == pCh {
+							break
+						}
+						ti++
+					}
+					if tCh != pCh {
+						return noMatch
+					}
+				}
+				if matched := dowild(p[pi:], text[ti:], flags); matched != noMatch {
+					if !matchSlash || matched != abortToStarStar {
+						return matched
+					}
+				} else if !matchSlash && tCh == '/' {
+					return abortToStarStar
+				}
+				ti++
+				tCh = at(text, ti)
+			}
+			return abortAll
+		case '[':
+			pi++
+			pCh = at(p, pi)
+			if pCh == '^' {
+				pCh = '!'
+			}
+			negated := pCh == '!'
+			if negated {
+				pi++
+				pCh = at(p, pi)
+			}
+			var prevCh byte
+			matched := false
+			for {
+				if pCh == 0 {
+					return abortAll
+				}
+				if pCh == '\\' {
+					pi++
+					pCh = at(p, pi)
+					if pCh == 0 {
+						return abortAll
+					}
+					if tCh == pCh {
+						matched = true
+					}
+				} else if pCh == '-' && prevCh != 0 && at(p, pi+1) != 0 && at(p, pi+1) != ']' {
+					pi++
+					pCh = at(p, pi)
+					if pCh == '\\' {
+						pi++
+						pCh = at(p, pi)
+						if pCh == 0 {
+							return abortAll
+						}
+					}
+					if tCh <= pCh && tCh >= prevCh {
+						matched = true
+					} else if flags&CaseFold != 0 && isLower(tCh) {
+						upper := toUpper(tCh)
+						if upper <= pCh && upper >= prevCh {
+							matched = true
+						}
+					}
+					pCh = 0 // this makes prevCh 0
+				} else if pCh == '[' && at(p, pi+1) == ':' {
+					pi += 2
+					start := pi
+					for pCh = at(p, pi); pCh != 0 && pCh != ']'; pCh = at(p, pi) {
+						pi++
+					}
+					if pCh == 0 {
+						return abortAll
+					}
+					length := pi - start - 1
+					if length < 0 || p[pi-1] != ':' {
+						// didn't find ":]", so treat it like a normal set
+						pi = start - 2
+						pCh = '['
+						if tCh == pCh {
+							matched = true
+						}
+					} else {
+						ok, valid := matchClass(string(p[start:start+length]), tCh, flags)
+						if !valid {
+							// malformed [:class:] string
+							return abortAll
+						}
+						if ok {
+							matched = true
+						}
+						pCh = 0 // this makes prevCh 0
+					}
+				} else if tCh == pCh {
+					matched = true
+				}
+				prevCh = pCh
+				pi++
+				pCh = at(p, pi)
+				if pCh == ']' {
+					break
+				}
+			}
+			if matched == negated || (flags&PathName != 0 && tCh == '/') {
+				return noMatch
+			}
+		}
+	}
+	if ti < len(text) {
+		return noMatch
+	}
+	return match
+}
+
+// matchClass reports whether c is in the character class, and whether the class name is valid.
+func matchClass(class string, c byte, flags Flags) (bool, bool) {
+	isAlpha := isUpper(c) || isLower(c)
+	isDigit := '0' <= c && c <= '9'
+	switch class {
+	case "alnum":
+		return isAlpha || isDigit, true
+	case "alpha":
+		return isAlpha, true
+	case "blank":
+		return c == ' ' || c == '\t', true
+	case "cntrl":
+		return c < 0x20 || c == 0x7f, true
+	case "digit":
+		return isDigit, true
+	case "graph":
+		return 0x21 <= c && c <= 0x7e, true
+	case "lower":
+		return isLower(c) || (flags&CaseFold != 0 && isUpper(c)), true
+	case "print":
+		return 0x20 <= c && c <= 0x7e, true
+	case "punct":
+		return 0x21 <= c && c <= 0x7e && !isAlpha && !isDigit, true
+	case "space":
+		return c == ' ' || ('\t' <= c && c <= '\r'), true
+	case "upper":
+		return isUpper(c) || (flags&CaseFold != 0 && isLower(c)), true
+	case "xdigit":
+		return isDigit || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F'), true
+	}
+	return false, false
+}
@@ -0,0 +1,110 @@
+// worktree is a package to check and write files in the working tree for commands moving the index and the
+// working tree from a tree to another tree, like $ git checkout and $ git cherry-pick.
+//
+// Files are clean if they match their index entries. entries having assume-valid or skip-worktree flag are always
+// clean, a file whose type or executable bit differs from its entry is changed, and the other files are hashed
+// unless their stat information matches their entries like git. core.fileMode, core.trustCTime and core.checkStat
+// config decide how stat information is compared.
+package worktree
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// ReadIndex reads the index of the repository, it is empty if there is no index file.
+// the modification time of the index file is also returned to detect racily clean entries.
+// gitDir of parameters must be path to .git directory.
+func ReadIndex(gitDir string) (index.Index, time.Time, error) {
+	info, err := os.Stat(filepath.Join(gitDir, "index"))
+	if os.IsNotExist(err) {
+		return index.NewIndex(nil), time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	idx, err := index.ReadIndexFrom(gitDir)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return idx, info.ModTime(), nil
+}
+
+// ReadEntries returns all entries of the index.
+func ReadEntries(idx index.Index) ([]*index.Entry, error) {
+	entries := make([]*index.Entry, 0, idx.Header().NumOfEntries)
+	for i := uint32(0); i < idx.Header().NumOfEntries; i++ {
+		entry, err := idx.Entries(i)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// IndexMode returns mode of the index entry as tree entry mode.
+func IndexMode(entry *index.Entry) object.FileMode {
+	return object.FileMode(uint32(entry.ObjectType)<<12 | uint32(entry.Permission))
+}
+
+// SetMode sets the object type and the permission of the entry from the tree entry mode.
+func SetMode(entry *index.Entry, mode object.FileMode) {
+	entry.ObjectType = index.ObjectType(mode >> 12)
+	entry.Permission = uint16(mode & 0777)
+}
+
+// TreeFiles returns entries of files in the tree by their paths, nil means the empty tree.
+// names of the returned entries are paths from the root.
+func TreeFiles(gitDir string, tree []byte) (map[string]*object.TreeEntry, error) {
+	files := map[string]*object.TreeEntry{}
+	var walk func(prefix string, digest []byte) error
+	walk = func(prefix string, digest []byte) error {
+		obj, err := object.ReadObjectFrom(gitDir, digest)
+		if err != nil {
+			return err
+		}
+		t, ok := obj.(*object.Tree)
+		if !ok {
+			return object.ErrUnexpectedType
+		}
+		for _, entry := range t.Entries {
+			name := path.Join(prefix, entry.Name)
+			if entry.Mode.IsTree() {
+				if err := walk(name, entry.Digest); err != nil {
+					return err
+				}
+				continue
+			}
+			files[name] = &object.TreeEntry{Mode: entry.Mode, Name: name, Digest: entry.Digest}
+		}
+		return nil
+	}
+	if tree == nil {
+		return files, nil
+	}
+	if err := walk("", tree); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// NewEntry returns the index entry of the written file, its stat information is taken from the file.
+// stat information of a git link is empty like git.
+func NewEntry(file *object.TreeEntry, info os.FileInfo) (*index.Entry, error) {
+	entry := &index.Entry{Digest: file.Digest}
+	if file.Mode != object.ModeGitLink {
+		var err error
+		if entry, err = index.NewEntry(info, file.Digest); err != nil {
+			return nil, err
+		}
+	}
+	entry.Name = file.Name
+	SetMode(entry, file.Mode)
+	return entry, nil
+}
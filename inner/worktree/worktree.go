@@ -0,0 +1,296 @@
+package worktree
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shumon84/mogit/inner/attributes"
+	"github.com/shumon84/mogit/inner/config"
+	"github.com/shumon84/mogit/inner/index"
+	"github.com/shumon84/mogit/inner/object"
+)
+
+// Worktree is a type to check and write files in the working tree.
+// it isn't safe for concurrent use, Clone returns another Worktree for another goroutine.
+type Worktree struct {
+	workDir   string
+	gitDir    string
+	stat      *index.StatConfig            // how stat information of files is compared with entries
+	converter *attributes.Converter        // converter between blobs and files
+	indexBlob func(string) ([]byte, error) // reader of blobs in the index for the converter
+	indexTime time.Time                    // modification time of the index file
+}
+
+// NewWorktree creates a Worktree of the repository, cfg is the config of the repository.
+// entries are all entries of the index, and indexTime is the modification time of the index file.
+// gitDir of parameters must be path to .git directory.
+func NewWorktree(gitDir, workDir string, cfg *config.Config, entries []*index.Entry, indexTime time.Time) (*Worktree, error) {
+	w := &Worktree{workDir: workDir, gitDir: gitDir, indexBlob: index.BlobReader(gitDir, entries), indexTime: indexTime}
+	w.stat = &index.StatConfig{Minimal: cfg.GetString("core.checkstat", "default") == "minimal"}
+	var err error
+	if w.stat.FileMode, err = cfg.Bool("core.filemode", true); err != nil {
+		return nil, err
+	}
+	if w.stat.TrustCTime, err = cfg.Bool("core.trustctime", true); err != nil {
+		return nil, err
+	}
+	if w.converter, err = attributes.NewConverter(gitDir); err != nil {
+		return nil, err
+	}
+	w.converter.IndexBlob = w.indexBlob
+	return w, nil
+}
+
+// Clone returns a Worktree having its own converter.
+func (w *Worktree) Clone() (*Worktree, error) {
+	converter, err := attributes.NewConverter(w.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	converter.IndexBlob = w.indexBlob
+	clone := *w
+	clone.converter = converter
+	return &clone, nil
+}
+
+// Close stops filter processes of the converter.
+func (w *Worktree) Close() error {
+	return w.converter.Close()
+}
+
+// Path returns path of the file in the working tree.
+func (w *Worktree) Path(name string) string {
+	return filepath.Join(w.workDir, filepath.FromSlash(name))
+}
+
+// IsClean reports whether the file of the index entry has no local changes.
+// a file which doesn't exist is regarded as clean, it has nothing to lose.
+func (w *Worktree) IsClean(entry *index.Entry) (bool, error) {
+	return w.isClean(entry, true)
+}
+
+// IsUpToDate reports whether the file of the index entry exists and has no local changes like $ git diff-files.
+func (w *Worktree) IsUpToDate(entry *index.Entry) (bool, error) {
+	return w.isClean(entry, false)
+}
+
+// isClean reports whether the file of the index entry has no local changes, missing is the result if the file
+// doesn't exist. entries having assume-valid or skip-worktree flag are clean, and a git link is clean if it exists.
+// the file isn't hashed if its stat information matches the entry and the entry isn't racily clean.
+func (w *Worktree) isClean(entry *index.Entry, missing bool) (bool, error) {
+	if entry.IsAssumeValid || entry.SkipWorktree {
+		return true, nil
+	}
+	info, err := os.Lstat(w.Path(entry.Name))
+	if os.IsNotExist(err) || IsNotDir(err) {
+		return missing, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if entry.ObjectType == index.GitLink {
+		return true, nil
+	}
+	isLink := info.Mode()&os.ModeSymlink != 0
+	if !isLink && !info.Mode().IsRegular() || isLink != (entry.ObjectType == index.SymbolicLink) {
+		return false, nil
+	}
+	if !isLink && w.stat.FileMode && (info.Mode()&0100 != 0) != (entry.Permission&0100 != 0) {
+		return false, nil
+	}
+	if entry.MTime.Before(w.indexTime) && entry.MatchStat(info, w.stat) {
+		return true, nil
+	}
+	digest, err := w.HashFile(entry.Name, info)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(digest, entry.Digest), nil
+}
+
+// HashFile returns SHA1 digest of the file as a blob object, info is stat information of the file by os.Lstat.
+func (w *Worktree) HashFile(name string, info os.FileInfo) ([]byte, error) {
+	filePath := w.Path(name)
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return object.NewBlobFromBytes([]byte(filepath.ToSlash(target))).SHA1()
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = w.converter.ToGit(name, data); err != nil {
+		return nil, err
+	}
+	return object.NewBlobFromBytes(data).SHA1()
+}
+
+// CanCreate reports whether the file can be created without losing untracked files.
+// files which are tracked or ignored, and a file having the same contents are not lost.
+// ignored reports whether the path is ignored, the second parameter is whether the path is a directory.
+func (w *Worktree) CanCreate(file *object.TreeEntry, tracked map[string]*index.Entry, ignored func(string, bool) bool) (bool, error) {
+	components := strings.Split(file.Name, "/")
+	for i := 1; i < len(components); i++ {
+		dir := strings.Join(components[:i], "/")
+		info, err := os.Lstat(w.Path(dir))
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !info.IsDir() {
+			return tracked[dir] != nil || ignored(dir, false), nil
+		}
+	}
+	info, err := os.Lstat(w.Path(file.Name))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.IsDir() {
+		return w.canRemoveDir(file.Name, tracked, ignored)
+	}
+	if ignored(file.Name, false) {
+		return true, nil
+	}
+	if file.Mode == object.ModeGitLink {
+		return false, nil
+	}
+	digest, err := w.HashFile(file.Name, info)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(digest, file.Digest), nil
+}
+
+// canRemoveDir reports whether all files in the directory are tracked or ignored.
+func (w *Worktree) canRemoveDir(dir string, tracked map[string]*index.Entry, ignored func(string, bool) bool) (bool, error) {
+	if ignored(dir, true) {
+		return true, nil
+	}
+	infos, err := ioutil.ReadDir(w.Path(dir))
+	if err != nil {
+		return false, err
+	}
+	for _, info := range infos {
+		name := dir + "/" + info.Name()
+		if info.IsDir() {
+			if tracked[name] != nil {
+				continue
+			}
+			ok, err := w.canRemoveDir(name, tracked, ignored)
+			if err != nil || !ok {
+				return false, err
+			}
+			continue
+		}
+		if tracked[name] == nil && !ignored(name, false) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Remove removes the file, and directories which become empty.
+func (w *Worktree) Remove(name string) error {
+	// a directory of a git link is left if it isn't empty
+	if err := os.Remove(w.Path(name)); err != nil && !os.IsNotExist(err) && !IsNotDir(err) && !isNotEmpty(err) {
+		return err
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if os.Remove(w.Path(dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Write writes the contents of the tree entry to the file, and returns its stat information.
+// files or directories in the way are removed.
+func (w *Worktree) Write(file *object.TreeEntry) (os.FileInfo, error) {
+	if err := w.MakeDirs(file.Name); err != nil {
+		return nil, err
+	}
+	filePath := w.Path(file.Name)
+	if info, err := os.Lstat(filePath); err == nil {
+		if info.IsDir() && file.Mode == object.ModeGitLink {
+			return info, nil
+		}
+		if err := os.RemoveAll(filePath); err != nil {
+			return nil, err
+		}
+	}
+	if file.Mode == object.ModeGitLink {
+		if err := os.Mkdir(filePath, 0777); err != nil {
+			return nil, err
+		}
+		return os.Lstat(filePath)
+	}
+	data, err := object.ReadBlobContent(w.gitDir, file.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if file.Mode == object.ModeSymlink {
+		if err := os.Symlink(filepath.FromSlash(string(data)), filePath); err != nil {
+			return nil, err
+		}
+		return os.Lstat(filePath)
+	}
+	if data, err = w.converter.ToWorktree(file.Name, data); err != nil {
+		return nil, err
+	}
+	perm := os.FileMode(0666)
+	if file.Mode == object.ModeExecutable {
+		perm = 0777
+	}
+	if err := ioutil.WriteFile(filePath, data, perm); err != nil {
+		return nil, err
+	}
+	return os.Lstat(filePath)
+}
+
+// MakeDirs creates leading directories of the path, files in the way are removed.
+func (w *Worktree) MakeDirs(name string) error {
+	components := strings.Split(name, "/")
+	for i := 1; i < len(components); i++ {
+		dir := w.Path(strings.Join(components[:i], "/"))
+		info, err := os.Lstat(dir)
+		if err == nil && info.IsDir() {
+			continue
+		}
+		if err == nil {
+			if err := os.Remove(dir); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Mkdir(dir, 0777); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsNotDir reports whether the error is caused by a file in the way of a path like os.IsNotExist.
+func IsNotDir(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	return ok && pathErr.Err == syscall.ENOTDIR
+}
+
+func isNotEmpty(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	return ok && (pathErr.Err == syscall.ENOTEMPTY || pathErr.Err == syscall.EEXIST)
+}
@@ -0,0 +1,295 @@
+package xdiff
+
+// constants of the indent heuristic, they are the same as xdiffi.c of git
+const (
+	maxIndent = 200 // indentation wider than this is regarded as this
+	maxBlanks = 20  // blank lines more than this are not counted
+
+	startOfFilePenalty              = 1
+	endOfFilePenalty                = 21
+	totalBlankWeight                = -30
+	postBlankWeight                 = 6
+	relativeIndentPenalty           = -4
+	relativeIndentWithBlankPenalty  = 10
+	relativeOutdentPenalty          = 24
+	relativeOutdentWithBlankPenalty = 17
+	relativeDedentPenalty           = 23
+	relativeDedentWithBlankPenalty  = 17
+
+	indentWeight     = 60
+	indentMaxSliding = 100 // groups are not slid more than this by the indent heuristic
+)
+
+// compact slides groups of changed lines in both sides.
+func (e *env) compact(indentHeuristic bool) {
+	compactFile(e.f1, e.f2, indentHeuristic)
+	compactFile(e.f2, e.f1, indentHeuristic)
+}
+
+// group is a type representing a group of changed lines, it may be empty.
+type group struct {
+	start, end int
+}
+
+func newGroup(f *file) *group {
+	g := &group{}
+	for f.changed(g.end) {
+		g.end++
+	}
+	return g
+}
+
+// next moves to the next group, it returns false at end of file.
+func (g *group) next(f *file) bool {
+	if g.end == len(f.recs) {
+		return false
+	}
+	g.start = g.end + 1
+	for g.end = g.start; f.changed(g.end); g.end++ {
+	}
+	return true
+}
+
+// previous moves to the previous group, it returns false at start of file.
+func (g *group) previous(f *file) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	for g.start = g.end; f.changed(g.start - 1); g.start-- {
+	}
+	return true
+}
+
+// slideDown moves the group down by one line if the first line equals the line after the group.
+func (g *group) slideDown(f *file) bool {
+	if g.end < len(f.recs) && f.recs[g.start].class == f.recs[g.end].class {
+		f.setChanged(g.start, false)
+		f.setChanged(g.end, true)
+		g.start++
+		g.end++
+		for f.changed(g.end) {
+			g.end++
+		}
+		return true
+	}
+	return false
+}
+
+// slideUp moves the group up by one line if the last line equals the line before the group.
+func (g *group) slideUp(f *file) bool {
+	if g.start > 0 && f.recs[g.start-1].class == f.recs[g.end-1].class {
+		g.start--
+		g.end--
+		f.setChanged(g.start, true)
+		f.setChanged(g.end, false)
+		for f.changed(g.start - 1) {
+			g.start--
+		}
+		return true
+	}
+	return false
+}
+
+// compactFile slides groups of f as far as possible, merging adjacent groups,
+// and then places them aligned to groups of the other side or by the indent heuristic.
+func compactFile(f, other *file, indentHeuristic bool) {
+	g := newGroup(f)
+	otherGroup := newGroup(other)
+
+	for {
+		if g.end != g.start {
+			var groupSize, earliestEnd int
+			endMatchingOther := -1
+			for {
+				groupSize = g.end - g.start
+				endMatchingOther = -1
+
+				for g.slideUp(f) {
+					otherGroup.previous(other)
+				}
+				earliestEnd = g.end
+				if otherGroup.end > otherGroup.start {
+					endMatchingOther = g.end
+				}
+
+				for g.slideDown(f) {
+					otherGroup.next(other)
+					if otherGroup.end > otherGroup.start {
+						endMatchingOther = g.end
+					}
+				}
+				if groupSize == g.end-g.start {
+					break
+				}
+			}
+
+			switch {
+			case g.end == earliestEnd:
+				// no shifting is possible
+			case endMatchingOther != -1:
+				for otherGroup.end == otherGroup.start {
+					g.slideUp(f)
+					otherGroup.previous(other)
+				}
+			case indentHeuristic:
+				bestShift := -1
+				var bestScore splitScore
+				shift := earliestEnd
+				if g.end-groupSize-1 > shift {
+					shift = g.end - groupSize - 1
+				}
+				if g.end-indentMaxSliding > shift {
+					shift = g.end - indentMaxSliding
+				}
+				for ; shift <= g.end; shift++ {
+					score := splitScore{}
+					score.add(measureSplit(f, shift))
+					score.add(measureSplit(f, shift-groupSize))
+					if bestShift == -1 || score.compare(&bestScore) <= 0 {
+						bestScore = score
+						bestShift = shift
+					}
+				}
+				for g.end > bestShift {
+					g.slideUp(f)
+					otherGroup.previous(other)
+				}
+			}
+		}
+
+		if !g.next(f) {
+			break
+		}
+		otherGroup.next(other)
+	}
+}
+
+// splitMeasurement is a type representing characteristics of lines around a split point.
+type splitMeasurement struct {
+	endOfFile  bool
+	indent     int // indentation of the line after the split, -1 if it's blank
+	preBlank   int // number of blank lines before the split
+	preIndent  int // indentation of the first non-blank line before the split
+	postBlank  int // number of blank lines after the line after the split
+	postIndent int // indentation of the first non-blank line after that
+}
+
+// indentOf returns width of indentation of the line, or -1 if the line is blank.
+func indentOf(rec *record) int {
+	indent := 0
+	for _, c := range rec.data {
+		if !isSpace(c) {
+			return indent
+		}
+		if c == ' ' {
+			indent++
+		} else if c == '\t' {
+			indent += 8 - indent%8
+		}
+		if indent >= maxIndent {
+			return maxIndent
+		}
+	}
+	return -1
+}
+
+func measureSplit(f *file, split int) *splitMeasurement {
+	m := &splitMeasurement{}
+	if split >= len(f.recs) {
+		m.endOfFile = true
+		m.indent = -1
+	} else {
+		m.indent = indentOf(f.recs[split])
+	}
+
+	m.preIndent = -1
+	for i := split - 1; i >= 0; i-- {
+		m.preIndent = indentOf(f.recs[i])
+		if m.preIndent != -1 {
+			break
+		}
+		m.preBlank++
+		if m.preBlank == maxBlanks {
+			m.preIndent = 0
+			break
+		}
+	}
+
+	m.postIndent = -1
+	for i := split + 1; i < len(f.recs); i++ {
+		m.postIndent = indentOf(f.recs[i])
+		if m.postIndent != -1 {
+			break
+		}
+		m.postBlank++
+		if m.postBlank == maxBlanks {
+			m.postIndent = 0
+			break
+		}
+	}
+	return m
+}
+
+// splitScore is a type representing badness of split points, the lower is the better.
+type splitScore struct {
+	effectiveIndent int
+	penalty         int
+}
+
+func (s *splitScore) add(m *splitMeasurement) {
+	if m.preIndent == -1 && m.preBlank == 0 {
+		s.penalty += startOfFilePenalty
+	}
+	if m.endOfFile {
+		s.penalty += endOfFilePenalty
+	}
+
+	postBlank := 0
+	if m.indent == -1 {
+		postBlank = 1 + m.postBlank
+	}
+	totalBlank := m.preBlank + postBlank
+	s.penalty += totalBlankWeight * totalBlank
+	s.penalty += postBlankWeight * postBlank
+
+	indent := m.indent
+	if indent == -1 {
+		indent = m.postIndent
+	}
+	anyBlanks := totalBlank != 0
+	s.effectiveIndent += indent
+
+	switch {
+	case indent == -1, m.preIndent == -1:
+	case indent > m.preIndent:
+		if anyBlanks {
+			s.penalty += relativeIndentWithBlankPenalty
+		} else {
+			s.penalty += relativeIndentPenalty
+		}
+	case indent == m.preIndent:
+	case m.postIndent != -1 && m.postIndent > indent:
+		if anyBlanks {
+			s.penalty += relativeOutdentWithBlankPenalty
+		} else {
+			s.penalty += relativeOutdentPenalty
+		}
+	default:
+		if anyBlanks {
+			s.penalty += relativeDedentWithBlankPenalty
+		} else {
+			s.penalty += relativeDedentPenalty
+		}
+	}
+}
+
+func (s *splitScore) compare(other *splitScore) int {
+	cmpIndents := 0
+	if s.effectiveIndent > other.effectiveIndent {
+		cmpIndents = 1
+	} else if s.effectiveIndent < other.effectiveIndent {
+		cmpIndents = -1
+	}
+	return indentWeight*cmpIndents + (s.penalty - other.penalty)
+}
@@ -0,0 +1,267 @@
+package xdiff
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// funcLineSize is the maximum length of the function line in hunk header.
+const funcLineSize = 80
+
+// Line is a type representing a line of hunk.
+type Line struct {
+	Op   byte   // ' ' for context, '-' for deleted line and '+' for added line
+	Text []byte // content of the line including trailing '\n', the last line may not have it
+}
+
+// Hunk is a type representing a hunk of unified diff.
+// line numbers are 1-based like hunk header.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	FuncName []byte // function line shown after the header, it may be empty
+	Lines    []*Line
+}
+
+// Header returns the hunk header.
+//
+//  @@ -<old start>,<old lines> +<new start>,<new lines> @@ <function line>
+func (h *Hunk) Header() string {
+	header := "@@ -" + hunkRange(h.OldStart, h.OldLines) + " +" + hunkRange(h.NewStart, h.NewLines) + " @@"
+	if len(h.FuncName) > 0 {
+		header += " " + string(h.FuncName)
+	}
+	return header
+}
+
+// hunkRange formats start and count of hunk header, count is omitted if it's 1.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return strconv.Itoa(start)
+	}
+	return strconv.Itoa(start) + "," + strconv.Itoa(count)
+}
+
+// WriteTo writes the hunk in unified format.
+// a line without trailing '\n' is followed by "\ No newline at end of file".
+func (h *Hunk) WriteTo(w io.Writer) (int64, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(h.Header())
+	buf.WriteByte('\n')
+	for _, line := range h.Lines {
+		buf.WriteByte(line.Op)
+		buf.Write(line.Text)
+		if !bytes.HasSuffix(line.Text, []byte("\n")) {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	return buf.WriteTo(w)
+}
+
+// change is a type representing a group of changed lines while making hunks.
+type change struct {
+	*Edit
+	ignore bool // all lines are blank and --ignore-blank-lines is given
+}
+
+// Hunks computes hunks of unified diff from old content to new content.
+// options may be nil.
+func Hunks(old, new []byte, options *Options) []*Hunk {
+	if options == nil {
+		options = defaultOptions()
+	}
+	e := newEnv(splitLines(old), splitLines(new), options.Flags)
+	e.diff(options.Algorithm)
+	e.compact(!options.NoIndentHeuristic)
+	edits := e.script()
+	changes := make([]*change, len(edits))
+	for i, edit := range edits {
+		changes[i] = &change{Edit: edit}
+		if options.Flags&IgnoreBlankLines != 0 {
+			changes[i].ignore = e.isIgnorable(edit)
+		}
+	}
+	return e.hunks(changes, options)
+}
+
+// WriteUnified writes hunks of unified diff from old content to new content.
+// options may be nil.
+func WriteUnified(w io.Writer, old, new []byte, options *Options) error {
+	for _, hunk := range Hunks(old, new, options) {
+		if _, err := hunk.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isIgnorable reports whether all lines of the edit are blank.
+func (e *env) isIgnorable(edit *Edit) bool {
+	for i := 0; i < edit.OldLines; i++ {
+		if !isBlankLine(e.f1.recs[edit.OldStart+i].data, e.flags) {
+			return false
+		}
+	}
+	for i := 0; i < edit.NewLines; i++ {
+		if !isBlankLine(e.f2.recs[edit.NewStart+i].data, e.flags) {
+			return false
+		}
+	}
+	return true
+}
+
+// nextHunk returns the range of changes of the next hunk starting from changes[0].
+// leading ignorable changes are skipped, it returns -1 if there is no more hunk.
+func nextHunk(changes []*change, options *Options) (int, int) {
+	maxCommon := 2*options.Context + options.InterHunkContext
+	maxIgnorable := options.Context
+
+	first := 0
+	for i := 0; i < len(changes) && changes[i].ignore; i++ {
+		if i+1 == len(changes) || changes[i+1].OldStart-(changes[i].OldStart+changes[i].OldLines) >= maxIgnorable {
+			first = i + 1
+		}
+	}
+	if first == len(changes) {
+		return -1, -1
+	}
+
+	last := first
+	ignored := 0
+	for i := first + 1; i < len(changes); i++ {
+		prev, ch := changes[i-1], changes[i]
+		distance := ch.OldStart - (prev.OldStart + prev.OldLines)
+		if distance > maxCommon {
+			break
+		}
+		lastChange := changes[last]
+		switch {
+		case distance < maxIgnorable && (!ch.ignore || last == i-1):
+			last = i
+			ignored = 0
+		case distance < maxIgnorable && ch.ignore:
+			ignored += ch.NewLines
+		case last != i-1 && ch.OldStart+ignored-(lastChange.OldStart+lastChange.OldLines) > maxCommon:
+			return first, last
+		case !ch.ignore:
+			last = i
+			ignored = 0
+		default:
+			ignored += ch.NewLines
+		}
+	}
+	return first, last
+}
+
+// hunks groups changes into hunks with context lines.
+func (e *env) hunks(changes []*change, options *Options) []*Hunk {
+	hunks := []*Hunk{}
+	funcName := []byte{}
+	funcLinePrev := -1
+	for len(changes) > 0 {
+		first, last := nextHunk(changes, options)
+		if first < 0 {
+			break
+		}
+		xch, xche := changes[first], changes[last]
+
+		s1 := maxInt(xch.OldStart-options.Context, 0)
+		s2 := maxInt(xch.NewStart-options.Context, 0)
+		lctx := options.Context
+		lctx = minInt(lctx, len(e.f1.recs)-(xche.OldStart+xche.OldLines))
+		lctx = minInt(lctx, len(e.f2.recs)-(xche.NewStart+xche.NewLines))
+		e1 := xche.OldStart + xche.OldLines + lctx
+		e2 := xche.NewStart + xche.NewLines + lctx
+
+		if name, ok := e.funcLine(s1-1, funcLinePrev, options); ok {
+			funcName = name
+		}
+		funcLinePrev = s1 - 1
+
+		hunk := &Hunk{
+			OldStart: s1 + 1,
+			OldLines: e1 - s1,
+			NewStart: s2 + 1,
+			NewLines: e2 - s2,
+			FuncName: funcName,
+			Lines:    []*Line{},
+		}
+		emit := func(f *file, i int, op byte) {
+			hunk.Lines = append(hunk.Lines, &Line{Op: op, Text: f.recs[i].data})
+		}
+		for ; s2 < xch.NewStart; s2++ {
+			emit(e.f2, s2, ' ')
+		}
+		s1, s2 = xch.OldStart, xch.NewStart
+		for i := first; ; i++ {
+			ch := changes[i]
+			for ; s1 < ch.OldStart && s2 < ch.NewStart; s1, s2 = s1+1, s2+1 {
+				emit(e.f2, s2, ' ')
+			}
+			for s1 = ch.OldStart; s1 < ch.OldStart+ch.OldLines; s1++ {
+				emit(e.f1, s1, '-')
+			}
+			for s2 = ch.NewStart; s2 < ch.NewStart+ch.NewLines; s2++ {
+				emit(e.f2, s2, '+')
+			}
+			if i == last {
+				break
+			}
+			s1 = ch.OldStart + ch.OldLines
+			s2 = ch.NewStart + ch.NewLines
+		}
+		for s2 = xche.NewStart + xche.NewLines; s2 < e2; s2++ {
+			emit(e.f2, s2, ' ')
+		}
+		hunks = append(hunks, hunk)
+		changes = changes[last+1:]
+	}
+	return hunks
+}
+
+// funcLine searches the function line of the old side from start back to limit exclusive.
+func (e *env) funcLine(start, limit int, options *Options) ([]byte, bool) {
+	funcName := options.FuncName
+	if funcName == nil {
+		funcName = defaultFuncName
+	}
+	for l := start; l != limit && 0 <= l && l < len(e.f1.recs); l-- {
+		name, ok := funcName(e.f1.recs[l].data)
+		if !ok {
+			continue
+		}
+		if len(name) > funcLineSize {
+			name = name[:funcLineSize]
+		}
+		for len(name) > 0 && isSpace(name[len(name)-1]) {
+			name = name[:len(name)-1]
+		}
+		return name, true
+	}
+	return nil, false
+}
+
+// defaultFuncName matches lines beginning with an alphabet, '_' or '$'.
+func defaultFuncName(line []byte) ([]byte, bool) {
+	if len(line) == 0 {
+		return nil, false
+	}
+	c := line[0]
+	if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '_' || c == '$' {
+		return line, true
+	}
+	return nil, false
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,8 @@
+package xdiff
+
+import "errors"
+
+var (
+	ErrUnknownAlgorithm     = errors.New("unknown diff algorithm")
+	ErrUnknownConflictStyle = errors.New("unknown conflict style")
+)
@@ -0,0 +1,153 @@
+package xdiff
+
+// maxChainLength is the limit of occurrences of a line used as the longest common subsequence.
+const maxChainLength = 64
+
+// histogramRecord is a type representing occurrences of a line in the old side.
+type histogramRecord struct {
+	ptr int // the first line of the occurrences
+	cnt int // number of occurrences
+}
+
+// region is a type representing a common region of both sides, line numbers are 1-based.
+type region struct {
+	begin1, end1 int
+	begin2, end2 int
+}
+
+// histogramIndex is a type holding state while finding the longest common subsequence.
+type histogramIndex struct {
+	e         *env
+	records   map[int]*histogramRecord // records by class
+	lineMap   []*histogramRecord       // records by line, the index is shifted by ptrShift
+	nextPtrs  []int                    // the next occurrence of each line, 0 means none
+	ptrShift  int
+	cnt       int  // the lowest count of occurrences found
+	hasCommon bool // whether any common line is found
+}
+
+// histogram computes changed lines between line1..line1+count1-1 and line2..line2+count2-1 by histogram algorithm.
+// line numbers are 1-based.
+func (e *env) histogram(line1, count1, line2, count2 int) {
+	for {
+		if count1 <= 0 && count2 <= 0 {
+			return
+		}
+		if count1 == 0 || count2 == 0 {
+			e.markChanged(line1, count1, line2, count2)
+			return
+		}
+
+		lcs, fallBack := e.findLCS(line1, count1, line2, count2)
+		if fallBack {
+			e.fallBack(line1, count1, line2, count2)
+			return
+		}
+		if lcs.begin1 == 0 && lcs.begin2 == 0 {
+			e.markChanged(line1, count1, line2, count2)
+			return
+		}
+		e.histogram(line1, lcs.begin1-line1, line2, lcs.begin2-line2)
+		end1, end2 := line1+count1-1, line2+count2-1
+		line1, count1 = lcs.end1+1, end1-lcs.end1
+		line2, count2 = lcs.end2+1, end2-lcs.end2
+	}
+}
+
+// findLCS finds the longest common subsequence preferring lines with fewer occurrences.
+// it reports fallBack if all common lines appear too many times.
+func (e *env) findLCS(line1, count1, line2, count2 int) (*region, bool) {
+	index := &histogramIndex{
+		e:        e,
+		records:  map[int]*histogramRecord{},
+		lineMap:  make([]*histogramRecord, count1),
+		nextPtrs: make([]int, count1),
+		ptrShift: line1,
+	}
+	for ptr := line1 + count1 - 1; line1 <= ptr; ptr-- {
+		class := e.f1.recs[ptr-1].class
+		rec, ok := index.records[class]
+		if ok {
+			index.nextPtrs[ptr-index.ptrShift] = rec.ptr
+			rec.ptr = ptr
+			rec.cnt++
+		} else {
+			rec = &histogramRecord{ptr: ptr, cnt: 1}
+			index.records[class] = rec
+		}
+		index.lineMap[ptr-index.ptrShift] = rec
+	}
+
+	index.cnt = maxChainLength + 1
+	lcs := &region{}
+	for bPtr := line2; bPtr <= line2+count2-1; {
+		bPtr = index.tryLCS(lcs, bPtr, line1, count1, line2, count2)
+	}
+	return lcs, index.hasCommon && maxChainLength < index.cnt
+}
+
+func (index *histogramIndex) tryLCS(lcs *region, bPtr, line1, count1, line2, count2 int) int {
+	e := index.e
+	bNext := bPtr + 1
+	rec, ok := index.records[e.f2.recs[bPtr-1].class]
+	if !ok {
+		return bNext
+	}
+	if rec.cnt > index.cnt {
+		index.hasCommon = true
+		return bNext
+	}
+
+	index.hasCommon = true
+	end1, end2 := line1+count1-1, line2+count2-1
+	as := rec.ptr
+	for {
+		np := index.nextPtrs[as-index.ptrShift]
+		bs := bPtr
+		ae, be := as, bs
+		rc := rec.cnt
+
+		for line1 < as && line2 < bs && e.match(as-1, bs-1) {
+			as--
+			bs--
+			if 1 < rc {
+				rc = minInt(rc, index.lineMap[as-index.ptrShift].cnt)
+			}
+		}
+		for ae < end1 && be < end2 && e.match(ae+1, be+1) {
+			ae++
+			be++
+			if 1 < rc {
+				rc = minInt(rc, index.lineMap[ae-index.ptrShift].cnt)
+			}
+		}
+
+		if bNext <= be {
+			bNext = be + 1
+		}
+		if lcs.end1-lcs.begin1 < ae-as || rc < index.cnt {
+			lcs.begin1, lcs.begin2 = as, bs
+			lcs.end1, lcs.end2 = ae, be
+			index.cnt = rc
+		}
+
+		if np == 0 {
+			break
+		}
+		for np <= ae {
+			np = index.nextPtrs[np-index.ptrShift]
+			if np == 0 {
+				return bNext
+			}
+		}
+		as = np
+	}
+	return bNext
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
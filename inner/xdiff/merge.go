@@ -0,0 +1,475 @@
+package xdiff
+
+import (
+	"bytes"
+)
+
+// DefaultMarkerSize is the default length of conflict markers.
+const DefaultMarkerSize = 7
+
+// ConflictStyle is a type representing how conflicts are shown.
+type ConflictStyle int
+
+// constants of ConflictStyle, same as merge.conflictStyle config
+const (
+	StyleMerge        ConflictStyle = iota // both sides are shown
+	StyleDiff3                             // the common ancestor is also shown
+	StyleZealousDiff3                      // like diff3, but common lines at both ends of conflicts are moved out
+)
+
+// String is implementation of fmt.Stringer interface.
+// it returns the name used by merge.conflictStyle config.
+func (s ConflictStyle) String() string {
+	switch s {
+	case StyleMerge:
+		return "merge"
+	case StyleDiff3:
+		return "diff3"
+	case StyleZealousDiff3:
+		return "zdiff3"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseConflictStyle parses the name of conflict style like merge.conflictStyle config.
+func ParseConflictStyle(name string) (ConflictStyle, error) {
+	switch name {
+	case "merge":
+		return StyleMerge, nil
+	case "diff3":
+		return StyleDiff3, nil
+	case "zdiff3":
+		return StyleZealousDiff3, nil
+	default:
+		return 0, ErrUnknownConflictStyle
+	}
+}
+
+// Favor is a type representing how conflicts are resolved automatically.
+type Favor int
+
+// constants of Favor, same as --ours, --theirs and --union options of git merge-file
+const (
+	FavorNone   Favor = iota // conflicts are left with markers
+	FavorOurs                // our side is taken
+	FavorTheirs              // their side is taken
+	FavorUnion               // both sides are taken
+)
+
+// MergeLevel is a type representing how hard conflicts are reduced.
+type MergeLevel int
+
+// constants of MergeLevel
+const (
+	MergeZealous      MergeLevel = iota // the default, changes are compared and only differing lines conflict
+	MergeMinimal                        // all overlapping changes conflict
+	MergeEager                          // overlapping changes conflict unless they are identical
+	MergeZealousAlnum                   // like MergeZealous, and conflicts separated by lines without letters or digits are joined
+)
+
+// rank returns the level number of xdiff in git, a higher number reduces conflicts harder.
+func (l MergeLevel) rank() int {
+	switch l {
+	case MergeMinimal:
+		return 0
+	case MergeEager:
+		return 1
+	case MergeZealousAlnum:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// MergeOptions is a type representing options of three-way merge.
+type MergeOptions struct {
+	Algorithm       Algorithm // algorithm to compute changes of each side
+	Flags           Flags
+	IndentHeuristic bool // slide changes to line up with indentation
+	Level           MergeLevel
+	Style           ConflictStyle
+	Favor           Favor
+	MarkerSize      int // length of conflict markers, 0 means DefaultMarkerSize
+
+	// labels shown after conflict markers, they may be empty
+	AncestorLabel string // shown after "|||||||" in diff3 and zdiff3 style
+	OurLabel      string // shown after "<<<<<<<"
+	TheirLabel    string // shown after ">>>>>>>"
+}
+
+// merge modes of hunks, same as xmerge.c of git
+const (
+	modeConflict  = 0
+	modeOurs      = 1
+	modeTheirs    = 2
+	modeBoth      = 3
+	modeIdentical = 4 // both sides made the same change, it's shown as ours
+)
+
+// mergeHunk is a type representing a changed region of three-way merge.
+// each side is a pair of start index and number of lines.
+type mergeHunk struct {
+	next     *mergeHunk
+	mode     int
+	i0, chg0 int // region of the ancestor
+	i1, chg1 int // region of ours
+	i2, chg2 int // region of theirs
+}
+
+// merger is a type holding state while merging.
+type merger struct {
+	options      *MergeOptions
+	base         [][]byte
+	ours, theirs [][]byte
+	head         *mergeHunk
+	tail         *mergeHunk
+}
+
+// Merge merges changes from base to ours and changes from base to theirs, and returns the result and
+// the number of conflicts. both sides are written between conflict markers for each conflict.
+//
+//  <<<<<<< <our label>
+//  <lines of ours>
+//  ||||||| <ancestor label>          (diff3 and zdiff3 style)
+//  <lines of the ancestor>
+//  =======
+//  <lines of theirs>
+//  >>>>>>> <their label>
+//
+// options may be nil.
+func Merge(base, ours, theirs []byte, options *MergeOptions) ([]byte, int) {
+	if options == nil {
+		options = &MergeOptions{}
+	}
+	m := &merger{
+		options: options,
+		base:    splitLines(base),
+		ours:    splitLines(ours),
+		theirs:  splitLines(theirs),
+	}
+	ourEdits := m.diff(m.base, m.ours)
+	theirEdits := m.diff(m.base, m.theirs)
+	if len(ourEdits) == 0 {
+		return theirs, 0
+	}
+	if len(theirEdits) == 0 {
+		return ours, 0
+	}
+	m.collect(ourEdits, theirEdits)
+	if options.Style == StyleZealousDiff3 {
+		m.refineZealousDiff3Conflicts()
+	} else if m.level() >= MergeZealous.rank() {
+		m.refineConflicts()
+		m.simplifyNonConflicts(m.level() > MergeZealous.rank())
+	}
+	return m.fill()
+}
+
+// level returns the rank of the merge level, diff3 output doesn't make sense for levels higher than eager.
+func (m *merger) level() int {
+	level := m.options.Level.rank()
+	if (m.options.Style == StyleDiff3 || m.options.Style == StyleZealousDiff3) && level > MergeEager.rank() {
+		level = MergeEager.rank()
+	}
+	return level
+}
+
+// diff computes changes from lines1 to lines2.
+func (m *merger) diff(lines1, lines2 [][]byte) []*Edit {
+	e := newEnv(lines1, lines2, m.options.Flags)
+	e.diff(m.options.Algorithm)
+	e.compact(m.options.IndentHeuristic)
+	return e.script()
+}
+
+// linesMatch reports whether lines are equal according to flags.
+func (m *merger) linesMatch(line1, line2 []byte) bool {
+	return bytes.Equal(normalize(line1, m.options.Flags), normalize(line2, m.options.Flags))
+}
+
+// appendHunk appends a hunk, it's joined to the last hunk if they overlap.
+func (m *merger) appendHunk(mode, i0, chg0, i1, chg1, i2, chg2 int) {
+	if last := m.tail; last != nil && (i1 <= last.i1+last.chg1 || i2 <= last.i2+last.chg2) {
+		if mode != last.mode {
+			last.mode = modeConflict
+		}
+		last.chg0 = i0 + chg0 - last.i0
+		last.chg1 = i1 + chg1 - last.i1
+		last.chg2 = i2 + chg2 - last.i2
+		return
+	}
+	h := &mergeHunk{mode: mode, i0: i0, chg0: chg0, i1: i1, chg1: chg1, i2: i2, chg2: chg2}
+	if m.tail == nil {
+		m.head = h
+	} else {
+		m.tail.next = h
+	}
+	m.tail = h
+}
+
+// collect makes hunks from changes of both sides.
+// a change of one side which doesn't overlap changes of the other side is taken,
+// and overlapping changes conflict unless they are identical.
+func (m *merger) collect(ourEdits, theirEdits []*Edit) {
+	for len(ourEdits) > 0 && len(theirEdits) > 0 {
+		x1, x2 := ourEdits[0], theirEdits[0]
+		if x1.OldStart+x1.OldLines < x2.OldStart {
+			m.appendHunk(modeOurs, x1.OldStart, x1.OldLines, x1.NewStart, x1.NewLines,
+				x2.NewStart-x2.OldStart+x1.OldStart, x1.OldLines)
+			ourEdits = ourEdits[1:]
+			continue
+		}
+		if x2.OldStart+x2.OldLines < x1.OldStart {
+			m.appendHunk(modeTheirs, x2.OldStart, x2.OldLines, x1.NewStart-x1.OldStart+x2.OldStart, x2.OldLines,
+				x2.NewStart, x2.NewLines)
+			theirEdits = theirEdits[1:]
+			continue
+		}
+		if m.level() == MergeMinimal.rank() || x1.OldStart != x2.OldStart || x1.OldLines != x2.OldLines ||
+			x1.NewLines != x2.NewLines || !m.sameLines(x1.NewStart, x2.NewStart, x1.NewLines) {
+			off := x1.OldStart - x2.OldStart
+			ffo := off + x1.OldLines - x2.OldLines
+			i0, i1, i2 := x1.OldStart, x1.NewStart, x2.NewStart
+			if off > 0 {
+				i0 -= off
+				i1 -= off
+			} else {
+				i2 += off
+			}
+			chg0 := x1.OldStart + x1.OldLines - i0
+			chg1 := x1.NewStart + x1.NewLines - i1
+			chg2 := x2.NewStart + x2.NewLines - i2
+			if ffo < 0 {
+				chg0 -= ffo
+				chg1 -= ffo
+			} else {
+				chg2 += ffo
+			}
+			m.appendHunk(modeConflict, i0, chg0, i1, chg1, i2, chg2)
+		}
+
+		end1, end2 := x1.OldStart+x1.OldLines, x2.OldStart+x2.OldLines
+		if end1 >= end2 {
+			theirEdits = theirEdits[1:]
+		}
+		if end2 >= end1 {
+			ourEdits = ourEdits[1:]
+		}
+	}
+	for _, x1 := range ourEdits {
+		m.appendHunk(modeOurs, x1.OldStart, x1.OldLines, x1.NewStart, x1.NewLines,
+			x1.OldStart+len(m.theirs)-len(m.base), x1.OldLines)
+	}
+	for _, x2 := range theirEdits {
+		m.appendHunk(modeTheirs, x2.OldStart, x2.OldLines, x2.OldStart+len(m.ours)-len(m.base), x2.OldLines,
+			x2.NewStart, x2.NewLines)
+	}
+}
+
+// sameLines reports whether count lines of ours from i1 and lines of theirs from i2 are the same.
+func (m *merger) sameLines(i1, i2, count int) bool {
+	for i := 0; i < count; i++ {
+		if !m.linesMatch(m.ours[i1+i], m.theirs[i2+i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// refineZealousDiff3Conflicts moves common lines at the beginning and the end of conflicts out of them.
+func (m *merger) refineZealousDiff3Conflicts() {
+	for h := m.head; h != nil; h = h.next {
+		if h.mode != modeConflict {
+			continue
+		}
+		for h.chg1 > 0 && h.chg2 > 0 && m.linesMatch(m.ours[h.i1], m.theirs[h.i2]) {
+			h.chg1--
+			h.chg2--
+			h.i1++
+			h.i2++
+		}
+		for h.chg1 > 0 && h.chg2 > 0 && m.linesMatch(m.ours[h.i1+h.chg1-1], m.theirs[h.i2+h.chg2-1]) {
+			h.chg1--
+			h.chg2--
+		}
+	}
+}
+
+// refineConflicts compares both sides of conflicts, and only differing lines are left as conflicts.
+// sometimes changes are not quite identical, but differ in only a few lines.
+func (m *merger) refineConflicts() {
+	for h := m.head; h != nil; h = h.next {
+		// no sense refining a conflict when one side is empty
+		if h.mode != modeConflict || h.chg1 == 0 || h.chg2 == 0 {
+			continue
+		}
+		edits := m.diff(m.ours[h.i1:h.i1+h.chg1], m.theirs[h.i2:h.i2+h.chg2])
+		if len(edits) == 0 {
+			h.mode = modeIdentical
+			continue
+		}
+		i1, i2 := h.i1, h.i2
+		h.i1, h.chg1 = edits[0].OldStart+i1, edits[0].OldLines
+		h.i2, h.chg2 = edits[0].NewStart+i2, edits[0].NewLines
+		for _, edit := range edits[1:] {
+			h.next = &mergeHunk{
+				next: h.next,
+				mode: modeConflict,
+				i1:   edit.OldStart + i1,
+				chg1: edit.OldLines,
+				i2:   edit.NewStart + i2,
+				chg2: edit.NewLines,
+			}
+			h = h.next
+		}
+	}
+}
+
+// simplifyNonConflicts joins conflicts separated by less than 4 lines, because it takes up less or as many lines.
+// if simplifyIfNoAlnum is true, conflicts separated by lines without letters or digits are also joined.
+func (m *merger) simplifyNonConflicts(simplifyIfNoAlnum bool) {
+	h := m.head
+	for h != nil && h.next != nil {
+		next := h.next
+		begin, end := h.i1+h.chg1, next.i1
+		if h.mode != modeConflict || next.mode != modeConflict ||
+			(end-begin > 3 && (!simplifyIfNoAlnum || linesContainAlnum(m.ours[begin:end]))) {
+			h = next
+			continue
+		}
+		h.chg1 = next.i1 + next.chg1 - h.i1
+		h.chg2 = next.i2 + next.chg2 - h.i2
+		h.next = next.next
+	}
+}
+
+func linesContainAlnum(lines [][]byte) bool {
+	for _, line := range lines {
+		for _, c := range line {
+			if '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fill writes the result, and returns it with the number of conflicts.
+func (m *merger) fill() ([]byte, int) {
+	buf := &bytes.Buffer{}
+	conflicts, i := 0, 0
+	for h := m.head; h != nil; h = h.next {
+		if m.options.Favor != FavorNone && h.mode == modeConflict {
+			h.mode = int(m.options.Favor)
+		}
+		switch {
+		case h.mode == modeConflict:
+			conflicts++
+			m.writeConflict(buf, h, i)
+		case h.mode&modeBoth != 0:
+			// before the changed part
+			writeLines(buf, m.ours[i:h.i1], false, false)
+			if h.mode&modeOurs != 0 {
+				writeLines(buf, m.ours[h.i1:h.i1+h.chg1], m.needsCR(h), h.mode&modeTheirs != 0)
+			}
+			if h.mode&modeTheirs != 0 {
+				writeLines(buf, m.theirs[h.i2:h.i2+h.chg2], false, false)
+			}
+		default:
+			// identical changes are written as ours
+			continue
+		}
+		i = h.i1 + h.chg1
+	}
+	writeLines(buf, m.ours[i:], false, false)
+	return buf.Bytes(), conflicts
+}
+
+// writeConflict writes lines of ours before the conflict, and then both sides between conflict markers.
+func (m *merger) writeConflict(buf *bytes.Buffer, h *mergeHunk, i int) {
+	markerSize := m.options.MarkerSize
+	if markerSize <= 0 {
+		markerSize = DefaultMarkerSize
+	}
+	needsCR := m.needsCR(h)
+	marker := func(c byte, label string) {
+		buf.Write(bytes.Repeat([]byte{c}, markerSize))
+		if label != "" {
+			buf.WriteByte(' ')
+			buf.WriteString(label)
+		}
+		if needsCR {
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte('\n')
+	}
+
+	writeLines(buf, m.ours[i:h.i1], false, false)
+	marker('<', m.options.OurLabel)
+	writeLines(buf, m.ours[h.i1:h.i1+h.chg1], needsCR, true)
+	if m.options.Style == StyleDiff3 || m.options.Style == StyleZealousDiff3 {
+		marker('|', m.options.AncestorLabel)
+		writeLines(buf, m.base[h.i0:h.i0+h.chg0], needsCR, true)
+	}
+	marker('=', "")
+	writeLines(buf, m.theirs[h.i2:h.i2+h.chg2], needsCR, true)
+	marker('>', m.options.TheirLabel)
+}
+
+// writeLines writes lines, if addNL is true, a line break is added to the last line without it.
+func writeLines(buf *bytes.Buffer, lines [][]byte, needsCR, addNL bool) {
+	for _, line := range lines {
+		buf.Write(line)
+	}
+	if addNL && len(lines) > 0 && !bytes.HasSuffix(lines[len(lines)-1], []byte("\n")) {
+		if needsCR {
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte('\n')
+	}
+}
+
+// needsCR reports whether line breaks written around the hunk should be CRLF.
+// it follows lines preceding the hunk or the first lines of both sides, and the first line of the ancestor.
+func (m *merger) needsCR(h *mergeHunk) bool {
+	before := func(i int) int {
+		if i > 0 {
+			return i - 1
+		}
+		return 0
+	}
+	needsCR := eolIsCRLF(m.ours, before(h.i1))
+	if needsCR != 0 {
+		needsCR = eolIsCRLF(m.theirs, before(h.i2))
+	}
+	if needsCR != 0 {
+		needsCR = eolIsCRLF(m.base, 0)
+	}
+	return needsCR > 0
+}
+
+// eolIsCRLF returns 1 if the i-th line ends with CRLF, 0 if it ends with LF, and -1 if it can't be determined.
+// if the line is the last line without a line break, the preceding line is examined.
+func eolIsCRLF(lines [][]byte, i int) int {
+	hasCRLF := func(line []byte) int {
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			return 1
+		}
+		return 0
+	}
+	if i < len(lines)-1 {
+		// all lines before the last must end with LF
+		return hasCRLF(lines[i])
+	}
+	if len(lines) == 0 {
+		return -1
+	}
+	if bytes.HasSuffix(lines[i], []byte("\n")) {
+		return hasCRLF(lines[i])
+	}
+	if i == 0 {
+		return -1
+	}
+	return hasCRLF(lines[i-1])
+}
@@ -0,0 +1,272 @@
+package xdiff
+
+// constants of heuristics of Myers algorithm, they are the same as xdiffi.c of git
+const (
+	maxCostMin  = 256 // minimum of the cost to give up searching the shortest path
+	heurMinCost = 256 // cost to start looking for good snakes
+	snakeCount  = 20  // length of snake regarded as good
+	kHeur       = 4   // factor of the cost to regard a diagonal interesting
+)
+
+const lineMax = int(^uint(0) >> 1)
+
+// split is a type representing the point where a box is divided.
+type split struct {
+	i1, i2       int
+	minLo, minHi bool // whether the shortest path is needed in the lower and the higher box
+}
+
+// myers is a type holding state of Myers algorithm.
+type myers struct {
+	ha1, ha2   []int
+	rchg1      func(i int)
+	rchg2      func(i int)
+	kvd        []int
+	fOff, bOff int // offsets of forward and backward diagonals in kvd
+	maxCost    int
+}
+
+// myers computes changed lines by Myers algorithm, e.optimize must be called before.
+func (e *env) myers(minimal bool) {
+	f1, f2 := e.f1, e.f2
+	ndiags := len(f1.ha) + len(f2.ha) + 3
+	m := &myers{
+		ha1:     f1.ha,
+		ha2:     f2.ha,
+		rchg1:   func(i int) { f1.setChanged(f1.rindex[i], true) },
+		rchg2:   func(i int) { f2.setChanged(f2.rindex[i], true) },
+		kvd:     make([]int, 2*ndiags+2),
+		fOff:    len(f2.ha) + 1,
+		bOff:    ndiags + len(f2.ha) + 1,
+		maxCost: bogoSqrt(ndiags),
+	}
+	if m.maxCost < maxCostMin {
+		m.maxCost = maxCostMin
+	}
+	m.compare(0, len(f1.ha), 0, len(f2.ha), minimal)
+}
+
+func (m *myers) kvdf(d int) *int {
+	return &m.kvd[m.fOff+d]
+}
+
+func (m *myers) kvdb(d int) *int {
+	return &m.kvd[m.bOff+d]
+}
+
+// compare marks changed lines between ha1[off1:lim1] and ha2[off2:lim2].
+func (m *myers) compare(off1, lim1, off2, lim2 int, needMin bool) {
+	ha1, ha2 := m.ha1, m.ha2
+	for off1 < lim1 && off2 < lim2 && ha1[off1] == ha2[off2] {
+		off1++
+		off2++
+	}
+	for off1 < lim1 && off2 < lim2 && ha1[lim1-1] == ha2[lim2-1] {
+		lim1--
+		lim2--
+	}
+
+	switch {
+	case off1 == lim1:
+		for ; off2 < lim2; off2++ {
+			m.rchg2(off2)
+		}
+	case off2 == lim2:
+		for ; off1 < lim1; off1++ {
+			m.rchg1(off1)
+		}
+	default:
+		spl := m.split(off1, lim1, off2, lim2, needMin)
+		m.compare(off1, spl.i1, off2, spl.i2, spl.minLo)
+		m.compare(spl.i1, lim1, spl.i2, lim2, spl.minHi)
+	}
+}
+
+// split finds the middle snake of the box, or a good enough point if it costs too much.
+func (m *myers) split(off1, lim1, off2, lim2 int, needMin bool) split {
+	ha1, ha2 := m.ha1, m.ha2
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax := fmid, fmid
+	bmin, bmax := bmid, bmid
+
+	*m.kvdf(fmid) = off1
+	*m.kvdb(bmid) = lim1
+
+	for ec := 1; ; ec++ {
+		gotSnake := false
+
+		if fmin > dmin {
+			fmin--
+			*m.kvdf(fmin - 1) = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			*m.kvdf(fmax + 1) = -1
+		} else {
+			fmax--
+		}
+
+		for d := fmax; d >= fmin; d -= 2 {
+			var i1 int
+			if *m.kvdf(d - 1) >= *m.kvdf(d + 1) {
+				i1 = *m.kvdf(d - 1) + 1
+			} else {
+				i1 = *m.kvdf(d + 1)
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 < lim1 && i2 < lim2 && ha1[i1] == ha2[i2] {
+				i1++
+				i2++
+			}
+			if i1-prev1 > snakeCount {
+				gotSnake = true
+			}
+			*m.kvdf(d) = i1
+			if odd && bmin <= d && d <= bmax && *m.kvdb(d) <= i1 {
+				return split{i1: i1, i2: i2, minLo: true, minHi: true}
+			}
+		}
+
+		if bmin > dmin {
+			bmin--
+			*m.kvdb(bmin - 1) = lineMax
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			*m.kvdb(bmax + 1) = lineMax
+		} else {
+			bmax--
+		}
+
+		for d := bmax; d >= bmin; d -= 2 {
+			var i1 int
+			if *m.kvdb(d - 1) < *m.kvdb(d + 1) {
+				i1 = *m.kvdb(d - 1)
+			} else {
+				i1 = *m.kvdb(d + 1) - 1
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 > off1 && i2 > off2 && ha1[i1-1] == ha2[i2-1] {
+				i1--
+				i2--
+			}
+			if prev1-i1 > snakeCount {
+				gotSnake = true
+			}
+			*m.kvdb(d) = i1
+			if !odd && fmin <= d && d <= fmax && i1 <= *m.kvdf(d) {
+				return split{i1: i1, i2: i2, minLo: true, minHi: true}
+			}
+		}
+
+		if needMin {
+			continue
+		}
+
+		// look for a diagonal which reached far with a good snake
+		if gotSnake && ec > heurMinCost {
+			best := 0
+			var spl split
+			for d := fmax; d >= fmin; d -= 2 {
+				dd := d - fmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *m.kvdf(d)
+				i2 := i1 - d
+				v := (i1 - off1) + (i2 - off2) - dd
+				if v > kHeur*ec && v > best &&
+					off1+snakeCount <= i1 && i1 < lim1 &&
+					off2+snakeCount <= i2 && i2 < lim2 {
+					for k := 1; ha1[i1-k] == ha2[i2-k]; k++ {
+						if k == snakeCount {
+							best = v
+							spl.i1, spl.i2 = i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				spl.minLo, spl.minHi = true, false
+				return spl
+			}
+
+			best = 0
+			for d := bmax; d >= bmin; d -= 2 {
+				dd := d - bmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *m.kvdb(d)
+				i2 := i1 - d
+				v := (lim1 - i1) + (lim2 - i2) - dd
+				if v > kHeur*ec && v > best &&
+					off1 < i1 && i1 <= lim1-snakeCount &&
+					off2 < i2 && i2 <= lim2-snakeCount {
+					for k := 0; ha1[i1+k] == ha2[i2+k]; k++ {
+						if k == snakeCount-1 {
+							best = v
+							spl.i1, spl.i2 = i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				spl.minLo, spl.minHi = false, true
+				return spl
+			}
+		}
+
+		// give up and take the furthest reaching path
+		if ec >= m.maxCost {
+			fbest, fbest1 := -1, -1
+			for d := fmax; d >= fmin; d -= 2 {
+				i1 := *m.kvdf(d)
+				if lim1 < i1 {
+					i1 = lim1
+				}
+				i2 := i1 - d
+				if lim2 < i2 {
+					i1 = lim2 + d
+					i2 = lim2
+				}
+				if fbest < i1+i2 {
+					fbest = i1 + i2
+					fbest1 = i1
+				}
+			}
+
+			bbest, bbest1 := lineMax, lineMax
+			for d := bmax; d >= bmin; d -= 2 {
+				i1 := *m.kvdb(d)
+				if i1 < off1 {
+					i1 = off1
+				}
+				i2 := i1 - d
+				if i2 < off2 {
+					i1 = off2 + d
+					i2 = off2
+				}
+				if i1+i2 < bbest {
+					bbest = i1 + i2
+					bbest1 = i1
+				}
+			}
+
+			if (lim1+lim2)-bbest < fbest-(off1+off2) {
+				return split{i1: fbest1, i2: fbest - fbest1, minLo: true, minHi: false}
+			}
+			return split{i1: bbest1, i2: bbest - bbest1, minLo: false, minHi: true}
+		}
+	}
+}
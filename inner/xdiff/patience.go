@@ -0,0 +1,175 @@
+package xdiff
+
+// nonUnique is line2 of patienceEntry meaning the line is not unique in either side.
+const nonUnique = -1
+
+// patienceEntry is a type representing a line which may be unique in both sides.
+// line numbers are 1-based.
+type patienceEntry struct {
+	line1    int // line in the old side
+	line2    int // line in the new side, 0 if it doesn't appear, nonUnique if it isn't unique
+	next     *patienceEntry
+	previous *patienceEntry
+}
+
+// patience computes changed lines between line1..line1+count1-1 and line2..line2+count2-1 by patience algorithm.
+// line numbers are 1-based.
+func (e *env) patience(line1, count1, line2, count2 int) {
+	if count1 == 0 || count2 == 0 {
+		e.markChanged(line1, count1, line2, count2)
+		return
+	}
+
+	entries := map[int]*patienceEntry{}
+	var first, last *patienceEntry
+	for line := line1; line < line1+count1; line++ {
+		class := e.f1.recs[line-1].class
+		if entry, ok := entries[class]; ok {
+			entry.line2 = nonUnique
+			continue
+		}
+		entry := &patienceEntry{line1: line}
+		entries[class] = entry
+		if first == nil {
+			first = entry
+		}
+		if last != nil {
+			last.next = entry
+			entry.previous = last
+		}
+		last = entry
+	}
+	hasMatches := false
+	for line := line2; line < line2+count2; line++ {
+		entry, ok := entries[e.f2.recs[line-1].class]
+		if !ok {
+			continue
+		}
+		hasMatches = true
+		if entry.line2 != 0 {
+			entry.line2 = nonUnique
+		} else {
+			entry.line2 = line
+		}
+	}
+
+	if !hasMatches {
+		e.markChanged(line1, count1, line2, count2)
+		return
+	}
+	if first = longestCommonSequence(first, len(entries)); first == nil {
+		e.fallBack(line1, count1, line2, count2)
+		return
+	}
+	e.walkCommonSequence(first, line1, count1, line2, count2)
+}
+
+// longestCommonSequence returns the first entry of the longest sequence of unique lines
+// in increasing order in both sides, they are linked by next.
+func longestCommonSequence(first *patienceEntry, nr int) *patienceEntry {
+	sequence := make([]*patienceEntry, nr)
+	longest := 0
+	for entry := first; entry != nil; entry = entry.next {
+		if entry.line2 == 0 || entry.line2 == nonUnique {
+			continue
+		}
+		// find the longest sequence whose last element has smaller line2
+		left, right := -1, longest
+		for left+1 < right {
+			middle := left + (right-left)/2
+			if sequence[middle].line2 > entry.line2 {
+				right = middle
+			} else {
+				left = middle
+			}
+		}
+		entry.previous = nil
+		if left >= 0 {
+			entry.previous = sequence[left]
+		}
+		sequence[left+1] = entry
+		if left+1 == longest {
+			longest++
+		}
+	}
+	if longest == 0 {
+		return nil
+	}
+
+	entry := sequence[longest-1]
+	entry.next = nil
+	for entry.previous != nil {
+		entry.previous.next = entry
+		entry = entry.previous
+	}
+	return entry
+}
+
+func (e *env) walkCommonSequence(first *patienceEntry, line1, count1, line2, count2 int) {
+	end1, end2 := line1+count1, line2+count2
+	for {
+		var next1, next2 int
+		if first != nil {
+			next1, next2 = first.line1, first.line2
+			for next1 > line1 && next2 > line2 && e.match(next1-1, next2-1) {
+				next1--
+				next2--
+			}
+		} else {
+			next1, next2 = end1, end2
+		}
+		for line1 < next1 && line2 < next2 && e.match(line1, line2) {
+			line1++
+			line2++
+		}
+
+		if next1 > line1 || next2 > line2 {
+			e.patience(line1, next1-line1, line2, next2-line2)
+		}
+		if first == nil {
+			return
+		}
+
+		for first.next != nil && first.next.line1 == first.line1+1 && first.next.line2 == first.line2+1 {
+			first = first.next
+		}
+		line1 = first.line1 + 1
+		line2 = first.line2 + 1
+		first = first.next
+	}
+}
+
+// match reports whether the lines of both sides are equal, line numbers are 1-based.
+func (e *env) match(line1, line2 int) bool {
+	return e.f1.recs[line1-1].class == e.f2.recs[line2-1].class
+}
+
+// markChanged marks all lines in the ranges changed, line numbers are 1-based.
+func (e *env) markChanged(line1, count1, line2, count2 int) {
+	for i := 0; i < count1; i++ {
+		e.f1.setChanged(line1-1+i, true)
+	}
+	for i := 0; i < count2; i++ {
+		e.f2.setChanged(line2-1+i, true)
+	}
+}
+
+// fallBack computes changed lines in the ranges by Myers algorithm, line numbers are 1-based.
+func (e *env) fallBack(line1, count1, line2, count2 int) {
+	lines := func(f *file, line, count int) [][]byte {
+		lines := make([][]byte, count)
+		for i := range lines {
+			lines[i] = f.recs[line-1+i].data
+		}
+		return lines
+	}
+	sub := newEnv(lines(e.f1, line1, count1), lines(e.f2, line2, count2), e.flags)
+	sub.optimize()
+	sub.myers(false)
+	for i := 0; i < count1; i++ {
+		e.f1.setChanged(line1-1+i, sub.f1.changed(i))
+	}
+	for i := 0; i < count2; i++ {
+		e.f2.setChanged(line2-1+i, sub.f2.changed(i))
+	}
+}
@@ -0,0 +1,312 @@
+package xdiff
+
+import "bytes"
+
+// constants to discard lines before comparison, they are the same as xprepare.c of git
+const (
+	maxEqLimit     = 1024 // lines appearing more than this times in the other side are multimatch
+	simScanWindow  = 100  // window to examine runs around multimatch lines
+	discardRunRate = 4    // multimatch lines are discarded if they are less than 1/4 of the run
+)
+
+// record is a type representing a line.
+type record struct {
+	data  []byte // content of the line including trailing '\n'
+	class int    // lines having the same class are equal
+}
+
+// file is a type representing one side of comparison.
+type file struct {
+	recs   []*record
+	rchg   []bool // whether each line is changed, the index is shifted by one to access rchg[-1] and rchg[len(recs)]
+	rindex []int  // indices of lines compared by Myers algorithm
+	ha     []int  // classes of lines in rindex
+	dstart int    // index of the first line different from the other side
+	dend   int    // index of the last line different from the other side
+}
+
+// changed reports whether the i-th line is changed, i may be -1 or len(recs).
+func (f *file) changed(i int) bool {
+	return f.rchg[i+1]
+}
+
+func (f *file) setChanged(i int, changed bool) {
+	f.rchg[i+1] = changed
+}
+
+// classCount is a type representing the number of lines of a class in each side.
+type classCount struct {
+	len1, len2 int
+}
+
+// env is a type holding state while computing a diff.
+type env struct {
+	flags  Flags
+	f1, f2 *file
+	counts []classCount // counts by class
+}
+
+// splitLines splits data to lines keeping trailing '\n'.
+// the last line may not have '\n'.
+func splitLines(data []byte) [][]byte {
+	lines := [][]byte{}
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, data)
+			break
+		}
+		lines = append(lines, data[:i+1])
+		data = data[i+1:]
+	}
+	return lines
+}
+
+func newEnv(lines1, lines2 [][]byte, flags Flags) *env {
+	e := &env{flags: flags, counts: []classCount{}}
+	classes := map[string]int{}
+	newFile := func(lines [][]byte, side int) *file {
+		f := &file{
+			recs:   make([]*record, len(lines)),
+			rchg:   make([]bool, len(lines)+2),
+			dstart: 0,
+			dend:   len(lines) - 1,
+		}
+		for i, line := range lines {
+			key := string(normalize(line, flags))
+			class, ok := classes[key]
+			if !ok {
+				class = len(e.counts)
+				classes[key] = class
+				e.counts = append(e.counts, classCount{})
+			}
+			if side == 1 {
+				e.counts[class].len1++
+			} else {
+				e.counts[class].len2++
+			}
+			f.recs[i] = &record{data: line, class: class}
+		}
+		return f
+	}
+	e.f1 = newFile(lines1, 1)
+	e.f2 = newFile(lines2, 2)
+	return e
+}
+
+// normalize returns the line with whitespaces removed according to flags,
+// lines are equal if the normalized lines are the same.
+func normalize(line []byte, flags Flags) []byte {
+	switch {
+	case flags&IgnoreAllSpace != 0:
+		normalized := make([]byte, 0, len(line))
+		for _, c := range line {
+			if !isSpace(c) {
+				normalized = append(normalized, c)
+			}
+		}
+		return normalized
+	case flags&IgnoreSpaceChange != 0:
+		normalized := make([]byte, 0, len(line))
+		for i := 0; i < len(line); i++ {
+			if !isSpace(line[i]) {
+				normalized = append(normalized, line[i])
+				continue
+			}
+			for i+1 < len(line) && isSpace(line[i+1]) {
+				i++
+			}
+			if i+1 < len(line) {
+				normalized = append(normalized, ' ')
+			}
+		}
+		return normalized
+	case flags&IgnoreSpaceAtEOL != 0:
+		return bytes.TrimRightFunc(line, func(r rune) bool {
+			return r < 0x80 && isSpace(byte(r))
+		})
+	case flags&IgnoreCRAtEOL != 0:
+		if bytes.HasSuffix(line, []byte("\n")) {
+			return bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+		}
+		return line
+	}
+	return line
+}
+
+// isSpace reports whether c is a whitespace like isspace() of C.
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+// isBlankLine reports whether the line is blank according to flags.
+func isBlankLine(line []byte, flags Flags) bool {
+	if flags&whitespaceFlags == 0 {
+		return len(line) <= 1
+	}
+	for _, c := range line {
+		if !isSpace(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// optimize trims common lines at both ends and discards lines which can't match, before Myers algorithm.
+func (e *env) optimize() {
+	e.trimEnds()
+	e.cleanupRecords()
+}
+
+func (e *env) trimEnds() {
+	recs1, recs2 := e.f1.recs, e.f2.recs
+	limit := len(recs1)
+	if len(recs2) < limit {
+		limit = len(recs2)
+	}
+	i := 0
+	for ; i < limit; i++ {
+		if recs1[i].class != recs2[i].class {
+			break
+		}
+	}
+	e.f1.dstart, e.f2.dstart = i, i
+
+	limit -= i
+	j := 0
+	for ; j < limit; j++ {
+		if recs1[len(recs1)-1-j].class != recs2[len(recs2)-1-j].class {
+			break
+		}
+	}
+	e.f1.dend = len(recs1) - j - 1
+	e.f2.dend = len(recs2) - j - 1
+}
+
+// cleanupRecords selects lines compared by Myers algorithm.
+// lines having no match in the other side are marked changed without comparison,
+// and lines having many matches are also discarded if they are in runs of such lines.
+func (e *env) cleanupRecords() {
+	dis1 := make([]byte, len(e.f1.recs)+1)
+	dis2 := make([]byte, len(e.f2.recs)+1)
+
+	limit := bogoSqrt(len(e.f1.recs))
+	if limit > maxEqLimit {
+		limit = maxEqLimit
+	}
+	for i := e.f1.dstart; i <= e.f1.dend; i++ {
+		dis1[i] = discardLevel(e.counts[e.f1.recs[i].class].len2, limit)
+	}
+	limit = bogoSqrt(len(e.f2.recs))
+	if limit > maxEqLimit {
+		limit = maxEqLimit
+	}
+	for i := e.f2.dstart; i <= e.f2.dend; i++ {
+		dis2[i] = discardLevel(e.counts[e.f2.recs[i].class].len1, limit)
+	}
+
+	selectRecords := func(f *file, dis []byte) {
+		f.rindex = []int{}
+		f.ha = []int{}
+		for i := f.dstart; i <= f.dend; i++ {
+			if dis[i] == 1 || (dis[i] == 2 && !isDiscardable(dis, i, f.dstart, f.dend)) {
+				f.rindex = append(f.rindex, i)
+				f.ha = append(f.ha, f.recs[i].class)
+			} else {
+				f.setChanged(i, true)
+			}
+		}
+	}
+	selectRecords(e.f1, dis1)
+	selectRecords(e.f2, dis2)
+}
+
+// bogoSqrt returns rough square root of n by shifts.
+func bogoSqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+// discardLevel returns 0 if the line has no match, 2 if it has many matches, otherwise 1.
+func discardLevel(matches, limit int) byte {
+	switch {
+	case matches == 0:
+		return 0
+	case matches >= limit:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// isDiscardable reports whether the multimatch line at i is in the middle of a run of lines
+// which have no match or many matches, and lines without match dominate the run.
+func isDiscardable(dis []byte, i, start, end int) bool {
+	if i-start > simScanWindow {
+		start = i - simScanWindow
+	}
+	if end-i > simScanWindow {
+		end = i + simScanWindow
+	}
+
+	noMatch, multiMatch := 0, 1
+	for r := 1; i-r >= start; r++ {
+		if dis[i-r] == 0 {
+			noMatch++
+		} else if dis[i-r] == 2 {
+			multiMatch++
+		} else {
+			break
+		}
+	}
+	if noMatch == 0 {
+		return false
+	}
+	noMatchAfter, multiMatchAfter := 0, 1
+	for r := 1; i+r <= end; r++ {
+		if dis[i+r] == 0 {
+			noMatchAfter++
+		} else if dis[i+r] == 2 {
+			multiMatchAfter++
+		} else {
+			break
+		}
+	}
+	if noMatchAfter == 0 {
+		return false
+	}
+	noMatch += noMatchAfter
+	multiMatch += multiMatchAfter
+	return multiMatch*discardRunRate < multiMatch+noMatch
+}
+
+// script returns groups of changed lines.
+func (e *env) script() []*Edit {
+	edits := []*Edit{}
+	i1, i2 := len(e.f1.recs), len(e.f2.recs)
+	for i1 >= 0 || i2 >= 0 {
+		if e.f1.changed(i1-1) || e.f2.changed(i2-1) {
+			l1, l2 := i1, i2
+			for e.f1.changed(i1 - 1) {
+				i1--
+			}
+			for e.f2.changed(i2 - 1) {
+				i2--
+			}
+			edits = append(edits, &Edit{OldStart: i1, OldLines: l1 - i1, NewStart: i2, NewLines: l2 - i2})
+		}
+		i1--
+		i2--
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
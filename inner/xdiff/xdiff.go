@@ -0,0 +1,148 @@
+// xdiff is a package to compute line based differences of two contents like xdiff library in git
+//
+// The following algorithms are supported.
+//
+//  Myers     - the default algorithm, with the same heuristics as git to bound the cost
+//  Minimal   - Myers without the heuristics, it spends extra time to produce the smallest diff
+//  Patience  - matches lines appearing exactly once in both sides first
+//  Histogram - extends patience to support lines appearing a few times
+//
+// Changes are slid to line up with changes of the other side and indentation after they are computed,
+// so hunks are the same as $ git diff produces.
+//
+// Merge merges two contents derived from a common ancestor like $ git merge-file,
+// overlapping changes are shown between conflict markers.
+//
+// If you want to know more about diff algorithms, please refer to
+// https://git-scm.com/docs/git-diff#Documentation/git-diff.txt---diff-algorithmpatienceminimalhistogrammyers
+package xdiff
+
+// Algorithm is a type representing diff algorithm.
+type Algorithm int
+
+// constants of Algorithm
+const (
+	Myers Algorithm = iota
+	Minimal
+	Patience
+	Histogram
+)
+
+// String is implementation of fmt.Stringer interface.
+// it returns the name used by --diff-algorithm option.
+func (a Algorithm) String() string {
+	switch a {
+	case Myers:
+		return "myers"
+	case Minimal:
+		return "minimal"
+	case Patience:
+		return "patience"
+	case Histogram:
+		return "histogram"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseAlgorithm parses the name of diff algorithm like diff.algorithm config.
+// "default" is the same as "myers".
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch name {
+	case "myers", "default":
+		return Myers, nil
+	case "minimal":
+		return Minimal, nil
+	case "patience":
+		return Patience, nil
+	case "histogram":
+		return Histogram, nil
+	default:
+		return 0, ErrUnknownAlgorithm
+	}
+}
+
+// Flags is a type representing how whitespaces are compared.
+type Flags uint
+
+// constants of Flags
+const (
+	IgnoreAllSpace    Flags = 1 << iota // -w, ignore all whitespaces
+	IgnoreSpaceChange                   // -b, ignore changes in amount of whitespaces
+	IgnoreSpaceAtEOL                    // --ignore-space-at-eol, ignore whitespaces at end of line
+	IgnoreCRAtEOL                       // --ignore-cr-at-eol, ignore carriage return at end of line
+	IgnoreBlankLines                    // --ignore-blank-lines, ignore changes whose lines are all blank
+)
+
+const whitespaceFlags = IgnoreAllSpace | IgnoreSpaceChange | IgnoreSpaceAtEOL | IgnoreCRAtEOL
+
+// DefaultContext is the default number of context lines.
+const DefaultContext = 3
+
+// Options is a type representing options of diff.
+type Options struct {
+	Algorithm         Algorithm
+	Flags             Flags
+	Context           int  // number of context lines like -U<n>
+	InterHunkContext  int  // hunks closer than this number of lines are merged like --inter-hunk-context
+	NoIndentHeuristic bool // don't slide changes to line up with indentation
+
+	// FuncName returns the text shown in the hunk header if the line is a function line.
+	// nil means lines beginning with an alphabet, '_' or '$' like git.
+	FuncName func(line []byte) ([]byte, bool)
+}
+
+// defaultOptions returns options used when nil is given.
+func defaultOptions() *Options {
+	return &Options{Context: DefaultContext}
+}
+
+// Edit is a type representing a group of changed lines.
+// line numbers are 0-based.
+type Edit struct {
+	OldStart int // index of the first deleted line in the old content
+	OldLines int // number of deleted lines
+	NewStart int // index of the first added line in the new content
+	NewLines int // number of added lines
+}
+
+// Diff computes groups of changed lines from old content to new content.
+// options may be nil.
+func Diff(old, new []byte, options *Options) []*Edit {
+	if options == nil {
+		options = defaultOptions()
+	}
+	e := newEnv(splitLines(old), splitLines(new), options.Flags)
+	e.diff(options.Algorithm)
+	e.compact(!options.NoIndentHeuristic)
+	return e.script()
+}
+
+// diff computes changed lines by the algorithm.
+func (e *env) diff(algorithm Algorithm) {
+	switch algorithm {
+	case Patience:
+		e.patience(1, len(e.f1.recs), 1, len(e.f2.recs))
+	case Histogram:
+		e.histogram(1, len(e.f1.recs), 1, len(e.f2.recs))
+	default:
+		e.optimize()
+		e.myers(algorithm == Minimal)
+	}
+}
+
+// binaryCheckSize is the size of leading bytes checked by IsBinary.
+const binaryCheckSize = 8000
+
+// IsBinary reports whether the content is binary, it has NUL in the first 8000 bytes like git.
+func IsBinary(data []byte) bool {
+	if len(data) > binaryCheckSize {
+		data = data[:binaryCheckSize]
+	}
+	for _, b := range data {
+		if b == 0 {
+			return true
+		}
+	}
+	return false
+}